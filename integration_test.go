@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serverBinary is the server built once for the integration tests
+var serverBinary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "mcp-unix-shell-test")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create temp dir: %v\n", err)
+		os.Exit(1)
+	}
+
+	serverBinary = filepath.Join(dir, "mcp-unix-shell")
+	if output, err := exec.Command("go", "build", "-o", serverBinary, ".").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build server: %v\n%s", err, output)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// stdioClient speaks MCP to a server process over its stdin and stdout
+type stdioClient struct {
+	t      *testing.T
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan string
+	nextID int
+	stop   sync.Once
+}
+
+// toolResult is the decoded result of a tools/call request
+type toolResult struct {
+	Content []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Resource struct {
+			URI      string `json:"uri"`
+			MIMEType string `json:"mimeType"`
+			Text     string `json:"text"`
+		} `json:"resource"`
+	} `json:"content"`
+	IsError bool `json:"isError"`
+}
+
+// startServer launches the server with args and completes the MCP handshake
+func startServer(t *testing.T, args ...string) *stdioClient {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	if os.Geteuid() == 0 {
+		args = append(args, "--allow-root")
+	}
+	cmd := exec.Command(serverBinary, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe failed: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe failed: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	c := &stdioClient{t: t, cmd: cmd, stdin: stdin, lines: make(chan string)}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			c.lines <- scanner.Text()
+		}
+		close(c.lines)
+	}()
+	t.Cleanup(c.close)
+
+	c.request("initialize", map[string]interface{}{
+		"protocolVersion": "2024-11-05",
+		"clientInfo":      map[string]interface{}{"name": "integration-test", "version": "0.1.0"},
+		"capabilities":    map[string]interface{}{},
+	}, nil)
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "method": "notifications/initialized"})
+	return c
+}
+
+// close closes stdin and waits for the server to exit
+func (c *stdioClient) close() {
+	c.stop.Do(func() {
+		c.stdin.Close()
+		if err := c.cmd.Wait(); err != nil {
+			c.t.Errorf("server did not exit cleanly after stdin closed: %v", err)
+		}
+	})
+}
+
+// send writes one JSON-RPC message
+func (c *stdioClient) send(message interface{}) {
+	c.t.Helper()
+	data, err := json.Marshal(message)
+	if err != nil {
+		c.t.Fatalf("failed to encode message: %v", err)
+	}
+	if _, err := c.stdin.Write(append(data, '\n')); err != nil {
+		c.t.Fatalf("failed to write to server: %v", err)
+	}
+}
+
+// request sends a request and decodes the result of its response into result
+func (c *stdioClient) request(method string, params interface{}, result interface{}) {
+	c.t.Helper()
+	c.nextID++
+	id := c.nextID
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				c.t.Fatalf("server closed stdout while waiting for %s", method)
+			}
+			var response struct {
+				ID     *int            `json:"id"`
+				Result json.RawMessage `json:"result"`
+				Error  *struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(line), &response); err != nil {
+				c.t.Fatalf("server wrote invalid JSON %q: %v", line, err)
+			}
+			if response.ID == nil || *response.ID != id {
+				continue // A notification or an unrelated response
+			}
+			if response.Error != nil {
+				c.t.Fatalf("%s failed: %s", method, response.Error.Message)
+			}
+			if result != nil {
+				if err := json.Unmarshal(response.Result, result); err != nil {
+					c.t.Fatalf("failed to decode %s result: %v", method, err)
+				}
+			}
+			return
+		case <-timeout:
+			c.t.Fatalf("timed out waiting for %s", method)
+		}
+	}
+}
+
+// callTool calls a tool and returns its decoded result
+func (c *stdioClient) callTool(name string, args map[string]interface{}) toolResult {
+	c.t.Helper()
+	var result toolResult
+	c.request("tools/call", map[string]interface{}{"name": name, "arguments": args}, &result)
+	if len(result.Content) == 0 {
+		c.t.Fatalf("%s returned no content", name)
+	}
+	return result
+}
+
+func TestStdioListTools(t *testing.T) {
+	c := startServer(t, "--allowed-commands=echo")
+
+	var result struct {
+		Tools []struct {
+			Name        string `json:"name"`
+			InputSchema struct {
+				Required []string `json:"required"`
+			} `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	c.request("tools/list", map[string]interface{}{}, &result)
+
+	tools := make(map[string][]string)
+	for _, tool := range result.Tools {
+		tools[tool.Name] = tool.InputSchema.Required
+	}
+	for _, name := range []string{"execute_command", "list_recent_commands", "list_allowed_commands", "validate_syntax"} {
+		if _, ok := tools[name]; !ok {
+			t.Errorf("tools/list is missing %s", name)
+		}
+	}
+	if required := tools["execute_command"]; len(required) != 1 || required[0] != "command" {
+		t.Errorf("execute_command requires %v, want [command]", required)
+	}
+}
+
+func TestStdioExecuteAndList(t *testing.T) {
+	c := startServer(t, "--allowed-commands=echo,printf,sh")
+
+	tests := []struct {
+		args    map[string]interface{}
+		want    string
+		isError bool
+	}{
+		{map[string]interface{}{"command": "echo hello"}, "$ echo hello\n\nhello\n", false},
+		{map[string]interface{}{"command": "sh -c 'exit 3'"}, "Command failed with exit code 3", false},
+		{map[string]interface{}{"command": "echo ok; rm -rf /nonexistent"}, "Command 'rm' is not in the allowed list", true},
+		{map[string]interface{}{"command": "echo 'unterminated"}, "could not be parsed safely", true},
+		{map[string]interface{}{"command": "echo hi", "shell": "fish"}, "Unsupported shell 'fish'", true},
+	}
+
+	for _, tt := range tests {
+		result := c.callTool("execute_command", tt.args)
+		if result.IsError != tt.isError || !strings.Contains(result.Content[0].Text, tt.want) {
+			t.Errorf("execute_command(%v) = %q (error %v), want %q (error %v)", tt.args, result.Content[0].Text, result.IsError, tt.want, tt.isError)
+		}
+	}
+
+	result := c.callTool("execute_command", map[string]interface{}{
+		"command":     `printf '{"items":[{"name":"a"},{"name":"b"}]}'`,
+		"json_format": "compact",
+		"json_path":   ".items[1].name",
+	})
+	if len(result.Content) != 4 || result.Content[1].Resource.MIMEType != "application/json" || result.Content[1].Resource.Text != `"b"` {
+		t.Errorf("json_path result = %+v, want an application/json resource with \"b\"", result.Content)
+	}
+
+	result = c.callTool("list_recent_commands", map[string]interface{}{"limit": 10})
+	text := result.Content[0].Text
+	if !strings.Contains(text, "$ echo hello") || !strings.Contains(text, "Failed (exit code 3)") {
+		t.Errorf("list_recent_commands = %q, want the executed commands", text)
+	}
+	if strings.Contains(text, "rm -rf") {
+		t.Errorf("list_recent_commands = %q, denied commands must not be recorded", text)
+	}
+
+	result = c.callTool("list_allowed_commands", nil)
+	if text := result.Content[0].Text; !strings.Contains(text, "echo") || !strings.Contains(text, "printf") {
+		t.Errorf("list_allowed_commands = %q, want the allowlist", text)
+	}
+}
+
+func TestStdioSecretsNotInherited(t *testing.T) {
+	secrets := []string{"MCP_SHELL_ADMIN_TOKEN", "MCP_SHELL_AGENT_TOKEN", "MCP_SHELL_WEBHOOK_SECRET", "MCP_SHELL_SMTP_USER", "MCP_SHELL_SMTP_PASSWORD"}
+	for _, name := range secrets {
+		t.Setenv(name, "secret-"+name)
+	}
+	c := startServer(t, "--allowed-commands=env")
+
+	result := c.callTool("execute_command", map[string]interface{}{"command": "env"})
+	for _, name := range secrets {
+		if strings.Contains(result.Content[0].Text, "secret-"+name) {
+			t.Errorf("commands inherited %s: %q", name, result.Content[0].Text)
+		}
+	}
+}
+
+func TestStdioTimeout(t *testing.T) {
+	c := startServer(t, "--allowed-commands=sleep", "--timeout=500ms")
+
+	start := time.Now()
+	result := c.callTool("execute_command", map[string]interface{}{"command": "sleep 10"})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out command took %s to return", elapsed)
+	}
+	if text := result.Content[0].Text; !strings.Contains(text, "timed out after 500ms") || !strings.Contains(text, "exit code 124") {
+		t.Errorf("execute_command = %q, want a timeout after 500ms with exit code 124", text)
+	}
+}
+
+func TestStdioHistoryPersists(t *testing.T) {
+	history := "jsonl:" + filepath.Join(t.TempDir(), "history.jsonl")
+
+	first := startServer(t, "--allowed-commands=echo", "--history="+history)
+	first.callTool("execute_command", map[string]interface{}{"command": "echo before restart"})
+	first.close()
+
+	second := startServer(t, "--allowed-commands=echo", "--history="+history)
+	result := second.callTool("list_recent_commands", nil)
+	if text := result.Content[0].Text; !strings.Contains(text, "$ echo before restart") {
+		t.Errorf("list_recent_commands after restart = %q, want the earlier command", text)
+	}
+}
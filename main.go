@@ -2,419 +2,534 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/mark3labs/mcp-go/mcp"
-	"github.com/mark3labs/mcp-go/server"
+	"github.com/gamunu/mcp-unix-shell/shellserver"
 )
 
-// Constants
-const (
-	DEFAULT_LIMIT    = 10               // Default number of commands to list
-	DEFAULT_SHELL    = "bash"           // Default shell to use
-	COMMAND_TIMEOUT  = 30 * time.Second // Default timeout for commands
-	MAX_OUTPUT_SIZE  = 1024 * 1024      // 1MB max output size
-	MAX_HISTORY_SIZE = 100              // Maximum commands to keep in history
-)
+// stringList is a flag value that collects every occurrence of a repeated flag
+type stringList []string
 
-// CommandExecution stores information about an executed command
-type CommandExecution struct {
-	Command     string    `json:"command"`
-	Shell       string    `json:"shell"`
-	Output      string    `json:"output"`
-	ExitCode    int       `json:"exitCode"`
-	StartTime   time.Time `json:"startTime"`
-	EndTime     time.Time `json:"endTime"`
-	ExecutionMs int64     `json:"executionMs"`
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
 }
 
-// ShellServer implements the MCP server for shell command execution
-type ShellServer struct {
-	allowedCommands  []string
-	allowAllCommands bool
-	commandHistory   []CommandExecution
-	historyMutex     sync.Mutex
-	server           *server.MCPServer
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
-// NewShellServer creates a new shell server with the given allowed commands
-func NewShellServer(allowedCommands string) (*ShellServer, error) {
-	var cmdList []string
-	allowAll := false
-
-	// Parse allowed commands
-	if allowedCommands == "*" {
-		allowAll = true
-		cmdList = []string{}
-	} else {
-		// Split by comma and trim spaces
-		for _, cmd := range strings.Split(allowedCommands, ",") {
-			trimmed := strings.TrimSpace(cmd)
-			if trimmed != "" {
-				cmdList = append(cmdList, trimmed)
-			}
-		}
+// parseRateLimit parses a rate limit of the form "<count>/<duration>", e.g. "30/1m"
+func parseRateLimit(value string) (int, time.Duration, error) {
+	countText, periodText, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("expected <count>/<duration>, e.g. 30/1m")
 	}
-
-	s := &ShellServer{
-		allowedCommands:  cmdList,
-		allowAllCommands: allowAll,
-		commandHistory:   make([]CommandExecution, 0, MAX_HISTORY_SIZE),
-		server: server.NewMCPServer(
-			"unix-shell-server",
-			"0.1.0",
-			server.WithResourceCapabilities(false, false),
-		),
+	count, err := strconv.Atoi(strings.TrimSpace(countText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid count '%s'", countText)
 	}
+	period, err := time.ParseDuration(strings.TrimSpace(periodText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid duration '%s'", periodText)
+	}
+	return count, period, nil
+}
 
-	// Register tool handlers
-	s.server.AddTool(mcp.NewTool(
-		"execute_command",
-		mcp.WithDescription("Execute a shell command using bash or zsh."),
-		mcp.WithString("command",
-			mcp.Description("The command to execute"),
-			mcp.Required(),
-		),
-		mcp.WithString("shell",
-			mcp.Description("The shell to use (bash or zsh)"),
-		),
-	), s.handleExecuteCommand)
-
-	s.server.AddTool(mcp.NewTool(
-		"list_recent_commands",
-		mcp.WithDescription("List recently executed commands."),
-		mcp.WithNumber("limit",
-			mcp.Description("Maximum number of commands to return"),
-		),
-	), s.handleListRecentCommands)
-
-	s.server.AddTool(mcp.NewTool(
-		"list_allowed_commands",
-		mcp.WithDescription("List all commands that are allowed to be executed."),
-	), s.handleListAllowedCommands)
-
-	return s, nil
+// secretEnv returns the value of an environment variable holding a secret
+// and removes it from the server's environment
+func secretEnv(name string) string {
+	value := os.Getenv(name)
+	os.Unsetenv(name)
+	return value
 }
 
-// isCommandAllowed checks if a command is in the allowed list
-func (s *ShellServer) isCommandAllowed(command string) bool {
-	if s.allowAllCommands {
-		return true
+// runPolicyCommand runs "policy import [--format=...] <file>", printing the
+// imported rules as a --preset policy file, and returns the exit code
+func runPolicyCommand(args []string) int {
+	usage := fmt.Sprintf("Usage: %s policy import [--format=auto|permissions|sudoers] <file> > policy.json\n", os.Args[0])
+	if len(args) == 0 || args[0] != "import" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
 	}
 
-	// Extract the base command (first word before any spaces)
-	baseCmd := strings.Fields(command)
-	if len(baseCmd) == 0 {
-		return false
+	flags := flag.NewFlagSet("policy import", flag.ContinueOnError)
+	formatFlag := flags.String("format", shellserver.IMPORT_FORMAT_AUTO, "Input format: 'permissions' (Claude or Cursor permission JSON), 'sudoers', or 'auto' to detect")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
 	}
-
-	// Check if the base command is in the allowed list
-	for _, allowed := range s.allowedCommands {
-		if baseCmd[0] == allowed {
-			return true
-		}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
 	}
 
-	return false
-}
-
-// addToHistory adds a command execution to the history
-func (s *ShellServer) addToHistory(execution CommandExecution) {
-	s.historyMutex.Lock()
-	defer s.historyMutex.Unlock()
-
-	// Add to the front of the list
-	s.commandHistory = append([]CommandExecution{execution}, s.commandHistory...)
+	path := flags.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	rules, warnings, err := shellserver.ImportPolicy(data, *formatFlag)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot import '%s': %v\n", path, err)
+		return 1
+	}
 
-	// Trim if exceeding max size
-	if len(s.commandHistory) > MAX_HISTORY_SIZE {
-		s.commandHistory = s.commandHistory[:MAX_HISTORY_SIZE]
+	rules.Description = "Imported from " + path
+	output, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
 	}
+	fmt.Println(string(output))
+	return 0
 }
 
-// getHistory returns the command history (up to limit)
-func (s *ShellServer) getHistory(limit int) []CommandExecution {
-	s.historyMutex.Lock()
-	defer s.historyMutex.Unlock()
-
-	if limit <= 0 || limit > len(s.commandHistory) {
-		limit = len(s.commandHistory)
+// runVerifyAuditLog runs "verify_audit_log <file>", checking the hash chain
+// of a file written by '--notify file:<path>', and returns the exit code
+func runVerifyAuditLog(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify_audit_log <file>\n", os.Args[0])
+		return 2
 	}
 
-	result := make([]CommandExecution, limit)
-	copy(result, s.commandHistory[:limit])
-	return result
-}
-
-// executeCommand executes a shell command and returns its output
-func (s *ShellServer) executeCommand(command string, shell string) CommandExecution {
-	if shell == "" {
-		shell = DEFAULT_SHELL
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
 	}
+	defer file.Close()
 
-	// Only allow bash or zsh
-	if shell != "bash" && shell != "zsh" {
-		return CommandExecution{
-			Command:   command,
-			Shell:     shell,
-			Output:    fmt.Sprintf("Error: Unsupported shell '%s'. Only bash and zsh are supported.", shell),
-			ExitCode:  1,
-			StartTime: time.Now(),
-			EndTime:   time.Now(),
-		}
+	entries, err := shellserver.VerifyAuditLog(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v (%d entries before it are intact)\n", args[0], err, entries)
+		return 1
 	}
+	fmt.Printf("%s: hash chain of %d entries is intact\n", args[0], entries)
+	return 0
+}
 
-	execution := CommandExecution{
-		Command:   command,
-		Shell:     shell,
-		StartTime: time.Now(),
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		os.Exit(runPolicyCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify_audit_log" {
+		os.Exit(runVerifyAuditLog(os.Args[2:]))
 	}
 
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), COMMAND_TIMEOUT)
-	defer cancel()
+	// Parse command line flags
+	allowedCommandsFlag := flag.String("allowed-commands", "", "Comma-separated list of allowed commands or '*' to allow all commands")
+	presetFlag := flag.String("preset", "", "Comma-separated policy presets ("+strings.Join(shellserver.PresetNames(), ", ")+") or .json policy files, extended by '--allowed-commands'")
+	timeoutFlag := flag.Duration("timeout", shellserver.COMMAND_TIMEOUT, "Maximum run time for each command")
+	idleTimeoutFlag := flag.Duration("idle-timeout", 0, "Stop commands that produce no output for this long; --timeout still caps their total run time (0 disables)")
+	writeTimeoutFlag := flag.Duration("write-timeout", shellserver.WRITE_TIMEOUT, "How long the client may read no output while responses wait for it before the server gives up on it; 0 waits forever")
+	chunkSizeFlag := flag.Int("chunk-size", shellserver.STDIO_CHUNK_SIZE, "Bytes of a response written to the client, and over HTTP flushed, at a time")
+	compressionLevelFlag := flag.Int("compression-level", shellserver.DEFAULT_COMPRESSION_LEVEL, "gzip level (1-9) of responses of the gateway and admin API to clients that accept gzip, and of large agent results; 0 turns compression off")
+	reapIntervalFlag := flag.Duration("reap-interval", shellserver.REAP_INTERVAL, "How often to collect orphan processes (as PID 1), close idle sessions, purge the trash and remove leftover temporary files; 0 disables it")
+	retentionFlag := flag.Duration("retention", 0, "Remove history entries, rotated audit files and recordings older than this, e.g. 720h; 0 keeps everything")
+	retentionIntervalFlag := flag.Duration("retention-interval", shellserver.RETENTION_INTERVAL, "How often to enforce --retention")
+	asInitFlag := flag.Bool("as-init", false, "Forward signals to child processes and adopt and collect the orphans of commands, as when running as PID 1 (Linux only)")
+	sessionIdleTimeoutFlag := flag.Duration("session-idle-timeout", 0, "Close sessions and REPLs that ran nothing for this long; 0 keeps them open")
+	progressIntervalFlag := flag.Duration("progress-interval", shellserver.PROGRESS_INTERVAL, "How often to send progress notifications for running commands when the client asks for them; 0 disables them")
+	rateLimitFlag := flag.String("rate-limit", "", "Refuse commands beyond a rate such as '30/1m' (30 commands per minute)")
+	anomalyFlag := flag.String("anomaly", "", "Alert on unusual activity, e.g. 'rate=60,novel=5,writes=30,window=1m,throttle=5m' (throttle refuses commands for a while)")
+	maxConcurrentFlag := flag.Int("max-concurrent-commands", 0, "Run at most this many commands at once, queueing the others with interactive calls ahead of batch ones; 0 for no limit")
+	probeRateLimitFlag := flag.String("probe-rate-limit", fmt.Sprintf("%d/%s", shellserver.DEFAULT_PROBE_LIMIT, shellserver.DEFAULT_PROBE_PERIOD), "Refuse resolve_host, tcp_ping and trace_route calls beyond this rate")
+	redactSecretsFlag := flag.Bool("redact-secrets", false, "Mask tokens, keys and passwords in command output before it is returned, stored or sent to notifiers")
+	scrubFlag := flag.String("scrub", shellserver.SCRUB_STANDARD, "Mask what command output reveals about the machine: 'standard' (home directories, user and host names), 'strict' (also IP, MAC and email addresses) or 'off'")
+	lintOnExecuteFlag := flag.Bool("lint-on-execute", false, "Run shellcheck on every executed command and attach findings to the result")
+	var notifyFlags stringList
+	flag.Var(&notifyFlags, "notify", "Send command events to a notifier: 'stderr', 'syslog', 'file:<path>' or 'webhook:<url>', optionally followed by a space and a comma-separated event filter (repeatable)")
+	webhookURLFlag := flag.String("webhook-url", "", "POST command events as JSON to this URL")
+	webhookEventsFlag := flag.String("webhook-events", "", "Comma-separated events to send to the webhook: start,finish,denial,timeout (default all)")
+	webhookSecretFlag := flag.String("webhook-secret", "", "Sign webhook payloads with HMAC-SHA256 using this secret (or set MCP_SHELL_WEBHOOK_SECRET)")
+	approvalRequiredFlag := flag.String("approval-required", "", "Comma-separated commands that need human approval, matched like deny rules, e.g. 'rm,git push'")
+	approvalWebhookFlag := flag.String("approval-webhook", "", "Slack or Discord incoming webhook URL to post approval requests to")
+	approvalListenFlag := flag.String("approval-listen", "127.0.0.1:8787", "Address for the approval callback endpoint")
+	approvalURLFlag := flag.String("approval-public-url", "", "Base URL of the approval endpoint as reachable from chat (defaults to http://<approval-listen>)")
+	approvalTimeoutFlag := flag.Duration("approval-timeout", shellserver.DEFAULT_APPROVAL_TIMEOUT, "How long a high-risk command waits for approval before it is denied")
+	historyFlag := flag.String("history", shellserver.HISTORY_MEMORY, "Where to keep command history: 'memory', or 'jsonl:<path>' or 'sqlite:<path>' (builds with -tags sqlite) to keep it across restarts")
+	recordDirFlag := flag.String("record-dir", "", "Record executions, sessions and REPLs as asciicast v2 files in this directory")
+	tempDirFlag := flag.String("temp-dir", "", "Directory the server and its commands (as TMPDIR) create temporary files in; by default the first writable of $TMPDIR or /tmp, /dev/shm and /run/user/<uid>")
+	trashDirFlag := flag.String("trash-dir", "", "Move what rm deletes under the working and project directories to this trash directory, on the same file system, so restore_file can bring it back")
+	trashRetentionFlag := flag.Duration("trash-retention", shellserver.DEFAULT_TRASH_RETENTION, "How long --trash-dir keeps deleted files; 0 keeps them until restored")
+	digestSMTPFlag := flag.String("digest-smtp", "", "SMTP server (host:port) for a periodic activity digest; credentials from MCP_SHELL_SMTP_USER and MCP_SHELL_SMTP_PASSWORD")
+	digestFromFlag := flag.String("digest-from", "", "Sender address for the activity digest")
+	digestToFlag := flag.String("digest-to", "", "Comma-separated recipients of the activity digest")
+	digestIntervalFlag := flag.Duration("digest-interval", shellserver.DEFAULT_DIGEST_INTERVAL, "How often to send the activity digest; each digest covers this many hours")
+	containersFlag := flag.String("containers", "", "Container runtime for create_sandbox, exec_in_sandbox and destroy_sandbox, e.g. 'docker' or 'podman' (empty disables them)")
+	containerImagesFlag := flag.String("container-images", "", "JSON file of the images sandboxes may run, their pull policy and registry credentials")
+	provisionToolsFlag := flag.String("provision-tools", "", "JSON file of pinned static tools, such as rg, fd and jq, downloaded with SHA-256 verification into a tools directory in front of the commands' PATH")
+	containerDevicesFlag := flag.String("container-devices", "", "Comma-separated host devices sandboxes may be given, e.g. '/dev/kvm,/dev/nvidia*'")
+	containerGPUsFlag := flag.Bool("container-gpus", false, "Allow sandboxes to be given all GPUs")
+	containerHardeningFlag := flag.Bool("container-hardening", true, "Run sandboxes without capabilities, with no-new-privileges, a read-only root file system with a tmpfs at /tmp, and the pids and memory limits of --limits")
+	containerSeccompFlag := flag.String("container-seccomp", "", "Seccomp profile JSON file for sandboxes instead of the runtime's default profile")
+	snapshotDirFlag := flag.String("snapshot-dir", "", "Directory where snapshot_session saves session state for restore_session (empty disables them)")
+	sessionBackendFlag := flag.String("session-backend", shellserver.SESSION_BACKEND_PIPE, "Backend for persistent sessions: 'pipe' or 'tmux' (lets a human attach with 'tmux attach')")
+	limitsFlag := flag.String("limits", "", "Resource limits for every command, e.g. 'cpu=30s,memory=2G,fsize=1G,nofile=1024,nproc=256'")
+	requestLimitsFlag := flag.String("request-limits", "", "Size limits of tool calls, e.g. 'command=64K,args=4096,stdin=10M,payload=4M'")
+	invisibleFlag := flag.Bool("allow-invisible-characters", false, "Let commands contain zero-width and bidirectional characters, e.g. for emoji and right-to-left text")
+	runAsFlag := flag.String("run-as", "", "Run commands, sessions and REPLs as this user (the server must run as root)")
+	hardenFlag := flag.Bool("harden", false, "Restrict the server process itself once started (seccomp on Linux, unveil and pledge on OpenBSD)")
+	landlockFlag := flag.Bool("landlock", false, "Restrict the file system access of the server and its commands to the paths it is configured with, using Linux's Landlock")
+	deterministicFlag := flag.Bool("deterministic-test-mode", false, "Record every time as 2000-01-01T00:00:00Z and number execution and other IDs from 1, for tests that assert on the server's output")
+	allowRootFlag := flag.Bool("allow-root", false, "Allow the server to run as root, e.g. for --run-as")
+	targetsFlag := flag.String("targets", "", "JSON file of SSH hosts, agents and groups of them for execute_on_targets and execute_command's target")
+	var discoverFlags stringList
+	flag.Var(&discoverFlags, "discover", "Add the SSH hosts a source finds to the targets: 'file:<registry.json>', 'consul:<service>' or 'consul:<url>/<service>', or 'mdns:<service>' such as 'mdns:_ssh._tcp' (repeatable)")
+	discoverIntervalFlag := flag.Duration("discover-interval", shellserver.DEFAULT_DISCOVERY_INTERVAL, "How often --discover sources are asked for their hosts")
+	gatewayListenFlag := flag.String("gateway-listen", "", "Accept connections from the agents of --targets on this host:port, so commands can run on them")
+	gatewayCertFlag := flag.String("gateway-tls-cert", "", "TLS certificate for --gateway-listen; required unless it listens on a loopback address")
+	gatewayKeyFlag := flag.String("gateway-tls-key", "", "Key of --gateway-tls-cert")
+	gatewayFlag := flag.String("gateway", "", "Run as an agent of the gateway at this URL, e.g. https://gateway.example.com:9443, running its commands instead of serving MCP; the token is read from MCP_SHELL_AGENT_TOKEN")
+	agentNameFlag := flag.String("agent-name", "", "Name of this agent in the gateway's targets file; defaults to the host name")
+	agentLabelsFlag := flag.String("agent-labels", "", "Labels this agent reports to the gateway besides os and arch, e.g. 'role=build,gpu=a100'")
+	gatewayCAFlag := flag.String("gateway-ca", "", "PEM file of the CA certificates to verify --gateway with, instead of the system's")
+	targetHealthFlag := flag.Duration("target-health-interval", shellserver.DEFAULT_HEALTH_INTERVAL, "How often to check that --targets hosts are reachable; 0 disables the checks")
+	projectsFlag := flag.String("projects", "", "JSON file of projects with their directory, environment and policy, selected with execute_command's 'project' parameter")
+	trustManifestsFlag := flag.String("trust-manifests", "", "Comma-separated directories in or under which a .mcp-shell.yaml in the working directory is trusted to declare a project, its commands and its tasks")
+	enableToolsFlag := flag.String("enable-tools", "", "Comma-separated tools, or globs such as 'list_*', to register; empty registers all")
+	disableToolsFlag := flag.String("disable-tools", "", "Comma-separated tools, or globs such as '*_sandbox', not to register, e.g. in hardened deployments")
+	dotenvFlag := flag.String("dotenv", "", "Comma-separated variable names, or patterns such as 'APP_*', that commands get from the .env and .envrc files of the directory they run in (empty reads no such files)")
+	clientPoliciesFlag := flag.String("client-policies", "", "JSON file of policy rules added for commands from named MCP clients")
+	allowedURLsFlag := flag.String("allowed-urls", "", "Comma-separated URL prefixes fetch_url may fetch, e.g. 'https://api.github.com/,https://*.example.com', or '*' for any http and https URL")
+	fetchMethodsFlag := flag.String("fetch-methods", shellserver.DEFAULT_FETCH_METHODS, "Comma-separated HTTP methods fetch_url may use")
+	fetchMaxSizeFlag := flag.Int64("fetch-max-size", shellserver.DEFAULT_FETCH_SIZE, "Largest response body in bytes fetch_url reads")
+	fetchTimeoutFlag := flag.Duration("fetch-timeout", shellserver.DEFAULT_FETCH_TIMEOUT, "Maximum time for each fetch_url request")
+	adminSocketFlag := flag.String("admin-socket", "", "Unix socket to serve the admin tools (clear_history, maintenance_mode, policy edits) on, apart from the agent's tools (empty disables them)")
+	adminAPIFlag := flag.String("admin-api", "", "Serve a JSON admin API (executions, running commands, kill, policy reload, drain) on this Unix socket path or host:port; a host:port needs a bearer token in MCP_SHELL_ADMIN_TOKEN")
+	pprofFlag := flag.Bool("pprof", false, "Serve the net/http/pprof endpoints on a Unix socket next to --admin-socket, at its path plus '.pprof'")
+	shadowPolicyFlag := flag.String("shadow-policy", "", "Policy file or preset to evaluate in shadow: commands are decided by the live policy, and events record what it would have decided")
+	policyEditsFlag := flag.String("policy-edits", "", "Policy file that allow_command and deny_command save persisted edits to; applied at startup")
+	messagesFlag := flag.String("messages", "", "JSON file of translated user-facing messages, keyed by message ID")
+	flag.Parse()
 
-	// Create the command
-	cmd := exec.CommandContext(ctx, shell, "-c", command)
+	// Secrets are taken out of the environment before anything is started,
+	// so commands the server runs do not inherit them
+	adminToken := secretEnv("MCP_SHELL_ADMIN_TOKEN")
+	agentToken := secretEnv("MCP_SHELL_AGENT_TOKEN")
+	webhookSecret := secretEnv("MCP_SHELL_WEBHOOK_SECRET")
 
-	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+	if *allowedCommandsFlag == "" && *presetFlag == "" {
+		fmt.Fprintf(os.Stderr, "Error: The '--allowed-commands' flag is required.\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s --allowed-commands=ls,cat,echo,find\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Or start from a preset: %s --preset=readonly-inspection\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Or to allow all commands (use with caution): %s --allowed-commands=*\n", os.Args[0])
+		os.Exit(1)
+	}
 
-	execution.EndTime = time.Now()
-	execution.ExecutionMs = execution.EndTime.Sub(execution.StartTime).Milliseconds()
+	if os.Geteuid() == 0 && !*allowRootFlag {
+		fmt.Fprintf(os.Stderr, "Error: Refusing to run as root, which gives every allowed command full control of the system.\n")
+		fmt.Fprintf(os.Stderr, "Run as an unprivileged user, or pass '--allow-root' (needed for '--run-as').\n")
+		os.Exit(1)
+	}
+	if err := shellserver.DisableCoreDumps(); err != nil {
+		log.Printf("Warning: failed to disable core dumps: %v", err)
+	}
 
-	// Truncate output if it's too large
-	outputStr := string(output)
-	if len(outputStr) > MAX_OUTPUT_SIZE {
-		outputStr = outputStr[:MAX_OUTPUT_SIZE] + "\n... (output truncated due to size limit)"
+	opts := []shellserver.Option{
+		shellserver.WithAllowedCommands(*allowedCommandsFlag),
+		shellserver.WithTimeout(*timeoutFlag),
+		shellserver.WithIdleTimeout(*idleTimeoutFlag),
+		shellserver.WithProgressInterval(*progressIntervalFlag),
+		shellserver.WithWriteTimeout(*writeTimeoutFlag),
+		shellserver.WithChunkSize(*chunkSizeFlag),
+		shellserver.WithCompression(*compressionLevelFlag, shellserver.COMPRESS_MIN_SIZE),
+		shellserver.WithReaper(*reapIntervalFlag),
+		shellserver.WithStorageRetention(*retentionFlag),
+		shellserver.WithRetentionInterval(*retentionIntervalFlag),
+		shellserver.WithMaxConcurrentCommands(*maxConcurrentFlag),
+		shellserver.WithSessionIdleTimeout(*sessionIdleTimeoutFlag),
+		shellserver.WithLintOnExecute(*lintOnExecuteFlag),
+		shellserver.WithSessionBackend(*sessionBackendFlag),
+		shellserver.WithRecordDir(*recordDirFlag),
+		shellserver.WithScrubbing(*scrubFlag),
+		shellserver.WithAllowedURLs(*allowedURLsFlag),
+		shellserver.WithFetchMethods(*fetchMethodsFlag),
+		shellserver.WithFetchLimits(*fetchMaxSizeFlag, *fetchTimeoutFlag),
 	}
-	execution.Output = outputStr
 
-	// Handle different error types
-	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			execution.Output += "\n\nError: Command execution timed out after 30 seconds."
-			execution.ExitCode = 124 // Common timeout exit code
-		} else if exitError, ok := err.(*exec.ExitError); ok {
-			execution.ExitCode = exitError.ExitCode()
-		} else {
-			execution.Output += "\n\nError: " + err.Error()
-			execution.ExitCode = 1
+	for _, preset := range strings.Split(*presetFlag, ",") {
+		if preset = strings.TrimSpace(preset); preset != "" {
+			opts = append(opts, shellserver.WithPreset(preset))
 		}
-	} else {
-		execution.ExitCode = 0
 	}
 
-	return execution
-}
-
-// Tool handlers
-func (s *ShellServer) handleExecuteCommand(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	command, ok := request.Params.Arguments["command"].(string)
-	if !ok {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: "Error: 'command' must be a string",
-				},
-			},
-			IsError: true,
-		}, nil
+	history, err := shellserver.ParseHistoryStore(*historyFlag)
+	if err != nil {
+		log.Fatalf("Invalid --history '%s': %v", *historyFlag, err)
 	}
+	opts = append(opts, shellserver.WithHistoryStore(history))
 
-	// Get optional shell parameter
-	shell := DEFAULT_SHELL
-	if shellArg, ok := request.Params.Arguments["shell"].(string); ok && shellArg != "" {
-		shell = shellArg
+	if *limitsFlag != "" {
+		limits, err := shellserver.ParseResourceLimits(*limitsFlag)
+		if err != nil {
+			log.Fatalf("Invalid --limits '%s': %v", *limitsFlag, err)
+		}
+		opts = append(opts, shellserver.WithResourceLimits(limits))
 	}
-
-	// Check if command is allowed
-	if !s.isCommandAllowed(command) {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf(
-						"Error: Command '%s' is not in the allowed list. Run 'list_allowed_commands' to see what commands are permitted.",
-						strings.Fields(command)[0],
-					),
-				},
-			},
-			IsError: true,
-		}, nil
+	if *requestLimitsFlag != "" {
+		limits, err := shellserver.ParseRequestLimits(*requestLimitsFlag)
+		if err != nil {
+			log.Fatalf("Invalid --request-limits '%s': %v", *requestLimitsFlag, err)
+		}
+		opts = append(opts, shellserver.WithRequestLimits(limits))
 	}
-
-	// Execute the command
-	execution := s.executeCommand(command, shell)
-
-	// Add to history
-	s.addToHistory(execution)
-
-	// Construct the response
-	var executionStatus string
-	if execution.ExitCode == 0 {
-		executionStatus = "completed successfully"
-	} else {
-		executionStatus = fmt.Sprintf("failed with exit code %d", execution.ExitCode)
+	if *invisibleFlag {
+		opts = append(opts, shellserver.WithInvisibleCharacters())
 	}
-
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf(
-					"$ %s\n\n%s\n\nCommand %s in %d ms",
-					command,
-					execution.Output,
-					executionStatus,
-					execution.ExecutionMs,
-				),
-			},
-		},
-	}, nil
-}
-
-func (s *ShellServer) handleListRecentCommands(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	// Get optional limit parameter
-	limit := DEFAULT_LIMIT
-	if limitArg, ok := request.Params.Arguments["limit"].(float64); ok {
-		limit = int(limitArg)
+	if *runAsFlag != "" {
+		opts = append(opts, shellserver.WithRunAs(*runAsFlag))
 	}
-
-	// Get command history
-	history := s.getHistory(limit)
-
-	if len(history) == 0 {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: "No commands have been executed yet.",
-				},
-			},
-		}, nil
+	if *projectsFlag != "" {
+		projects, err := shellserver.LoadProjects(*projectsFlag)
+		if err != nil {
+			log.Fatalf("Invalid --projects '%s': %v", *projectsFlag, err)
+		}
+		opts = append(opts, shellserver.WithProjects(projects))
 	}
-
-	// Format the response
-	var result strings.Builder
-	result.WriteString(fmt.Sprintf("Recent commands (showing %d of %d total):\n\n",
-		len(history), len(s.commandHistory)))
-
-	for i, cmd := range history {
-		statusMsg := "Success"
-		if cmd.ExitCode != 0 {
-			statusMsg = fmt.Sprintf("Failed (exit code %d)", cmd.ExitCode)
+	if *containersFlag != "" {
+		opts = append(opts, shellserver.WithContainers(*containersFlag))
+	}
+	if *containerDevicesFlag != "" || *containerGPUsFlag {
+		var patterns []string
+		for _, pattern := range strings.Split(*containerDevicesFlag, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
 		}
-
-		result.WriteString(fmt.Sprintf(
-			"%d. [%s] $ %s\n   Shell: %s, Duration: %d ms, Status: %s\n\n",
-			i+1,
-			cmd.StartTime.Format(time.RFC3339),
-			cmd.Command,
-			cmd.Shell,
-			cmd.ExecutionMs,
-			statusMsg,
-		))
+		opts = append(opts, shellserver.WithContainerDevices(patterns, *containerGPUsFlag))
 	}
-
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: result.String(),
-			},
-		},
-	}, nil
-}
-
-func (s *ShellServer) handleListAllowedCommands(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	if s.allowAllCommands {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: "All commands are allowed ('*' mode).\n\nWarning: This server is configured to execute any shell command. This poses a security risk.",
-				},
-			},
-		}, nil
+	if !*containerHardeningFlag {
+		opts = append(opts, shellserver.WithContainerHardening(false))
 	}
-
-	if len(s.allowedCommands) == 0 {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: "No commands are currently allowed. Configure the server with the '--allowed-commands' flag.",
-				},
-			},
-		}, nil
+	if *containerSeccompFlag != "" {
+		opts = append(opts, shellserver.WithContainerSeccomp(*containerSeccompFlag))
 	}
-
-	var result strings.Builder
-	result.WriteString(fmt.Sprintf("Allowed commands (%d):\n\n", len(s.allowedCommands)))
-
-	for i, cmd := range s.allowedCommands {
-		result.WriteString(fmt.Sprintf("%d. %s\n", i+1, cmd))
+	if *containerImagesFlag != "" {
+		images, err := shellserver.LoadContainerImages(*containerImagesFlag)
+		if err != nil {
+			log.Fatalf("Invalid --container-images '%s': %v", *containerImagesFlag, err)
+		}
+		opts = append(opts, shellserver.WithContainerImages(images))
+	}
+	if *provisionToolsFlag != "" {
+		manifest, err := shellserver.LoadToolManifest(*provisionToolsFlag)
+		if err != nil {
+			log.Fatalf("Invalid --provision-tools '%s': %v", *provisionToolsFlag, err)
+		}
+		opts = append(opts, shellserver.WithToolProvisioning(manifest))
+	}
+	if *trustManifestsFlag != "" {
+		var dirs []string
+		for _, dir := range strings.Split(*trustManifestsFlag, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+		opts = append(opts, shellserver.WithTrustedManifests(dirs))
+	}
+	if *enableToolsFlag != "" || *disableToolsFlag != "" {
+		split := func(spec string) []string {
+			var names []string
+			for _, name := range strings.Split(spec, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					names = append(names, name)
+				}
+			}
+			return names
+		}
+		opts = append(opts, shellserver.WithTools(split(*enableToolsFlag), split(*disableToolsFlag)))
+	}
+	if *dotenvFlag != "" {
+		var names []string
+		for _, name := range strings.Split(*dotenvFlag, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		opts = append(opts, shellserver.WithDotenv(names))
+	}
+	if *clientPoliciesFlag != "" {
+		policies, err := shellserver.LoadClientPolicies(*clientPoliciesFlag)
+		if err != nil {
+			log.Fatalf("Invalid --client-policies '%s': %v", *clientPoliciesFlag, err)
+		}
+		opts = append(opts, shellserver.WithClientPolicies(policies))
+	}
+	if *adminSocketFlag != "" {
+		opts = append(opts, shellserver.WithAdminSocket(*adminSocketFlag))
+	}
+	if *adminAPIFlag != "" {
+		opts = append(opts, shellserver.WithAdminAPI(*adminAPIFlag, adminToken))
+	}
+	if *asInitFlag {
+		opts = append(opts, shellserver.WithInit())
+	}
+	if *tempDirFlag != "" {
+		opts = append(opts, shellserver.WithTempDir(*tempDirFlag))
+	}
+	if *pprofFlag {
+		opts = append(opts, shellserver.WithProfiling())
+	}
+	if *shadowPolicyFlag != "" {
+		opts = append(opts, shellserver.WithShadowPolicy(*shadowPolicyFlag))
+	}
+	if *policyEditsFlag != "" {
+		opts = append(opts, shellserver.WithPolicyEdits(*policyEditsFlag))
+	}
+	if *snapshotDirFlag != "" {
+		opts = append(opts, shellserver.WithSessionSnapshots(*snapshotDirFlag))
+	}
+	if *trashDirFlag != "" {
+		opts = append(opts, shellserver.WithTrash(*trashDirFlag, *trashRetentionFlag))
+	}
+	if *targetsFlag != "" {
+		targets, err := shellserver.LoadTargets(*targetsFlag)
+		if err != nil {
+			log.Fatalf("Invalid --targets '%s': %v", *targetsFlag, err)
+		}
+		opts = append(opts, shellserver.WithTargets(targets))
+	}
+	if len(discoverFlags) > 0 {
+		var sources []shellserver.DiscoverySource
+		for _, spec := range discoverFlags {
+			source, err := shellserver.ParseDiscoverySource(spec)
+			if err != nil {
+				log.Fatalf("Invalid --discover '%s': %v", spec, err)
+			}
+			sources = append(sources, source)
+		}
+		opts = append(opts, shellserver.WithTargetDiscovery(sources, *discoverIntervalFlag))
+	}
+	if *targetsFlag != "" || len(discoverFlags) > 0 {
+		opts = append(opts, shellserver.WithTargetHealthChecks(*targetHealthFlag))
+	}
+	if *gatewayListenFlag != "" {
+		opts = append(opts, shellserver.WithGateway(*gatewayListenFlag, *gatewayCertFlag, *gatewayKeyFlag))
+	}
+	if *gatewayFlag != "" {
+		name := *agentNameFlag
+		if name == "" {
+			name, _ = os.Hostname()
+		}
+		var labels map[string]string
+		if *agentLabelsFlag != "" {
+			var err error
+			if labels, err = shellserver.ParseLabels(*agentLabelsFlag); err != nil {
+				log.Fatalf("Invalid --agent-labels: %v", err)
+			}
+		}
+		opts = append(opts, shellserver.WithAgent(shellserver.AgentConfig{
+			Gateway: *gatewayFlag,
+			Name:    name,
+			Token:   agentToken,
+			CAFile:  *gatewayCAFlag,
+			Labels:  labels,
+		}))
+	}
+	if *messagesFlag != "" {
+		catalog, err := shellserver.LoadMessageCatalog(*messagesFlag)
+		if err != nil {
+			log.Fatalf("Invalid --messages '%s': %v", *messagesFlag, err)
+		}
+		opts = append(opts, shellserver.WithTranslator(catalog))
+	}
+	if *deterministicFlag {
+		opts = append(opts, shellserver.WithDeterministicMode())
+	}
+	if *landlockFlag {
+		sandbox, err := shellserver.LandlockSandbox()
+		if err != nil {
+			log.Fatalf("Cannot use --landlock: %v", err)
+		}
+		opts = append(opts, shellserver.WithSandbox(sandbox))
+	}
+	if *hardenFlag {
+		sandbox, err := shellserver.PlatformSandbox()
+		if err != nil {
+			log.Fatalf("Cannot use --harden: %v", err)
+		}
+		opts = append(opts, shellserver.WithSandbox(sandbox))
+	}
+	if *rateLimitFlag != "" {
+		count, period, err := parseRateLimit(*rateLimitFlag)
+		if err != nil {
+			log.Fatalf("Invalid --rate-limit '%s': %v", *rateLimitFlag, err)
+		}
+		opts = append(opts, shellserver.WithRateLimit(count, period))
+	}
+	if *anomalyFlag != "" {
+		thresholds, err := shellserver.ParseAnomalyThresholds(*anomalyFlag)
+		if err != nil {
+			log.Fatalf("Invalid --anomaly '%s': %v", *anomalyFlag, err)
+		}
+		opts = append(opts, shellserver.WithAnomalyDetection(thresholds))
+	}
+	probeCount, probePeriod, err := parseRateLimit(*probeRateLimitFlag)
+	if err != nil {
+		log.Fatalf("Invalid --probe-rate-limit '%s': %v", *probeRateLimitFlag, err)
+	}
+	opts = append(opts, shellserver.WithProbeRateLimit(probeCount, probePeriod))
+	if *redactSecretsFlag {
+		opts = append(opts, shellserver.WithRedaction())
 	}
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: result.String(),
-			},
-		},
-	}, nil
-}
-
-func (s *ShellServer) Serve() error {
-	return server.ServeStdio(s.server)
-}
-
-func main() {
-	// Parse command line flags
-	allowedCommandsFlag := flag.String("allowed-commands", "", "Comma-separated list of allowed commands or '*' to allow all commands")
-	flag.Parse()
+	if *webhookSecretFlag != "" {
+		webhookSecret = *webhookSecretFlag
+	}
+	if *webhookURLFlag != "" {
+		notifyFlags = append(notifyFlags, strings.TrimSpace(shellserver.NOTIFIER_WEBHOOK+":"+*webhookURLFlag+" "+*webhookEventsFlag))
+	}
+	for _, spec := range notifyFlags {
+		notifier, err := shellserver.ParseNotifier(spec, webhookSecret)
+		if err != nil {
+			log.Fatalf("Invalid notifier '%s': %v", spec, err)
+		}
+		opts = append(opts, shellserver.WithNotifier(notifier))
+	}
 
-	if *allowedCommandsFlag == "" {
-		fmt.Fprintf(os.Stderr, "Error: The '--allowed-commands' flag is required.\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s --allowed-commands=ls,cat,echo,find\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Or to allow all commands (use with caution): %s --allowed-commands=*\n", os.Args[0])
-		os.Exit(1)
+	if *approvalRequiredFlag != "" {
+		opts = append(opts, shellserver.WithApproval(*approvalRequiredFlag, *approvalWebhookFlag, *approvalURLFlag, *approvalListenFlag, *approvalTimeoutFlag))
+	}
+	if *digestSMTPFlag != "" {
+		opts = append(opts, shellserver.WithEmailDigest(*digestSMTPFlag, *digestFromFlag, *digestToFlag, *digestIntervalFlag))
 	}
 
 	// Create and start the server
-	shellServer, err := NewShellServer(*allowedCommandsFlag)
+	shellServer, err := shellserver.NewShellServer(opts...)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
 
-	// Log the server configuration
-	if shellServer.allowAllCommands {
-		log.Println("Starting shell server with all commands allowed ('*' mode)")
-	} else {
-		log.Printf("Starting shell server with %d allowed commands", len(shellServer.allowedCommands))
+	// Run the gateway's commands as its agent, until stopped
+	if *gatewayFlag != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = shellServer.ServeAgent(ctx)
+		stop()
+		shellServer.Close()
+		if err != nil {
+			log.Fatalf("Agent error: %v", err)
+		}
+		return
 	}
 
 	// Serve requests
-	if err := shellServer.Serve(); err != nil {
+	err = shellServer.Serve()
+	shellServer.Close()
+	if err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
@@ -0,0 +1,8 @@
+//go:build sqlite
+
+package main
+
+// Building with -tags sqlite registers a pure-Go SQLite driver, so that
+// --history=sqlite:<path> works. Add it to the module first with
+// 'go get modernc.org/sqlite'.
+import _ "modernc.org/sqlite"
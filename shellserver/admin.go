@@ -0,0 +1,246 @@
+package shellserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// maintenance is set while the server refuses agent tool calls
+type maintenance struct {
+	message string // Told to the agent with each refusal
+}
+
+// WithAdminSocket serves the admin tools on a Unix socket at path, as an
+// MCP server of their own, so the agent-facing tools cannot change the
+// server's restrictions. The socket is only accessible to the server's
+// user, and since agent commands run as that user too, it is a protected
+// path of the allowlist. An existing socket at path is replaced.
+func WithAdminSocket(path string) Option {
+	return func(s *ShellServer) error {
+		if path == "" {
+			return fmt.Errorf("admin socket path is empty")
+		}
+		s.adminSocket = path
+		return nil
+	}
+}
+
+// RegisterAdminTools adds the admin tools to an MCP server. Embedding
+// applications serve them on an endpoint the agent cannot reach, never on
+// the server RegisterTools adds the shell tools to.
+func (s *ShellServer) RegisterAdminTools(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"clear_history",
+		mcp.WithDescription("Forget every command in the history, including a --history file."),
+	), s.handleClearHistory)
+
+	mcpServer.AddTool(mcp.NewTool(
+		"maintenance_mode",
+		mcp.WithDescription("Refuse every agent tool call until maintenance mode is turned off again."),
+		mcp.WithBoolean("enabled",
+			mcp.Description("Whether agent tool calls are refused"),
+			mcp.Required(),
+		),
+		mcp.WithString("message",
+			mcp.Description("What the agent is told, e.g. when to try again"),
+		),
+	), s.handleMaintenanceMode)
+
+	s.registerPolicyTools(mcpServer)
+
+	mcpServer.AddTool(mcp.NewTool(
+		"shadow_policy_report",
+		mcp.WithDescription("Compare the --shadow-policy with the live policy: the commands it would have refused or allowed differently since the server started."),
+	), s.handleShadowPolicyReport)
+
+	mcpServer.AddTool(mcp.NewTool(
+		"dump_diagnostics",
+		mcp.WithDescription("Show the server's goroutine count, memory statistics, running commands, sessions, REPLs and sandboxes, and the messages and approvals waiting, to debug stuck executions and leaks."),
+		mcp.WithBoolean("goroutines",
+			mcp.Description("Also dump the stack of every goroutine"),
+		),
+	), s.handleDumpDiagnostics)
+}
+
+// inMaintenance returns the maintenance the server is in, or nil
+func (s *ShellServer) inMaintenance() *maintenance {
+	return s.maintenance.Load()
+}
+
+func (s *ShellServer) handleClearHistory(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	clearer, ok := s.history.(HistoryClearer)
+	if !ok {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: The history store cannot be cleared.",
+		}), nil
+	}
+	count, _ := s.history.Count()
+	if err := clearer.Clear(); err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: Failed to clear the command history: %v", err),
+		}), nil
+	}
+	s.logger.Printf("Admin cleared the command history of %d commands", count)
+	s.resourceUpdated(HISTORY_RESOURCE_URI)
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: fmt.Sprintf("Cleared %d commands from the history.", count),
+			},
+		},
+	}, nil
+}
+
+func (s *ShellServer) handleMaintenanceMode(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	enabled, ok := request.Params.Arguments["enabled"].(bool)
+	if !ok {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'enabled' must be a boolean",
+			Details: map[string]interface{}{"argument": "enabled"},
+		}), nil
+	}
+	if !enabled {
+		s.maintenance.Store(nil)
+		s.logger.Println("Admin ended maintenance mode")
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "Maintenance mode is off; agent tool calls are accepted again.",
+				},
+			},
+		}, nil
+	}
+	message, _ := request.Params.Arguments["message"].(string)
+	if message == "" {
+		message = "Try again later."
+	}
+	s.maintenance.Store(&maintenance{message: message})
+	s.logger.Printf("Admin started maintenance mode: %s", message)
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: "Maintenance mode is on; agent tool calls are refused.",
+			},
+		},
+	}, nil
+}
+
+// listenAdmin serves the admin tools on the admin socket in the background
+func (s *ShellServer) listenAdmin() error {
+	listener, err := listenPrivateSocket(s.adminSocket)
+	if err != nil {
+		return err
+	}
+	s.adminListener = listener
+
+	admin := server.NewMCPServer("unix-shell-admin", "0.1.0", server.WithToolCapabilities(false))
+	s.RegisterAdminTools(admin)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveAdminConn(admin, conn)
+		}
+	}()
+	return nil
+}
+
+// protectAdminSockets adds the admin and profiling sockets to the protected
+// paths, so commands and file tools cannot reach the admin tools through
+// them. A custom policy is left as it is.
+func (s *ShellServer) protectAdminSockets() error {
+	if s.adminSocket == "" {
+		return nil
+	}
+	if _, ok := s.policy.(*AllowlistPolicy); !ok || s.customPolicy {
+		s.logger.Println("Warning: the policy is custom, so it must refuse commands that open the admin socket itself")
+		return nil
+	}
+	socket, err := filepath.Abs(s.adminSocket)
+	if err != nil {
+		return err
+	}
+	rules := &PolicyRules{DenyPaths: []string{socket}}
+	if s.profiling {
+		rules.DenyPaths = append(rules.DenyPaths, socket+PPROF_SOCKET_SUFFIX)
+	}
+	if err := s.addPolicyRules(rules); err != nil {
+		return err
+	}
+	s.policySources = append(s.policySources, policySource{rules: rules})
+	return nil
+}
+
+// listenPrivateSocket listens on a Unix socket at path that only the
+// server's user can open, replacing a socket left there
+func listenPrivateSocket(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := listenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serveAdminConn answers the JSON-RPC messages of one admin client, one per
+// line, until it disconnects
+func serveAdminConn(admin *server.MCPServer, conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), MAX_OUTPUT_SIZE)
+	for scanner.Scan() {
+		response := admin.HandleMessage(context.Background(), json.RawMessage(scanner.Bytes()))
+		if response == nil {
+			continue
+		}
+		data, err := json.Marshal(response)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(append(data, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+// closeAdmin stops serving the admin and profiling sockets and the admin
+// API, and removes the sockets
+func (s *ShellServer) closeAdmin() {
+	if s.adminListener != nil {
+		s.adminListener.Close()
+	}
+	if s.adminAPIListener != nil {
+		s.adminAPIListener.Close()
+	}
+	if s.pprofListener != nil {
+		s.pprofListener.Close()
+	}
+}
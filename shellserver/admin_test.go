@@ -0,0 +1,101 @@
+package shellserver
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAdminSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the admin socket is a Unix socket")
+	}
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithAdminSocket(path))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("admin socket mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	for _, name := range []string{"clear_history", "maintenance_mode", "allow_command", "list_policy_rules", "dump_diagnostics"} {
+		if s.toolNames[name] {
+			t.Errorf("%s is among the agent's tools", name)
+		}
+	}
+	// Agent commands run as the server's user, so the socket is protected
+	for _, command := range []string{"echo " + path, "echo {} > " + path} {
+		if s.policy.Allowed(command) {
+			t.Errorf("%q is allowed, want the admin socket protected", command)
+		}
+	}
+	if err := s.ReloadPolicy(); err != nil || s.policy.Allowed("echo "+path) {
+		t.Errorf("after a reload (%v), the admin socket is no longer protected", err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("cannot connect to the admin socket: %v", err)
+	}
+	defer conn.Close()
+	replies := bufio.NewScanner(conn)
+	admin := func(id int, method string, params string) string {
+		t.Helper()
+		fmt.Fprintf(conn, `{"jsonrpc":"2.0","id":%d,"method":"%s","params":%s}`+"\n", id, method, params)
+		if !replies.Scan() {
+			t.Fatalf("no response to %s: %v", method, replies.Err())
+		}
+		return replies.Text()
+	}
+	execute := s.authorized("execute_command", s.handleExecuteCommand)
+
+	admin(1, "initialize", `{"protocolVersion":"2024-11-05","clientInfo":{"name":"admin","version":"1"},"capabilities":{}}`)
+	if tools := admin(2, "tools/list", `{}`); !strings.Contains(tools, `"maintenance_mode"`) || strings.Contains(tools, `"execute_command"`) {
+		t.Errorf("admin tools/list = %s, want only the admin tools", tools)
+	}
+
+	callTool(t, execute, map[string]interface{}{"command": "echo one"})
+	admin(3, "tools/call", `{"name":"maintenance_mode","arguments":{"enabled":true,"message":"Back at noon."}}`)
+	if text, isError := callTool(t, execute, map[string]interface{}{"command": "echo two"}); !isError || !strings.Contains(text, "maintenance mode. Back at noon.") {
+		t.Errorf("execute_command in maintenance = %q, want a refusal", text)
+	}
+	admin(4, "tools/call", `{"name":"maintenance_mode","arguments":{"enabled":false}}`)
+	if text, isError := callTool(t, execute, map[string]interface{}{"command": "echo three"}); isError {
+		t.Errorf("execute_command after maintenance = %q, want it to run", text)
+	}
+
+	if reply := admin(5, "tools/call", `{"name":"clear_history","arguments":{}}`); !strings.Contains(reply, "Cleared 2 commands") {
+		t.Errorf("clear_history = %s", reply)
+	}
+	if count, _ := s.history.Count(); count != 0 {
+		t.Errorf("history holds %d commands after clear_history, want 0", count)
+	}
+}
+
+func TestClearJSONLHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := newJSONLHistory(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history.Add(CommandExecution{ID: 1, Command: "echo one"})
+	if err := history.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	history.Add(CommandExecution{ID: 2, Command: "echo two"})
+
+	reopened, err := newJSONLHistory(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, _ := reopened.Recent(0)
+	if count, _ := reopened.Count(); count != 1 || len(recent) != 1 || recent[0].Command != "echo two" {
+		t.Errorf("history after Clear = %+v, want only the later command", recent)
+	}
+}
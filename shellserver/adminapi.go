@@ -0,0 +1,291 @@
+package shellserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DRAIN_MESSAGE is what agents are told while the server drains, unless
+// the drain request gives a message
+const DRAIN_MESSAGE = "The server is being drained; try again shortly."
+
+// MAX_DRAIN_WAIT caps how long a drain request waits for running commands
+const MAX_DRAIN_WAIT = 10 * time.Minute
+
+// WithAdminAPI serves a JSON admin API over HTTP at addr, for fleet tooling
+// that manages many servers: listing executions and running commands,
+// stopping a command, reloading the policy and draining the server. addr is
+// a Unix socket path, which only the server's user can open, or a
+// host:port. Requests must carry token as a bearer token; one is required
+// on TCP addresses.
+func WithAdminAPI(addr string, token string) Option {
+	return func(s *ShellServer) error {
+		if addr == "" {
+			return fmt.Errorf("admin API address is empty")
+		}
+		if !isSocketPath(addr) && token == "" {
+			return fmt.Errorf("the admin API on %s needs a token; serve it on a Unix socket path to go without one", addr)
+		}
+		s.adminAPI, s.adminAPIToken = addr, token
+		return nil
+	}
+}
+
+// isSocketPath reports whether an address is a Unix socket path rather
+// than a host:port
+func isSocketPath(addr string) bool {
+	return strings.Contains(addr, "/")
+}
+
+// commandStopper stops a running command's processes. A command stopped
+// before its processes start, e.g. while queued, is stopped as soon as they
+// do.
+type commandStopper struct {
+	mutex   sync.Mutex
+	stops   map[int]func()
+	next    int
+	stopped bool
+}
+
+// add registers a func that stops processes of the command, and returns a
+// func that unregisters it once they exited
+func (c *commandStopper) add(stop func()) func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopped {
+		stop()
+		return func() {}
+	}
+	if c.stops == nil {
+		c.stops = make(map[int]func())
+	}
+	id := c.next
+	c.next++
+	c.stops[id] = stop
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(c.stops, id)
+	}
+}
+
+// stop stops the command's processes
+func (c *commandStopper) stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stopped = true
+	for _, stop := range c.stops {
+		stop()
+	}
+}
+
+// errNotRunning is returned for a command ID that is not running
+var errNotRunning = errors.New("not running")
+
+// stopRunning stops the running command with the ID dump_diagnostics gives
+// it, and returns its command line. Its processes get SIGTERM, and SIGKILL
+// after STOP_GRACE_PERIOD. Commands in sessions cannot be stopped.
+func (s *ShellServer) stopRunning(id int64) (string, error) {
+	s.runningMutex.Lock()
+	running, found := s.running[id]
+	s.runningMutex.Unlock()
+	if !found {
+		return "", errNotRunning
+	}
+	if running.session != "" {
+		return running.command, fmt.Errorf("command %d runs in session %s, which cannot interrupt it; close the session instead", id, running.session)
+	}
+	running.stopper.stop()
+	s.logger.Printf("Admin stopped command %d (%s)", id, escapeHidden(running.command))
+	return running.command, nil
+}
+
+// drain refuses agent tool calls from now on and waits up to wait for the
+// running commands to finish. It returns how many still run.
+func (s *ShellServer) drain(message string, wait time.Duration) int {
+	if message == "" {
+		message = DRAIN_MESSAGE
+	}
+	s.maintenance.Store(&maintenance{message: message})
+	s.logger.Printf("Admin started draining the server: %s", message)
+	deadline := time.Now().Add(wait)
+	for {
+		s.runningMutex.Lock()
+		running := len(s.running)
+		s.runningMutex.Unlock()
+		if running == 0 || !time.Now().Before(deadline) {
+			return running
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// listenAdminAPI serves the admin API in the background
+func (s *ShellServer) listenAdminAPI() error {
+	var listener net.Listener
+	var err error
+	if isSocketPath(s.adminAPI) {
+		listener, err = listenPrivateSocket(s.adminAPI)
+	} else {
+		listener, err = net.Listen("tcp", s.adminAPI)
+	}
+	if err != nil {
+		return err
+	}
+	s.adminAPIListener = listener
+	go func() {
+		if err := http.Serve(listener, s.adminAPIHandler()); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logger.Printf("Admin API stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// adminAPIHandler routes the admin API's requests
+func (s *ShellServer) adminAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/executions", s.handleAPIExecutions)
+	mux.HandleFunc("GET /v1/running", s.handleAPIRunning)
+	mux.HandleFunc("POST /v1/running/{id}/kill", s.handleAPIKill)
+	mux.HandleFunc("POST /v1/policy/reload", s.handleAPIReloadPolicy)
+	mux.HandleFunc("POST /v1/drain", s.handleAPIDrain)
+	mux.HandleFunc("DELETE /v1/drain", s.handleAPIUndrain)
+	mux.HandleFunc("GET /v1/diagnostics", s.handleAPIDiagnostics)
+	return s.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminAPIToken != "" {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminAPIToken)) != 1 {
+				writeAPIError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+// writeAPIResult writes a JSON response
+func writeAPIResult(w http.ResponseWriter, status int, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeAPIError writes a JSON error response
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIResult(w, status, map[string]string{"error": message})
+}
+
+// handleAPIExecutions lists the newest executions in the history, of one
+// project if ?project= is given, up to ?limit=
+func (s *ShellServer) handleAPIExecutions(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a non-negative number, got '%s'", value))
+			return
+		}
+	}
+	project := r.URL.Query().Get("project")
+	fetch := limit
+	if project != "" {
+		fetch = 0
+	}
+	executions, total, err := historySnapshot(s.history, fetch)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("failed to read the history: %v", err))
+		return
+	}
+	if project != "" {
+		matched := []CommandExecution{}
+		for _, execution := range executions {
+			if execution.Project == project {
+				matched = append(matched, execution)
+			}
+		}
+		executions, total = matched, len(matched)
+		if limit > 0 && len(executions) > limit {
+			executions = executions[:limit]
+		}
+	}
+	writeAPIResult(w, http.StatusOK, map[string]interface{}{"total": total, "executions": executions})
+}
+
+// handleAPIRunning lists the commands, sessions, REPLs and sandboxes the
+// server has running
+func (s *ShellServer) handleAPIRunning(w http.ResponseWriter, r *http.Request) {
+	writeAPIResult(w, http.StatusOK, map[string]interface{}{"running": s.processDiagnostics()})
+}
+
+// handleAPIKill stops a running command
+func (s *ShellServer) handleAPIKill(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("'%s' is not a command ID", r.PathValue("id")))
+		return
+	}
+	command, err := s.stopRunning(id)
+	if err == errNotRunning {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no command %d is running", id))
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeAPIResult(w, http.StatusOK, map[string]interface{}{"id": id, "command": command})
+}
+
+// handleAPIReloadPolicy reloads the policy from its files
+func (s *ShellServer) handleAPIReloadPolicy(w http.ResponseWriter, r *http.Request) {
+	if err := s.ReloadPolicy(); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeAPIResult(w, http.StatusOK, s.policy.(*AllowlistPolicy).rules())
+}
+
+// handleAPIDrain refuses agent tool calls and waits for the running
+// commands, up to the "wait" duration of the JSON body
+func (s *ShellServer) handleAPIDrain(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Message string `json:"message"`
+		Wait    string `json:"wait"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+	}
+	var wait time.Duration
+	if body.Wait != "" {
+		var err error
+		if wait, err = time.ParseDuration(body.Wait); err != nil || wait < 0 || wait > MAX_DRAIN_WAIT {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("wait must be a duration up to %s, got '%s'", MAX_DRAIN_WAIT, body.Wait))
+			return
+		}
+	}
+	running := s.drain(body.Message, wait)
+	writeAPIResult(w, http.StatusOK, map[string]interface{}{"draining": true, "running": running})
+}
+
+// handleAPIUndrain accepts agent tool calls again
+func (s *ShellServer) handleAPIUndrain(w http.ResponseWriter, r *http.Request) {
+	s.maintenance.Store(nil)
+	s.logger.Println("Admin ended draining the server")
+	writeAPIResult(w, http.StatusOK, map[string]interface{}{"draining": false})
+}
+
+// handleAPIDiagnostics returns what dump_diagnostics does, without stacks
+func (s *ShellServer) handleAPIDiagnostics(w http.ResponseWriter, r *http.Request) {
+	writeAPIResult(w, http.StatusOK, s.diagnostics(false))
+}
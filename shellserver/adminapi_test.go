@@ -0,0 +1,288 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// callAdminAPI sends a request to the admin API and decodes its JSON result
+func callAdminAPI(t *testing.T, s *ShellServer, method string, path string, body string) (int, map[string]interface{}) {
+	t.Helper()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+s.adminAPIToken)
+	recorder := httptest.NewRecorder()
+	s.adminAPIHandler().ServeHTTP(recorder, request)
+	var result map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("%s %s returned invalid JSON %q: %v", method, path, recorder.Body.String(), err)
+	}
+	return recorder.Code, result
+}
+
+func TestWithAdminAPI(t *testing.T) {
+	tests := []struct {
+		addr  string
+		token string
+		err   string
+	}{
+		{"", "", "address is empty"},
+		{"127.0.0.1:9440", "", "needs a token"},
+		{"127.0.0.1:9440", "secret", ""},
+		{"/run/mcp-shell/api.sock", "", ""},
+	}
+	for _, tt := range tests {
+		err := WithAdminAPI(tt.addr, tt.token)(&ShellServer{})
+		if (tt.err == "" && err != nil) || (tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err))) {
+			t.Errorf("WithAdminAPI(%q, %q) = %v, want %q", tt.addr, tt.token, err, tt.err)
+		}
+	}
+}
+
+func TestAdminAPISocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	s, err := NewShellServer(WithAdminAPI(path, "secret"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("socket %s = %v, %v; want mode 0600", path, info, err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		request, _ := http.NewRequest("GET", "http://localhost/v1/running", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		response.Body.Close()
+		if response.StatusCode != want {
+			t.Errorf("token %q: status %d, want %d", token, response.StatusCode, want)
+		}
+	}
+}
+
+func TestAdminAPIExecutions(t *testing.T) {
+	s, err := NewShellServer()
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	for _, execution := range []CommandExecution{{Command: "make", Project: "api"}, {Command: "ls"}, {Command: "make test", Project: "api"}} {
+		s.addToHistory(execution)
+	}
+
+	tests := []struct {
+		query    string
+		total    float64
+		commands string
+	}{
+		{"", 3, "make test,ls,make"},
+		{"?limit=1", 3, "make test"},
+		{"?project=api", 2, "make test,make"},
+		{"?project=api&limit=1", 2, "make test"},
+		{"?project=web", 0, ""},
+	}
+	for _, tt := range tests {
+		status, result := callAdminAPI(t, s, "GET", "/v1/executions"+tt.query, "")
+		var commands []string
+		for _, execution := range result["executions"].([]interface{}) {
+			commands = append(commands, execution.(map[string]interface{})["command"].(string))
+		}
+		if status != http.StatusOK || result["total"] != tt.total || strings.Join(commands, ",") != tt.commands {
+			t.Errorf("executions%s = %d %v, want %v of %v", tt.query, status, result, tt.commands, tt.total)
+		}
+	}
+	if status, _ := callAdminAPI(t, s, "GET", "/v1/executions?limit=-1", ""); status != http.StatusBadRequest {
+		t.Errorf("a negative limit returned status %d", status)
+	}
+}
+
+func TestAdminAPIKill(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("sleep"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	done := make(chan CommandExecution)
+	go func() {
+		execution, _ := s.exec(context.Background(), &ExecRequest{Command: "sleep 10", Shell: "bash"})
+		done <- execution
+	}()
+	var id string
+	for deadline := time.Now().Add(5 * time.Second); id == "" && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		_, result := callAdminAPI(t, s, "GET", "/v1/running", "")
+		for _, process := range result["running"].([]interface{}) {
+			if process := process.(map[string]interface{}); process["kind"] == "command" {
+				id = process["id"].(string)
+			}
+		}
+	}
+	// Let the process start
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if status, result := callAdminAPI(t, s, "POST", "/v1/running/"+id+"/kill", ""); status != http.StatusOK || result["command"] != "sleep 10" {
+		t.Errorf("kill = %d %v, want sleep 10 stopped", status, result)
+	}
+	execution := <-done
+	if time.Since(start) > 5*time.Second || !strings.Contains(execution.Output, "stopped by an administrator") {
+		t.Errorf("killed command returned %q after %s", execution.Output, time.Since(start))
+	}
+	if status, _ := callAdminAPI(t, s, "POST", "/v1/running/"+id+"/kill", ""); status != http.StatusNotFound {
+		t.Errorf("killing a finished command returned status %d, want 404", status)
+	}
+}
+
+func TestCommandStopper(t *testing.T) {
+	var stopper commandStopper
+	stopped := 0
+	remove := stopper.add(func() { stopped++ })
+	stopper.add(func() { stopped++ })
+	remove()
+	stopper.stop()
+	if stopped != 1 {
+		t.Errorf("stop ran %d funcs, want the 1 still registered", stopped)
+	}
+	// A command stopped before its processes started stops them at once
+	stopper.add(func() { stopped++ })
+	if stopped != 2 {
+		t.Errorf("a func added after stop did not run")
+	}
+}
+
+func TestReloadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	preset := filepath.Join(dir, "policy.json")
+	edits := filepath.Join(dir, "edits.json")
+	os.WriteFile(preset, []byte(`{"allow": ["ls"]}`), 0600)
+	os.WriteFile(edits, []byte(`{"deny": ["git push"]}`), 0600)
+	s, err := NewShellServer(
+		WithAllowedCommands("git"),
+		WithPreset(preset),
+		WithPolicyEdits(edits),
+		WithProjects([]Project{{Name: "api", Dir: dir, Policy: &PolicyRules{Allow: []string{"make"}}}}),
+		WithClientPolicies([]ClientPolicy{{Name: "cursor", Policy: &PolicyRules{Deny: []string{"git status"}}}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	s.policy.(*AllowlistPolicy).addRules(&PolicyRules{Allow: []string{"tar"}}) // Not persisted
+
+	os.WriteFile(preset, []byte(`{"allow": ["cat"]}`), 0600)
+	if status, result := callAdminAPI(t, s, "POST", "/v1/policy/reload", ""); status != http.StatusOK || len(result["allow"].([]interface{})) != 2 {
+		t.Errorf("reload = %d %v, want git and cat allowed", status, result)
+	}
+	tests := []struct {
+		project string
+		client  string
+		command string
+		want    bool
+	}{
+		{"", "", "cat x", true},
+		{"", "", "ls", false},
+		{"", "", "tar xf a.tar", false},
+		{"", "", "git push", false},
+		{"api", "", "cat x", true},
+		{"api", "", "make", true},
+		{"api", "cursor", "cat x", true},
+		{"api", "cursor", "git status", false},
+		{"", "cursor", "git status", false},
+		{"", "cursor", "git log", true},
+	}
+	for _, tt := range tests {
+		if got := s.policyFor(tt.project, tt.client).Allowed(tt.command); got != tt.want {
+			t.Errorf("after reload, %q for project %q and client %q allowed = %v, want %v", tt.command, tt.project, tt.client, got, tt.want)
+		}
+	}
+
+	// A broken file leaves the policy as it was
+	os.WriteFile(preset, []byte(`{"allow": [`), 0600)
+	if status, _ := callAdminAPI(t, s, "POST", "/v1/policy/reload", ""); status != http.StatusUnprocessableEntity {
+		t.Errorf("reload of a broken file returned status %d", status)
+	}
+	if !s.policy.Allowed("cat x") {
+		t.Errorf("a failed reload changed the policy")
+	}
+
+	custom, _ := NewShellServer(WithPolicy(NewAllowlistPolicy("ls")))
+	defer custom.Close()
+	if err := custom.ReloadPolicy(); err == nil {
+		t.Errorf("a policy given with WithPolicy was reloaded")
+	}
+}
+
+// Run with -race: file tools read the protected paths while a reload
+// replaces them
+func TestReloadPolicyWhileInspecting(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "secrets"), 0o755)
+	os.WriteFile(filepath.Join(dir, "secrets", "key"), []byte("hunter2\n"), 0o600)
+	s, err := NewShellServer(
+		WithAllowedCommands("ls"),
+		WithPolicyRules(&PolicyRules{DenyPaths: []string{"secrets"}}),
+		WithProjects([]Project{{Name: "app", Dir: dir}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := s.ReloadPolicy(); err != nil {
+				t.Errorf("ReloadPolicy failed: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if _, isError := callTool(t, s.handleHeadFile, map[string]interface{}{"path": "secrets/key", "project": "app"}); !isError {
+			t.Fatalf("head_file read a protected path during a reload")
+		}
+	}
+	<-done
+}
+
+func TestAdminAPIDrain(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	execute := s.authorized("execute_command", s.handleExecuteCommand)
+
+	if status, result := callAdminAPI(t, s, "POST", "/v1/drain", `{"message": "Moving hosts.", "wait": "1s"}`); status != http.StatusOK || result["running"] != 0.0 {
+		t.Errorf("drain = %d %v, want nothing running", status, result)
+	}
+	if text, isError := callTool(t, execute, map[string]interface{}{"command": "echo one"}); !isError || !strings.Contains(text, "Moving hosts.") {
+		t.Errorf("execute_command while draining = %q, want a refusal", text)
+	}
+	if status, _ := callAdminAPI(t, s, "POST", "/v1/drain", `{"wait": "forever"}`); status != http.StatusBadRequest {
+		t.Errorf("drain with an invalid wait returned status %d", status)
+	}
+	callAdminAPI(t, s, "DELETE", "/v1/drain", "")
+	if text, isError := callTool(t, execute, map[string]interface{}{"command": "echo two"}); isError {
+		t.Errorf("execute_command after draining = %q, want it to run", text)
+	}
+}
@@ -0,0 +1,331 @@
+package shellserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Agent settings
+const (
+	AGENT_CONCURRENCY   = 4                // Commands an agent runs at once by default
+	AGENT_MAX_BACKOFF   = 30 * time.Second // Longest wait between attempts to reach the gateway
+	AGENT_RESULT_TRIES  = 3                // Attempts to report a result
+	AGENT_TRANSPORT     = "gateway"        // Transport of the clients of commands an agent runs
+	agentRequestTimeout = AGENT_POLL_TIMEOUT + 10*time.Second
+)
+
+// AgentConfig connects a server to a gateway as one of its agents
+type AgentConfig struct {
+	Gateway     string            // Base URL of the gateway, e.g. https://gateway.example.com:9443
+	Name        string            // Name of the agent in the gateway's targets file
+	Token       string            // Token of the agent in the gateway's targets file
+	CAFile      string            // PEM certificates to verify the gateway with; the system's if empty
+	Concurrency int               // Commands run at once; AGENT_CONCURRENCY if zero
+	Labels      map[string]string // Labels reported to the gateway, besides os and arch
+}
+
+// check validates the configuration and returns the gateway's URL
+func (c AgentConfig) check() (*url.URL, error) {
+	gateway, err := url.Parse(c.Gateway)
+	if err != nil || gateway.Host == "" || (gateway.Scheme != "https" && gateway.Scheme != "http") {
+		return nil, fmt.Errorf("gateway must be an https:// URL, got '%s'", c.Gateway)
+	}
+	if gateway.Scheme == "http" && !loopbackAddress(gateway.Host) {
+		return nil, fmt.Errorf("gateway %s is not on this host, so it must use https: the agent token would cross the network in the clear", c.Gateway)
+	}
+	if !targetName.MatchString(c.Name) {
+		return nil, fmt.Errorf("invalid agent name '%s'", c.Name)
+	}
+	if len(c.Token) < MIN_AGENT_TOKEN {
+		return nil, fmt.Errorf("the agent token must have at least %d characters", MIN_AGENT_TOKEN)
+	}
+	if c.Concurrency < 0 {
+		return nil, fmt.Errorf("agent concurrency must not be negative, got %d", c.Concurrency)
+	}
+	if err := checkLabels(c.Labels); err != nil {
+		return nil, err
+	}
+	return gateway, nil
+}
+
+// agentClient is the connection of an agent to its gateway
+type agentClient struct {
+	s        *ShellServer
+	config   AgentConfig
+	gateway  *url.URL
+	base     string // Gateway URL up to the agent's name
+	client   *http.Client
+	hostname string
+	labels   map[string]string // Reported with every poll
+
+	mutex   sync.Mutex
+	running map[string]*commandStopper // Commands running, by job ID
+}
+
+// WithAgent makes the server an agent of a gateway, which ServeAgent
+// connects to. The gateway's CA is read here, before the server is
+// hardened.
+func WithAgent(config AgentConfig) Option {
+	return func(s *ShellServer) error {
+		gateway, err := config.check()
+		if err != nil {
+			return err
+		}
+		if config.Concurrency == 0 {
+			config.Concurrency = AGENT_CONCURRENCY
+		}
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if config.CAFile != "" {
+			pem, err := os.ReadFile(config.CAFile)
+			if err != nil {
+				return fmt.Errorf("failed to read the gateway CA: %v", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates in %s", config.CAFile)
+			}
+		}
+		s.agent = &agentClient{
+			s:       s,
+			config:  config,
+			gateway: gateway,
+			base:    strings.TrimSuffix(gateway.String(), "/") + "/v1/agents/" + url.PathEscape(config.Name),
+			client:  &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}},
+			running: make(map[string]*commandStopper),
+		}
+		s.agent.hostname, _ = os.Hostname()
+		s.agent.labels = map[string]string{"os": runtime.GOOS, "arch": runtime.GOARCH}
+		for key, value := range config.Labels {
+			s.agent.labels[key] = value
+		}
+		return nil
+	}
+}
+
+// ServeAgent runs commands for the gateway of WithAgent until ctx ends: it
+// polls the gateway for commands and runs each through the server's
+// middleware chain, so the server's own policy applies to them too. An
+// unreachable gateway is retried with backoff; a refused token ends it.
+func (s *ShellServer) ServeAgent(ctx context.Context) error {
+	a := s.agent
+	if a == nil {
+		return fmt.Errorf("the server is not an agent; configure it with WithAgent")
+	}
+	config := a.config
+
+	var wg sync.WaitGroup
+	defer func() {
+		// Stop what still runs; the gateway stops waiting for it anyway
+		a.mutex.Lock()
+		for _, stopper := range a.running {
+			stopper.stop()
+		}
+		a.mutex.Unlock()
+		wg.Wait()
+	}()
+	s.logger.Printf("Agent %s polling gateway %s", config.Name, a.gateway.Redacted())
+	backoff, connected := time.Second, false
+	for ctx.Err() == nil {
+		work, err := a.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if errors.Is(err, errAgentRefused) {
+				return err
+			}
+			if connected || backoff == time.Second {
+				s.logger.Printf("Agent cannot reach the gateway, retrying: %v", err)
+			}
+			connected = false
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			backoff = min(2*backoff, AGENT_MAX_BACKOFF)
+			continue
+		}
+		if !connected {
+			s.logger.Printf("Agent connected to the gateway")
+		}
+		backoff, connected = time.Second, true
+
+		a.mutex.Lock()
+		for _, id := range work.Cancel {
+			if stopper, found := a.running[id]; found {
+				stopper.stop()
+			}
+		}
+		for _, job := range work.Jobs {
+			stopper := &commandStopper{}
+			a.running[job.ID] = stopper
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				execution := a.run(job, stopper)
+				a.mutex.Lock()
+				delete(a.running, job.ID)
+				a.mutex.Unlock()
+				a.report(ctx, job.ID, execution)
+			}()
+		}
+		a.mutex.Unlock()
+	}
+	return nil
+}
+
+// errAgentRefused is returned when the gateway refuses the agent's token
+var errAgentRefused = errors.New("the gateway refused the agent's name or token")
+
+// poll asks the gateway for work, waiting up to AGENT_POLL_TIMEOUT
+func (a *agentClient) poll(ctx context.Context) (agentWork, error) {
+	a.mutex.Lock()
+	running := len(a.running)
+	a.mutex.Unlock()
+	info := AgentInfo{Hostname: a.hostname, OS: runtime.GOOS, Arch: runtime.GOARCH, Labels: a.labels, Running: running, Free: max(0, a.config.Concurrency-running)}
+	var work agentWork
+	response, err := a.post(ctx, "/poll", info)
+	if err != nil {
+		return work, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(response.Body).Decode(&work); err != nil {
+			return work, fmt.Errorf("invalid work from the gateway: %v", err)
+		}
+	case http.StatusNoContent:
+	case http.StatusUnauthorized:
+		return work, errAgentRefused
+	default:
+		return work, fmt.Errorf("gateway returned %s", response.Status)
+	}
+	return work, nil
+}
+
+// post sends body as JSON to the agent's path on the gateway
+func (a *agentClient) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	// Results with megabytes of output are gzipped; the client asks for
+	// gzipped responses by itself
+	compressed := len(data) >= a.s.compressMinSize && a.s.compressionLevel != gzip.NoCompression
+	if compressed {
+		if data, err = gzipBody(data, a.s.compressionLevel); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, agentRequestTimeout)
+	request, err := http.NewRequestWithContext(ctx, "POST", a.base+path, bytes.NewReader(data))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+a.config.Token)
+	request.Header.Set("Content-Type", JSON_MIME_TYPE)
+	if compressed {
+		request.Header.Set("Content-Encoding", ENCODING_GZIP)
+	}
+	response, err := a.client.Do(request)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	response.Body = cancelOnClose{response.Body, cancel}
+	return response, nil
+}
+
+// cancelOnClose cancels a request's context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// run runs a job through the middleware chain as a command for the MCP
+// client the gateway runs it for
+func (a *agentClient) run(job AgentJob, stopper *commandStopper) CommandExecution {
+	req := &ExecRequest{
+		Command:     job.Command,
+		Shell:       job.Shell,
+		Env:         job.Env,
+		Stdin:       job.Stdin,
+		Limits:      job.Limits,
+		IdleTimeout: time.Duration(job.IdleTimeoutMs) * time.Millisecond,
+		Priority:    job.Priority,
+		stopper:     stopper,
+	}
+	if job.Client != nil {
+		client := *job.Client
+		client.Transport, client.PeerPID, client.PeerUID = AGENT_TRANSPORT, 0, nil
+		req.Client = &client
+	}
+	if a.s.workProject != nil {
+		req.Project = a.s.workProject.Name
+	}
+	// The gateway's timeout can only shorten the agent's
+	if req.Limits.Timeout <= 0 || req.Limits.Timeout > a.s.timeout {
+		req.Limits.Timeout = a.s.timeout
+	}
+	if job.StopPattern != "" {
+		var err error
+		if req.StopPattern, err = regexp.Compile(job.StopPattern); err != nil {
+			now := time.Now()
+			return CommandExecution{Command: job.Command, Shell: job.Shell, Output: fmt.Sprintf("Error: invalid stop pattern: %v", err), ExitCode: 1, ErrorCode: ERROR_INVALID_ARGUMENT, StartTime: now, EndTime: now}
+		}
+	}
+
+	execution, err := a.s.exec(context.Background(), req)
+	if err != nil {
+		// Refused here; tell the gateway as the agent would be told
+		toolError := a.s.deniedToolError(req, err)
+		now := time.Now()
+		return CommandExecution{Command: job.Command, Shell: job.Shell, Output: toolError.Message, ExitCode: -1, ErrorCode: toolError.Code, StartTime: now, EndTime: now}
+	}
+	return execution
+}
+
+// report sends a result to the gateway, trying up to AGENT_RESULT_TRIES
+// times
+func (a *agentClient) report(ctx context.Context, id string, execution CommandExecution) {
+	for try := 1; ; try++ {
+		response, err := a.post(ctx, "/jobs/"+url.PathEscape(id), execution)
+		if err == nil {
+			response.Body.Close()
+			// Not found means the gateway stopped waiting for it
+			if response.StatusCode == http.StatusOK || response.StatusCode == http.StatusNotFound {
+				return
+			}
+			err = fmt.Errorf("gateway returned %s", response.Status)
+		}
+		if try == AGENT_RESULT_TRIES || ctx.Err() != nil {
+			a.s.logger.Printf("Agent failed to report the result of command %s: %v", id, err)
+			return
+		}
+		select {
+		case <-time.After(time.Duration(try) * time.Second):
+		case <-ctx.Done():
+		}
+	}
+}
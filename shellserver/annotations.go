@@ -0,0 +1,54 @@
+package shellserver
+
+import (
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Content priorities; 1 means the content is essential
+const (
+	PRIORITY_OUTPUT  = 1.0 // Command output, which the assistant reasons over
+	PRIORITY_SUMMARY = 0.8 // One-line summary shown to the user
+	SUMMARY_MAX_LEN  = 80  // Commands longer than this are shortened in summaries
+)
+
+// contentAnnotations has the shape of mcp.Annotated's anonymous Annotations struct
+type contentAnnotations = struct {
+	Audience []mcp.Role `json:"audience,omitempty"`
+	Priority float64    `json:"priority,omitempty"`
+}
+
+// annotate sets who a content item is for and how important it is
+func annotate(annotated *mcp.Annotated, priority float64, audience ...mcp.Role) {
+	annotated.Annotations = &contentAnnotations{Audience: audience, Priority: priority}
+}
+
+// assistantText creates text content meant for the assistant
+func assistantText(text string) mcp.TextContent {
+	content := mcp.NewTextContent(text)
+	annotate(&content.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+	return content
+}
+
+// executionSummary creates a one-line, user-facing summary of an execution
+func (s *ShellServer) executionSummary(execution CommandExecution) mcp.TextContent {
+	command := execution.Command
+	if execution.Original != "" {
+		command = execution.Original
+	}
+	if len(command) > SUMMARY_MAX_LEN {
+		command = command[:SUMMARY_MAX_LEN-3] + "..."
+	}
+
+	status := s.message(MSG_COMPLETED)
+	if execution.ExitCode != 0 {
+		status = s.message(MSG_FAILED, execution.ExitCode)
+	}
+
+	text := s.message(MSG_SUMMARY, command, status, execution.ExecutionMs)
+	if execution.Session != "" {
+		text = s.message(MSG_SUMMARY_SESSION, command, execution.Session, status, execution.ExecutionMs)
+	}
+	content := mcp.NewTextContent(text)
+	annotate(&content.Annotated, PRIORITY_SUMMARY, mcp.RoleUser)
+	return content
+}
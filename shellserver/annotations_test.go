@@ -0,0 +1,61 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestExecuteCommandAnnotations(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithExecutor(fixedExecutor(`{"a":1}`)))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		args      map[string]interface{}
+		audiences []string // Audience of each content item
+		summary   string
+	}{
+		{map[string]interface{}{"command": "echo hi"}, []string{"assistant", "user"}, "echo hi: completed successfully in 0 ms"},
+		{map[string]interface{}{"command": "echo hi", "json_format": "compact"}, []string{"assistant", "assistant", "user"}, "echo hi: completed successfully"},
+		{map[string]interface{}{"command": "echo " + strings.Repeat("x", 100)}, []string{"assistant", "user"}, "echo xxx"},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		result, _ := s.handleExecuteCommand(context.Background(), request)
+
+		// Check the annotations as clients see them on the wire
+		data, _ := json.Marshal(result)
+		var wire struct {
+			Content []struct {
+				Text        string `json:"text"`
+				Annotations struct {
+					Audience []string `json:"audience"`
+					Priority float64  `json:"priority"`
+				} `json:"annotations"`
+			} `json:"content"`
+		}
+		if err := json.Unmarshal(data, &wire); err != nil {
+			t.Fatalf("failed to decode result: %v", err)
+		}
+		if len(wire.Content) != len(tt.audiences) {
+			t.Fatalf("%v returned %d items, want %d", tt.args, len(wire.Content), len(tt.audiences))
+		}
+		for i, item := range wire.Content {
+			if len(item.Annotations.Audience) != 1 || item.Annotations.Audience[0] != tt.audiences[i] || item.Annotations.Priority == 0 {
+				t.Errorf("%v item %d annotations = %+v, want audience %s", tt.args, i, item.Annotations, tt.audiences[i])
+			}
+		}
+
+		summary := wire.Content[len(wire.Content)-1].Text
+		if !strings.HasPrefix(summary, tt.summary) || len(summary) > SUMMARY_MAX_LEN+40 {
+			t.Errorf("%v summary = %q, want it to start with %q", tt.args, summary, tt.summary)
+		}
+	}
+}
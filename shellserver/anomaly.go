@@ -0,0 +1,281 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An agent that was compromised, or is stuck in a loop, behaves unlike the
+// agent it replaced: it runs commands far faster, reaches for programs the
+// server never ran before, or starts writing files in bulk. The anomaly
+// detector counts these per window and, when a count crosses its threshold,
+// alerts notifiers and optionally throttles every command for a while.
+
+// Anomaly detection settings by default
+const (
+	DEFAULT_ANOMALY_WINDOW = time.Minute
+	MAX_ANOMALY_BASELINE   = 1000 // Executions of the history that seed the programs seen before
+)
+
+// Kinds of anomaly
+const (
+	ANOMALY_RATE   = "rate"   // Too many commands
+	ANOMALY_NOVEL  = "novel"  // Too many programs never run before
+	ANOMALY_WRITES = "writes" // Too many commands writing files
+)
+
+// fileWritingCommands change files by design, besides the commands that
+// redirect output to a file
+var fileWritingCommands = map[string]bool{
+	"chmod": true, "chown": true, "cp": true, "dd": true, "install": true, "ln": true,
+	"mkdir": true, "mv": true, "rm": true, "rmdir": true, "rsync": true, "shred": true,
+	"tee": true, "touch": true, "truncate": true, "unlink": true,
+}
+
+// AnomalyThresholds are how many commands of each kind may run within
+// Window before the detector raises an anomaly. Zero thresholds are not
+// checked.
+type AnomalyThresholds struct {
+	Rate     int           // Commands
+	Novel    int           // Commands running a program for the first time since the history began
+	Writes   int           // Commands writing files, e.g. with rm, cp, tee or a > redirection
+	Window   time.Duration // DEFAULT_ANOMALY_WINDOW if zero
+	Throttle time.Duration // How long commands are refused after an anomaly; zero only alerts
+}
+
+// ParseAnomalyThresholds parses an --anomaly spec of comma-separated
+// key=value pairs, e.g. "rate=60,novel=5,writes=30,window=1m,throttle=5m"
+func ParseAnomalyThresholds(spec string) (AnomalyThresholds, error) {
+	var thresholds AnomalyThresholds
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return AnomalyThresholds{}, fmt.Errorf("expected key=value, got '%s'", pair)
+		}
+
+		var err error
+		var count uint64
+		switch key {
+		case ANOMALY_RATE:
+			count, err = parseLimitCount(value)
+			thresholds.Rate = int(count)
+		case ANOMALY_NOVEL:
+			count, err = parseLimitCount(value)
+			thresholds.Novel = int(count)
+		case ANOMALY_WRITES:
+			count, err = parseLimitCount(value)
+			thresholds.Writes = int(count)
+		case "window":
+			thresholds.Window, err = time.ParseDuration(value)
+		case "throttle":
+			thresholds.Throttle, err = time.ParseDuration(value)
+		default:
+			return AnomalyThresholds{}, fmt.Errorf("unknown threshold '%s': expected rate, novel, writes, window or throttle", key)
+		}
+		if err != nil {
+			return AnomalyThresholds{}, fmt.Errorf("invalid %s '%s': %v", key, value, err)
+		}
+	}
+	return thresholds, nil
+}
+
+// WithAnomalyDetection alerts notifiers, and with a Throttle refuses
+// commands, when commands cross one of the thresholds
+func WithAnomalyDetection(thresholds AnomalyThresholds) Option {
+	return func(s *ShellServer) error {
+		if thresholds.Rate < 0 || thresholds.Novel < 0 || thresholds.Writes < 0 {
+			return fmt.Errorf("anomaly thresholds must not be negative")
+		}
+		if thresholds.Rate == 0 && thresholds.Novel == 0 && thresholds.Writes == 0 {
+			return fmt.Errorf("anomaly detection needs a rate, novel or writes threshold")
+		}
+		if thresholds.Window < 0 || thresholds.Throttle < 0 {
+			return fmt.Errorf("anomaly window and throttle must not be negative")
+		}
+		if thresholds.Window == 0 {
+			thresholds.Window = DEFAULT_ANOMALY_WINDOW
+		}
+		s.anomalies = &anomalyDetector{
+			thresholds: thresholds,
+			seen:       map[string]bool{},
+			recent:     map[string][]time.Time{},
+			raised:     map[string]time.Time{},
+		}
+		return nil
+	}
+}
+
+// anomalyDetector keeps the recent commands of each kind and the programs
+// run before
+type anomalyDetector struct {
+	mutex      sync.Mutex
+	thresholds AnomalyThresholds
+	seen       map[string]bool        // Programs run before
+	recent     map[string][]time.Time // When commands of each ANOMALY_* kind ran, within the window
+	raised     map[string]time.Time   // When each kind last raised an anomaly
+	throttled  time.Time              // Commands are refused until then
+	cause      string                 // What the throttling is for
+}
+
+// anomaly is a threshold a command crossed
+type anomaly struct {
+	kind  string // ANOMALY_*
+	count int
+	limit int
+}
+
+// learn marks the programs of past executions as seen
+func (d *anomalyDetector) learn(history []CommandExecution) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, execution := range history {
+		commands, _ := ParseCommands(execution.Command)
+		for _, cmd := range commands {
+			d.seen[cmd.Name] = true
+		}
+	}
+}
+
+// record counts a command run at now. It returns the anomaly the command
+// raised, if any, and whether commands are throttled.
+func (d *anomalyDetector) record(command string, now time.Time) (*anomaly, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if now.Before(d.throttled) {
+		return nil, true
+	}
+
+	commands, _ := ParseCommands(command)
+	novel, writes := false, false
+	for _, cmd := range commands {
+		if !d.seen[cmd.Name] {
+			d.seen[cmd.Name], novel = true, true
+		}
+		writes = writes || writesFiles(cmd)
+	}
+
+	var raised *anomaly
+	for _, kind := range []struct {
+		name    string
+		limit   int
+		matches bool
+	}{
+		{ANOMALY_RATE, d.thresholds.Rate, true},
+		{ANOMALY_NOVEL, d.thresholds.Novel, novel},
+		{ANOMALY_WRITES, d.thresholds.Writes, writes},
+	} {
+		if kind.limit == 0 || !kind.matches {
+			continue
+		}
+		count := d.add(kind.name, now)
+		// A kind raises one anomaly per window, not one per command over it
+		if count > kind.limit && raised == nil && now.Sub(d.raised[kind.name]) >= d.thresholds.Window {
+			raised = &anomaly{kind: kind.name, count: count, limit: kind.limit}
+		}
+	}
+	if raised == nil {
+		return nil, false
+	}
+	d.raised[raised.kind] = now
+	if d.thresholds.Throttle > 0 {
+		d.throttled, d.cause = now.Add(d.thresholds.Throttle), raised.describe(d.thresholds.Window)
+	}
+	return raised, d.thresholds.Throttle > 0
+}
+
+// add records a command of a kind at now and returns how many ran within
+// the window
+func (d *anomalyDetector) add(kind string, now time.Time) int {
+	cutoff := now.Add(-d.thresholds.Window)
+	kept := d.recent[kind][:0]
+	for _, t := range d.recent[kind] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	d.recent[kind] = append(kept, now)
+	return len(d.recent[kind])
+}
+
+// throttling returns until when commands are refused, and why
+func (d *anomalyDetector) throttling() (time.Time, string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.throttled, d.cause
+}
+
+// describe describes the anomaly for notifiers and the agent
+func (a anomaly) describe(window time.Duration) string {
+	switch a.kind {
+	case ANOMALY_NOVEL:
+		return fmt.Sprintf("%d commands ran programs not run before within %s, over the threshold of %d", a.count, window, a.limit)
+	case ANOMALY_WRITES:
+		return fmt.Sprintf("%d commands wrote files within %s, over the threshold of %d", a.count, window, a.limit)
+	default:
+		return fmt.Sprintf("%d commands ran within %s, over the threshold of %d", a.count, window, a.limit)
+	}
+}
+
+// writesFiles reports whether a command writes files, by its name or by
+// redirecting output to a file other than /dev/null
+func writesFiles(cmd ParsedCommand) bool {
+	if fileWritingCommands[cmd.Name] {
+		return true
+	}
+	if cmd.Name == "sed" && containsString(cmd.Args, "-i") {
+		return true
+	}
+	for _, redirect := range cmd.Redirects {
+		op, target, duplicate := splitRedirect(redirect)
+		if !duplicate && strings.ContainsRune(op, '>') && target != "/dev/null" {
+			return true
+		}
+	}
+	return false
+}
+
+// anomalyStep counts the commands that passed the policy and alerts
+// notifiers when one crosses a threshold. While commands are throttled, it
+// refuses them.
+func (s *ShellServer) anomalyStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		if s.anomalies == nil {
+			return next(ctx, req)
+		}
+		now := time.Now()
+		raised, throttled := s.anomalies.record(req.Command, now)
+		if raised != nil {
+			reason := raised.describe(s.anomalies.thresholds.Window)
+			s.logger.Printf("Anomaly: %s", reason)
+			s.emitEvent(EVENT_ANOMALY, CommandExecution{
+				Command:   req.Command,
+				Original:  req.Original,
+				Reason:    req.Reason,
+				Shell:     req.Shell,
+				Session:   req.Session,
+				Project:   req.Project,
+				Target:    req.Target,
+				Container: req.Container,
+				Client:    req.Client,
+				StartTime: s.now(),
+			}, reason)
+		}
+		if !throttled {
+			return next(ctx, req)
+		}
+		until, cause := s.anomalies.throttling()
+		return CommandExecution{}, &DeniedError{
+			Reason:  "throttled: " + cause,
+			Message: s.message(MSG_THROTTLED, until.Sub(now).Round(time.Second), cause),
+			Code:    ERROR_RATE_LIMITED,
+			Details: map[string]interface{}{"rule": "anomaly", "retryAfterMs": until.Sub(now).Milliseconds()},
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package shellserver
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAnomalyThresholds(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    AnomalyThresholds
+		wantErr bool
+	}{
+		{"rate=60", AnomalyThresholds{Rate: 60}, false},
+		{"novel=5, writes=30,window=5m,throttle=10m", AnomalyThresholds{Novel: 5, Writes: 30, Window: 5 * time.Minute, Throttle: 10 * time.Minute}, false},
+		{"rate", AnomalyThresholds{}, true},
+		{"rate=0", AnomalyThresholds{}, true},
+		{"window=soon", AnomalyThresholds{}, true},
+		{"reads=10", AnomalyThresholds{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAnomalyThresholds(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAnomalyThresholds(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAnomalyThresholds(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	if _, err := NewShellServer(WithAnomalyDetection(AnomalyThresholds{Window: time.Minute})); err == nil {
+		t.Errorf("NewShellServer accepted anomaly detection without thresholds")
+	}
+}
+
+func TestWritesFiles(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"ls -la", false},
+		{"grep x file 2>&1 >/dev/null", false},
+		{"echo hi > notes.txt", true},
+		{"sort data >> sorted", true},
+		{"sed -i s/a/b/ file", true},
+		{"sed s/a/b/ file", false},
+		{"cat a | tee b", true},
+		{"rm -f build.log", true},
+	}
+
+	for _, tt := range tests {
+		commands, err := ParseCommands(tt.command)
+		if err != nil {
+			t.Fatalf("ParseCommands(%q) failed: %v", tt.command, err)
+		}
+		got := false
+		for _, cmd := range commands {
+			got = got || writesFiles(cmd)
+		}
+		if got != tt.want {
+			t.Errorf("writesFiles(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	d := &anomalyDetector{
+		thresholds: AnomalyThresholds{Rate: 3, Novel: 2, Writes: 2, Window: time.Minute},
+		seen:       map[string]bool{},
+		recent:     map[string][]time.Time{},
+		raised:     map[string]time.Time{},
+	}
+	d.learn([]CommandExecution{{Command: "ls -la | wc -l"}, {Command: "git status"}})
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		command string
+		at      time.Duration
+		want    string
+	}{
+		{"ls", 0, ""},
+		{"git log", time.Second, ""},
+		{"wc -l < log", 2 * time.Second, ""},
+		// The fourth command within a minute
+		{"ls", 3 * time.Second, ANOMALY_RATE},
+		// Raised once per window
+		{"ls", 4 * time.Second, ""},
+		{"nmap host", 70 * time.Second, ""},
+		{"nc -l 4444", 71 * time.Second, ""},
+		{"socat - tcp:host:80", 72 * time.Second, ANOMALY_NOVEL},
+		// Programs become known once run
+		{"nmap host", 140 * time.Second, ""},
+		{"touch a", 200 * time.Second, ""},
+		{"touch b", 201 * time.Second, ""},
+		{"echo c > c", 202 * time.Second, ANOMALY_WRITES},
+	}
+
+	for _, tt := range tests {
+		raised, throttled := d.record(tt.command, start.Add(tt.at))
+		got := ""
+		if raised != nil {
+			got = raised.kind
+		}
+		if got != tt.want || throttled {
+			t.Errorf("record(%q) at %s = %q (throttled %v), want %q", tt.command, tt.at, got, throttled, tt.want)
+		}
+	}
+}
+
+func TestAnomalyThrottle(t *testing.T) {
+	events := &recordingNotifier{}
+	s, err := NewShellServer(
+		WithAllowedCommands("echo"),
+		WithExecutor(&fakeExecutor{}),
+		WithNotifier(events),
+		WithAnomalyDetection(AnomalyThresholds{Rate: 2, Throttle: time.Hour}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	execute := s.authorized("execute_command", s.handleExecuteCommand)
+
+	for i, want := range []string{"fake: echo 1", "fake: echo 2", "Commands are throttled", "Commands are throttled"} {
+		command := "echo " + strconv.Itoa(i+1)
+		text, isError := callTool(t, execute, map[string]interface{}{"command": command})
+		if !strings.Contains(text, want) || isError != (i >= 2) {
+			t.Errorf("execute_command(%q) = %q (error %v), want %q", command, text, isError, want)
+		}
+	}
+	anomalies := 0
+	for _, event := range events.events {
+		if event.Event == EVENT_ANOMALY {
+			anomalies++
+			if !strings.Contains(event.Reason, "3 commands ran within 1m0s, over the threshold of 2") {
+				t.Errorf("anomaly reason = %q", event.Reason)
+			}
+		}
+	}
+	if anomalies != 1 {
+		t.Errorf("sent %d anomaly events, want 1", anomalies)
+	}
+}
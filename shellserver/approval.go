@@ -0,0 +1,253 @@
+package shellserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Approval workflow settings
+const (
+	DEFAULT_APPROVAL_TIMEOUT = 5 * time.Minute // How long a command waits for a human decision
+	APPROVAL_PATH_PREFIX     = "/approvals/"
+)
+
+// Approval decisions
+const (
+	APPROVAL_APPROVED = "approved"
+	APPROVAL_DENIED   = "denied"
+	APPROVAL_EXPIRED  = "expired"
+)
+
+// pendingApproval is a high-risk command waiting for a human decision
+type pendingApproval struct {
+	id       string
+	token    string
+	command  string
+	impact   string // What the command would do to files, e.g. "deletes 12 files (3.4 MiB)"
+	reason   string // Why the agent runs the command, if it said
+	created  time.Time
+	decision chan string
+}
+
+// approvalManager posts high-risk commands to a chat webhook and waits for
+// an approve or deny callback on its HTTP endpoint
+type approvalManager struct {
+	rules      [][]string // Command prefixes that need approval, split into words
+	chatURL    string     // Slack or Discord incoming webhook URL
+	publicURL  string     // Base URL of the callback endpoint as seen from chat
+	timeout    time.Duration
+	client     *http.Client
+	mutex      sync.Mutex
+	pending    map[string]*pendingApproval
+	listenAddr string
+	logger     *log.Logger
+}
+
+// newApprovalManager parses the high-risk command list. Each entry is a
+// command name followed by words it must be given, such as "rm" or
+// "git push".
+func newApprovalManager(required string, chatURL string, publicURL string, timeout time.Duration) *approvalManager {
+	m := &approvalManager{
+		chatURL:   chatURL,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		timeout:   timeout,
+		client:    &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		pending:   make(map[string]*pendingApproval),
+		logger:    log.Default(),
+	}
+	for _, rule := range strings.Split(required, ",") {
+		if fields := strings.Fields(rule); len(fields) > 0 {
+			m.rules = append(m.rules, fields)
+		}
+	}
+	return m
+}
+
+// requiresApproval reports whether any command a command line would run
+// matches a high-risk rule, as deny rules are matched. Command lines that
+// cannot be parsed require approval.
+func (m *approvalManager) requiresApproval(command string) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+	commands, err := ParseCommands(command)
+	if err != nil {
+		return true
+	}
+	for _, cmd := range commands {
+		for _, rule := range m.rules {
+			if matchesDenyRule(cmd, rule) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestApproval posts the command, and its impact and the agent's reason
+// if known, to chat and blocks until a human decides or the timeout expires
+func (m *approvalManager) requestApproval(command string, impact string, reason string) (string, error) {
+	if m.chatURL == "" || m.publicURL == "" {
+		return APPROVAL_DENIED, fmt.Errorf("approval is required but no approval channel is configured")
+	}
+
+	approval := &pendingApproval{
+		id:       randomHex(8),
+		token:    randomHex(16),
+		command:  command,
+		impact:   impact,
+		reason:   reason,
+		created:  time.Now(),
+		decision: make(chan string, 1),
+	}
+
+	m.mutex.Lock()
+	m.pending[approval.id] = approval
+	m.mutex.Unlock()
+	defer func() {
+		m.mutex.Lock()
+		delete(m.pending, approval.id)
+		m.mutex.Unlock()
+	}()
+
+	if err := m.postToChat(approval); err != nil {
+		return APPROVAL_DENIED, fmt.Errorf("could not post approval request: %v", err)
+	}
+
+	select {
+	case decision := <-approval.decision:
+		return decision, nil
+	case <-time.After(m.timeout):
+		return APPROVAL_EXPIRED, nil
+	}
+}
+
+// postToChat sends the approval request to a Slack or Discord webhook
+func (m *approvalManager) postToChat(approval *pendingApproval) error {
+	link := fmt.Sprintf("%s%s%s?token=%s", m.publicURL, APPROVAL_PATH_PREFIX, approval.id, approval.token)
+	text := fmt.Sprintf("An agent wants to run a high-risk command:\n```\n%s\n```\n", escapeHidden(approval.command))
+	if approval.reason != "" {
+		text += fmt.Sprintf("Reason given by the agent: %s\n", escapeHidden(approval.reason))
+	}
+	if approval.impact != "" {
+		text += fmt.Sprintf("Impact: the command %s.\n", approval.impact)
+	}
+	text += fmt.Sprintf("Review and approve or deny within %s: %s", m.timeout, link)
+
+	// Discord webhooks take "content"; Slack and compatible ones take "text"
+	payload := map[string]string{"text": text}
+	if parsed, err := url.Parse(m.chatURL); err == nil && (strings.HasSuffix(parsed.Host, "discord.com") || strings.HasSuffix(parsed.Host, "discordapp.com")) {
+		payload = map[string]string{"content": text}
+	}
+	body, _ := json.Marshal(payload)
+
+	resp, err := m.client.Post(m.chatURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("chat webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// ServeHTTP handles approval callbacks. GET renders a confirmation page so
+// that link previews in chat never approve anything; POST records the decision.
+func (m *approvalManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, APPROVAL_PATH_PREFIX)
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		token = r.PostForm.Get("token")
+	}
+
+	m.mutex.Lock()
+	approval, ok := m.pending[id]
+	m.mutex.Unlock()
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(approval.token)) != 1 {
+		http.Error(w, "Unknown or expired approval request.", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html><html><body>
+<h3>Approve command?</h3><pre>%s</pre><p>%s</p><p>%s</p>
+<form method="post"><input type="hidden" name="token" value="%s">
+<button name="decision" value="approve">Approve</button>
+<button name="decision" value="deny">Deny</button></form></body></html>`,
+			html.EscapeString(escapeHidden(approval.command)), html.EscapeString(approvalReasonText(approval.reason)),
+			html.EscapeString(approvalImpactText(approval.impact)), html.EscapeString(token))
+	case http.MethodPost:
+		decision := APPROVAL_DENIED
+		if r.PostForm.Get("decision") == "approve" {
+			decision = APPROVAL_APPROVED
+		}
+		select {
+		case approval.decision <- decision:
+			fmt.Fprintf(w, "Command %s.\n", decision)
+		default:
+			http.Error(w, "A decision was already recorded.", http.StatusConflict)
+		}
+	default:
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+// listen serves the approval callback endpoint in the background
+func (m *approvalManager) listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	m.listenAddr = listener.Addr().String()
+	if m.publicURL == "" {
+		m.publicURL = "http://" + m.listenAddr
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(APPROVAL_PATH_PREFIX, m)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			m.logger.Printf("Approval endpoint stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// approvalImpactText describes an impact on the approval page
+func approvalImpactText(impact string) string {
+	if impact == "" {
+		return "The impact of this command was not assessed."
+	}
+	return "Impact: the command " + impact + "."
+}
+
+// approvalReasonText describes the agent's reason on the approval page
+func approvalReasonText(reason string) string {
+	if reason == "" {
+		return "The agent gave no reason for this command."
+	}
+	return "Reason given by the agent: " + escapeHidden(reason)
+}
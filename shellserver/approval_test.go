@@ -0,0 +1,112 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequiresApproval(t *testing.T) {
+	m := newApprovalManager("rm, git push ,kubectl delete", "", "", time.Second)
+
+	tests := []struct {
+		command  string
+		required bool
+	}{
+		{"rm -rf build", true},
+		{"git push origin main", true},
+		{"git status", false},
+		{"kubectl delete pod x", true},
+		{"kubectl get pods", false},
+		{"rmdir foo", false},
+		{"", false},
+		{"echo x; rm -rf build", true},
+		{"echo $(rm -rf build)", true},
+		{"\\rm -rf build", true},
+		{"'rm' -rf build", true},
+		{"\"rm\" -rf build", true},
+		{"git -C repo push", true},
+		{"echo 'unterminated", true},
+	}
+
+	for _, test := range tests {
+		if got := m.requiresApproval(test.command); got != test.required {
+			t.Errorf("requiresApproval(%q) = %v, want %v", test.command, got, test.required)
+		}
+	}
+
+	var unset *approvalManager
+	if unset.requiresApproval("rm -rf /") {
+		t.Errorf("a nil approval manager should not require approval")
+	}
+}
+
+func TestApprovalFlow(t *testing.T) {
+	posted := make(chan string, 1)
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		posted <- payload["text"]
+	}))
+	defer chat.Close()
+
+	m := newApprovalManager("rm", chat.URL, "", 5*time.Second)
+	if err := m.listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	result := make(chan string, 1)
+	go func() {
+		decision, err := m.requestApproval("rm -rf build\u200d", "deletes 3 files (1.0 KiB)", "Stale <build> output")
+		if err != nil {
+			t.Errorf("requestApproval failed: %v", err)
+		}
+		result <- decision
+	}()
+
+	text := <-posted
+	link := regexp.MustCompile(`http://\S+`).FindString(text)
+	// Invisible characters are shown escaped
+	if !strings.Contains(text, "rm -rf build\\u{200d}") || !strings.Contains(text, "deletes 3 files") ||
+		!strings.Contains(text, "Reason given by the agent: Stale <build> output") || link == "" {
+		t.Fatalf("chat message %q lacks the command, impact, reason or link", text)
+	}
+
+	// Opening the link must not decide anything on its own
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatalf("GET approval page failed: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "rm -rf build\\u{200d}") || !strings.Contains(string(page), "deletes 3 files") ||
+		!strings.Contains(string(page), "Stale &lt;build&gt; output") {
+		t.Errorf("approval page does not show the command, impact and reason: %s", page)
+	}
+
+	parsed, _ := url.Parse(link)
+	token := parsed.Query().Get("token")
+	parsed.RawQuery = ""
+	resp, err = http.PostForm(parsed.String(), url.Values{"token": {token}, "decision": {"approve"}})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST approval failed: %v %v", err, resp)
+	}
+	resp.Body.Close()
+
+	if decision := <-result; decision != APPROVAL_APPROVED {
+		t.Errorf("decision = %q, want %q", decision, APPROVAL_APPROVED)
+	}
+
+	// A wrong token is rejected
+	resp, _ = http.PostForm(parsed.String(), url.Values{"token": {"nope"}, "decision": {"approve"}})
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("POST with bad token returned %d, want 404", resp.StatusCode)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,438 @@
+package shellserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// list_archive and extract_archive read tar, gzipped tar, zip and gzip
+// files in process, so tar and unzip need not be allowed with whatever
+// flags an agent passes them. Extraction checks every entry before writing
+// anything: names that would land outside the destination, links, protected
+// paths and existing files refuse the whole archive.
+
+// Archive formats
+const (
+	ARCHIVE_TAR    = "tar"
+	ARCHIVE_TAR_GZ = "tar.gz"
+	ARCHIVE_ZIP    = "zip"
+	ARCHIVE_GZIP   = "gzip" // A single gzipped file
+)
+
+// Limits of the archive tools
+const (
+	MAX_ARCHIVE_ENTRIES = 10000                    // Most entries listed or extracted
+	MAX_EXTRACT_SIZE    = 1024 * 1024 * 1024       // Most bytes extracted from one archive
+	ARCHIVE_URI         = "shell://archive.json"   // URI of the structured list_archive result
+	EXTRACT_URI         = "shell://extracted.json" // URI of the structured extract_archive result
+)
+
+// ArchiveEntry is a file, directory or link in an archive
+type ArchiveEntry struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"` // "file", "directory", "symlink", "hardlink" or "other"
+	Size       int64     `json:"size"` // Uncompressed size, as the archive states it
+	Mode       string    `json:"mode"`
+	ModTime    time.Time `json:"modTime,omitempty"`
+	LinkTarget string    `json:"linkTarget,omitempty"`
+}
+
+// ArchiveListing is what list_archive returns
+type ArchiveListing struct {
+	Path      string         `json:"path"`
+	Format    string         `json:"format"`
+	Entries   []ArchiveEntry `json:"entries"`
+	TotalSize int64          `json:"totalSize"` // Uncompressed size of the listed files
+	Truncated bool           `json:"truncated"` // The archive has more than MAX_ARCHIVE_ENTRIES entries
+}
+
+// Extraction is what extract_archive returns
+type Extraction struct {
+	Path        string   `json:"path"`
+	Destination string   `json:"destination"`
+	Format      string   `json:"format"`
+	Files       []string `json:"files"` // Paths written, relative to the destination
+	Directories int      `json:"directories"`
+	Bytes       int64    `json:"bytes"`
+}
+
+// errArchiveStop ends a walk early without an error
+var errArchiveStop = errors.New("stop")
+
+// walkArchive calls fn with each entry of the archive at name and a reader
+// for its content, valid only during the call. It returns the format.
+func walkArchive(name string, fn func(entry ArchiveEntry, content io.Reader) error) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(512)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return ARCHIVE_ZIP, walkZip(file, fn)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		decompressed, err := gzip.NewReader(reader)
+		if err != nil {
+			return ARCHIVE_GZIP, err
+		}
+		inner := bufio.NewReader(decompressed)
+		if header, _ := inner.Peek(512); isTar(header) {
+			return ARCHIVE_TAR_GZ, walkTar(inner, fn)
+		}
+		entryName := decompressed.Name
+		if entryName == "" {
+			entryName = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(name), ".gz"), ".gzip")
+		}
+		entry := ArchiveEntry{Name: path.Base(entryName), Type: "file", Size: -1, Mode: os.FileMode(0o644).String(), ModTime: decompressed.ModTime}
+		if err := fn(entry, inner); err != nil && err != errArchiveStop {
+			return ARCHIVE_GZIP, err
+		}
+		return ARCHIVE_GZIP, nil
+	case isTar(magic):
+		return ARCHIVE_TAR, walkTar(reader, fn)
+	}
+	return "", fmt.Errorf("not a tar, zip or gzip archive")
+}
+
+// isTar reports whether a block starts a tar archive
+func isTar(block []byte) bool {
+	return len(block) >= 262 && bytes.HasPrefix(block[257:], []byte("ustar"))
+}
+
+func walkTar(r io.Reader, fn func(entry ArchiveEntry, content io.Reader) error) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry := ArchiveEntry{
+			Name:    header.Name,
+			Size:    header.Size,
+			Mode:    header.FileInfo().Mode().String(),
+			ModTime: header.ModTime,
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			entry.Type = "file"
+		case tar.TypeDir:
+			entry.Type = "directory"
+		case tar.TypeSymlink:
+			entry.Type, entry.LinkTarget = "symlink", header.Linkname
+		case tar.TypeLink:
+			entry.Type, entry.LinkTarget = "hardlink", header.Linkname
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			entry.Type = "other"
+		}
+		if err := fn(entry, reader); err != nil {
+			if err == errArchiveStop {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func walkZip(file *os.File, fn func(entry ArchiveEntry, content io.Reader) error) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	reader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return err
+	}
+	for _, f := range reader.File {
+		mode := f.Mode()
+		entry := ArchiveEntry{
+			Name:    f.Name,
+			Size:    int64(f.UncompressedSize64),
+			Mode:    mode.String(),
+			ModTime: f.Modified,
+		}
+		switch {
+		case mode.IsDir():
+			entry.Type = "directory"
+		case mode&os.ModeSymlink != 0:
+			entry.Type = "symlink"
+		case mode.IsRegular():
+			entry.Type = "file"
+		default:
+			entry.Type = "other"
+		}
+
+		content, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if entry.Type == "symlink" {
+			target, _ := io.ReadAll(io.LimitReader(content, 4096))
+			entry.LinkTarget = string(target)
+		}
+		err = fn(entry, content)
+		content.Close()
+		if err != nil {
+			if err == errArchiveStop {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// listArchive lists up to MAX_ARCHIVE_ENTRIES entries of an archive
+func listArchive(name string) (*ArchiveListing, error) {
+	listing := &ArchiveListing{Path: name, Entries: []ArchiveEntry{}}
+	format, err := walkArchive(name, func(entry ArchiveEntry, content io.Reader) error {
+		if len(listing.Entries) == MAX_ARCHIVE_ENTRIES {
+			listing.Truncated = true
+			return errArchiveStop
+		}
+		if entry.Size < 0 {
+			// A gzip file does not state its size; count it
+			entry.Size, _ = io.Copy(io.Discard, io.LimitReader(content, MAX_EXTRACT_SIZE+1))
+		}
+		if entry.Type == "file" {
+			listing.TotalSize += entry.Size
+		}
+		listing.Entries = append(listing.Entries, entry)
+		return nil
+	})
+	listing.Format = format
+	return listing, err
+}
+
+// archiveTarget returns where an entry is extracted to under destination,
+// or an error if its name is absolute or climbs out with ".."
+func archiveTarget(destination string, name string) (string, string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || (len(name) > 1 && name[1] == ':') {
+		return "", "", fmt.Errorf("entry '%s' has an absolute path", name)
+	}
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", "", fmt.Errorf("entry '%s' would be extracted outside the destination", name)
+	}
+	if cleaned == "." {
+		return destination, ".", nil
+	}
+	return filepath.Join(destination, filepath.FromSlash(cleaned)), cleaned, nil
+}
+
+// checkExtraction refuses an archive before anything is written: entries
+// outside the destination or under protected paths, links, entries that
+// are neither files nor directories, existing files unless overwrite is
+// set, and archives over the entry and size limits
+func checkExtraction(name string, destination string, overwrite bool, protected func(string) bool) error {
+	entries := 0
+	var total int64
+	_, err := walkArchive(name, func(entry ArchiveEntry, content io.Reader) error {
+		entries++
+		if entries > MAX_ARCHIVE_ENTRIES {
+			return fmt.Errorf("the archive has more than %d entries", MAX_ARCHIVE_ENTRIES)
+		}
+		target, relative, err := archiveTarget(destination, entry.Name)
+		if err != nil {
+			return err
+		}
+		switch entry.Type {
+		case "file", "directory":
+		case "symlink", "hardlink":
+			return fmt.Errorf("entry '%s' is a link, which is not extracted", entry.Name)
+		default:
+			return fmt.Errorf("entry '%s' is not a file or directory", entry.Name)
+		}
+		if protected != nil && (protected(relative) || protected(target)) {
+			return fmt.Errorf("entry '%s' is under a protected path", entry.Name)
+		}
+		if entry.Type == "directory" {
+			return nil
+		}
+		if relative == "." {
+			return fmt.Errorf("entry '%s' names no file", entry.Name)
+		}
+
+		size := entry.Size
+		if size < 0 {
+			size, _ = io.Copy(io.Discard, io.LimitReader(content, MAX_EXTRACT_SIZE+1))
+		}
+		if total += size; total > MAX_EXTRACT_SIZE {
+			return fmt.Errorf("the archive expands to more than %s", formatByteSize(MAX_EXTRACT_SIZE))
+		}
+		if info, err := os.Lstat(target); err == nil {
+			switch {
+			case !info.Mode().IsRegular():
+				return fmt.Errorf("'%s' exists and is not a regular file", relative)
+			case !overwrite:
+				return fmt.Errorf("'%s' exists; set overwrite to replace it", relative)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// extractArchive writes the files and directories of a checked archive
+// under destination. Sizes are enforced while writing, as archives can
+// understate them, and no directory is followed out of the destination.
+func extractArchive(name string, destination string) (*Extraction, error) {
+	extraction := &Extraction{Path: name, Destination: destination, Files: []string{}}
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		return extraction, err
+	}
+	root, err := filepath.EvalSymlinks(destination)
+	if err != nil {
+		return extraction, err
+	}
+
+	budget := int64(MAX_EXTRACT_SIZE)
+	format, err := walkArchive(name, func(entry ArchiveEntry, content io.Reader) error {
+		target, relative, err := archiveTarget(destination, entry.Name)
+		if err != nil {
+			return err
+		}
+		dir := target
+		if entry.Type != "directory" {
+			dir = filepath.Dir(target)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		// An existing symlinked directory must not lead out
+		if real, err := filepath.EvalSymlinks(dir); err != nil || !isUnder(real, root) {
+			return fmt.Errorf("'%s' is outside the destination", filepath.Dir(relative))
+		}
+		if entry.Type == "directory" {
+			extraction.Directories++
+			return nil
+		}
+
+		// A file replaced by a link since the check is not written through
+		if info, err := os.Lstat(target); err == nil && !info.Mode().IsRegular() {
+			return fmt.Errorf("'%s' exists and is not a regular file", relative)
+		}
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		written, err := io.Copy(file, io.LimitReader(content, budget+1))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if budget -= written; budget < 0 {
+			os.Remove(target)
+			return fmt.Errorf("the archive expands to more than %s", formatByteSize(MAX_EXTRACT_SIZE))
+		}
+		extraction.Files = append(extraction.Files, relative)
+		extraction.Bytes += written
+		return nil
+	})
+	extraction.Format = format
+	return extraction, err
+}
+
+func (s *ShellServer) handleListArchive(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, toolError := s.inspectPath(ctx, request, "path")
+	if toolError == nil {
+		var file *os.File
+		if file, _, toolError = openRegular(name); file != nil {
+			file.Close()
+		}
+	}
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	listing, err := listArchive(name)
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_ARCHIVE_REJECTED,
+			Message: fmt.Sprintf("Error: '%s' cannot be read: %v", name, err),
+			Details: map[string]interface{}{"path": name},
+		}), nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s (%s): %d entries, %s uncompressed", name, listing.Format, len(listing.Entries), formatByteSize(listing.TotalSize))
+	if listing.Truncated {
+		fmt.Fprintf(&text, "; only the first %d entries are listed", MAX_ARCHIVE_ENTRIES)
+	}
+	for _, entry := range listing.Entries {
+		fmt.Fprintf(&text, "\n%s %10d %s", entry.Mode, entry.Size, entry.Name)
+		if entry.LinkTarget != "" {
+			fmt.Fprintf(&text, " -> %s", entry.LinkTarget)
+		}
+	}
+	return jsonResult(text.String(), ARCHIVE_URI, listing), nil
+}
+
+func (s *ShellServer) handleExtractArchive(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, toolError := s.inspectPath(ctx, request, "path")
+	if toolError == nil {
+		var file *os.File
+		if file, _, toolError = openRegular(name); file != nil {
+			file.Close()
+		}
+	}
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	destination, toolError := s.writablePath(ctx, request, "destination")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	overwrite, _ := request.Params.Arguments["overwrite"].(bool)
+	projectName, _ := request.Params.Arguments["project"].(string)
+
+	if err := checkExtraction(name, destination, overwrite, s.protectedPaths(projectName, clientName(s.clientIdentity(ctx)))); err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_ARCHIVE_REJECTED,
+			Message: s.message(MSG_ARCHIVE_REJECTED, name, err),
+			Details: map[string]interface{}{"path": name, "reason": err.Error()},
+		}), nil
+	}
+
+	extraction, err := extractArchive(name, destination)
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: extracting '%s' failed after %d files: %v", name, len(extraction.Files), err),
+			Details: map[string]interface{}{"path": name, "files": extraction.Files},
+		}), nil
+	}
+
+	text := fmt.Sprintf("Extracted %d files (%s) and %d directories from %s into %s", len(extraction.Files), formatByteSize(extraction.Bytes), extraction.Directories, name, destination)
+	return jsonResult(text, EXTRACT_URI, extraction), nil
+}
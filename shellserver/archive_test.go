@@ -0,0 +1,225 @@
+package shellserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// testEntry is a file, directory or symlink to put in a test archive
+type testEntry struct {
+	name    string
+	content string
+	link    string // Symlink target, for a symlink
+}
+
+func writeTar(t *testing.T, name string, compress bool, entries []testEntry) {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		switch {
+		case entry.link != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.link, 0
+		case strings.HasSuffix(entry.name, "/"):
+			header.Typeflag, header.Mode = tar.TypeDir, 0o755
+		}
+		writer.WriteHeader(header)
+		writer.Write([]byte(entry.content))
+	}
+	writer.Close()
+
+	data := buffer.Bytes()
+	if compress {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(data)
+		gz.Close()
+		data = compressed.Bytes()
+	}
+	os.WriteFile(name, data, 0o644)
+}
+
+func writeZip(t *testing.T, name string, entries []testEntry) {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.link != "" {
+			header.SetMode(os.ModeSymlink | 0o777)
+		}
+		w, _ := writer.CreateHeader(header)
+		if entry.link != "" {
+			w.Write([]byte(entry.link))
+		} else {
+			w.Write([]byte(entry.content))
+		}
+	}
+	writer.Close()
+	os.WriteFile(name, buffer.Bytes(), 0o644)
+}
+
+func TestArchiveTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"a/b.txt", "/dest/a/b.txt", false},
+		{"./a/../b.txt", "/dest/b.txt", false},
+		{"../evil", "", true},
+		{"a/../../evil", "", true},
+		{"/etc/passwd", "", true},
+		{"..\\evil", "", true},
+		{"C:\\evil", "", true},
+	}
+
+	for _, tt := range tests {
+		got, _, err := archiveTarget("/dest", tt.name)
+		if (err != nil) != tt.wantErr || (err == nil && got != filepath.FromSlash(tt.want)) {
+			t.Errorf("archiveTarget(%q) = %q, %v, want %q (error %v)", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestListArchive(t *testing.T) {
+	dir := t.TempDir()
+	entries := []testEntry{{name: "src/"}, {name: "src/main.go", content: "package main\n"}, {name: "link", link: "src/main.go"}}
+	writeTar(t, filepath.Join(dir, "a.tar"), false, entries)
+	writeTar(t, filepath.Join(dir, "a.tgz"), true, entries)
+	writeZip(t, filepath.Join(dir, "a.zip"), entries)
+	var gz bytes.Buffer
+	writer := gzip.NewWriter(&gz)
+	writer.Write([]byte("hello\n"))
+	writer.Close()
+	os.WriteFile(filepath.Join(dir, "notes.txt.gz"), gz.Bytes(), 0o644)
+	os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("not an archive"), 0o644)
+
+	tests := []struct {
+		file    string
+		format  string
+		want    string // Names and types of the entries
+		total   int64
+		wantErr bool
+	}{
+		{"a.tar", ARCHIVE_TAR, "src/:directory src/main.go:file link:symlink", 13, false},
+		{"a.tgz", ARCHIVE_TAR_GZ, "src/:directory src/main.go:file link:symlink", 13, false},
+		{"a.zip", ARCHIVE_ZIP, "src/:directory src/main.go:file link:symlink", 13, false},
+		{"notes.txt.gz", ARCHIVE_GZIP, "notes.txt:file", 6, false},
+		{"plain.txt", "", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		listing, err := listArchive(filepath.Join(dir, tt.file))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("listArchive(%s) succeeded, want an error", tt.file)
+			}
+			continue
+		}
+		var got []string
+		for _, entry := range listing.Entries {
+			got = append(got, entry.Name+":"+entry.Type)
+		}
+		if err != nil || listing.Format != tt.format || strings.Join(got, " ") != tt.want || listing.TotalSize != tt.total {
+			t.Errorf("listArchive(%s) = %s %v (%d bytes), %v, want %s %s (%d bytes)", tt.file, listing.Format, got, listing.TotalSize, err, tt.format, tt.want, tt.total)
+		}
+	}
+	if listing, _ := listArchive(filepath.Join(dir, "a.zip")); listing.Entries[2].LinkTarget != "src/main.go" {
+		t.Errorf("zip symlink target = %q, want src/main.go", listing.Entries[2].LinkTarget)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	writeTar(t, filepath.Join(dir, "good.tgz"), true, []testEntry{{name: "pkg/"}, {name: "pkg/a.txt", content: "a\n"}, {name: "pkg/sub/b.txt", content: "bb\n"}})
+	writeZip(t, filepath.Join(dir, "slip.zip"), []testEntry{{name: "ok.txt", content: "ok"}, {name: "../../evil.txt", content: "evil"}})
+	writeTar(t, filepath.Join(dir, "abs.tar"), false, []testEntry{{name: "/tmp/evil.txt", content: "evil"}})
+	writeTar(t, filepath.Join(dir, "link.tar"), false, []testEntry{{name: "escape", link: "/etc"}, {name: "escape/passwd", content: "evil"}})
+	writeTar(t, filepath.Join(dir, "secret.tar"), false, []testEntry{{name: ".ssh/authorized_keys", content: "ssh-ed25519 AAAA"}})
+	os.Mkdir(filepath.Join(dir, "existing"), 0o755)
+	os.WriteFile(filepath.Join(dir, "existing", "ok.txt"), []byte("mine"), 0o644)
+	writeZip(t, filepath.Join(dir, "clash.zip"), []testEntry{{name: "ok.txt", content: "theirs"}})
+	os.Mkdir(filepath.Join(dir, "trap"), 0o755)
+	os.Symlink(outside, filepath.Join(dir, "trap", "sub"))
+	writeTar(t, filepath.Join(dir, "trap.tar"), false, []testEntry{{name: "sub/planted.txt", content: "x"}})
+
+	s, err := NewShellServer(
+		WithAllowedCommands("ls"),
+		WithPolicyRules(&PolicyRules{DenyPaths: []string{".ssh"}}),
+		WithProjects([]Project{{Name: "app", Dir: dir}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		archive     string
+		destination string
+		overwrite   bool
+		want        string
+		wantCode    string
+	}{
+		{"good.tgz", "out", false, "Extracted 2 files (5 B) and 1 directories", ""},
+		{"slip.zip", "slip", false, "would be extracted outside the destination", ERROR_ARCHIVE_REJECTED},
+		{"abs.tar", "abs", false, "has an absolute path", ERROR_ARCHIVE_REJECTED},
+		{"link.tar", "link", false, "is a link", ERROR_ARCHIVE_REJECTED},
+		{"secret.tar", "home", false, "under a protected path", ERROR_ARCHIVE_REJECTED},
+		{"clash.zip", "existing", false, "'ok.txt' exists; set overwrite", ERROR_ARCHIVE_REJECTED},
+		{"clash.zip", "existing", true, "Extracted 1 files", ""},
+		{"trap.tar", "trap", false, "outside the destination", ERROR_EXECUTION_FAILED},
+		{"good.tgz", outside, false, "outside the server's and the projects' directories", ERROR_POLICY_DENIED},
+		{"good.tgz", ".ssh", false, "under the protected path '.ssh'", ERROR_POLICY_DENIED},
+		{"missing.tar", "out2", false, "does not exist", ERROR_INVALID_ARGUMENT},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"path": tt.archive, "destination": tt.destination, "overwrite": tt.overwrite, "project": "app"}
+		result, _ := s.handleExtractArchive(context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("extract %s into %s = %q (code %q), want %q (code %q)", tt.archive, tt.destination, text, code, tt.want, tt.wantCode)
+		}
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "out", "pkg", "sub", "b.txt")); string(data) != "bb\n" {
+		t.Errorf("out/pkg/sub/b.txt = %q, want bb", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "existing", "ok.txt")); string(data) != "theirs" {
+		t.Errorf("existing/ok.txt = %q, want it overwritten", data)
+	}
+	for _, leaked := range []string{filepath.Join(dir, "slip", "ok.txt"), filepath.Join(outside, "planted.txt"), filepath.Join(dir, "home", ".ssh")} {
+		if _, err := os.Lstat(leaked); err == nil {
+			t.Errorf("%s was written", leaked)
+		}
+	}
+
+	// Read-only policies refuse extraction
+	readOnly := true
+	s, err = NewShellServer(WithAllowedCommands("ls"), WithPolicyRules(&PolicyRules{ReadOnly: &readOnly}), WithProjects([]Project{{Name: "app", Dir: dir}}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"path": "good.tgz", "destination": "ro", "project": "app"}
+	result, _ := s.handleExtractArchive(context.Background(), request)
+	if toolError := resultError(t, result); toolError == nil || toolError.Code != ERROR_POLICY_DENIED {
+		t.Errorf("read-only extraction = %+v, want %s", toolError, ERROR_POLICY_DENIED)
+	}
+}
@@ -0,0 +1,106 @@
+package shellserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The event file written by the file notifier is hash-linked: every line
+// carries the SHA-256 of the line before it in "prevHash", the first line an
+// empty one. Changing, removing or inserting a line breaks the chain at the
+// line after it, which VerifyAuditLog reports. Removing lines from the end
+// cannot be detected from the file alone.
+
+// chainedEvent is a command event as written to a hash-linked file
+type chainedEvent struct {
+	CommandEvent
+	PrevHash string `json:"prevHash"`
+}
+
+// AuditChainError reports where the hash chain of an event file breaks
+type AuditChainError struct {
+	Line   int
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// lineHash returns the hex SHA-256 of a line without its newline
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLine returns the last non-empty line of a file, or nil if it has none
+func lastLine(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read backwards in chunks until the line before the last one ends
+	const chunkSize = 4096
+	var tail []byte
+	for end := info.Size(); end > 0; {
+		start := max(end-chunkSize, 0)
+		chunk := make([]byte, end-start)
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+		end = start
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+	}
+	trimmed := bytes.TrimRight(tail, "\n")
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+	return trimmed, nil
+}
+
+// VerifyAuditLog checks the hash chain of an event file written by the file
+// notifier, returning the number of intact entries. A broken chain is
+// reported as an *AuditChainError.
+func VerifyAuditLog(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	previous := ""
+	entries := 0
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return entries, err
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) > 0 {
+			var entry struct {
+				PrevHash *string `json:"prevHash"`
+			}
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				return entries, &AuditChainError{Line: number, Reason: "not a JSON event: " + jsonErr.Error()}
+			}
+			switch {
+			case entry.PrevHash == nil:
+				return entries, &AuditChainError{Line: number, Reason: "no prevHash; the entry was not written with hash linking"}
+			case *entry.PrevHash != previous:
+				return entries, &AuditChainError{Line: number, Reason: "prevHash does not match the entry before it; an entry was changed, removed or inserted"}
+			}
+			previous = lineHash(line)
+			entries++
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package shellserver
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	notifier, err := newFileNotifier(path)
+	if err != nil {
+		t.Fatalf("newFileNotifier failed: %v", err)
+	}
+	s := &ShellServer{notifiers: []Notifier{notifier}}
+	s.emitEvent(EVENT_START, CommandExecution{Command: "ls"}, "")
+	s.emitEvent(EVENT_FINISH, CommandExecution{Command: "ls", Output: strings.Repeat("x", 10000)}, "")
+
+	// A reopened file continues the chain
+	notifier, err = newFileNotifier(path)
+	if err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	s.notifiers = []Notifier{notifier}
+	s.emitEvent(EVENT_DENIAL, CommandExecution{Command: "rm -rf /"}, "not allowed")
+	s.emitEvent(EVENT_START, CommandExecution{Command: "pwd"}, "")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading the log failed: %v", err)
+	}
+	if entries, err := VerifyAuditLog(bytes.NewReader(data)); err != nil || entries != 4 {
+		t.Fatalf("VerifyAuditLog = %d, %v, want 4 intact entries", entries, err)
+	}
+
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	tests := []struct {
+		name    string
+		lines   []string
+		line    int
+		entries int
+	}{
+		{"changed", []string{lines[0], strings.Replace(lines[1], `"ls"`, `"id"`, 1), lines[2], lines[3]}, 3, 2},
+		{"removed", []string{lines[0], lines[2], lines[3]}, 2, 1},
+		{"inserted", []string{lines[0], lines[1], lines[1], lines[2], lines[3]}, 3, 2},
+		{"head removed", []string{lines[1], lines[2], lines[3]}, 1, 0},
+		{"unlinked", []string{`{"event":"start"}` + "\n", lines[1]}, 1, 0},
+		{"garbage", []string{lines[0], "not json\n"}, 2, 1},
+	}
+
+	for _, tt := range tests {
+		entries, err := VerifyAuditLog(strings.NewReader(strings.Join(tt.lines, "")))
+		var chainError *AuditChainError
+		if !errors.As(err, &chainError) || chainError.Line != tt.line || entries != tt.entries {
+			t.Errorf("%s: VerifyAuditLog = %d, %v, want a break at line %d after %d entries", tt.name, entries, err, tt.line, tt.entries)
+		}
+	}
+}
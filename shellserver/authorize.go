@@ -0,0 +1,108 @@
+package shellserver
+
+import (
+	"context"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// AuthorizationRequest describes a tool call for an Authorizer
+type AuthorizationRequest struct {
+	Tool      string                 // Name of the tool called, e.g. "execute_command"
+	Arguments map[string]interface{} // Arguments as sent by the client
+	Client    *ClientIdentity        // Client making the call, if known
+
+	// For tools given a command, such as execute_command and
+	// execute_on_targets: the command, and its simple commands as parsed.
+	// Parsed is nil if the command could not be parsed; ParseError says why.
+	Command    string
+	Parsed     []ParsedCommand
+	ParseError error
+}
+
+// Authorizer decides on every tool call before the tool runs, e.g. by asking
+// the user of an embedding application. It returns nil to let the call
+// through; any other error refuses it, and a *DeniedError controls what the
+// agent is told. Calls the authorizer lets through are still subject to the
+// policy.
+type Authorizer interface {
+	Authorize(ctx context.Context, req AuthorizationRequest) error
+}
+
+// AuthorizerFunc adapts a function to an Authorizer
+type AuthorizerFunc func(ctx context.Context, req AuthorizationRequest) error
+
+// Authorize calls f
+func (f AuthorizerFunc) Authorize(ctx context.Context, req AuthorizationRequest) error {
+	return f(ctx, req)
+}
+
+// WithAuthorizer has authorizer decide on every tool call
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(s *ShellServer) error {
+		s.authorizer = authorizer
+		return nil
+	}
+}
+
+// addTool adds a tool to an MCP server, behind the authorizer if one is set
+func (s *ShellServer) addTool(mcpServer *server.MCPServer, tool mcp.Tool, handler server.ToolHandlerFunc) {
+	s.toolNames[tool.Name] = true
+	if !s.tools.allows(tool.Name) {
+		return
+	}
+	mcpServer.AddTool(tool, s.authorized(tool.Name, handler))
+}
+
+// authorized wraps a tool handler so calls over the request limits, calls
+// during maintenance and calls the authorizer refuses fail without reaching
+// it. Commands the authorizer refuses are reported as denials.
+func (s *ShellServer) authorized(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if toolError := s.validateCall(name, request); toolError != nil {
+			return errorResult(*toolError), nil
+		}
+		if maintenance := s.inMaintenance(); maintenance != nil {
+			return errorResult(ToolError{
+				Code:    ERROR_POLICY_DENIED,
+				Message: s.message(MSG_MAINTENANCE, maintenance.message),
+				Details: map[string]interface{}{"rule": "maintenance", "tool": name},
+			}), nil
+		}
+		if s.authorizer == nil {
+			return handler(ctx, request)
+		}
+
+		req := AuthorizationRequest{
+			Tool:      name,
+			Arguments: request.Params.Arguments,
+			Client:    s.clientIdentity(ctx),
+		}
+		if command, ok := request.Params.Arguments["command"].(string); ok {
+			req.Command = command
+			req.Parsed, req.ParseError = ParseCommands(command)
+		}
+
+		err := s.authorizer.Authorize(ctx, req)
+		if err == nil {
+			return handler(ctx, request)
+		}
+		denied, ok := err.(*DeniedError)
+		if !ok {
+			denied = &DeniedError{
+				Reason:  err.Error(),
+				Message: s.message(MSG_NOT_AUTHORIZED, name, err),
+				Details: map[string]interface{}{"rule": "authorizer", "tool": name},
+			}
+		}
+		if req.Command == "" {
+			return errorResult(refusal(denied)), nil
+		}
+		shell, _ := request.Params.Arguments["shell"].(string)
+		if shell == "" {
+			shell = DEFAULT_SHELL
+		}
+		return errorResult(s.deniedToolError(&ExecRequest{Command: req.Command, Shell: shell, Client: req.Client}, denied)), nil
+	}
+}
@@ -0,0 +1,81 @@
+package shellserver_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gamunu/mcp-unix-shell/shellserver"
+	"github.com/gamunu/mcp-unix-shell/shellserver/shelltest"
+)
+
+func TestAuthorizer(t *testing.T) {
+	var calls []shellserver.AuthorizationRequest
+	authorizer := shellserver.AuthorizerFunc(func(ctx context.Context, req shellserver.AuthorizationRequest) error {
+		calls = append(calls, req)
+		switch {
+		case req.Tool == "list_targets":
+			return errors.New("targets are private")
+		case len(req.Parsed) > 0 && req.Parsed[0].Name == "git" && len(req.Parsed[0].Args) > 0 && req.Parsed[0].Args[0] == "push":
+			return &shellserver.DeniedError{Reason: "push declined", Message: "The user declined the push.", Code: shellserver.ERROR_APPROVAL_REQUIRED}
+		}
+		return nil
+	})
+
+	executor := shelltest.NewExecutor().OnPrefix("git", shelltest.Result{Output: "ok\n"})
+	shell, err := shellserver.NewShellServer(
+		shellserver.WithAllowedCommands("git"),
+		shellserver.WithExecutor(executor),
+		shellserver.WithAuthorizer(authorizer),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer shell.Close()
+
+	ctx := context.Background()
+	client, err := shelltest.NewClient(ctx, shell)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		tool     string
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{"execute_command", map[string]interface{}{"command": "git status"}, "ok", ""},
+		{"execute_command", map[string]interface{}{"command": "git push origin main"}, "The user declined the push.", shellserver.ERROR_APPROVAL_REQUIRED},
+		{"list_targets", nil, "The call to 'list_targets' was not authorized: targets are private.", shellserver.ERROR_POLICY_DENIED},
+		{"list_allowed_commands", nil, "git", ""},
+	}
+
+	for _, tt := range tests {
+		result, err := client.CallTool(ctx, tt.tool, tt.args)
+		if err != nil {
+			t.Fatalf("%s failed: %v", tt.tool, err)
+		}
+		if text := shelltest.Text(result); !strings.Contains(text, tt.want) || shelltest.ErrorCode(result) != tt.wantCode {
+			t.Errorf("%s(%v) = %q (code %q), want %q (code %q)", tt.tool, tt.args, text, shelltest.ErrorCode(result), tt.want, tt.wantCode)
+		}
+	}
+
+	if got := executor.Commands(); len(got) != 1 || got[0] != "git status" {
+		t.Errorf("commands run = %v, want only git status", got)
+	}
+	if len(calls) != len(tests) {
+		t.Fatalf("authorizer called %d times, want %d", len(calls), len(tests))
+	}
+	first := calls[0]
+	if first.Tool != "execute_command" || first.Command != "git status" || len(first.Parsed) != 1 || first.Parsed[0].Name != "git" || first.ParseError != nil {
+		t.Errorf("first call = %+v, want execute_command with git status parsed", first)
+	}
+	if first.Client == nil || first.Client.Name != "shelltest" {
+		t.Errorf("first call client = %+v, want shelltest", first.Client)
+	}
+	if calls[2].Command != "" || calls[2].Parsed != nil {
+		t.Errorf("list_targets call = %+v, want no command", calls[2])
+	}
+}
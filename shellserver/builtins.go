@@ -0,0 +1,56 @@
+package shellserver
+
+import (
+	"strings"
+)
+
+// stateBuiltins lists shell builtins that only change the state of the shell
+// they run in. Each execute_command call runs in a new shell, so on their own
+// these have no lasting effect. The value says whether the builtin needs a
+// non-flag argument to change anything; without one it only prints state,
+// e.g. "export" or "ulimit -n".
+var stateBuiltins = map[string]bool{
+	"cd":      false,
+	"pushd":   false,
+	"popd":    false,
+	"source":  false,
+	".":       false,
+	"export":  true,
+	"unset":   true,
+	"alias":   true,
+	"unalias": true,
+	"ulimit":  true,
+	"umask":   true,
+}
+
+// statelessBuiltin returns the first builtin of a command made up only of
+// builtins that change shell state, or "" if the command does anything else.
+// Commands that cannot be parsed are left for the policy to judge.
+func statelessBuiltin(command string) string {
+	commands, err := ParseCommands(command)
+	if err != nil || len(commands) == 0 {
+		return ""
+	}
+
+	for _, parsed := range commands {
+		needsArgument, found := stateBuiltins[parsed.Name]
+		if !found || len(parsed.Redirects) > 0 {
+			return ""
+		}
+		if needsArgument && !hasOperand(parsed.Args) {
+			return ""
+		}
+	}
+	return commands[0].Name
+}
+
+// hasOperand reports whether any argument is not a flag. "alias" and "export"
+// with an assignment such as "ll=ls -l" count as having one.
+func hasOperand(args []string) bool {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package shellserver
+
+import "testing"
+
+func TestStatelessBuiltin(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"cd /tmp", "cd"},
+		{"cd", "cd"},
+		{"export PATH=/opt/bin:$PATH", "export"},
+		{"cd /tmp; export GREETING=hello", "cd"},
+		{"alias ll='ls -l'", "alias"},
+		{"ulimit -n 4096", "ulimit"},
+		{"umask 022", "umask"},
+		{"source ~/.profile", "source"},
+		{". ./env.sh", "."},
+		{"export", ""},
+		{"export -p", ""},
+		{"alias", ""},
+		{"ulimit -n", ""},
+		{"umask", ""},
+		{"cd /tmp && make", ""},
+		{"pushd /tmp > /dev/null", ""},
+		{"echo cd", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if result := statelessBuiltin(test.command); result != test.expected {
+			t.Errorf("statelessBuiltin(%q) = %q, want %q", test.command, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// TRANSPORT_STDIO names the transport Serve uses
+const TRANSPORT_STDIO = "stdio"
+
+// MAX_CLIENT_SESSIONS caps the client identities kept; the transports do not
+// report closed sessions, so one is forgotten to make room for a new one
+const MAX_CLIENT_SESSIONS = 1024
+
+// ClientIdentity is who a command was run for: the MCP client as it named
+// itself when initializing, and what the transport knows about the peer.
+// The name and version are reported by the client and prove nothing.
+type ClientIdentity struct {
+	Name      string `json:"name,omitempty"`      // clientInfo.name from initialize, e.g. "claude-code"
+	Version   string `json:"version,omitempty"`   // clientInfo.version from initialize
+	Transport string `json:"transport,omitempty"` // TRANSPORT_STDIO, or as set by an embedding application
+	Subject   string `json:"subject,omitempty"`   // Subject of the token the client authenticated with, if any
+	PeerPID   int    `json:"peerPid,omitempty"`   // Process on the other end of the transport, if known
+	PeerUID   *int   `json:"peerUid,omitempty"`   // User ID of that process, if known
+}
+
+// transportIdentityKey is the context key for what the transport knows
+type transportIdentityKey struct{}
+
+// ContextWithClientIdentity records what a transport knows about its peer,
+// e.g. the subject of a verified token, for the tool calls made with ctx.
+// Embedding applications call it from their transport's context function;
+// Name and Version are taken from the client's initialize request instead.
+func ContextWithClientIdentity(ctx context.Context, identity ClientIdentity) context.Context {
+	return context.WithValue(ctx, transportIdentityKey{}, identity)
+}
+
+// stdioIdentity describes the peer of the stdio transport: the process that
+// started the server
+func stdioIdentity() ClientIdentity {
+	identity := ClientIdentity{Transport: TRANSPORT_STDIO, PeerPID: os.Getppid()}
+	if uid, ok := processOwner(identity.PeerPID); ok {
+		identity.PeerUID = &uid
+	}
+	return identity
+}
+
+// AddHooks makes an MCP server record each client's name and version as it
+// initializes. NewShellServer adds them to its own server; an embedding
+// application serving the tools with RegisterTools adds them to the hooks
+// it creates its server with.
+func (s *ShellServer) AddHooks(hooks *server.Hooks) {
+	hooks.AddAfterInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest, result *mcp.InitializeResult) {
+		session := server.ClientSessionFromContext(ctx)
+		if session == nil {
+			return
+		}
+		s.clientMutex.Lock()
+		defer s.clientMutex.Unlock()
+		if _, found := s.clients[session.SessionID()]; !found && len(s.clients) >= MAX_CLIENT_SESSIONS {
+			for sessionID := range s.clients {
+				delete(s.clients, sessionID)
+				break
+			}
+		}
+		s.clients[session.SessionID()] = message.Params.ClientInfo
+	})
+}
+
+// clientIdentity returns the identity of the client making a tool call, or
+// nil if nothing is known about it
+func (s *ShellServer) clientIdentity(ctx context.Context) *ClientIdentity {
+	identity, found := ctx.Value(transportIdentityKey{}).(ClientIdentity)
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		s.clientMutex.Lock()
+		info, initialized := s.clients[session.SessionID()]
+		s.clientMutex.Unlock()
+		if initialized {
+			identity.Name, identity.Version = info.Name, info.Version
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &identity
+}
+
+// clientName returns the name of a request's client, or "" if unknown
+func clientName(client *ClientIdentity) string {
+	if client == nil {
+		return ""
+	}
+	return client.Name
+}
+
+// ClientPolicy is a policy for the commands of one MCP client
+type ClientPolicy struct {
+	Name   string       `json:"name"`   // clientInfo.name the client initializes with; case is ignored
+	Policy *PolicyRules `json:"policy"` // Rules added to the server's or the project's policy
+}
+
+// LoadClientPolicies reads a --client-policies file: {"clients": [{"name":
+// "cursor", "policy": {"extends": ["readonly-inspection"], "deny": ["git
+// push"]}}]}. Unknown fields are rejected.
+func LoadClientPolicies(path string) ([]ClientPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Clients []ClientPolicy `json:"clients"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid client policies file: %v", err)
+	}
+	return file.Clients, nil
+}
+
+// WithClientPolicies adds rules to the policy of commands from the named MCP
+// clients, on top of the server's or the project's policy. Client names are
+// reported by the clients themselves, so the rules keep well-behaved clients
+// apart rather than stopping a hostile one.
+func WithClientPolicies(policies []ClientPolicy) Option {
+	return func(s *ShellServer) error {
+		for _, config := range policies {
+			name := strings.ToLower(strings.TrimSpace(config.Name))
+			if name == "" {
+				return fmt.Errorf("client policy without a client name")
+			}
+			if _, found := s.clientRules[name]; found {
+				return fmt.Errorf("client '%s' is configured twice", config.Name)
+			}
+			if config.Policy == nil {
+				return fmt.Errorf("client '%s': no policy", config.Name)
+			}
+			if len(config.Policy.Env) > 0 {
+				return fmt.Errorf("client '%s': env cannot be set per client", config.Name)
+			}
+			if len(config.Policy.Locks) > 0 {
+				return fmt.Errorf("client '%s': locks cannot be set per client", config.Name)
+			}
+			if len(config.Policy.Tripwires) > 0 {
+				return fmt.Errorf("client '%s': tripwires cannot be set per client", config.Name)
+			}
+			rules, err := resolveRules(config.Policy)
+			if err != nil {
+				return fmt.Errorf("client '%s': %v", config.Name, err)
+			}
+			if s.clientRules == nil {
+				s.clientRules = make(map[string]*PolicyRules)
+			}
+			s.clientRules[name] = rules
+		}
+		return nil
+	}
+}
+
+// resolveClientPolicies gives each configured client the server's and each
+// project's policy with the client's rules added; it runs after
+// resolveProjectPolicies
+func (s *ShellServer) resolveClientPolicies() error {
+	if len(s.clientRules) == 0 {
+		return nil
+	}
+	s.clientPolicies = make(map[string]map[string]Policy)
+	for name, rules := range s.clientRules {
+		policies := map[string]Policy{"": s.policy}
+		for projectName, p := range s.projects {
+			policies[projectName] = p.policy
+		}
+		for projectName, policy := range policies {
+			base, ok := policy.(*AllowlistPolicy)
+			if !ok {
+				return fmt.Errorf("client '%s': policy rules extend the allowlist and cannot be combined with a custom policy", name)
+			}
+			extended := base.clone()
+			extended.addRules(rules)
+			policies[projectName] = extended
+		}
+		s.clientPolicies[name] = policies
+	}
+	return nil
+}
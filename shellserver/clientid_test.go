@@ -0,0 +1,140 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// initializeAs sends an initialize request naming the client over session
+func initializeAs(t *testing.T, s *ShellServer, session *testSession, name string) context.Context {
+	t.Helper()
+	ctx := s.server.WithContext(context.Background(), session)
+	message := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"` + mcp.LATEST_PROTOCOL_VERSION +
+		`","clientInfo":{"name":"` + name + `","version":"1.2.3"},"capabilities":{}}}`
+	if response, ok := s.server.HandleMessage(ctx, []byte(message)).(mcp.JSONRPCResponse); !ok {
+		t.Fatalf("initialize failed: %+v", response)
+	}
+	return ctx
+}
+
+func TestClientIdentity(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	if identity := s.clientIdentity(context.Background()); identity != nil {
+		t.Errorf("identity without a session = %+v, want nil", identity)
+	}
+
+	ctx := initializeAs(t, s, &testSession{}, "cursor")
+	ctx = ContextWithClientIdentity(ctx, ClientIdentity{Name: "ignored", Transport: "sse", Subject: "alice"})
+	identity := s.clientIdentity(ctx)
+	if identity == nil || identity.Name != "cursor" || identity.Version != "1.2.3" || identity.Transport != "sse" || identity.Subject != "alice" {
+		t.Fatalf("identity = %+v, want cursor 1.2.3 over sse for alice", identity)
+	}
+
+	// Executions, denied or not, carry the identity
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "echo hi"}
+	s.handleExecuteCommand(ctx, request)
+	executions, _ := s.history.Recent(1)
+	if len(executions) != 1 || executions[0].Client == nil || executions[0].Client.Name != "cursor" || executions[0].Client.Subject != "alice" {
+		t.Errorf("history = %+v, want the execution tagged with the client", executions)
+	}
+
+	events := &recordingNotifier{}
+	s.notifiers = append(s.notifiers, events)
+	request.Params.Arguments = map[string]interface{}{"command": "curl example.com"}
+	s.handleExecuteCommand(ctx, request)
+	if len(events.events) != 1 || events.events[0].Execution.Client == nil || events.events[0].Execution.Client.Name != "cursor" {
+		t.Errorf("denial events = %+v, want one tagged with the client", events.events)
+	}
+}
+
+func TestStdioIdentity(t *testing.T) {
+	identity := stdioIdentity()
+	if identity.Transport != TRANSPORT_STDIO || identity.PeerPID != os.Getppid() {
+		t.Errorf("stdioIdentity() = %+v, want stdio from the parent process", identity)
+	}
+}
+
+func TestClientPolicies(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewShellServer(
+		WithAllowedCommands("ls,git"),
+		WithProjects([]Project{{Name: "api", Dir: dir, Policy: &PolicyRules{Allow: []string{"make"}}}}),
+		WithClientPolicies([]ClientPolicy{
+			{Name: "Cursor", Policy: &PolicyRules{Deny: []string{"git push"}, DenyPaths: []string{".env"}}},
+			{Name: "builder", Policy: &PolicyRules{Allow: []string{"go"}}},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		project string
+		client  string
+		command string
+		want    bool
+	}{
+		{"", "", "git push", true},
+		{"", "cursor", "git push", false},
+		{"", "CURSOR", "cat .env", false},
+		{"", "cursor", "git status", true},
+		{"api", "cursor", "make", true},
+		{"api", "cursor", "git push", false},
+		{"api", "other", "git push", true},
+		{"", "builder", "go build", true},
+		{"", "other", "go build", false},
+		{"unknown", "cursor", "git push", false},
+	}
+
+	for _, tt := range tests {
+		if got := s.policyFor(tt.project, tt.client).Allowed(tt.command); got != tt.want {
+			t.Errorf("policyFor(%q, %q).Allowed(%q) = %v, want %v", tt.project, tt.client, tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestClientPolicyErrors(t *testing.T) {
+	tests := []struct {
+		policies []ClientPolicy
+		want     string
+	}{
+		{[]ClientPolicy{{Name: " ", Policy: &PolicyRules{}}}, "without a client name"},
+		{[]ClientPolicy{{Name: "a", Policy: &PolicyRules{}}, {Name: "A", Policy: &PolicyRules{}}}, "configured twice"},
+		{[]ClientPolicy{{Name: "a"}}, "no policy"},
+		{[]ClientPolicy{{Name: "a", Policy: &PolicyRules{Env: []string{"A=1"}}}}, "env cannot be set"},
+		{[]ClientPolicy{{Name: "a", Policy: &PolicyRules{Extends: []string{"no-such-preset"}}}}, "unknown preset"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewShellServer(WithAllowedCommands("ls"), WithClientPolicies(tt.policies))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("WithClientPolicies(%+v) error = %v, want %q", tt.policies, err, tt.want)
+		}
+	}
+}
+
+func TestLoadClientPolicies(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "clients.json")
+	os.WriteFile(valid, []byte(`{"clients": [{"name": "cursor", "policy": {"deny": ["git push"]}}]}`), 0o644)
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"clients": [{"name": "cursor", "rules": {}}]}`), 0o644)
+
+	policies, err := LoadClientPolicies(valid)
+	if err != nil || len(policies) != 1 || policies[0].Name != "cursor" || policies[0].Policy.Deny[0] != "git push" {
+		t.Errorf("LoadClientPolicies(valid) = %+v, %v", policies, err)
+	}
+	if _, err := LoadClientPolicies(invalid); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("LoadClientPolicies(invalid) error = %v, want unknown field", err)
+	}
+}
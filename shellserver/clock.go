@@ -0,0 +1,70 @@
+package shellserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// DETERMINISTIC_EPOCH is the time the clock of a deterministic server shows
+var DETERMINISTIC_EPOCH = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock tells the time executions, events, pins, snapshots and trash
+// entries are recorded with, and the rate limit counts commands by.
+// Timeouts and backoff always use the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// FixedClock is a Clock that is always at the same time, so every recorded
+// time is equal and every duration zero
+type FixedClock time.Time
+
+// Now returns the clock's time
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// WithClock records times from clock instead of the system clock. Commands
+// are then timed by it too, in place of the executor's own measurement.
+func WithClock(clock Clock) Option {
+	return func(s *ShellServer) error {
+		if clock == nil {
+			return fmt.Errorf("clock is nil")
+		}
+		s.clock = clock
+		return nil
+	}
+}
+
+// WithDeterministicMode makes what the server records repeatable, so tests
+// and embedders can assert on it exactly: times come from a FixedClock at
+// DETERMINISTIC_EPOCH, execution IDs start at 1 whatever the history holds,
+// and IDs that are otherwise random are numbered in order.
+func WithDeterministicMode() Option {
+	return func(s *ShellServer) error {
+		s.clock = FixedClock(DETERMINISTIC_EPOCH)
+		s.deterministic = true
+		return nil
+	}
+}
+
+// now returns the time to record
+func (s *ShellServer) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// randomID returns n random bytes as hex, or in deterministic mode the next
+// number of a sequence, as just as many hex digits
+func (s *ShellServer) randomID(n int) string {
+	if s.deterministic {
+		return fmt.Sprintf("%0*x", 2*n, s.idSequence.Add(1))
+	}
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,58 @@
+package shellserver
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeterministicMode(t *testing.T) {
+	recorder := &recordingNotifier{}
+	history := filepath.Join(t.TempDir(), "history.jsonl")
+	for run := 1; run <= 2; run++ {
+		store, err := newJSONLHistory(history, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewShellServer(WithAllowedCommands("echo,sleep"), WithDeterministicMode(), WithNotifier(recorder), WithHistoryStore(store))
+		if err != nil {
+			t.Fatalf("NewShellServer failed: %v", err)
+		}
+		callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "sleep 0.01"})
+		recent, _ := s.history.Recent(1)
+		if len(recent) != 1 {
+			t.Fatalf("run %d: history holds %d executions, want 1", run, len(recent))
+		}
+		execution := recent[0]
+		if execution.ID != 1 || !execution.StartTime.Equal(DETERMINISTIC_EPOCH) || !execution.EndTime.Equal(DETERMINISTIC_EPOCH) || execution.ExecutionMs != 0 {
+			t.Errorf("run %d: execution = ID %d, %v to %v in %d ms, want ID 1 at %v taking 0 ms",
+				run, execution.ID, execution.StartTime, execution.EndTime, execution.ExecutionMs, DETERMINISTIC_EPOCH)
+		}
+		if id := s.randomID(4); id != "00000001" {
+			t.Errorf("run %d: first random ID = %q, want 00000001", run, id)
+		}
+	}
+	for _, event := range recorder.events {
+		if !event.Timestamp.Equal(DETERMINISTIC_EPOCH) {
+			t.Errorf("%s event at %v, want %v", event.Event, event.Timestamp, DETERMINISTIC_EPOCH)
+		}
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithClock(FixedClock(at)))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "echo hi"})
+	if recent, _ := s.history.Recent(1); len(recent) != 1 || !recent[0].StartTime.Equal(at) {
+		t.Errorf("execution recorded as %+v, want it at %v", recent, at)
+	}
+	if len(s.randomID(4)) != 8 {
+		t.Errorf("random ID is not 8 hex digits")
+	}
+	if _, err := NewShellServer(WithClock(nil)); err == nil {
+		t.Errorf("WithClock(nil) should fail")
+	}
+}
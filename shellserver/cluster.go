@@ -0,0 +1,494 @@
+package shellserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Agents are mcp-unix-shell servers on other machines that run commands for
+// this one, the gateway, so one MCP endpoint can front a lab of machines.
+// An agent connects out to the gateway and long-polls it for commands, so
+// it needs no open port. Commands for an agent pass the gateway's chain,
+// with the agent's policy rules added, and then the agent's own.
+
+// Gateway settings
+const (
+	AGENT_POLL_TIMEOUT   = 25 * time.Second        // How long a poll waits for work
+	AGENT_OFFLINE_AFTER  = 2 * AGENT_POLL_TIMEOUT  // Time without a poll after which an agent is unreachable
+	AGENT_CHECK_INTERVAL = 5 * time.Second         // How often a waiting command checks its agent is still online
+	AGENT_RESULT_GRACE   = 10 * time.Second        // How long the result of a stopped command is waited for
+	MIN_AGENT_TOKEN      = 16                      // Characters an agent token needs at least
+	MAX_AGENT_REQUEST    = MAX_OUTPUT_SIZE + 1<<20 // Bytes of a request from an agent
+)
+
+// AgentTarget is an agent commands can run on
+type AgentTarget struct {
+	Token  string            `json:"token"`            // Secret the agent authenticates with; at least MIN_AGENT_TOKEN characters
+	Labels map[string]string `json:"labels,omitempty"` // Labels for selectors, taking precedence over those the agent reports
+	Policy *PolicyRules      `json:"policy,omitempty"` // Rules added to the policy for commands on the agent
+}
+
+// AgentInfo is what an agent reports with every poll
+type AgentInfo struct {
+	Hostname string            `json:"hostname,omitempty"`
+	OS       string            `json:"os,omitempty"`
+	Arch     string            `json:"arch,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"` // Labels the agent gives itself, with os and arch
+	Running  int               `json:"running"`          // Commands it runs now
+	Free     int               `json:"free"`             // Commands it can start now
+}
+
+// AgentJob is a command the gateway hands an agent
+type AgentJob struct {
+	ID            string          `json:"id"`
+	Command       string          `json:"command"`
+	Shell         string          `json:"shell"`
+	Env           []string        `json:"env,omitempty"`
+	Stdin         []byte          `json:"stdin,omitempty"`
+	Limits        CallLimits      `json:"limits"` // Limits.Timeout is how long the gateway waits
+	IdleTimeoutMs int64           `json:"idleTimeoutMs,omitempty"`
+	StopPattern   string          `json:"stopPattern,omitempty"`
+	Priority      string          `json:"priority,omitempty"`
+	Client        *ClientIdentity `json:"client,omitempty"` // MCP client the command runs for, if known
+}
+
+// agentWork is what a poll returns: commands to start, and IDs of commands
+// the gateway stopped waiting for
+type agentWork struct {
+	Jobs   []AgentJob `json:"jobs,omitempty"`
+	Cancel []string   `json:"cancel,omitempty"`
+}
+
+// agentCall is a command on its way to an agent and back
+type agentCall struct {
+	job    AgentJob
+	result chan CommandExecution // Receives the agent's result
+}
+
+// agentState is a configured agent as the gateway sees it
+type agentState struct {
+	token    string
+	info     AgentInfo
+	lastPoll time.Time             // Zero until the agent connects
+	queued   []*agentCall          // Commands not handed out yet
+	sent     map[string]*agentCall // Commands handed out, by job ID
+	cancel   []string              // Job IDs to tell the agent to stop
+	wake     chan struct{}         // Signaled when there is work for the agent
+}
+
+// online reports whether the agent polled recently
+func (a *agentState) online(now time.Time) bool {
+	return !a.lastPoll.IsZero() && now.Sub(a.lastPoll) < AGENT_OFFLINE_AFTER
+}
+
+// signal wakes the agent's waiting poll
+func (a *agentState) signal() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// agentHub hands commands to agents and collects their results
+type agentHub struct {
+	mutex    sync.Mutex
+	agents   map[string]*agentState
+	lastJob  int64
+	listener net.Listener
+}
+
+// newAgentHub returns a hub for the configured agents
+func newAgentHub(agents map[string]AgentTarget) *agentHub {
+	h := &agentHub{agents: make(map[string]*agentState)}
+	for name, agent := range agents {
+		h.agents[name] = &agentState{token: agent.Token, sent: make(map[string]*agentCall), wake: make(chan struct{}, 1)}
+	}
+	return h
+}
+
+// has reports whether name is an agent; h may be nil
+func (h *agentHub) has(name string) bool {
+	return h != nil && h.agents[name] != nil
+}
+
+// reportedLabels returns the labels an agent reported with its last poll
+func (h *agentHub) reportedLabels(name string) map[string]string {
+	if !h.has(name) {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.agents[name].info.Labels
+}
+
+// online reports whether an agent polled recently
+func (h *agentHub) online(name string) bool {
+	if !h.has(name) {
+		return false
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.agents[name].online(time.Now())
+}
+
+// errAgentOffline is returned for commands on an agent that is not polling
+var errAgentOffline = errors.New("is not connected to the gateway")
+
+// run hands job to the agent and waits for its result until ctx ends. A
+// command stopped after the agent took it is stopped on the agent, whose
+// result is waited for up to AGENT_RESULT_GRACE.
+func (h *agentHub) run(ctx context.Context, name string, job AgentJob) (CommandExecution, error) {
+	h.mutex.Lock()
+	agent, found := h.agents[name]
+	if !found {
+		h.mutex.Unlock()
+		return CommandExecution{}, fmt.Errorf("no agent named '%s'", name)
+	}
+	if !agent.online(time.Now()) {
+		h.mutex.Unlock()
+		return CommandExecution{}, errAgentOffline
+	}
+	h.lastJob++
+	job.ID = strconv.FormatInt(h.lastJob, 10)
+	call := &agentCall{job: job, result: make(chan CommandExecution, 1)}
+	agent.queued = append(agent.queued, call)
+	agent.signal()
+	h.mutex.Unlock()
+
+	check := time.NewTicker(AGENT_CHECK_INTERVAL)
+	defer check.Stop()
+	for waiting := true; waiting; {
+		select {
+		case execution := <-call.result:
+			return execution, nil
+		case <-check.C:
+			h.mutex.Lock()
+			online := agent.online(time.Now())
+			h.mutex.Unlock()
+			if !online {
+				h.abandon(agent, call)
+				return CommandExecution{}, errAgentOffline
+			}
+		case <-ctx.Done():
+			waiting = false
+		}
+	}
+
+	if !h.abandon(agent, call) {
+		// Not taken yet, or the result just arrived
+		select {
+		case execution := <-call.result:
+			return execution, nil
+		default:
+			return CommandExecution{}, ctx.Err()
+		}
+	}
+	grace := time.NewTimer(AGENT_RESULT_GRACE)
+	defer grace.Stop()
+	select {
+	case execution := <-call.result:
+		return execution, nil
+	case <-grace.C:
+		h.mutex.Lock()
+		delete(agent.sent, job.ID)
+		h.mutex.Unlock()
+		return CommandExecution{}, ctx.Err()
+	}
+}
+
+// abandon withdraws a call the agent has not taken, or tells the agent to
+// stop it. It reports whether the agent had taken it.
+func (h *agentHub) abandon(agent *agentState, call *agentCall) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, sent := agent.sent[call.job.ID]; sent {
+		agent.cancel = append(agent.cancel, call.job.ID)
+		agent.signal()
+		return true
+	}
+	for i, queued := range agent.queued {
+		if queued == call {
+			agent.queued = append(agent.queued[:i], agent.queued[i+1:]...)
+			break
+		}
+	}
+	return false
+}
+
+// take returns the agent's pending work, up to free commands; the caller
+// holds the mutex
+func (a *agentState) take(free int) agentWork {
+	work := agentWork{Cancel: a.cancel}
+	a.cancel = nil
+	for len(a.queued) > 0 && len(work.Jobs) < free {
+		call := a.queued[0]
+		a.queued = a.queued[1:]
+		a.sent[call.job.ID] = call
+		work.Jobs = append(work.Jobs, call.job)
+	}
+	return work
+}
+
+// agentStatus describes an agent for list_targets
+func (h *agentHub) agentStatus(name string) string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	agent := h.agents[name]
+	switch {
+	case agent.lastPoll.IsZero():
+		return "never connected"
+	case !agent.online(time.Now()):
+		return "offline since " + agent.lastPoll.Format(time.TimeOnly)
+	}
+	return fmt.Sprintf("online, %s %s/%s, %d running", agent.info.Hostname, agent.info.OS, agent.info.Arch, agent.info.Running)
+}
+
+// WithGateway accepts connections from the agents of the targets file on
+// addr. Agents authenticate with their tokens, which certFile and keyFile
+// keep secret on the wire; without them the gateway only listens on a
+// loopback address, e.g. behind a TLS-terminating proxy.
+func WithGateway(addr string, certFile string, keyFile string) Option {
+	return func(s *ShellServer) error {
+		if addr == "" {
+			return fmt.Errorf("gateway address is empty")
+		}
+		if (certFile == "") != (keyFile == "") {
+			return fmt.Errorf("the gateway needs both a TLS certificate and its key")
+		}
+		if certFile == "" && !loopbackAddress(addr) {
+			return fmt.Errorf("the gateway on %s needs a TLS certificate and key; agent tokens would cross the network in the clear", addr)
+		}
+		s.gateway, s.gatewayCert, s.gatewayKey = addr, certFile, keyFile
+		return nil
+	}
+}
+
+// loopbackAddress reports whether a host:port is on the loopback interface
+func loopbackAddress(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// resolveGateway checks there are targets and the agents of the targets
+// file have a gateway to connect to, and sets up the hub; it runs after
+// resolveClientPolicies
+func (s *ShellServer) resolveGateway() error {
+	var agents map[string]AgentTarget
+	if s.targets != nil {
+		agents = s.targets.Agents
+		if len(s.targets.Hosts) == 0 && len(agents) == 0 && s.discovery == nil {
+			return fmt.Errorf("no hosts or agents configured, and no discovery to find them")
+		}
+	}
+	if len(agents) > 0 && s.gateway == "" {
+		return fmt.Errorf("the targets file has agents, but no gateway for them to connect to; set one with --gateway-listen")
+	}
+	if len(agents) == 0 && s.gateway != "" {
+		return fmt.Errorf("the gateway has no agents to serve; add them to the targets file")
+	}
+	if len(s.targetRules) > 0 || len(s.labelRules) > 0 {
+		if _, ok := s.policy.(*AllowlistPolicy); !ok || s.customPolicy {
+			return fmt.Errorf("target policy rules extend the allowlist and cannot be combined with a custom policy")
+		}
+	}
+	if len(agents) > 0 {
+		s.agents = newAgentHub(agents)
+	}
+	return nil
+}
+
+// listenGateway serves the agents in the background
+func (s *ShellServer) listenGateway() error {
+	listener, err := net.Listen("tcp", s.gateway)
+	if err != nil {
+		return err
+	}
+	if s.gatewayCert != "" {
+		certificate, err := tls.LoadX509KeyPair(s.gatewayCert, s.gatewayKey)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to load the TLS certificate: %v", err)
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12})
+	}
+	s.agents.listener = listener
+	go func() {
+		if err := http.Serve(listener, s.gatewayHandler()); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logger.Printf("Gateway stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// gatewayHandler routes the agents' requests
+func (s *ShellServer) gatewayHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/agents/{name}/poll", s.handleAgentPoll)
+	mux.HandleFunc("POST /v1/agents/{name}/jobs/{id}", s.handleAgentResult)
+	// Agents gzip large results
+	return s.CompressHandler(mux)
+}
+
+// authenticateAgent returns the agent a request comes from, or writes an
+// error and returns nil. Unknown agents and wrong tokens are refused alike.
+func (s *ShellServer) authenticateAgent(w http.ResponseWriter, r *http.Request) *agentState {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	agent, found := s.agents.agents[r.PathValue("name")]
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(agent.token)) != 1 {
+		writeAPIError(w, http.StatusUnauthorized, "unknown agent or wrong bearer token")
+		return nil
+	}
+	return agent
+}
+
+// handleAgentPoll records the agent's state and returns its work, waiting up
+// to AGENT_POLL_TIMEOUT for some
+func (s *ShellServer) handleAgentPoll(w http.ResponseWriter, r *http.Request) {
+	agent := s.authenticateAgent(w, r)
+	if agent == nil {
+		return
+	}
+	var info AgentInfo
+	if err := json.NewDecoder(io.LimitReader(r.Body, MAX_AGENT_REQUEST)).Decode(&info); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+	name := r.PathValue("name")
+	s.agents.mutex.Lock()
+	if !agent.online(time.Now()) {
+		s.logger.Printf("Agent %s connected from %s (%s)", name, r.RemoteAddr, info.Hostname)
+	}
+	agent.info, agent.lastPoll = info, time.Now()
+	s.agents.mutex.Unlock()
+
+	timeout := time.NewTimer(AGENT_POLL_TIMEOUT)
+	defer timeout.Stop()
+	for {
+		s.agents.mutex.Lock()
+		work := agent.take(info.Free)
+		s.agents.mutex.Unlock()
+		if len(work.Jobs) > 0 || len(work.Cancel) > 0 {
+			writeAPIResult(w, http.StatusOK, work)
+			return
+		}
+		select {
+		case <-agent.wake:
+		case <-timeout.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleAgentResult passes the result of a command to the call waiting for
+// it
+func (s *ShellServer) handleAgentResult(w http.ResponseWriter, r *http.Request) {
+	agent := s.authenticateAgent(w, r)
+	if agent == nil {
+		return
+	}
+	var execution CommandExecution
+	if err := json.NewDecoder(io.LimitReader(r.Body, MAX_AGENT_REQUEST)).Decode(&execution); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+	id := r.PathValue("id")
+	s.agents.mutex.Lock()
+	call, found := agent.sent[id]
+	delete(agent.sent, id)
+	s.agents.mutex.Unlock()
+	if !found {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no command %s is waited for", id))
+		return
+	}
+	call.result <- execution
+	writeAPIResult(w, http.StatusOK, map[string]string{"id": id})
+}
+
+// agentExecutor runs commands on an agent
+type agentExecutor struct {
+	hub  *agentHub
+	name string
+	req  *ExecRequest // Idle timeout, stop pattern and priority of the command, which the agent applies
+}
+
+// Execute hands the command to the agent and waits for its result, which
+// arrives in one piece: the output is not streamed.
+func (e agentExecutor) Execute(ctx context.Context, command string, shell string, env []string, stream io.Writer) CommandExecution {
+	start := time.Now()
+	job := AgentJob{
+		Command:       command,
+		Shell:         shell,
+		Env:           env,
+		Limits:        callLimitsFromContext(ctx),
+		IdleTimeoutMs: e.req.IdleTimeout.Milliseconds(),
+		Priority:      e.req.Priority,
+		Client:        e.req.Client,
+	}
+	if e.req.StopPattern != nil {
+		job.StopPattern = e.req.StopPattern.String()
+	}
+	if stdin := StdinFromContext(ctx); stdin != nil {
+		job.Stdin, _ = io.ReadAll(stdin)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		job.Limits.Timeout = time.Until(deadline)
+	}
+
+	execution, err := e.hub.run(ctx, e.name, job)
+	if err == nil {
+		// The gateway numbers and describes its executions itself
+		execution.ID, execution.Context, execution.Session = 0, nil, ""
+		if ctx.Err() == context.Canceled {
+			// Stopped here; the gateway reports that itself
+			execution.Output = strings.TrimSuffix(execution.Output, STOPPED_BY_ADMIN) + "\n\nError: " + context.Canceled.Error()
+		}
+		if stream != nil {
+			io.WriteString(stream, execution.Output)
+		}
+		return execution
+	}
+
+	execution = CommandExecution{Command: command, Shell: shell, StartTime: start, EndTime: time.Now()}
+	execution.ExecutionMs = execution.EndTime.Sub(start).Milliseconds()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		execution.Output = fmt.Sprintf("Error: Command execution timed out after %s.", job.Limits.Timeout.Round(time.Millisecond))
+		execution.ExitCode, execution.TimedOut, execution.ErrorCode = 124, true, ERROR_TIMEOUT
+	case errors.Is(err, context.Canceled):
+		execution.Output = "\n\nError: " + context.Canceled.Error()
+		execution.ExitCode = -1
+	default:
+		execution.Output = fmt.Sprintf("agent: %s %v", e.name, err)
+		execution.ExitCode, execution.ErrorCode = -1, ERROR_TARGET_UNREACHABLE
+	}
+	return execution
+}
+
+// runOnAgent runs a request on its agent. The agent applies the idle
+// timeout and stop pattern, so the gateway waits for the whole command.
+func (s *ShellServer) runOnAgent(req *ExecRequest) CommandExecution {
+	local := *req
+	local.IdleTimeout, local.StopPattern = 0, nil
+	return s.executeWith(agentExecutor{hub: s.agents, name: req.Target, req: req}, &local, req.Command)
+}
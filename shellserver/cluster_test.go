@@ -0,0 +1,210 @@
+package shellserver
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAgentToken = "0123456789abcdef"
+
+// testGateway starts a gateway on a loopback port for agents lab1, whose
+// policy adds sleep, and lab2
+func testGateway(t *testing.T) *ShellServer {
+	t.Helper()
+	targets := &Targets{Agents: map[string]AgentTarget{
+		"lab1": {Token: testAgentToken, Policy: &PolicyRules{Allow: []string{"sleep", "printf"}}},
+		"lab2": {Token: testAgentToken + "2"},
+	}}
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithTargets(targets), WithGateway("127.0.0.1:0", "", ""))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// startAgent connects a server allowing commands to the gateway as lab1,
+// and waits until the gateway sees it
+func startAgent(t *testing.T, gateway *ShellServer, commands string) {
+	t.Helper()
+	agent, err := NewShellServer(WithAllowedCommands(commands),
+		WithAgent(AgentConfig{Gateway: "http://" + gateway.agents.listener.Addr().String(), Name: "lab1", Token: testAgentToken}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		agent.ServeAgent(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		agent.Close()
+	})
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if strings.HasPrefix(gateway.agents.agentStatus("lab1"), "online") {
+			return
+		}
+	}
+	t.Fatalf("agent did not connect: %s", gateway.agents.agentStatus("lab1"))
+}
+
+func TestWithGateway(t *testing.T) {
+	tests := []struct {
+		addr string
+		cert string
+		key  string
+		err  string
+	}{
+		{"", "", "", "address is empty"},
+		{"127.0.0.1:9443", "", "", ""},
+		{"localhost:9443", "", "", ""},
+		{"0.0.0.0:9443", "", "", "needs a TLS certificate"},
+		{":9443", "cert.pem", "", "both"},
+		{":9443", "cert.pem", "key.pem", ""},
+	}
+	for _, tt := range tests {
+		err := WithGateway(tt.addr, tt.cert, tt.key)(&ShellServer{})
+		if (tt.err == "" && err != nil) || (tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err))) {
+			t.Errorf("WithGateway(%q, %q, %q) = %v, want %q", tt.addr, tt.cert, tt.key, err, tt.err)
+		}
+	}
+
+	agents := &Targets{Agents: map[string]AgentTarget{"lab1": {Token: testAgentToken}}}
+	if _, err := NewShellServer(WithTargets(agents)); err == nil || !strings.Contains(err.Error(), "--gateway-listen") {
+		t.Errorf("agents without a gateway: %v", err)
+	}
+	if _, err := NewShellServer(WithGateway("127.0.0.1:0", "", "")); err == nil || !strings.Contains(err.Error(), "no agents") {
+		t.Errorf("a gateway without agents: %v", err)
+	}
+	withRules := &Targets{Agents: map[string]AgentTarget{"lab1": {Token: testAgentToken, Policy: &PolicyRules{Allow: []string{"ls"}}}}}
+	if _, err := NewShellServer(WithPolicy(NewAllowlistPolicy("ls")), WithTargets(withRules), WithGateway("127.0.0.1:0", "", "")); err == nil {
+		t.Errorf("target rules were combined with a custom policy")
+	}
+}
+
+func TestAgentConfigCheck(t *testing.T) {
+	tests := []struct {
+		config AgentConfig
+		err    string
+	}{
+		{AgentConfig{Gateway: "https://gateway:9443", Name: "lab1", Token: testAgentToken}, ""},
+		{AgentConfig{Gateway: "http://127.0.0.1:9443", Name: "lab1", Token: testAgentToken}, ""},
+		{AgentConfig{Gateway: "http://gateway:9443", Name: "lab1", Token: testAgentToken}, "must use https"},
+		{AgentConfig{Gateway: "gateway:9443", Name: "lab1", Token: testAgentToken}, "https:// URL"},
+		{AgentConfig{Gateway: "https://gateway:9443", Name: "lab 1", Token: testAgentToken}, "invalid agent name"},
+		{AgentConfig{Gateway: "https://gateway:9443", Name: "lab1", Token: "short"}, "at least"},
+	}
+	for _, tt := range tests {
+		_, err := tt.config.check()
+		if (tt.err == "" && err != nil) || (tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err))) {
+			t.Errorf("check(%+v) = %v, want %q", tt.config, err, tt.err)
+		}
+	}
+}
+
+func TestGatewayRunsOnAgent(t *testing.T) {
+	gateway := testGateway(t)
+	startAgent(t, gateway, "echo,sleep")
+	execute := gateway.authorized("execute_command", gateway.handleExecuteCommand)
+
+	tests := []struct {
+		command string
+		target  string
+		want    string
+		isError bool
+	}{
+		{"echo hello from the agent", "lab1", "hello from the agent", false},
+		{"cat /etc/passwd", "lab1", "not in the allowed list", true},  // Refused by the gateway
+		{"printf hi", "lab1", "not in the allowed list", false},       // Allowed on lab1 by the gateway, refused by the agent
+		{"echo hi", "lab2", "is not connected to the gateway", false}, // Never connected
+	}
+	for _, tt := range tests {
+		text, isError := callTool(t, execute, map[string]interface{}{"command": tt.command, "target": tt.target})
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s on %s = %q (error %v), want %q", tt.command, tt.target, text, isError, tt.want)
+		}
+	}
+
+	execution, err := gateway.exec(context.Background(), &ExecRequest{Command: "echo hi", Shell: "bash", Target: "lab2"})
+	if err != nil || execution.ErrorCode != ERROR_TARGET_UNREACHABLE {
+		t.Errorf("command on an offline agent = %+v, %v, want %s", execution, err, ERROR_TARGET_UNREACHABLE)
+	}
+	// Large results are gzipped on their way to the gateway
+	long := strings.Repeat("log line ", 1000)
+	if text, _ := callTool(t, execute, map[string]interface{}{"command": "echo " + long, "target": "lab1"}); !strings.Contains(text, long) {
+		t.Errorf("large output on lab1 = %d bytes, want the whole output", len(text))
+	}
+
+	// lab1 reports its os, so selectors match it
+	selector := "os=" + runtime.GOOS
+	if text, isError := callTool(t, execute, map[string]interface{}{"command": "echo picked", "target_selector": selector}); isError || !strings.Contains(text, "picked") {
+		t.Errorf("echo on %s = %q (error %v), want it run on lab1", selector, text, isError)
+	}
+	text, _ := callTool(t, gateway.handleListTargets, nil)
+	for _, want := range []string{"Agents (2)", "- lab1: online", "[arch=" + runtime.GOARCH + ",os=" + runtime.GOOS + "]", "also allows sleep, printf", "- lab2: never connected"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("list_targets = %q, want %q", text, want)
+		}
+	}
+}
+
+func TestGatewayStopsAgentCommand(t *testing.T) {
+	gateway := testGateway(t)
+	startAgent(t, gateway, "sleep")
+
+	done := make(chan CommandExecution)
+	go func() {
+		execution, _ := gateway.exec(context.Background(), &ExecRequest{Command: "sleep 30", Shell: "bash", Target: "lab1"})
+		done <- execution
+	}()
+	// Wait until the agent took the command
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		gateway.agents.mutex.Lock()
+		sent := len(gateway.agents.agents["lab1"].sent)
+		gateway.agents.mutex.Unlock()
+		if sent == 1 {
+			break
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	gateway.runningMutex.Lock()
+	var ids []int64
+	for id := range gateway.running {
+		ids = append(ids, id)
+	}
+	gateway.runningMutex.Unlock()
+	if len(ids) != 1 {
+		t.Fatalf("gateway runs %d commands, want 1", len(ids))
+	}
+	gateway.stopRunning(ids[0])
+	execution := <-done
+	if time.Since(start) > 10*time.Second || strings.Count(execution.Output, "stopped by an administrator") != 1 {
+		t.Errorf("stopped command returned %q after %s", execution.Output, time.Since(start))
+	}
+}
+
+func TestAgentRefused(t *testing.T) {
+	gateway := testGateway(t)
+	agent, err := NewShellServer(WithAgent(AgentConfig{Gateway: "http://" + gateway.agents.listener.Addr().String(), Name: "lab1", Token: testAgentToken + "x"}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer agent.Close()
+	if err := agent.ServeAgent(context.Background()); err != errAgentRefused {
+		t.Errorf("ServeAgent with a wrong token = %v, want %v", err, errAgentRefused)
+	}
+
+	server, _ := NewShellServer()
+	defer server.Close()
+	if err := server.ServeAgent(context.Background()); err == nil {
+		t.Errorf("ServeAgent ran without WithAgent")
+	}
+}
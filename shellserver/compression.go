@@ -0,0 +1,266 @@
+package shellserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Results of commands that print megabytes of logs are slow to fetch over a
+// network. The server's HTTP endpoints, and an embedding application's MCP
+// transport wrapped with CompressHandler, gzip what they send to clients
+// that accept it, and write it in chunks that are flushed one by one, so
+// proxies pass on a large SSE event as it is written. Only gzip is offered:
+// zstd would need a dependency beyond the standard library.
+
+// Compression settings
+const (
+	ENCODING_GZIP             = "gzip"
+	DEFAULT_COMPRESSION_LEVEL = gzip.BestSpeed // Latency matters more than the last few percent
+	COMPRESS_MIN_SIZE         = 1024           // Bytes below which a response is sent as is
+	MIN_CHUNK_SIZE            = 1024
+	MAX_CHUNK_SIZE            = 16 * 1024 * 1024
+)
+
+// WithCompression sets the gzip level of responses to clients that accept
+// it, from gzip.BestSpeed to gzip.BestCompression; gzip.NoCompression
+// turns compression off. Responses are compressed once they reach minSize
+// bytes; event streams always are.
+func WithCompression(level int, minSize int) Option {
+	return func(s *ShellServer) error {
+		if level < gzip.NoCompression || level > gzip.BestCompression {
+			return fmt.Errorf("compression level must be between %d and %d, got %d", gzip.NoCompression, gzip.BestCompression, level)
+		}
+		if minSize < 0 {
+			return fmt.Errorf("compression minimum size must not be negative, got %d", minSize)
+		}
+		s.compressionLevel, s.compressMinSize = level, minSize
+		return nil
+	}
+}
+
+// WithChunkSize sets how many bytes of a response are written, and for HTTP
+// flushed, at a time, on stdio and network transports alike
+func WithChunkSize(size int) Option {
+	return func(s *ShellServer) error {
+		if size < MIN_CHUNK_SIZE || size > MAX_CHUNK_SIZE {
+			return fmt.Errorf("chunk size must be between %d and %d bytes, got %d", MIN_CHUNK_SIZE, MAX_CHUNK_SIZE, size)
+		}
+		s.chunkSize = size
+		return nil
+	}
+}
+
+// CompressHandler wraps the HTTP handler of a network transport, such as
+// mcp-go's SSE server, so tool results and resource reads are gzipped for
+// the clients whose requests accept it, and written in chunks. Requests
+// with a gzipped body are decompressed too.
+func (s *ShellServer) CompressHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") == ENCODING_GZIP {
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			defer body.Close()
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressWriter{
+			ResponseWriter: w,
+			level:          s.compressionLevel,
+			minSize:        s.compressMinSize,
+			chunkSize:      s.chunkSize,
+			accepted:       s.compressionLevel != gzip.NoCompression && acceptsGzip(r.Header.Get("Accept-Encoding")),
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header accepts gzip,
+// either by name or with *, and does not refuse it with q=0
+func acceptsGzip(header string) bool {
+	accepted := false
+	for _, entry := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != ENCODING_GZIP && coding != "*" {
+			continue
+		}
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			var err error
+			if quality, err = strconv.ParseFloat(value, 64); err != nil {
+				quality = 0
+			}
+		}
+		if coding == ENCODING_GZIP {
+			// An explicit gzip entry overrides *
+			return quality > 0
+		}
+		accepted = quality > 0
+	}
+	return accepted
+}
+
+// compressWriter gzips a response once it is known to be an event stream
+// or to reach minSize bytes. Until then the response is held back, status
+// included.
+type compressWriter struct {
+	http.ResponseWriter
+	level     int
+	minSize   int
+	chunkSize int
+	accepted  bool // The client accepts gzip
+
+	status   int          // Status held back; zero if not set yet
+	buffered []byte       // Body held back
+	decided  bool         // Whether the response is compressed has been decided
+	gzip     *gzip.Writer // Nil if the response is not compressed
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 && !c.decided {
+		c.status = status
+		// Responses without a body, and informational ones, go as they are
+		if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+			c.decide(false)
+		}
+		return
+	}
+	if !c.decided {
+		return
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.decided {
+		switch {
+		case !c.compressible():
+			c.decide(false)
+		case c.eventStream():
+			c.decide(true)
+		default:
+			c.buffered = append(c.buffered, p...)
+			if len(c.buffered) < c.minSize {
+				return len(p), nil
+			}
+			c.decide(true)
+			return len(p), c.writeChunks(nil)
+		}
+	}
+	return len(p), c.writeChunks(p)
+}
+
+// compressible reports whether the client accepts gzip and the handler did
+// not encode the response itself
+func (c *compressWriter) compressible() bool {
+	return c.accepted && c.Header().Get("Content-Encoding") == ""
+}
+
+// eventStream reports whether the response is an SSE stream, whose size is
+// not known until it ends
+func (c *compressWriter) eventStream() bool {
+	return strings.HasPrefix(c.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// decide sends the status and headers, compressed or not, and then what
+// was held back
+func (c *compressWriter) decide(compress bool) {
+	c.decided = true
+	header := c.Header()
+	if compress {
+		header.Set("Content-Encoding", ENCODING_GZIP)
+		header.Del("Content-Length")
+		c.gzip, _ = gzip.NewWriterLevel(c.ResponseWriter, c.level)
+	}
+	if c.status != 0 {
+		c.ResponseWriter.WriteHeader(c.status)
+	}
+}
+
+// writeChunks writes what was held back and p a chunk at a time, flushing
+// the connection after each chunk
+func (c *compressWriter) writeChunks(p []byte) error {
+	if len(c.buffered) > 0 {
+		buffered := c.buffered
+		c.buffered = nil
+		if err := c.writeChunks(buffered); err != nil {
+			return err
+		}
+	}
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.chunkSize)]
+		p = p[len(chunk):]
+		var err error
+		if c.gzip != nil {
+			_, err = c.gzip.Write(chunk)
+		} else {
+			_, err = c.ResponseWriter.Write(chunk)
+		}
+		if err != nil {
+			return err
+		}
+		if len(p) > 0 {
+			c.Flush()
+		}
+	}
+	return nil
+}
+
+// Flush sends what was written so far, compressed if the response is
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		// A flushed response is streamed, so it cannot wait for minSize
+		c.decide(c.compressible() && (len(c.buffered) > 0 || c.eventStream()))
+		c.writeChunks(nil)
+	}
+	if c.gzip != nil {
+		c.gzip.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// close ends the response: a small one is sent as it is
+func (c *compressWriter) close() {
+	if !c.decided {
+		c.decide(false)
+		c.writeChunks(nil)
+	}
+	if c.gzip != nil {
+		c.gzip.Close()
+	}
+}
+
+// gzipBody compresses a request body for a server whose handler is
+// wrapped with CompressHandler
+func gzipBody(data []byte, level int) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&compressed, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
@@ -0,0 +1,177 @@
+package shellserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"zstd, br", false},
+		{"*", true},
+		{"*, gzip;q=0", false},
+		{"GZIP", true},
+		{"gzip;q=0.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		option  Option
+		wantErr bool
+	}{
+		{"best speed", WithCompression(gzip.BestSpeed, COMPRESS_MIN_SIZE), false},
+		{"off", WithCompression(gzip.NoCompression, 0), false},
+		{"level too high", WithCompression(10, 0), true},
+		{"negative minimum", WithCompression(gzip.BestSpeed, -1), true},
+		{"chunk size", WithChunkSize(4096), false},
+		{"chunk too small", WithChunkSize(100), true},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewShellServer(tt.option); (err != nil) != tt.wantErr {
+			t.Errorf("%s: NewShellServer error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCompressHandler(t *testing.T) {
+	s, err := NewShellServer(WithChunkSize(MIN_CHUNK_SIZE))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	large := `{"output": "` + strings.Repeat("building target ", 1000) + `"}`
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		status      int
+		body        string
+		compressed  bool
+	}{
+		{"large result", "gzip", "application/json", http.StatusOK, large, true},
+		{"small result", "gzip", "application/json", http.StatusOK, `{"ok": true}`, false},
+		{"not accepted", "", "application/json", http.StatusOK, large, false},
+		{"refused", "zstd, gzip;q=0", "application/json", http.StatusOK, large, false},
+		{"no body", "gzip", "", http.StatusAccepted, "", false},
+		{"small event", "gzip", "text/event-stream", http.StatusOK, "event: message\ndata: {}\n\n", true},
+	}
+
+	for _, tt := range tests {
+		handler := s.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				w.Header().Set("Content-Type", tt.contentType)
+			}
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		}))
+		request := httptest.NewRequest("POST", "/message", nil)
+		request.Header.Set("Accept-Encoding", tt.accept)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		body := recorder.Body.Bytes()
+		if compressed := recorder.Header().Get("Content-Encoding") == ENCODING_GZIP; compressed != tt.compressed {
+			t.Errorf("%s: compressed = %v, want %v", tt.name, compressed, tt.compressed)
+		} else if compressed {
+			reader, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Errorf("%s: invalid gzip: %v", tt.name, err)
+				continue
+			}
+			if body, err = io.ReadAll(reader); err != nil {
+				t.Errorf("%s: invalid gzip: %v", tt.name, err)
+			}
+			if recorder.Body.Len() >= len(tt.body) && len(tt.body) > COMPRESS_MIN_SIZE {
+				t.Errorf("%s: %d bytes compressed to %d", tt.name, len(tt.body), recorder.Body.Len())
+			}
+		}
+		if recorder.Code != tt.status || string(body) != tt.body {
+			t.Errorf("%s: got %d with %d bytes, want %d with %d bytes", tt.name, recorder.Code, len(body), tt.status, len(tt.body))
+		}
+	}
+}
+
+func TestCompressHandlerStreams(t *testing.T) {
+	s, _ := NewShellServer()
+	release := make(chan struct{})
+	server := httptest.NewServer(s.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: message\ndata: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "event: message\ndata: second\n\n")
+	})))
+	defer server.Close()
+	defer close(release)
+
+	// The first event arrives while the stream is still open
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err := (&http.Client{Transport: &http.Transport{DisableCompression: true}}).Do(request)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Encoding") != ENCODING_GZIP {
+		t.Fatalf("Content-Encoding = %q, want gzip", response.Header.Get("Content-Encoding"))
+	}
+	reader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip stream: %v", err)
+	}
+	lines := bufio.NewReader(reader)
+	for _, want := range []string{"event: message\n", "data: first\n"} {
+		if line, err := lines.ReadString('\n'); line != want {
+			t.Errorf("stream line = %q (%v), want %q", line, err, want)
+		}
+	}
+}
+
+func TestCompressHandlerRequestBody(t *testing.T) {
+	s, _ := NewShellServer()
+	var got string
+	handler := s.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+	}))
+
+	compressed, err := gzipBody([]byte(`{"output": "hello"}`), gzip.BestSpeed)
+	if err != nil {
+		t.Fatalf("gzipBody failed: %v", err)
+	}
+	request := httptest.NewRequest("POST", "/v1/agents/lab1/jobs/1", bytes.NewReader(compressed))
+	request.Header.Set("Content-Encoding", ENCODING_GZIP)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if got != `{"output": "hello"}` {
+		t.Errorf("handler read %q, want the decompressed body", got)
+	}
+
+	request = httptest.NewRequest("POST", "/v1/agents/lab1/jobs/1", strings.NewReader("not gzip"))
+	request.Header.Set("Content-Encoding", ENCODING_GZIP)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid gzip body: status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
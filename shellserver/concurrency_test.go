@@ -0,0 +1,66 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// TestConcurrentToolCalls runs tool calls in parallel, as HTTP transports
+// do, while the policy is edited; run with -race
+func TestConcurrentToolCalls(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithTenantIsolation(nil))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	calls := []struct {
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]interface{}
+	}{
+		{s.handleExecuteCommand, map[string]interface{}{"command": "echo hi"}},
+		{s.handleListRecentCommands, map[string]interface{}{}},
+		{s.handleListAllowedCommands, map[string]interface{}{}},
+		{s.handleListPolicyRules, map[string]interface{}{}},
+		{s.handleAllowCommand, map[string]interface{}{"command": "printf"}},
+		{s.handleDenyCommand, map[string]interface{}{"rule": "echo secret"}},
+		{s.handleStartSession, map[string]interface{}{}},
+		{s.handleListSessions, map[string]interface{}{}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for _, call := range calls {
+			wg.Add(1)
+			go func(tenant int) {
+				defer wg.Done()
+				ctx := ContextWithClientIdentity(context.Background(), ClientIdentity{Subject: fmt.Sprint("tenant-", tenant)})
+				var request mcp.CallToolRequest
+				request.Params.Arguments = call.args
+				if _, err := call.handler(ctx, request); err != nil {
+					t.Errorf("handler returned error: %v", err)
+				}
+			}(i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleReadHistory(context.Background(), mcp.ReadResourceRequest{})
+			s.resourceUpdated(HISTORY_RESOURCE_URI)
+		}()
+	}
+	wg.Wait()
+
+	recent, count, err := historySnapshot(s.history, 0)
+	if err != nil || count != 4 {
+		t.Fatalf("history holds %d executions (%v), want 4", count, err)
+	}
+	for i, execution := range recent {
+		if want := int64(4 - i); execution.ID != want {
+			t.Errorf("execution %d of the history has ID %d, want %d", i, execution.ID, want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// WithContainerDevices lets sandboxes be given the host devices matching
+// patterns, e.g. "/dev/kvm" or "/dev/nvidia*", and all GPUs if gpus is set.
+// Without it sandboxes get no devices.
+func WithContainerDevices(patterns []string, gpus bool) Option {
+	return func(s *ShellServer) error {
+		for _, pattern := range patterns {
+			if !strings.HasPrefix(pattern, "/dev/") || filepath.Clean(pattern) != pattern {
+				return fmt.Errorf("device pattern must be a clean path under /dev, got '%s'", pattern)
+			}
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid device pattern '%s': %v", pattern, err)
+			}
+		}
+		s.containerDevices, s.containerGPUs = patterns, gpus
+		return nil
+	}
+}
+
+// deviceAllowed reports whether path matches an allowed device pattern
+func (s *ShellServer) deviceAllowed(path string) bool {
+	for _, pattern := range s.containerDevices {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// containerDevice checks a device requested for a sandbox. Both the path
+// and, if it is a symlink, its target must match an allowed pattern, and
+// the target must be a device.
+func (s *ShellServer) containerDevice(device string) (string, *ToolError) {
+	denied := func() (string, *ToolError) {
+		allowed := "none; allow them with --container-devices"
+		if len(s.containerDevices) > 0 {
+			allowed = strings.Join(s.containerDevices, ", ")
+		}
+		return "", &ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_DEVICE_NOT_ALLOWED, device, allowed),
+			Details: map[string]interface{}{"rule": "device", "device": device, "devices": s.containerDevices},
+		}
+	}
+	if filepath.Clean(device) != device || !s.deviceAllowed(device) {
+		return denied()
+	}
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return "", fileError(device, err)
+	}
+	if !s.deviceAllowed(resolved) {
+		return denied()
+	}
+	if info, err := os.Stat(resolved); err != nil || info.Mode()&os.ModeDevice == 0 {
+		return "", &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: '%s' is not a device.", device),
+			Details: map[string]interface{}{"argument": "devices", "device": device},
+		}
+	}
+	return resolved, nil
+}
+
+// sandboxDevices reads the devices and gpus arguments of create_sandbox
+func (s *ShellServer) sandboxDevices(request mcp.CallToolRequest) ([]string, bool, *ToolError) {
+	items, ok := request.Params.Arguments["devices"].([]interface{})
+	if !ok && request.Params.Arguments["devices"] != nil {
+		return nil, false, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'devices' must be an array of strings",
+			Details: map[string]interface{}{"argument": "devices"},
+		}
+	}
+	var devices []string
+	for _, item := range items {
+		device, _ := item.(string)
+		resolved, toolError := s.containerDevice(device)
+		if toolError != nil {
+			return nil, false, toolError
+		}
+		devices = append(devices, resolved)
+	}
+
+	gpus, _ := request.Params.Arguments["gpus"].(bool)
+	if gpus && !s.containerGPUs {
+		return nil, false, &ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_GPUS_NOT_ALLOWED),
+			Details: map[string]interface{}{"rule": "gpus"},
+		}
+	}
+	return devices, gpus, nil
+}
+
+// deviceArgs returns the runtime's options giving a container the devices
+// and, if gpus is set, all GPUs. Podman names GPUs through CDI.
+func (s *ShellServer) deviceArgs(devices []string, gpus bool) []string {
+	var args []string
+	for _, device := range devices {
+		args = append(args, "--device", device)
+	}
+	if gpus {
+		if filepath.Base(s.containerRuntime) == "podman" {
+			args = append(args, "--device", "nvidia.com/gpu=all")
+		} else {
+			args = append(args, "--gpus", "all")
+		}
+	}
+	return args
+}
@@ -0,0 +1,90 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestWithContainerDevices(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		wantErr  bool
+	}{
+		{[]string{"/dev/kvm", "/dev/nvidia*", "/dev/dri/renderD*"}, false},
+		{[]string{"/etc/passwd"}, true},
+		{[]string{"/dev/../etc/shadow"}, true},
+		{[]string{"/dev/[nvidia"}, true},
+	}
+
+	for _, tt := range tests {
+		_, err := NewShellServer(WithContainerDevices(tt.patterns, false))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("WithContainerDevices(%v) error = %v, wantErr %v", tt.patterns, err, tt.wantErr)
+		}
+	}
+}
+
+func TestContainerDevice(t *testing.T) {
+	s, err := NewShellServer(WithContainerDevices([]string{"/dev/null", "/dev/shm"}, false))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		device   string
+		want     string
+		wantCode string
+	}{
+		{"/dev/null", "/dev/null", ""},
+		{"/dev/zero", "", ERROR_POLICY_DENIED},
+		{"/dev/./null", "", ERROR_POLICY_DENIED},
+		{"/dev/shm", "", ERROR_INVALID_ARGUMENT},
+	}
+
+	for _, tt := range tests {
+		got, toolError := s.containerDevice(tt.device)
+		code := ""
+		if toolError != nil {
+			code = toolError.Code
+		}
+		if got != tt.want || code != tt.wantCode {
+			t.Errorf("containerDevice(%q) = %q, %v, want %q (code %q)", tt.device, got, toolError, tt.want, tt.wantCode)
+		}
+	}
+}
+
+func TestSandboxDevices(t *testing.T) {
+	s, state := fakeDockerServer(t, WithAllowedCommands("ls"), WithContainerDevices([]string{"/dev/null"}, true))
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"image": "alpine:3.20", "devices": []interface{}{"/dev/null"}, "gpus": true}
+	result, _ := s.handleCreateSandbox(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "Devices: /dev/null.\nAll GPUs are available.") {
+		t.Fatalf("create_sandbox = %q, want the device and GPUs listed", text)
+	}
+	matches, _ := filepath.Glob(filepath.Join(state, "*.args"))
+	if len(matches) != 1 {
+		t.Fatalf("containers created = %v, want one", matches)
+	}
+	if args, _ := os.ReadFile(matches[0]); !strings.Contains(string(args), "--network none --device /dev/null --gpus all ") {
+		t.Errorf("docker run arguments = %q, want the device and GPUs passed", args)
+	}
+
+	// Without --container-gpus, GPUs are refused
+	s, _ = fakeDockerServer(t, WithAllowedCommands("ls"))
+	request.Params.Arguments = map[string]interface{}{"image": "alpine:3.20", "gpus": true}
+	result, _ = s.handleCreateSandbox(context.Background(), request)
+	if toolError := resultError(t, result); toolError == nil || toolError.Code != ERROR_POLICY_DENIED {
+		t.Errorf("create_sandbox with gpus = %v, want a policy denial", toolError)
+	}
+
+	s.containerRuntime = "/usr/bin/podman"
+	if got, want := s.deviceArgs([]string{"/dev/kvm"}, true), []string{"--device", "/dev/kvm", "--device", "nvidia.com/gpu=all"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deviceArgs() with podman = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Defaults of the hardening of sandbox containers
+const (
+	DEFAULT_CONTAINER_PIDS = 256                              // Processes a sandbox may run without an nproc limit
+	CONTAINER_TMPFS        = "/tmp:rw,nosuid,nodev,size=256m" // The writable scratch space of a read-only sandbox
+)
+
+// containerHardening is how sandbox containers are locked down
+type containerHardening struct {
+	disabled bool   // Run sandboxes with the runtime's defaults
+	seccomp  string // Seccomp profile file; empty for the runtime's default profile
+}
+
+// WithContainerHardening turns the hardening of sandbox containers on or
+// off. It is on by default: sandboxes run without capabilities, with
+// no-new-privileges, with a read-only root file system and a tmpfs at /tmp,
+// and with the process and memory limits of WithResourceLimits, or
+// DEFAULT_CONTAINER_PIDS processes. Turn it off for images that must write
+// to their root file system, e.g. to install packages.
+func WithContainerHardening(enabled bool) Option {
+	return func(s *ShellServer) error {
+		s.containerHardening.disabled = !enabled
+		return nil
+	}
+}
+
+// WithContainerSeccomp runs sandbox containers under the seccomp profile in
+// the JSON file at path instead of the runtime's default profile
+func WithContainerSeccomp(path string) Option {
+	return func(s *ShellServer) error {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read seccomp profile: %v", err)
+		}
+		var profile map[string]interface{}
+		if err := json.Unmarshal(data, &profile); err != nil {
+			return fmt.Errorf("invalid seccomp profile '%s': %v", path, err)
+		}
+		s.containerHardening.seccomp = path
+		return nil
+	}
+}
+
+// hardeningArgs returns the run arguments that lock a sandbox container down
+func (s *ShellServer) hardeningArgs() []string {
+	var args []string
+	if s.containerHardening.seccomp != "" {
+		args = append(args, "--security-opt", "seccomp="+s.containerHardening.seccomp)
+	}
+	if s.containerHardening.disabled {
+		return args
+	}
+	args = append(args,
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--read-only",
+		"--tmpfs", CONTAINER_TMPFS,
+	)
+
+	limits := s.control.limits
+	pids := uint64(DEFAULT_CONTAINER_PIDS)
+	if limits.Processes > 0 {
+		pids = limits.Processes
+	}
+	args = append(args, "--pids-limit", strconv.FormatUint(pids, 10))
+	if limits.Memory > 0 {
+		// Without an equal swap limit the container could swap as much again
+		memory := strconv.FormatUint(limits.Memory, 10)
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	if limits.OpenFiles > 0 {
+		args = append(args, "--ulimit", fmt.Sprintf("nofile=%d:%d", limits.OpenFiles, limits.OpenFiles))
+	}
+	return args
+}
@@ -0,0 +1,58 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestHardeningArgs(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	os.WriteFile(profile, []byte(`{"defaultAction":"SCMP_ACT_ERRNO"}`), 0600)
+	tests := []struct {
+		name   string
+		opts   []Option
+		want   string
+		absent string
+	}{
+		{"default", nil, "--cap-drop ALL --security-opt no-new-privileges --read-only --tmpfs " + CONTAINER_TMPFS + " --pids-limit 256", "--memory"},
+		{"limits", []Option{WithResourceLimits(ResourceLimits{Processes: 64, Memory: 1 << 30, OpenFiles: 512})}, "--pids-limit 64 --memory 1073741824 --memory-swap 1073741824 --ulimit nofile=512:512", ""},
+		{"seccomp", []Option{WithContainerSeccomp(profile)}, "--security-opt seccomp=" + profile + " --cap-drop ALL", ""},
+		{"disabled", []Option{WithContainerHardening(false)}, "", "--cap-drop"},
+	}
+	for _, tt := range tests {
+		s, err := NewShellServer(tt.opts...)
+		if err != nil {
+			t.Errorf("%s: NewShellServer failed: %v", tt.name, err)
+			continue
+		}
+		args := strings.Join(s.hardeningArgs(), " ")
+		if !strings.Contains(args, tt.want) || (tt.absent != "" && strings.Contains(args, tt.absent)) {
+			t.Errorf("%s: hardening arguments = %q, want %q without %q", tt.name, args, tt.want, tt.absent)
+		}
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	os.WriteFile(invalid, []byte("not json"), 0600)
+	if _, err := NewShellServer(WithContainerSeccomp(invalid)); err == nil {
+		t.Errorf("an invalid seccomp profile was accepted")
+	}
+}
+
+func TestHardenedSandbox(t *testing.T) {
+	s, state := fakeDockerServer(t, WithAllowedCommands("echo"))
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"image": "alpine:3.20"}
+	if result, _ := s.handleCreateSandbox(context.Background(), request); result.IsError {
+		t.Fatalf("create_sandbox failed: %v", result.Content)
+	}
+	args, _ := os.ReadFile(filepath.Join(state, fmt.Sprintf("mcp-%d-sandbox-1.args", os.Getpid())))
+	if !strings.Contains(string(args), "--cap-drop ALL --security-opt no-new-privileges --read-only") {
+		t.Errorf("docker run arguments = %q, want the sandbox hardened", args)
+	}
+}
@@ -0,0 +1,239 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Pull policies of sandbox images
+const (
+	PULL_NEVER          = "never"          // Only images already on the host run
+	PULL_IF_NOT_PRESENT = "if-not-present" // Images missing from the host are pulled
+	DOCKER_HUB          = "docker.io"      // Registry of images named without one
+)
+
+var (
+	// digestPattern matches an image digest
+	digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+	// tagPattern matches an image tag
+	tagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// ContainerImages restricts the images sandboxes run and says how they are
+// pulled
+type ContainerImages struct {
+	Images         []string                `json:"images"`                   // Allowed images; "*" for any
+	RequireDigests bool                    `json:"requireDigests,omitempty"` // Only run images by digest
+	PullPolicy     string                  `json:"pullPolicy,omitempty"`     // PULL_*; PULL_IF_NOT_PRESENT if empty
+	Registries     map[string]RegistryAuth `json:"registries,omitempty"`     // Credentials by registry host
+}
+
+// RegistryAuth are the credentials images are pulled from a registry with
+type RegistryAuth struct {
+	Username    string `json:"username"`
+	PasswordEnv string `json:"passwordEnv"` // Environment variable holding the password or token
+}
+
+// imageRef is an image reference split into its parts
+type imageRef struct {
+	name   string // Repository, with its registry if it has one
+	tag    string // Empty if not given
+	digest string // Empty if not given
+}
+
+// parseImage splits an image reference such as
+// ghcr.io/acme/tool:1.2@sha256:<hex>
+func parseImage(reference string) (imageRef, error) {
+	if !imagePattern.MatchString(reference) {
+		return imageRef{}, fmt.Errorf("'%s' is not an image reference", reference)
+	}
+	var ref imageRef
+	ref.name, ref.digest, _ = strings.Cut(reference, "@")
+	if ref.digest != "" && !digestPattern.MatchString(ref.digest) {
+		return imageRef{}, fmt.Errorf("'%s' has an invalid digest; expected sha256:<64 hex digits>", reference)
+	}
+	if i := strings.LastIndex(ref.name, ":"); i > strings.LastIndex(ref.name, "/") {
+		ref.name, ref.tag = ref.name[:i], ref.name[i+1:]
+		if !tagPattern.MatchString(ref.tag) {
+			return imageRef{}, fmt.Errorf("'%s' has an invalid tag", reference)
+		}
+	}
+	if ref.name == "" || strings.Contains(ref.name, "@") || strings.HasSuffix(ref.name, "/") {
+		return imageRef{}, fmt.Errorf("'%s' is not an image reference", reference)
+	}
+	return ref, nil
+}
+
+// String returns the reference as the container runtime takes it
+func (r imageRef) String() string {
+	reference := r.name
+	if r.tag != "" {
+		reference += ":" + r.tag
+	}
+	if r.digest != "" {
+		reference += "@" + r.digest
+	}
+	return reference
+}
+
+// registry returns the host of the registry the image is pulled from
+func (r imageRef) registry() string {
+	first, _, found := strings.Cut(r.name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return DOCKER_HUB
+}
+
+// LoadContainerImages reads a --container-images file:
+// {"images": ["debian:bookworm@sha256:<hex>", "ghcr.io/acme/tool"],
+// "pullPolicy": "never", "registries": {"ghcr.io": {"username": "bot",
+// "passwordEnv": "GHCR_TOKEN"}}}
+func LoadContainerImages(path string) (*ContainerImages, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var images ContainerImages
+	if err := decoder.Decode(&images); err != nil {
+		return nil, fmt.Errorf("invalid container images file: %v", err)
+	}
+	return &images, nil
+}
+
+// check validates the images, pull policy and registries
+func (c *ContainerImages) check() error {
+	switch c.PullPolicy {
+	case "":
+		c.PullPolicy = PULL_IF_NOT_PRESENT
+	case PULL_NEVER, PULL_IF_NOT_PRESENT:
+	default:
+		return fmt.Errorf("invalid pull policy '%s'; expected '%s' or '%s'", c.PullPolicy, PULL_NEVER, PULL_IF_NOT_PRESENT)
+	}
+	for _, image := range c.Images {
+		if image == "*" {
+			continue
+		}
+		ref, err := parseImage(image)
+		if err != nil {
+			return err
+		}
+		if c.RequireDigests && ref.digest == "" {
+			return fmt.Errorf("image '%s' is not pinned to a digest, which requireDigests needs", image)
+		}
+	}
+	for registry, auth := range c.Registries {
+		if registry == "" || strings.ContainsAny(registry, "/ ") {
+			return fmt.Errorf("invalid registry '%s'; expected a host name such as ghcr.io", registry)
+		}
+		if auth.Username == "" || auth.PasswordEnv == "" {
+			return fmt.Errorf("registry '%s' needs a username and a passwordEnv", registry)
+		}
+	}
+	return nil
+}
+
+// WithContainerImages restricts the images sandboxes run. Without it no
+// image is allowed.
+func WithContainerImages(images *ContainerImages) Option {
+	return func(s *ShellServer) error {
+		if err := images.check(); err != nil {
+			return err
+		}
+		s.containerImages = images
+		return nil
+	}
+}
+
+// resolve returns the reference to run for a requested image: the request
+// pinned to the digest of the allowed image it matches. A tag must match
+// the allowed one if that has a tag; a digest must match if both have one.
+func (c *ContainerImages) resolve(requested string) (string, error) {
+	ref, err := parseImage(requested)
+	if err != nil {
+		return "", err
+	}
+	for _, image := range c.Images {
+		if image == "*" {
+			if c.RequireDigests && ref.digest == "" {
+				continue
+			}
+			return ref.String(), nil
+		}
+		allowed, _ := parseImage(image)
+		if allowed.name != ref.name ||
+			(allowed.tag != "" && allowed.tag != ref.tag) ||
+			(allowed.digest != "" && ref.digest != "" && allowed.digest != ref.digest) {
+			continue
+		}
+		if allowed.digest != "" {
+			ref.digest = allowed.digest
+		}
+		if c.RequireDigests && ref.digest == "" {
+			continue
+		}
+		return ref.String(), nil
+	}
+	return "", fmt.Errorf("image '%s' is not allowed", requested)
+}
+
+// pullFlag returns the runtime's --pull value for the pull policy
+func (c *ContainerImages) pullFlag() string {
+	if c.PullPolicy == PULL_NEVER {
+		return "never"
+	}
+	return "missing"
+}
+
+// registryEnv writes a client configuration with the credentials of the
+// image's registry, if any are configured, to a new private directory. It
+// returns the environment that points docker and podman at it, and a
+// function that removes it. The operator's own configuration is left alone.
+func (c *ContainerImages) registryEnv(image string, tempDir string) ([]string, func(), error) {
+	ref, err := parseImage(image)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	registry := ref.registry()
+	auth, found := c.Registries[registry]
+	if !found || c.PullPolicy == PULL_NEVER {
+		return nil, func() {}, nil
+	}
+	password := os.Getenv(auth.PasswordEnv)
+	if password == "" {
+		return nil, func() {}, fmt.Errorf("the password of registry '%s' is not set in $%s", registry, auth.PasswordEnv)
+	}
+
+	// Docker Hub credentials are keyed by its legacy index URL
+	key := registry
+	if registry == DOCKER_HUB {
+		key = "https://index.docker.io/v1/"
+	}
+	config, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			key: map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + password))},
+		},
+	})
+	if err != nil {
+		return nil, func() {}, err
+	}
+	dir, err := os.MkdirTemp(tempDir, "mcp-shell-registry-")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, config, 0o600); err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+	return []string{"DOCKER_CONFIG=" + dir, "REGISTRY_AUTH_FILE=" + path}, cleanup, nil
+}
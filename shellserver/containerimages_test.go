@@ -0,0 +1,142 @@
+package shellserver
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseImage(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0", 64)
+	tests := []struct {
+		reference string
+		want      imageRef
+		registry  string
+		wantErr   bool
+	}{
+		{"alpine", imageRef{name: "alpine"}, DOCKER_HUB, false},
+		{"alpine:3.20", imageRef{name: "alpine", tag: "3.20"}, DOCKER_HUB, false},
+		{"acme/tool@" + digest, imageRef{name: "acme/tool", digest: digest}, DOCKER_HUB, false},
+		{"ghcr.io/acme/tool:1.2@" + digest, imageRef{name: "ghcr.io/acme/tool", tag: "1.2", digest: digest}, "ghcr.io", false},
+		{"localhost:5000/tool", imageRef{name: "localhost:5000/tool"}, "localhost:5000", false},
+		{"localhost/tool", imageRef{name: "localhost/tool"}, "localhost", false},
+		{"alpine:", imageRef{}, "", true},
+		{"alpine@sha256:abc", imageRef{}, "", true},
+		{"--privileged", imageRef{}, "", true},
+		{"acme/", imageRef{}, "", true},
+		{"a b", imageRef{}, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseImage(tt.reference)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseImage(%q) error = %v, wantErr %v", tt.reference, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got != tt.want || got.String() != tt.reference || got.registry() != tt.registry {
+			t.Errorf("parseImage(%q) = %+v (%s, registry %s), want %+v (registry %s)", tt.reference, got, got, got.registry(), tt.want, tt.registry)
+		}
+	}
+}
+
+func TestContainerImagesResolve(t *testing.T) {
+	pinned := "sha256:" + strings.Repeat("a", 64)
+	other := "sha256:" + strings.Repeat("b", 64)
+	images := &ContainerImages{Images: []string{"debian:bookworm@" + pinned, "python:3.12", "ghcr.io/acme/tool"}}
+	if err := images.check(); err != nil {
+		t.Fatalf("check() failed: %v", err)
+	}
+
+	tests := []struct {
+		requested string
+		want      string
+	}{
+		{"debian:bookworm", "debian:bookworm@" + pinned},
+		{"debian:bookworm@" + pinned, "debian:bookworm@" + pinned},
+		{"debian:bookworm@" + other, ""},
+		{"debian:trixie", ""},
+		{"debian", ""},
+		{"python:3.12", "python:3.12"},
+		{"python:3.13", ""},
+		{"ghcr.io/acme/tool:2.0", "ghcr.io/acme/tool:2.0"},
+		{"ghcr.io/acme/tool@" + other, "ghcr.io/acme/tool@" + other},
+		{"acme/tool", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := images.resolve(tt.requested)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("resolve(%q) = %q, %v, want %q", tt.requested, got, err, tt.want)
+		}
+	}
+
+	// With requireDigests, any image must still be run by digest
+	strict := &ContainerImages{Images: []string{"*"}, RequireDigests: true}
+	if _, err := strict.resolve("alpine"); err == nil {
+		t.Errorf("resolve(alpine) with requireDigests succeeded, want an error")
+	}
+	if got, err := strict.resolve("alpine@" + other); err != nil || got != "alpine@"+other {
+		t.Errorf("resolve(alpine@digest) with requireDigests = %q, %v", got, err)
+	}
+}
+
+func TestContainerImagesCheck(t *testing.T) {
+	tests := []struct {
+		images  ContainerImages
+		wantErr string
+	}{
+		{ContainerImages{Images: []string{"alpine"}, PullPolicy: PULL_NEVER}, ""},
+		{ContainerImages{Images: []string{"alpine"}, PullPolicy: "always"}, "invalid pull policy 'always'"},
+		{ContainerImages{Images: []string{"alpine"}, RequireDigests: true}, "is not pinned to a digest"},
+		{ContainerImages{Images: []string{"alpine:"}}, "invalid tag"},
+		{ContainerImages{Registries: map[string]RegistryAuth{"ghcr.io": {Username: "bot"}}}, "needs a username and a passwordEnv"},
+		{ContainerImages{Registries: map[string]RegistryAuth{"https://ghcr.io": {Username: "bot", PasswordEnv: "X"}}}, "invalid registry"},
+	}
+
+	for _, tt := range tests {
+		err := tt.images.check()
+		if (tt.wantErr == "" && err != nil) || (tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr))) {
+			t.Errorf("check(%+v) error = %v, want %q", tt.images, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRegistryEnv(t *testing.T) {
+	images := &ContainerImages{
+		Images:     []string{"*"},
+		PullPolicy: PULL_IF_NOT_PRESENT,
+		Registries: map[string]RegistryAuth{
+			"ghcr.io":  {Username: "bot", PasswordEnv: "TEST_GHCR_TOKEN"},
+			DOCKER_HUB: {Username: "hub", PasswordEnv: "TEST_UNSET_TOKEN"},
+		},
+	}
+	t.Setenv("TEST_GHCR_TOKEN", "s3cret")
+	os.Unsetenv("TEST_UNSET_TOKEN")
+
+	env, cleanup, err := images.registryEnv("ghcr.io/acme/tool:1", t.TempDir())
+	if err != nil || len(env) != 2 || !strings.HasPrefix(env[0], "DOCKER_CONFIG=") {
+		t.Fatalf("registryEnv(ghcr.io) = %v, %v, want a docker config", env, err)
+	}
+	config := strings.TrimPrefix(env[1], "REGISTRY_AUTH_FILE=")
+	data, _ := os.ReadFile(config)
+	info, _ := os.Stat(config)
+	auth := base64.StdEncoding.EncodeToString([]byte("bot:s3cret"))
+	if !strings.Contains(string(data), `"ghcr.io":{"auth":"`+auth+`"}`) || info.Mode().Perm() != 0o600 {
+		t.Errorf("registry config = %s (mode %v), want the ghcr.io credentials in a private file", data, info.Mode().Perm())
+	}
+	cleanup()
+	if _, err := os.Stat(config); !os.IsNotExist(err) {
+		t.Errorf("registry config still exists after cleanup: %v", err)
+	}
+
+	if env, _, err := images.registryEnv("quay.io/acme/tool", t.TempDir()); env != nil || err != nil {
+		t.Errorf("registryEnv(quay.io) = %v, %v, want nothing for a registry without credentials", env, err)
+	}
+	if _, _, err := images.registryEnv("alpine", t.TempDir()); err == nil || !strings.Contains(err.Error(), "$TEST_UNSET_TOKEN") {
+		t.Errorf("registryEnv(alpine) error = %v, want the unset variable named", err)
+	}
+}
@@ -0,0 +1,435 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	MAX_CONTAINERS          = 5                       // Maximum concurrently running sandbox containers
+	CONTAINER_LABEL         = "mcp-unix-shell.server" // Label on every sandbox container, set to the server's PID
+	DEFAULT_CONTAINER_SHELL = "sh"                    // Shell of exec_in_sandbox; images often lack bash
+	SANDBOX_URI             = "shell://sandbox.json"  // URI of the structured create_sandbox result
+)
+
+// imagePattern matches image references: a name with an optional registry,
+// tag and digest
+var imagePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/:@-]*$`)
+
+// ContainerMount is a host directory made visible in a sandbox container
+type ContainerMount struct {
+	Source   string `json:"source"`   // Directory on the host
+	Target   string `json:"target"`   // Where it appears in the container
+	Writable bool   `json:"writable"` // Whether the container may change it
+}
+
+// sandboxContainer is a long-lived container whose files and installed
+// packages carry across exec_in_sandbox calls
+type sandboxContainer struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"` // Name of the container for the runtime
+	Image     string           `json:"image"`
+	Mounts    []ContainerMount `json:"mounts,omitempty"`
+	Network   bool             `json:"network"`
+	Devices   []string         `json:"devices,omitempty"` // Host devices passed through
+	GPUs      bool             `json:"gpus,omitempty"`    // Whether all GPUs are passed through
+	StartTime time.Time        `json:"startTime"`
+	Tenant    string           `json:"-"` // Tenant that created the sandbox
+}
+
+// WithContainers enables create_sandbox, exec_in_sandbox and
+// destroy_sandbox, which run containers with runtime, e.g. "docker" or
+// "podman"
+func WithContainers(runtime string) Option {
+	return func(s *ShellServer) error {
+		if runtime == "" || strings.HasPrefix(runtime, "-") {
+			return fmt.Errorf("invalid container runtime '%s'", runtime)
+		}
+		s.containerRuntime = runtime
+		return nil
+	}
+}
+
+// containerExecutor runs commands in a sandbox container with the runtime's
+// exec command
+type containerExecutor struct {
+	runtime string
+	name    string
+	control processControl
+}
+
+// Execute runs command with shell -c in the container. Extra environment
+// variables in env are set for it.
+func (e containerExecutor) Execute(ctx context.Context, command string, shell string, env []string, stream io.Writer) CommandExecution {
+	if shell == "" {
+		shell = DEFAULT_CONTAINER_SHELL
+	}
+	execution := CommandExecution{Command: command, Shell: shell, StartTime: time.Now()}
+	if shell != "sh" && shell != "bash" && shell != "zsh" {
+		execution.Output = fmt.Sprintf("Error: Unsupported shell '%s'. Only sh, bash and zsh are supported in sandboxes.", shell)
+		execution.ExitCode = 1
+		execution.ErrorCode = ERROR_SHELL_UNSUPPORTED
+		execution.EndTime = time.Now()
+		return execution
+	}
+
+	args := []string{"exec"}
+	if StdinFromContext(ctx) != nil {
+		args = append(args, "-i")
+	}
+	for _, entry := range env {
+		args = append(args, "-e", entry)
+	}
+	args = append(args, e.name, shell, "-c", command)
+	execution = runCommand(ctx, e.control.command(ctx, e.runtime, args...), execution, stream)
+	// The runtime client's own usage says nothing about the command
+	execution.Usage = nil
+	return execution
+}
+
+// runInContainer runs the request in its sandbox container
+func (s *ShellServer) runInContainer(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+	container, found := s.getContainer(ctx, req.Container)
+	if !found {
+		return CommandExecution{}, fmt.Errorf("no sandbox with ID '%s'", req.Container)
+	}
+	executor := containerExecutor{runtime: s.containerRuntime, name: container.Name, control: s.control}
+	return s.executeWith(executor, req, req.Command), nil
+}
+
+// containerMount parses a "host:container[:ro|:rw]" mount. The host
+// directory must be in the server's or a project's directory and not
+// protected by the policy; mounts are read-only unless ":rw" is given and
+// the policy allows writing.
+func (s *ShellServer) containerMount(ctx context.Context, spec string) (ContainerMount, *ToolError) {
+	invalid := func(reason string) (ContainerMount, *ToolError) {
+		return ContainerMount{}, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: Invalid mount '%s': %s", spec, reason),
+			Details: map[string]interface{}{"argument": "mounts", "mount": spec},
+		}
+	}
+	parts := strings.Split(spec, ":")
+	if len(parts) == 3 && (parts[2] == "ro" || parts[2] == "rw") {
+		parts = parts[:2]
+	} else if len(parts) != 2 {
+		return invalid("expected host:container, host:container:ro or host:container:rw")
+	}
+	mount := ContainerMount{Target: path.Clean(parts[1]), Writable: strings.HasSuffix(spec, ":rw")}
+	if !path.IsAbs(mount.Target) || mount.Target == "/" {
+		return invalid("the container path must be absolute and not /")
+	}
+	if strings.Contains(spec, ",") {
+		return invalid("paths cannot contain ','")
+	}
+
+	// The host side is checked as a file tool would check it, which keeps it
+	// inside the server's or a project's directory
+	var pathRequest mcp.CallToolRequest
+	pathRequest.Params.Name = "create_sandbox"
+	pathRequest.Params.Arguments = map[string]interface{}{"path": parts[0]}
+	check := s.inspectPath
+	if mount.Writable {
+		check = s.writablePath
+	}
+	source, toolError := check(ctx, pathRequest, "path")
+	if toolError != nil {
+		return ContainerMount{}, toolError
+	}
+	if info, err := os.Stat(source); err != nil || !info.IsDir() {
+		return invalid("the host path must be an existing directory")
+	}
+	mount.Source = source
+	return mount, nil
+}
+
+// createContainer starts a sandbox container with the image, mounts,
+// network and devices of spec, which idles until it is destroyed
+func (s *ShellServer) createContainer(spec sandboxContainer) (*sandboxContainer, error) {
+	s.containerMutex.Lock()
+	if len(s.containers) >= MAX_CONTAINERS {
+		s.containerMutex.Unlock()
+		return nil, fmt.Errorf("too many sandboxes (maximum %d); destroy one with 'destroy_sandbox' first", MAX_CONTAINERS)
+	}
+	s.containerCounter++
+	id := fmt.Sprintf("sandbox-%d", s.containerCounter)
+	container := &spec
+	container.ID = id
+	container.Name = fmt.Sprintf("mcp-%d-%s", os.Getpid(), id)
+	container.StartTime = s.now()
+	// Reserve the slot while the container starts
+	s.containers[id] = container
+	s.containerMutex.Unlock()
+
+	args := []string{"run", "--detach", "--init", "--name", container.Name, "--label", fmt.Sprintf("%s=%d", CONTAINER_LABEL, os.Getpid())}
+	args = append(args, s.hardeningArgs()...)
+	if !container.Network {
+		args = append(args, "--network", "none")
+	}
+	args = append(args, s.deviceArgs(container.Devices, container.GPUs)...)
+	for _, mount := range container.Mounts {
+		option := "type=bind,source=" + mount.Source + ",target=" + mount.Target
+		if !mount.Writable {
+			option += ",readonly"
+		}
+		args = append(args, "--mount", option)
+	}
+	if len(container.Mounts) > 0 {
+		args = append(args, "--workdir", container.Mounts[0].Target)
+	}
+	args = append(args, "--pull", s.containerImages.pullFlag(), "--entrypoint", "sleep", "--", container.Image, "infinity")
+
+	// Pull with the registry's credentials, if it has any configured
+	env, cleanup, err := s.containerImages.registryEnv(container.Image, s.tempDir)
+	defer cleanup()
+	if err != nil {
+		s.containerMutex.Lock()
+		delete(s.containers, id)
+		s.containerMutex.Unlock()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	cmd := s.control.command(ctx, s.containerRuntime, args...)
+	if len(env) > 0 {
+		cmd.Env = append(cmd.Environ(), env...)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		s.containerMutex.Lock()
+		delete(s.containers, id)
+		s.containerMutex.Unlock()
+		// A container that was created but failed to start is removed too
+		s.control.command(context.Background(), s.containerRuntime, "rm", "--force", container.Name).Run()
+		return nil, fmt.Errorf("%s run failed: %v: %s", s.containerRuntime, err, strings.TrimSpace(string(output)))
+	}
+	return container, nil
+}
+
+// getContainer looks up a sandbox container of the calling tenant by ID
+func (s *ShellServer) getContainer(ctx context.Context, id string) (*sandboxContainer, bool) {
+	tenant := s.tenant(ctx)
+	s.containerMutex.Lock()
+	defer s.containerMutex.Unlock()
+	container, ok := s.containers[id]
+	if !ok || container.Tenant != tenant {
+		return nil, false
+	}
+	return container, true
+}
+
+// destroyContainer removes a sandbox container and forgets it
+func (s *ShellServer) destroyContainer(id string) error {
+	s.containerMutex.Lock()
+	container, ok := s.containers[id]
+	delete(s.containers, id)
+	s.containerMutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no sandbox with ID '%s'", id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if output, err := s.control.command(ctx, s.containerRuntime, "rm", "--force", container.Name).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s rm failed: %v: %s", s.containerRuntime, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// destroyAllContainers removes every sandbox container, e.g. on shutdown
+func (s *ShellServer) destroyAllContainers() {
+	s.containerMutex.Lock()
+	ids := make([]string, 0, len(s.containers))
+	for id := range s.containers {
+		ids = append(ids, id)
+	}
+	s.containerMutex.Unlock()
+
+	for _, id := range ids {
+		if err := s.destroyContainer(id); err != nil {
+			s.logger.Printf("Failed to destroy %s: %v", id, err)
+		}
+	}
+}
+
+// containerIDs lists the IDs of the calling tenant's sandbox containers
+func (s *ShellServer) containerIDs(ctx context.Context) []string {
+	tenant := s.tenant(ctx)
+	s.containerMutex.Lock()
+	defer s.containerMutex.Unlock()
+	ids := make([]string, 0, len(s.containers))
+	for id, container := range s.containers {
+		if container.Tenant == tenant {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *ShellServer) handleCreateSandbox(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if s.containerRuntime == "" {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: s.message(MSG_NO_CONTAINERS)}), nil
+	}
+	requested, _ := request.Params.Arguments["image"].(string)
+	if _, err := parseImage(requested); err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: " + err.Error(),
+			Details: map[string]interface{}{"argument": "image"},
+		}), nil
+	}
+	images := &ContainerImages{}
+	if s.containerImages != nil {
+		images = s.containerImages
+	}
+	image, err := images.resolve(requested)
+	if err != nil {
+		allowed := "none; configure them with --container-images"
+		if len(images.Images) > 0 {
+			allowed = strings.Join(images.Images, ", ")
+		}
+		return errorResult(ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_IMAGE_NOT_ALLOWED, requested, allowed),
+			Details: map[string]interface{}{"rule": "image", "image": requested, "images": images.Images},
+		}), nil
+	}
+
+	var mounts []ContainerMount
+	specs, ok := request.Params.Arguments["mounts"].([]interface{})
+	if !ok && request.Params.Arguments["mounts"] != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'mounts' must be an array of strings",
+			Details: map[string]interface{}{"argument": "mounts"},
+		}), nil
+	}
+	for _, item := range specs {
+		spec, _ := item.(string)
+		mount, toolError := s.containerMount(ctx, spec)
+		if toolError != nil {
+			return errorResult(*toolError), nil
+		}
+		mounts = append(mounts, mount)
+	}
+	network, _ := request.Params.Arguments["network"].(bool)
+	devices, gpus, toolError := s.sandboxDevices(request)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	container, err := s.createContainer(sandboxContainer{Image: image, Mounts: mounts, Network: network, Devices: devices, GPUs: gpus, Tenant: s.tenant(ctx)})
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: "Error: " + err.Error(),
+			Details: map[string]interface{}{"image": image},
+		}), nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Created sandbox '%s' from %s (container %s)", container.ID, container.Image, container.Name)
+	if !container.Network {
+		text.WriteString(" without network access")
+	}
+	text.WriteString(".\n")
+	for _, mount := range container.Mounts {
+		mode := "read-only"
+		if mount.Writable {
+			mode = "writable"
+		}
+		fmt.Fprintf(&text, "Mounted %s at %s (%s).\n", mount.Source, mount.Target, mode)
+	}
+	if len(container.Devices) > 0 {
+		fmt.Fprintf(&text, "Devices: %s.\n", strings.Join(container.Devices, ", "))
+	}
+	if container.GPUs {
+		text.WriteString("All GPUs are available.\n")
+	}
+	text.WriteString("Pass its sandbox_id to exec_in_sandbox to run commands in it, and destroy it with destroy_sandbox when done.")
+	return jsonResult(text.String(), SANDBOX_URI, container), nil
+}
+
+func (s *ShellServer) handleExecInSandbox(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	command, ok := request.Params.Arguments["command"].(string)
+	if !ok {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_INVALID_COMMAND),
+			Details: map[string]interface{}{"argument": "command"},
+		}), nil
+	}
+	id, _ := request.Params.Arguments["sandbox_id"].(string)
+	if _, found := s.getContainer(ctx, id); !found {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_SANDBOX_NOT_FOUND, id),
+			Details: map[string]interface{}{"argument": "sandbox_id", "sandbox_id": id, "sandboxes": s.containerIDs(ctx)},
+		}), nil
+	}
+	shell, _ := request.Params.Arguments["shell"].(string)
+	if shell == "" {
+		shell = DEFAULT_CONTAINER_SHELL
+	}
+
+	// Run the command through the middleware chain, so the policy, the
+	// audit log and the history see it as any other
+	req := &ExecRequest{Command: command, Shell: shell, Container: id, Client: s.clientIdentity(ctx)}
+	execution, err := s.exec(ctx, req)
+	if err != nil {
+		return errorResult(s.deniedToolError(req, err)), nil
+	}
+
+	status := "completed successfully"
+	if execution.ExitCode != 0 {
+		status = fmt.Sprintf("failed with exit code %d", execution.ExitCode)
+	}
+	content := []mcp.Content{
+		assistantText(fmt.Sprintf("$ %s\n\n%s\n\nCommand %s in %d ms in %s, output at %s",
+			execution.Command, execution.Output, status, execution.ExecutionMs, id, executionURI(execution.ID))),
+	}
+	toolError := s.executionError(execution)
+	if toolError != nil {
+		content = append(content, errorResource(*toolError))
+	}
+	return &mcp.CallToolResult{
+		Content: append(content, s.executionSummary(execution)),
+		IsError: toolError != nil && toolError.Code == ERROR_SHELL_UNSUPPORTED,
+	}, nil
+}
+
+func (s *ShellServer) handleDestroySandbox(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	id, _ := request.Params.Arguments["sandbox_id"].(string)
+	if _, found := s.getContainer(ctx, id); !found {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_SANDBOX_NOT_FOUND, id),
+			Details: map[string]interface{}{"argument": "sandbox_id", "sandbox_id": id, "sandboxes": s.containerIDs(ctx)},
+		}), nil
+	}
+	if err := s.destroyContainer(id); err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: "Error: " + err.Error()}), nil
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{assistantText(fmt.Sprintf("Destroyed sandbox '%s'.", id))},
+	}, nil
+}
@@ -0,0 +1,138 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// fakeDocker stands in for the docker client: a container is a directory
+// under FAKE_DOCKER_DIR holding its run arguments, in which exec runs
+// commands locally
+const fakeDocker = `#!/bin/sh
+state=$FAKE_DOCKER_DIR
+case $1 in
+run)
+	shift; args="$*"
+	while [ "$1" != "--name" ]; do shift; done
+	name=$2
+	case $args in *missing:*) echo "Unable to find image" >&2; exit 125;; esac
+	mkdir "$state/$name" && echo "$args" > "$state/$name.args" && echo 0123abcd;;
+exec)
+	shift
+	while [ "$1" = "-i" ] || [ "$1" = "-e" ]; do [ "$1" = "-e" ] && export "$2" && shift; shift; done
+	[ -d "$state/$1" ] || { echo "No such container: $1" >&2; exit 1; }
+	cd "$state/$1" && shift && exec "$@";;
+rm)
+	rm -rf "$state/$3" "$state/$3.args";;
+esac
+`
+
+func fakeDockerServer(t *testing.T, opts ...Option) (*ShellServer, string) {
+	t.Helper()
+	bin, state := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(fakeDocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_DOCKER_DIR", state)
+
+	images := &ContainerImages{Images: []string{"alpine:3.20@sha256:" + strings.Repeat("a", 64), "missing"}}
+	s, err := NewShellServer(append([]Option{WithContainers("docker"), WithContainerImages(images)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s, state
+}
+
+func TestSandboxes(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "src"), 0o755)
+	os.Mkdir(filepath.Join(dir, ".ssh"), 0o755)
+	s, state := fakeDockerServer(t,
+		WithAllowedCommands("echo,cat,touch,ls"),
+		WithPolicyRules(&PolicyRules{DenyPaths: []string{".ssh"}}),
+		WithProjects([]Project{{Name: "app", Dir: dir}}),
+	)
+
+	tests := []struct {
+		tool     string
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{"exec_in_sandbox", map[string]interface{}{"sandbox_id": "sandbox-1", "command": "ls"}, "No sandbox with ID 'sandbox-1'", ERROR_INVALID_ARGUMENT},
+		{"create_sandbox", map[string]interface{}{"image": "--privileged"}, "is not an image reference", ERROR_INVALID_ARGUMENT},
+		{"create_sandbox", map[string]interface{}{"image": "ubuntu"}, "Image 'ubuntu' is not allowed. Allowed images: alpine:3.20@sha256:", ERROR_POLICY_DENIED},
+		{"create_sandbox", map[string]interface{}{"image": "alpine:3.20", "mounts": []interface{}{"/etc:/etc"}}, "outside the server's and the projects' directories", ERROR_POLICY_DENIED},
+		{"create_sandbox", map[string]interface{}{"image": "alpine:3.20", "mounts": []interface{}{filepath.Join(dir, ".ssh") + ":/keys"}}, "", ERROR_POLICY_DENIED},
+		{"create_sandbox", map[string]interface{}{"image": "alpine:3.20", "mounts": []interface{}{dir + ":relative"}}, "the container path must be absolute", ERROR_INVALID_ARGUMENT},
+		{"create_sandbox", map[string]interface{}{"image": "missing:latest"}, "docker run failed", ERROR_EXECUTION_FAILED},
+		{"create_sandbox", map[string]interface{}{"image": "alpine:3.20", "mounts": []interface{}{filepath.Join(dir, "src") + ":/src:rw"}}, "Created sandbox 'sandbox-2' from alpine:3.20@sha256:aaaa", ""},
+		{"exec_in_sandbox", map[string]interface{}{"sandbox_id": "sandbox-2", "command": "touch built && echo done"}, "done\n", ""},
+		{"exec_in_sandbox", map[string]interface{}{"sandbox_id": "sandbox-2", "command": "ls"}, "built\n", ""},
+		{"exec_in_sandbox", map[string]interface{}{"sandbox_id": "sandbox-2", "command": "rm built"}, "Command 'rm' is not in the allowed list", ERROR_POLICY_DENIED},
+		{"exec_in_sandbox", map[string]interface{}{"sandbox_id": "sandbox-2", "command": "ls", "shell": "fish"}, "Only sh, bash and zsh", ERROR_SHELL_UNSUPPORTED},
+		{"destroy_sandbox", map[string]interface{}{"sandbox_id": "sandbox-2"}, "Destroyed sandbox 'sandbox-2'", ""},
+		{"destroy_sandbox", map[string]interface{}{"sandbox_id": "sandbox-2"}, "No sandbox with ID 'sandbox-2'", ERROR_INVALID_ARGUMENT},
+	}
+
+	handlers := map[string]func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error){
+		"create_sandbox":  s.handleCreateSandbox,
+		"exec_in_sandbox": s.handleExecInSandbox,
+		"destroy_sandbox": s.handleDestroySandbox,
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		result, _ := handlers[tt.tool](context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("%s(%v) = %q (code %q), want %q (code %q)", tt.tool, tt.args, text, code, tt.want, tt.wantCode)
+		}
+		if tt.tool == "create_sandbox" && code == "" {
+			args, _ := os.ReadFile(filepath.Join(state, fmt.Sprintf("mcp-%d-sandbox-2.args", os.Getpid())))
+			want := "--network none --mount type=bind,source=" + filepath.Join(dir, "src") + ",target=/src --workdir /src --pull missing --entrypoint sleep -- alpine:3.20@sha256:"
+			if !strings.Contains(string(args), want) {
+				t.Errorf("docker run arguments = %q, want them to contain %q", args, want)
+			}
+		}
+	}
+
+	executions, _ := s.history.Recent(1)
+	if len(executions) != 1 || executions[0].Container != "sandbox-2" {
+		t.Errorf("last execution = %+v, want it recorded in sandbox-2", executions)
+	}
+	if entries, _ := os.ReadDir(state); len(entries) != 0 {
+		t.Errorf("containers left behind: %v", entries)
+	}
+}
+
+func TestSandboxesDisabled(t *testing.T) {
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"image": "alpine"}
+
+	s, _ := NewShellServer(WithAllowedCommands("ls"))
+	result, _ := s.handleCreateSandbox(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "--containers=docker") {
+		t.Errorf("create_sandbox without a runtime = %q, want it to say how to enable sandboxes", text)
+	}
+
+	// A runtime alone allows no image
+	s, _ = NewShellServer(WithAllowedCommands("ls"), WithContainers("docker"))
+	result, _ = s.handleCreateSandbox(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "Allowed images: none; configure them with --container-images") {
+		t.Errorf("create_sandbox without images = %q, want it to say how to allow images", text)
+	}
+}
@@ -0,0 +1,214 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Limits for describe_command lookups
+const (
+	DESCRIBE_TIMEOUT   = 5 * time.Second // Timeout for --help and man lookups
+	DESCRIBE_MAX_LINES = 80              // Maximum lines in a usage summary
+	DESCRIBE_MAX_SIZE  = 8 * 1024        // Maximum bytes in a usage summary
+	MAX_DESCRIBE_CACHE = 256             // Usage summaries kept; a random one is dropped when full
+)
+
+// commandNamePattern matches plain executable names, never paths or shell syntax
+var commandNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// manSections are the man page sections kept in a usage summary
+var manSections = []string{"NAME", "SYNOPSIS", "DESCRIPTION", "OPTIONS"}
+
+// describeCommand returns a short usage summary for a command, preferring
+// its --help output and falling back to the man page. Running --help executes
+// the binary, so it is only attempted if "<name> --help" passes the
+// tripwires and the client's policy.
+func (s *ShellServer) describeCommand(name string, client *ClientIdentity) (string, string, error) {
+	help := name + " --help"
+	if tripped := s.tripwireDenial(&ExecRequest{Command: help, Client: client}); tripped != nil {
+		return "", "", tripped
+	}
+	runsHelp := s.policyDenial(s.policyFor("", clientName(client)), help) == nil
+
+	// Clients the policy refuses --help to get the man page, cached apart
+	key := name
+	if !runsHelp {
+		key += "\x00man"
+	}
+	s.describeMutex.Lock()
+	if cached, ok := s.describeCache[key]; ok {
+		s.describeMutex.Unlock()
+		return cached.summary, cached.source, nil
+	}
+	s.describeMutex.Unlock()
+
+	var summary, source string
+	if runsHelp {
+		if help, err := s.runHelp(name); err == nil && strings.TrimSpace(help) != "" {
+			summary, source = help, "--help"
+		}
+	}
+	if summary == "" {
+		page, err := s.readManPage(name)
+		if err != nil {
+			return "", "", err
+		}
+		summary, source = summarizeManPage(page), "man page"
+	}
+
+	summary = limitSummary(summary)
+
+	s.cacheDescription(key, describeEntry{summary: summary, source: source})
+	return summary, source, nil
+}
+
+// cacheDescription keeps a usage summary, dropping a random one if the
+// cache is full
+func (s *ShellServer) cacheDescription(key string, entry describeEntry) {
+	s.describeMutex.Lock()
+	defer s.describeMutex.Unlock()
+	if _, found := s.describeCache[key]; !found && len(s.describeCache) >= MAX_DESCRIBE_CACHE {
+		for dropped := range s.describeCache {
+			delete(s.describeCache, dropped)
+			break
+		}
+	}
+	s.describeCache[key] = entry
+}
+
+// runHelp runs "<name> --help" with the configured executor, so it runs as
+// the user, within the limits and in the sandbox commands do, and returns
+// its output
+func (s *ShellServer) runHelp(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DESCRIBE_TIMEOUT)
+	defer cancel()
+
+	// Many tools print help to stderr or exit nonzero, so both are accepted
+	execution := s.executor.Execute(ctx, shellQuote(name)+" --help", DEFAULT_SHELL, nil, nil)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if execution.TimedOut || execution.ExitCode == 126 || execution.ExitCode == 127 {
+		return "", fmt.Errorf("'%s --help' failed with exit code %d", name, execution.ExitCode)
+	}
+	return execution.Output, nil
+}
+
+// readManPage renders the man page for name as plain text, as the user and
+// within the limits commands run with
+func (s *ShellServer) readManPage(name string) (string, error) {
+	if _, err := exec.LookPath("man"); err != nil {
+		return "", fmt.Errorf("no --help output available and man is not installed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DESCRIBE_TIMEOUT)
+	defer cancel()
+
+	cmd := s.control.command(ctx, "man", name)
+	cmd.Env = append(cmd.Env, "MANPAGER=cat", "PAGER=cat", "MANWIDTH=80")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("no --help output or man page found for '%s'", name)
+	}
+	return stripOverstrike(string(output)), nil
+}
+
+// stripOverstrike removes the backspace sequences man uses for bold and underline
+func stripOverstrike(text string) string {
+	result := make([]rune, 0, len(text))
+	for _, r := range text {
+		if r == '\b' {
+			// Drop the previous character; the next one replaces it
+			if len(result) > 0 {
+				result = result[:len(result)-1]
+			}
+			continue
+		}
+		result = append(result, r)
+	}
+	return string(result)
+}
+
+// summarizeManPage keeps only the sections useful for learning a command's flags
+func summarizeManPage(page string) string {
+	var result strings.Builder
+	keep := false
+	for _, line := range strings.Split(page, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && line == strings.TrimLeft(line, " \t") && strings.ToUpper(trimmed) == trimmed {
+			// Section headings are unindented and upper case
+			keep = false
+			for _, section := range manSections {
+				if trimmed == section {
+					keep = true
+					break
+				}
+			}
+		}
+		if keep {
+			result.WriteString(line)
+			result.WriteString("\n")
+		}
+	}
+	if result.Len() == 0 {
+		return page
+	}
+	return result.String()
+}
+
+// limitSummary caps a summary by line count and size
+func limitSummary(summary string) string {
+	lines := strings.Split(strings.TrimSpace(summary), "\n")
+	truncated := false
+	if len(lines) > DESCRIBE_MAX_LINES {
+		lines = lines[:DESCRIBE_MAX_LINES]
+		truncated = true
+	}
+	result := strings.Join(lines, "\n")
+	if len(result) > DESCRIBE_MAX_SIZE {
+		result = result[:DESCRIBE_MAX_SIZE]
+		truncated = true
+	}
+	if truncated {
+		result += "\n... (summary truncated)"
+	}
+	return result
+}
+
+func (s *ShellServer) handleDescribeCommand(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, ok := request.Params.Arguments["command"].(string)
+	if !ok || !commandNamePattern.MatchString(name) {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "Error: 'command' must be a plain command name such as 'tar' or 'git'",
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	summary, source, err := s.describeCommand(name, s.clientIdentity(ctx))
+	if err != nil {
+		return errorResult(refusal(err)), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: fmt.Sprintf("Usage summary for '%s' (from %s):\n\n%s", name, source, summary),
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,88 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDescribeCommandPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the described commands are shell scripts")
+	}
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "ran")
+	for _, name := range []string{"helper", "secret", "blocked"} {
+		script := fmt.Sprintf("#!/bin/sh\necho %s >> %s\necho 'usage: %s [-v]'\n", name, ran, name)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s, err := NewShellServer(
+		WithAllowedCommands("helper,secret"),
+		WithPolicyRules(&PolicyRules{Tripwires: []Tripwire{{Name: "secret", Commands: []string{"secret"}}}}),
+		WithClientPolicies([]ClientPolicy{{Name: "cursor", Policy: &PolicyRules{Deny: []string{"helper"}}}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		client *ClientIdentity
+		want   string
+		runs   bool
+	}{
+		{"helper", nil, "usage: helper", true},
+		{"helper", &ClientIdentity{Name: "cursor"}, "", false},
+		{"secret", nil, "tripwire", false},
+		{"blocked", nil, "", false},
+	}
+
+	for _, tt := range tests {
+		os.Remove(ran)
+		summary, _, err := s.describeCommand(tt.name, tt.client)
+		if tt.want != "" && (err == nil && !strings.Contains(summary, tt.want) || err != nil && !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("describeCommand(%q) = %q, %v; want %q", tt.name, summary, err, tt.want)
+		}
+		if _, err := os.Stat(ran); (err == nil) != tt.runs {
+			t.Errorf("describeCommand(%q) for %v ran it: %v, want %v", tt.name, tt.client, err == nil, tt.runs)
+		}
+	}
+}
+
+func TestDescribeCommandUsesExecutor(t *testing.T) {
+	executor := &fakeExecutor{}
+	s, err := NewShellServer(WithAllowedCommands("helper"), WithExecutor(executor))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	// --help runs where commands run, not as a child of the server
+	summary, source, err := s.describeCommand("helper", nil)
+	if err != nil || source != "--help" || summary != "fake: 'helper' --help" {
+		t.Errorf("describeCommand(helper) = %q from %q, %v; want the executor's output", summary, source, err)
+	}
+	if len(executor.commands) != 1 || executor.commands[0] != "'helper' --help" {
+		t.Errorf("executor ran %q, want 'helper' --help", executor.commands)
+	}
+}
+
+func TestDescribeCacheBounded(t *testing.T) {
+	s, err := NewShellServer()
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	for i := 0; i < MAX_DESCRIBE_CACHE+10; i++ {
+		s.cacheDescription(fmt.Sprintf("cmd%d", i), describeEntry{summary: "usage"})
+	}
+	last := fmt.Sprintf("cmd%d", MAX_DESCRIBE_CACHE+9)
+	if _, found := s.describeCache[last]; len(s.describeCache) != MAX_DESCRIBE_CACHE || !found {
+		t.Errorf("describe cache holds %d summaries (latest kept: %v), want %d", len(s.describeCache), found, MAX_DESCRIBE_CACHE)
+	}
+}
@@ -0,0 +1,278 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// DIAGNOSTICS_URI is the URI of the structured dump_diagnostics result
+const DIAGNOSTICS_URI = "shell://diagnostics.json"
+
+// PPROF_SOCKET_SUFFIX is added to the admin socket path to name the socket
+// the profiling endpoints are served on
+const PPROF_SOCKET_SUFFIX = ".pprof"
+
+// MAX_GOROUTINE_DUMP caps the bytes of goroutine stacks dump_diagnostics returns
+const MAX_GOROUTINE_DUMP = 256 * 1024
+
+// Diagnostics is the state of the server for debugging stuck executions and
+// leaks, as returned by dump_diagnostics
+type Diagnostics struct {
+	Time       time.Time             `json:"time"`
+	Goroutines int                   `json:"goroutines"`
+	Memory     MemoryDiagnostics     `json:"memory"`
+	Processes  []ProcessDiagnostic   `json:"processes"` // Commands, sessions, REPLs and sandboxes, oldest first
+	Queues     QueueDiagnostics      `json:"queues"`
+	Reaper     ReaperDiagnostics     `json:"reaper"`              // What the reaper cleaned up since the server started
+	Retention  *RetentionDiagnostics `json:"retention,omitempty"` // What the retention job removed, if a retention is set
+	Stacks     string                `json:"stacks,omitempty"`    // Goroutine dump, if asked for
+}
+
+// MemoryDiagnostics are the Go runtime's memory statistics, in bytes
+type MemoryDiagnostics struct {
+	HeapAlloc   uint64 `json:"heapAlloc"`   // Allocated heap objects
+	HeapInuse   uint64 `json:"heapInuse"`   // Heap spans in use
+	HeapObjects uint64 `json:"heapObjects"` // Number of allocated heap objects
+	StackInuse  uint64 `json:"stackInuse"`
+	Sys         uint64 `json:"sys"` // Obtained from the operating system
+	NumGC       uint32 `json:"numGC"`
+}
+
+// ProcessDiagnostic is something the server has running
+type ProcessDiagnostic struct {
+	Kind      string    `json:"kind"` // command, session, repl or sandbox
+	ID        string    `json:"id,omitempty"`
+	Command   string    `json:"command,omitempty"` // Command line, shell, interpreter or image
+	Tenant    string    `json:"tenant,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+	StartTime time.Time `json:"startTime"`
+	Busy      bool      `json:"busy,omitempty"` // Whether a session or REPL is running a command
+}
+
+// QueueDiagnostics are the messages and requests waiting on someone else
+type QueueDiagnostics struct {
+	StdioMessages    int    `json:"stdioMessages"`        // Messages not yet written to the stdio client
+	StdioBytes       int    `json:"stdioBytes"`           // Bytes of those messages
+	StdioIdleMs      int64  `json:"stdioIdleMs"`          // Time since the client last read, while messages wait
+	StdioError       string `json:"stdioError,omitempty"` // Why writes to the client fail, once they do
+	PendingApprovals int    `json:"pendingApprovals"`     // Commands waiting for a human
+	QueuedCommands   int    `json:"queuedCommands"`       // Interactive commands waiting for a slot
+	QueuedBatch      int    `json:"queuedBatch"`          // Batch commands waiting for a slot
+}
+
+// runningCommand is a command between the audit step and its result
+type runningCommand struct {
+	command   string
+	tenant    string
+	startTime time.Time
+	session   string // Session the command runs in, if any
+	stopper   *commandStopper
+}
+
+// WithProfiling serves the net/http/pprof endpoints on a Unix socket next to
+// the admin socket, named by adding PPROF_SOCKET_SUFFIX to its path. Like
+// the admin socket, only the server's user can open it.
+func WithProfiling() Option {
+	return func(s *ShellServer) error {
+		s.profiling = true
+		return nil
+	}
+}
+
+// listenProfiling serves the profiling endpoints in the background
+func (s *ShellServer) listenProfiling() error {
+	path := s.adminSocket + PPROF_SOCKET_SUFFIX
+	listener, err := listenPrivateSocket(path)
+	if err != nil {
+		return err
+	}
+	s.pprofListener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logger.Printf("Profiling endpoints stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// trackRunning records command as running, under the ID it gives
+// req.runningID, until the returned func is called
+func (s *ShellServer) trackRunning(req *ExecRequest) func() {
+	s.runningMutex.Lock()
+	defer s.runningMutex.Unlock()
+	s.runningCounter++
+	id := s.runningCounter
+	req.runningID = id
+	if s.running == nil {
+		s.running = make(map[int64]runningCommand)
+	}
+	if req.stopper == nil {
+		req.stopper = &commandStopper{}
+	}
+	running := runningCommand{command: req.Command, startTime: s.now(), session: req.Session, stopper: req.stopper}
+	if req.Client != nil {
+		running.tenant = req.Client.Subject
+	}
+	s.running[id] = running
+	return func() {
+		s.runningMutex.Lock()
+		delete(s.running, id)
+		s.runningMutex.Unlock()
+	}
+}
+
+// diagnostics collects the state of the server
+func (s *ShellServer) diagnostics(stacks bool) Diagnostics {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	d := Diagnostics{
+		Time:       s.now(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: MemoryDiagnostics{
+			HeapAlloc:   memory.HeapAlloc,
+			HeapInuse:   memory.HeapInuse,
+			HeapObjects: memory.HeapObjects,
+			StackInuse:  memory.StackInuse,
+			Sys:         memory.Sys,
+			NumGC:       memory.NumGC,
+		},
+		Processes: s.processDiagnostics(),
+		Reaper:    s.reaperStats(),
+	}
+	if s.retention > 0 {
+		retention := s.retentionStats()
+		d.Retention = &retention
+	}
+
+	if queue := s.stdioQueue.Load(); queue != nil {
+		d.Queues.StdioMessages, d.Queues.StdioBytes, d.Queues.StdioIdleMs, d.Queues.StdioError = queue.state()
+	}
+	if s.approvals != nil {
+		s.approvals.mutex.Lock()
+		d.Queues.PendingApprovals = len(s.approvals.pending)
+		s.approvals.mutex.Unlock()
+	}
+	if s.queue != nil {
+		d.Queues.QueuedCommands, d.Queues.QueuedBatch = s.queue.state()
+	}
+
+	if stacks {
+		var dump bytes.Buffer
+		runtimepprof.Lookup("goroutine").WriteTo(&dump, 2)
+		d.Stacks = dump.String()
+		if len(d.Stacks) > MAX_GOROUTINE_DUMP {
+			d.Stacks = d.Stacks[:MAX_GOROUTINE_DUMP] + "\n[goroutine dump truncated]"
+		}
+	}
+	return d
+}
+
+// processDiagnostics lists the commands, sessions, REPLs and sandboxes the
+// server has running, oldest first
+func (s *ShellServer) processDiagnostics() []ProcessDiagnostic {
+	processes := []ProcessDiagnostic{}
+
+	s.runningMutex.Lock()
+	for id, running := range s.running {
+		processes = append(processes, ProcessDiagnostic{Kind: "command", ID: fmt.Sprint(id), Command: running.command, Tenant: running.tenant, StartTime: running.startTime})
+	}
+	s.runningMutex.Unlock()
+
+	s.sessionMutex.Lock()
+	for _, session := range s.sessions {
+		busy := !session.runMutex.TryLock()
+		if !busy {
+			session.runMutex.Unlock()
+		}
+		processes = append(processes, ProcessDiagnostic{Kind: "session", ID: session.id, Command: session.shell + " (" + session.backend + ")", Tenant: session.tenant, StartTime: session.startTime, Busy: busy})
+	}
+	s.sessionMutex.Unlock()
+
+	s.replMutex.Lock()
+	for _, repl := range s.replSessions {
+		busy := !repl.evalMutex.TryLock()
+		if !busy {
+			repl.evalMutex.Unlock()
+		}
+		process := ProcessDiagnostic{Kind: "repl", ID: repl.id, Command: repl.interpreter, Tenant: repl.tenant, StartTime: repl.startTime, Busy: busy}
+		if repl.cmd.Process != nil {
+			process.PID = repl.cmd.Process.Pid
+		}
+		processes = append(processes, process)
+	}
+	s.replMutex.Unlock()
+
+	s.containerMutex.Lock()
+	for _, container := range s.containers {
+		processes = append(processes, ProcessDiagnostic{Kind: "sandbox", ID: container.ID, Command: container.Image, Tenant: container.Tenant, StartTime: container.StartTime})
+	}
+	s.containerMutex.Unlock()
+
+	sort.SliceStable(processes, func(i, j int) bool {
+		return processes[i].StartTime.Before(processes[j].StartTime)
+	})
+	return processes
+}
+
+func (s *ShellServer) handleDumpDiagnostics(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	stacks, _ := request.Params.Arguments["goroutines"].(bool)
+	d := s.diagnostics(stacks)
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Goroutines: %d\n", d.Goroutines))
+	result.WriteString(fmt.Sprintf("Memory: %s heap in use, %s from the system, %d GCs\n", formatByteSize(int64(d.Memory.HeapInuse)), formatByteSize(int64(d.Memory.Sys)), d.Memory.NumGC))
+	result.WriteString(fmt.Sprintf("Stdio queue: %d messages, %s", d.Queues.StdioMessages, formatByteSize(int64(d.Queues.StdioBytes))))
+	if d.Queues.StdioMessages > 0 {
+		result.WriteString(fmt.Sprintf(", client idle for %dms", d.Queues.StdioIdleMs))
+	}
+	if d.Queues.StdioError != "" {
+		result.WriteString(fmt.Sprintf(", failed: %s", d.Queues.StdioError))
+	}
+	result.WriteString(fmt.Sprintf("\nPending approvals: %d\n", d.Queues.PendingApprovals))
+	if s.queue != nil {
+		result.WriteString(fmt.Sprintf("Queued commands: %d interactive, %d batch\n", d.Queues.QueuedCommands, d.Queues.QueuedBatch))
+	}
+	result.WriteString(fmt.Sprintf("Reaped: %d orphan processes, %d idle sessions, %d temporary files, %d trash entries\n", d.Reaper.Zombies, d.Reaper.IdleSessions, d.Reaper.TempFiles, d.Reaper.TrashEntries))
+	if m := d.Retention; m != nil {
+		result.WriteString(fmt.Sprintf("Retention: %d history entries (%s), %d rotated audit files (%s), %d recordings (%s) removed\n",
+			m.HistoryEntries, formatByteSize(m.HistoryBytes), m.AuditFiles, formatByteSize(m.AuditBytes), m.Recordings, formatByteSize(m.RecordingBytes)))
+	}
+
+	result.WriteString(fmt.Sprintf("\nRunning (%d):\n", len(d.Processes)))
+	for _, p := range d.Processes {
+		result.WriteString(fmt.Sprintf("- %s %s: %s, for %s", p.Kind, p.ID, p.Command, d.Time.Sub(p.StartTime).Round(time.Millisecond)))
+		if p.PID != 0 {
+			result.WriteString(fmt.Sprintf(", pid %d", p.PID))
+		}
+		if p.Busy {
+			result.WriteString(", busy")
+		}
+		result.WriteString("\n")
+	}
+	if d.Stacks != "" {
+		result.WriteString("\nGoroutines:\n" + d.Stacks)
+	}
+	return jsonResult(result.String(), DIAGNOSTICS_URI, d), nil
+}
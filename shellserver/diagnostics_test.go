@@ -0,0 +1,101 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestDumpDiagnostics(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	block := func(next ExecFunc) ExecFunc {
+		return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+			close(started)
+			<-release
+			return next(ctx, req)
+		}
+	}
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithMiddleware(block))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	callTool(t, s.handleStartSession, map[string]interface{}{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "echo stuck"})
+	}()
+	<-started
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"goroutines": true}
+	result, _ := s.handleDumpDiagnostics(context.Background(), request)
+	var d Diagnostics
+	if err := json.Unmarshal([]byte(result.Content[1].(mcp.EmbeddedResource).Resource.(mcp.TextResourceContents).Text), &d); err != nil {
+		t.Fatalf("cannot decode the diagnostics: %v", err)
+	}
+	close(release)
+	<-done
+
+	kinds := map[string]string{}
+	for _, p := range d.Processes {
+		kinds[p.Kind] = p.Command
+	}
+	if kinds["command"] != "echo stuck" {
+		t.Errorf("running command = %q, want 'echo stuck'", kinds["command"])
+	}
+	if _, found := kinds["session"]; !found {
+		t.Errorf("processes = %+v, want the session", d.Processes)
+	}
+	if d.Goroutines == 0 || d.Memory.Sys == 0 {
+		t.Errorf("goroutines = %d, sys = %d, want them measured", d.Goroutines, d.Memory.Sys)
+	}
+	if !strings.Contains(d.Stacks, "goroutine ") {
+		t.Errorf("stacks = %.100q, want a goroutine dump", d.Stacks)
+	}
+
+	// The command is forgotten once it finishes
+	if processes := s.processDiagnostics(); len(processes) != 1 || processes[0].Kind != "session" {
+		t.Errorf("processes after the command = %+v, want only the session", processes)
+	}
+}
+
+func TestProfiling(t *testing.T) {
+	if _, err := NewShellServer(WithProfiling()); err == nil {
+		t.Errorf("WithProfiling without an admin socket succeeded, want an error")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("the profiling endpoints are served on a Unix socket")
+	}
+
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	s, err := NewShellServer(WithAdminSocket(path), WithProfiling())
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path+PPROF_SOCKET_SUFFIX)
+		},
+	}}
+	response, err := client.Get("http://localhost/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatalf("cannot fetch a profile: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine profile") {
+		t.Errorf("goroutine profile = %d %.100q", response.StatusCode, body)
+	}
+}
@@ -0,0 +1,194 @@
+package shellserver
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest settings
+const (
+	DEFAULT_DIGEST_INTERVAL = 24 * time.Hour // Period covered by each digest
+	DIGEST_MAX_ITEMS        = 50             // Commands itemized per section
+)
+
+// digestWindow aggregates activity for one digest period
+type digestWindow struct {
+	start      time.Time
+	executions int
+	failures   int
+	timeouts   int
+	denials    int
+	highRisk   int
+	failed     []string
+	denied     []string
+	risky      []string
+}
+
+// activityDigest periodically emails a summary of executions, failures,
+// denials and high-risk commands
+type activityDigest struct {
+	smtpAddr string
+	from     string
+	to       []string
+	auth     smtp.Auth
+	interval time.Duration
+	highRisk func(command string) bool
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+	logger   *log.Logger
+
+	mutex  sync.Mutex
+	window digestWindow
+}
+
+// newActivityDigest configures a digest sent over SMTP. Credentials come from
+// MCP_SHELL_SMTP_USER and MCP_SHELL_SMTP_PASSWORD so they stay out of process listings.
+func newActivityDigest(smtpAddr string, from string, to string, interval time.Duration, highRisk func(string) bool) (*activityDigest, error) {
+	host, _, found := strings.Cut(smtpAddr, ":")
+	if !found || host == "" {
+		return nil, fmt.Errorf("SMTP address must be host:port")
+	}
+	if from == "" {
+		return nil, fmt.Errorf("a sender address is required")
+	}
+
+	var recipients []string
+	for _, addr := range strings.Split(to, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			recipients = append(recipients, addr)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+	if interval <= 0 {
+		interval = DEFAULT_DIGEST_INTERVAL
+	}
+
+	d := &activityDigest{
+		smtpAddr: smtpAddr,
+		from:     from,
+		to:       recipients,
+		interval: interval,
+		highRisk: highRisk,
+		sendMail: smtp.SendMail,
+		logger:   log.Default(),
+		window:   digestWindow{start: time.Now()},
+	}
+	// The credentials are removed from the environment once read, so
+	// commands do not inherit them
+	user, password := os.Getenv("MCP_SHELL_SMTP_USER"), os.Getenv("MCP_SHELL_SMTP_PASSWORD")
+	os.Unsetenv("MCP_SHELL_SMTP_USER")
+	os.Unsetenv("MCP_SHELL_SMTP_PASSWORD")
+	if user != "" {
+		d.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return d, nil
+}
+
+// appendCapped adds an item to a list unless it is already full
+func appendCapped(items []string, item string) []string {
+	if len(items) >= DIGEST_MAX_ITEMS {
+		return items
+	}
+	return append(items, item)
+}
+
+// Notify adds a command event to the current window
+func (d *activityDigest) Notify(event CommandEvent) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	execution := event.Execution
+	stamp := event.Timestamp.Format(time.RFC3339)
+	switch event.Event {
+	case EVENT_FINISH, EVENT_TIMEOUT:
+		d.window.executions++
+		if event.Event == EVENT_TIMEOUT {
+			d.window.timeouts++
+		}
+		if execution.ExitCode != 0 {
+			d.window.failures++
+			d.window.failed = appendCapped(d.window.failed,
+				fmt.Sprintf("[%s] %s (exit %d)", stamp, execution.Command, execution.ExitCode))
+		}
+		if d.highRisk != nil && d.highRisk(execution.Command) {
+			d.window.highRisk++
+			d.window.risky = appendCapped(d.window.risky,
+				fmt.Sprintf("[%s] %s (exit %d)", stamp, execution.Command, execution.ExitCode))
+		}
+	case EVENT_DENIAL:
+		d.window.denials++
+		d.window.denied = appendCapped(d.window.denied,
+			fmt.Sprintf("[%s] %s: %s", stamp, execution.Command, event.Reason))
+	}
+	return nil
+}
+
+// run sends a digest every interval until the process exits
+func (d *activityDigest) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := d.flush(now); err != nil {
+			d.logger.Printf("Failed to send activity digest: %v", err)
+		}
+	}
+}
+
+// flush sends the digest for the current window and starts a new one.
+// Windows without any activity are skipped.
+func (d *activityDigest) flush(now time.Time) error {
+	d.mutex.Lock()
+	window := d.window
+	d.window = digestWindow{start: now}
+	d.mutex.Unlock()
+
+	if window.executions == 0 && window.denials == 0 {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	subject := fmt.Sprintf("MCP shell activity on %s: %d executions, %d failures, %d denials",
+		hostname, window.executions, window.failures, window.denials)
+	message := fmt.Sprintf("From: %s\nTo: %s\nSubject: %s\nContent-Type: text/plain; charset=utf-8\n\n%s",
+		d.from, strings.Join(d.to, ", "), subject, buildDigestBody(window, now, hostname))
+
+	return d.sendMail(d.smtpAddr, d.auth, d.from, d.to, []byte(strings.ReplaceAll(message, "\n", "\r\n")))
+}
+
+// buildDigestBody renders a window as plain text
+func buildDigestBody(window digestWindow, end time.Time, hostname string) string {
+	var body strings.Builder
+	body.WriteString(fmt.Sprintf("MCP shell activity on %s from %s to %s\n\n",
+		hostname, window.start.Format(time.RFC3339), end.Format(time.RFC3339)))
+	body.WriteString(fmt.Sprintf("Executions: %d\nFailures:   %d\nTimeouts:   %d\nDenials:    %d\nHigh-risk:  %d\n",
+		window.executions, window.failures, window.timeouts, window.denials, window.highRisk))
+
+	sections := []struct {
+		title string
+		total int
+		items []string
+	}{
+		{"Failed commands", window.failures, window.failed},
+		{"Denied commands", window.denials, window.denied},
+		{"High-risk commands", window.highRisk, window.risky},
+	}
+	for _, section := range sections {
+		if len(section.items) == 0 {
+			continue
+		}
+		body.WriteString(fmt.Sprintf("\n%s:\n", section.title))
+		for _, item := range section.items {
+			body.WriteString("  - " + item + "\n")
+		}
+		if section.total > len(section.items) {
+			body.WriteString(fmt.Sprintf("  ... and %d more\n", section.total-len(section.items)))
+		}
+	}
+	return body.String()
+}
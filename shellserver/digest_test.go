@@ -0,0 +1,123 @@
+package shellserver
+
+import (
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewActivityDigestValidation(t *testing.T) {
+	tests := []struct {
+		addr, from, to string
+		wantErr        bool
+	}{
+		{"mail.example.com:587", "agent@example.com", "ops@example.com", false},
+		{"mail.example.com", "agent@example.com", "ops@example.com", true},
+		{"mail.example.com:587", "", "ops@example.com", true},
+		{"mail.example.com:587", "agent@example.com", " , ", true},
+	}
+
+	for _, tt := range tests {
+		_, err := newActivityDigest(tt.addr, tt.from, tt.to, time.Hour, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newActivityDigest(%q, %q, %q) error = %v, wantErr %v", tt.addr, tt.from, tt.to, err, tt.wantErr)
+		}
+	}
+}
+
+func TestActivityDigestTakesSMTPCredentials(t *testing.T) {
+	t.Setenv("MCP_SHELL_SMTP_USER", "agent")
+	t.Setenv("MCP_SHELL_SMTP_PASSWORD", "hunter2")
+
+	d, err := newActivityDigest("mail.example.com:587", "agent@example.com", "ops@example.com", time.Hour, nil)
+	if err != nil {
+		t.Fatalf("newActivityDigest failed: %v", err)
+	}
+	if d.auth == nil {
+		t.Errorf("the digest has no SMTP credentials")
+	}
+	for _, name := range []string{"MCP_SHELL_SMTP_USER", "MCP_SHELL_SMTP_PASSWORD"} {
+		if _, set := os.LookupEnv(name); set {
+			t.Errorf("%s is still in the environment", name)
+		}
+	}
+}
+
+func TestActivityDigestFlush(t *testing.T) {
+	d, err := newActivityDigest("mail.example.com:25", "agent@example.com", "ops@example.com, sec@example.com", time.Hour,
+		func(command string) bool { return strings.HasPrefix(command, "rm ") })
+	if err != nil {
+		t.Fatalf("newActivityDigest failed: %v", err)
+	}
+
+	var sent []string
+	var recipients []string
+	d.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, string(msg))
+		recipients = to
+		return nil
+	}
+
+	// An empty window sends nothing
+	if err := d.flush(time.Now()); err != nil || len(sent) != 0 {
+		t.Fatalf("flush of empty window sent %d messages, err %v", len(sent), err)
+	}
+
+	now := time.Now()
+	d.Notify(CommandEvent{Event: EVENT_START, Timestamp: now, Execution: CommandExecution{Command: "ls"}})
+	d.Notify(CommandEvent{Event: EVENT_FINISH, Timestamp: now, Execution: CommandExecution{Command: "ls"}})
+	d.Notify(CommandEvent{Event: EVENT_FINISH, Timestamp: now, Execution: CommandExecution{Command: "rm -r build", ExitCode: 1}})
+	d.Notify(CommandEvent{Event: EVENT_TIMEOUT, Timestamp: now, Execution: CommandExecution{Command: "sleep 99", ExitCode: 124}})
+	d.Notify(CommandEvent{Event: EVENT_DENIAL, Timestamp: now, Execution: CommandExecution{Command: "curl evil"}, Reason: "not allowed"})
+
+	if err := d.flush(now); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("flush sent %d messages, want 1", len(sent))
+	}
+	if len(recipients) != 2 {
+		t.Errorf("recipients = %v, want 2 addresses", recipients)
+	}
+
+	msg := sent[0]
+	for _, want := range []string{
+		"Subject: MCP shell activity on",
+		"3 executions, 2 failures, 1 denials",
+		"Executions: 3",
+		"Timeouts:   1",
+		"High-risk:  1",
+		"rm -r build (exit 1)",
+		"sleep 99 (exit 124)",
+		"curl evil: not allowed",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("digest is missing %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "\r\r") || strings.Contains(strings.ReplaceAll(msg, "\r\n", ""), "\n") {
+		t.Errorf("digest lines should end in CRLF")
+	}
+
+	// The window resets after each digest
+	if err := d.flush(time.Now()); err != nil || len(sent) != 1 {
+		t.Errorf("second flush sent %d messages, want none", len(sent)-1)
+	}
+}
+
+func TestBuildDigestBodyTruncates(t *testing.T) {
+	window := digestWindow{start: time.Now(), executions: DIGEST_MAX_ITEMS + 5, failures: DIGEST_MAX_ITEMS + 5}
+	for i := 0; i < DIGEST_MAX_ITEMS+5; i++ {
+		window.failed = appendCapped(window.failed, "false")
+	}
+
+	body := buildDigestBody(window, time.Now(), "host")
+	if len(window.failed) != DIGEST_MAX_ITEMS {
+		t.Errorf("appendCapped kept %d items, want %d", len(window.failed), DIGEST_MAX_ITEMS)
+	}
+	if !strings.Contains(body, "... and 5 more") {
+		t.Errorf("body should note truncated items:\n%s", body)
+	}
+}
@@ -0,0 +1,317 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discovery adds the SSH hosts a registry file, Consul's catalog or mDNS
+// know of to those of the targets file, and refreshes them at an interval.
+// Discovered hosts carry labels, which selectors and label policies match;
+// a source that fails keeps the hosts it found last.
+
+// Kinds of discovery sources
+const (
+	DISCOVERY_FILE   = "file"
+	DISCOVERY_CONSUL = "consul"
+	DISCOVERY_MDNS   = "mdns"
+)
+
+// Discovery settings
+const (
+	DEFAULT_DISCOVERY_INTERVAL = time.Minute
+	DISCOVERY_TIMEOUT          = 10 * time.Second // How long one source may take to answer
+	DEFAULT_CONSUL_ADDR        = "http://127.0.0.1:8500"
+	DEFAULT_MDNS_SERVICE       = "_ssh._tcp"
+)
+
+// DiscoverySource finds SSH hosts commands can run on
+type DiscoverySource interface {
+	// Discover returns the hosts the source knows of now, by name
+	Discover(ctx context.Context) (map[string]SSHHost, error)
+	// String describes the source for logs
+	String() string
+}
+
+// ParseDiscoverySource parses a --discover value: 'file:<path>' for a
+// registry file, 'consul:<service>' or 'consul:<url>/<service>' for the
+// healthy instances of a Consul service, or 'mdns:<service>' for the hosts
+// announcing a DNS-SD service on the local network, '_ssh._tcp' if empty
+func ParseDiscoverySource(spec string) (DiscoverySource, error) {
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case DISCOVERY_FILE:
+		if target == "" {
+			return nil, fmt.Errorf("file discovery needs a path, e.g. 'file:/etc/mcp-shell/registry.json'")
+		}
+		return registryFile{path: target}, nil
+	case DISCOVERY_CONSUL:
+		return newConsulCatalog(target)
+	case DISCOVERY_MDNS:
+		if target == "" {
+			target = DEFAULT_MDNS_SERVICE
+		}
+		if !mdnsService.MatchString(target) {
+			return nil, fmt.Errorf("invalid DNS-SD service '%s', expected e.g. '%s'", target, DEFAULT_MDNS_SERVICE)
+		}
+		return mdnsBrowser{service: target}, nil
+	}
+	return nil, fmt.Errorf("unknown discovery source '%s', expected one of: %s, %s, %s", kind, DISCOVERY_FILE, DISCOVERY_CONSUL, DISCOVERY_MDNS)
+}
+
+// registryFile reads hosts from a JSON file in the format of the targets
+// file's hosts: {"hosts": {"build1": {"address": "10.0.0.5", "labels":
+// {"role": "build"}}}}. Other tools can rewrite it as machines come and go.
+type registryFile struct {
+	path string
+}
+
+func (r registryFile) Discover(ctx context.Context) (map[string]SSHHost, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var file struct {
+		Hosts map[string]SSHHost `json:"hosts"`
+	}
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid registry file: %v", err)
+	}
+	for name, host := range file.Hosts {
+		if host.Policy != nil {
+			return nil, fmt.Errorf("host '%s': discovered hosts cannot have a policy; use labelPolicies in the targets file", name)
+		}
+	}
+	return file.Hosts, nil
+}
+
+func (r registryFile) String() string {
+	return DISCOVERY_FILE + ":" + r.path
+}
+
+// consulCatalog finds the instances of a service that pass their Consul
+// health checks. Each is named after its node, and labeled with its
+// service metadata and its key=value tags.
+type consulCatalog struct {
+	addr    string // Consul's HTTP API, e.g. http://127.0.0.1:8500
+	service string
+	token   string // ACL token from CONSUL_HTTP_TOKEN, if set
+	client  *http.Client
+}
+
+// newConsulCatalog parses '<service>' or '<url>/<service>'; without a URL
+// CONSUL_HTTP_ADDR or DEFAULT_CONSUL_ADDR is used
+func newConsulCatalog(target string) (*consulCatalog, error) {
+	addr, service := os.Getenv("CONSUL_HTTP_ADDR"), target
+	if strings.Contains(target, "://") {
+		slash := strings.LastIndex(target, "/")
+		addr, service = target[:slash], target[slash+1:]
+	}
+	if addr == "" {
+		addr = DEFAULT_CONSUL_ADDR
+	} else if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if _, err := url.Parse(addr); err != nil || service == "" || strings.Contains(service, "/") {
+		return nil, fmt.Errorf("consul discovery needs a service, e.g. 'consul:ssh' or 'consul:http://consul:8500/ssh', got '%s'", target)
+	}
+	return &consulCatalog{addr: strings.TrimSuffix(addr, "/"), service: service, token: os.Getenv("CONSUL_HTTP_TOKEN"), client: &http.Client{}}, nil
+}
+
+func (c *consulCatalog) Discover(ctx context.Context) (map[string]SSHHost, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", c.addr+"/v1/health/service/"+url.PathEscape(c.service)+"?passing=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		request.Header.Set("X-Consul-Token", c.token)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned %s", response.Status)
+	}
+	var entries []struct {
+		Node struct {
+			Node    string
+			Address string
+		}
+		Service struct {
+			ID      string
+			Address string
+			Port    int
+			Tags    []string
+			Meta    map[string]string
+		}
+	}
+	if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid consul response: %v", err)
+	}
+
+	hosts := make(map[string]SSHHost)
+	for _, entry := range entries {
+		host := SSHHost{Address: entry.Service.Address, Port: entry.Service.Port, Labels: map[string]string{}}
+		if host.Address == "" {
+			host.Address = entry.Node.Address
+		}
+		for key, value := range entry.Service.Meta {
+			host.Labels[key] = value
+		}
+		for _, tag := range entry.Service.Tags {
+			if key, value, found := strings.Cut(tag, "="); found {
+				host.Labels[key] = value
+			}
+		}
+		validLabels(host.Labels)
+		name := targetNameFor(entry.Node.Node)
+		if _, taken := hosts[name]; taken {
+			// Several instances on one node
+			name = targetNameFor(entry.Node.Node + "-" + entry.Service.ID)
+		}
+		hosts[name] = host
+	}
+	return hosts, nil
+}
+
+func (c *consulCatalog) String() string {
+	return DISCOVERY_CONSUL + ":" + c.addr + "/" + c.service
+}
+
+// invalidNameChars matches what host names may not contain
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// targetNameFor makes a discovered name a valid host name
+func targetNameFor(name string) string {
+	return strings.Trim(invalidNameChars.ReplaceAllString(name, "-"), "-._")
+}
+
+// validLabels removes the labels that are not valid keys and values
+func validLabels(labels map[string]string) {
+	for key, value := range labels {
+		if !labelName.MatchString(key) || !labelName.MatchString(value) {
+			delete(labels, key)
+		}
+	}
+}
+
+// discovery is the state of target discovery
+type discovery struct {
+	sources  []DiscoverySource
+	interval time.Duration
+	found    []map[string]SSHHost // Hosts each source found last
+	stop     chan struct{}
+	mutex    sync.Mutex // Serializes refreshes
+}
+
+// WithTargetDiscovery adds the hosts sources find to the targets, and looks
+// for them again at every interval. Names of the targets file win over
+// discovered ones, and earlier sources over later ones.
+func WithTargetDiscovery(sources []DiscoverySource, interval time.Duration) Option {
+	return func(s *ShellServer) error {
+		if len(sources) == 0 {
+			return fmt.Errorf("no discovery sources given")
+		}
+		if interval <= 0 {
+			return fmt.Errorf("discovery interval must be positive, got %s", interval)
+		}
+		s.discovery = &discovery{sources: sources, interval: interval, found: make([]map[string]SSHHost, len(sources))}
+		if s.targets == nil {
+			s.targets = &Targets{}
+		}
+		return nil
+	}
+}
+
+// currentTargets returns the configured targets with the hosts discovered
+// last. The result must not be changed.
+func (s *ShellServer) currentTargets() *Targets {
+	if live := s.liveTargets.Load(); live != nil {
+		return live
+	}
+	return s.targets
+}
+
+// runDiscovery refreshes the discovered hosts now and then at every
+// interval, until stop is closed
+func (s *ShellServer) runDiscovery(stop chan struct{}) {
+	ticker := time.NewTicker(s.discovery.interval)
+	defer ticker.Stop()
+	for {
+		s.refreshTargets()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// refreshTargets asks every source for its hosts and makes them targets
+func (s *ShellServer) refreshTargets() {
+	s.discovery.mutex.Lock()
+	defer s.discovery.mutex.Unlock()
+	for i, source := range s.discovery.sources {
+		ctx, cancel := context.WithTimeout(context.Background(), DISCOVERY_TIMEOUT)
+		hosts, err := source.Discover(ctx)
+		cancel()
+		if err != nil {
+			s.logger.Printf("Discovery from %s failed, keeping its %d hosts: %v", source, len(s.discovery.found[i]), err)
+			continue
+		}
+		s.discovery.found[i] = hosts
+	}
+
+	live := *s.targets
+	live.Hosts = make(map[string]SSHHost, len(s.targets.Hosts))
+	for name, host := range s.targets.Hosts {
+		live.Hosts[name] = host
+	}
+	for i, hosts := range s.discovery.found {
+		names := make([]string, 0, len(hosts))
+		for name := range hosts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if live.has(name) || s.targets.Groups[name] != nil {
+				continue
+			}
+			if err := checkHost(name, hosts[name]); err != nil {
+				s.logger.Printf("Discovery from %s: skipping %v", s.discovery.sources[i], err)
+				continue
+			}
+			live.Hosts[name] = hosts[name]
+		}
+	}
+
+	previous := s.currentTargets()
+	for name := range live.Hosts {
+		if _, found := previous.Hosts[name]; !found {
+			s.logger.Printf("Discovered target %s (%s)", name, live.Hosts[name].Address)
+		}
+	}
+	for name := range previous.Hosts {
+		if _, found := live.Hosts[name]; !found {
+			s.logger.Printf("Target %s is no longer discovered", name)
+		}
+	}
+	if s.sshPool != nil {
+		s.sshPool.add(&live)
+	}
+	s.liveTargets.Store(&live)
+}
@@ -0,0 +1,227 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDiscoverySource(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"file:/etc/mcp-shell/registry.json", "file:/etc/mcp-shell/registry.json", false},
+		{"file:", "", true},
+		{"consul:http://consul:8500/ssh", "consul:http://consul:8500/ssh", false},
+		{"consul:http://consul:8500/", "", true},
+		{"mdns:", "mdns:_ssh._tcp", false},
+		{"mdns:_build._tcp", "mdns:_build._tcp", false},
+		{"mdns:ssh", "", true},
+		{"dns:_ssh._tcp", "", true},
+	}
+
+	for _, tt := range tests {
+		source, err := ParseDiscoverySource(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDiscoverySource(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err == nil && source.String() != tt.want {
+			t.Errorf("ParseDiscoverySource(%q) = %s, want %s", tt.spec, source, tt.want)
+		}
+	}
+}
+
+func TestTargetsNeedHostsOrDiscovery(t *testing.T) {
+	if _, err := NewShellServer(WithTargets(&Targets{})); err == nil {
+		t.Errorf("NewShellServer accepted targets without hosts or discovery")
+	}
+	s, err := NewShellServer(WithTargetDiscovery([]DiscoverySource{registryFile{path: "/nonexistent"}}, time.Hour))
+	if err != nil {
+		t.Fatalf("NewShellServer with only discovery failed: %v", err)
+	}
+	s.Close()
+}
+
+func TestRegistryDiscovery(t *testing.T) {
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "ssh"), []byte(fakeSSH), 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	registry := filepath.Join(t.TempDir(), "registry.json")
+	os.WriteFile(registry, []byte(`{"hosts": {
+		"build1": {"address": "build1", "labels": {"role": "build", "os": "linux"}},
+		"web1": {"address": "elsewhere"}
+	}}`), 0644)
+	targets := testTargets()
+	targets.LabelPolicies = []LabelPolicy{{Selector: "role=build", Policy: &PolicyRules{Allow: []string{"printf"}}}}
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithTargets(targets), WithTargetDiscovery([]DiscoverySource{registryFile{path: registry}}, time.Hour))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	s.refreshTargets()
+
+	// Hosts of the targets file win over discovered ones
+	text, _ := callTool(t, s.handleListTargets, nil)
+	for _, want := range []string{"Hosts (4)", "- build1 (build1, discovered): ", "[os=linux,role=build]", "- web1 (web1): "} {
+		if !strings.Contains(text, want) {
+			t.Errorf("list_targets = %q, want %q", text, want)
+		}
+	}
+	if text, _ := callTool(t, s.handleListTargets, map[string]interface{}{"selector": "role=build"}); strings.Contains(text, "web1") {
+		t.Errorf("list_targets with selector = %q, want only build1", text)
+	}
+
+	execute := s.authorized("execute_command", s.handleExecuteCommand)
+	tests := []struct {
+		tool    string
+		args    map[string]interface{}
+		want    string
+		isError bool
+	}{
+		// The label policy allows printf on build hosts only
+		{"execute_command", map[string]interface{}{"command": "printf on-$TARGET_HOST", "target_selector": "role=build"}, "on-build1", false},
+		{"execute_command", map[string]interface{}{"command": "printf on-$TARGET_HOST", "target": "web1"}, "not in the allowed list", true},
+		{"execute_command", map[string]interface{}{"command": "echo hi", "target": "web1", "target_selector": "role=build"}, "either", true},
+		{"execute_on_targets", map[string]interface{}{"command": "echo on $TARGET_HOST", "selector": "os=linux"}, "1 of 1 hosts succeeded", false},
+		{"execute_on_targets", map[string]interface{}{"command": "echo on $TARGET_HOST", "targets": "web", "selector": "role=build"}, "no hosts or agents match", true},
+		{"execute_on_targets", map[string]interface{}{"command": "echo on $TARGET_HOST", "selector": "role"}, "invalid selector", true},
+	}
+	for _, tt := range tests {
+		handler := execute
+		if tt.tool == "execute_on_targets" {
+			handler = s.handleExecuteOnTargets
+		}
+		text, isError := callTool(t, handler, tt.args)
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s %v = %q (error %v), want %q", tt.tool, tt.args, text, isError, tt.want)
+		}
+	}
+
+	// A registry that cannot be read keeps its hosts; an empty one drops them
+	os.WriteFile(registry, []byte(`{"hosts": `), 0644)
+	s.refreshTargets()
+	if !s.currentTargets().has("build1") {
+		t.Errorf("build1 was dropped when the registry could not be read")
+	}
+	os.WriteFile(registry, []byte(`{"hosts": {}}`), 0644)
+	s.refreshTargets()
+	text, _ = callTool(t, execute, map[string]interface{}{"command": "echo hi", "target": "build1"})
+	if !strings.Contains(text, "unknown host or agent 'build1'") {
+		t.Errorf("execute_command on a host no longer discovered = %q", text)
+	}
+}
+
+func TestRegistryRejectsPolicy(t *testing.T) {
+	registry := filepath.Join(t.TempDir(), "registry.json")
+	os.WriteFile(registry, []byte(`{"hosts": {"build1": {"address": "build1", "policy": {"allow": ["rm"]}}}}`), 0644)
+	if _, err := (registryFile{path: registry}).Discover(context.Background()); err == nil || !strings.Contains(err.Error(), "labelPolicies") {
+		t.Errorf("Discover of a host with a policy: error = %v", err)
+	}
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/ssh" || r.URL.Query().Get("passing") != "true" || r.Header.Get("X-Consul-Token") != "acl-token" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`[
+			{"Node": {"Node": "build1", "Address": "10.0.0.5"}, "Service": {"ID": "ssh", "Port": 22, "Tags": ["role=build", "primary", "bad=a b"], "Meta": {"os": "linux"}}},
+			{"Node": {"Node": "build1", "Address": "10.0.0.5"}, "Service": {"ID": "ssh-alt", "Address": "10.0.1.5", "Port": 2222}}
+		]`))
+	}))
+	defer consul.Close()
+	t.Setenv("CONSUL_HTTP_TOKEN", "acl-token")
+
+	source, err := ParseDiscoverySource("consul:" + consul.URL + "/ssh")
+	if err != nil {
+		t.Fatalf("ParseDiscoverySource failed: %v", err)
+	}
+	hosts, err := source.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("Discover = %+v, want 2 hosts", hosts)
+	}
+	if host := hosts["build1"]; host.Address != "10.0.0.5" || host.Labels["role"] != "build" || host.Labels["os"] != "linux" || len(host.Labels) != 2 {
+		t.Errorf("build1 = %+v, want 10.0.0.5 with role=build and os=linux", host)
+	}
+	if host := hosts["build1-ssh-alt"]; host.Address != "10.0.1.5" || host.Port != 2222 {
+		t.Errorf("build1-ssh-alt = %+v, want the service's address and port", host)
+	}
+
+	t.Setenv("CONSUL_HTTP_TOKEN", "")
+	source, _ = ParseDiscoverySource("consul:" + consul.URL + "/ssh")
+	if _, err := source.Discover(context.Background()); err == nil {
+		t.Errorf("Discover without the token succeeded")
+	}
+}
+
+// dnsName encodes a name without compression
+func dnsName(name string) []byte {
+	var encoded []byte
+	for _, label := range strings.Split(name, ".") {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	return append(encoded, 0)
+}
+
+// dnsRecord encodes a resource record
+func dnsRecord(name []byte, recordType uint16, data []byte) []byte {
+	record := append([]byte{}, name...)
+	record = binary.BigEndian.AppendUint16(record, recordType)
+	record = binary.BigEndian.AppendUint16(record, dnsClassIN)
+	record = binary.BigEndian.AppendUint32(record, 120)
+	record = binary.BigEndian.AppendUint16(record, uint16(len(data)))
+	return append(record, data...)
+}
+
+func TestMDNSRecords(t *testing.T) {
+	query := mdnsQuery("_ssh._tcp.local.")
+	if name, _, err := readDNSName(query, 12); err != nil || name != "_ssh._tcp.local" {
+		t.Errorf("mdnsQuery asks for %q (%v)", name, err)
+	}
+
+	// The service's name is at offset 12, which later names point to
+	instance := append([]byte{9}, "Build Box"...)
+	instance = append(instance, 0xC0, 12)
+	srv := []byte{0, 0, 0, 0, 0x08, 0xAE} // Port 2222
+	srv = append(srv, dnsName("buildbox.local")...)
+	packet := []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 3}
+	packet = append(packet, dnsRecord(dnsName("_ssh._tcp.local"), dnsTypePTR, instance)...)
+	packet = append(packet, dnsRecord(instance, dnsTypeSRV, srv)...)
+	packet = append(packet, dnsRecord(instance, dnsTypeTXT, []byte("\x0arole=build\x07primary"))...)
+	packet = append(packet, dnsRecord(dnsName("buildbox.local"), dnsTypeA, []byte{10, 0, 0, 7})...)
+
+	records := newMDNSRecords()
+	if err := records.parse(packet); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	hosts := records.hosts("_ssh._tcp.local")
+	host, found := hosts["build-box"]
+	if len(hosts) != 1 || !found || host.Address != "10.0.0.7" || host.Port != 2222 || host.Labels["role"] != "build" || len(host.Labels) != 1 {
+		t.Errorf("hosts = %+v, want build-box at 10.0.0.7:2222 with role=build", hosts)
+	}
+	if err := checkHost("build-box", host); err != nil {
+		t.Errorf("discovered host is invalid: %v", err)
+	}
+
+	// Malformed packets are refused without panicking
+	loop := []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0xC0, 12}
+	for _, bad := range [][]byte{packet[:5], packet[:len(packet)-3], loop} {
+		if err := newMDNSRecords().parse(bad); err == nil {
+			t.Errorf("parse(%x) succeeded", bad)
+		}
+	}
+}
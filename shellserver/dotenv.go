@@ -0,0 +1,146 @@
+package shellserver
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// dotenvFiles are the files variables are loaded from, in order; a
+// variable in a later file replaces one in an earlier file
+var dotenvFiles = []string{".env", ".envrc"}
+
+// WithDotenv gives commands the variables named by patterns, e.g.
+// "DATABASE_URL" or "APP_*", from the .env and .envrc files of the
+// directory they run in. Without it the files are not read.
+func WithDotenv(patterns []string) Option {
+	return func(s *ShellServer) error {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" || strings.ContainsAny(pattern, "=/") {
+				return fmt.Errorf("invalid variable name pattern '%s'", pattern)
+			}
+		}
+		s.dotenvNames = patterns
+		return nil
+	}
+}
+
+// dotenvAllowed reports whether a variable matches an allowed pattern
+func (s *ShellServer) dotenvAllowed(name string) bool {
+	for _, pattern := range s.dotenvNames {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// dotenv returns the allowed variables of the .env and .envrc files in dir
+// as NAME=value entries. Variables the policy sets are left out, as the
+// policy's env takes precedence.
+func (s *ShellServer) dotenv(dir string) []string {
+	configured := make(map[string]bool)
+	for _, entry := range s.control.env {
+		name, _, _ := strings.Cut(entry, "=")
+		configured[name] = true
+	}
+	values := make(map[string]string)
+	var names []string
+	for _, file := range dotenvFiles {
+		data := readTaskFile(dir, file)
+		for _, entry := range parseDotenv(data) {
+			name, value, _ := strings.Cut(entry, "=")
+			if !s.dotenvAllowed(name) || configured[name] {
+				continue
+			}
+			if _, found := values[name]; !found {
+				names = append(names, name)
+			}
+			values[name] = value
+		}
+	}
+
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, name+"="+values[name])
+	}
+	return env
+}
+
+// parseDotenv returns the assignments of a .env file, or of an .envrc as
+// far as it is one, as NAME=value entries. Lines may start with "export".
+// Values may be single-quoted, taken literally, or double-quoted, where
+// backslash escapes \n, \", \\ and \$. Other lines, such as the direnv
+// commands of an .envrc, and values that expand variables or commands,
+// which only a shell could evaluate, are skipped.
+func parseDotenv(data []byte) []string {
+	var env []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimLeft(strings.TrimPrefix(line, "export "), " \t")
+		name, raw, found := strings.Cut(line, "=")
+		if !found || !envNamePattern.MatchString(name) {
+			continue
+		}
+		if value, ok := dotenvValue(raw); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// dotenvValue unquotes the value of an assignment. It is not ok if the
+// value is malformed or expands anything.
+func dotenvValue(raw string) (string, bool) {
+	// rest must be empty or a comment after the value
+	trailing := func(rest string) bool {
+		rest = strings.TrimLeft(rest, " \t")
+		return rest == "" || strings.HasPrefix(rest, "#")
+	}
+
+	switch {
+	case strings.HasPrefix(raw, "'"):
+		value, rest, found := strings.Cut(raw[1:], "'")
+		return value, found && trailing(rest)
+	case strings.HasPrefix(raw, `"`):
+		var value strings.Builder
+		for i := 1; i < len(raw); i++ {
+			switch c := raw[i]; c {
+			case '"':
+				return value.String(), trailing(raw[i+1:])
+			case '$', '`':
+				return "", false
+			case '\\':
+				if i+1 == len(raw) {
+					return "", false
+				}
+				i++
+				switch raw[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case '"', '\\', '$', '`':
+					value.WriteByte(raw[i])
+				default:
+					value.WriteByte('\\')
+					value.WriteByte(raw[i])
+				}
+			default:
+				value.WriteByte(c)
+			}
+		}
+		return "", false
+	}
+
+	value := raw
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, "$`'\"\\") {
+		return "", false
+	}
+	return value, true
+}
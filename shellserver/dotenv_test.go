@@ -0,0 +1,80 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"plain", "A=1\nB=two words # comment", []string{"A=1", "B=two words"}},
+		{"export", "export A=1\nexport\tB=2", []string{"A=1"}},
+		{"single quotes", `A='$HOME "x" \n'`, []string{`A=$HOME "x" \n`}},
+		{"double quotes", `A="a\nb \"c\" \$d \\ \w" # comment`, []string{"A=a\nb \"c\" $d \\ \\w"}},
+		{"empty", "A=\nB=''", []string{"A=", "B="}},
+		{"comments and blanks", "# A=1\n\n  B=2  ", []string{"B=2"}},
+		{"expansions", "A=$HOME/bin\nB=\"${X}\"\nC=`id`\nD=\"$(id)\"", nil},
+		{"unterminated", "A='x\nB=\"y", nil},
+		{"trailing text", "A='x' y\nB=\"x\"y", nil},
+		{"direnv commands", "dotenv\nPATH_add bin\nsource_up\nuse nix", nil},
+		{"invalid name", "1A=1\nA-B=2\n=3", nil},
+	}
+
+	for _, tt := range tests {
+		if got := parseDotenv([]byte(tt.input)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseDotenv() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDotenv(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".env":   "DATABASE_URL=postgres://localhost/dev\nAPP_MODE=dev\nSECRET=hidden\nPOLICY_VAR=env\n",
+		".envrc": "dotenv\nexport APP_MODE=test\nexport APP_DEBUG=1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := NewShellServer(
+		WithAllowedCommands("*"),
+		WithPolicyRules(&PolicyRules{Env: []string{"POLICY_VAR=policy"}}),
+		WithDotenv([]string{"DATABASE_URL", "APP_*", "POLICY_VAR"}),
+		WithProjects([]Project{{Name: "api", Dir: dir, Env: []string{"APP_MODE=project"}}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	want := []string{"DATABASE_URL=postgres://localhost/dev", "APP_MODE=test", "APP_DEBUG=1"}
+	if got := s.dotenv(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("dotenv() = %q, want %q", got, want)
+	}
+	if got := s.dotenv(t.TempDir()); len(got) != 0 {
+		t.Errorf("dotenv() without files = %q, want none", got)
+	}
+
+	// Commands of a project get the variables, and the project's env wins
+	text, isError := callTool(t, s.handleExecuteCommand, map[string]interface{}{
+		"command": `echo "$DATABASE_URL $APP_MODE $APP_DEBUG ${SECRET-unset} $POLICY_VAR"`,
+		"project": "api",
+	})
+	if isError || !strings.Contains(text, "postgres://localhost/dev project 1 unset policy") {
+		t.Errorf("execute_command = %q, want the allowed variables", text)
+	}
+
+	for _, patterns := range [][]string{{""}, {"A=B"}, {"[A"}} {
+		if _, err := NewShellServer(WithDotenv(patterns)); err == nil {
+			t.Errorf("WithDotenv(%q) succeeded, want an error", patterns)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package shellserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PARTIAL_SUFFIX is added to the path of an append-only file to name the
+// file its torn last lines are salvaged to
+const PARTIAL_SUFFIX = ".partial"
+
+// The history and audit files are append-only JSON lines. Every line is
+// flushed to disk before the write is reported done, so a crash loses at
+// most the line being written. That line may be left half written; it is
+// moved to <path>.partial when the file is next opened, so new lines never
+// follow a torn one. Files that are replaced rather than appended to are
+// written to a temporary file and renamed over the old one.
+
+// recoverAppendLog cuts a torn last line off an append-only file and
+// appends it to path+PARTIAL_SUFFIX. It returns the number of bytes
+// salvaged.
+func recoverAppendLog(file *os.File, path string) (int, error) {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return 0, err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		return 0, nil
+	}
+
+	// Find where the last complete line ends
+	const chunkSize = 4096
+	end := info.Size()
+	var tail []byte
+	for end > 0 {
+		start := max(end-chunkSize, 0)
+		chunk := make([]byte, end-start)
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			tail = append(chunk[i+1:], tail...)
+			end = start + int64(i) + 1
+			break
+		}
+		tail = append(chunk, tail...)
+		end = start
+	}
+
+	partial, err := openPrivateFile(path+PARTIAL_SUFFIX, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return 0, fmt.Errorf("cannot salvage the torn end of %s: %v", path, err)
+	}
+	if _, err := partial.Write(append(tail, '\n')); err != nil {
+		partial.Close()
+		return 0, err
+	}
+	if err := partial.Sync(); err != nil {
+		partial.Close()
+		return 0, err
+	}
+	if err := partial.Close(); err != nil {
+		return 0, err
+	}
+	if err := file.Truncate(end); err != nil {
+		return 0, err
+	}
+	return len(tail), file.Sync()
+}
+
+// writeFileAtomic replaces the file at path with data, readable by the owner
+// only. A crash leaves either the old or the new content, never a mix.
+func writeFileAtomic(path string, data []byte) error {
+	return replaceFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// replaceFileAtomic replaces the file at path with what write writes, like
+// writeFileAtomic, for content too large to hold in memory
+func replaceFileAtomic(path string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if err := temp.Chmod(0600); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := write(temp); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes a directory so a rename in it survives a crash. Not every
+// platform can sync a directory, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
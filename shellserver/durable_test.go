@@ -0,0 +1,110 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRecoverAppendLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		want     string
+		salvaged string
+	}{
+		{"empty", "", "", ""},
+		{"complete", "{\"a\":1}\n{\"b\":2}\n", "{\"a\":1}\n{\"b\":2}\n", ""},
+		{"torn", "{\"a\":1}\n{\"b\":", "{\"a\":1}\n", "{\"b\":\n"},
+		{"only torn", "{\"a\"", "", "{\"a\"\n"},
+		{"long torn", "{}\n" + strings.Repeat("x", 10000), "{}\n", strings.Repeat("x", 10000) + "\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "log.jsonl")
+		if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		file, err := openPrivateFile(path, os.O_RDWR|os.O_APPEND)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := recoverAppendLog(file, path)
+		file.Close()
+		if err != nil {
+			t.Errorf("%s: recoverAppendLog failed: %v", tt.name, err)
+			continue
+		}
+		data, _ := os.ReadFile(path)
+		salvaged, _ := os.ReadFile(path + PARTIAL_SUFFIX)
+		if string(data) != tt.want || string(salvaged) != tt.salvaged || n != len(strings.TrimSuffix(tt.salvaged, "\n")) {
+			t.Errorf("%s: file %q and salvaged %q (%d bytes), want %q and %q", tt.name, data, salvaged, n, tt.want, tt.salvaged)
+		}
+	}
+}
+
+func TestCrashedHistoryAndAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	history := filepath.Join(dir, "history.jsonl")
+	store, err := newJSONLHistory(history, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(CommandExecution{ID: 1, Command: "echo one"})
+	events := filepath.Join(dir, "events.jsonl")
+	notifier, err := newFileNotifier(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.Notify(CommandEvent{Event: EVENT_START, Execution: CommandExecution{Command: "echo one"}})
+
+	// A crash in the middle of the next writes
+	for _, path := range []string{history, events} {
+		file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		file.WriteString(`{"id":2,"command":"ec`)
+		file.Close()
+	}
+
+	store, err = newJSONLHistory(history, 10)
+	if err != nil {
+		t.Fatalf("reopening the history failed: %v", err)
+	}
+	store.Add(CommandExecution{ID: 3, Command: "echo three"})
+	if count, _ := store.Count(); count != 2 {
+		t.Errorf("history holds %d executions after recovery, want 2", count)
+	}
+	notifier, err = newFileNotifier(events)
+	if err != nil {
+		t.Fatalf("reopening the event file failed: %v", err)
+	}
+	notifier.Notify(CommandEvent{Event: EVENT_START, Execution: CommandExecution{Command: "echo three"}})
+	file, _ := os.Open(events)
+	defer file.Close()
+	if entries, err := VerifyAuditLog(file); err != nil || entries != 2 {
+		t.Errorf("VerifyAuditLog after recovery = %d entries, %v; want 2 intact entries", entries, err)
+	}
+	for _, path := range []string{history, events} {
+		if salvaged, _ := os.ReadFile(path + PARTIAL_SUFFIX); !strings.Contains(string(salvaged), `"command":"ec`) {
+			t.Errorf("%s: torn line not salvaged, got %q", filepath.Base(path), salvaged)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pins.json")
+	for _, content := range []string{"old", "new"} {
+		if err := writeFileAtomic(path, []byte(content)); err != nil {
+			t.Fatalf("writeFileAtomic failed: %v", err)
+		}
+	}
+	data, _ := os.ReadFile(path)
+	entries, _ := os.ReadDir(dir)
+	if string(data) != "new" || len(entries) != 1 {
+		t.Errorf("after two writes the file holds %q among %d files, want %q alone", data, len(entries), "new")
+	}
+	if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
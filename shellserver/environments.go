@@ -0,0 +1,181 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Environment managers activate_environment recognizes
+const (
+	ENV_MANAGER_VENV  = "venv"  // Python virtual environments
+	ENV_MANAGER_CONDA = "conda" // conda and mamba environments
+	ENV_MANAGER_NVM   = "nvm"   // Node.js versions installed by nvm
+	ENV_MANAGER_RBENV = "rbenv" // Ruby versions installed by rbenv
+)
+
+// ENVIRONMENT_URI is the URI of the structured activate_environment result
+const ENVIRONMENT_URI = "shell://environment.json"
+
+// environmentManager activates environments of one kind in a session
+type environmentManager struct {
+	interpreter string // Interpreter the activation puts first in PATH
+	// activate returns the command that activates the environment named
+	// name, or the default one if name is empty, in shell
+	activate func(name string, shell string) string
+	// probe prints the path of the interpreter the session now runs, then
+	// the directory it must be in, or an empty line if none is active
+	probe string
+}
+
+var environmentManagers = map[string]environmentManager{
+	ENV_MANAGER_VENV: {
+		interpreter: "python",
+		activate: func(name string, shell string) string {
+			if name == "" {
+				name = ".venv"
+			}
+			return ". " + shellQuote(strings.TrimSuffix(name, "/")+"/bin/activate")
+		},
+		probe: `printf '%s\n' "$(command -v python)" "${VIRTUAL_ENV:+$VIRTUAL_ENV/bin}"`,
+	},
+	ENV_MANAGER_CONDA: {
+		interpreter: "python",
+		activate: func(name string, shell string) string {
+			if name == "" {
+				name = "base"
+			}
+			return fmt.Sprintf(`eval "$(conda shell.%s hook)" && conda activate %s`, shell, shellQuote(name))
+		},
+		probe: `printf '%s\n' "$(command -v python)" "${CONDA_PREFIX:+$CONDA_PREFIX/bin}"`,
+	},
+	ENV_MANAGER_NVM: {
+		interpreter: "node",
+		activate: func(name string, shell string) string {
+			// Without a version nvm uses the one in .nvmrc
+			command := `. "${NVM_DIR:-$HOME/.nvm}/nvm.sh" && nvm use`
+			if name != "" {
+				command += " " + shellQuote(name)
+			}
+			return command
+		},
+		probe: `printf '%s\n' "$(command -v node)" "$NVM_BIN"`,
+	},
+	ENV_MANAGER_RBENV: {
+		interpreter: "ruby",
+		activate: func(name string, shell string) string {
+			// Without a version rbenv uses the one in .ruby-version
+			command := fmt.Sprintf(`eval "$(rbenv init - %s)"`, shell)
+			if name != "" {
+				command += " && rbenv shell " + shellQuote(name)
+			}
+			return command
+		},
+		// rbenv runs ruby through a shim, so ask it which ruby that is
+		probe: `__mcp_p=$(rbenv prefix 2>/dev/null); printf '%s\n' "$(rbenv which ruby 2>/dev/null)" "${__mcp_p:+$__mcp_p/bin}"; unset __mcp_p`,
+	},
+}
+
+// ActivatedEnvironment is the structured result of activate_environment
+type ActivatedEnvironment struct {
+	Session     string `json:"session"`
+	Manager     string `json:"manager"`
+	Name        string `json:"name,omitempty"`
+	Interpreter string `json:"interpreter"` // Path of the interpreter the session now runs
+	Bin         string `json:"bin"`         // Directory of the environment's executables
+}
+
+// environmentManagerNames returns the recognized managers, sorted
+func environmentManagerNames() []string {
+	names := make([]string, 0, len(environmentManagers))
+	for name := range environmentManagers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *ShellServer) handleActivateEnvironment(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	id, _ := request.Params.Arguments["session_id"].(string)
+	session, found := s.getSession(ctx, id)
+	if !found {
+		return errorResult(ToolError{
+			Code:    ERROR_SESSION_NOT_FOUND,
+			Message: s.message(MSG_SESSION_NOT_FOUND, id),
+			Details: map[string]interface{}{"argument": "session_id", "session_id": id},
+		}), nil
+	}
+	managerName, _ := request.Params.Arguments["manager"].(string)
+	manager, found := environmentManagers[managerName]
+	if !found {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: Unknown environment manager '%s'. Expected one of: %s", managerName, strings.Join(environmentManagerNames(), ", ")),
+			Details: map[string]interface{}{"argument": "manager", "managers": environmentManagerNames()},
+		}), nil
+	}
+	name, _ := request.Params.Arguments["name"].(string)
+	if strings.HasPrefix(name, "-") || strings.ContainsAny(name, "\n\r") {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'name' must not start with '-' or contain line breaks",
+			Details: map[string]interface{}{"argument": "name"},
+		}), nil
+	}
+
+	// The activation runs scripts of the environment, so it goes through
+	// the middleware chain as any other command would
+	req := &ExecRequest{
+		Command: manager.activate(name, session.shell),
+		Shell:   session.shell,
+		Session: session.id,
+		Client:  s.clientIdentity(ctx),
+	}
+	execution, err := s.exec(ctx, req)
+	if err != nil {
+		return errorResult(s.deniedToolError(req, err)), nil
+	}
+	if execution.ExitCode != 0 {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: Activating the %s environment failed with exit code %d:\n%s", managerName, execution.ExitCode, execution.Output),
+			Details: map[string]interface{}{"manager": managerName, "command": req.Command, "exitCode": execution.ExitCode},
+		}), nil
+	}
+
+	// Check that the interpreter the session now runs is the environment's
+	session.runMutex.Lock()
+	output, _, err := session.impl.run(manager.probe, s.timeout)
+	session.runMutex.Unlock()
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: Cannot check the %s environment: %v", managerName, err),
+			Details: map[string]interface{}{"manager": managerName},
+		}), nil
+	}
+	interpreter, bin, _ := strings.Cut(strings.TrimSuffix(output, "\n"), "\n")
+	interpreter, bin = strings.TrimSpace(interpreter), strings.TrimSpace(bin)
+	if bin == "" || !strings.HasPrefix(interpreter, strings.TrimSuffix(bin, "/")+"/") {
+		resolved := interpreter
+		if resolved == "" {
+			resolved = "not found"
+		}
+		return errorResult(ToolError{
+			Code: ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: The %s environment did not activate: %s is %s, not in the environment's directory '%s'.",
+				managerName, manager.interpreter, resolved, bin),
+			Details: map[string]interface{}{"manager": managerName, "interpreter": interpreter, "bin": bin},
+		}), nil
+	}
+
+	activated := ActivatedEnvironment{Session: session.id, Manager: managerName, Name: name, Interpreter: interpreter, Bin: bin}
+	text := fmt.Sprintf("Activated the %s environment in session '%s': %s is %s.", managerName, session.id, manager.interpreter, interpreter)
+	return jsonResult(text, ENVIRONMENT_URI, activated), nil
+}
@@ -0,0 +1,90 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeVenv creates a virtualenv whose activate script puts its bin first
+// in PATH, or only sets VIRTUAL_ENV if broken is set
+func fakeVenv(t *testing.T, dir string, broken bool) {
+	t.Helper()
+	bin := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	activate := "VIRTUAL_ENV=" + shellQuote(dir) + "\nexport VIRTUAL_ENV\n"
+	if !broken {
+		activate += "PATH=\"$VIRTUAL_ENV/bin:$PATH\"\nexport PATH\n"
+	}
+	if err := os.WriteFile(filepath.Join(bin, "activate"), []byte(activate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "python"), []byte("#!/bin/sh\necho fake python\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActivateEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	fakeVenv(t, filepath.Join(dir, "good"), false)
+	fakeVenv(t, filepath.Join(dir, "broken"), true)
+
+	s, err := NewShellServer(WithAllowedCommands("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.closeAllSessions()
+
+	tests := []struct {
+		name    string
+		args    map[string]interface{}
+		want    string
+		isError bool
+	}{
+		{"venv", map[string]interface{}{"manager": "venv", "name": filepath.Join(dir, "good")}, "python is " + filepath.Join(dir, "good", "bin", "python"), false},
+		{"not activated", map[string]interface{}{"manager": "venv", "name": filepath.Join(dir, "broken")}, "did not activate", true},
+		{"missing", map[string]interface{}{"manager": "venv", "name": filepath.Join(dir, "missing")}, "failed with exit code", true},
+		{"unknown manager", map[string]interface{}{"manager": "pyenv"}, "Unknown environment manager 'pyenv'", true},
+		{"option name", map[string]interface{}{"manager": "conda", "name": "--help"}, "must not start with '-'", true},
+		{"unknown session", map[string]interface{}{"manager": "venv", "session_id": "session-9"}, "No session with ID 'session-9'", true},
+	}
+
+	for _, tt := range tests {
+		session, err := s.startSession("bash", "")
+		if err != nil {
+			t.Fatalf("startSession failed: %v", err)
+		}
+		if _, found := tt.args["session_id"]; !found {
+			tt.args["session_id"] = session.id
+		}
+		text, isError := callTool(t, s.handleActivateEnvironment, tt.args)
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s: activate_environment = %q (error %v), want %q (error %v)", tt.name, text, isError, tt.want, tt.isError)
+		}
+		if tt.name == "venv" {
+			execution := s.executeInSession(session, "python")
+			if strings.TrimSpace(execution.Output) != "fake python" {
+				t.Errorf("python after activation = %q, want the environment's", execution.Output)
+			}
+		}
+		s.closeSession(session.id)
+	}
+
+	// The activation is checked by the policy as any command
+	s, err = NewShellServer(WithAllowedCommands("ls"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.closeAllSessions()
+	session, err := s.startSession("bash", "")
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	text, isError := callTool(t, s.handleActivateEnvironment, map[string]interface{}{"session_id": session.id, "manager": "venv", "name": filepath.Join(dir, "good")})
+	if !isError || !strings.Contains(text, "not in the allowed list") {
+		t.Errorf("activate_environment with a restrictive policy = %q, want a denial", text)
+	}
+}
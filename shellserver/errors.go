@@ -0,0 +1,117 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Error codes attached to failed or cut-short executions. They are stable,
+// so agents can branch on them instead of parsing messages.
+const (
+	ERROR_POLICY_DENIED      = "POLICY_DENIED"      // The policy or a middleware step refused the command
+	ERROR_APPROVAL_REQUIRED  = "APPROVAL_REQUIRED"  // The command needs human approval and did not get it
+	ERROR_RATE_LIMITED       = "RATE_LIMITED"       // Too many commands ran recently
+	ERROR_TIMEOUT            = "TIMEOUT"            // The command was killed at the timeout
+	ERROR_IDLE_TIMEOUT       = "IDLE_TIMEOUT"       // The command was killed after producing no output for its idle timeout
+	ERROR_OUTPUT_LIMIT       = "OUTPUT_LIMIT"       // The output was truncated at MAX_OUTPUT_SIZE
+	ERROR_SHELL_UNSUPPORTED  = "SHELL_UNSUPPORTED"  // The requested shell cannot be used
+	ERROR_COMMAND_NOT_FOUND  = "COMMAND_NOT_FOUND"  // The shell could not find a command (exit code 127)
+	ERROR_SESSION_NOT_FOUND  = "SESSION_NOT_FOUND"  // session_id names no open session
+	ERROR_STATELESS_BUILTIN  = "STATELESS_BUILTIN"  // A builtin such as cd or export would have no lasting effect outside a session
+	ERROR_INVALID_ARGUMENT   = "INVALID_ARGUMENT"   // A tool argument is missing or has the wrong type
+	ERROR_TARGET_UNREACHABLE = "TARGET_UNREACHABLE" // ssh could not connect or log in to the target host
+	ERROR_FILE_TOO_LARGE     = "FILE_TOO_LARGE"     // A file to transfer is over MAX_TRANSFER_SIZE
+	ERROR_ARCHIVE_REJECTED   = "ARCHIVE_REJECTED"   // An archive cannot be read, or has entries that are unsafe to extract
+	ERROR_REPLAY_DIVERGED    = "REPLAY_DIVERGED"    // replay_execution with strict found the conditions changed
+	ERROR_REQUEST_TOO_LARGE  = "REQUEST_TOO_LARGE"  // A tool call is over the request limits
+	ERROR_EXECUTION_FAILED   = "EXECUTION_FAILED"   // The command could not be run for another reason
+	ERROR_URI                = "shell://error.json"
+)
+
+// ToolError is the machine-readable form of a failure, attached to tool
+// results as JSON content at ERROR_URI
+type ToolError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+	Hint    string                 `json:"hint,omitempty"` // What to try instead, e.g. a similar allowed command
+}
+
+// errorResource returns a tool error as embedded JSON content
+func errorResource(toolError ToolError) mcp.EmbeddedResource {
+	// A map of plain values always marshals
+	data, _ := json.Marshal(toolError)
+	resource := mcp.EmbeddedResource{
+		Type: "resource",
+		Resource: mcp.TextResourceContents{
+			URI:      ERROR_URI,
+			MIMEType: JSON_MIME_TYPE,
+			Text:     string(data),
+		},
+	}
+	annotate(&resource.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+	return resource
+}
+
+// errorResult returns a failed tool result with the message as text
+// followed by the structured error
+func errorResult(toolError ToolError) *mcp.CallToolResult {
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: toolError.Message,
+			},
+			errorResource(toolError),
+		},
+		IsError: true,
+	}
+}
+
+// executionError describes why an execution that ran was cut short, or
+// returns nil if it was not
+func (s *ShellServer) executionError(execution CommandExecution) *ToolError {
+	toolError := &ToolError{Code: execution.ErrorCode}
+	switch execution.ErrorCode {
+	case "":
+		return nil
+	case ERROR_TIMEOUT:
+		timeout := s.timeout
+		if execution.TimeoutMs > 0 {
+			timeout = time.Duration(execution.TimeoutMs) * time.Millisecond
+		}
+		toolError.Message = fmt.Sprintf("Command timed out after %s", timeout)
+		toolError.Details = map[string]interface{}{"timeoutMs": timeout.Milliseconds()}
+	case ERROR_IDLE_TIMEOUT:
+		idleTimeout := time.Duration(execution.IdleTimeoutMs) * time.Millisecond
+		toolError.Message = fmt.Sprintf("Command produced no output for %s and was stopped", idleTimeout)
+		toolError.Details = map[string]interface{}{"idleTimeoutMs": execution.IdleTimeoutMs}
+	case ERROR_OUTPUT_LIMIT:
+		limit := MAX_OUTPUT_SIZE
+		if execution.OutputLimit > 0 {
+			limit = execution.OutputLimit
+		}
+		toolError.Message = fmt.Sprintf("Output was truncated to %d bytes", limit)
+		toolError.Details = map[string]interface{}{"limitBytes": limit}
+	case ERROR_SHELL_UNSUPPORTED:
+		toolError.Message = fmt.Sprintf("Unsupported shell '%s'", execution.Shell)
+		toolError.Details = map[string]interface{}{"shell": execution.Shell, "supported": []string{"bash", "zsh"}}
+	case ERROR_COMMAND_NOT_FOUND:
+		name := missingCommand(execution.Output)
+		toolError.Message = fmt.Sprintf("Command '%s' was not found", name)
+		toolError.Details = map[string]interface{}{"command": name}
+		if suggestion := suggestCommand(s.policyFor(execution.Project, clientName(execution.Client)), name); suggestion != "" {
+			toolError.Details["suggestion"] = suggestion
+			toolError.Hint = s.message(MSG_DID_YOU_MEAN, suggestion)
+		}
+	case ERROR_TARGET_UNREACHABLE:
+		toolError.Message = fmt.Sprintf("Host '%s' could not be reached", execution.Target)
+		toolError.Details = map[string]interface{}{"target": execution.Target}
+	default:
+		toolError.Message = "Command did not complete"
+	}
+	return toolError
+}
@@ -0,0 +1,115 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// resultError decodes the structured error attached to a tool result
+func resultError(t *testing.T, result *mcp.CallToolResult) *ToolError {
+	t.Helper()
+	for _, content := range result.Content {
+		resource, ok := content.(mcp.EmbeddedResource)
+		if !ok {
+			continue
+		}
+		contents, ok := resource.Resource.(mcp.TextResourceContents)
+		if !ok || contents.URI != ERROR_URI {
+			continue
+		}
+		var toolError ToolError
+		if err := json.Unmarshal([]byte(contents.Text), &toolError); err != nil {
+			t.Fatalf("error resource is not JSON: %v", err)
+		}
+		return &toolError
+	}
+	return nil
+}
+
+func TestExecuteCommandErrorCodes(t *testing.T) {
+	s, err := NewShellServer(
+		WithAllowedCommands("echo,big"),
+		WithRateLimit(3, time.Hour),
+		WithExecutor(fixedExecutor("ok\n")),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		args    map[string]interface{}
+		code    string
+		detail  string // A key expected in the details
+		isError bool
+	}{
+		{map[string]interface{}{"command": "rm -rf /"}, ERROR_POLICY_DENIED, "command", true},
+		{map[string]interface{}{"command": "echo $(rm x)"}, ERROR_POLICY_DENIED, "rule", true},
+		{map[string]interface{}{"command": 42}, ERROR_INVALID_ARGUMENT, "argument", true},
+		{map[string]interface{}{"command": "echo hi", "session_id": "s-9"}, ERROR_SESSION_NOT_FOUND, "sessionId", true},
+		{map[string]interface{}{"command": "cd /tmp"}, ERROR_STATELESS_BUILTIN, "builtin", true},
+		{map[string]interface{}{"command": "echo ok"}, "", "", false},
+		{map[string]interface{}{"command": "echo ok"}, "", "", false},
+		{map[string]interface{}{"command": "echo ok"}, "", "", false},
+		{map[string]interface{}{"command": "echo ok"}, ERROR_RATE_LIMITED, "limit", true},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		result, _ := s.handleExecuteCommand(context.Background(), request)
+		toolError := resultError(t, result)
+
+		if tt.code == "" {
+			if toolError != nil {
+				t.Errorf("%v returned error %+v, want none", tt.args, toolError)
+			}
+			continue
+		}
+		if toolError == nil || toolError.Code != tt.code || toolError.Details[tt.detail] == nil || result.IsError != tt.isError {
+			t.Errorf("%v = %+v (error %v), want code %s with %s (error %v)", tt.args, toolError, result.IsError, tt.code, tt.detail, tt.isError)
+		}
+	}
+
+	// Truncated output is reported but the result is not a failure
+	s, err = NewShellServer(WithAllowedCommands("big"), WithExecutor(fixedExecutor(strings.Repeat("x", MAX_OUTPUT_SIZE+1))))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "big"}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	if toolError := resultError(t, result); toolError == nil || toolError.Code != ERROR_OUTPUT_LIMIT || result.IsError {
+		t.Errorf("big output = %+v (error %v), want %s", toolError, result.IsError, ERROR_OUTPUT_LIMIT)
+	}
+}
+
+func TestExecutionErrorCodes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		command string
+		shell   string
+		code    string
+	}{
+		{"sleep 5", "bash", ERROR_TIMEOUT},
+		{"echo hi", "fish", ERROR_SHELL_UNSUPPORTED},
+		{"exit 3", "bash", ""},
+	}
+
+	for _, tt := range tests {
+		execution := localExecutor{}.Execute(ctx, tt.command, tt.shell, nil, nil)
+		if tt.code == ERROR_TIMEOUT {
+			// Later commands run with a live context
+			ctx = context.Background()
+		}
+		if execution.ErrorCode != tt.code {
+			t.Errorf("Execute(%q, %s) error code = %q, want %q", tt.command, tt.shell, execution.ErrorCode, tt.code)
+		}
+	}
+}
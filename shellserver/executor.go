@@ -0,0 +1,278 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Executor runs a single shell command until it finishes or ctx expires.
+// Combined stdout and stderr are returned in the execution and, if stream is
+// non-nil, copied to it as they are produced. The command's input, if any,
+// is StdinFromContext(ctx).
+type Executor interface {
+	Execute(ctx context.Context, command string, shell string, env []string, stream io.Writer) CommandExecution
+}
+
+// localExecutor runs commands as child processes of the server
+type localExecutor struct {
+	control processControl
+}
+
+// Execute runs command with shell -c, within the call's limits. Extra
+// environment variables in env are appended to the server's environment.
+func (e localExecutor) Execute(ctx context.Context, command string, shell string, env []string, stream io.Writer) CommandExecution {
+	if shell == "" {
+		shell = DEFAULT_SHELL
+	}
+
+	if execution, unsupported := unsupportedShell(command, shell); unsupported {
+		return execution
+	}
+
+	execution := CommandExecution{
+		Command:   command,
+		Shell:     shell,
+		StartTime: time.Now(),
+	}
+
+	// Create the command
+	cmd := e.control.withCallLimits(callLimitsFromContext(ctx)).command(ctx, shell, "-c", command)
+	if len(env) > 0 {
+		cmd.Env = append(cmd.Environ(), env...)
+	}
+	return runCommand(ctx, cmd, execution, stream)
+}
+
+// unsupportedShell returns a failed execution if shell is not bash or zsh
+func unsupportedShell(command string, shell string) (CommandExecution, bool) {
+	if shell == "bash" || shell == "zsh" {
+		return CommandExecution{}, false
+	}
+	return CommandExecution{
+		Command:   command,
+		Shell:     shell,
+		Output:    fmt.Sprintf("Error: Unsupported shell '%s'. Only bash and zsh are supported.", shell),
+		ExitCode:  1,
+		ErrorCode: ERROR_SHELL_UNSUPPORTED,
+		StartTime: time.Now(),
+		EndTime:   time.Now(),
+	}, true
+}
+
+// runCommand runs cmd for an execution that has its command, shell and start
+// time set, capturing combined stdout and stderr
+func runCommand(ctx context.Context, cmd *exec.Cmd, execution CommandExecution, stream io.Writer) CommandExecution {
+	// Capture both stdout and stderr
+	var output bytes.Buffer
+	var writer io.Writer = &output
+	if stream != nil {
+		writer = io.MultiWriter(&output, stream)
+	}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	if stdin := StdinFromContext(ctx); stdin != nil && cmd.Stdin == nil {
+		cmd.Stdin = stdin
+	}
+	err := cmd.Run()
+
+	execution.EndTime = time.Now()
+	execution.ExecutionMs = execution.EndTime.Sub(execution.StartTime).Milliseconds()
+
+	execution.Output = output.String()
+	if cmd.ProcessState != nil {
+		execution.Usage = resourceUsage(cmd.ProcessState)
+	}
+
+	// Handle different error types
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			timeout := execution.EndTime.Sub(execution.StartTime)
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = deadline.Sub(execution.StartTime)
+			}
+			execution.Output += fmt.Sprintf("\n\nError: Command execution timed out after %s.", timeout.Round(time.Millisecond))
+			execution.ExitCode = 124 // Common timeout exit code
+			execution.TimedOut = true
+			execution.ErrorCode = ERROR_TIMEOUT
+		} else if exitError, ok := err.(*exec.ExitError); ok {
+			execution.ExitCode = exitError.ExitCode()
+		} else {
+			execution.Output += "\n\nError: " + err.Error()
+			execution.ExitCode = 1
+		}
+	} else {
+		execution.ExitCode = 0
+	}
+
+	return execution
+}
+
+// executeCommand runs a command through the configured executor, recording
+// it as an asciicast if recording is enabled
+func (s *ShellServer) executeCommand(command string, shell string, env []string) CommandExecution {
+	return s.executeWith(s.executor, &ExecRequest{Command: command, Shell: shell, Env: env}, command)
+}
+
+// executeWith runs command for req through executor, with req's shell,
+// environment, output writer and idle timeout. It is recorded as an
+// asciicast if recording is enabled.
+func (s *ShellServer) executeWith(executor Executor, req *ExecRequest, command string) CommandExecution {
+	timeout := s.timeout
+	if req.Limits.Timeout > 0 {
+		timeout = req.Limits.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	var stoppedByAdmin atomic.Bool
+	if req.stopper != nil {
+		defer req.stopper.add(func() {
+			stoppedByAdmin.Store(true)
+			stop(errStopRequested)
+		})()
+	}
+	if req.Stdin != nil {
+		ctx = withStdin(ctx, req.Stdin)
+	}
+	if !req.Limits.isZero() {
+		ctx = withCallLimits(ctx, req.Limits)
+	}
+
+	var writers []io.Writer
+	if req.Output != nil {
+		writers = append(writers, req.Output)
+	}
+	if s.recordDir != "" {
+		if recorder, err := s.newCastRecorder("exec", command, req.Shell); err == nil {
+			defer recorder.Close()
+			recorder.input(command)
+			writers = append(writers, recorder)
+		} else {
+			s.logger.Printf("Failed to start recording: %v", err)
+		}
+	}
+	var idle *idleWatch
+	if req.IdleTimeout > 0 {
+		idle = newIdleWatch(req.IdleTimeout, cancel)
+		defer idle.stop()
+		writers = append(writers, idle)
+	}
+	var match *patternWatch
+	if req.StopPattern != nil {
+		match = &patternWatch{pattern: req.StopPattern, stop: func() { stop(errStopRequested) }}
+		writers = append(writers, match)
+	}
+	var stream io.Writer
+	if len(writers) > 0 {
+		stream = io.MultiWriter(writers...)
+	}
+
+	execution := executor.Execute(ctx, command, req.Shell, req.Env, stream)
+	if match != nil && match.matched.Load() && !execution.TimedOut {
+		// Stopping the command is a success: it printed what was waited for
+		execution.Output = strings.TrimSuffix(execution.Output, "\n\nError: "+context.Canceled.Error())
+		execution.Output += fmt.Sprintf("\n\nStopped after the output matched '%s'.", req.StopPattern)
+		execution.ExitCode = 0
+		execution.StoppedOnPattern = req.StopPattern.String()
+	}
+	if stoppedByAdmin.Load() && !execution.TimedOut {
+		execution.Output = strings.TrimSuffix(execution.Output, "\n\nError: "+context.Canceled.Error())
+		execution.Output += STOPPED_BY_ADMIN
+		execution.ErrorCode = ERROR_EXECUTION_FAILED
+	}
+	if req.Limits.Timeout > 0 {
+		execution.TimeoutMs = req.Limits.Timeout.Milliseconds()
+	}
+	if idle != nil {
+		execution.IdleTimeoutMs = req.IdleTimeout.Milliseconds()
+		if idle.expired.Load() && !execution.TimedOut {
+			execution.Output += fmt.Sprintf("\n\nError: Command produced no output for %s and was stopped.", req.IdleTimeout)
+			execution.ExitCode = 124
+			execution.TimedOut = true
+			execution.ErrorCode = ERROR_IDLE_TIMEOUT
+		}
+	}
+	return execution
+}
+
+// STOPPED_BY_ADMIN ends the output of a command stopped through the admin API
+const STOPPED_BY_ADMIN = "\n\nError: Command was stopped by an administrator."
+
+// MAX_PATTERN_LINE caps how much of one line stop_on_pattern keeps
+const MAX_PATTERN_LINE = 64 * 1024
+
+// idleWatch cancels a command that produces no output for its timeout.
+// Every write restarts the timer.
+type idleWatch struct {
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleWatch(timeout time.Duration, cancel context.CancelFunc) *idleWatch {
+	w := &idleWatch{timeout: timeout}
+	w.timer = time.AfterFunc(timeout, func() {
+		w.expired.Store(true)
+		cancel()
+	})
+	return w
+}
+
+// Write restarts the timer; the output itself is not kept
+func (w *idleWatch) Write(data []byte) (int, error) {
+	w.timer.Reset(w.timeout)
+	return len(data), nil
+}
+
+func (w *idleWatch) stop() {
+	w.timer.Stop()
+}
+
+// patternWatch stops a command once a line of its output matches a
+// pattern. The line being written is matched too, so a prompt or banner
+// without a newline still counts.
+type patternWatch struct {
+	pattern *regexp.Regexp
+	stop    func()
+	line    []byte // Output since the last newline
+	matched atomic.Bool
+}
+
+func (w *patternWatch) Write(data []byte) (int, error) {
+	if w.matched.Load() {
+		return len(data), nil
+	}
+	w.line = append(w.line, data...)
+	for {
+		end := bytes.IndexByte(w.line, '\n')
+		if end < 0 {
+			break
+		}
+		if w.pattern.Match(w.line[:end]) {
+			w.found()
+			return len(data), nil
+		}
+		w.line = w.line[end+1:]
+	}
+	if len(w.line) > MAX_PATTERN_LINE {
+		w.line = w.line[len(w.line)-MAX_PATTERN_LINE:]
+	}
+	if w.pattern.Match(w.line) {
+		w.found()
+	}
+	return len(data), nil
+}
+
+func (w *patternWatch) found() {
+	w.matched.Store(true)
+	w.line = nil
+	w.stop()
+}
@@ -0,0 +1,87 @@
+package shellserver
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestPatternWatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		writes  []string
+		want    bool
+	}{
+		{"started", []string{"booting\n", "server started\n"}, true},
+		{"started", []string{"booting\n", "server sta", "rted on :80"}, true},
+		{"^ready$", []string{"not ready\n", "ready\n"}, true},
+		{"^ready$", []string{"not ready\nalready\n"}, false},
+		{"a.*b", []string{"a\n", "b\n"}, false},
+	}
+
+	for _, tt := range tests {
+		stopped := false
+		w := &patternWatch{pattern: regexp.MustCompile(tt.pattern), stop: func() { stopped = true }}
+		for _, write := range tt.writes {
+			w.Write([]byte(write))
+		}
+		if w.matched.Load() != tt.want || stopped != tt.want {
+			t.Errorf("pattern %q over %q: matched %v, stopped %v, want %v", tt.pattern, tt.writes, w.matched.Load(), stopped, tt.want)
+		}
+	}
+}
+
+func TestExecuteCommandStopOnPattern(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo,sleep,trap,wait,exit"), WithTimeout(10*time.Second))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		args    map[string]interface{}
+		want    []string
+		isError bool
+	}{
+		{
+			map[string]interface{}{"command": "echo booting; sleep 0.1; echo 'Server started on port 8080'; sleep 10", "stop_on_pattern": "started on port \\d+"},
+			[]string{"Server started on port 8080", "Stopped after the output matched", "completed successfully"},
+			false,
+		},
+		// The command gets to clean up before it is killed
+		{
+			map[string]interface{}{"command": "trap 'echo cleaning up; exit 0' TERM; echo ready; sleep 10 & wait", "stop_on_pattern": "^ready"},
+			[]string{"ready\ncleaning up", "completed successfully"},
+			false,
+		},
+		// Commands that finish first are unaffected
+		{
+			map[string]interface{}{"command": "echo done; exit 3", "stop_on_pattern": "never"},
+			[]string{"failed with exit code 3"},
+			false,
+		},
+		{map[string]interface{}{"command": "echo hi", "stop_on_pattern": "("}, []string{"invalid 'stop_on_pattern'"}, true},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		start := time.Now()
+		result, _ := s.handleExecuteCommand(context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		if result.IsError != tt.isError {
+			t.Errorf("%v: isError = %v, want %v (%q)", tt.args, result.IsError, tt.isError, text)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("%v = %q, want %q", tt.args, text, want)
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%v took %s, want it stopped early", tt.args, elapsed)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// fetch_url lets the assistant download a file or check an endpoint without
+// curl or wget being allowed. Only URLs matching the operator's allowlist
+// are fetched, and only hosts the allowlist names may be private addresses.
+
+// Defaults and limits of fetch_url
+const (
+	DEFAULT_FETCH_METHODS = "GET,HEAD"           // Methods allowed when none are configured
+	DEFAULT_FETCH_SIZE    = 10 * 1024 * 1024     // Largest response body when no limit is configured
+	DEFAULT_FETCH_TIMEOUT = 30 * time.Second     // Limit for each request when none is configured
+	MAX_FETCH_REDIRECTS   = 5                    // Redirects followed before giving up
+	FETCH_URI             = "shell://fetch.json" // URI of the structured fetch_url result
+)
+
+// fetchConfig is what fetch_url may fetch and how much
+type fetchConfig struct {
+	allowed []urlPattern // Nothing is fetched when empty
+	methods []string
+	maxSize int64
+	timeout time.Duration
+}
+
+// urlPattern is an allowlist entry such as https://*.example.com/api/
+type urlPattern struct {
+	raw    string
+	scheme string // http or https; empty for "*", which matches every URL
+	host   string // A host, or "*.domain" for the domain's subdomains
+	port   string // "" for the scheme's default port
+	path   string // Prefix the URL path must start with
+}
+
+// FetchResult is what fetch_url returns
+type FetchResult struct {
+	URL         string            `json:"url"`
+	FinalURL    string            `json:"finalUrl"` // URL after redirects
+	Method      string            `json:"method"`
+	Status      int               `json:"status"`
+	Headers     map[string]string `json:"headers"`
+	ContentType string            `json:"contentType,omitempty"`
+	Size        int64             `json:"size"`      // Bytes of body read
+	Truncated   bool              `json:"truncated"` // The body was cut at the size limit
+	SavedTo     string            `json:"savedTo,omitempty"`
+	DurationMs  int64             `json:"durationMs"`
+}
+
+// privateAddressError is returned when a redirect leads to a private address
+type privateAddressError struct {
+	host string
+	ip   net.IP
+}
+
+func (e *privateAddressError) Error() string {
+	return fmt.Sprintf("'%s' resolves to the private address %s", e.host, e.ip)
+}
+
+// WithAllowedURLs lets fetch_url fetch URLs matching a comma-separated list
+// of patterns such as "https://api.github.com/repos/" or
+// "https://*.example.com". A pattern matches URLs with its scheme, host and
+// port whose path starts with its path; "*" matches every http and https URL.
+func WithAllowedURLs(allowedURLs string) Option {
+	return func(s *ShellServer) error {
+		for _, raw := range strings.Split(allowedURLs, ",") {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			pattern, err := parseURLPattern(raw)
+			if err != nil {
+				return err
+			}
+			s.fetch.allowed = append(s.fetch.allowed, pattern)
+		}
+		return nil
+	}
+}
+
+// WithFetchMethods sets the comma-separated HTTP methods fetch_url may use,
+// GET and HEAD by default
+func WithFetchMethods(methods string) Option {
+	return func(s *ShellServer) error {
+		s.fetch.methods = nil
+		for _, method := range strings.Split(methods, ",") {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			if method == "" {
+				continue
+			}
+			if strings.ContainsFunc(method, func(r rune) bool { return r < 'A' || r > 'Z' }) {
+				return fmt.Errorf("invalid HTTP method '%s'", method)
+			}
+			s.fetch.methods = append(s.fetch.methods, method)
+		}
+		if len(s.fetch.methods) == 0 {
+			return fmt.Errorf("no HTTP methods given")
+		}
+		return nil
+	}
+}
+
+// WithFetchLimits limits the size of the response bodies fetch_url reads
+// and how long each request may take
+func WithFetchLimits(maxSize int64, timeout time.Duration) Option {
+	return func(s *ShellServer) error {
+		if maxSize <= 0 || timeout <= 0 {
+			return fmt.Errorf("fetch limits must be positive, got %d bytes and %s", maxSize, timeout)
+		}
+		s.fetch.maxSize, s.fetch.timeout = maxSize, timeout
+		return nil
+	}
+}
+
+// parseURLPattern parses an allowlist entry
+func parseURLPattern(raw string) (urlPattern, error) {
+	if raw == "*" {
+		return urlPattern{raw: raw}, nil
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return urlPattern{}, fmt.Errorf("invalid URL pattern '%s': %v", raw, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return urlPattern{}, fmt.Errorf("invalid URL pattern '%s': the scheme must be http or https", raw)
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return urlPattern{}, fmt.Errorf("invalid URL pattern '%s': expected a host or '*.' and a domain", raw)
+	}
+	if parsed.User != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return urlPattern{}, fmt.Errorf("invalid URL pattern '%s': only a scheme, host, port and path are allowed", raw)
+	}
+	return urlPattern{raw: raw, scheme: parsed.Scheme, host: host, port: parsed.Port(), path: parsed.EscapedPath()}, nil
+}
+
+// matches reports whether u is allowed by the pattern
+func (p urlPattern) matches(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	if p.raw == "*" {
+		return true
+	}
+	if u.Scheme != p.scheme || u.Port() != p.port {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if domain, found := strings.CutPrefix(p.host, "*."); found {
+		if !strings.HasSuffix(host, "."+domain) {
+			return false
+		}
+	} else if host != p.host {
+		return false
+	}
+	// "/api" allows "/api" and "/api/...", but not "/apiary"
+	urlPath := path.Clean("/" + u.EscapedPath())
+	prefix := strings.TrimSuffix(p.path, "/")
+	return prefix == "" || urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// allowedURL returns whether u matches the allowlist, and whether a pattern
+// names its host literally, so that it may be a private address
+func (c *fetchConfig) allowedURL(u *url.URL) (bool, bool) {
+	allowed, named := false, false
+	for _, pattern := range c.allowed {
+		if pattern.matches(u) {
+			allowed = true
+			named = named || pattern.host == strings.ToLower(u.Hostname())
+		}
+	}
+	return allowed, named
+}
+
+// privateIP reports whether ip is loopback, private, link-local (including
+// cloud metadata endpoints), shared or unspecified
+func privateIP(ip net.IP) bool {
+	shared := net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || shared.Contains(ip)
+}
+
+// fetchClient returns a client for one fetch_url call. Only the host of the
+// first request may be a private address, and only if the allowlist names
+// it; redirects must match the allowlist and lead to public addresses.
+// Addresses are checked when connecting, so DNS rebinding cannot bypass it.
+func (c *fetchConfig) fetchClient(origin string, privateAllowed bool) *http.Client {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	transport := &http.Transport{
+		Proxy: nil, // Proxies from the environment would connect on our behalf, unchecked
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			var lastErr error
+			for _, ip := range addresses {
+				if privateIP(ip.IP) && !(privateAllowed && strings.EqualFold(host, origin)) {
+					lastErr = &privateAddressError{host: host, ip: ip.IP}
+					continue
+				}
+				conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+				if err == nil {
+					return conn, nil
+				}
+				lastErr = err
+			}
+			if lastErr == nil {
+				lastErr = fmt.Errorf("no addresses for '%s'", host)
+			}
+			return nil, lastErr
+		},
+		TLSHandshakeTimeout:   c.timeout,
+		ResponseHeaderTimeout: c.timeout,
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) > MAX_FETCH_REDIRECTS {
+				return fmt.Errorf("more than %d redirects", MAX_FETCH_REDIRECTS)
+			}
+			if allowed, _ := c.allowedURL(request.URL); !allowed {
+				return &redirectDeniedError{url: request.URL.String()}
+			}
+			return nil
+		},
+	}
+}
+
+// redirectDeniedError is returned when a redirect leads off the allowlist
+type redirectDeniedError struct {
+	url string
+}
+
+func (e *redirectDeniedError) Error() string {
+	return fmt.Sprintf("redirect to '%s', which is not in the URL allowlist", e.url)
+}
+
+// textual reports whether a body can be shown as text
+func textual(contentType string, body []byte) bool {
+	if strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "json") || strings.Contains(contentType, "xml") {
+		return true
+	}
+	return utf8.Valid(body) && bytes.IndexByte(body, 0) < 0
+}
+
+func (s *ShellServer) handleFetchURL(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	invalid := func(argument string, format string, args ...interface{}) (*mcp.CallToolResult, error) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: " + fmt.Sprintf(format, args...),
+			Details: map[string]interface{}{"argument": argument},
+		}), nil
+	}
+	rawURL, _ := request.Params.Arguments["url"].(string)
+	target, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || target.Host == "" {
+		return invalid("url", "'url' must be an absolute http or https URL")
+	}
+	method := "GET"
+	if value, ok := request.Params.Arguments["method"].(string); ok && value != "" {
+		method = strings.ToUpper(value)
+	}
+
+	if len(s.fetch.allowed) == 0 {
+		return errorResult(ToolError{Code: ERROR_POLICY_DENIED, Message: s.message(MSG_FETCH_DISABLED)}), nil
+	}
+	allowed, named := s.fetch.allowedURL(target)
+	if !allowed {
+		return errorResult(ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_URL_NOT_ALLOWED, target.String()),
+			Details: map[string]interface{}{"rule": "url_allowlist", "url": target.String()},
+		}), nil
+	}
+	if !containsString(s.fetch.methods, method) {
+		return errorResult(ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_METHOD_NOT_ALLOWED, method, strings.Join(s.fetch.methods, ", ")),
+			Details: map[string]interface{}{"rule": "fetch_methods", "method": method},
+		}), nil
+	}
+
+	var body io.Reader
+	if value, ok := request.Params.Arguments["body"].(string); ok && value != "" {
+		if method == "GET" || method == "HEAD" {
+			return invalid("body", "'body' cannot be sent with %s", method)
+		}
+		body = strings.NewReader(value)
+	}
+	headers := http.Header{}
+	if value, found := request.Params.Arguments["headers"]; found {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("headers", "'headers' must be an object of header names and values")
+		}
+		for name, value := range fields {
+			text, ok := value.(string)
+			if !ok {
+				return invalid("headers", "the value of header '%s' must be a string", name)
+			}
+			headers.Set(name, text)
+		}
+	}
+
+	saveTo := ""
+	if value, ok := request.Params.Arguments["save_to"].(string); ok && value != "" {
+		var toolError *ToolError
+		if saveTo, toolError = s.writablePath(ctx, request, "save_to"); toolError != nil {
+			return errorResult(*toolError), nil
+		}
+		if overwrite, _ := request.Params.Arguments["overwrite"].(bool); !overwrite {
+			if _, err := os.Lstat(saveTo); err == nil {
+				return invalid("save_to", "'%s' exists; set overwrite to replace it", saveTo)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.fetch.timeout)
+	defer cancel()
+	httpRequest, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return invalid("url", "%v", err)
+	}
+	for name, values := range headers {
+		httpRequest.Header[name] = values
+	}
+	start := time.Now()
+	response, err := s.fetch.fetchClient(target.Hostname(), named).Do(httpRequest)
+	if err != nil {
+		return errorResult(s.fetchFailed(target.String(), err)), nil
+	}
+	defer response.Body.Close()
+
+	result := &FetchResult{
+		URL:         target.String(),
+		FinalURL:    response.Request.URL.String(),
+		Method:      method,
+		Status:      response.StatusCode,
+		Headers:     map[string]string{},
+		ContentType: response.Header.Get("Content-Type"),
+	}
+	for name := range response.Header {
+		result.Headers[name] = response.Header.Get(name)
+	}
+
+	// Bodies are read to one byte past the limit to notice larger ones
+	var content bytes.Buffer
+	var sink io.Writer = &content
+	var file *os.File
+	if saveTo != "" && response.StatusCode < 300 {
+		if response.ContentLength > s.fetch.maxSize {
+			return errorResult(s.fetchTooLarge(target.String())), nil
+		}
+		if file, err = os.Create(saveTo); err != nil {
+			return errorResult(*fileError(saveTo, err)), nil
+		}
+		defer file.Close()
+		sink = file
+	}
+	result.Size, err = io.Copy(sink, io.LimitReader(response.Body, s.fetch.maxSize+1))
+	if result.Size > s.fetch.maxSize {
+		result.Size, result.Truncated = s.fetch.maxSize, true
+		content.Truncate(int(s.fetch.maxSize))
+		if file != nil {
+			file.Close()
+			os.Remove(saveTo)
+			return errorResult(s.fetchTooLarge(target.String())), nil
+		}
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+			os.Remove(saveTo)
+		}
+		return errorResult(s.fetchFailed(target.String(), err)), nil
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return errorResult(*fileError(saveTo, err)), nil
+		}
+		result.SavedTo = saveTo
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s %s: %s", method, result.FinalURL, response.Status)
+	if result.ContentType != "" {
+		fmt.Fprintf(&text, " (%s)", result.ContentType)
+	}
+	fmt.Fprintf(&text, ", %s in %d ms", formatByteSize(result.Size), result.DurationMs)
+	switch {
+	case result.SavedTo != "":
+		fmt.Fprintf(&text, "\nSaved to %s", result.SavedTo)
+	case saveTo != "":
+		fmt.Fprintf(&text, "\nNot saved to %s because the request did not succeed", saveTo)
+	}
+	if result.Truncated {
+		fmt.Fprintf(&text, "\nThe body was cut at the %s limit.", formatByteSize(s.fetch.maxSize))
+	}
+	if content.Len() > 0 {
+		if textual(result.ContentType, content.Bytes()) {
+			shown := content.String()
+			if len(shown) > MAX_OUTPUT_SIZE {
+				shown = shown[:MAX_OUTPUT_SIZE] + "\n... (output truncated due to size limit)"
+			}
+			text.WriteString("\n\n" + shown)
+		} else {
+			text.WriteString("\n\nThe body is binary and is not shown; set save_to to keep it.")
+		}
+	}
+	return jsonResult(text.String(), FETCH_URI, result), nil
+}
+
+// fetchFailed describes a request that got no response
+func (s *ShellServer) fetchFailed(target string, err error) ToolError {
+	var private *privateAddressError
+	var redirect *redirectDeniedError
+	switch {
+	case errors.As(err, &private):
+		return ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_FETCH_PRIVATE, private.host, private.ip.String()),
+			Details: map[string]interface{}{"rule": "private_address", "url": target, "host": private.host},
+		}
+	case errors.As(err, &redirect):
+		return ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_URL_NOT_ALLOWED, redirect.url),
+			Details: map[string]interface{}{"rule": "url_allowlist", "url": redirect.url},
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return ToolError{
+			Code:    ERROR_TIMEOUT,
+			Message: fmt.Sprintf("Error: fetching '%s' took longer than %s", target, s.fetch.timeout),
+			Details: map[string]interface{}{"url": target, "timeoutMs": s.fetch.timeout.Milliseconds()},
+		}
+	}
+	return ToolError{
+		Code:    ERROR_EXECUTION_FAILED,
+		Message: fmt.Sprintf("Error: fetching '%s' failed: %v", target, err),
+		Details: map[string]interface{}{"url": target},
+	}
+}
+
+// fetchTooLarge refuses a download over the size limit
+func (s *ShellServer) fetchTooLarge(target string) ToolError {
+	return ToolError{
+		Code:    ERROR_FILE_TOO_LARGE,
+		Message: s.message(MSG_FILE_TOO_LARGE, target, s.fetch.maxSize),
+		Details: map[string]interface{}{"url": target, "limit": s.fetch.maxSize},
+	}
+}
+
+// fetchDefaults fills in the fetch settings no option set
+func (c *fetchConfig) fetchDefaults() {
+	if c.methods == nil {
+		c.methods = strings.Split(DEFAULT_FETCH_METHODS, ",")
+	}
+	if c.maxSize == 0 {
+		c.maxSize = DEFAULT_FETCH_SIZE
+	}
+	if c.timeout == 0 {
+		c.timeout = DEFAULT_FETCH_TIMEOUT
+	}
+}
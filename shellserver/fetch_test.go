@@ -0,0 +1,206 @@
+package shellserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestURLPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		want    bool
+	}{
+		{"https://api.github.com/repos/", "https://api.github.com/repos/a/b", true},
+		{"https://api.github.com/repos/", "https://api.github.com/repos", true},
+		{"https://api.github.com/repos", "https://api.github.com/reposx", false},
+		{"https://api.github.com/repos/", "https://api.github.com/repos/../users", false},
+		{"https://api.github.com/repos/", "http://api.github.com/repos/a", false},
+		{"https://api.github.com", "https://API.github.com/anything", true},
+		{"https://api.github.com", "https://api.github.com:8443/", false},
+		{"http://localhost:8080", "http://localhost:8080/health", true},
+		{"http://localhost:8080", "http://localhost/health", false},
+		{"https://*.example.com", "https://cdn.example.com/x", true},
+		{"https://*.example.com", "https://example.com/x", false},
+		{"https://*.example.com", "https://evilexample.com/x", false},
+		{"https://example.com", "https://example.com.evil.net/", false},
+		{"*", "http://anything.test/", true},
+		{"*", "ftp://anything.test/", false},
+	}
+
+	for _, tt := range tests {
+		pattern, err := parseURLPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("parseURLPattern(%q) failed: %v", tt.pattern, err)
+		}
+		target, _ := url.Parse(tt.url)
+		if got := pattern.matches(target); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.url, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"example.com", "ftp://example.com", "https://", "https://a*.example.com", "https://example.com/?q=1", "https://user@example.com"} {
+		if _, err := parseURLPattern(invalid); err == nil {
+			t.Errorf("parseURLPattern(%q) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"192.168.0.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"8.8.8.8", false},
+		{"2606:4700::1111", false},
+	}
+
+	for _, tt := range tests {
+		if got := privateIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("privateIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestFetchURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello world\n"))
+	})
+	mux.HandleFunc("/binary", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte{0, 1, 2, 0xff})
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 2048)))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method + " " + r.Header.Get("X-Test")))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusNotFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	base := server.URL
+	local := strings.Replace(base, "127.0.0.1", "localhost", 1)
+	mux.HandleFunc("/redirect/same", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hello", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://elsewhere.test/", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect/local", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, local+"/hello", http.StatusFound)
+	})
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "existing.txt"), []byte("mine"), 0o644)
+	s, err := NewShellServer(
+		WithAllowedCommands("ls"),
+		WithAllowedURLs(base+"/, "+local+"/"),
+		WithFetchMethods("GET,HEAD,POST"),
+		WithFetchLimits(1024, time.Second),
+		WithProjects([]Project{{Name: "app", Dir: dir}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{map[string]interface{}{"url": base + "/hello"}, "200 OK (text/plain), 12 B in", ""},
+		{map[string]interface{}{"url": base + "/hello"}, "\n\nhello world\n", ""},
+		{map[string]interface{}{"url": base + "/hello", "method": "head"}, "HEAD " + base + "/hello: 200 OK", ""},
+		{map[string]interface{}{"url": base + "/echo", "method": "POST", "body": "x", "headers": map[string]interface{}{"X-Test": "yes"}}, "POST yes", ""},
+		{map[string]interface{}{"url": base + "/echo", "method": "DELETE"}, "DELETE requests are not allowed; the allowed methods are GET, HEAD, POST", ERROR_POLICY_DENIED},
+		{map[string]interface{}{"url": base + "/echo", "body": "x"}, "'body' cannot be sent with GET", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"url": base + "/echo", "headers": "X-Test: yes"}, "'headers' must be an object", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"url": base + "/binary"}, "The body is binary and is not shown", ""},
+		{map[string]interface{}{"url": base + "/big"}, "The body was cut at the 1.0 KiB limit", ""},
+		{map[string]interface{}{"url": base + "/missing"}, "404 Not Found", ""},
+		{map[string]interface{}{"url": base + "/redirect/same"}, "GET " + base + "/hello: 200 OK", ""},
+		{map[string]interface{}{"url": base + "/redirect/away"}, "'https://elsewhere.test/' is not in the URL allowlist", ERROR_POLICY_DENIED},
+		{map[string]interface{}{"url": base + "/redirect/local"}, "'localhost' resolves to the private address", ERROR_POLICY_DENIED},
+		{map[string]interface{}{"url": base + "/slow"}, "took longer than 1s", ERROR_TIMEOUT},
+		{map[string]interface{}{"url": "https://example.com/"}, "'https://example.com/' is not in the URL allowlist", ERROR_POLICY_DENIED},
+		{map[string]interface{}{"url": "not a url"}, "'url' must be an absolute http or https URL", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"url": base + "/hello", "save_to": "hello.txt"}, "Saved to " + filepath.Join(dir, "hello.txt"), ""},
+		{map[string]interface{}{"url": base + "/big", "save_to": "big.txt"}, "larger than the 1024 byte", ERROR_FILE_TOO_LARGE},
+		{map[string]interface{}{"url": base + "/hello", "save_to": "existing.txt"}, "exists; set overwrite", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"url": base + "/missing", "save_to": "missing.txt"}, "Not saved to", ""},
+		{map[string]interface{}{"url": base + "/hello", "save_to": filepath.Join(t.TempDir(), "out.txt")}, "outside the server's and the projects' directories", ERROR_POLICY_DENIED},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		request.Params.Arguments["project"] = "app"
+		result, _ := s.handleFetchURL(context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("fetch_url(%v) = %q (code %q), want %q (code %q)", tt.args, text, code, tt.want, tt.wantCode)
+		}
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "hello.txt")); string(data) != "hello world\n" {
+		t.Errorf("hello.txt = %q, want the body", data)
+	}
+	for _, name := range []string{"big.txt", "missing.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s was saved", name)
+		}
+	}
+
+	// A wildcard pattern does not name the host, so it may not be private
+	s, err = NewShellServer(WithAllowedCommands("ls"), WithAllowedURLs("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"url": base + "/hello"}
+	result, _ := s.handleFetchURL(context.Background(), request)
+	if toolError := resultError(t, result); toolError == nil || toolError.Code != ERROR_POLICY_DENIED {
+		t.Errorf("fetching %s with a wildcard = %+v, want %s", base, toolError, ERROR_POLICY_DENIED)
+	}
+
+	// Without an allowlist nothing is fetched
+	s, _ = NewShellServer(WithAllowedCommands("ls"))
+	result, _ = s.handleFetchURL(context.Background(), request)
+	if toolError := resultError(t, result); toolError == nil || !strings.Contains(toolError.Message, "--allowed-urls") {
+		t.Errorf("fetching without an allowlist = %+v, want a refusal", toolError)
+	}
+}
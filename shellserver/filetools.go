@@ -0,0 +1,548 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// hash_file, compare_files and find_files cover checksum, diff and find
+// chores in process, under the same path policy as the inspection tools.
+
+// Limits of the file tools
+const (
+	MAX_DIFF_SIZE      = 10 * 1024 * 1024     // Largest file compare_files diffs line by line
+	MAX_DIFF_EDITS     = 2000                 // Most inserted and deleted lines a line diff finds
+	DEFAULT_DIFF_LINES = 3                    // Lines of context around each change
+	DEFAULT_FIND_LIMIT = 200                  // Paths find_files returns when no limit is given
+	MAX_FIND_LIMIT     = 5000                 // Most paths find_files returns
+	FIND_URI           = "shell://found.json" // URI of the structured find_files result
+)
+
+// hashAlgorithms are the digests hash_file computes
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// FoundFile is a path matched by find_files
+type FoundFile struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"` // "file", "directory", "symlink" or "other"
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// FindResult is what find_files returns
+type FindResult struct {
+	Root      string      `json:"root"`
+	Matches   []FoundFile `json:"matches"`
+	Truncated bool        `json:"truncated"` // More paths match than were returned
+	Protected int         `json:"protected"` // Paths left out because they are under a protected path
+}
+
+// findFilter selects paths for find_files; zero fields match everything
+type findFilter struct {
+	name      string // Glob matched against the base name
+	kind      string // "file" or "directory"
+	minSize   int64
+	maxSize   int64 // Negative for no limit
+	newerThan time.Time
+	olderThan time.Time
+	maxDepth  int // Zero for no limit
+}
+
+// matches reports whether the entry name passes the filter
+func (f findFilter) matches(name string, info fs.FileInfo) bool {
+	if f.name != "" {
+		if matched, _ := filepath.Match(f.name, name); !matched {
+			return false
+		}
+	}
+	if f.kind != "" && fileType(info.Mode()) != f.kind {
+		return false
+	}
+	if info.Size() < f.minSize || (f.maxSize >= 0 && info.Size() > f.maxSize) {
+		return false
+	}
+	if !f.newerThan.IsZero() && !info.ModTime().After(f.newerThan) {
+		return false
+	}
+	if !f.olderThan.IsZero() && !info.ModTime().Before(f.olderThan) {
+		return false
+	}
+	return true
+}
+
+// parseAge parses a duration such as "30m", "12h" or "7d"
+func parseAge(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		count, err := strconv.Atoi(days)
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("invalid age '%s'", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age '%s', expected e.g. 30m, 12h or 7d", value)
+	}
+	return age, nil
+}
+
+// findFiles walks root, not following symlinks, and returns up to limit
+// paths passing filter. Protected paths are neither returned nor searched.
+func findFiles(root string, filter findFilter, limit int, protected func(string) bool) (*FindResult, error) {
+	result := &FindResult{Root: root, Matches: []FoundFile{}}
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are skipped, as find reports and skips them
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if p != root && protected != nil && protected(p) {
+			result.Protected++
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		depth := 0
+		if rel, err := filepath.Rel(root, p); err == nil && rel != "." {
+			depth = strings.Count(rel, string(filepath.Separator)) + 1
+		}
+		if filter.maxDepth > 0 && depth > filter.maxDepth {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || !filter.matches(entry.Name(), info) {
+			return nil
+		}
+		if len(result.Matches) == limit {
+			result.Truncated = true
+			return filepath.SkipAll
+		}
+		result.Matches = append(result.Matches, FoundFile{Path: p, Type: fileType(info.Mode()), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return result, err
+}
+
+// diffOp is one line of a line diff: ' ' kept, '-' deleted or '+' inserted
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the shortest edit script turning a into b, found with
+// Myers' algorithm, or false if it needs more than maxEdits edits
+func diffLines(a, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int // trace[d][k+d] is the furthest x on diagonal k after d edits
+
+	found := -1
+	for d := 0; d <= limit && found < 0; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = d
+			}
+		}
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+	}
+	if found < 0 {
+		return nil, false
+	}
+
+	// Walk back from the end, collecting operations in reverse
+	var ops []diffOp
+	x, y := n, m
+	for d := found; d > 0; d-- {
+		previous := trace[d-1]
+		at := func(k int) int { return previous[k+d-1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x, y = x-1, y-1
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// unifiedDiff formats a line diff as unified diff hunks with context lines
+// around each change, as diff -u does
+func unifiedDiff(ops []diffOp, context int) string {
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change and the end of the hunk around it
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		// Changes separated by at most twice the context share a hunk
+		last := first
+		for i := first + 1; i < len(ops) && i-last-1 <= 2*context; i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			}
+		}
+		hunkStart := max(first-context, start)
+		hunkEnd := min(last+1+context, len(ops))
+
+		// Line numbers of the hunk in each file
+		lineA, lineB := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				lineA++
+			}
+			if op.kind != '-' {
+				lineB++
+			}
+		}
+		countA, countB := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		if countA == 0 {
+			lineA--
+		}
+		if countB == 0 {
+			lineB--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+// splitLines splits file content into lines without their endings
+func splitLines(data []byte) []string {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// firstDifference compares two readers byte by byte, returning the offset
+// and 1-based line of the first difference, or -1 if they are equal
+func firstDifference(a, b io.Reader) (int64, int, error) {
+	bufferA, bufferB := make([]byte, 64*1024), make([]byte, 64*1024)
+	var offset int64
+	line := 1
+	for {
+		n, errA := io.ReadFull(a, bufferA)
+		m, errB := io.ReadFull(b, bufferB)
+		common := min(n, m)
+		for i := 0; i < common; i++ {
+			if bufferA[i] != bufferB[i] {
+				return offset + int64(i), line, nil
+			}
+			if bufferA[i] == '\n' {
+				line++
+			}
+		}
+		if n != m {
+			return offset + int64(common), line, nil
+		}
+		offset += int64(n)
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return 0, 0, errA
+		}
+		if errB != nil && !endB {
+			return 0, 0, errB
+		}
+		if endA || endB {
+			return -1, 0, nil
+		}
+	}
+}
+
+func (s *ShellServer) handleHashFile(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, toolError := s.inspectPath(ctx, request, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	algorithm := "sha256"
+	if value, ok := request.Params.Arguments["algorithm"].(string); ok && value != "" {
+		algorithm = strings.ToLower(value)
+	}
+	newHash, found := hashAlgorithms[algorithm]
+	if !found {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: unknown algorithm '%s', expected md5, sha1 or sha256", algorithm),
+			Details: map[string]interface{}{"argument": "algorithm"},
+		}), nil
+	}
+
+	file, _, toolError := openRegular(name)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer file.Close()
+	digest := newHash()
+	if _, err := io.Copy(digest, file); err != nil {
+		return errorResult(*fileError(name, err)), nil
+	}
+	// The same format as sha256sum and friends
+	text := fmt.Sprintf("%s  %s", hex.EncodeToString(digest.Sum(nil)), name)
+	return &mcp.CallToolResult{Content: []mcp.Content{assistantText(text)}}, nil
+}
+
+func (s *ShellServer) handleCompareFiles(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	nameA, toolError := s.inspectPath(ctx, request, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	nameB, toolError := s.inspectPath(ctx, request, "other_path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	mode := "lines"
+	if value, ok := request.Params.Arguments["mode"].(string); ok && value != "" {
+		mode = value
+	}
+	if mode != "lines" && mode != "bytes" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: unknown mode '%s', expected lines or bytes", mode),
+			Details: map[string]interface{}{"argument": "mode"},
+		}), nil
+	}
+	contextLines := DEFAULT_DIFF_LINES
+	if value, found := request.Params.Arguments["context"]; found {
+		number, ok := value.(float64)
+		if !ok || number < 0 || number != float64(int(number)) {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: "Error: 'context' must be a whole number of lines",
+				Details: map[string]interface{}{"argument": "context"},
+			}), nil
+		}
+		contextLines = min(int(number), MAX_INSPECT_LINES)
+	}
+
+	fileA, infoA, toolError := openRegular(nameA)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer fileA.Close()
+	fileB, infoB, toolError := openRegular(nameB)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer fileB.Close()
+
+	offset, line, err := firstDifference(fileA, fileB)
+	if err != nil {
+		return errorResult(*fileError(nameA, err)), nil
+	}
+	if offset < 0 {
+		text := fmt.Sprintf("%s and %s are identical (%s).", nameA, nameB, formatByteSize(infoA.Size()))
+		return &mcp.CallToolResult{Content: []mcp.Content{assistantText(text)}}, nil
+	}
+	summary := fmt.Sprintf("%s and %s differ at byte %d, line %d (sizes %d and %d bytes).", nameA, nameB, offset+1, line, infoA.Size(), infoB.Size())
+	if mode == "bytes" {
+		return &mcp.CallToolResult{Content: []mcp.Content{assistantText(summary)}}, nil
+	}
+
+	// Line diffs need both files in memory, and make no sense for binaries
+	if infoA.Size() > MAX_DIFF_SIZE || infoB.Size() > MAX_DIFF_SIZE {
+		return &mcp.CallToolResult{Content: []mcp.Content{assistantText(summary + fmt.Sprintf(" Files over %s are not diffed line by line.", formatByteSize(MAX_DIFF_SIZE)))}}, nil
+	}
+	dataA, err := os.ReadFile(nameA)
+	if err != nil {
+		return errorResult(*fileError(nameA, err)), nil
+	}
+	dataB, err := os.ReadFile(nameB)
+	if err != nil {
+		return errorResult(*fileError(nameB, err)), nil
+	}
+	if bytes.IndexByte(dataA, 0) >= 0 || bytes.IndexByte(dataB, 0) >= 0 {
+		return &mcp.CallToolResult{Content: []mcp.Content{assistantText(summary + " Binary files are not diffed line by line.")}}, nil
+	}
+	ops, ok := diffLines(splitLines(dataA), splitLines(dataB), MAX_DIFF_EDITS)
+	if !ok {
+		return &mcp.CallToolResult{Content: []mcp.Content{assistantText(summary + fmt.Sprintf(" They differ in more than %d lines, so no diff is shown.", MAX_DIFF_EDITS))}}, nil
+	}
+	diff := unifiedDiff(ops, contextLines)
+	if diff == "" {
+		// Only line endings differ
+		return &mcp.CallToolResult{Content: []mcp.Content{assistantText(summary + " The lines are equal; only line endings differ.")}}, nil
+	}
+	text := fmt.Sprintf("--- %s\n+++ %s\n%s", nameA, nameB, diff)
+	if len(text) > MAX_OUTPUT_SIZE {
+		text = text[:MAX_OUTPUT_SIZE] + "\n... (diff truncated due to size limit)"
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{assistantText(text)}}, nil
+}
+
+func (s *ShellServer) handleFindFiles(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	// The path defaults to the working directory, as for find
+	if _, found := request.Params.Arguments["path"]; !found {
+		arguments := map[string]interface{}{"path": "."}
+		for key, value := range request.Params.Arguments {
+			arguments[key] = value
+		}
+		request.Params.Arguments = arguments
+	}
+	root, toolError := s.inspectPath(ctx, request, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	invalid := func(argument string, format string, args ...interface{}) (*mcp.CallToolResult, error) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: " + fmt.Sprintf(format, args...),
+			Details: map[string]interface{}{"argument": argument},
+		}), nil
+	}
+	filter := findFilter{maxSize: -1}
+	filter.name, _ = request.Params.Arguments["name"].(string)
+	if _, err := filepath.Match(filter.name, ""); err != nil {
+		return invalid("name", "invalid 'name' pattern: %v", err)
+	}
+	filter.kind, _ = request.Params.Arguments["type"].(string)
+	if filter.kind != "" && filter.kind != "file" && filter.kind != "directory" {
+		return invalid("type", "'type' must be file or directory")
+	}
+	for argument, target := range map[string]*int64{"min_size": &filter.minSize, "max_size": &filter.maxSize} {
+		if value, found := request.Params.Arguments[argument]; found {
+			number, ok := value.(float64)
+			if !ok || number < 0 {
+				return invalid(argument, "'%s' must be a number of bytes", argument)
+			}
+			*target = int64(number)
+		}
+	}
+	now := time.Now()
+	for argument, target := range map[string]*time.Time{"newer_than": &filter.newerThan, "older_than": &filter.olderThan} {
+		if value, ok := request.Params.Arguments[argument].(string); ok && value != "" {
+			age, err := parseAge(value)
+			if err != nil {
+				return invalid(argument, "%v", err)
+			}
+			*target = now.Add(-age)
+		}
+	}
+	if value, found := request.Params.Arguments["max_depth"]; found {
+		number, ok := value.(float64)
+		if !ok || number < 1 {
+			return invalid("max_depth", "'max_depth' must be a positive number")
+		}
+		filter.maxDepth = int(number)
+	}
+	limit, toolError := countArgument(request, "limit", DEFAULT_FIND_LIMIT, MAX_FIND_LIMIT)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	projectName, _ := request.Params.Arguments["project"].(string)
+	result, err := findFiles(root, filter, limit, s.protectedPaths(projectName, clientName(s.clientIdentity(ctx))))
+	if err != nil {
+		return errorResult(*fileError(root, err)), nil
+	}
+
+	var text strings.Builder
+	switch {
+	case len(result.Matches) == 0:
+		fmt.Fprintf(&text, "No paths under %s match.", root)
+	case result.Truncated:
+		fmt.Fprintf(&text, "More than %d paths under %s match; the first %d are:", limit, root, limit)
+	default:
+		fmt.Fprintf(&text, "%d paths under %s match:", len(result.Matches), root)
+	}
+	for _, match := range result.Matches {
+		fmt.Fprintf(&text, "\n%s  %s  %s", match.Path, formatByteSize(match.Size), match.ModTime.Format(time.RFC3339))
+	}
+	if result.Protected > 0 {
+		fmt.Fprintf(&text, "\n\n%d paths under protected paths were left out.", result.Protected)
+	}
+	return jsonResult(text.String(), FIND_URI, result), nil
+}
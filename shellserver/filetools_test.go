@@ -0,0 +1,177 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		context int
+		want    string
+	}{
+		{"a b c", "a b c", 3, ""},
+		{"a b c", "a x c", 3, "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"a b c", "a b c d", 1, "@@ -3,1 +3,2 @@\n c\n+d\n"},
+		{"a b c", "b c", 0, "@@ -1,1 +0,0 @@\n-a\n"},
+		{"", "a", 3, "@@ -0,0 +1,1 @@\n+a\n"},
+		{"1 2 3 4 5 6 7 8 9", "x 2 3 4 5 6 7 8 y", 1, "@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -8,2 +8,2 @@\n 8\n-9\n+y\n"},
+		{"1 2 3 4 5", "x 2 3 4 y", 2, "@@ -1,5 +1,5 @@\n-1\n+x\n 2\n 3\n 4\n-5\n+y\n"},
+	}
+
+	for _, tt := range tests {
+		ops, ok := diffLines(strings.Fields(tt.a), strings.Fields(tt.b), MAX_DIFF_EDITS)
+		if got := unifiedDiff(ops, tt.context); !ok || got != tt.want {
+			t.Errorf("diff %q %q = %q, %v, want %q", tt.a, tt.b, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := diffLines(strings.Fields("a b c d"), strings.Fields("w x y z"), 3); ok {
+		t.Errorf("diffLines found a script of more than 3 edits")
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+	tests := []struct {
+		a, b       string
+		wantOffset int64
+		wantLine   int
+	}{
+		{"same\n", "same\n", -1, 0},
+		{"one\ntwo\n", "one\ntwX\n", 6, 2},
+		{"short", "short and long", 5, 1},
+		{"", "x", 0, 1},
+		{strings.Repeat("x", 70000) + "a", strings.Repeat("x", 70000) + "b", 70000, 1},
+	}
+
+	for _, tt := range tests {
+		offset, line, err := firstDifference(strings.NewReader(tt.a), strings.NewReader(tt.b))
+		if err != nil || offset != tt.wantOffset || (offset >= 0 && line != tt.wantLine) {
+			t.Errorf("firstDifference(%.10q, %.10q) = %d, %d, %v, want %d, %d", tt.a, tt.b, offset, line, err, tt.wantOffset, tt.wantLine)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30m", 30 * time.Minute, false},
+		{"12h", 12 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"-1h", 0, true},
+		{"xd", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAge(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAge(%q) = %v, %v, want %v (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFileTools(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\nthree\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("one\n2\nthree\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "copy.txt"), []byte("one\ntwo\nthree\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "crlf.txt"), []byte("one\r\ntwo\r\nthree\r\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "bin1"), []byte("a\x00b"), 0o644)
+	os.WriteFile(filepath.Join(dir, "bin2"), []byte("a\x00c"), 0o644)
+	os.MkdirAll(filepath.Join(dir, "src", "deep"), 0o755)
+	os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "src", "deep", "util.go"), []byte("package deep\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "src", "old.go"), []byte("package main\n"), 0o644)
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "src", "old.go"), old, old)
+	os.Mkdir(filepath.Join(dir, "secrets"), 0o755)
+	os.WriteFile(filepath.Join(dir, "secrets", "key.go"), []byte("hunter2\n"), 0o600)
+	os.Symlink(filepath.Join(dir, "secrets"), filepath.Join(dir, "src", "link"))
+
+	s, err := NewShellServer(
+		WithAllowedCommands("ls"),
+		WithPolicyRules(&PolicyRules{DenyPaths: []string{"secrets"}}),
+		WithProjects([]Project{{Name: "app", Dir: dir}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		tool     string
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{"hash_file", map[string]interface{}{"path": "a.txt"}, "b6285c57e8797db5d4c51c80d6f11938afda9b11c6a003549709189e9b4b92a2  " + filepath.Join(dir, "a.txt"), ""},
+		{"hash_file", map[string]interface{}{"path": "a.txt", "algorithm": "MD5"}, "deed54b823522e0525693b090363f9df  ", ""},
+		{"hash_file", map[string]interface{}{"path": "a.txt", "algorithm": "crc32"}, "unknown algorithm 'crc32'", ERROR_INVALID_ARGUMENT},
+		{"hash_file", map[string]interface{}{"path": "secrets/key.go"}, "under the protected path 'secrets'", ERROR_POLICY_DENIED},
+		{"hash_file", map[string]interface{}{"path": "src"}, "is a directory", ERROR_INVALID_ARGUMENT},
+		{"compare_files", map[string]interface{}{"path": "a.txt", "other_path": "copy.txt"}, "are identical (14 B)", ""},
+		{"compare_files", map[string]interface{}{"path": "a.txt", "other_path": "b.txt", "mode": "bytes"}, "differ at byte 5, line 2 (sizes 14 and 12 bytes).", ""},
+		{"compare_files", map[string]interface{}{"path": "a.txt", "other_path": "b.txt"}, "+++ " + filepath.Join(dir, "b.txt") + "\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n", ""},
+		{"compare_files", map[string]interface{}{"path": "a.txt", "other_path": "b.txt", "context": float64(0)}, "@@ -2,1 +2,1 @@\n-two\n+2\n", ""},
+		{"compare_files", map[string]interface{}{"path": "a.txt", "other_path": "crlf.txt"}, "only line endings differ", ""},
+		{"compare_files", map[string]interface{}{"path": "bin1", "other_path": "bin2"}, "Binary files are not diffed", ""},
+		{"compare_files", map[string]interface{}{"path": "a.txt", "other_path": "b.txt", "mode": "words"}, "unknown mode 'words'", ERROR_INVALID_ARGUMENT},
+		{"compare_files", map[string]interface{}{"path": "a.txt", "other_path": "secrets/key.go"}, "under the protected path 'secrets'", ERROR_POLICY_DENIED},
+		{"compare_files", map[string]interface{}{"path": "a.txt"}, "'other_path' must be a non-empty string", ERROR_INVALID_ARGUMENT},
+		{"find_files", map[string]interface{}{"path": "src", "name": "*.go"}, "3 paths under " + filepath.Join(dir, "src") + " match", ""},
+		{"find_files", map[string]interface{}{"name": "*.go", "max_depth": float64(2)}, "2 paths under", ""},
+		{"find_files", map[string]interface{}{"name": "*.go", "older_than": "1d"}, "1 paths under", ""},
+		{"find_files", map[string]interface{}{"name": "*.go", "newer_than": "1h"}, "2 paths under", ""},
+		{"find_files", map[string]interface{}{"type": "directory"}, "3 paths under", ""},
+		{"find_files", map[string]interface{}{"type": "file", "min_size": float64(14)}, "3 paths under", ""},
+		{"find_files", map[string]interface{}{"type": "file", "max_size": float64(3)}, "2 paths under", ""},
+		{"find_files", map[string]interface{}{"name": "*.go", "limit": float64(1)}, "More than 1 paths", ""},
+		{"find_files", map[string]interface{}{"name": "*.md"}, "No paths under", ""},
+		{"find_files", map[string]interface{}{"name": "["}, "invalid 'name' pattern", ERROR_INVALID_ARGUMENT},
+		{"find_files", map[string]interface{}{"path": "/etc", "name": "shadow"}, "outside the server's and the projects' directories", ERROR_POLICY_DENIED},
+		{"hash_file", map[string]interface{}{"path": "/etc/passwd"}, "outside the server's and the projects' directories", ERROR_POLICY_DENIED},
+		{"find_files", map[string]interface{}{"newer_than": "soon"}, "invalid age 'soon'", ERROR_INVALID_ARGUMENT},
+		{"find_files", map[string]interface{}{"type": "socket"}, "'type' must be file or directory", ERROR_INVALID_ARGUMENT},
+		{"find_files", map[string]interface{}{"path": "secrets"}, "under the protected path 'secrets'", ERROR_POLICY_DENIED},
+	}
+
+	handlers := map[string]func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error){
+		"hash_file":     s.handleHashFile,
+		"compare_files": s.handleCompareFiles,
+		"find_files":    s.handleFindFiles,
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		request.Params.Arguments["project"] = "app"
+		result, _ := handlers[tt.tool](context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("%s(%v) = %q (code %q), want %q (code %q)", tt.tool, tt.args, text, code, tt.want, tt.wantCode)
+		}
+	}
+
+	// Protected paths are neither listed nor searched, even without a name filter
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"project": "app"}
+	result, _ := s.handleFindFiles(context.Background(), request)
+	text := result.Content[0].(mcp.TextContent).Text
+	if strings.Contains(text, "key.go") || !strings.Contains(text, "1 paths under protected paths were left out") {
+		t.Errorf("find_files listed a protected path: %q", text)
+	}
+}
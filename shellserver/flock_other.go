@@ -0,0 +1,11 @@
+//go:build !unix
+
+package shellserver
+
+import "os"
+
+// tryLockFile does nothing, and reports the lock as taken: without flock,
+// file locks only serialize the server's own commands
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,321 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Limits for expand_glob
+const (
+	DEFAULT_GLOB_LIMIT     = 200                 // Paths returned when no limit is given
+	MAX_GLOB_LIMIT         = 5000                // Most paths a single expansion returns
+	MAX_BRACE_ALTERNATIVES = 256                 // Most patterns a brace expression expands to
+	GLOB_URI               = "shell://glob.json" // URI of the structured expansion
+)
+
+// GlobExpansion is what a pattern expands to, as returned by expand_glob
+type GlobExpansion struct {
+	Pattern   string   `json:"pattern"`
+	Dir       string   `json:"dir"` // Directory relative patterns were expanded in
+	Matches   []string `json:"matches"`
+	Truncated bool     `json:"truncated"` // More paths match than were returned
+	Protected int      `json:"protected"` // Paths left out because they are under a protected path
+}
+
+// globber expands a pattern the way bash does with globstar enabled: "*",
+// "?" and "[...]" match within a path component, "**" matches any number of
+// directories, and names starting with "." only match patterns that do.
+// Paths for which protected returns true are left out and not searched.
+type globber struct {
+	dir       string
+	limit     int
+	protected func(string) bool
+	seen      map[string]bool
+	result    *GlobExpansion
+}
+
+// expandGlob expands pattern in dir, returning at most limit paths
+func expandGlob(pattern, dir string, limit int, protected func(string) bool) (*GlobExpansion, error) {
+	g := &globber{
+		dir:       dir,
+		limit:     limit,
+		protected: protected,
+		seen:      make(map[string]bool),
+		result:    &GlobExpansion{Pattern: pattern, Dir: dir, Matches: []string{}},
+	}
+
+	for _, alternative := range expandBraces(pattern) {
+		base, rest := "", alternative
+		if home, err := os.UserHomeDir(); err == nil && (rest == "~" || strings.HasPrefix(rest, "~/")) {
+			rest = home + rest[1:]
+		}
+		if strings.HasPrefix(rest, "/") {
+			base = "/"
+		}
+
+		var components []string
+		for _, component := range strings.Split(rest, "/") {
+			if component == "" {
+				continue
+			}
+			if _, err := filepath.Match(component, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern '%s': %v", component, err)
+			}
+			components = append(components, component)
+		}
+		if len(components) == 0 {
+			continue
+		}
+		if !g.walk(base, components) {
+			break
+		}
+	}
+	return g.result, nil
+}
+
+// walk matches components below base, returning false once the limit is hit
+func (g *globber) walk(base string, components []string) bool {
+	if len(components) == 0 {
+		return g.add(base)
+	}
+
+	component := components[0]
+	if component == "**" {
+		if !g.walk(base, components[1:]) {
+			return false
+		}
+		// Symlinked directories are not followed, as in bash
+		for _, entry := range g.readDir(base) {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				if next := g.join(base, entry.Name()); next != "" && !g.walk(next, components) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	if !strings.ContainsAny(component, "*?[\\") {
+		return g.descend(base, component, components[1:])
+	}
+	for _, entry := range g.readDir(base) {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(component, ".") {
+			continue
+		}
+		if matched, _ := filepath.Match(component, name); matched && !g.descend(base, name, components[1:]) {
+			return false
+		}
+	}
+	return true
+}
+
+// descend continues with the entry name of base, if it exists and, when
+// more components follow, is a directory
+func (g *globber) descend(base, name string, rest []string) bool {
+	next := g.join(base, name)
+	if next == "" {
+		return true
+	}
+	info, err := os.Stat(g.path(next))
+	if err != nil && len(rest) == 0 {
+		// Dangling symlinks still match
+		info, err = os.Lstat(g.path(next))
+	}
+	if err != nil || (len(rest) > 0 && !info.IsDir()) {
+		return true
+	}
+	return g.walk(next, rest)
+}
+
+// join returns base/name, or "" if the result is protected
+func (g *globber) join(base, name string) string {
+	next := name
+	if base != "" {
+		next = strings.TrimSuffix(base, "/") + "/" + name
+	}
+	if g.protected != nil && (g.protected(next) || g.protected(g.path(next))) {
+		g.result.Protected++
+		return ""
+	}
+	return next
+}
+
+// add records a match, returning false once the limit is hit
+func (g *globber) add(match string) bool {
+	if g.seen[match] {
+		return true
+	}
+	if len(g.result.Matches) >= g.limit {
+		g.result.Truncated = true
+		return false
+	}
+	g.seen[match] = true
+	g.result.Matches = append(g.result.Matches, match)
+	return true
+}
+
+// path returns where a match is on disk
+func (g *globber) path(match string) string {
+	if filepath.IsAbs(match) {
+		return match
+	}
+	return filepath.Join(g.dir, match)
+}
+
+// readDir lists base sorted by name; unreadable directories match nothing
+func (g *globber) readDir(base string) []os.DirEntry {
+	dir := g.dir
+	if base != "" {
+		dir = g.path(base)
+	}
+	entries, _ := os.ReadDir(dir)
+	return entries
+}
+
+// expandBraces expands brace expressions such as "*.{o,a}" into one pattern
+// per alternative, as bash does before globbing
+func expandBraces(pattern string) []string {
+	depth, open := 0, -1
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				open, commas = i, nil
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if len(commas) == 0 {
+				// "{}" and "{a}" are not expanded
+				continue
+			}
+
+			prefix, suffix := pattern[:open], pattern[i+1:]
+			var results []string
+			start := open + 1
+			for _, end := range append(commas, i) {
+				results = append(results, expandBraces(prefix+pattern[start:end]+suffix)...)
+				if len(results) >= MAX_BRACE_ALTERNATIVES {
+					return results[:MAX_BRACE_ALTERNATIVES]
+				}
+				start = end + 1
+			}
+			return results
+		}
+	}
+	return []string{pattern}
+}
+
+// workDir returns the directory execute_command runs in for a project, or
+// for the server's own directory if projectName is empty
+func (s *ShellServer) workDir(projectName string) (string, *ToolError) {
+	if projectName == "" {
+		dir, _ := os.Getwd()
+		return dir, nil
+	}
+	p, found := s.projects[projectName]
+	if !found {
+		return "", &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_UNKNOWN_PROJECT, projectName),
+			Details: map[string]interface{}{"argument": "project", "project": projectName, "projects": s.ProjectNames()},
+		}
+	}
+	return p.Dir, nil
+}
+
+// protectedPaths returns a check for paths under the protected paths of a
+// project's policy for a client, or nil if it has none
+func (s *ShellServer) protectedPaths(projectName string, client string) func(string) bool {
+	allowlist, ok := s.policyFor(projectName, client).(*AllowlistPolicy)
+	if !ok || len(allowlist.DeniedPaths()) == 0 {
+		return nil
+	}
+	return func(p string) bool {
+		return allowlist.protects(p) != ""
+	}
+}
+
+func (s *ShellServer) handleExpandGlob(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	pattern, ok := request.Params.Arguments["pattern"].(string)
+	if !ok || strings.TrimSpace(pattern) == "" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'pattern' must be a non-empty string",
+			Details: map[string]interface{}{"argument": "pattern"},
+		}), nil
+	}
+
+	limit := DEFAULT_GLOB_LIMIT
+	if limitArg, ok := request.Params.Arguments["limit"].(float64); ok && limitArg > 0 {
+		limit = min(int(limitArg), MAX_GLOB_LIMIT)
+	}
+
+	// Expand where execute_command would run
+	projectName, _ := request.Params.Arguments["project"].(string)
+	dir, toolError := s.workDir(projectName)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	expansion, err := expandGlob(pattern, dir, limit, s.protectedPaths(projectName, clientName(s.clientIdentity(ctx))))
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: " + err.Error(),
+			Details: map[string]interface{}{"argument": "pattern"},
+		}), nil
+	}
+
+	var text strings.Builder
+	switch {
+	case len(expansion.Matches) == 0:
+		fmt.Fprintf(&text, "'%s' matches nothing in %s; the shell would pass it on unexpanded.", pattern, dir)
+	case expansion.Truncated:
+		fmt.Fprintf(&text, "'%s' matches more than %d paths in %s; the first %d are:\n%s", pattern, limit, dir, limit, strings.Join(expansion.Matches, "\n"))
+	default:
+		fmt.Fprintf(&text, "'%s' matches %d paths in %s:\n%s", pattern, len(expansion.Matches), dir, strings.Join(expansion.Matches, "\n"))
+	}
+	if expansion.Protected > 0 {
+		fmt.Fprintf(&text, "\n\n%d paths under protected paths were left out.", expansion.Protected)
+	}
+
+	// An expansion of plain values always marshals
+	data, _ := json.Marshal(expansion)
+	resource := mcp.EmbeddedResource{
+		Type: "resource",
+		Resource: mcp.TextResourceContents{
+			URI:      GLOB_URI,
+			MIMEType: JSON_MIME_TYPE,
+			Text:     string(data),
+		},
+	}
+	annotate(&resource.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{assistantText(text.String()), resource},
+	}, nil
+}
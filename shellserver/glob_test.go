@@ -0,0 +1,78 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"*.o", []string{"*.o"}},
+		{"*.{o,a}", []string{"*.o", "*.a"}},
+		{"{src,lib}/*.{c,h}", []string{"src/*.c", "src/*.h", "lib/*.c", "lib/*.h"}},
+		{"a{b,{c,d}}", []string{"ab", "ac", "ad"}},
+		{"x{}y{a}", []string{"x{}y{a}"}},
+		{`\{a,b}`, []string{`\{a,b}`}},
+	}
+
+	for _, test := range tests {
+		if result := expandBraces(test.pattern); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("expandBraces(%q) = %q, want %q", test.pattern, result, test.expected)
+		}
+	}
+}
+
+func TestExpandGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"main.o", "main.c", ".hidden.o",
+		"build/a.o", "build/sub/b.o", "build/sub/b.a", "build/.cache/c.o",
+		"secrets/key.o",
+	} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	protected := func(p string) bool { return refersToPath(p, []string{"secrets"}) }
+
+	tests := []struct {
+		pattern   string
+		limit     int
+		expected  string
+		truncated bool
+		protected int
+	}{
+		{"*.o", 10, "main.o", false, 0},
+		{".*.o", 10, ".hidden.o", false, 0},
+		{"build/**/*.o", 10, "build/a.o,build/sub/b.o", false, 0},
+		{"build/sub/*.{o,a}", 10, "build/sub/b.o,build/sub/b.a", false, 0},
+		{"**/*.o", 2, "main.o,build/a.o", true, 0},
+		{"*/*.o", 10, "build/a.o", false, 1},
+		{"secrets/key.o", 10, "", false, 1},
+		{"build/missing/*", 10, "", false, 0},
+		{"main.c/*", 10, "", false, 0},
+		{dir + "/build/*.o", 10, dir + "/build/a.o", false, 0},
+	}
+
+	for _, test := range tests {
+		expansion, err := expandGlob(test.pattern, dir, test.limit, protected)
+		if err != nil {
+			t.Errorf("expandGlob(%q) failed: %v", test.pattern, err)
+			continue
+		}
+		matches := strings.Join(expansion.Matches, ",")
+		if matches != test.expected || expansion.Truncated != test.truncated || expansion.Protected != test.protected {
+			t.Errorf("expandGlob(%q) = %q (truncated %v, protected %d), want %q (truncated %v, protected %d)",
+				test.pattern, matches, expansion.Truncated, expansion.Protected, test.expected, test.truncated, test.protected)
+		}
+	}
+
+	if _, err := expandGlob("build/[a", dir, 10, nil); err == nil {
+		t.Errorf("expandGlob with an unclosed bracket succeeded, want an error")
+	}
+}
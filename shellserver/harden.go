@@ -0,0 +1,33 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+)
+
+// openPrivateFile opens a file that holds commands and their output, such
+// as the history or an audit log. New files are created readable by the
+// owner only, and existing files that other users can access are tightened.
+func openPrivateFile(path string, flag int) (*os.File, error) {
+	file, err := os.OpenFile(path, flag|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, writableError(err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		if err := file.Chmod(info.Mode().Perm() &^ 0077); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to restrict permissions of %s: %v", path, err)
+		}
+	}
+	return file, nil
+}
@@ -0,0 +1,11 @@
+package shellserver
+
+import "syscall"
+
+// setNotDumpable clears the dumpable flag, which exec resets for commands
+func setNotDumpable() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_DUMPABLE, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
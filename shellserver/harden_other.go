@@ -0,0 +1,8 @@
+//go:build !unix
+
+package shellserver
+
+// DisableCoreDumps does nothing; this platform has no core dump rlimit
+func DisableCoreDumps() error {
+	return nil
+}
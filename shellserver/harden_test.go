@@ -0,0 +1,35 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestOpenPrivateFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions")
+	}
+	dir := t.TempDir()
+
+	created := filepath.Join(dir, "history.jsonl")
+	shared := filepath.Join(dir, "audit.jsonl")
+	os.WriteFile(shared, nil, 0644)
+	os.Chmod(shared, 0664)
+
+	for _, path := range []string{created, shared} {
+		file, err := openPrivateFile(path, os.O_APPEND|os.O_WRONLY)
+		if err != nil {
+			t.Fatalf("openPrivateFile(%s) failed: %v", path, err)
+		}
+		file.Close()
+		if info, _ := os.Stat(path); info.Mode().Perm()&0077 != 0 {
+			t.Errorf("%s has mode %v, want no group or world access", path, info.Mode().Perm())
+		}
+	}
+
+	if _, err := openPrivateFile(dir, os.O_RDONLY); err == nil {
+		t.Errorf("openPrivateFile should refuse a directory")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package shellserver
+
+import "syscall"
+
+// DisableCoreDumps stops the server from writing core dumps, which would
+// contain command output and secrets such as webhook keys. Commands inherit
+// the limit. On Linux the process is also marked non-dumpable, so other
+// processes of the same user cannot ptrace it or read its memory.
+func DisableCoreDumps() error {
+	if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{}); err != nil {
+		return err
+	}
+	return setNotDumpable()
+}
@@ -0,0 +1,8 @@
+//go:build unix && !linux
+
+package shellserver
+
+// setNotDumpable does nothing; the rlimit is the only control here
+func setNotDumpable() error {
+	return nil
+}
@@ -0,0 +1,422 @@
+package shellserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// History store kinds accepted by --history
+const (
+	HISTORY_MEMORY = "memory" // Most recent commands, lost on restart
+	HISTORY_JSONL  = "jsonl"  // Every command appended to a JSON lines file
+	HISTORY_SQLITE = "sqlite" // Every command inserted into a SQLite database; needs a driver, see ParseHistoryStore
+)
+
+// MAX_HISTORY_LINE is the most bytes of a line of a jsonl history file that
+// are read; longer lines are skipped when the file is loaded
+const MAX_HISTORY_LINE = 64 * 1024 * 1024
+
+// HistoryStore stores executed commands, newest first
+type HistoryStore interface {
+	Add(execution CommandExecution) error
+	// Recent returns up to limit executions, newest first; limit <= 0 returns all
+	Recent(limit int) ([]CommandExecution, error)
+	// Count returns the total number of stored executions
+	Count() (int, error)
+}
+
+// HistoryClearer is implemented by history stores that can forget every
+// execution, for the clear_history admin tool
+type HistoryClearer interface {
+	Clear() error
+}
+
+// HistorySnapshotter is implemented by history stores that can return the
+// newest executions and the total count as of one moment, so a listing
+// made while commands finish does not count executions it does not show
+type HistorySnapshotter interface {
+	Snapshot(limit int) ([]CommandExecution, int, error)
+}
+
+// HistoryPruner is implemented by history stores that can forget the
+// executions started before a cutoff, for the retention job (see
+// WithStorageRetention)
+type HistoryPruner interface {
+	// Prune returns how many executions it removed and the bytes of
+	// storage that freed
+	Prune(cutoff time.Time) (int, int64, error)
+}
+
+// memoryHistory keeps the most recent executions in memory, in a ring
+// buffer so adding does not copy the whole history. Readers share the lock
+// and get a copy, so iterating it is unaffected by later adds.
+type memoryHistory struct {
+	mutex      sync.RWMutex
+	executions []CommandExecution
+	oldest     int // Index of the oldest execution once the buffer is full
+	count      int
+}
+
+// newMemoryHistory creates a history that keeps at most maxSize executions
+func newMemoryHistory(maxSize int) *memoryHistory {
+	return &memoryHistory{executions: make([]CommandExecution, maxSize)}
+}
+
+// Add adds a command execution, replacing the oldest one when full
+func (h *memoryHistory) Add(execution CommandExecution) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.executions) == 0 {
+		return nil
+	}
+	if h.count < len(h.executions) {
+		h.executions[h.count] = execution
+		h.count++
+		return nil
+	}
+	h.executions[h.oldest] = execution
+	h.oldest = (h.oldest + 1) % len(h.executions)
+	return nil
+}
+
+// Recent returns a copy of the newest executions, newest first
+func (h *memoryHistory) Recent(limit int) ([]CommandExecution, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.newest(limit), nil
+}
+
+// newest copies up to limit of the newest executions; the caller holds the
+// lock
+func (h *memoryHistory) newest(limit int) []CommandExecution {
+	if limit <= 0 || limit > h.count {
+		limit = h.count
+	}
+
+	result := make([]CommandExecution, limit)
+	newest := h.oldest + h.count - 1
+	for i := range result {
+		result[i] = h.executions[(newest-i)%len(h.executions)]
+	}
+	return result
+}
+
+// Count returns the number of stored executions
+func (h *memoryHistory) Count() (int, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.count, nil
+}
+
+// Snapshot returns the newest executions and the number stored under one lock
+func (h *memoryHistory) Snapshot(limit int) ([]CommandExecution, int, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.newest(limit), h.count, nil
+}
+
+// Clear forgets every execution
+func (h *memoryHistory) Clear() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	clear(h.executions)
+	h.oldest, h.count = 0, 0
+	return nil
+}
+
+// Prune forgets the executions started before cutoff. Memory is not
+// storage, so no bytes are reported.
+func (h *memoryHistory) Prune(cutoff time.Time) (int, int64, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	kept := h.newest(0)
+	clear(h.executions)
+	h.oldest, h.count = 0, 0
+	removed := 0
+	for i := len(kept) - 1; i >= 0; i-- {
+		if kept[i].StartTime.Before(cutoff) {
+			removed++
+			continue
+		}
+		h.executions[h.count] = kept[i]
+		h.count++
+	}
+	return removed, 0, nil
+}
+
+// jsonlHistory appends every execution to a JSON lines file so history
+// survives restarts. The newest executions are cached in memory for listing.
+type jsonlHistory struct {
+	mutex  sync.RWMutex
+	path   string
+	file   *os.File
+	recent *memoryHistory
+	total  int
+}
+
+// newJSONLHistory opens or creates path and loads its newest executions,
+// salvaging a line a crash left half written
+func newJSONLHistory(path string, maxSize int) (*jsonlHistory, error) {
+	file, err := openPrivateFile(path, os.O_RDWR|os.O_APPEND)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := recoverAppendLog(file, path); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to recover history file: %v", err)
+	}
+
+	h := &jsonlHistory{path: path, file: file, recent: newMemoryHistory(maxSize)}
+	skipped, err := readHistoryLines(file, MAX_HISTORY_LINE, func(line []byte) {
+		var execution CommandExecution
+		if json.Unmarshal(line, &execution) != nil {
+			return // Skip lines damaged before crash recovery existed
+		}
+		h.recent.Add(execution)
+		h.total++
+	})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read history file: %v", err)
+	}
+	if skipped > 0 {
+		log.Printf("Warning: skipped %d lines of %s longer than %d bytes", skipped, path, MAX_HISTORY_LINE)
+	}
+	return h, nil
+}
+
+// readHistoryLines calls fn with each line of r, without its newline. Lines longer
+// than limit are not kept in memory but skipped and counted.
+func readHistoryLines(r io.Reader, limit int, fn func(line []byte)) (int, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var line []byte
+	skipped, tooLong := 0, false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong && len(line)+len(chunk) > limit+1 {
+			tooLong, line = true, line[:0]
+		} else if !tooLong {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if tooLong {
+			skipped++
+		} else if len(line) > 0 {
+			fn(bytes.TrimSuffix(line, []byte("\n")))
+		}
+		line, tooLong = line[:0], false
+		if err == io.EOF {
+			return skipped, nil
+		}
+		if err != nil {
+			return skipped, err
+		}
+	}
+}
+
+// Add appends the execution to the file and flushes it to disk
+func (h *jsonlHistory) Add(execution CommandExecution) error {
+	// Escaping <, > and & as \u003c and so on would make output of them six
+	// times as long
+	var line bytes.Buffer
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(execution); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, err := h.file.Write(line.Bytes()); err != nil {
+		return err
+	}
+	if err := h.file.Sync(); err != nil {
+		return err
+	}
+	h.total++
+	return h.recent.Add(execution)
+}
+
+// Clear replaces the file with an empty one and empties the in-memory cache
+func (h *jsonlHistory) Clear() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	// Windows cannot rename over an open file
+	h.file.Close()
+	err := writeFileAtomic(h.path, nil)
+	file, openErr := openPrivateFile(h.path, os.O_RDWR|os.O_APPEND)
+	if openErr != nil {
+		return openErr
+	}
+	h.file = file
+	if err != nil {
+		return err
+	}
+	h.total = 0
+	return h.recent.Clear()
+}
+
+// errNothingPruned stops the rewrite of a history file nothing is pruned from
+var errNothingPruned = errors.New("nothing to prune")
+
+// Prune rewrites the file without the executions started before cutoff.
+// The kept lines are streamed to a temporary file that replaces the old
+// one, so a crash leaves either file whole. Lines that cannot be parsed are
+// kept.
+func (h *jsonlHistory) Prune(cutoff time.Time) (int, int64, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	before, err := h.file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	removed := 0
+	// Windows cannot rename over an open file
+	h.file.Close()
+	err = replaceFileAtomic(h.path, func(w io.Writer) error {
+		old, err := os.Open(h.path)
+		if err != nil {
+			return err
+		}
+		defer old.Close()
+		writer := bufio.NewWriter(w)
+		scanner := bufio.NewScanner(old)
+		scanner.Buffer(make([]byte, 64*1024), MAX_HISTORY_LINE)
+		for scanner.Scan() {
+			var execution CommandExecution
+			if json.Unmarshal(scanner.Bytes(), &execution) == nil && execution.StartTime.Before(cutoff) {
+				removed++
+				continue
+			}
+			writer.Write(scanner.Bytes())
+			writer.WriteByte('\n')
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if removed == 0 {
+			return errNothingPruned
+		}
+		return writer.Flush()
+	})
+	file, openErr := openPrivateFile(h.path, os.O_RDWR|os.O_APPEND)
+	if openErr != nil {
+		return 0, 0, openErr
+	}
+	h.file = file
+	if err == errNothingPruned {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var reclaimed int64
+	if after, err := file.Stat(); err == nil {
+		reclaimed = before.Size() - after.Size()
+	}
+	h.total -= removed
+	h.recent.Prune(cutoff)
+	return removed, reclaimed, nil
+}
+
+// Recent returns the newest executions from the in-memory cache
+func (h *jsonlHistory) Recent(limit int) ([]CommandExecution, error) {
+	return h.recent.Recent(limit)
+}
+
+// Count returns the number of executions in the file
+func (h *jsonlHistory) Count() (int, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.total, nil
+}
+
+// Snapshot returns the newest executions and the number in the file, with
+// no execution added in between
+func (h *jsonlHistory) Snapshot(limit int) ([]CommandExecution, int, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	recent, err := h.recent.Recent(limit)
+	return recent, h.total, err
+}
+
+// ParseHistoryStore builds a history store from a --history spec: "memory",
+// "jsonl:<path>" or "sqlite:<path>". The package does not import a SQLite
+// driver, which needs cgo or a very large pure-Go package; "sqlite:<path>"
+// works once the program registers one with database/sql, e.g. by
+// importing modernc.org/sqlite.
+func ParseHistoryStore(spec string) (HistoryStore, error) {
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", HISTORY_MEMORY:
+		return newMemoryHistory(MAX_HISTORY_SIZE), nil
+	case HISTORY_JSONL:
+		if target == "" {
+			return nil, fmt.Errorf("jsonl history needs a path, e.g. 'jsonl:/var/lib/mcp-shell/history.jsonl'")
+		}
+		return newJSONLHistory(target, MAX_HISTORY_SIZE)
+	case HISTORY_SQLITE:
+		if target == "" {
+			return nil, fmt.Errorf("sqlite history needs a path, e.g. 'sqlite:/var/lib/mcp-shell/history.db'")
+		}
+		return newSQLHistory(target)
+	default:
+		return nil, fmt.Errorf("unknown history store '%s', expected '%s', '%s:<path>' or '%s:<path>'", kind, HISTORY_MEMORY, HISTORY_JSONL, HISTORY_SQLITE)
+	}
+}
+
+// historyFile returns the file a history store keeps executions in, or ""
+// for stores that keep them in memory or elsewhere
+func historyFile(history HistoryStore) string {
+	switch h := history.(type) {
+	case *jsonlHistory:
+		return h.path
+	case *sqlHistory:
+		return h.path
+	}
+	return ""
+}
+
+// historySnapshot returns up to limit of the newest executions and the
+// total count, consistently if the store supports it
+func historySnapshot(history HistoryStore, limit int) ([]CommandExecution, int, error) {
+	if snapshotter, ok := history.(HistorySnapshotter); ok {
+		return snapshotter.Snapshot(limit)
+	}
+	recent, err := history.Recent(limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := history.Count()
+	return recent, total, err
+}
+
+// addToHistory numbers a command execution and adds it to the history. IDs
+// are given under the same lock as the add, so executions that finish
+// together are stored in the order of their IDs.
+func (s *ShellServer) addToHistory(execution CommandExecution) CommandExecution {
+	s.historyMutex.Lock()
+	execution.ID = s.executionID.Add(1)
+	err := s.history.Add(execution)
+	s.historyMutex.Unlock()
+	if err != nil {
+		s.logger.Printf("Failed to record command in history: %v", err)
+		return execution
+	}
+	s.resourceUpdated(HISTORY_RESOURCE_URI)
+	return execution
+}
@@ -0,0 +1,185 @@
+package shellserver
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// sqliteDrivers are the database/sql driver names SQLite drivers register
+// under: modernc.org/sqlite and github.com/mattn/go-sqlite3
+var sqliteDrivers = []string{"sqlite", "sqlite3"}
+
+// sqlHistory stores every execution as a row of a SQLite database. The
+// driver is not built into the package: the program registers one with
+// database/sql, as the mcp-unix-shell command does when built with
+// -tags sqlite. Executions are stored as JSON, as in a jsonl history, with
+// the start time in a column of its own for pruning.
+type sqlHistory struct {
+	mutex sync.RWMutex // Held for writing while adding, so snapshots are consistent
+	path  string
+	db    *sql.DB
+}
+
+// sqliteDriver returns the name of a registered SQLite driver, or ""
+func sqliteDriver() string {
+	registered := sql.Drivers()
+	for _, name := range sqliteDrivers {
+		if slices.Contains(registered, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// newSQLHistory opens or creates the SQLite database at path
+func newSQLHistory(path string) (*sqlHistory, error) {
+	driver := sqliteDriver()
+	if driver == "" {
+		return nil, fmt.Errorf("SQLite history needs a SQLite driver, which this binary was built without; build it with '-tags sqlite' or use 'jsonl:<path>'")
+	}
+	// Create the file first, so the database is only readable by the server
+	file, err := openPrivateFile(path, os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	db, err := sql.Open(driver, path)
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes writes, so SQLite never reports itself busy
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS executions (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			start_time INTEGER NOT NULL,
+			execution TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS executions_start_time ON executions (start_time)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create the history table in %s: %v", path, err)
+		}
+	}
+	return &sqlHistory{path: path, db: db}, nil
+}
+
+// Add inserts the execution
+func (h *sqlHistory) Add(execution CommandExecution) error {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(execution); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := h.db.Exec(`INSERT INTO executions (start_time, execution) VALUES (?, ?)`,
+		execution.StartTime.UnixNano(), string(bytes.TrimSuffix(data.Bytes(), []byte("\n"))))
+	return err
+}
+
+// Recent returns up to limit of the newest executions, newest first
+func (h *sqlHistory) Recent(limit int) ([]CommandExecution, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.newest(limit)
+}
+
+// newest reads up to limit of the newest executions; the caller holds the
+// lock. Rows that cannot be decoded are skipped.
+func (h *sqlHistory) newest(limit int) ([]CommandExecution, error) {
+	if limit <= 0 {
+		limit = -1 // No limit in SQLite
+	}
+	rows, err := h.db.Query(`SELECT execution FROM executions ORDER BY seq DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := []CommandExecution{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var execution CommandExecution
+		if json.Unmarshal([]byte(data), &execution) == nil {
+			executions = append(executions, execution)
+		}
+	}
+	return executions, rows.Err()
+}
+
+// Count returns the number of stored executions
+func (h *sqlHistory) Count() (int, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.count()
+}
+
+// count counts the rows; the caller holds the lock
+func (h *sqlHistory) count() (int, error) {
+	var count int
+	err := h.db.QueryRow(`SELECT COUNT(*) FROM executions`).Scan(&count)
+	return count, err
+}
+
+// Snapshot returns the newest executions and the number stored, with no
+// execution added in between
+func (h *sqlHistory) Snapshot(limit int) ([]CommandExecution, int, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	recent, err := h.newest(limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := h.count()
+	return recent, count, err
+}
+
+// Clear deletes every execution
+func (h *sqlHistory) Clear() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := h.db.Exec(`DELETE FROM executions`)
+	return err
+}
+
+// Prune deletes the executions started before cutoff and vacuums the
+// database, so the file shrinks by the space they took
+func (h *sqlHistory) Prune(cutoff time.Time) (int, int64, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	before, err := os.Stat(h.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	result, err := h.db.Exec(`DELETE FROM executions WHERE start_time < ?`, cutoff.UnixNano())
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, _ := result.RowsAffected()
+	if removed == 0 {
+		return 0, 0, nil
+	}
+	if _, err := h.db.Exec(`VACUUM`); err != nil {
+		return int(removed), 0, err
+	}
+
+	var reclaimed int64
+	if after, err := os.Stat(h.path); err == nil {
+		reclaimed = before.Size() - after.Size()
+	}
+	return int(removed), reclaimed, nil
+}
@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package shellserver
+
+// The SQLite history tests need a driver, which only the sqlite build tag
+// brings in
+import _ "modernc.org/sqlite"
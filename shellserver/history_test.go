@@ -0,0 +1,304 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadHistoryLines(t *testing.T) {
+	long := strings.Repeat("y", 200*1024) // Longer than the reader's buffer
+	tests := []struct {
+		input   string
+		limit   int
+		want    []string
+		skipped int
+	}{
+		{"", 5, nil, 0},
+		{"a\nbb\n", 5, []string{"a", "bb"}, 0},
+		{"\n\nlast", 5, []string{"", "", "last"}, 0},
+		{"short\nxxxxxxxxxx\nend", 5, []string{"short", "end"}, 1},
+		{"a\n" + long + "\nb\n", 100 * 1024, []string{"a", "b"}, 1},
+		{"a\n" + long + "\nb\n", len(long), []string{"a", long, "b"}, 0},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		skipped, err := readHistoryLines(strings.NewReader(tt.input), tt.limit, func(line []byte) {
+			got = append(got, string(line))
+		})
+		if err != nil || skipped != tt.skipped || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("readHistoryLines(%.20q, %d) = %d lines, %d skipped, %v; want %d lines, %d skipped", tt.input, tt.limit, len(got), skipped, err, len(tt.want), tt.skipped)
+		}
+	}
+}
+
+// Output of <, > and & must not grow past what loading the file reads
+func TestJSONLHistoryLargeOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := newJSONLHistory(path, 3)
+	if err != nil {
+		t.Fatalf("newJSONLHistory failed: %v", err)
+	}
+	output := strings.Repeat("<>&", MAX_OUTPUT_SIZE/3)
+	if err := store.Add(CommandExecution{Command: "cat page.html", Output: output}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	store.file.Close()
+	if info, err := os.Stat(path); err != nil || info.Size() > int64(len(output))+1024 {
+		t.Errorf("history file holds %v bytes (%v) for %d bytes of output", info.Size(), err, len(output))
+	}
+
+	reopened, err := newJSONLHistory(path, 3)
+	if err != nil {
+		t.Fatalf("reopening history failed: %v", err)
+	}
+	defer reopened.file.Close()
+	if recent, _ := reopened.Recent(1); len(recent) != 1 || recent[0].Output != output {
+		t.Errorf("reopened history lost the execution with large output")
+	}
+}
+
+func TestJSONLHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := newJSONLHistory(path, 3)
+	if err != nil {
+		t.Fatalf("newJSONLHistory failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := store.Add(CommandExecution{Command: fmt.Sprintf("command%d", i), Shell: "bash"}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	store.file.Close()
+
+	// Simulate a line cut short by a crash
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("failed to open history file: %v", err)
+	}
+	file.WriteString(`{"command":"trunc`)
+	file.Close()
+
+	reopened, err := newJSONLHistory(path, 3)
+	if err != nil {
+		t.Fatalf("reopening history failed: %v", err)
+	}
+	defer reopened.file.Close()
+
+	if count, _ := reopened.Count(); count != 5 {
+		t.Errorf("Count() = %d, want 5", count)
+	}
+	recent, _ := reopened.Recent(0)
+	if len(recent) != 3 || recent[0].Command != "command4" || recent[2].Command != "command2" {
+		t.Errorf("Recent(0) = %+v, want command4..command2", recent)
+	}
+}
+
+func TestSQLiteHistory(t *testing.T) {
+	if sqliteDriver() == "" {
+		t.Skip("no SQLite driver is registered; run with -tags sqlite")
+	}
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := newSQLHistory(path)
+	if err != nil {
+		t.Fatalf("newSQLHistory failed: %v", err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		execution := CommandExecution{ID: int64(i + 1), Command: fmt.Sprintf("echo '<%d>' && true", i), Shell: "bash", StartTime: start.Add(time.Duration(i) * time.Hour)}
+		if err := store.Add(execution); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	store.db.Close()
+	if info, err := os.Stat(path); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm() != 0o600) {
+		t.Errorf("history database mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	reopened, err := newSQLHistory(path)
+	if err != nil {
+		t.Fatalf("reopening history failed: %v", err)
+	}
+	defer reopened.db.Close()
+	if count, _ := reopened.Count(); count != 5 {
+		t.Errorf("Count() = %d, want 5", count)
+	}
+	recent, total, _ := reopened.Snapshot(2)
+	if total != 5 || len(recent) != 2 || recent[0].ID != 5 || recent[0].Command != "echo '<4>' && true" || recent[1].ID != 4 {
+		t.Errorf("Snapshot(2) = %+v, %d, want executions 5 and 4 of 5", recent, total)
+	}
+
+	if removed, _, err := reopened.Prune(start.Add(2 * time.Hour)); err != nil || removed != 2 {
+		t.Errorf("Prune = %d, %v, want 2 removed", removed, err)
+	}
+	if recent, _ := reopened.Recent(0); len(recent) != 3 || recent[2].ID != 3 {
+		t.Errorf("Recent(0) after Prune = %+v, want executions 5 to 3", recent)
+	}
+	if err := reopened.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if count, _ := reopened.Count(); count != 0 {
+		t.Errorf("Count() after Clear = %d, want 0", count)
+	}
+}
+
+func TestParseHistoryStore(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		spec    string
+		wantErr string
+	}{
+		{"memory", ""},
+		{"", ""},
+		{"jsonl:" + filepath.Join(dir, "history.jsonl"), ""},
+		{"jsonl", "needs a path"},
+		{"jsonl:" + filepath.Join(dir, "missing", "history.jsonl"), "no such file"},
+		{"sqlite", "needs a path"},
+		{"redis", "unknown history store"},
+	}
+	if sqliteDriver() == "" {
+		tests = append(tests, struct {
+			spec    string
+			wantErr string
+		}{"sqlite:" + filepath.Join(dir, "history.db"), "build it with '-tags sqlite'"})
+	}
+
+	for _, tt := range tests {
+		store, err := ParseHistoryStore(tt.spec)
+		if tt.wantErr == "" {
+			if err != nil || store == nil {
+				t.Errorf("ParseHistoryStore(%q) error = %v, want a store", tt.spec, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseHistoryStore(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+// recentHistory returns the newest executions, failing the test on error
+func recentHistory(t *testing.T, s *ShellServer, limit int) []CommandExecution {
+	t.Helper()
+	history, err := s.history.Recent(limit)
+	if err != nil {
+		t.Fatalf("Recent(%d) failed: %v", limit, err)
+	}
+	return history
+}
+
+// historyCount returns the number of stored executions, failing the test on error
+func historyCount(t *testing.T, s *ShellServer) int {
+	t.Helper()
+	count, err := s.history.Count()
+	if err != nil {
+		t.Fatalf("Count() failed: %v", err)
+	}
+	return count
+}
+
+func BenchmarkMemoryHistoryAdd(b *testing.B) {
+	history := newMemoryHistory(MAX_HISTORY_SIZE)
+	execution := CommandExecution{Command: "echo hello", Shell: "bash", Output: "hello\n"}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		history.Add(execution)
+	}
+}
+
+func TestMemoryHistoryRing(t *testing.T) {
+	history := newMemoryHistory(3)
+	for i := 0; i < 7; i++ {
+		history.Add(CommandExecution{Command: fmt.Sprintf("command%d", i)})
+
+		// Check the order after every insert, including each wraparound
+		want := []string{}
+		for j := i; j >= 0 && j > i-3; j-- {
+			want = append(want, fmt.Sprintf("command%d", j))
+		}
+		recent, _ := history.Recent(0)
+		got := make([]string, len(recent))
+		for j, execution := range recent {
+			got[j] = execution.Command
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("after %d adds Recent(0) = %v, want %v", i+1, got, want)
+		}
+	}
+
+	if recent, _ := history.Recent(2); len(recent) != 2 || recent[0].Command != "command6" {
+		t.Errorf("Recent(2) = %+v, want command6 first", recent)
+	}
+	if count, _ := history.Count(); count != 3 {
+		t.Errorf("Count() = %d, want 3", count)
+	}
+}
+
+func TestMemoryHistoryConcurrent(t *testing.T) {
+	history := newMemoryHistory(MAX_HISTORY_SIZE)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			history.Add(CommandExecution{ExitCode: i})
+		}
+	}()
+
+	// Readers get a consistent newest-first snapshot while writes continue
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				recent, _ := history.Recent(0)
+				for j := 1; j < len(recent); j++ {
+					if recent[j].ExitCode != recent[j-1].ExitCode-1 {
+						t.Errorf("snapshot out of order at %d: %d after %d", j, recent[j].ExitCode, recent[j-1].ExitCode)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHistorySnapshot(t *testing.T) {
+	jsonl, err := newJSONLHistory(filepath.Join(t.TempDir(), "history.jsonl"), 1000)
+	if err != nil {
+		t.Fatalf("newJSONLHistory failed: %v", err)
+	}
+	defer jsonl.file.Close()
+	stores := map[string]HistoryStore{"memory": newMemoryHistory(1000), "jsonl": jsonl}
+
+	for name, history := range stores {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				history.Add(CommandExecution{ExitCode: i})
+			}
+		}()
+
+		// The count matches the executions returned while adds continue
+		for i := 0; i < 200; i++ {
+			recent, total, err := historySnapshot(history, 0)
+			if err != nil || len(recent) != total {
+				t.Errorf("%s: snapshot has %d executions but counts %d (%v)", name, len(recent), total, err)
+				break
+			}
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,61 @@
+package shellserver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MAX_IMAGE_SIZE caps images returned with output_image
+const MAX_IMAGE_SIZE = 5 * 1024 * 1024
+
+// imageMIMETypes are the formats returned as image content
+var imageMIMETypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
+// loadOutputImage reads an image written by a command that started at
+// since. Files that were not modified by the command or do not look like an
+// image are refused, so output_image cannot be used to read arbitrary files.
+func loadOutputImage(path string, since time.Time) (mcp.ImageContent, error) {
+	if !filepath.IsAbs(path) {
+		return mcp.ImageContent{}, fmt.Errorf("output_image must be an absolute path, got '%s'", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return mcp.ImageContent{}, fmt.Errorf("output image not found: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return mcp.ImageContent{}, fmt.Errorf("output image '%s' is not a regular file", path)
+	}
+	// Allow for filesystems with coarse modification times
+	if info.ModTime().Before(since.Add(-time.Second)) {
+		return mcp.ImageContent{}, fmt.Errorf("output image '%s' was not written by this command", path)
+	}
+	if info.Size() > MAX_IMAGE_SIZE {
+		return mcp.ImageContent{}, fmt.Errorf("output image is %d bytes, larger than the %d byte limit", info.Size(), MAX_IMAGE_SIZE)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return mcp.ImageContent{}, fmt.Errorf("failed to read output image: %v", err)
+	}
+	mimeType := http.DetectContentType(data)
+	if !imageMIMETypes[mimeType] {
+		return mcp.ImageContent{}, fmt.Errorf("output image '%s' is %s, not a PNG, JPEG, GIF, WebP or BMP image", path, mimeType)
+	}
+
+	image := mcp.NewImageContent(base64.StdEncoding.EncodeToString(data), mimeType)
+	annotate(&image.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant, mcp.RoleUser)
+	return image, nil
+}
@@ -0,0 +1,99 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// writePNG writes a 1x1 PNG to path
+func writePNG(t *testing.T, path string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("failed to write PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadOutputImage(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+
+	plot := filepath.Join(dir, "plot.png")
+	data := writePNG(t, plot)
+
+	stale := filepath.Join(dir, "stale.png")
+	writePNG(t, stale)
+	os.Chtimes(stale, start.Add(-time.Hour), start.Add(-time.Hour))
+
+	notes := filepath.Join(dir, "notes.png")
+	os.WriteFile(notes, []byte("not an image"), 0600)
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{plot, ""},
+		{"plot.png", "absolute path"},
+		{filepath.Join(dir, "missing.png"), "not found"},
+		{dir, "not a regular file"},
+		{stale, "not written by this command"},
+		{notes, "not a PNG"},
+	}
+
+	for _, tt := range tests {
+		content, err := loadOutputImage(tt.path, start)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadOutputImage(%q) error = %v, want %q", tt.path, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadOutputImage(%q) failed: %v", tt.path, err)
+			continue
+		}
+		if content.MIMEType != "image/png" || content.Data != base64.StdEncoding.EncodeToString(data) {
+			t.Errorf("loadOutputImage(%q) = %s image of %d bytes, want the PNG", tt.path, content.MIMEType, len(content.Data))
+		}
+	}
+}
+
+func TestExecuteCommandOutputImage(t *testing.T) {
+	plot := filepath.Join(t.TempDir(), "plot.png")
+	writePNG(t, plot)
+
+	s, err := NewShellServer(WithAllowedCommands("gnuplot"), WithExecutor(fixedExecutor("")))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "gnuplot plot.gp", "output_image": plot}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	if len(result.Content) != 3 {
+		t.Fatalf("result has %d items, want text, image and summary", len(result.Content))
+	}
+	if image, ok := result.Content[1].(mcp.ImageContent); !ok || image.MIMEType != "image/png" {
+		t.Errorf("second item = %#v, want PNG image content", result.Content[1])
+	}
+
+	request.Params.Arguments["output_image"] = filepath.Join(filepath.Dir(plot), "missing.png")
+	result, _ = s.handleExecuteCommand(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; len(result.Content) != 2 || !strings.Contains(text, "Warning: output image not found") {
+		t.Errorf("missing image result = %q, want a warning and no image", text)
+	}
+}
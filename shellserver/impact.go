@@ -0,0 +1,475 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Limits for impact previews
+const (
+	MAX_IMPACT_FILES = 100000                // Files counted before a preview stops walking directories
+	MAX_IMPACT_PATHS = 100                   // Paths listed in a preview; totals cover all of them
+	IMPACT_URI       = "shell://impact.json" // URI of the structured preview
+)
+
+// Effects a destructive command has on a path
+const (
+	IMPACT_DELETED     = "deleted"
+	IMPACT_MOVED       = "moved"
+	IMPACT_OVERWRITTEN = "overwritten"
+	IMPACT_TRUNCATED   = "truncated"
+)
+
+// impactOrder is the order effects are summarized in
+var impactOrder = []string{IMPACT_DELETED, IMPACT_OVERWRITTEN, IMPACT_TRUNCATED, IMPACT_MOVED}
+
+// ImpactEntry is one path a destructive command would change
+type ImpactEntry struct {
+	Path   string `json:"path"`
+	Effect string `json:"effect"`
+	Files  int    `json:"files"`            // More than one for a directory
+	Bytes  int64  `json:"bytes"`            // Data lost; for truncate only what is cut off
+	Device bool   `json:"device,omitempty"` // A device such as a disk, whose size is not known
+}
+
+// ImpactCount totals the files and bytes with one effect
+type ImpactCount struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Impact is what rm, mv, truncate and dd in a command would do to the files
+// on disk, as returned by preview_impact
+type Impact struct {
+	Command    string                 `json:"command"`
+	Dir        string                 `json:"dir"`      // Directory relative paths were resolved in
+	Assessed   bool                   `json:"assessed"` // The command contains rm, mv, truncate or dd
+	Effects    map[string]ImpactCount `json:"effects"`
+	Entries    []ImpactEntry          `json:"entries"`
+	Truncated  bool                   `json:"truncated"`  // Counts stopped at MAX_IMPACT_FILES or entries at MAX_IMPACT_PATHS
+	Incomplete bool                   `json:"incomplete"` // Some arguments depend on expansions and were not assessed
+	Protected  int                    `json:"protected"`  // Paths left out because they are under a protected path
+}
+
+// impactAssessor resolves the operands of destructive commands against the
+// file system without changing anything
+type impactAssessor struct {
+	dir       string
+	protected func(string) bool
+	impact    *Impact
+}
+
+// assessImpact previews the effect of every rm, mv, truncate and dd in a
+// command. Other commands, such as find -delete, are not assessed.
+func assessImpact(command, dir string, protected func(string) bool) (*Impact, error) {
+	commands, err := ParseCommands(command)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &impactAssessor{
+		dir:       dir,
+		protected: protected,
+		impact:    &Impact{Command: command, Dir: dir, Effects: map[string]ImpactCount{}, Entries: []ImpactEntry{}},
+	}
+	for _, parsed := range commands {
+		switch parsed.Name {
+		case "rm":
+			a.rm(parsed.Args)
+		case "mv":
+			a.mv(parsed.Args)
+		case "truncate":
+			a.truncate(parsed.Args)
+		case "dd":
+			a.dd(parsed.Args)
+		default:
+			continue
+		}
+		a.impact.Assessed = true
+	}
+	return a.impact, nil
+}
+
+// rm deletes files, and directories with -r
+func (a *impactAssessor) rm(args []string) {
+	flags, operands := splitOperands(args, nil)
+	recursive := hasShortFlag(flags, 'r') || hasShortFlag(flags, 'R') || containsString(flags, "--recursive")
+	for _, path := range a.resolve(operands) {
+		info, err := os.Lstat(a.path(path))
+		if err != nil || (info.IsDir() && !recursive) {
+			continue
+		}
+		files, bytes := a.measure(path, info)
+		a.add(ImpactEntry{Path: path, Effect: IMPACT_DELETED, Files: files, Bytes: bytes})
+	}
+}
+
+// mv moves its sources, overwriting files of the same name at the destination
+func (a *impactAssessor) mv(args []string) {
+	flags, operands := splitOperands(args, map[string]bool{"-t": true, "--target-directory": true, "-S": true, "--suffix": true})
+	if len(operands) == 0 {
+		return
+	}
+
+	destination, intoDir := "", false
+	for i, flag := range flags {
+		if (flag == "-t" || flag == "--target-directory") && i+1 < len(flags) {
+			destination, intoDir = flags[i+1], true
+		} else if value, found := strings.CutPrefix(flag, "--target-directory="); found {
+			destination, intoDir = value, true
+		}
+	}
+	if destination == "" {
+		if len(operands) < 2 {
+			return
+		}
+		destination, operands = operands[len(operands)-1], operands[:len(operands)-1]
+		if destination == "" {
+			a.impact.Incomplete = true
+			return
+		}
+		if info, err := os.Stat(a.path(destination)); err == nil && info.IsDir() {
+			intoDir = true
+		}
+	}
+	noClobber := hasShortFlag(flags, 'n') || containsString(flags, "--no-clobber")
+
+	for _, path := range a.resolve(operands) {
+		info, err := os.Lstat(a.path(path))
+		if err != nil {
+			continue
+		}
+		files, bytes := a.measure(path, info)
+		a.add(ImpactEntry{Path: path, Effect: IMPACT_MOVED, Files: files, Bytes: bytes})
+
+		target := destination
+		if intoDir {
+			target = filepath.Join(destination, filepath.Base(path))
+		}
+		if existing, err := os.Lstat(a.path(target)); err == nil && !existing.IsDir() && !noClobber && !a.isProtected(target) {
+			a.add(ImpactEntry{Path: target, Effect: IMPACT_OVERWRITTEN, Files: 1, Bytes: existing.Size()})
+		}
+	}
+}
+
+// truncate cuts files down to a size, by default 0
+func (a *impactAssessor) truncate(args []string) {
+	flags, operands := splitOperands(args, map[string]bool{"-s": true, "--size": true, "-r": true, "--reference": true})
+
+	// Sizes relative to the current size or another file are not evaluated;
+	// the whole file is then counted as at risk
+	size := int64(0)
+	for i, flag := range flags {
+		value, found := "", false
+		switch {
+		case (flag == "-s" || flag == "--size") && i+1 < len(flags):
+			value, found = flags[i+1], true
+		case strings.HasPrefix(flag, "--size="):
+			value, found = strings.TrimPrefix(flag, "--size="), true
+		case strings.HasPrefix(flag, "-s") && len(flag) > 2:
+			value, found = flag[2:], true
+		}
+		if found {
+			if parsed, err := parseTruncateSize(value); err == nil {
+				size = parsed
+			}
+		}
+	}
+
+	for _, path := range a.resolve(operands) {
+		info, err := os.Stat(a.path(path))
+		if err != nil || !info.Mode().IsRegular() || info.Size() <= size {
+			continue
+		}
+		a.add(ImpactEntry{Path: path, Effect: IMPACT_TRUNCATED, Files: 1, Bytes: info.Size() - size})
+	}
+}
+
+// dd overwrites its of= file or device
+func (a *impactAssessor) dd(args []string) {
+	for _, arg := range args {
+		if arg == "" {
+			a.impact.Incomplete = true
+			continue
+		}
+		output, found := strings.CutPrefix(arg, "of=")
+		if !found {
+			continue
+		}
+		if a.isProtected(output) {
+			a.impact.Protected++
+			continue
+		}
+		info, err := os.Stat(a.path(output))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		device := info.Mode()&os.ModeDevice != 0
+		a.add(ImpactEntry{Path: output, Effect: IMPACT_OVERWRITTEN, Files: 1, Bytes: info.Size(), Device: device})
+	}
+}
+
+// resolve expands the glob patterns among operands as the shell would,
+// leaving out protected paths
+func (a *impactAssessor) resolve(operands []string) []string {
+	var paths []string
+	for _, operand := range operands {
+		if operand == "" {
+			// An argument built from an expansion such as "$DIR"
+			a.impact.Incomplete = true
+			continue
+		}
+		if _, err := os.Lstat(a.path(operand)); err == nil || !strings.ContainsAny(operand, "*?[{") {
+			if a.isProtected(operand) {
+				a.impact.Protected++
+				continue
+			}
+			paths = append(paths, operand)
+			continue
+		}
+		expansion, err := expandGlob(operand, a.dir, MAX_GLOB_LIMIT, a.protected)
+		if err != nil {
+			continue
+		}
+		a.impact.Protected += expansion.Protected
+		a.impact.Truncated = a.impact.Truncated || expansion.Truncated
+		paths = append(paths, expansion.Matches...)
+	}
+	return paths
+}
+
+// measure counts the files under a path and their size, stopping at
+// MAX_IMPACT_FILES
+func (a *impactAssessor) measure(path string, info os.FileInfo) (int, int64) {
+	if !info.IsDir() {
+		return 1, info.Size()
+	}
+
+	files, bytes := 0, int64(0)
+	filepath.WalkDir(a.path(path), func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if files >= MAX_IMPACT_FILES {
+			a.impact.Truncated = true
+			return filepath.SkipAll
+		}
+		files++
+		if info, err := entry.Info(); err == nil {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return files, bytes
+}
+
+// add records an entry, listing at most MAX_IMPACT_PATHS of them
+func (a *impactAssessor) add(entry ImpactEntry) {
+	count := a.impact.Effects[entry.Effect]
+	count.Files += entry.Files
+	count.Bytes += entry.Bytes
+	a.impact.Effects[entry.Effect] = count
+
+	if len(a.impact.Entries) >= MAX_IMPACT_PATHS {
+		a.impact.Truncated = true
+		return
+	}
+	a.impact.Entries = append(a.impact.Entries, entry)
+}
+
+// path returns where an operand is on disk
+func (a *impactAssessor) path(operand string) string {
+	if home, err := os.UserHomeDir(); err == nil && (operand == "~" || strings.HasPrefix(operand, "~/")) {
+		operand = home + operand[1:]
+	}
+	if filepath.IsAbs(operand) {
+		return operand
+	}
+	return filepath.Join(a.dir, operand)
+}
+
+// isProtected reports whether an operand is under a protected path
+func (a *impactAssessor) isProtected(operand string) bool {
+	return a.protected != nil && (a.protected(operand) || a.protected(a.path(operand)))
+}
+
+// splitOperands separates flags from operands. Flags named in valueFlags
+// take the next argument as their value, which stays with the flags.
+func splitOperands(args []string, valueFlags map[string]bool) ([]string, []string) {
+	var flags, operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return flags, append(operands, args[i+1:]...)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			flags = append(flags, arg)
+			if valueFlags[arg] && i+1 < len(args) {
+				i++
+				flags = append(flags, args[i])
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return flags, operands
+}
+
+// hasShortFlag reports whether a single-letter flag is given alone or
+// combined with others, e.g. -r in -rf
+func hasShortFlag(flags []string, letter byte) bool {
+	for _, flag := range flags {
+		if len(flag) > 1 && flag[0] == '-' && flag[1] != '-' && strings.IndexByte(flag[1:], letter) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateSizeUnits are the size suffixes truncate accepts
+var truncateSizeUnits = map[string]int64{
+	"": 1, "K": 1 << 10, "KiB": 1 << 10, "KB": 1000,
+	"M": 1 << 20, "MiB": 1 << 20, "MB": 1000 * 1000,
+	"G": 1 << 30, "GiB": 1 << 30, "GB": 1000 * 1000 * 1000,
+	"T": 1 << 40, "TiB": 1 << 40, "TB": 1000 * 1000 * 1000 * 1000,
+}
+
+// parseTruncateSize parses an absolute truncate size such as "0" or "10M"
+func parseTruncateSize(value string) (int64, error) {
+	digits := strings.TrimRight(value, "KMGTiB")
+	unit, ok := truncateSizeUnits[value[len(digits):]]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit in '%s'", value)
+	}
+	number, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || strings.Trim(digits, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid size '%s'", value)
+	}
+	return number * unit, nil
+}
+
+// String summarizes an impact in one line, e.g. "deletes 12 files (3.4 MiB)"
+func (impact *Impact) String() string {
+	verbs := map[string]string{
+		IMPACT_DELETED:     "deletes",
+		IMPACT_OVERWRITTEN: "overwrites",
+		IMPACT_TRUNCATED:   "truncates",
+		IMPACT_MOVED:       "moves",
+	}
+
+	var parts []string
+	for _, effect := range impactOrder {
+		count, found := impact.Effects[effect]
+		if !found {
+			continue
+		}
+		noun := "files"
+		if count.Files == 1 {
+			noun = "file"
+		}
+		parts = append(parts, fmt.Sprintf("%s %d %s (%s)", verbs[effect], count.Files, noun, formatByteSize(count.Bytes)))
+	}
+	summary := strings.Join(parts, ", ")
+	switch {
+	case !impact.Assessed:
+		return "no rm, mv, truncate or dd to assess"
+	case summary == "":
+		summary = "affects no existing files"
+	}
+	if impact.Truncated {
+		summary += " or more"
+	}
+	if impact.Incomplete {
+		summary += "; arguments built from expansions were not assessed"
+	}
+	return summary
+}
+
+// approvalImpact summarizes what a command waiting for approval would do to
+// local files, or returns "" if that cannot be told: sessions keep their own
+// working directory, and targets and sandboxes run elsewhere
+func (s *ShellServer) approvalImpact(req *ExecRequest) string {
+	if req.Session != "" || req.Target != "" || req.Container != "" {
+		return ""
+	}
+	dir := req.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	impact, err := assessImpact(req.Command, dir, s.protectedPaths(req.Project, clientName(req.Client)))
+	if err != nil || !impact.Assessed {
+		return ""
+	}
+	return impact.String()
+}
+
+func (s *ShellServer) handlePreviewImpact(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	command, ok := request.Params.Arguments["command"].(string)
+	if !ok {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_INVALID_COMMAND),
+			Details: map[string]interface{}{"argument": "command"},
+		}), nil
+	}
+
+	projectName, _ := request.Params.Arguments["project"].(string)
+	dir, toolError := s.workDir(projectName)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	impact, err := assessImpact(command, dir, s.protectedPaths(projectName, clientName(s.clientIdentity(ctx))))
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_UNPARSEABLE, err),
+			Details: map[string]interface{}{"argument": "command", "parseError": err.Error()},
+		}), nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "$ %s\nIn %s the command %s.", command, dir, impact)
+	if len(impact.Entries) > 0 {
+		text.WriteString("\n")
+		for _, entry := range impact.Entries {
+			size := formatByteSize(entry.Bytes)
+			if entry.Device {
+				size = "device"
+			} else if entry.Files > 1 {
+				size = fmt.Sprintf("%d files, %s", entry.Files, size)
+			}
+			fmt.Fprintf(&text, "\n%-11s %s (%s)", entry.Effect, entry.Path, size)
+		}
+	}
+	if impact.Protected > 0 {
+		fmt.Fprintf(&text, "\n\n%d paths under protected paths were left out.", impact.Protected)
+	}
+
+	// An impact of plain values always marshals
+	data, _ := json.Marshal(impact)
+	resource := mcp.EmbeddedResource{
+		Type: "resource",
+		Resource: mcp.TextResourceContents{
+			URI:      IMPACT_URI,
+			MIMEType: JSON_MIME_TYPE,
+			Text:     string(data),
+		},
+	}
+	annotate(&resource.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{assistantText(text.String()), resource},
+	}, nil
+}
@@ -0,0 +1,100 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssessImpact(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{
+		"a.log": 100, "b.log": 50, "keep.txt": 10, "dest/a.log": 7,
+		"build/x.o": 1000, "build/sub/y.o": 24, "secrets/key": 5,
+	} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644)
+	}
+	protected := func(p string) bool { return refersToPath(p, []string{"secrets"}) }
+
+	tests := []struct {
+		command    string
+		effects    string // effect:files:bytes, in impactOrder
+		entries    string
+		incomplete bool
+		protected  int
+	}{
+		{"rm a.log b.log missing", "deleted:2:150", "a.log,b.log", false, 0},
+		{"rm *.log", "deleted:2:150", "a.log,b.log", false, 0},
+		{"rm build", "", "", false, 0},
+		{"rm -rf build keep.txt", "deleted:3:1034", "build,keep.txt", false, 0},
+		{"rm -r -- secrets", "", "", false, 1},
+		{"rm \"$TARGET\" a.log", "deleted:1:100", "a.log", true, 0},
+		{"mv a.log b.log dest", "overwritten:1:7 moved:2:150", "a.log,dest/a.log,b.log", false, 0},
+		{"mv -n a.log dest/", "moved:1:100", "a.log", false, 0},
+		{"mv keep.txt a.log", "overwritten:1:100 moved:1:10", "keep.txt,a.log", false, 0},
+		{"truncate -s 0 a.log", "truncated:1:100", "a.log", false, 0},
+		{"truncate -s40 a.log b.log keep.txt", "truncated:2:70", "a.log,b.log", false, 0},
+		{"truncate --size=1K a.log", "", "", false, 0},
+		{"dd if=/dev/zero of=keep.txt bs=1 count=1", "overwritten:1:10", "keep.txt", false, 0},
+		{"ls && rm keep.txt", "deleted:1:10", "keep.txt", false, 0},
+	}
+
+	for _, test := range tests {
+		impact, err := assessImpact(test.command, dir, protected)
+		if err != nil {
+			t.Errorf("assessImpact(%q) failed: %v", test.command, err)
+			continue
+		}
+		var effects, entries []string
+		for _, effect := range impactOrder {
+			if count, found := impact.Effects[effect]; found {
+				effects = append(effects, fmt.Sprintf("%s:%d:%d", effect, count.Files, count.Bytes))
+			}
+		}
+		for _, entry := range impact.Entries {
+			entries = append(entries, entry.Path)
+		}
+		if strings.Join(effects, " ") != test.effects || strings.Join(entries, ",") != test.entries ||
+			impact.Incomplete != test.incomplete || impact.Protected != test.protected || !impact.Assessed {
+			t.Errorf("assessImpact(%q) = %v %v (incomplete %v, protected %d), want %s %s (incomplete %v, protected %d)",
+				test.command, effects, entries, impact.Incomplete, impact.Protected, test.effects, test.entries, test.incomplete, test.protected)
+		}
+	}
+
+	impact, _ := assessImpact("ls -la", dir, nil)
+	if impact.Assessed || impact.String() != "no rm, mv, truncate or dd to assess" {
+		t.Errorf("assessImpact(ls) = %+v (%s), want nothing assessed", impact, impact)
+	}
+	impact, _ = assessImpact("rm -rf build a.log", dir, nil)
+	if summary := impact.String(); summary != "deletes 3 files (1.1 KiB)" {
+		t.Errorf("impact summary = %q", summary)
+	}
+}
+
+func TestParseTruncateSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		valid    bool
+	}{
+		{"0", 0, true},
+		{"10", 10, true},
+		{"4K", 4096, true},
+		{"2KB", 2000, true},
+		{"1MiB", 1 << 20, true},
+		{"+5", 0, false},
+		{"<10M", 0, false},
+		{"10X", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		result, err := parseTruncateSize(test.value)
+		if result != test.expected || (err == nil) != test.valid {
+			t.Errorf("parseTruncateSize(%q) = %d, %v, want %d (valid %v)", test.value, result, err, test.expected, test.valid)
+		}
+	}
+}
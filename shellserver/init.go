@@ -0,0 +1,24 @@
+package shellserver
+
+import "os"
+
+// WithInit makes Serve take on the duties of an init process: signals sent
+// to the server are forwarded to the commands, sessions and REPLs it runs,
+// and exited orphans are collected as soon as they exit instead of by the
+// reaper's next run. Serve does this by itself when the server is PID 1, as
+// a container entrypoint. With WithInit the server also adopts the orphans
+// of its commands when it is not PID 1. Only supported on Linux.
+func WithInit() Option {
+	return func(s *ShellServer) error {
+		if err := becomeSubreaper(); err != nil {
+			return err
+		}
+		s.asInit = true
+		return nil
+	}
+}
+
+// isInit reports whether the server does an init's duties
+func (s *ShellServer) isInit() bool {
+	return s.asInit || os.Getpid() == 1
+}
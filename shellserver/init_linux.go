@@ -0,0 +1,36 @@
+//go:build linux
+
+package shellserver
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// PR_SET_CHILD_SUBREAPER makes the orphans of a process's descendants its
+// children rather than PID 1's
+const PR_SET_CHILD_SUBREAPER = 36
+
+// becomeSubreaper makes the server adopt the orphans of its commands
+func becomeSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_CHILD_SUBREAPER, 1, 0); errno != 0 {
+		return fmt.Errorf("cannot adopt orphan processes: %v", errno)
+	}
+	return nil
+}
+
+// forwardSignal sends sig to the server's children, to the whole process
+// group of those that lead one, and returns how many it reached
+func forwardSignal(sig syscall.Signal) int {
+	forwarded := 0
+	for _, child := range childProcesses() {
+		target := child.pid
+		if child.pgid == child.pid {
+			target = -child.pid
+		}
+		if syscall.Kill(target, sig) == nil {
+			forwarded++
+		}
+	}
+	return forwarded
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package shellserver
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestForwardSignal(t *testing.T) {
+	// A command tree started the way the server starts commands
+	cmd := processControl{}.command(context.Background(), "bash", "-c", "sleep 30 | cat")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("bash failed: %v", err)
+	}
+	// Wait for the pipeline to start
+	time.Sleep(100 * time.Millisecond)
+
+	if forwarded := forwardSignal(syscall.SIGTERM); forwarded < 1 {
+		t.Errorf("forwardSignal reached %d children, want the command", forwarded)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		if status, ok := err.(*exec.ExitError); !ok || status.Sys().(syscall.WaitStatus).Signal() != syscall.SIGTERM {
+			t.Errorf("command exited with %v, want SIGTERM", err)
+		}
+	case <-time.After(5 * time.Second):
+		killProcessTree(cmd)
+		t.Errorf("the command did not exit after SIGTERM was forwarded")
+	}
+}
+
+func TestWithInit(t *testing.T) {
+	s, err := NewShellServer(WithInit(), WithReaper(0))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_CHILD_SUBREAPER, 0, 0)
+	defer s.Close()
+	if !s.isInit() {
+		t.Errorf("WithInit did not make the server an init")
+	}
+
+	// Orphans are collected as they exit, without waiting for the reaper
+	stop := s.serveInit()
+	defer stop()
+	processControl{}.command(context.Background(), "bash", "-c", "sleep 0.1 &").Run()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.reaperStats().Zombies == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if zombies := s.reaperStats().Zombies; zombies != 1 {
+		t.Errorf("init collected %d orphans, want 1", zombies)
+	}
+}
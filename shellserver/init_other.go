@@ -0,0 +1,18 @@
+//go:build !unix
+
+package shellserver
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// becomeSubreaper is refused: only Linux lets a process adopt orphans
+func becomeSubreaper() error {
+	return fmt.Errorf("running as init is only supported on Linux, not %s", runtime.GOOS)
+}
+
+// serveInit does nothing; there are no Unix signals to forward
+func (s *ShellServer) serveInit() func() {
+	return func() {}
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package shellserver
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// initSignals are forwarded to the server's children in init mode
+var initSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// serveInit forwards signals to the children and collects exited orphans
+// until the returned func is called
+func (s *ShellServer) serveInit() func() {
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGCHLD}, initSignals...)...)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGCHLD {
+					if reaped := reapOrphans(); reaped > 0 {
+						s.addReaped(ReaperDiagnostics{Zombies: reaped})
+					}
+					continue
+				}
+				if forwarded := forwardSignal(sig.(syscall.Signal)); forwarded > 0 {
+					s.logger.Printf("Forwarded %s to %d child processes", sig, forwarded)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(stop)
+		<-done
+	}
+}
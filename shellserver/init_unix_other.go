@@ -0,0 +1,19 @@
+//go:build unix && !linux
+
+package shellserver
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// becomeSubreaper is refused: only Linux lets a process adopt orphans
+func becomeSubreaper() error {
+	return fmt.Errorf("running as init is only supported on Linux, not %s", runtime.GOOS)
+}
+
+// forwardSignal reaches no one: without /proc the children are unknown
+func forwardSignal(sig syscall.Signal) int {
+	return 0
+}
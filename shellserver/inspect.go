@@ -0,0 +1,537 @@
+package shellserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// grep_file, head_file, tail_file, count_lines and file_stat read files in
+// process, so inspecting a file does not need grep, head, tail, wc or stat
+// in the allowlist. They refuse protected paths, resolving symlinks first,
+// and only read regular files.
+
+// Limits of the file inspection tools
+const (
+	DEFAULT_INSPECT_LINES = 10                         // Lines head_file and tail_file return when no count is given
+	MAX_INSPECT_LINES     = 10000                      // Most lines head_file and tail_file return
+	DEFAULT_GREP_MATCHES  = 100                        // Matching lines grep_file returns when no limit is given
+	MAX_GREP_MATCHES      = 5000                       // Most matching lines grep_file returns
+	MAX_LINE_LENGTH       = 64 * 1024                  // Longer lines are cut short
+	FILE_STAT_URI         = "shell://file_stat.json"   // URI of the structured file_stat result
+	LINE_COUNT_URI        = "shell://count_lines.json" // URI of the structured count_lines result
+)
+
+// FileStat describes a file, as returned by file_stat
+type FileStat struct {
+	Path       string    `json:"path"`
+	Type       string    `json:"type"` // "file", "directory", "symlink" or "other"
+	Size       int64     `json:"size"`
+	Mode       string    `json:"mode"` // e.g. "-rw-r--r--"
+	ModTime    time.Time `json:"modTime"`
+	LinkTarget string    `json:"linkTarget,omitempty"` // Where a symlink points
+}
+
+// LineCount is what count_lines counts, as wc does
+type LineCount struct {
+	Path  string `json:"path"`
+	Lines int64  `json:"lines"` // Newlines, so a last line without one is not counted
+	Words int64  `json:"words"` // Runs of characters between whitespace
+	Bytes int64  `json:"bytes"`
+}
+
+// inspectPath resolves a path argument of a file tool against the project's
+// directory and refuses paths that trip a tripwire, paths outside the
+// server's and the projects' directories and protected paths. The returned path is the one to open;
+// symlinks are still followed when opening it.
+func (s *ShellServer) inspectPath(ctx context.Context, request mcp.CallToolRequest, argument string) (string, *ToolError) {
+	name, ok := request.Params.Arguments[argument].(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		return "", &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: '%s' must be a non-empty string", argument),
+			Details: map[string]interface{}{"argument": argument},
+		}
+	}
+
+	projectName, _ := request.Params.Arguments["project"].(string)
+	dir, toolError := s.workDir(projectName)
+	if toolError != nil {
+		return "", toolError
+	}
+	resolved := name
+	if home, err := os.UserHomeDir(); err == nil && (resolved == "~" || strings.HasPrefix(resolved, "~/")) {
+		resolved = home + resolved[1:]
+	}
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(dir, resolved)
+	}
+	resolved = filepath.Clean(resolved)
+
+	// A symlink into a protected path is as protected as its target
+	checked := []string{name, resolved}
+	if target, err := filepath.EvalSymlinks(resolved); err == nil && target != resolved {
+		checked = append(checked, target)
+	}
+	if toolError := s.fileTripwireDenial(ctx, request.Params.Name, name, checked...); toolError != nil {
+		return "", toolError
+	}
+	for _, p := range checked[1:] {
+		if !s.insideWorkRoots(p) {
+			return "", &ToolError{
+				Code:    ERROR_POLICY_DENIED,
+				Message: s.message(MSG_READ_OUTSIDE, name),
+				Details: map[string]interface{}{"rule": "read_outside", "path": name, "roots": s.workRoots()},
+			}
+		}
+	}
+	allowlist, ok := s.policyFor(projectName, clientName(s.clientIdentity(ctx))).(*AllowlistPolicy)
+	if !ok {
+		return resolved, nil
+	}
+	for _, p := range checked {
+		if rule := allowlist.protects(p); rule != "" {
+			return "", &ToolError{
+				Code:    ERROR_POLICY_DENIED,
+				Message: s.message(MSG_FILE_DENIED_PATH, name, rule),
+				Details: map[string]interface{}{"rule": DENY_PATH, "match": rule, "path": name},
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// insideWorkRoots reports whether path is inside the server's or a project's
+// directory, comparing symlink-free forms too so a root reached through a
+// symlink, such as /tmp on macOS, still contains its files
+func (s *ShellServer) insideWorkRoots(path string) bool {
+	for _, root := range s.workRoots() {
+		if isUnder(path, root) {
+			return true
+		}
+		if real, err := filepath.EvalSymlinks(root); err == nil && isUnder(path, real) {
+			return true
+		}
+	}
+	return false
+}
+
+// writablePath resolves a path argument a tool writes to, as inspectPath
+// does, and checks that it is inside the server's or a project's directory
+// and that the policy allows writing
+func (s *ShellServer) writablePath(ctx context.Context, request mcp.CallToolRequest, argument string) (string, *ToolError) {
+	destination, toolError := s.inspectPath(ctx, request, argument)
+	if toolError != nil {
+		return "", toolError
+	}
+	projectName, _ := request.Params.Arguments["project"].(string)
+	if allowlist, ok := s.policyFor(projectName, clientName(s.clientIdentity(ctx))).(*AllowlistPolicy); ok && allowlist.ReadOnly() {
+		return "", &ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_TRANSFER_READ_ONLY, destination),
+			Details: map[string]interface{}{"rule": DENY_REDIRECT, "path": destination},
+		}
+	}
+
+	roots := s.workRoots()
+	for _, root := range roots {
+		if isUnder(destination, root) {
+			return destination, nil
+		}
+	}
+	return "", &ToolError{
+		Code:    ERROR_POLICY_DENIED,
+		Message: s.message(MSG_WRITE_OUTSIDE, destination),
+		Details: map[string]interface{}{"rule": "write_outside", "path": destination, "roots": roots},
+	}
+}
+
+// openRegular opens a regular file for reading; devices, pipes and
+// directories are refused so reading them cannot block or never end
+func openRegular(name string) (*os.File, os.FileInfo, *ToolError) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, fileError(name, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fileError(name, err)
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		kind := "not a regular file"
+		if info.IsDir() {
+			kind = "a directory"
+		}
+		return nil, nil, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: '%s' is %s.", name, kind),
+			Details: map[string]interface{}{"argument": "path", "path": name},
+		}
+	}
+	return file, info, nil
+}
+
+// fileError describes a file that could not be read
+func fileError(name string, err error) *ToolError {
+	if errors.Is(err, os.ErrNotExist) {
+		return &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: '%s' does not exist.", name),
+			Details: map[string]interface{}{"argument": "path", "path": name},
+		}
+	}
+	return &ToolError{
+		Code:    ERROR_EXECUTION_FAILED,
+		Message: "Error: " + err.Error(),
+		Details: map[string]interface{}{"path": name},
+	}
+}
+
+// countArgument returns a positive count argument, defaulting to def and
+// capped at limit
+func countArgument(request mcp.CallToolRequest, argument string, def int, limit int) (int, *ToolError) {
+	value, found := request.Params.Arguments[argument]
+	if !found {
+		return def, nil
+	}
+	number, ok := value.(float64)
+	if !ok || number < 1 || number != float64(int(number)) {
+		return 0, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: '%s' must be a positive whole number", argument),
+			Details: map[string]interface{}{"argument": argument},
+		}
+	}
+	return min(int(number), limit), nil
+}
+
+// readLines calls fn with each line of r and its number, without the line
+// ending, until fn returns false. Lines over MAX_LINE_LENGTH are cut short.
+func readLines(r io.Reader, fn func(number int, line string) bool) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var line []byte
+	for number := 1; ; {
+		fragment, isPrefix, err := reader.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(line) < MAX_LINE_LENGTH {
+			line = append(line, fragment[:min(len(fragment), MAX_LINE_LENGTH-len(line))]...)
+		}
+		if isPrefix {
+			continue
+		}
+		if !fn(number, printable(line)) {
+			return nil
+		}
+		line = line[:0]
+		number++
+	}
+}
+
+// printable replaces invalid UTF-8, e.g. in binary files
+func printable(line []byte) string {
+	return strings.ToValidUTF8(string(line), "�")
+}
+
+// tailLines returns the last n lines of a file of the given size, reading
+// it backwards so large files are not read whole. Reading stops after
+// MAX_OUTPUT_SIZE bytes, returning the lines found so far.
+func tailLines(file io.ReaderAt, size int64, n int) ([]string, error) {
+	const chunkSize = 64 * 1024
+	var tail []byte
+	end := size
+	for end > 0 && int64(len(tail)) < MAX_OUTPUT_SIZE {
+		start := max(end-chunkSize, 0)
+		chunk := make([]byte, end-start)
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+		end = start
+		// One newline more than lines wanted, not counting a final one
+		if bytes.Count(bytes.TrimSuffix(tail, []byte("\n")), []byte("\n")) >= n {
+			break
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(tail), "\n"), "\n")
+	if end > 0 && len(lines) > 1 {
+		// The first line was only read in part
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) > MAX_LINE_LENGTH {
+			line = line[:MAX_LINE_LENGTH]
+		}
+		lines[i] = printable([]byte(line))
+	}
+	return lines, nil
+}
+
+// countLines counts lines, words and bytes as wc does in the C locale
+func countLines(r io.Reader) (LineCount, error) {
+	var count LineCount
+	buffer := make([]byte, 64*1024)
+	inWord := false
+	for {
+		n, err := r.Read(buffer)
+		for _, b := range buffer[:n] {
+			switch b {
+			case '\n':
+				count.Lines++
+				inWord = false
+			case ' ', '\t', '\r', '\v', '\f':
+				inWord = false
+			default:
+				if !inWord {
+					count.Words++
+					inWord = true
+				}
+			}
+		}
+		count.Bytes += int64(n)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+// fileType names the type of a file mode as file_stat reports it
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	}
+	return "other"
+}
+
+// statFile describes the file at name without following a final symlink
+func statFile(name string) (*FileStat, error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	stat := &FileStat{
+		Path:    name,
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		ModTime: info.ModTime(),
+		Type:    fileType(info.Mode()),
+	}
+	if stat.Type == "symlink" {
+		stat.LinkTarget, _ = os.Readlink(name)
+	}
+	return stat, nil
+}
+
+// jsonResult returns text for the assistant followed by value as JSON at uri
+func jsonResult(text string, uri string, value interface{}) *mcp.CallToolResult {
+	// Results of plain values always marshal
+	data, _ := json.Marshal(value)
+	resource := mcp.EmbeddedResource{
+		Type: "resource",
+		Resource: mcp.TextResourceContents{
+			URI:      uri,
+			MIMEType: JSON_MIME_TYPE,
+			Text:     string(data),
+		},
+	}
+	annotate(&resource.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{assistantText(text), resource},
+	}
+}
+
+func (s *ShellServer) handleGrepFile(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, toolError := s.inspectPath(ctx, request, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	pattern, ok := request.Params.Arguments["pattern"].(string)
+	if !ok || pattern == "" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'pattern' must be a non-empty string",
+			Details: map[string]interface{}{"argument": "pattern"},
+		}), nil
+	}
+	if ignoreCase, _ := request.Params.Arguments["ignore_case"].(bool); ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: invalid 'pattern': %v", err),
+			Details: map[string]interface{}{"argument": "pattern"},
+		}), nil
+	}
+	limit, toolError := countArgument(request, "max_matches", DEFAULT_GREP_MATCHES, MAX_GREP_MATCHES)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	file, _, toolError := openRegular(name)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer file.Close()
+
+	var matches []string
+	truncated := false
+	err = readLines(file, func(number int, line string) bool {
+		if !compiled.MatchString(line) {
+			return true
+		}
+		if len(matches) == limit {
+			truncated = true
+			return false
+		}
+		matches = append(matches, fmt.Sprintf("%d:%s", number, line))
+		return true
+	})
+	if err != nil {
+		return errorResult(*fileError(name, err)), nil
+	}
+
+	var text string
+	switch {
+	case len(matches) == 0:
+		text = fmt.Sprintf("No lines of %s match '%s'.", name, pattern)
+	case truncated:
+		text = fmt.Sprintf("More than %d lines of %s match; the first %d are:\n%s", limit, name, limit, strings.Join(matches, "\n"))
+	default:
+		text = fmt.Sprintf("%d lines of %s match:\n%s", len(matches), name, strings.Join(matches, "\n"))
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{assistantText(text)}}, nil
+}
+
+func (s *ShellServer) handleHeadFile(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, toolError := s.inspectPath(ctx, request, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	n, toolError := countArgument(request, "lines", DEFAULT_INSPECT_LINES, MAX_INSPECT_LINES)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	file, _, toolError := openRegular(name)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer file.Close()
+
+	var lines []string
+	err := readLines(file, func(number int, line string) bool {
+		lines = append(lines, line)
+		return number < n
+	})
+	if err != nil {
+		return errorResult(*fileError(name, err)), nil
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{assistantText(strings.Join(lines, "\n"))}}, nil
+}
+
+func (s *ShellServer) handleTailFile(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, toolError := s.inspectPath(ctx, request, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	n, toolError := countArgument(request, "lines", DEFAULT_INSPECT_LINES, MAX_INSPECT_LINES)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	file, info, toolError := openRegular(name)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer file.Close()
+
+	lines, err := tailLines(file, info.Size(), n)
+	if err != nil {
+		return errorResult(*fileError(name, err)), nil
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{assistantText(strings.Join(lines, "\n"))}}, nil
+}
+
+func (s *ShellServer) handleCountLines(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, toolError := s.inspectPath(ctx, request, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	file, _, toolError := openRegular(name)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer file.Close()
+
+	count, err := countLines(file)
+	if err != nil {
+		return errorResult(*fileError(name, err)), nil
+	}
+	count.Path = name
+	text := fmt.Sprintf("%s: %d lines, %d words, %d bytes", name, count.Lines, count.Words, count.Bytes)
+	return jsonResult(text, LINE_COUNT_URI, count), nil
+}
+
+func (s *ShellServer) handleFileStat(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, toolError := s.inspectPath(ctx, request, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	stat, err := statFile(name)
+	if err != nil {
+		return errorResult(*fileError(name, err)), nil
+	}
+
+	text := fmt.Sprintf("%s: %s, %s, %s, modified %s", name, stat.Type, formatByteSize(stat.Size), stat.Mode, stat.ModTime.Format(time.RFC3339))
+	if stat.LinkTarget != "" {
+		text += ", -> " + stat.LinkTarget
+	}
+	return jsonResult(text, FILE_STAT_URI, stat), nil
+}
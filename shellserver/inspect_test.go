@@ -0,0 +1,144 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestTailLines(t *testing.T) {
+	var long strings.Builder
+	for i := 1; i <= 20000; i++ {
+		fmt.Fprintf(&long, "line %d\n", i)
+	}
+
+	tests := []struct {
+		content string
+		n       int
+		want    []string
+	}{
+		{"a\nb\nc\n", 2, []string{"b", "c"}},
+		{"a\nb\nc", 2, []string{"b", "c"}},
+		{"a\nb\n", 5, []string{"a", "b"}},
+		{"a\r\nb\r\n", 1, []string{"b"}},
+		{"only", 3, []string{"only"}},
+		{"", 3, []string{""}},
+		{long.String(), 3, []string{"line 19998", "line 19999", "line 20000"}},
+	}
+
+	for _, tt := range tests {
+		got, err := tailLines(strings.NewReader(tt.content), int64(len(tt.content)), tt.n)
+		if err != nil || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("tailLines(%.20q, %d) = %q, %v, want %q", tt.content, tt.n, got, err, tt.want)
+		}
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	tests := []struct {
+		content string
+		want    LineCount
+	}{
+		{"", LineCount{}},
+		{"hello world\n", LineCount{Lines: 1, Words: 2, Bytes: 12}},
+		{"no newline", LineCount{Lines: 0, Words: 2, Bytes: 10}},
+		{"  a\tb \n\nc\n", LineCount{Lines: 3, Words: 3, Bytes: 10}},
+	}
+
+	for _, tt := range tests {
+		if got, err := countLines(strings.NewReader(tt.content)); err != nil || got != tt.want {
+			t.Errorf("countLines(%q) = %+v, %v, want %+v", tt.content, got, err, tt.want)
+		}
+	}
+}
+
+func TestReadLinesCutsLongLines(t *testing.T) {
+	content := strings.Repeat("x", MAX_LINE_LENGTH+100) + "\nnext\n"
+	var lines []string
+	readLines(strings.NewReader(content), func(number int, line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+	if len(lines) != 2 || len(lines[0]) != MAX_LINE_LENGTH || lines[1] != "next" {
+		t.Errorf("got %d lines, first %d bytes, want the first cut to %d bytes", len(lines), len(lines[0]), MAX_LINE_LENGTH)
+	}
+}
+
+func TestFileInspectionTools(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.log"), []byte("start\nERROR disk full\nok\nerror retry\nend\n"), 0o644)
+	os.Mkdir(filepath.Join(dir, "secrets"), 0o755)
+	os.WriteFile(filepath.Join(dir, "secrets", "key"), []byte("hunter2\n"), 0o600)
+	os.Symlink(filepath.Join(dir, "secrets", "key"), filepath.Join(dir, "innocent"))
+	outside := filepath.Join(t.TempDir(), "id_ed25519")
+	os.WriteFile(outside, []byte("private\n"), 0o600)
+	os.Symlink(outside, filepath.Join(dir, "escape"))
+
+	s, err := NewShellServer(
+		WithAllowedCommands("ls"),
+		WithPolicyRules(&PolicyRules{DenyPaths: []string{"secrets"}}),
+		WithProjects([]Project{{Name: "app", Dir: dir}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		tool     string
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{"grep_file", map[string]interface{}{"path": "app.log", "pattern": "error"}, "1 lines of " + filepath.Join(dir, "app.log") + " match:\n4:error retry", ""},
+		{"grep_file", map[string]interface{}{"path": "app.log", "pattern": "error", "ignore_case": true}, "2:ERROR disk full\n4:error retry", ""},
+		{"grep_file", map[string]interface{}{"path": "app.log", "pattern": "e", "max_matches": float64(1)}, "More than 1 lines", ""},
+		{"grep_file", map[string]interface{}{"path": "app.log", "pattern": "("}, "invalid 'pattern'", ERROR_INVALID_ARGUMENT},
+		{"head_file", map[string]interface{}{"path": "app.log", "lines": float64(2)}, "start\nERROR disk full", ""},
+		{"head_file", map[string]interface{}{"path": "app.log", "lines": float64(0)}, "positive whole number", ERROR_INVALID_ARGUMENT},
+		{"tail_file", map[string]interface{}{"path": "app.log", "lines": float64(2)}, "error retry\nend", ""},
+		{"count_lines", map[string]interface{}{"path": "app.log"}, "5 lines, 8 words, 41 bytes", ""},
+		{"file_stat", map[string]interface{}{"path": "app.log"}, "file, 41 B, -rw-", ""},
+		{"file_stat", map[string]interface{}{"path": "."}, "directory", ""},
+		{"head_file", map[string]interface{}{"path": "."}, "is a directory", ERROR_INVALID_ARGUMENT},
+		{"head_file", map[string]interface{}{"path": "missing"}, "does not exist", ERROR_INVALID_ARGUMENT},
+		{"head_file", map[string]interface{}{"path": "secrets/key"}, "under the protected path 'secrets'", ERROR_POLICY_DENIED},
+		{"head_file", map[string]interface{}{"path": "innocent"}, "under the protected path 'secrets'", ERROR_POLICY_DENIED},
+		{"head_file", map[string]interface{}{"path": outside}, "outside the server's and the projects' directories", ERROR_POLICY_DENIED},
+		{"head_file", map[string]interface{}{"path": "escape"}, "outside the server's and the projects' directories", ERROR_POLICY_DENIED},
+		{"grep_file", map[string]interface{}{"path": "/etc/passwd", "pattern": "root"}, "outside the server's and the projects' directories", ERROR_POLICY_DENIED},
+		{"file_stat", map[string]interface{}{"path": "../" + filepath.Base(dir)}, "", ""},
+		{"file_stat", map[string]interface{}{"path": "innocent"}, "under the protected path 'secrets'", ERROR_POLICY_DENIED},
+		{"head_file", map[string]interface{}{}, "'path' must be a non-empty string", ERROR_INVALID_ARGUMENT},
+		{"head_file", map[string]interface{}{"path": "app.log", "project": "other"}, "No project named 'other'", ERROR_INVALID_ARGUMENT},
+	}
+
+	handlers := map[string]func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error){
+		"grep_file":   s.handleGrepFile,
+		"head_file":   s.handleHeadFile,
+		"tail_file":   s.handleTailFile,
+		"count_lines": s.handleCountLines,
+		"file_stat":   s.handleFileStat,
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		if _, found := tt.args["project"]; !found {
+			request.Params.Arguments["project"] = "app"
+		}
+		result, _ := handlers[tt.tool](context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("%s(%v) = %q (code %q), want %q (code %q)", tt.tool, tt.args, text, code, tt.want, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSON output formats accepted by the json_format parameter
+const (
+	JSON_FORMAT_PRETTY  = "pretty"
+	JSON_FORMAT_COMPACT = "compact"
+	JSON_MIME_TYPE      = "application/json"
+	JSON_OUTPUT_URI     = "shell://output.json"
+)
+
+// processJSONOutput re-serializes output that is valid JSON, optionally
+// extracting a sub-value with a json_path expression first.
+// It returns false if the output is not JSON and no path was requested.
+func processJSONOutput(output string, format string, path string) (string, bool, error) {
+	trimmed := strings.TrimSpace(output)
+	if trimmed == "" || !json.Valid([]byte(trimmed)) {
+		if path != "" {
+			return output, false, fmt.Errorf("output is not valid JSON, cannot apply json_path %q", path)
+		}
+		return output, false, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return output, false, err
+	}
+
+	if path != "" {
+		extracted, err := extractJSONPath(value, path)
+		if err != nil {
+			return output, false, err
+		}
+		value = extracted
+		if format == "" {
+			format = JSON_FORMAT_PRETTY
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	switch format {
+	case JSON_FORMAT_PRETTY:
+		encoder.SetIndent("", "  ")
+	case JSON_FORMAT_COMPACT, "":
+	default:
+		return output, false, fmt.Errorf("unsupported json_format %q, expected 'pretty' or 'compact'", format)
+	}
+	if err := encoder.Encode(value); err != nil {
+		return output, false, err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), true, nil
+}
+
+// extractJSONPath walks a decoded JSON value using a simple path expression
+// such as "$.items[0].metadata.name" or "items.0.metadata.name"
+func extractJSONPath(value interface{}, path string) (interface{}, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := value
+	for i, segment := range segments {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			next, ok := node[segment]
+			if !ok {
+				return nil, fmt.Errorf("json_path %q: key %q not found", path, segment)
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, fmt.Errorf("json_path %q: %q is not an array index", path, segment)
+			}
+			if index < 0 {
+				index += len(node)
+			}
+			if index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("json_path %q: index %s out of range (length %d)", path, segment, len(node))
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("json_path %q: cannot descend into %q at segment %d", path, segment, i+1)
+		}
+	}
+
+	return current, nil
+}
+
+// parseJSONPath splits a path expression into keys and array indexes
+func parseJSONPath(path string) ([]string, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+
+	var segments []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			segments = append(segments, current.String())
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("json_path %q: unterminated '['", path)
+			}
+			index := strings.Trim(path[i+1:i+end], `"'`)
+			if index == "" {
+				return nil, fmt.Errorf("json_path %q: empty index", path)
+			}
+			segments = append(segments, index)
+			i += end
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return segments, nil
+}
@@ -0,0 +1,44 @@
+package shellserver
+
+import "testing"
+
+func TestProcessJSONOutput(t *testing.T) {
+	input := `{"items": [{"name": "a", "size": 12345678901234567890}, {"name": "b"}], "count": 2}`
+
+	tests := []struct {
+		format   string
+		path     string
+		expected string
+		isJSON   bool
+		wantErr  bool
+	}{
+		{"compact", "", `{"count":2,"items":[{"name":"a","size":12345678901234567890},{"name":"b"}]}`, true, false},
+		{"", ".count", "2", true, false},
+		{"", "$.items[1].name", `"b"`, true, false},
+		{"compact", "items.0", `{"name":"a","size":12345678901234567890}`, true, false},
+		{"pretty", "items[-1]", "{\n  \"name\": \"b\"\n}", true, false},
+		{"", ".missing", "", false, true},
+		{"", "items[5]", "", false, true},
+		{"yaml", "", "", false, true},
+	}
+
+	for _, test := range tests {
+		result, isJSON, err := processJSONOutput(input, test.format, test.path)
+		if (err != nil) != test.wantErr {
+			t.Errorf("processJSONOutput(%q, %q) error = %v, wantErr %v", test.format, test.path, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+		if isJSON != test.isJSON || result != test.expected {
+			t.Errorf("processJSONOutput(%q, %q) = (%q, %v), want (%q, %v)",
+				test.format, test.path, result, isJSON, test.expected, test.isJSON)
+		}
+	}
+
+	// Non-JSON output is passed through untouched
+	if result, isJSON, err := processJSONOutput("total 0\n", "pretty", ""); err != nil || isJSON || result != "total 0\n" {
+		t.Errorf("processJSONOutput on plain text = (%q, %v, %v), want passthrough", result, isJSON, err)
+	}
+}
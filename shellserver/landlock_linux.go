@@ -0,0 +1,210 @@
+//go:build linux && (amd64 || arm64)
+
+package shellserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// landlock(7) system calls and constants not exported by package syscall
+const (
+	sysLandlockCreateRuleset     = 444
+	sysLandlockAddRule           = 445
+	sysLandlockRestrictSelf      = 446
+	landlockCreateRulesetVersion = 1 << 0   // LANDLOCK_CREATE_RULESET_VERSION
+	landlockRulePathBeneath      = 1        // LANDLOCK_RULE_PATH_BENEATH
+	oPath                        = 0x200000 // O_PATH, on amd64 and arm64
+)
+
+// Landlock file system access rights; REFER came with ABI 2 and TRUNCATE
+// with ABI 3
+const (
+	landlockExecute    = 1 << 0
+	landlockWriteFile  = 1 << 1
+	landlockReadFile   = 1 << 2
+	landlockReadDir    = 1 << 3
+	landlockRemoveDir  = 1 << 4
+	landlockRemoveFile = 1 << 5
+	landlockMakeChar   = 1 << 6
+	landlockMakeDir    = 1 << 7
+	landlockMakeReg    = 1 << 8
+	landlockMakeSock   = 1 << 9
+	landlockMakeFifo   = 1 << 10
+	landlockMakeBlock  = 1 << 11
+	landlockMakeSym    = 1 << 12
+	landlockRefer      = 1 << 13
+	landlockTruncate   = 1 << 14
+
+	// Rights a rule on a file, rather than a directory, may grant
+	landlockFileRights = landlockExecute | landlockWriteFile | landlockReadFile | landlockTruncate
+)
+
+// landlockSystemPaths are granted besides the server's paths. The domain
+// is inherited by every command, which must still find its libraries,
+// devices and process information.
+var landlockSystemPaths = map[string]string{
+	"/bin":                 "rx",
+	"/sbin":                "rx",
+	"/usr":                 "rx",
+	"/lib":                 "rx",
+	"/lib64":               "rx",
+	"/lib32":               "rx",
+	"/opt":                 "rx",
+	"/proc":                "r",
+	"/sys":                 "r",
+	"/dev":                 "rw",
+	"/run/systemd/resolve": "r", // Target of /etc/resolv.conf under systemd-resolved
+}
+
+// landlockRulesetAttr is struct landlock_ruleset_attr up to ABI 3
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// landlockPathBeneathAttr is struct landlock_path_beneath_attr. The kernel
+// struct is packed and reads only its first 12 bytes.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// landlockSandbox confines the server's file system access with Landlock.
+// Unlike unveil, a Landlock domain survives exec, so it holds for commands
+// as well: they can reach the server's paths and the system's programs and
+// libraries, but not, for example, the home directory or ~/.ssh.
+type landlockSandbox struct {
+	abi int // Landlock ABI version of the kernel
+}
+
+// LandlockSandbox returns a sandbox that restricts the server and its
+// commands to the paths the server needs, with Linux's Landlock. It fails
+// when the kernel lacks Landlock, and in binaries built with cgo, which
+// cannot restrict every thread.
+func LandlockSandbox() (Sandbox, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return nil, fmt.Errorf("the kernel does not support Landlock, or it is disabled: %v", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_GETPID, 0, 0, 0); errno != 0 {
+		return nil, landlockThreadsError(errno)
+	}
+	return &landlockSandbox{abi: int(abi)}, nil
+}
+
+// Name identifies the sandbox
+func (l *landlockSandbox) Name() string {
+	return "Landlock"
+}
+
+// Restrict creates a ruleset from paths and the system paths and applies it
+// to every thread. no_new_privs is set first, as Landlock requires without
+// CAP_SYS_ADMIN. Paths that do not exist are skipped.
+func (l *landlockSandbox) Restrict(paths map[string]string) error {
+	handled := landlockHandledRights(l.abi)
+	attr := landlockRulesetAttr{handledAccessFS: handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create ruleset: %v", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	all := map[string]string{}
+	for path, access := range landlockSystemPaths {
+		all[path] = access
+	}
+	for path, access := range paths {
+		all[path] += access
+	}
+	names := make([]string, 0, len(all))
+	for path := range all {
+		names = append(names, path)
+	}
+	sort.Strings(names)
+	for _, path := range names {
+		if err := addLandlockRule(int(fd), path, all[path], handled); err != nil && !errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return landlockThreadsError(errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to restrict the server: %v", errno)
+	}
+	return nil
+}
+
+// addLandlockRule allows access beneath path
+func addLandlockRule(ruleset int, path string, access string, handled uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return err
+	}
+
+	rule := landlockPathBeneathAttr{
+		allowedAccess: landlockRights(access, stat.Mode&syscall.S_IFMT == syscall.S_IFDIR) & handled,
+		parentFd:      int32(fd),
+	}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// landlockRights maps the access letters of a Sandbox path to Landlock
+// rights. Creating includes removing and renaming, which the trash and
+// atomic writes need.
+func landlockRights(access string, dir bool) uint64 {
+	var rights uint64
+	for _, c := range access {
+		switch c {
+		case 'r':
+			rights |= landlockReadFile | landlockReadDir
+		case 'w':
+			rights |= landlockWriteFile | landlockTruncate
+		case 'x':
+			rights |= landlockExecute
+		case 'c':
+			rights |= landlockMakeReg | landlockMakeDir | landlockMakeSym | landlockMakeSock | landlockMakeFifo |
+				landlockRemoveFile | landlockRemoveDir | landlockRefer
+		}
+	}
+	if !dir {
+		rights &= landlockFileRights
+	}
+	return rights
+}
+
+// landlockHandledRights lists the rights the kernel's ABI can restrict.
+// Devices cannot be created anywhere.
+func landlockHandledRights(abi int) uint64 {
+	handled := uint64(landlockExecute | landlockWriteFile | landlockReadFile | landlockReadDir |
+		landlockRemoveDir | landlockRemoveFile | landlockMakeChar | landlockMakeDir | landlockMakeReg |
+		landlockMakeSock | landlockMakeFifo | landlockMakeBlock | landlockMakeSym)
+	if abi >= 2 {
+		handled |= landlockRefer
+	}
+	if abi >= 3 {
+		handled |= landlockTruncate
+	}
+	return handled
+}
+
+// landlockThreadsError explains why a system call could not run on every
+// thread
+func landlockThreadsError(errno syscall.Errno) error {
+	if errno == syscall.ENOTSUP {
+		return fmt.Errorf("Landlock must apply to every thread, which a binary built with cgo cannot do; build with CGO_ENABLED=0")
+	}
+	return fmt.Errorf("failed to run a system call on every thread: %v", errno)
+}
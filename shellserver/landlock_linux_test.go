@@ -0,0 +1,76 @@
+//go:build linux && (amd64 || arm64)
+
+package shellserver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLandlockSandbox applies Landlock in a child test process, since it
+// cannot be lifted once applied
+func TestLandlockSandbox(t *testing.T) {
+	if dir := os.Getenv("SHELLSERVER_LANDLOCK_CHILD"); dir != "" {
+		sandbox, err := LandlockSandbox()
+		if err != nil {
+			t.Skipf("Landlock is unavailable: %v", err)
+		}
+		if err := sandbox.Restrict(map[string]string{dir: "rwc", "/etc": "r", "/dev/null": "rw"}); err != nil {
+			t.Fatalf("Restrict failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "allowed"), []byte("ok"), 0600); err != nil {
+			t.Errorf("writing a granted directory failed: %v", err)
+		}
+		if _, err := os.ReadFile(os.Getenv("SHELLSERVER_LANDLOCK_SECRET")); err == nil {
+			t.Errorf("reading a file outside the granted paths succeeded")
+		}
+		secret := os.Getenv("SHELLSERVER_LANDLOCK_SECRET")
+		execution := localExecutor{}.Execute(context.Background(), "cat "+secret+" || echo denied", "bash", nil, nil)
+		if strings.TrimSpace(execution.Output) == "secret" || !strings.Contains(execution.Output, "denied") {
+			t.Errorf("command under Landlock = %q, want the secret denied", execution.Output)
+		}
+		return
+	}
+
+	if _, err := LandlockSandbox(); err != nil {
+		t.Skipf("Landlock is unavailable: %v", err)
+	}
+	secret := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secret, []byte("secret"), 0600)
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLandlockSandbox$", "-test.v")
+	cmd.Env = append(os.Environ(), "SHELLSERVER_LANDLOCK_CHILD="+dir, "SHELLSERVER_LANDLOCK_SECRET="+secret)
+	output, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(output), "--- PASS") {
+		t.Errorf("Landlock child failed: %v\n%s", err, output)
+	}
+}
+
+func TestLandlockRights(t *testing.T) {
+	tests := []struct {
+		access string
+		dir    bool
+		want   uint64
+	}{
+		{"r", true, landlockReadFile | landlockReadDir},
+		{"r", false, landlockReadFile},
+		{"rx", false, landlockReadFile | landlockExecute},
+		{"w", true, landlockWriteFile | landlockTruncate},
+		{"c", false, 0},
+	}
+	for _, tt := range tests {
+		if got := landlockRights(tt.access, tt.dir); got != tt.want {
+			t.Errorf("landlockRights(%q, %v) = %#x, want %#x", tt.access, tt.dir, got, tt.want)
+		}
+	}
+	if landlockRights("c", true)&landlockRefer == 0 {
+		t.Errorf("creating in a directory does not allow renaming into it")
+	}
+	if landlockHandledRights(1)&(landlockRefer|landlockTruncate) != 0 {
+		t.Errorf("ABI 1 handles rights it does not know")
+	}
+}
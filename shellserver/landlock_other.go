@@ -0,0 +1,13 @@
+//go:build !(linux && (amd64 || arm64))
+
+package shellserver
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// LandlockSandbox returns a Landlock sandbox, which exists only on Linux
+func LandlockSandbox() (Sandbox, error) {
+	return nil, fmt.Errorf("Landlock is not available on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,263 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// A planning agent picks between commands, such as rg and grep -r, and sets
+// their timeouts, better when it knows how long they took before. The
+// latency tracker keeps the latest durations of every command line and of
+// every program run on its own, and estimates a command from the runs of
+// the same command line or, failing those, of its programs.
+
+// Latency tracking settings
+const (
+	LATENCY_SAMPLES  = 20   // Durations kept per command line and per program
+	MAX_LATENCY_KEYS = 2000 // Command lines and programs tracked; the least recently run are dropped
+	CHECK_URI        = "shell://check.json"
+)
+
+// Bases of a latency estimate
+const (
+	LATENCY_COMMAND = "command" // Runs of the same command line
+	LATENCY_PROGRAM = "program" // Runs of the slowest of its programs on their own
+)
+
+// LatencyEstimate is how long a command is expected to take
+type LatencyEstimate struct {
+	Basis    string `json:"basis"`             // LATENCY_COMMAND or LATENCY_PROGRAM
+	Program  string `json:"program,omitempty"` // Program the runs were of, for LATENCY_PROGRAM
+	Samples  int    `json:"samples"`           // Runs the estimate is based on
+	MedianMs int64  `json:"medianMs"`
+	P90Ms    int64  `json:"p90Ms"`
+	MaxMs    int64  `json:"maxMs"`
+	TimedOut int    `json:"timedOut,omitempty"` // Runs that were killed at a timeout
+}
+
+// latencySample is the duration of one run
+type latencySample struct {
+	ms       int64
+	timedOut bool
+}
+
+// latencyEntry holds the latest runs of a command line or program
+type latencyEntry struct {
+	samples []latencySample // Oldest first, at most LATENCY_SAMPLES
+	used    uint64          // When the entry was last recorded to, in records
+}
+
+// latencyTracker keeps the latest durations by scope, i.e. tenant and
+// target, since other machines and tenants' commands run differently
+type latencyTracker struct {
+	mutex   sync.Mutex
+	entries map[string]*latencyEntry
+	records uint64
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{entries: make(map[string]*latencyEntry)}
+}
+
+// latencyScope separates the durations of tenants and targets
+func latencyScope(tenant string, target string) string {
+	return tenant + "\x00" + target + "\x00"
+}
+
+// record adds the duration of an execution, under its command line and,
+// if it runs a single program, under that program. Executions that did not
+// run as a command would, e.g. on an unreachable target, are left out.
+func (l *latencyTracker) record(scope string, execution CommandExecution) {
+	if execution.ErrorCode != "" && !execution.TimedOut && execution.ErrorCode != ERROR_OUTPUT_LIMIT {
+		return
+	}
+	sample := latencySample{ms: execution.ExecutionMs, timedOut: execution.TimedOut}
+	commands, err := ParseCommands(execution.Command)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.add(scope+LATENCY_COMMAND+"\x00"+execution.Command, sample)
+	if err == nil && len(commands) == 1 {
+		l.add(scope+LATENCY_PROGRAM+"\x00"+commands[0].Name, sample)
+	}
+}
+
+// add appends a sample to the entry of key, dropping the least recently
+// used entry if there are too many
+func (l *latencyTracker) add(key string, sample latencySample) {
+	l.records++
+	entry, found := l.entries[key]
+	if !found {
+		if len(l.entries) >= MAX_LATENCY_KEYS {
+			oldest := ""
+			for k, e := range l.entries {
+				if oldest == "" || e.used < l.entries[oldest].used {
+					oldest = k
+				}
+			}
+			delete(l.entries, oldest)
+		}
+		entry = &latencyEntry{}
+		l.entries[key] = entry
+	}
+	entry.used = l.records
+	entry.samples = append(entry.samples, sample)
+	if len(entry.samples) > LATENCY_SAMPLES {
+		entry.samples = entry.samples[len(entry.samples)-LATENCY_SAMPLES:]
+	}
+}
+
+// estimate returns how long a command line is expected to take in scope,
+// or nil if neither it nor its programs ran before
+func (l *latencyTracker) estimate(scope string, command string) *LatencyEstimate {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if entry, found := l.entries[scope+LATENCY_COMMAND+"\x00"+command]; found {
+		estimate := summarizeLatency(entry.samples)
+		estimate.Basis = LATENCY_COMMAND
+		return &estimate
+	}
+
+	// A pipeline or list takes at least as long as its slowest program
+	commands, err := ParseCommands(command)
+	if err != nil {
+		return nil
+	}
+	var slowest *LatencyEstimate
+	for _, cmd := range commands {
+		entry, found := l.entries[scope+LATENCY_PROGRAM+"\x00"+cmd.Name]
+		if !found {
+			continue
+		}
+		estimate := summarizeLatency(entry.samples)
+		if slowest == nil || estimate.MedianMs > slowest.MedianMs {
+			estimate.Basis, estimate.Program = LATENCY_PROGRAM, cmd.Name
+			slowest = &estimate
+		}
+	}
+	return slowest
+}
+
+// summarizeLatency returns the median, 90th percentile and maximum of
+// samples
+func summarizeLatency(samples []latencySample) LatencyEstimate {
+	durations := make([]int64, len(samples))
+	estimate := LatencyEstimate{Samples: len(samples)}
+	for i, sample := range samples {
+		durations[i] = sample.ms
+		if sample.timedOut {
+			estimate.TimedOut++
+		}
+	}
+	slices.Sort(durations)
+	estimate.MedianMs = durations[(len(durations)-1)/2]
+	estimate.P90Ms = durations[(len(durations)*9+9)/10-1]
+	estimate.MaxMs = durations[len(durations)-1]
+	return estimate
+}
+
+// describe tells the agent what the estimate is based on
+func (e LatencyEstimate) describe() string {
+	basis := "this command"
+	if e.Basis == LATENCY_PROGRAM {
+		basis = "'" + e.Program + "'"
+	}
+	text := fmt.Sprintf("Expected to take about %s: the median of the last %d runs of %s, 90%% of which took at most %s and the slowest %s.",
+		transcriptDuration(time.Duration(e.MedianMs)*time.Millisecond), e.Samples, basis,
+		transcriptDuration(time.Duration(e.P90Ms)*time.Millisecond), transcriptDuration(time.Duration(e.MaxMs)*time.Millisecond))
+	if e.TimedOut > 0 {
+		text += fmt.Sprintf(" %d of them timed out.", e.TimedOut)
+	}
+	return text
+}
+
+// recordLatency adds an execution that ran to the latency tracker
+func (s *ShellServer) recordLatency(execution CommandExecution) {
+	tenant := ""
+	if s.tenancy.enabled {
+		tenant = executionTenant(execution)
+	}
+	s.latency.record(latencyScope(tenant, execution.Target), execution)
+}
+
+// withLatency puts the estimate for the next run of a command in the
+// result's metadata, as "latency"
+func (s *ShellServer) withLatency(ctx context.Context, result *mcp.CallToolResult, execution CommandExecution) *mcp.CallToolResult {
+	if estimate := s.latency.estimate(latencyScope(s.tenant(ctx), execution.Target), execution.Command); estimate != nil {
+		result.Meta = map[string]interface{}{"latency": estimate}
+	}
+	return result
+}
+
+// CommandCheck is the structured result of check_command
+type CommandCheck struct {
+	Allowed          bool             `json:"allowed"`
+	Code             string           `json:"code,omitempty"`    // ERROR_* code the command would be refused with
+	Message          string           `json:"message,omitempty"` // Why it would be refused
+	Hint             string           `json:"hint,omitempty"`
+	RequiresApproval bool             `json:"requiresApproval,omitempty"` // A human must approve it first
+	Latency          *LatencyEstimate `json:"latency,omitempty"`          // Nil if neither it nor its programs ran before
+}
+
+func (s *ShellServer) handleCheckCommand(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	command, ok := request.Params.Arguments["command"].(string)
+	if !ok || strings.TrimSpace(command) == "" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_INVALID_COMMAND),
+			Details: map[string]interface{}{"argument": "command"},
+		}), nil
+	}
+	projectName, _ := request.Params.Arguments["project"].(string)
+	if projectName != "" && s.projects[projectName] == nil {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_UNKNOWN_PROJECT, projectName),
+			Details: map[string]interface{}{"argument": "project", "project": projectName, "projects": s.ProjectNames()},
+		}), nil
+	}
+	if projectName == "" && s.workProject != nil {
+		projectName = s.workProject.Name
+	}
+	target, _ := request.Params.Arguments["target"].(string)
+	if target != "" && (s.targets == nil || !s.currentTargets().has(target)) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_INVALID_TARGETS, fmt.Errorf("unknown host or agent '%s'", target)),
+			Details: map[string]interface{}{"argument": "target"},
+		}), nil
+	}
+
+	var check CommandCheck
+	var text strings.Builder
+	policy := s.targetPolicy(s.policyFor(projectName, clientName(s.clientIdentity(ctx))), target)
+	if denied := s.policyDenial(policy, command); denied != nil {
+		toolError := refusal(denied)
+		check.Code, check.Message, check.Hint = toolError.Code, toolError.Message, toolError.Hint
+		text.WriteString(toolError.Message)
+	} else {
+		check.Allowed = true
+		check.RequiresApproval = s.approvals.requiresApproval(command)
+		text.WriteString("Allowed by the policy.")
+		if check.RequiresApproval {
+			text.WriteString(" A human must approve it before it runs.")
+		}
+	}
+
+	check.Latency = s.latency.estimate(latencyScope(s.tenant(ctx), target), command)
+	if check.Latency != nil {
+		text.WriteString("\n" + check.Latency.describe())
+	} else {
+		text.WriteString("\nNeither this command nor its programs ran before, so how long it takes is not known.")
+	}
+	return jsonResult(text.String(), CHECK_URI, check), nil
+}
@@ -0,0 +1,150 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestSummarizeLatency(t *testing.T) {
+	tests := []struct {
+		durations []int64
+		want      LatencyEstimate
+	}{
+		{[]int64{40}, LatencyEstimate{Samples: 1, MedianMs: 40, P90Ms: 40, MaxMs: 40}},
+		{[]int64{30, 10, 20}, LatencyEstimate{Samples: 3, MedianMs: 20, P90Ms: 30, MaxMs: 30}},
+		{[]int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 100}, LatencyEstimate{Samples: 10, MedianMs: 5, P90Ms: 9, MaxMs: 100}},
+	}
+
+	for _, tt := range tests {
+		var samples []latencySample
+		for _, ms := range tt.durations {
+			samples = append(samples, latencySample{ms: ms})
+		}
+		if got := summarizeLatency(samples); got != tt.want {
+			t.Errorf("summarizeLatency(%v) = %+v, want %+v", tt.durations, got, tt.want)
+		}
+	}
+}
+
+func TestLatencyEstimate(t *testing.T) {
+	l := newLatencyTracker()
+	scope := latencyScope("", "")
+	for _, execution := range []CommandExecution{
+		{Command: "grep -r TODO .", ExecutionMs: 900},
+		{Command: "grep -r TODO .", ExecutionMs: 1100},
+		{Command: "rg TODO", ExecutionMs: 40},
+		{Command: "sleep 60", ExecutionMs: 30000, TimedOut: true, ErrorCode: ERROR_TIMEOUT},
+		{Command: "ssh-only", ExecutionMs: 5, ErrorCode: ERROR_TARGET_UNREACHABLE},
+		{Command: "find . | grep x", ExecutionMs: 5000},
+	} {
+		l.record(scope, execution)
+	}
+
+	tests := []struct {
+		scope   string
+		command string
+		want    *LatencyEstimate
+	}{
+		{scope, "grep -r TODO .", &LatencyEstimate{Basis: LATENCY_COMMAND, Samples: 2, MedianMs: 900, P90Ms: 1100, MaxMs: 1100}},
+		{scope, "grep -r FIXME .", &LatencyEstimate{Basis: LATENCY_PROGRAM, Program: "grep", Samples: 2, MedianMs: 900, P90Ms: 1100, MaxMs: 1100}},
+		{scope, "rg FIXME | grep -v vendor", &LatencyEstimate{Basis: LATENCY_PROGRAM, Program: "grep", Samples: 2, MedianMs: 900, P90Ms: 1100, MaxMs: 1100}},
+		{scope, "rg FIXME", &LatencyEstimate{Basis: LATENCY_PROGRAM, Program: "rg", Samples: 1, MedianMs: 40, P90Ms: 40, MaxMs: 40}},
+		{scope, "sleep 60", &LatencyEstimate{Basis: LATENCY_COMMAND, Samples: 1, MedianMs: 30000, P90Ms: 30000, MaxMs: 30000, TimedOut: 1}},
+		// Pipelines only count under their command line, unreachable targets not at all
+		{scope, "find src", nil},
+		{scope, "ssh-only", nil},
+		{latencyScope("", "build1"), "rg TODO", nil},
+		{latencyScope("tenant", ""), "rg TODO", nil},
+	}
+
+	for _, tt := range tests {
+		got := l.estimate(tt.scope, tt.command)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("estimate(%q, %q) = %+v, want %+v", tt.scope, tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestLatencyTrackerBounds(t *testing.T) {
+	l := newLatencyTracker()
+	scope := latencyScope("", "")
+	l.record(scope, CommandExecution{Command: "echo first"})
+	for i := 0; i < LATENCY_SAMPLES+5; i++ {
+		l.record(scope, CommandExecution{Command: "make", ExecutionMs: int64(i)})
+	}
+	if estimate := l.estimate(scope, "make"); estimate == nil || estimate.Samples != LATENCY_SAMPLES || estimate.MaxMs != LATENCY_SAMPLES+4 {
+		t.Errorf("estimate after %d runs = %+v, want the latest %d", LATENCY_SAMPLES+5, estimate, LATENCY_SAMPLES)
+	}
+
+	for i := 0; len(l.entries) < MAX_LATENCY_KEYS; i++ {
+		l.record(scope, CommandExecution{Command: fmt.Sprintf("true %d | true", i)})
+	}
+	l.record(scope, CommandExecution{Command: "echo last"})
+	if len(l.entries) != MAX_LATENCY_KEYS {
+		t.Errorf("tracker holds %d entries, want at most %d", len(l.entries), MAX_LATENCY_KEYS)
+	}
+	// The command line is dropped, the program it ran is still known
+	if estimate := l.estimate(scope, "echo first"); estimate == nil || estimate.Basis != LATENCY_PROGRAM {
+		t.Errorf("estimate of the least recently run command = %+v, want one of its program", estimate)
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	s, err := NewShellServer(
+		WithAllowedCommands("echo,rm"),
+		WithExecutor(&fakeExecutor{}),
+		WithApproval("rm", "", "", "", DEFAULT_APPROVAL_TIMEOUT),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	// Results of execute_command carry the estimate for the next run
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "echo hi"}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	if estimate, ok := result.Meta["latency"].(*LatencyEstimate); !ok || estimate.Basis != LATENCY_COMMAND || estimate.Samples != 1 {
+		t.Errorf("execute_command metadata = %v, want a latency estimate", result.Meta)
+	}
+
+	tests := []struct {
+		command  string
+		text     string
+		allowed  bool
+		approval bool
+		basis    string
+	}{
+		{"echo hi", "Allowed by the policy.\nExpected to take about", true, false, LATENCY_COMMAND},
+		{"echo there", "of the last 1 runs of 'echo'", true, false, LATENCY_PROGRAM},
+		{"rm -rf build", "A human must approve it before it runs.\nNeither this command nor its programs ran before", true, true, ""},
+		{"curl example.com", "'curl'", false, false, ""},
+	}
+
+	for _, tt := range tests {
+		request.Params.Arguments = map[string]interface{}{"command": tt.command}
+		result, _ := s.handleCheckCommand(context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		if !strings.Contains(text, tt.text) {
+			t.Errorf("check_command(%q) = %q, want %q", tt.command, text, tt.text)
+		}
+		var check CommandCheck
+		if err := json.Unmarshal([]byte(result.Content[1].(mcp.EmbeddedResource).Resource.(mcp.TextResourceContents).Text), &check); err != nil {
+			t.Fatalf("check_command(%q) resource: %v", tt.command, err)
+		}
+		basis := ""
+		if check.Latency != nil {
+			basis = check.Latency.Basis
+		}
+		if check.Allowed != tt.allowed || check.RequiresApproval != tt.approval || basis != tt.basis {
+			t.Errorf("check_command(%q) = %+v, want allowed %v, approval %v and basis %q", tt.command, check, tt.allowed, tt.approval, tt.basis)
+		}
+		if !tt.allowed && check.Code != ERROR_POLICY_DENIED {
+			t.Errorf("check_command(%q) code = %q, want %q", tt.command, check.Code, ERROR_POLICY_DENIED)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MAX_NICE is the highest niceness a command can be given
+const MAX_NICE = 19
+
+// umaskPattern matches an octal file mode creation mask
+var umaskPattern = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+// CallLimits are the limits of one execute_command call, given as its
+// limits argument. They can only tighten the server's own: the --timeout,
+// the --limits and MAX_OUTPUT_SIZE.
+type CallLimits struct {
+	Timeout   time.Duration  // Zero for the server's timeout
+	MaxOutput int            // Bytes of output kept; zero for MAX_OUTPUT_SIZE
+	Nice      int            // Niceness added to the command
+	Umask     string         // File mode creation mask, e.g. "077"; empty keeps the server's
+	NoNetwork bool           // Run the command without network access
+	Resources ResourceLimits // Zero fields keep the server's limits
+}
+
+// isZero reports whether no limit is set
+func (l CallLimits) isZero() bool {
+	return l == CallLimits{}
+}
+
+type callLimitsKey struct{}
+
+// withCallLimits returns ctx carrying the limits of the command run with it
+func withCallLimits(ctx context.Context, limits CallLimits) context.Context {
+	return context.WithValue(ctx, callLimitsKey{}, limits)
+}
+
+// callLimitsFromContext returns the limits of the command executed with ctx
+func callLimitsFromContext(ctx context.Context) CallLimits {
+	limits, _ := ctx.Value(callLimitsKey{}).(CallLimits)
+	return limits
+}
+
+// withCallLimits returns c with the call's limits applied. Each resource
+// limit is the lower of c's and the call's.
+func (c processControl) withCallLimits(limits CallLimits) processControl {
+	lower := func(current *uint64, requested uint64) {
+		if requested > 0 && (*current == 0 || requested < *current) {
+			*current = requested
+		}
+	}
+	lower(&c.limits.Memory, limits.Resources.Memory)
+	lower(&c.limits.FileSize, limits.Resources.FileSize)
+	lower(&c.limits.OpenFiles, limits.Resources.OpenFiles)
+	lower(&c.limits.Processes, limits.Resources.Processes)
+	if limits.Resources.CPUTime > 0 && (c.limits.CPUTime == 0 || limits.Resources.CPUTime < c.limits.CPUTime) {
+		c.limits.CPUTime = limits.Resources.CPUTime
+	}
+	c.nice += limits.Nice
+	if limits.Umask != "" {
+		c.umask = limits.Umask
+	}
+	c.noNetwork = c.noNetwork || limits.NoNetwork
+	return c
+}
+
+// callLimits reads and checks the limits argument of execute_command, and
+// its idle timeout if it has one
+func (s *ShellServer) callLimits(request mcp.CallToolRequest) (CallLimits, *time.Duration, *ToolError) {
+	var limits CallLimits
+	value, found := request.Params.Arguments["limits"]
+	if !found || value == nil {
+		return limits, nil, nil
+	}
+	invalid := func(key string, format string, args ...interface{}) (CallLimits, *time.Duration, *ToolError) {
+		return CallLimits{}, nil, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: 'limits.%s' %s", key, fmt.Sprintf(format, args...)),
+			Details: map[string]interface{}{"argument": "limits", "limit": key},
+		}
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return CallLimits{}, nil, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'limits' must be an object",
+			Details: map[string]interface{}{"argument": "limits"},
+		}
+	}
+
+	// Keys are checked in order, so the same request fails the same way
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var idleTimeout *time.Duration
+	for _, key := range keys {
+		value := object[key]
+		number, isNumber := value.(float64)
+		text, isText := value.(string)
+		switch key {
+		case "timeout":
+			if !isNumber || number <= 0 {
+				return invalid(key, "must be a positive number of seconds")
+			}
+			limits.Timeout = time.Duration(number * float64(time.Second))
+			if limits.Timeout > s.timeout {
+				return invalid(key, "exceeds the server's maximum of %s", s.timeout)
+			}
+		case "idle_timeout":
+			if !isNumber || number < 0 {
+				return invalid(key, "must be a number of seconds, 0 to disable it")
+			}
+			timeout := time.Duration(number * float64(time.Second))
+			idleTimeout = &timeout
+		case "max_output":
+			if !isNumber || number < 1 || number != math.Trunc(number) {
+				return invalid(key, "must be a positive number of bytes")
+			}
+			if number > MAX_OUTPUT_SIZE {
+				return invalid(key, "exceeds the server's maximum of %d bytes", MAX_OUTPUT_SIZE)
+			}
+			limits.MaxOutput = int(number)
+		case "nice":
+			if !isNumber || number < 0 || number > MAX_NICE || number != math.Trunc(number) {
+				return invalid(key, "must be a whole number from 0 to %d", MAX_NICE)
+			}
+			limits.Nice = int(number)
+		case "umask":
+			if !isText || !umaskPattern.MatchString(text) {
+				return invalid(key, "must be an octal mask such as \"077\"")
+			}
+			limits.Umask = text
+		case "network":
+			enabled, ok := value.(bool)
+			if !ok {
+				return invalid(key, "must be a boolean")
+			}
+			limits.NoNetwork = !enabled
+		case "cpu", "memory", "fsize", "nofile", "nproc":
+			// The same values as in --limits, with counts also as numbers
+			if isNumber && number == math.Trunc(number) && number > 0 {
+				text, isText = fmt.Sprintf("%.0f", number), true
+			}
+			if !isText {
+				return invalid(key, "must be a value as in --limits, e.g. cpu=\"30s\", memory=\"512M\" or nofile=256")
+			}
+			parsed, err := ParseResourceLimits(key + "=" + text)
+			if err != nil {
+				return invalid(key, "must be a value as in --limits: %v", err)
+			}
+			if err := s.checkCallResource(key, parsed); err != nil {
+				return invalid(key, "%v", err)
+			}
+			limits.Resources = mergeResourceLimits(limits.Resources, parsed)
+		default:
+			return CallLimits{}, nil, &ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: fmt.Sprintf("Error: Unknown limit '%s'. Expected timeout, idle_timeout, max_output, nice, umask, network, cpu, memory, fsize, nofile or nproc", key),
+				Details: map[string]interface{}{"argument": "limits", "limit": key},
+			}
+		}
+	}
+
+	if err := checkProcessControl(s.control.withCallLimits(limits)); err != nil {
+		return CallLimits{}, nil, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: 'limits' cannot be enforced: %v", err),
+			Details: map[string]interface{}{"argument": "limits"},
+		}
+	}
+	return limits, idleTimeout, nil
+}
+
+// checkCallResource refuses a resource limit above the server's own
+func (s *ShellServer) checkCallResource(key string, requested ResourceLimits) error {
+	server := s.control.limits
+	exceeds := false
+	switch key {
+	case "cpu":
+		exceeds = server.CPUTime > 0 && requested.CPUTime > server.CPUTime
+	case "memory":
+		exceeds = server.Memory > 0 && requested.Memory > server.Memory
+	case "fsize":
+		exceeds = server.FileSize > 0 && requested.FileSize > server.FileSize
+	case "nofile":
+		exceeds = server.OpenFiles > 0 && requested.OpenFiles > server.OpenFiles
+	case "nproc":
+		exceeds = server.Processes > 0 && requested.Processes > server.Processes
+	}
+	if exceeds {
+		return fmt.Errorf("exceeds the server's --limits")
+	}
+	return nil
+}
+
+// mergeResourceLimits returns a with the limits set in b
+func mergeResourceLimits(a ResourceLimits, b ResourceLimits) ResourceLimits {
+	if b.CPUTime > 0 {
+		a.CPUTime = b.CPUTime
+	}
+	if b.Memory > 0 {
+		a.Memory = b.Memory
+	}
+	if b.FileSize > 0 {
+		a.FileSize = b.FileSize
+	}
+	if b.OpenFiles > 0 {
+		a.OpenFiles = b.OpenFiles
+	}
+	if b.Processes > 0 {
+		a.Processes = b.Processes
+	}
+	return a
+}
@@ -0,0 +1,122 @@
+package shellserver
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCallLimits(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("*"), WithTimeout(10*time.Second), WithResourceLimits(ResourceLimits{Memory: 1 << 30}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		limits interface{}
+		want   CallLimits
+		error  string
+	}{
+		{"none", nil, CallLimits{}, ""},
+		{"scalars", map[string]interface{}{"timeout": 2.5, "max_output": 100.0, "nice": 5.0, "umask": "077"},
+			CallLimits{Timeout: 2500 * time.Millisecond, MaxOutput: 100, Nice: 5, Umask: "077"}, ""},
+		{"resources", map[string]interface{}{"cpu": "5s", "memory": "512M", "nofile": 64.0},
+			CallLimits{Resources: ResourceLimits{CPUTime: 5 * time.Second, Memory: 512 << 20, OpenFiles: 64}}, ""},
+		{"not an object", "timeout=5", CallLimits{}, "'limits' must be an object"},
+		{"unknown", map[string]interface{}{"priority": 1.0}, CallLimits{}, "Unknown limit 'priority'"},
+		{"timeout above server", map[string]interface{}{"timeout": 60.0}, CallLimits{}, "exceeds the server's maximum of 10s"},
+		{"negative timeout", map[string]interface{}{"timeout": -1.0}, CallLimits{}, "'limits.timeout' must be a positive number"},
+		{"output above server", map[string]interface{}{"max_output": float64(MAX_OUTPUT_SIZE + 1)}, CallLimits{}, "'limits.max_output' exceeds"},
+		{"nice out of range", map[string]interface{}{"nice": 20.0}, CallLimits{}, "from 0 to 19"},
+		{"fractional nice", map[string]interface{}{"nice": 1.5}, CallLimits{}, "from 0 to 19"},
+		{"invalid umask", map[string]interface{}{"umask": "999"}, CallLimits{}, "octal mask"},
+		{"invalid network", map[string]interface{}{"network": "off"}, CallLimits{}, "must be a boolean"},
+		{"invalid memory", map[string]interface{}{"memory": "lots"}, CallLimits{}, "'limits.memory' must be a value as in --limits"},
+		{"memory above server", map[string]interface{}{"memory": "2G"}, CallLimits{}, "exceeds the server's --limits"},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"limits": tt.limits}
+		limits, _, toolError := s.callLimits(request)
+		if tt.error != "" {
+			if toolError == nil || !strings.Contains(toolError.Message, tt.error) {
+				t.Errorf("%s: callLimits() error = %v, want %q", tt.name, toolError, tt.error)
+			}
+			continue
+		}
+		if toolError != nil {
+			t.Errorf("%s: callLimits() error = %v", tt.name, toolError.Message)
+		} else if limits != tt.want {
+			t.Errorf("%s: callLimits() = %+v, want %+v", tt.name, limits, tt.want)
+		}
+	}
+}
+
+func TestExecuteWithLimits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("limits need a Unix shell")
+	}
+	s, err := NewShellServer(WithAllowedCommands("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		limits  map[string]interface{}
+		want    string
+	}{
+		{"timeout", "sleep 5", map[string]interface{}{"timeout": 0.2}, "timed out after 200ms"},
+		{"max_output", "printf '%050d' 0", map[string]interface{}{"max_output": 10.0}, "\n0000000000\n... (output truncated"},
+		{"umask", "umask", map[string]interface{}{"umask": "077"}, "0077"},
+		{"nice", "nice", map[string]interface{}{"nice": 7.0}, "\n7\n"},
+		{"fsize", "ulimit -f", map[string]interface{}{"fsize": "1M"}, "\n1024\n"},
+		{"idle_timeout", "sleep 5", map[string]interface{}{"idle_timeout": 0.2}, "no output for 200ms"},
+	}
+
+	for _, tt := range tests {
+		text, _ := callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": tt.command, "limits": tt.limits})
+		if !strings.Contains(text, tt.want) {
+			t.Errorf("%s: execute_command = %q, want %q", tt.name, text, tt.want)
+		}
+	}
+
+	session, err := s.startSession("bash", "")
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	defer s.closeAllSessions()
+	text, isError := callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "pwd", "limits": map[string]interface{}{"nice": 1.0}, "session_id": session.id})
+	if !isError || !strings.Contains(text, "cannot be used in a session") {
+		t.Errorf("execute_command with limits in a session = %q, want an error", text)
+	}
+}
+
+func TestExecuteWithoutNetwork(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("network isolation is Linux only")
+	}
+	if err := exec.Command("unshare", "--user", "--map-current-user", "--net", "--", "true").Run(); err != nil {
+		t.Skipf("unshare cannot create namespaces here: %v", err)
+	}
+	s, err := NewShellServer(WithAllowedCommands("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	// Only the header lines and the loopback interface are left
+	text, _ := callTool(t, s.handleExecuteCommand, map[string]interface{}{
+		"command": "tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' '",
+		"limits":  map[string]interface{}{"network": false},
+	})
+	if !strings.Contains(text, "\nlo\n") || strings.Contains(text, "eth") {
+		t.Errorf("execute_command without network = %q, want only the loopback interface", text)
+	}
+}
@@ -0,0 +1,136 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// LINT_TIMEOUT bounds how long shellcheck may run
+const LINT_TIMEOUT = 10 * time.Second
+
+// LintFinding is a single shellcheck diagnostic
+type LintFinding struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Level   string `json:"level"` // error, warning, info or style
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// shellcheckReport mirrors shellcheck's json1 output format
+type shellcheckReport struct {
+	Comments []LintFinding `json:"comments"`
+}
+
+// lintScript runs shellcheck over a script passed on stdin.
+// Shellcheck exits 1 when it has findings, which is not treated as a failure.
+func lintScript(script string, shell string) ([]LintFinding, error) {
+	if shell == "" {
+		shell = DEFAULT_SHELL
+	}
+	if shell != "bash" && shell != "zsh" {
+		return nil, fmt.Errorf("unsupported shell '%s'. Only bash and zsh are supported", shell)
+	}
+	if shell == "zsh" {
+		// shellcheck has no zsh dialect; bash is the closest it understands
+		shell = "bash"
+	}
+	if _, err := exec.LookPath("shellcheck"); err != nil {
+		return nil, fmt.Errorf("shellcheck is not installed on the server")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), LINT_TIMEOUT)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "shellcheck", "--format=json1", "--shell="+shell, "-")
+	cmd.Stdin = strings.NewReader(script)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); !ok || exitError.ExitCode() != 1 {
+			return nil, fmt.Errorf("shellcheck failed: %v", err)
+		}
+	}
+
+	return parseShellcheckReport(output)
+}
+
+// parseShellcheckReport decodes shellcheck json1 output
+func parseShellcheckReport(output []byte) ([]LintFinding, error) {
+	var report shellcheckReport
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("could not parse shellcheck output: %v", err)
+	}
+	return report.Comments, nil
+}
+
+// formatLintFindings renders findings as one line each
+func formatLintFindings(findings []LintFinding) string {
+	var result strings.Builder
+	for _, finding := range findings {
+		result.WriteString(fmt.Sprintf("line %d, column %d: %s SC%d: %s\n",
+			finding.Line, finding.Column, finding.Level, finding.Code, finding.Message))
+	}
+	return result.String()
+}
+
+func (s *ShellServer) handleLintScript(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	script, ok := request.Params.Arguments["script"].(string)
+	if !ok {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "Error: 'script' must be a string",
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	shell := DEFAULT_SHELL
+	if shellArg, ok := request.Params.Arguments["shell"].(string); ok && shellArg != "" {
+		shell = shellArg
+	}
+
+	findings, err := lintScript(script, shell)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	if len(findings) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "No shellcheck findings.",
+				},
+			},
+		}, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: fmt.Sprintf("Shellcheck findings (%d):\n\n%s", len(findings), formatLintFindings(findings)),
+			},
+		},
+	}, nil
+}
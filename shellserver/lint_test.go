@@ -0,0 +1,42 @@
+package shellserver
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParseShellcheckReport(t *testing.T) {
+	output := []byte(`{"comments":[{"file":"-","line":1,"endLine":1,"column":6,"endColumn":8,"level":"info","code":2086,"message":"Double quote to prevent globbing and word splitting.","fix":null}]}`)
+
+	findings, err := parseShellcheckReport(output)
+	if err != nil {
+		t.Fatalf("parseShellcheckReport failed: %v", err)
+	}
+	if len(findings) != 1 || findings[0].Code != 2086 || findings[0].Line != 1 || findings[0].Column != 6 {
+		t.Errorf("parseShellcheckReport() = %+v, want one SC2086 finding at 1:6", findings)
+	}
+
+	formatted := formatLintFindings(findings)
+	if !strings.Contains(formatted, "line 1, column 6: info SC2086") {
+		t.Errorf("formatLintFindings() = %q", formatted)
+	}
+
+	if _, err := parseShellcheckReport([]byte("not json")); err == nil {
+		t.Errorf("parseShellcheckReport should fail on invalid output")
+	}
+}
+
+func TestLintScript(t *testing.T) {
+	if _, err := exec.LookPath("shellcheck"); err != nil {
+		t.Skip("shellcheck not installed")
+	}
+
+	findings, err := lintScript("rm $1", "bash")
+	if err != nil {
+		t.Fatalf("lintScript failed: %v", err)
+	}
+	if len(findings) == 0 {
+		t.Errorf("lintScript(%q) returned no findings, want SC2086", "rm $1")
+	}
+}
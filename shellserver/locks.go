@@ -0,0 +1,375 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes of a lock rule
+const (
+	LOCK_SCOPE_GLOBAL    = "global"    // One command of the group at a time on the whole server
+	LOCK_SCOPE_WORKSPACE = "workspace" // One command of the group at a time per directory and target
+)
+
+// FLOCK_POLL_INTERVAL is how often a file lock held by another process is
+// tried again
+const FLOCK_POLL_INTERVAL = 100 * time.Millisecond
+
+// LockRule declares a mutual-exclusion group: commands matching any of its
+// commands, or referring to any of its files, run one at a time, and the
+// others queue until it finishes. Its files are also locked with flock(2)
+// while a command of the group runs, so other servers and tools that lock
+// them wait as well.
+type LockRule struct {
+	Name     string   `json:"name"`
+	Commands []string `json:"commands,omitempty"` // Command names with arguments they use, as in deny rules, e.g. "terraform apply"
+	Files    []string `json:"files,omitempty"`    // Files to lock, e.g. "package-lock.json"; relative to the command's directory
+	Scope    string   `json:"scope,omitempty"`    // LOCK_SCOPE_*; LOCK_SCOPE_GLOBAL if empty
+}
+
+// check rejects a lock rule that would match nothing
+func (r LockRule) check() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("lock without a name")
+	}
+	if len(r.Commands) == 0 && len(r.Files) == 0 {
+		return fmt.Errorf("lock '%s' has no commands or files", r.Name)
+	}
+	for _, command := range r.Commands {
+		if len(strings.Fields(command)) == 0 {
+			return fmt.Errorf("lock '%s' has an empty command", r.Name)
+		}
+	}
+	for _, file := range r.Files {
+		if len(pathComponents(file)) == 0 {
+			return fmt.Errorf("lock '%s': file '%s' names no file", r.Name, file)
+		}
+	}
+	if r.Scope != "" && r.Scope != LOCK_SCOPE_GLOBAL && r.Scope != LOCK_SCOPE_WORKSPACE {
+		return fmt.Errorf("lock '%s' has unknown scope '%s': expected %s or %s", r.Name, r.Scope, LOCK_SCOPE_GLOBAL, LOCK_SCOPE_WORKSPACE)
+	}
+	return nil
+}
+
+// matches reports whether one of the commands a command line runs belongs
+// to the group, or refers to one of its files in an argument or redirection
+func (r LockRule) matches(commands []ParsedCommand) bool {
+	return matchesCommandsOrFiles(commands, r.Commands, r.Files)
+}
+
+// matchesCommandsOrFiles reports whether one of commands matches one of
+// rules, as deny rules match, or refers to one of files in an argument or
+// redirection
+func matchesCommandsOrFiles(commands []ParsedCommand, rules []string, files []string) bool {
+	for _, cmd := range commands {
+		for _, rule := range rules {
+			if matchesDenyRule(cmd, strings.Fields(rule)) {
+				return true
+			}
+		}
+		words := cmd.Args
+		for _, redirect := range cmd.Redirects {
+			words = append(words[:len(words):len(words)], strings.TrimLeft(redirect, "0123456789<>&|-"))
+		}
+		for _, file := range files {
+			for _, word := range words {
+				if refersToPath(word, pathComponents(file)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// lockHolder is the command that holds a lock
+type lockHolder struct {
+	id      int64 // ID of the command in dump_diagnostics
+	command string
+	since   time.Time // When it got the lock
+}
+
+// lockWaiter is a command queued for a lock
+type lockWaiter struct {
+	holder lockHolder
+	ready  chan struct{} // Closed when the lock is handed to the waiter
+}
+
+// heldLock is a lock some command holds, and the commands queued for it
+type heldLock struct {
+	holder lockHolder
+	queue  []*lockWaiter
+}
+
+// commandLocks hands out the locks of mutual-exclusion groups, first come
+// first served
+type commandLocks struct {
+	mutex sync.Mutex
+	held  map[string]*heldLock // By lock key
+}
+
+// acquire takes the lock key for holder, queueing behind its current
+// holder if needed; waiting is told who holds it and how many are queued
+// before returning. It fails if ctx ends first.
+func (l *commandLocks) acquire(ctx context.Context, key string, holder lockHolder, waiting func(held lockHolder, queued int)) error {
+	l.mutex.Lock()
+	if l.held == nil {
+		l.held = make(map[string]*heldLock)
+	}
+	lock := l.held[key]
+	if lock == nil {
+		l.held[key] = &heldLock{holder: holder}
+		l.mutex.Unlock()
+		return nil
+	}
+	waiter := &lockWaiter{holder: holder, ready: make(chan struct{})}
+	lock.queue = append(lock.queue, waiter)
+	held, queued := lock.holder, len(lock.queue)
+	l.mutex.Unlock()
+
+	waiting(held, queued)
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	select {
+	case <-waiter.ready:
+		// The lock was handed over as ctx ended; pass it on
+		l.releaseLocked(key)
+	default:
+		lock := l.held[key]
+		for i, queued := range lock.queue {
+			if queued == waiter {
+				lock.queue = append(lock.queue[:i], lock.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// release hands the lock key to the next command in the queue, if any
+func (l *commandLocks) release(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.releaseLocked(key)
+}
+
+// releaseLocked releases the lock key; the caller holds the mutex
+func (l *commandLocks) releaseLocked(key string) {
+	lock := l.held[key]
+	if len(lock.queue) == 0 {
+		delete(l.held, key)
+		return
+	}
+	next := lock.queue[0]
+	lock.queue = lock.queue[1:]
+	lock.holder = next.holder
+	lock.holder.since = time.Now()
+	close(next.ready)
+}
+
+// lockRulesFor returns the lock rules of the server and of the project
+func (s *ShellServer) lockRulesFor(projectName string) []LockRule {
+	rules := s.lockRules
+	if p, found := s.projects[projectName]; found && p.Policy != nil && len(p.Policy.Locks) > 0 {
+		rules = append(append([]LockRule{}, rules...), p.Policy.Locks...)
+	}
+	return rules
+}
+
+// lockKeys returns the locks a request must hold, with the rule of each,
+// sorted so that commands of several groups always take them in the same
+// order
+func lockKeys(rules []LockRule, req *ExecRequest) ([]string, map[string]LockRule) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	commands, err := ParseCommands(req.Command)
+	if err != nil {
+		return nil, nil
+	}
+	matched := map[string]LockRule{}
+	for _, rule := range rules {
+		if !rule.matches(commands) {
+			continue
+		}
+		key := rule.Name
+		if rule.Scope == LOCK_SCOPE_WORKSPACE {
+			// Sessions may cd anywhere, so they share the lock of the session
+			place := req.Dir
+			if req.Session != "" {
+				place = "session " + req.Session
+			}
+			key += "\x00" + req.Target + "\x00" + req.Container + "\x00" + place
+		}
+		matched[key] = rule
+	}
+	keys := make([]string, 0, len(matched))
+	for key := range matched {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, matched
+}
+
+// lockFiles returns the files the rules lock for req, as absolute paths in
+// sorted order. Commands on targets and in sandboxes lock no local files.
+func lockFiles(rules map[string]LockRule, req *ExecRequest) []string {
+	if req.Target != "" || req.Container != "" {
+		return nil
+	}
+	dir := req.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	seen := map[string]bool{}
+	var files []string
+	for _, rule := range rules {
+		for _, file := range rule.Files {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// lockFile takes an exclusive flock(2) on path, polling while another
+// process holds it; waiting is called once if it has to wait. Files that do
+// not exist are not locked, and a nil file is returned. Closing the file
+// releases the lock.
+func lockFile(ctx context.Context, path string, waiting func()) (*os.File, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for waited := false; ; waited = true {
+		locked, err := tryLockFile(file)
+		if err != nil || locked {
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			return file, nil
+		}
+		if !waited {
+			waiting()
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(FLOCK_POLL_INTERVAL):
+		}
+	}
+}
+
+// lockStep runs commands of a mutual-exclusion group one at a time, and
+// holds the group's file locks while they run. A command that has to wait
+// is told, through a progress notification, what holds the lock, and its
+// result says how long it waited.
+func (s *ShellServer) lockStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		keys, rules := lockKeys(s.lockRulesFor(req.Project), req)
+		if len(keys) == 0 {
+			return next(ctx, req)
+		}
+
+		reporter, _ := req.Output.(*progressReporter)
+		report := func(status string) {
+			s.logger.Print(status)
+			if reporter != nil {
+				reporter.wait(status)
+			}
+		}
+		var taken []string
+		var files []*os.File
+		unlock := func() {
+			for _, file := range files {
+				file.Close()
+			}
+			for _, key := range taken {
+				s.locks.release(key)
+			}
+		}
+		defer unlock()
+
+		holder := lockHolder{id: req.runningID, command: req.Command, since: time.Now()}
+		var waits []string
+		for _, key := range keys {
+			name := rules[key].Name
+			start := time.Now()
+			waited := false
+			err := s.locks.acquire(ctx, key, holder, func(held lockHolder, queued int) {
+				waited = true
+				status := fmt.Sprintf("Waiting on lock '%s' held by command %d (%s)", name, held.id, held.command)
+				if queued > 1 {
+					status += fmt.Sprintf(", behind %d other commands", queued-1)
+				}
+				report(status)
+			})
+			if err != nil {
+				return CommandExecution{}, s.lockCanceled(name, err)
+			}
+			taken = append(taken, key)
+			if waited {
+				waits = append(waits, fmt.Sprintf("'%s' for %s", name, time.Since(start).Round(time.Millisecond)))
+			}
+		}
+		for _, path := range lockFiles(rules, req) {
+			start := time.Now()
+			waited := false
+			file, err := lockFile(ctx, path, func() {
+				waited = true
+				report(fmt.Sprintf("Waiting on the lock of %s held by another process", path))
+			})
+			if err != nil {
+				return CommandExecution{}, s.lockCanceled(path, err)
+			}
+			if file != nil {
+				files = append(files, file)
+			}
+			if waited {
+				waits = append(waits, fmt.Sprintf("%s for %s", path, time.Since(start).Round(time.Millisecond)))
+			}
+		}
+		if reporter != nil {
+			reporter.wait("")
+		}
+
+		execution, err := next(ctx, req)
+		if err == nil && len(waits) > 0 {
+			execution.Output += "\n\nWaited on lock " + strings.Join(waits, " and ") + " before running."
+		}
+		return execution, err
+	}
+}
+
+// lockCanceled reports that the lock named lock could not be taken
+func (s *ShellServer) lockCanceled(lock string, err error) error {
+	return &DeniedError{
+		Reason:  fmt.Sprintf("could not take lock '%s': %v", lock, err),
+		Message: s.message(MSG_LOCK_CANCELED, lock, err),
+		Code:    ERROR_EXECUTION_FAILED,
+		Details: map[string]interface{}{"lock": lock},
+	}
+}
@@ -0,0 +1,256 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockRuleCheck(t *testing.T) {
+	tests := []struct {
+		rule LockRule
+		err  string
+	}{
+		{LockRule{Name: "terraform", Commands: []string{"terraform apply"}}, ""},
+		{LockRule{Name: "apt", Commands: []string{"apt", "apt-get"}, Scope: LOCK_SCOPE_WORKSPACE}, ""},
+		{LockRule{Commands: []string{"apt"}}, "lock without a name"},
+		{LockRule{Name: "apt"}, "has no commands or files"},
+		{LockRule{Name: "npm", Files: []string{"package-lock.json"}}, ""},
+		{LockRule{Name: "npm", Files: []string{"/"}}, "names no file"},
+		{LockRule{Name: "apt", Commands: []string{" "}}, "empty command"},
+		{LockRule{Name: "apt", Commands: []string{"apt"}, Scope: "host"}, "unknown scope"},
+	}
+	for _, tt := range tests {
+		err := tt.rule.check()
+		if (tt.err == "" && err != nil) || (tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err))) {
+			t.Errorf("check(%+v) = %v, want %q", tt.rule, err, tt.err)
+		}
+	}
+
+	if _, err := parsePolicyRules([]byte(`{"locks": [{"name": "apt", "commands": ["apt"], "scope": "host"}]}`)); err == nil {
+		t.Errorf("a policy file with an invalid lock was accepted")
+	}
+	if _, err := NewShellServer(WithClientPolicies([]ClientPolicy{{Name: "cursor", Policy: &PolicyRules{Locks: []LockRule{{Name: "apt", Commands: []string{"apt"}}}}}})); err == nil {
+		t.Errorf("a lock set per client was accepted")
+	}
+}
+
+func TestLockKeys(t *testing.T) {
+	rules := []LockRule{
+		{Name: "terraform", Commands: []string{"terraform apply", "terraform destroy"}},
+		{Name: "apt", Commands: []string{"apt", "apt-get"}, Scope: LOCK_SCOPE_WORKSPACE},
+		{Name: "lockfiles", Files: []string{"package-lock.json"}},
+	}
+	tests := []struct {
+		req  ExecRequest
+		want []string
+	}{
+		{ExecRequest{Command: "terraform apply -auto-approve"}, []string{"terraform"}},
+		{ExecRequest{Command: "terraform plan"}, nil},
+		{ExecRequest{Command: "cd infra && terraform destroy"}, []string{"terraform"}},
+		{ExecRequest{Command: "apt-get install -y jq", Dir: "/work/a"}, []string{"apt"}},
+		{ExecRequest{Command: "apt update; terraform apply"}, []string{"apt", "terraform"}},
+		{ExecRequest{Command: "sed -i s/a/b/ web/package-lock.json"}, []string{"lockfiles"}},
+		{ExecRequest{Command: "jq . > package-lock.json"}, []string{"lockfiles"}},
+		{ExecRequest{Command: "cat package.json"}, nil},
+	}
+	for _, tt := range tests {
+		keys, names := lockKeys(rules, &tt.req)
+		var got []string
+		for _, key := range keys {
+			got = append(got, names[key].Name)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("locks of %q = %v, want %v", tt.req.Command, got, tt.want)
+		}
+	}
+
+	a, _ := lockKeys(rules, &ExecRequest{Command: "apt update", Dir: "/work/a"})
+	b, _ := lockKeys(rules, &ExecRequest{Command: "apt update", Dir: "/work/b"})
+	if a[0] == b[0] {
+		t.Errorf("workspace locks of different directories share the key %q", a[0])
+	}
+}
+
+func TestCommandLocksQueue(t *testing.T) {
+	var locks commandLocks
+	if err := locks.acquire(context.Background(), "k", lockHolder{id: 1}, nil); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	// A waiter that gives up leaves the queue to those behind it
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		canceled <- locks.acquire(ctx, "k", lockHolder{id: 2}, func(held lockHolder, queued int) {
+			if held.id != 1 || queued != 1 {
+				t.Errorf("waiting on %d with %d queued, want 1 and 1", held.id, queued)
+			}
+			cancel()
+		})
+	}()
+	if err := <-canceled; err == nil {
+		t.Errorf("acquire with a canceled context succeeded")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		locks.acquire(context.Background(), "k", lockHolder{id: 3}, func(lockHolder, int) {})
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("acquired a held lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	locks.release("k")
+	<-acquired
+	locks.release("k")
+	if len(locks.held) != 0 {
+		t.Errorf("locks still held after release: %v", locks.held)
+	}
+}
+
+func TestLockStep(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("sleep,echo"), WithPolicyRules(&PolicyRules{
+		Locks: []LockRule{{Name: "sleepers", Commands: []string{"sleep"}}},
+	}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	outputs := make([]string, 2)
+	start := time.Now()
+	for i := range outputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			execution, err := s.exec(context.Background(), &ExecRequest{Command: "sleep 0.2 && echo done", Shell: "bash"})
+			if err != nil {
+				t.Errorf("exec failed: %v", err)
+			}
+			outputs[i] = execution.Output
+		}(i)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("two locked commands took %s, want them to run one after the other", elapsed)
+	}
+	if !strings.Contains(outputs[0]+outputs[1], "Waited on lock 'sleepers'") {
+		t.Errorf("outputs %q do not say a command waited on the lock", outputs)
+	}
+
+	// Commands outside the group do not wait
+	done := make(chan struct{})
+	go func() {
+		s.exec(context.Background(), &ExecRequest{Command: "sleep 0.3", Shell: "bash"})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	execution, _ := s.exec(context.Background(), &ExecRequest{Command: "echo free", Shell: "bash"})
+	if strings.Contains(execution.Output, "Waited") {
+		t.Errorf("a command outside the group waited: %q", execution.Output)
+	}
+
+	// A caller that gives up while waiting gets an error and nothing runs
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = s.exec(ctx, &ExecRequest{Command: "sleep 0", Shell: "bash"})
+	if err == nil || refusal(err).Details["lock"] != "sleepers" {
+		t.Errorf("exec canceled while waiting = %v, want a lock error", err)
+	}
+	<-done
+}
+
+func TestLockProgress(t *testing.T) {
+	var sent []map[string]interface{}
+	var mutex sync.Mutex
+	reporter := &progressReporter{
+		send: func(params map[string]interface{}) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			sent = append(sent, params)
+			return nil
+		},
+		start: time.Now(),
+	}
+	s, err := NewShellServer(WithAllowedCommands("sleep"), WithPolicyRules(&PolicyRules{
+		Locks: []LockRule{{Name: "sleepers", Commands: []string{"sleep"}}},
+	}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.exec(context.Background(), &ExecRequest{Command: "sleep 0.2", Shell: "bash"})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.exec(context.Background(), &ExecRequest{Command: "sleep 0", Shell: "bash", Output: reporter})
+	<-done
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(sent) == 0 || !strings.Contains(sent[0]["message"].(string), "Waiting on lock 'sleepers' held by command 1 (sleep 0.2)") {
+		t.Errorf("progress notifications %v do not name the lock's holder", sent)
+	}
+	if reporter.status != "" {
+		t.Errorf("status %q still set once the lock was acquired", reporter.status)
+	}
+}
+
+func TestFileLocks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("flock is not available")
+	}
+	dir := t.TempDir()
+	lockfile := filepath.Join(dir, "package-lock.json")
+	os.WriteFile(lockfile, []byte("{}"), 0600)
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithPolicyRules(&PolicyRules{
+		Locks: []LockRule{{Name: "npm", Commands: []string{"echo npm"}, Files: []string{"package-lock.json", "missing.lock"}}},
+	}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	// Another process holding the lock, as far as flock can tell
+	other, err := os.Open(lockfile)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if locked, err := tryLockFile(other); !locked {
+		t.Fatalf("tryLockFile failed: %v", err)
+	}
+	go func() {
+		time.Sleep(3 * FLOCK_POLL_INTERVAL)
+		other.Close()
+	}()
+	execution, err := s.exec(context.Background(), &ExecRequest{Command: "echo npm install", Shell: "bash", Dir: dir})
+	if err != nil || !strings.Contains(execution.Output, "Waited on lock "+lockfile) {
+		t.Errorf("exec = (%q, %v), want it to wait on %s", execution.Output, err, lockfile)
+	}
+
+	// The lock is released once the command finished
+	again, _ := os.Open(lockfile)
+	defer again.Close()
+	if locked, err := tryLockFile(again); !locked {
+		t.Errorf("file still locked after the command: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*FLOCK_POLL_INTERVAL)
+	defer cancel()
+	_, err = s.exec(ctx, &ExecRequest{Command: "echo npm ci", Shell: "bash", Dir: dir})
+	if err == nil || refusal(err).Details["lock"] != lockfile {
+		t.Errorf("exec while another process holds the lock = %v, want a lock error", err)
+	}
+}
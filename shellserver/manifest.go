@@ -0,0 +1,231 @@
+package shellserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// MANIFEST_FILE declares the project of the directory it is in. The server
+// reads it from its working directory at startup, if the directory is
+// trusted with WithTrustedManifests.
+const MANIFEST_FILE = ".mcp-shell.yaml"
+
+// taskNamePattern matches task names, which may contain ':' as npm scripts do
+var taskNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,63}$`)
+
+// Task is a named command of a project, run with run_task
+type Task struct {
+	Command     string `json:"command"`
+	Description string `json:"description,omitempty"`
+}
+
+// LoadManifest reads the MANIFEST_FILE in dir as a project:
+//
+//	name: api                 # defaults to the directory's name
+//	extends: [devtools]       # presets, as in a policy file
+//	allow: [go, make]
+//	deny: ["git push --force"]
+//	denyPaths: [.env]
+//	readOnly: true
+//	env:
+//	  GOFLAGS: -mod=vendor
+//	tasks:
+//	  test: go test ./...
+//	  lint:
+//	    command: golangci-lint run
+//	    description: Run the linters
+//
+// Unknown keys are rejected.
+func LoadManifest(dir string) (Project, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MANIFEST_FILE))
+	if err != nil {
+		return Project{}, err
+	}
+	document, err := parseYAML(data)
+	if err != nil {
+		return Project{}, err
+	}
+	fields, ok := document.(map[string]interface{})
+	if !ok && document != nil {
+		return Project{}, errors.New("the manifest must be a mapping of keys to values")
+	}
+
+	config := Project{Name: filepath.Base(dir), Dir: dir}
+	rules := &PolicyRules{}
+	for key, value := range fields {
+		switch key {
+		case "name":
+			config.Name, err = manifestString(key, value)
+		case "extends":
+			rules.Extends, err = manifestList(key, value)
+		case "allow":
+			rules.Allow, err = manifestList(key, value)
+		case "deny":
+			rules.Deny, err = manifestList(key, value)
+		case "denyPaths":
+			rules.DenyPaths, err = manifestList(key, value)
+		case "readOnly":
+			var readOnly string
+			if readOnly, err = manifestString(key, value); err == nil {
+				if readOnly != "true" && readOnly != "false" {
+					err = fmt.Errorf("'readOnly' must be true or false, not '%s'", readOnly)
+				}
+				flag := readOnly == "true"
+				rules.ReadOnly = &flag
+			}
+		case "env":
+			config.Env, err = manifestEnv(value)
+		case "tasks":
+			config.Tasks, err = manifestTasks(value)
+		default:
+			err = fmt.Errorf("unknown key '%s'", key)
+		}
+		if err != nil {
+			return Project{}, err
+		}
+	}
+	// Without rules the project has the server's policy, which may be custom
+	if rules.Extends != nil || rules.Allow != nil || rules.Deny != nil || rules.DenyPaths != nil || rules.ReadOnly != nil {
+		config.Policy = rules
+	}
+	return config, nil
+}
+
+// manifestString returns value if it is a scalar
+func manifestString(key string, value interface{}) (string, error) {
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("'%s' must be a single value", key)
+	}
+	return text, nil
+}
+
+// manifestList returns value if it is a sequence of scalars
+func manifestList(key string, value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("'%s' must be a list", key)
+	}
+	var list []string
+	for _, item := range items {
+		text, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("'%s' must be a list of single values", key)
+		}
+		list = append(list, text)
+	}
+	return list, nil
+}
+
+// manifestEnv returns the environment as NAME=value pairs, from a mapping
+// or a list of pairs
+func manifestEnv(value interface{}) ([]string, error) {
+	mapping, ok := value.(map[string]interface{})
+	if !ok {
+		return manifestList("env", value)
+	}
+	var env []string
+	for name, value := range mapping {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("env '%s' must be a single value", name)
+		}
+		env = append(env, name+"="+text)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// manifestTasks returns the tasks, each a command or a mapping with a
+// command and a description
+func manifestTasks(value interface{}) (map[string]Task, error) {
+	mapping, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("'tasks' must map task names to commands")
+	}
+	tasks := make(map[string]Task, len(mapping))
+	for name, value := range mapping {
+		switch value := value.(type) {
+		case string:
+			tasks[name] = Task{Command: value}
+		case map[string]interface{}:
+			var task Task
+			for key, field := range value {
+				text, ok := field.(string)
+				switch {
+				case !ok:
+					return nil, fmt.Errorf("task '%s': '%s' must be a single value", name, key)
+				case key == "command":
+					task.Command = text
+				case key == "description":
+					task.Description = text
+				default:
+					return nil, fmt.Errorf("task '%s': unknown key '%s'", name, key)
+				}
+			}
+			tasks[name] = task
+		default:
+			return nil, fmt.Errorf("task '%s' must be a command or have a command and a description", name)
+		}
+	}
+	return tasks, nil
+}
+
+// WithTrustedManifests trusts the MANIFEST_FILE of a working directory in or
+// under dirs. A manifest can allow commands, so only directories whose
+// contents the operator controls should be trusted.
+func WithTrustedManifests(dirs []string) Option {
+	return func(s *ShellServer) error {
+		for _, dir := range dirs {
+			if !filepath.IsAbs(dir) {
+				return fmt.Errorf("trusted manifest directory must be an absolute path, got '%s'", dir)
+			}
+			resolved, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				return fmt.Errorf("trusted manifest directory: %v", err)
+			}
+			s.manifestRoots = append(s.manifestRoots, resolved)
+		}
+		return nil
+	}
+}
+
+// loadManifest adds the project the manifest in dir declares, if dir is
+// trusted. It runs for the working directory after every option.
+func (s *ShellServer) loadManifest(dir string) error {
+	path := filepath.Join(dir, MANIFEST_FILE)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil
+	}
+	trusted := false
+	for _, root := range s.manifestRoots {
+		trusted = trusted || isUnder(resolved, root)
+	}
+	if !trusted {
+		s.logger.Printf("Ignoring %s: the directory is not trusted with --trust-manifests", path)
+		return nil
+	}
+
+	config, err := LoadManifest(dir)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", path, err)
+	}
+	for _, p := range s.projects {
+		if p.Dir == filepath.Clean(dir) {
+			return fmt.Errorf("%s declares a project for %s, which project '%s' already has", path, dir, p.Name)
+		}
+	}
+	if err := s.addProject(config); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	s.logger.Printf("Loaded project '%s' from %s", config.Name, path)
+	return nil
+}
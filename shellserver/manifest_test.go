@@ -0,0 +1,109 @@
+package shellserver
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "api")
+	os.Mkdir(dir, 0o755)
+	readOnly := true
+
+	tests := []struct {
+		manifest string
+		want     Project
+		wantErr  string
+	}{
+		{"", Project{Name: "api", Dir: dir}, ""},
+		{
+			"name: backend\nallow: [go, make]\ndeny:\n  - git push --force\ndenyPaths: [.env]\nreadOnly: true\nenv:\n  GOFLAGS: -mod=vendor\n  CGO_ENABLED: '0'\n" +
+				"tasks:\n  test: go test ./...\n  lint:\n    command: golangci-lint run\n    description: Run the linters\n",
+			Project{
+				Name: "backend",
+				Dir:  dir,
+				Env:  []string{"CGO_ENABLED=0", "GOFLAGS=-mod=vendor"},
+				Policy: &PolicyRules{
+					Allow:     []string{"go", "make"},
+					Deny:      []string{"git push --force"},
+					DenyPaths: []string{".env"},
+					ReadOnly:  &readOnly,
+				},
+				Tasks: map[string]Task{
+					"test": {Command: "go test ./..."},
+					"lint": {Command: "golangci-lint run", Description: "Run the linters"},
+				},
+			},
+			"",
+		},
+		{"env: [A=1]\n", Project{Name: "api", Dir: dir, Env: []string{"A=1"}}, ""},
+		{"alow: [go]\n", Project{}, "unknown key 'alow'"},
+		{"allow: go\n", Project{}, "'allow' must be a list"},
+		{"readOnly: yes\n", Project{}, "'readOnly' must be true or false"},
+		{"tasks: [test]\n", Project{}, "'tasks' must map task names to commands"},
+		{"tasks:\n  test:\n    cmd: go test\n", Project{}, "task 'test': unknown key 'cmd'"},
+		{"- go\n", Project{}, "must be a mapping"},
+		{"allow: [go\n", Project{}, "line 1: flow sequences must end on the same line"},
+	}
+
+	for _, tt := range tests {
+		os.WriteFile(filepath.Join(dir, MANIFEST_FILE), []byte(tt.manifest), 0o644)
+		got, err := LoadManifest(dir)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadManifest(%q) error = %v, want %q", tt.manifest, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LoadManifest(%q) = %+v, %v, want %+v", tt.manifest, got, err, tt.want)
+		}
+	}
+}
+
+func TestTrustedManifests(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "api")
+	os.Mkdir(dir, 0o755)
+	os.WriteFile(filepath.Join(dir, MANIFEST_FILE), []byte("allow: [make]\ntasks:\n  build: make build\n"), 0o644)
+
+	// Untrusted manifests are ignored with a warning
+	var logs bytes.Buffer
+	s, err := NewShellServer(WithAllowedCommands("ls"), WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if err := s.loadManifest(dir); err != nil || len(s.projects) != 0 || !strings.Contains(logs.String(), "not trusted") {
+		t.Errorf("untrusted manifest: error %v, projects %v, log %q; want it ignored with a warning", err, s.ProjectNames(), logs.String())
+	}
+
+	s, err = NewShellServer(WithAllowedCommands("ls"), WithTrustedManifests([]string{root}), WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if err := s.loadManifest(dir); err != nil {
+		t.Fatalf("loadManifest failed: %v", err)
+	}
+	if err := s.resolveProjectPolicies(); err != nil {
+		t.Fatalf("resolveProjectPolicies failed: %v", err)
+	}
+	p := s.projects["api"]
+	if p == nil || p.Tasks["build"].Command != "make build" || !p.policy.Allowed("make build") || s.policy.Allowed("make build") {
+		t.Errorf("trusted manifest gave project %+v, want api allowing make", p)
+	}
+
+	// A directory cannot be declared twice
+	s, _ = NewShellServer(WithAllowedCommands("ls"), WithTrustedManifests([]string{root}), WithProjects([]Project{{Name: "other", Dir: dir}}))
+	if err := s.loadManifest(dir); err == nil || !strings.Contains(err.Error(), "which project 'other' already has") {
+		t.Errorf("manifest for a configured directory: error %v, want a conflict", err)
+	}
+
+	if _, err := NewShellServer(WithTrustedManifests([]string{"relative"})); err == nil {
+		t.Errorf("WithTrustedManifests(relative) succeeded, want an error")
+	}
+}
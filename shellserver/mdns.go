@@ -0,0 +1,244 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// mDNS discovery browses DNS-SD: it asks the local network once who offers
+// a service, from an ephemeral port so responders answer it directly
+// (RFC 6762's legacy unicast), and names each host after its instance. Key
+// value pairs of the instance's TXT record become labels.
+
+// mDNS settings
+const (
+	MDNS_ADDRESS = "224.0.0.251:5353"
+	MDNS_WAIT    = 2 * time.Second // How long answers are collected
+	mdnsMaxSize  = 9000            // Largest packet read
+)
+
+// DNS record types and class
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsClassIN  = 1
+)
+
+// mdnsService matches DNS-SD service types such as _ssh._tcp
+var mdnsService = regexp.MustCompile(`^_[A-Za-z0-9-]+\._(tcp|udp)$`)
+
+// mdnsBrowser finds the hosts announcing a DNS-SD service
+type mdnsBrowser struct {
+	service string
+}
+
+func (m mdnsBrowser) Discover(ctx context.Context) (map[string]SSHHost, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	group, err := net.ResolveUDPAddr("udp4", MDNS_ADDRESS)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(mdnsQuery(m.service+".local."), group); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(MDNS_WAIT)
+	if end, ok := ctx.Deadline(); ok && end.Before(deadline) {
+		deadline = end
+	}
+	conn.SetReadDeadline(deadline)
+	records := newMDNSRecords()
+	packet := make([]byte, mdnsMaxSize)
+	for {
+		n, _, err := conn.ReadFromUDP(packet)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		// Other responders' garbage does not spoil the answers
+		records.parse(packet[:n])
+	}
+	return records.hosts(m.service + ".local"), nil
+}
+
+func (m mdnsBrowser) String() string {
+	return DISCOVERY_MDNS + ":" + m.service
+}
+
+// mdnsQuery builds a query for the PTR records of name
+func mdnsQuery(name string) []byte {
+	packet := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(packet[4:], 1) // One question
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		packet = append(packet, byte(len(label)))
+		packet = append(packet, label...)
+	}
+	packet = append(packet, 0)
+	packet = binary.BigEndian.AppendUint16(packet, dnsTypePTR)
+	return binary.BigEndian.AppendUint16(packet, dnsClassIN)
+}
+
+// mdnsSRV is where an instance is served
+type mdnsSRV struct {
+	host string
+	port int
+}
+
+// mdnsRecords collects the records of mDNS answers, by lower-case name
+// without the final dot
+type mdnsRecords struct {
+	instances map[string][]string // PTR: service to instances
+	srv       map[string]mdnsSRV
+	txt       map[string][]string
+	addresses map[string]net.IP // A, or AAAA without an A
+}
+
+func newMDNSRecords() *mdnsRecords {
+	return &mdnsRecords{
+		instances: make(map[string][]string),
+		srv:       make(map[string]mdnsSRV),
+		txt:       make(map[string][]string),
+		addresses: make(map[string]net.IP),
+	}
+}
+
+// parse adds the answer and additional records of a response
+func (r *mdnsRecords) parse(packet []byte) error {
+	if len(packet) < 12 {
+		return fmt.Errorf("short packet")
+	}
+	if packet[2]&0x80 == 0 {
+		return fmt.Errorf("not a response")
+	}
+	questions := int(binary.BigEndian.Uint16(packet[4:]))
+	records := int(binary.BigEndian.Uint16(packet[6:])) + int(binary.BigEndian.Uint16(packet[8:])) + int(binary.BigEndian.Uint16(packet[10:]))
+	offset := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readDNSName(packet, offset)
+		if err != nil || next+4 > len(packet) {
+			return fmt.Errorf("invalid question")
+		}
+		offset = next + 4
+	}
+	for i := 0; i < records; i++ {
+		name, next, err := readDNSName(packet, offset)
+		if err != nil || next+10 > len(packet) {
+			return fmt.Errorf("invalid record")
+		}
+		recordType := binary.BigEndian.Uint16(packet[next:])
+		length := int(binary.BigEndian.Uint16(packet[next+8:]))
+		start := next + 10
+		if start+length > len(packet) {
+			return fmt.Errorf("invalid record length")
+		}
+		data := packet[start : start+length]
+		offset = start + length
+
+		switch recordType {
+		case dnsTypePTR:
+			if instance, _, err := readDNSName(packet, start); err == nil {
+				r.instances[name] = append(r.instances[name], instance)
+			}
+		case dnsTypeSRV:
+			if length < 7 {
+				continue
+			}
+			if host, _, err := readDNSName(packet, start+6); err == nil {
+				r.srv[name] = mdnsSRV{host: host, port: int(binary.BigEndian.Uint16(data[4:]))}
+			}
+		case dnsTypeTXT:
+			var entries []string
+			for len(data) > 0 && int(data[0]) < len(data) {
+				entries = append(entries, string(data[1:1+data[0]]))
+				data = data[1+data[0]:]
+			}
+			r.txt[name] = entries
+		case dnsTypeA:
+			if length == net.IPv4len {
+				r.addresses[name] = net.IP(data).To4()
+			}
+		case dnsTypeAAAA:
+			if _, found := r.addresses[name]; !found && length == net.IPv6len {
+				r.addresses[name] = net.IP(data)
+			}
+		}
+	}
+	return nil
+}
+
+// hosts returns a host for each instance of service with an SRV record
+func (r *mdnsRecords) hosts(service string) map[string]SSHHost {
+	hosts := make(map[string]SSHHost)
+	for _, instance := range r.instances[strings.ToLower(service)] {
+		srv, found := r.srv[instance]
+		if !found {
+			continue
+		}
+		name := targetNameFor(strings.TrimSuffix(instance, "."+strings.ToLower(service)))
+		host := SSHHost{Address: strings.TrimSuffix(srv.host, "."), Port: srv.port, Labels: map[string]string{}}
+		if address, found := r.addresses[srv.host]; found {
+			host.Address = address.String()
+		}
+		for _, entry := range r.txt[instance] {
+			if key, value, found := strings.Cut(entry, "="); found {
+				host.Labels[key] = value
+			}
+		}
+		validLabels(host.Labels)
+		if name != "" {
+			hosts[name] = host
+		}
+	}
+	return hosts
+}
+
+// readDNSName reads the possibly compressed name at offset, and returns
+// it in lower case without the final dot, and the offset after it
+func readDNSName(packet []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(packet) {
+			return "", 0, fmt.Errorf("name out of bounds")
+		}
+		length := int(packet[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), next, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(packet) || jumps > 10 {
+				return "", 0, fmt.Errorf("invalid name pointer")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(packet[offset:]) & 0x3FFF)
+			jumps++
+		default:
+			if offset+1+length > len(packet) {
+				return "", 0, fmt.Errorf("label out of bounds")
+			}
+			labels = append(labels, string(packet[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
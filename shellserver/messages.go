@@ -0,0 +1,250 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// IDs of user-facing messages a Translator can replace. Error codes,
+// JSON fields, event names and the output shown to the assistant are not
+// translated.
+const (
+	MSG_INVALID_COMMAND      = "invalid_command"      // 'command' argument is not a string
+	MSG_SESSION_NOT_FOUND    = "session_not_found"    // Session ID
+	MSG_UNKNOWN_PROJECT      = "unknown_project"      // Project name
+	MSG_STATELESS_BUILTIN    = "stateless_builtin"    // Builtin name
+	MSG_USE_SESSION          = "use_session"          // Builtin name
+	MSG_NO_TARGETS           = "no_targets"           // No SSH hosts or agents are configured
+	MSG_INVALID_TARGETS      = "invalid_targets"      // Resolution error
+	MSG_TRANSFERRED          = "transferred"          // Bytes, source, destination, milliseconds
+	MSG_FILE_TOO_LARGE       = "file_too_large"       // Path, limit in bytes
+	MSG_TRANSFER_DENIED_PATH = "transfer_denied_path" // Path, protected path
+	MSG_TRANSFER_READ_ONLY   = "transfer_read_only"   // Destination path
+	MSG_FILE_DENIED_PATH     = "file_denied_path"     // Path, protected path
+	MSG_WRITE_OUTSIDE        = "write_outside"        // Destination
+	MSG_READ_OUTSIDE         = "read_outside"         // Path
+	MSG_ARCHIVE_REJECTED     = "archive_rejected"     // Archive path, reason
+	MSG_FETCH_DISABLED       = "fetch_disabled"       // No URLs are allowed
+	MSG_URL_NOT_ALLOWED      = "url_not_allowed"      // URL
+	MSG_METHOD_NOT_ALLOWED   = "method_not_allowed"   // HTTP method, allowed methods
+	MSG_FETCH_PRIVATE        = "fetch_private"        // Host, private address
+	MSG_EXECUTION_NOT_FOUND  = "execution_not_found"  // Execution ID
+	MSG_STDIN_TOO_LARGE      = "stdin_too_large"      // Resource reference, limit in bytes
+	MSG_PIN_NOT_FOUND        = "pin_not_found"        // Pin name
+	MSG_PINS_EMPTY           = "pins_empty"           // No commands are pinned
+	MSG_NO_CONTAINERS        = "no_containers"        // Sandboxes are not enabled
+	MSG_DEVICE_NOT_ALLOWED   = "device_not_allowed"   // Device, allowed device patterns
+	MSG_GPUS_NOT_ALLOWED     = "gpus_not_allowed"     // Sandboxes may not be given GPUs
+	MSG_IMAGE_NOT_ALLOWED    = "image_not_allowed"    // Image, allowed images
+	MSG_SANDBOX_NOT_FOUND    = "sandbox_not_found"    // Sandbox ID
+	MSG_UNKNOWN_TASK         = "unknown_task"         // Task name, project name
+	MSG_NO_SNAPSHOTS         = "no_snapshots"         // Session snapshots are not enabled
+	MSG_SNAPSHOT_NOT_FOUND   = "snapshot_not_found"   // Snapshot name
+	MSG_TRASH_COMPOUND       = "trash_compound"       // rm is combined with other commands
+	MSG_TRASH_UNRESOLVED     = "trash_unresolved"     // rm argument
+	MSG_TRASH_MIXED          = "trash_mixed"          // Path outside the trash roots
+	MSG_TRASH_DISABLED       = "trash_disabled"       // No trash is configured
+	MSG_NOT_ALLOWED          = "not_allowed"          // Denied command name
+	MSG_DID_YOU_MEAN         = "did_you_mean"         // Suggested command
+	MSG_DENY_RULE            = "deny_rule"            // Matching deny rule
+	MSG_DENIED_PATH          = "denied_path"          // Protected path
+	MSG_READ_ONLY            = "read_only"            // Redirection
+	MSG_UNPARSEABLE          = "unparseable"          // Parse error
+	MSG_APPROVAL_DENIED      = "approval_denied"      // Approval decision or error
+	MSG_RATE_LIMITED         = "rate_limited"         // Limit, period
+	MSG_LOCK_CANCELED        = "lock_canceled"        // Lock name or file, error
+	MSG_QUEUE_CANCELED       = "queue_canceled"       // Error
+	MSG_NOT_AUTHORIZED       = "not_authorized"       // Tool name, authorizer error
+	MSG_MAINTENANCE          = "maintenance"          // Admin's message
+	MSG_PROBE_RATE_LIMITED   = "probe_rate_limited"   // Limit, period
+	MSG_REQUEST_TOO_LARGE    = "request_too_large"    // Limit, size, unit, maximum
+	MSG_SPLIT_REQUEST        = "split_request"        // How to stay within the request limits
+	MSG_HIDDEN_CHARACTER     = "hidden_character"     // Description, character, byte offset
+	MSG_REMOVE_HIDDEN        = "remove_hidden"        // How to write a command without hidden characters
+	MSG_TRIPWIRE             = "tripwire"             // A command tripped a tripwire
+	MSG_TRIPWIRE_LOCKDOWN    = "tripwire_lockdown"    // Maintenance message after a lockdown tripwire
+	MSG_THROTTLED            = "throttled"            // Time left, the anomaly
+	MSG_COMPLETED            = "completed"            // Status in summaries
+	MSG_FAILED               = "failed"               // Exit code
+	MSG_SUMMARY              = "summary"              // Command, status, milliseconds
+	MSG_SUMMARY_SESSION      = "summary_session"      // Command, session ID, status, milliseconds
+	MSG_SUMMARY_TARGETS      = "summary_targets"      // Command, targets, succeeded, hosts, milliseconds
+	MSG_HISTORY_EMPTY        = "history_empty"        // No history yet
+	MSG_HISTORY_HEADER       = "history_header"       // Shown, total
+	MSG_HISTORY_ENTRY        = "history_entry"        // Number, start time, command, shell, milliseconds, status
+	MSG_HISTORY_SUCCESS      = "history_success"      // Status of a successful entry
+	MSG_HISTORY_FAILED       = "history_failed"       // Exit code
+	MSG_HISTORY_OUTPUT       = "history_output"       // Reference to the entry's output
+	MSG_HISTORY_TENANT       = "history_tenant"       // Subject of the entry's client, empty for none
+	MSG_HISTORY_REASON       = "history_reason"       // Why the agent ran the entry's command
+)
+
+// englishMessages are the built-in formats for every message ID
+var englishMessages = map[string]string{
+	MSG_INVALID_COMMAND:      "Error: 'command' must be a string",
+	MSG_SESSION_NOT_FOUND:    "Error: No session with ID '%s'. Run 'start_session' first.",
+	MSG_UNKNOWN_PROJECT:      "Error: No project named '%s' is configured.",
+	MSG_STATELESS_BUILTIN:    "Error: '%s' only changes the state of the shell, and each command runs in a new shell, so it would have no lasting effect.",
+	MSG_USE_SESSION:          "Run '%s' in a session from 'start_session' to keep its effect, or chain it with the command that needs it, e.g. 'cd dir && make'.",
+	MSG_NO_TARGETS:           "Error: No SSH hosts or agents are configured. Start the server with --targets.",
+	MSG_INVALID_TARGETS:      "Error: %v. Run 'list_targets' to see the configured hosts, agents and groups.",
+	MSG_TRANSFERRED:          "Copied %d bytes from %s to %s in %d ms",
+	MSG_FILE_TOO_LARGE:       "Error: '%s' is larger than the %d byte transfer limit.",
+	MSG_TRANSFER_DENIED_PATH: "Error: '%s' is under the protected path '%s'.",
+	MSG_TRANSFER_READ_ONLY:   "Error: Writing '%s' is not allowed; the server is read-only.",
+	MSG_FILE_DENIED_PATH:     "Error: '%s' is under the protected path '%s'.",
+	MSG_WRITE_OUTSIDE:        "Error: '%s' is outside the server's and the projects' directories; files are only written inside them.",
+	MSG_READ_OUTSIDE:         "Error: '%s' is outside the server's and the projects' directories; files are only read inside them.",
+	MSG_ARCHIVE_REJECTED:     "Error: '%s' was not extracted: %v. Nothing was written.",
+	MSG_FETCH_DISABLED:       "Error: No URLs may be fetched. Start the server with --allowed-urls.",
+	MSG_URL_NOT_ALLOWED:      "Error: '%s' is not in the URL allowlist.",
+	MSG_METHOD_NOT_ALLOWED:   "Error: %s requests are not allowed; the allowed methods are %s.",
+	MSG_FETCH_PRIVATE:        "Error: '%s' resolves to the private address %s, and only hosts named in the URL allowlist may be private.",
+	MSG_EXECUTION_NOT_FOUND:  "Error: Execution %d is not in the recent history. Run 'list_recent_commands' to see the executions that can be referenced.",
+	MSG_STDIN_TOO_LARGE:      "Error: '%s' is larger than the %d byte input limit.",
+	MSG_PIN_NOT_FOUND:        "Error: No command is pinned as '%s'. Run 'list_pinned' to see the pinned commands.",
+	MSG_PINS_EMPTY:           "No commands are pinned. Pin one with 'pin_command'.",
+	MSG_NO_CONTAINERS:        "Error: Sandboxes are not enabled. Start the server with --containers=docker.",
+	MSG_DEVICE_NOT_ALLOWED:   "Error: Device '%s' is not allowed in sandboxes. Allowed devices: %s.",
+	MSG_GPUS_NOT_ALLOWED:     "Error: GPUs are not allowed in sandboxes. Start the server with --container-gpus.",
+	MSG_IMAGE_NOT_ALLOWED:    "Error: Image '%s' is not allowed. Allowed images: %s.",
+	MSG_SANDBOX_NOT_FOUND:    "Error: No sandbox with ID '%s'. Create one with 'create_sandbox'.",
+	MSG_UNKNOWN_TASK:         "Error: No task named '%s' in project '%s'. Run 'list_tasks' to see the tasks.",
+	MSG_NO_SNAPSHOTS:         "Error: Session snapshots are not enabled. Start the server with --snapshot-dir.",
+	MSG_SNAPSHOT_NOT_FOUND:   "Error: No snapshot named '%s'. Save one with 'snapshot_session'.",
+	MSG_TRASH_COMPOUND:       "Error: While the trash is enabled, rm must run as a command of its own, without redirections, so what it deletes can be moved to the trash.",
+	MSG_TRASH_UNRESOLVED:     "Error: The rm argument '%s' cannot be resolved to a path, so it cannot be moved to the trash. Use literal paths or globs, and absolute paths in sessions.",
+	MSG_TRASH_MIXED:          "Error: '%s' is outside the directories whose deletions go to the trash. Remove it with a separate rm.",
+	MSG_TRASH_DISABLED:       "The trash is disabled. Start the server with '--trash-dir' to move files deleted with rm to a trash they can be restored from.",
+	MSG_NOT_ALLOWED:          "Error: Command '%s' is not in the allowed list. Run 'list_allowed_commands' to see what commands are permitted.",
+	MSG_DID_YOU_MEAN:         "Did you mean '%s'?",
+	MSG_DENY_RULE:            "Error: Command matches the deny rule '%s'.",
+	MSG_DENIED_PATH:          "Error: Command refers to the protected path '%s'.",
+	MSG_READ_ONLY:            "Error: Output redirection '%s' is not allowed; the server is read-only.",
+	MSG_UNPARSEABLE:          "Error: Command was refused because it could not be parsed safely: %v.",
+	MSG_APPROVAL_DENIED:      "Error: Command requires human approval and was not approved (%s).",
+	MSG_RATE_LIMITED:         "Error: Rate limit of %d commands per %s exceeded. Wait before running more commands.",
+	MSG_LOCK_CANCELED:        "Error: The command did not run: lock '%s' could not be taken (%v).",
+	MSG_QUEUE_CANCELED:       "Error: The command did not run: the call ended while it was queued (%v).",
+	MSG_NOT_AUTHORIZED:       "Error: The call to '%s' was not authorized: %v.",
+	MSG_MAINTENANCE:          "Error: The server is in maintenance mode. %s",
+	MSG_PROBE_RATE_LIMITED:   "Error: Rate limit of %d network diagnostics per %s exceeded. Wait before probing again.",
+	MSG_REQUEST_TOO_LARGE:    "Error: The call was refused: its %s is %d %s, over the limit of %d.",
+	MSG_SPLIT_REQUEST:        "Split the work into smaller calls, or put long input in a file and pass it with stdin_resource.",
+	MSG_HIDDEN_CHARACTER:     "Error: The command contains %s (%s) at byte %d, which could disguise what it runs.",
+	MSG_REMOVE_HIDDEN:        "Retype the command in plain text; use printf escapes such as '\\t' for characters that must be in its output.",
+	MSG_TRIPWIRE:             "Error: The command was refused and reported to the operator.",
+	MSG_TRIPWIRE_LOCKDOWN:    "A command tripped a tripwire; the server refuses tool calls until an operator has reviewed it.",
+	MSG_THROTTLED:            "Error: Commands are throttled for %s after unusual activity: %s. Slow down, and check the commands are not stuck in a loop.",
+	MSG_COMPLETED:            "completed successfully",
+	MSG_FAILED:               "failed with exit code %d",
+	MSG_SUMMARY:              "%s: %s in %d ms",
+	MSG_SUMMARY_SESSION:      "%s in session %s: %s in %d ms",
+	MSG_SUMMARY_TARGETS:      "%s on %s: %d of %d hosts succeeded in %d ms",
+	MSG_HISTORY_EMPTY:        "No commands have been executed yet.",
+	MSG_HISTORY_HEADER:       "Recent commands (showing %d of %d total):",
+	MSG_HISTORY_ENTRY:        "%d. [%s] $ %s\n   Shell: %s, Duration: %d ms, Status: %s",
+	MSG_HISTORY_SUCCESS:      "Success",
+	MSG_HISTORY_FAILED:       "Failed (exit code %d)",
+	MSG_HISTORY_OUTPUT:       "   Output: %s",
+	MSG_HISTORY_TENANT:       "   Tenant: %q",
+	MSG_HISTORY_REASON:       "   Reason: %s",
+}
+
+// Translator supplies user-facing messages in the operator's language
+type Translator interface {
+	// Format returns the fmt format for a MSG_* ID, or "" to use English.
+	// It must use the same verbs for the same arguments as the English
+	// format, but may reorder them with explicit indexes such as %[2]s.
+	Format(id string) string
+}
+
+// MessageCatalog is a Translator backed by a map of message IDs to formats
+type MessageCatalog map[string]string
+
+// Format returns the catalog's format for id
+func (c MessageCatalog) Format(id string) string {
+	return c[id]
+}
+
+// LoadMessageCatalog reads a JSON object of message IDs to formats, e.g.
+// {"history_empty": "Noch keine Befehle ausgeführt."}. Unknown IDs are
+// rejected; WithTranslator checks the formats.
+func LoadMessageCatalog(path string) (MessageCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var catalog MessageCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("invalid message catalog: %v", err)
+	}
+	for id := range catalog {
+		if _, ok := englishMessages[id]; !ok {
+			return nil, fmt.Errorf("unknown message ID '%s'", id)
+		}
+	}
+	return catalog, nil
+}
+
+// checkTranslator checks every message the translator replaces against
+// the English format
+func checkTranslator(translator Translator) error {
+	for id, english := range englishMessages {
+		if format := translator.Format(id); format != "" {
+			if err := matchVerbs(english, format); err != nil {
+				return fmt.Errorf("message '%s': %v", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// formatVerb matches a fmt verb with an optional explicit argument index
+var formatVerb = regexp.MustCompile(`%[-+# 0]*(?:\[(\d+)\])?[0-9.]*([a-zA-Z%])`)
+
+// matchVerbs checks that format formats each argument of english with the
+// same verb
+func matchVerbs(english string, format string) error {
+	want, got := formatArgs(english), formatArgs(format)
+	if len(want) != len(got) {
+		return fmt.Errorf("uses %d arguments, want %d", len(got), len(want))
+	}
+	for arg, verb := range want {
+		if got[arg] != verb {
+			return fmt.Errorf("formats argument %d with %%%s, want %%%s", arg, got[arg], verb)
+		}
+	}
+	return nil
+}
+
+// formatArgs maps each argument a format uses, counted from 1, to its verb
+func formatArgs(format string) map[int]string {
+	args := map[int]string{}
+	next := 1
+	for _, match := range formatVerb.FindAllStringSubmatch(format, -1) {
+		if match[2] == "%" {
+			continue
+		}
+		if match[1] != "" {
+			next, _ = strconv.Atoi(match[1])
+		}
+		args[next] = match[2]
+		next++
+	}
+	return args
+}
+
+// message formats a user-facing message with the configured translator
+func (s *ShellServer) message(id string, args ...interface{}) string {
+	format := englishMessages[id]
+	if s.translator != nil {
+		if translated := s.translator.Format(id); translated != "" {
+			format = translated
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,82 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestLoadMessageCatalog(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		catalog string
+		wantErr string // From loading, or from WithTranslator
+	}{
+		{`{"history_empty": "Noch keine Befehle ausgeführt."}`, ""},
+		{`{"summary": "%[2]s nach %[3]d ms: %[1]s"}`, ""},
+		{`{"failed": "Exit-Code %d (100%% sicher)"}`, ""},
+		{`{"no_such_message": "x"}`, "unknown message ID 'no_such_message'"},
+		{`{"failed": "Exit-Code %s"}`, "formats argument 1 with %s, want %d"},
+		{`{"summary": "%s: %s"}`, "uses 2 arguments, want 3"},
+		{`["not", "an", "object"]`, "invalid message catalog"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, "messages.json")
+		os.WriteFile(path, []byte(tt.catalog), 0600)
+
+		catalog, err := LoadMessageCatalog(path)
+		if err == nil {
+			_, err = NewShellServer(WithTranslator(catalog))
+		}
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("catalog %s failed: %v", tt.catalog, err)
+		} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("catalog %s error = %v, want %q", tt.catalog, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTranslatedMessages(t *testing.T) {
+	catalog := MessageCatalog{
+		MSG_NOT_ALLOWED:     "Fehler: '%s' ist nicht erlaubt.",
+		MSG_COMPLETED:       "erfolgreich",
+		MSG_SUMMARY:         "%[2]s nach %[3]d ms: %[1]s",
+		MSG_HISTORY_HEADER:  "Letzte Befehle (%d von %d):",
+		MSG_HISTORY_SUCCESS: "Erfolg",
+	}
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithExecutor(fixedExecutor("hi\n")), WithTranslator(catalog))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "rm x"}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; text != "Fehler: 'rm' ist nicht erlaubt." {
+		t.Errorf("denial = %q", text)
+	}
+	if toolError := resultError(t, result); toolError == nil || toolError.Code != ERROR_POLICY_DENIED {
+		t.Errorf("translated denial error = %+v, want code %s", toolError, ERROR_POLICY_DENIED)
+	}
+
+	request.Params.Arguments = map[string]interface{}{"command": "echo hi"}
+	result, _ = s.handleExecuteCommand(context.Background(), request)
+	summary := result.Content[len(result.Content)-1].(mcp.TextContent).Text
+	if !strings.HasPrefix(summary, "erfolgreich nach ") || !strings.HasSuffix(summary, " ms: echo hi") {
+		t.Errorf("summary = %q", summary)
+	}
+	// The assistant still sees English
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "Command completed successfully") {
+		t.Errorf("assistant text = %q", text)
+	}
+
+	result, _ = s.handleListRecentCommands(context.Background(), mcp.CallToolRequest{})
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.HasPrefix(text, "Letzte Befehle (1 von 1):") || !strings.Contains(text, "Status: Erfolg") {
+		t.Errorf("history = %q", text)
+	}
+}
@@ -0,0 +1,362 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// REDACTED replaces secrets removed from command output
+const REDACTED = "[REDACTED]"
+
+// defaultRedactions match common credentials in command output
+var defaultRedactions = []*regexp.Regexp{
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),                                                            // AWS access key IDs
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),                                                  // GitHub tokens
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`),                                                // Slack tokens
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]{16,}`),                                          // Bearer tokens
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),      // PEM private keys
+	regexp.MustCompile(`(?i)\b[A-Z0-9_]*(?:PASSWORD|PASSWD|SECRET|TOKEN|API_KEY)[A-Z0-9_]*\s*[=:]\s*\S+`), // KEY=value assignments
+}
+
+// ExecRequest is a command on its way through the middleware chain
+type ExecRequest struct {
+	Command   string   // Command to run, after any rewriting
+	Original  string   // Command as requested, if it was rewritten
+	Reason    string   // Why the agent runs the command, if it said
+	Shell     string   // bash or zsh, or the interpreter of a REPL eval
+	Env       []string // Extra environment variables
+	Session   string   // Persistent session or REPL session to run in, if any
+	Project   string   // Project the command runs for, if any
+	Dir       string   // Directory to run in; empty for the server's working directory
+	Target    string   // SSH host or agent to run on; empty to run locally
+	Container string   // Sandbox container to run in, if any
+	Priority  string   // PRIORITY_*; empty for PRIORITY_INTERACTIVE
+
+	Client *ClientIdentity // Client the command is run for, if known
+
+	FailoverFrom string         // Unreachable target this request stands in for, if any
+	Output       io.Writer      // Also receives the output as it is produced, if set; not used in sessions
+	IdleTimeout  time.Duration  // Stop the command after this long without output; zero for none, not used in sessions
+	Limits       CallLimits     // Limits of this call; not used in sessions
+	StopPattern  *regexp.Regexp // Stop the command gracefully once a line of output matches; not used in sessions
+	Stdin        []byte         // Input for the command, if any; not used in sessions
+
+	SuccessPattern *regexp.Regexp // Output that means success whatever the exit code, if set
+	FailurePattern *regexp.Regexp // Output that means failure whatever the exit code, if set; wins over SuccessPattern
+
+	runningID int64           // ID of the command in dump_diagnostics, set by the audit step
+	stopper   *commandStopper // Stops the command for the admin API, set by the audit step unless the caller did
+}
+
+// ExecFunc runs a command request. A non-nil error means the command was
+// not run; return a *DeniedError to control what the agent is told.
+type ExecFunc func(ctx context.Context, req *ExecRequest) (CommandExecution, error)
+
+// Middleware wraps an ExecFunc with an extra step, e.g. a data loss
+// prevention check. It may inspect or change the request, refuse it by
+// returning an error, or inspect or change the resulting execution.
+type Middleware func(next ExecFunc) ExecFunc
+
+// DeniedError reports that a step refused to run a command
+type DeniedError struct {
+	Reason  string                 // Recorded in the denial event
+	Message string                 // Shown to the agent; the reason is used if empty
+	Code    string                 // ERROR_* code for the agent; ERROR_POLICY_DENIED if empty
+	Details map[string]interface{} // Machine-readable context for the code, if any
+	Hint    string                 // What the agent could try instead, if anything
+}
+
+func (e *DeniedError) Error() string {
+	return e.Reason
+}
+
+// buildChain assembles the execution pipeline:
+// policy → rate limit → anomalies → audit → locks → queue → custom middleware → redaction → execution → post-processing,
+// where execution moves what rm deletes to the trash, if enabled
+func (s *ShellServer) buildChain() ExecFunc {
+	steps := []Middleware{s.policyStep, s.rateLimitStep, s.anomalyStep, s.auditStep, s.lockStep, s.queueStep}
+	steps = append(steps, s.middleware...)
+	steps = append(steps, s.redactionStep, postProcessStep, s.trashStep)
+
+	run := s.runStep
+	for i := len(steps) - 1; i >= 0; i-- {
+		run = steps[i](run)
+	}
+	return run
+}
+
+// policyStep refuses commands that trip a tripwire or the policy does not
+// allow, and holds high-risk commands for human approval
+func (s *ShellServer) policyStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		if tripped := s.tripwireDenial(req); tripped != nil {
+			return CommandExecution{}, tripped
+		}
+		denied := s.policyDenial(s.targetPolicy(s.policyFor(req.Project, clientName(req.Client)), req.Target), req.Command)
+		if s.shadow != nil {
+			reason := ""
+			if denied != nil {
+				reason = denied.Reason
+			}
+			s.shadow.record(req.Command, denied == nil, reason, s.shadow.decide(s, req.Command))
+		}
+		if denied != nil {
+			return CommandExecution{}, denied
+		}
+
+		// High-risk commands wait for a human decision
+		if s.approvals.requiresApproval(req.Command) {
+			decision, err := s.approvals.requestApproval(req.Command, s.approvalImpact(req), req.Reason)
+			if decision != APPROVAL_APPROVED {
+				reason := "approval " + decision
+				if err != nil {
+					reason = err.Error()
+				}
+				return CommandExecution{}, &DeniedError{
+					Reason:  reason,
+					Message: s.message(MSG_APPROVAL_DENIED, reason),
+					Code:    ERROR_APPROVAL_REQUIRED,
+					Details: map[string]interface{}{"decision": decision},
+				}
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// policyDenial explains why policy refuses command, or returns nil if it is
+// allowed
+func (s *ShellServer) policyDenial(policy Policy, command string) *DeniedError {
+	if policy.Allowed(command) {
+		return nil
+	}
+	baseCmd, err := deniedCommand(policy, command)
+	if err != nil {
+		return &DeniedError{
+			Reason:  fmt.Sprintf("command could not be parsed: %v", err),
+			Message: s.message(MSG_UNPARSEABLE, err),
+			Code:    ERROR_POLICY_DENIED,
+			Details: map[string]interface{}{"rule": "unparseable", "parseError": err.Error()},
+		}
+	}
+	if rule, kind := deniedRule(policy, command); kind != "" {
+		return &DeniedError{
+			Reason:  deniedRuleReasons[kind] + " '" + rule + "'",
+			Message: s.message(deniedRuleMessages[kind], rule),
+			Code:    ERROR_POLICY_DENIED,
+			Details: map[string]interface{}{"rule": kind, "match": rule},
+		}
+	}
+	denied := &DeniedError{
+		Reason:  fmt.Sprintf("command '%s' is not in the allowed list", baseCmd),
+		Message: s.message(MSG_NOT_ALLOWED, baseCmd),
+		Code:    ERROR_POLICY_DENIED,
+		Details: map[string]interface{}{"rule": "not_allowed", "command": baseCmd},
+	}
+	if suggestion := suggestCommand(policy, baseCmd); suggestion != "" {
+		denied.Hint = s.message(MSG_DID_YOU_MEAN, suggestion)
+		denied.Message += " " + denied.Hint
+		denied.Details["suggestion"] = suggestion
+	}
+	return denied
+}
+
+// rateLimiter allows at most limit commands in any sliding window of period
+type rateLimiter struct {
+	mutex  sync.Mutex
+	limit  int
+	period time.Duration
+	recent []time.Time
+}
+
+// allow records a command at now if the limit has not been reached
+func (r *rateLimiter) allow(now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cutoff := now.Add(-r.period)
+	kept := r.recent[:0]
+	for _, t := range r.recent {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	r.recent = kept
+
+	if len(r.recent) >= r.limit {
+		return false
+	}
+	r.recent = append(r.recent, now)
+	return true
+}
+
+// rateLimitStep refuses commands beyond the configured rate, if any
+func (s *ShellServer) rateLimitStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		if s.rateLimit != nil && !s.rateLimit.allow(s.now()) {
+			return CommandExecution{}, &DeniedError{
+				Reason:  fmt.Sprintf("rate limit of %d commands per %s exceeded", s.rateLimit.limit, s.rateLimit.period),
+				Message: s.message(MSG_RATE_LIMITED, s.rateLimit.limit, s.rateLimit.period),
+				Code:    ERROR_RATE_LIMITED,
+				Details: map[string]interface{}{"limit": s.rateLimit.limit, "periodMs": s.rateLimit.period.Milliseconds()},
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// auditStep emits start and finish events and records the execution in
+// history and in the latency estimates
+func (s *ShellServer) auditStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		s.emitEvent(EVENT_START, CommandExecution{
+			Command:   req.Command,
+			Original:  req.Original,
+			Reason:    req.Reason,
+			Shell:     req.Shell,
+			Session:   req.Session,
+			Project:   req.Project,
+			Target:    req.Target,
+			Container: req.Container,
+			Client:    req.Client,
+			StartTime: s.now(),
+		}, "")
+
+		recorded := s.executionContext(req)
+		start := s.now()
+		done := s.trackRunning(req)
+		execution, err := next(ctx, req)
+		done()
+		if err != nil {
+			return execution, err
+		}
+		if s.clock != nil {
+			execution.StartTime, execution.EndTime = start, s.now()
+			execution.ExecutionMs = execution.EndTime.Sub(start).Milliseconds()
+		}
+
+		execution.Context = recorded
+		execution = s.addToHistory(execution)
+		s.recordLatency(execution)
+		if execution.TimedOut {
+			s.emitEvent(EVENT_TIMEOUT, execution, "")
+		} else {
+			s.emitEvent(EVENT_FINISH, execution, "")
+		}
+		return execution, nil
+	}
+}
+
+// redactionStep masks secrets and the machine's identity in the output
+// before anything else sees it
+func (s *ShellServer) redactionStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		execution, err := next(ctx, req)
+		for _, pattern := range s.redactions {
+			// Matching first avoids copying output that has nothing to redact
+			if pattern.MatchString(execution.Output) {
+				execution.Output = pattern.ReplaceAllString(execution.Output, REDACTED)
+			}
+		}
+		execution.Output = scrub(execution.Output, s.scrubbers)
+		return execution, err
+	}
+}
+
+// postProcessStep caps the output size and records the original command
+// and the agent's reason
+func postProcessStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		execution, err := next(ctx, req)
+		limit := MAX_OUTPUT_SIZE
+		if req.Limits.MaxOutput > 0 {
+			limit, execution.OutputLimit = req.Limits.MaxOutput, req.Limits.MaxOutput
+		}
+		if len(execution.Output) > limit {
+			execution.Output = execution.Output[:limit] + "\n... (output truncated due to size limit)"
+			if execution.ErrorCode == "" {
+				execution.ErrorCode = ERROR_OUTPUT_LIMIT
+			}
+		}
+		if execution.ExitCode == 127 && execution.ErrorCode == "" && missingCommand(execution.Output) != "" {
+			execution.ErrorCode = ERROR_COMMAND_NOT_FOUND
+		}
+		if req.Target != "" && execution.ExitCode == SSH_UNREACHABLE_EXIT && execution.ErrorCode == "" && sshUnreachable(execution.Output) {
+			execution.ErrorCode = ERROR_TARGET_UNREACHABLE
+		}
+		overrideExitCode(&execution, req)
+		execution.Original = req.Original
+		execution.Reason = req.Reason
+		execution.Project = req.Project
+		execution.Target = req.Target
+		execution.Container = req.Container
+		execution.FailoverFrom = req.FailoverFrom
+		execution.Client = req.Client
+		return execution, err
+	}
+}
+
+// overrideExitCode reports a command as failed or succeeded when its output
+// matches the request's failure or success pattern, keeping the real exit
+// code in OriginalExitCode. Commands that were cut short or did not run
+// are left alone.
+func overrideExitCode(execution *CommandExecution, req *ExecRequest) {
+	if execution.TimedOut || (execution.ErrorCode != "" && execution.ErrorCode != ERROR_OUTPUT_LIMIT) {
+		return
+	}
+	exitCode, pattern, kind := execution.ExitCode, "", ""
+	switch {
+	case req.FailurePattern != nil && execution.ExitCode == 0 && req.FailurePattern.MatchString(execution.Output):
+		execution.ExitCode, pattern, kind = 1, req.FailurePattern.String(), "failure"
+	case req.SuccessPattern != nil && execution.ExitCode != 0 && req.SuccessPattern.MatchString(execution.Output) &&
+		(req.FailurePattern == nil || !req.FailurePattern.MatchString(execution.Output)):
+		execution.ExitCode, pattern, kind = 0, req.SuccessPattern.String(), "success"
+	default:
+		return
+	}
+	execution.OriginalExitCode = &exitCode
+	execution.Output += fmt.Sprintf("\n\nExit code %d reported as %s: output matched %s_pattern '%s'.", exitCode, kind, kind, pattern)
+}
+
+// runStep executes the request in its session, on its target, in its
+// sandbox container or with the executor
+func (s *ShellServer) runStep(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+	if s.agents.has(req.Target) {
+		return s.runOnAgent(req), nil
+	}
+	if req.Target != "" {
+		return s.runOnSSH(ctx, req)
+	}
+	if req.Container != "" {
+		return s.runInContainer(ctx, req)
+	}
+	if req.Session == "" {
+		if req.Dir == "" {
+			return s.executeWith(s.executor, req, req.Command), nil
+		}
+		execution := s.executeWith(s.executor, req, "cd -- "+shellQuote(req.Dir)+" && "+req.Command)
+		execution.Command = req.Command
+		return execution, nil
+	}
+
+	session, found := s.getSession(ctx, req.Session)
+	if !found {
+		return CommandExecution{}, fmt.Errorf("no session with ID '%s'", req.Session)
+	}
+	// The variables are prefixed as shell assignments, so values are quoted
+	// and names must be plain identifiers
+	var prefix strings.Builder
+	for _, pair := range req.Env {
+		name, value, _ := strings.Cut(pair, "=")
+		if !envNamePattern.MatchString(name) {
+			return CommandExecution{}, fmt.Errorf("invalid environment variable name '%s'", name)
+		}
+		prefix.WriteString(name + "=" + shellQuote(value) + " ")
+	}
+	return s.executeInSession(session, prefix.String()+req.Command), nil
+}
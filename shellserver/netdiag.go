@@ -0,0 +1,400 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// resolve_host, tcp_ping and trace_route answer the questions dig, ping and
+// traceroute are usually allowed for, with structured results. Every call
+// counts against a rate limit of its own, apart from the command rate limit.
+
+// Defaults and limits of the network diagnostic tools
+const (
+	DEFAULT_PROBE_LIMIT  = 30                     // Diagnostic calls allowed per DEFAULT_PROBE_PERIOD
+	DEFAULT_PROBE_PERIOD = time.Minute            // Window of the diagnostic rate limit
+	PROBE_TIMEOUT        = 5 * time.Second        // Limit for each lookup or connection attempt
+	DEFAULT_PING_COUNT   = 4                      // Connection attempts when no count is given
+	MAX_PING_COUNT       = 20                     // Most connection attempts in one call
+	PING_INTERVAL        = 500 * time.Millisecond // Pause between connection attempts
+	DEFAULT_TRACE_HOPS   = 30                     // Hops probed when no limit is given
+	MAX_TRACE_HOPS       = 64                     // Most hops probed in one call
+	TRACE_HOP_TIMEOUT    = time.Second            // How long to wait for the reply to each hop
+	RESOLVE_URI          = "shell://resolve.json"
+	TCP_PING_URI         = "shell://tcp_ping.json"
+	TRACE_URI            = "shell://trace.json"
+	DEFAULT_RECORD_TYPES = "A,AAAA" // Record types resolve_host looks up by default
+)
+
+// recordTypes are the DNS record types resolve_host can look up
+var recordTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "TXT", "PTR"}
+
+// DNSRecord is an answer found by resolve_host
+type DNSRecord struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Priority int    `json:"priority,omitempty"` // MX preference
+}
+
+// ResolveResult is what resolve_host returns
+type ResolveResult struct {
+	Host       string            `json:"host"`
+	Records    []DNSRecord       `json:"records"`
+	Errors     map[string]string `json:"errors,omitempty"` // Failed lookups by record type
+	DurationMs int64             `json:"durationMs"`
+}
+
+// PingAttempt is one connection attempt of tcp_ping
+type PingAttempt struct {
+	Seq     int     `json:"seq"`
+	Address string  `json:"address,omitempty"`
+	Ms      float64 `json:"ms,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// PingResult is what tcp_ping returns
+type PingResult struct {
+	Host     string        `json:"host"`
+	Port     int           `json:"port"`
+	Attempts []PingAttempt `json:"attempts"`
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	MinMs    float64       `json:"minMs,omitempty"`
+	AvgMs    float64       `json:"avgMs,omitempty"`
+	MaxMs    float64       `json:"maxMs,omitempty"`
+}
+
+// TraceHop is one hop found by trace_route
+type TraceHop struct {
+	TTL     int     `json:"ttl"`
+	Address string  `json:"address,omitempty"` // Empty when the hop did not answer
+	Ms      float64 `json:"ms,omitempty"`
+}
+
+// TraceResult is what trace_route returns
+type TraceResult struct {
+	Host    string     `json:"host"`
+	Address string     `json:"address"`
+	Hops    []TraceHop `json:"hops"`
+	Reached bool       `json:"reached"` // The last hop is the host
+}
+
+// WithProbeRateLimit allows at most limit resolve_host, tcp_ping and
+// trace_route calls within period
+func WithProbeRateLimit(limit int, period time.Duration) Option {
+	return func(s *ShellServer) error {
+		if limit <= 0 || period <= 0 {
+			return fmt.Errorf("probe rate limit must be positive, got %d per %s", limit, period)
+		}
+		s.probeLimit = &rateLimiter{limit: limit, period: period}
+		return nil
+	}
+}
+
+// resolveHost looks up the given record types of host; an IP address is
+// looked up in reverse instead
+func resolveHost(ctx context.Context, resolver *net.Resolver, host string, types []string) *ResolveResult {
+	result := &ResolveResult{Host: host, Records: []DNSRecord{}}
+	failed := func(recordType string, err error) {
+		if result.Errors == nil {
+			result.Errors = map[string]string{}
+		}
+		result.Errors[recordType] = err.Error()
+	}
+	if net.ParseIP(host) != nil {
+		types = []string{"PTR"}
+	}
+
+	var addresses []net.IPAddr
+	var addressErr error
+	for _, recordType := range types {
+		switch recordType {
+		case "A", "AAAA":
+			if addresses == nil && addressErr == nil {
+				addresses, addressErr = resolver.LookupIPAddr(ctx, host)
+			}
+			if addressErr != nil {
+				failed(recordType, addressErr)
+				continue
+			}
+			for _, address := range addresses {
+				if (address.IP.To4() != nil) == (recordType == "A") {
+					result.Records = append(result.Records, DNSRecord{Type: recordType, Value: address.String()})
+				}
+			}
+		case "CNAME":
+			name, err := resolver.LookupCNAME(ctx, host)
+			if err != nil {
+				failed(recordType, err)
+			} else if strings.TrimSuffix(name, ".") != strings.TrimSuffix(host, ".") {
+				result.Records = append(result.Records, DNSRecord{Type: recordType, Value: name})
+			}
+		case "MX":
+			records, err := resolver.LookupMX(ctx, host)
+			if err != nil {
+				failed(recordType, err)
+			}
+			for _, record := range records {
+				result.Records = append(result.Records, DNSRecord{Type: recordType, Value: record.Host, Priority: int(record.Pref)})
+			}
+		case "NS":
+			records, err := resolver.LookupNS(ctx, host)
+			if err != nil {
+				failed(recordType, err)
+			}
+			for _, record := range records {
+				result.Records = append(result.Records, DNSRecord{Type: recordType, Value: record.Host})
+			}
+		case "TXT":
+			records, err := resolver.LookupTXT(ctx, host)
+			if err != nil {
+				failed(recordType, err)
+			}
+			for _, record := range records {
+				result.Records = append(result.Records, DNSRecord{Type: recordType, Value: record})
+			}
+		case "PTR":
+			names, err := resolver.LookupAddr(ctx, host)
+			if err != nil {
+				failed(recordType, err)
+			}
+			for _, name := range names {
+				result.Records = append(result.Records, DNSRecord{Type: recordType, Value: name})
+			}
+		}
+	}
+	return result
+}
+
+// tcpPing connects to host:port count times, pausing interval between
+// attempts, and reports how long each connection took
+func tcpPing(ctx context.Context, host string, port int, count int, interval time.Duration) *PingResult {
+	result := &PingResult{Host: host, Port: port, Attempts: []PingAttempt{}}
+	dialer := &net.Dialer{Timeout: PROBE_TIMEOUT}
+	var total float64
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return result
+			}
+		}
+		attempt := PingAttempt{Seq: seq}
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		result.Sent++
+		if err != nil {
+			attempt.Error = err.Error()
+			result.Attempts = append(result.Attempts, attempt)
+			continue
+		}
+		attempt.Ms = float64(time.Since(start).Microseconds()) / 1000
+		attempt.Address = conn.RemoteAddr().String()
+		conn.Close()
+		result.Attempts = append(result.Attempts, attempt)
+
+		result.Received++
+		total += attempt.Ms
+		if result.Received == 1 || attempt.Ms < result.MinMs {
+			result.MinMs = attempt.Ms
+		}
+		result.MaxMs = max(result.MaxMs, attempt.Ms)
+		result.AvgMs = total / float64(result.Received)
+	}
+	return result
+}
+
+// probeLimited refuses a diagnostic call beyond the rate limit
+func (s *ShellServer) probeLimited() *ToolError {
+	if s.probeLimit.allow(time.Now()) {
+		return nil
+	}
+	return &ToolError{
+		Code:    ERROR_RATE_LIMITED,
+		Message: s.message(MSG_PROBE_RATE_LIMITED, s.probeLimit.limit, s.probeLimit.period),
+		Details: map[string]interface{}{"limit": s.probeLimit.limit, "periodMs": s.probeLimit.period.Milliseconds()},
+	}
+}
+
+// probeHost returns the host argument of a diagnostic tool
+func probeHost(request mcp.CallToolRequest) (string, *ToolError) {
+	host, _ := request.Params.Arguments["host"].(string)
+	host = strings.TrimSpace(host)
+	if host == "" || strings.ContainsAny(host, " /:@") && net.ParseIP(host) == nil {
+		return "", &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'host' must be a host name or IP address",
+			Details: map[string]interface{}{"argument": "host"},
+		}
+	}
+	return host, nil
+}
+
+func (s *ShellServer) handleResolveHost(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	host, toolError := probeHost(request)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	typesText := DEFAULT_RECORD_TYPES
+	if value, ok := request.Params.Arguments["types"].(string); ok && value != "" {
+		typesText = value
+	}
+	var types []string
+	for _, recordType := range strings.Split(typesText, ",") {
+		recordType = strings.ToUpper(strings.TrimSpace(recordType))
+		if !containsString(recordTypes, recordType) {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: fmt.Sprintf("Error: unknown record type '%s', expected %s", recordType, strings.Join(recordTypes, ", ")),
+				Details: map[string]interface{}{"argument": "types"},
+			}), nil
+		}
+		types = append(types, recordType)
+	}
+	if toolError := s.probeLimited(); toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, PROBE_TIMEOUT)
+	defer cancel()
+	start := time.Now()
+	result := resolveHost(ctx, net.DefaultResolver, host, types)
+	result.DurationMs = time.Since(start).Milliseconds()
+
+	var text strings.Builder
+	if len(result.Records) == 0 {
+		fmt.Fprintf(&text, "No records found for %s.", host)
+	} else {
+		fmt.Fprintf(&text, "%d records for %s:", len(result.Records), host)
+	}
+	for _, record := range result.Records {
+		if record.Type == "MX" {
+			fmt.Fprintf(&text, "\n%s\t%d %s", record.Type, record.Priority, record.Value)
+		} else {
+			fmt.Fprintf(&text, "\n%s\t%s", record.Type, record.Value)
+		}
+	}
+	for _, recordType := range types {
+		if err, found := result.Errors[recordType]; found {
+			fmt.Fprintf(&text, "\n%s lookup failed: %s", recordType, err)
+		}
+	}
+	return jsonResult(text.String(), RESOLVE_URI, result), nil
+}
+
+func (s *ShellServer) handleTCPPing(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	host, toolError := probeHost(request)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	port, ok := request.Params.Arguments["port"].(float64)
+	if !ok || port < 1 || port > 65535 || port != float64(int(port)) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'port' must be a port number from 1 to 65535",
+			Details: map[string]interface{}{"argument": "port"},
+		}), nil
+	}
+	count, toolError := countArgument(request, "count", DEFAULT_PING_COUNT, MAX_PING_COUNT)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	if toolError := s.probeLimited(); toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	result := tcpPing(ctx, host, int(port), count, PING_INTERVAL)
+	var text strings.Builder
+	fmt.Fprintf(&text, "tcp_ping %s port %d: %d of %d connections succeeded", host, result.Port, result.Received, result.Sent)
+	if result.Received > 0 {
+		fmt.Fprintf(&text, ", min/avg/max %.1f/%.1f/%.1f ms", result.MinMs, result.AvgMs, result.MaxMs)
+	}
+	for _, attempt := range result.Attempts {
+		if attempt.Error != "" {
+			fmt.Fprintf(&text, "\n%d: %s", attempt.Seq, attempt.Error)
+		} else {
+			fmt.Fprintf(&text, "\n%d: connected to %s in %.1f ms", attempt.Seq, attempt.Address, attempt.Ms)
+		}
+	}
+	return jsonResult(text.String(), TCP_PING_URI, result), nil
+}
+
+func (s *ShellServer) handleTraceRoute(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	host, toolError := probeHost(request)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	maxHops, toolError := countArgument(request, "max_hops", DEFAULT_TRACE_HOPS, MAX_TRACE_HOPS)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	if toolError := s.probeLimited(); toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	lookup, cancel := context.WithTimeout(ctx, PROBE_TIMEOUT)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(lookup, host)
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: cannot resolve '%s': %v", host, err),
+			Details: map[string]interface{}{"host": host},
+		}), nil
+	}
+	var destination net.IP
+	for _, address := range addresses {
+		if destination = address.IP.To4(); destination != nil {
+			break
+		}
+	}
+	if destination == nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: '%s' has no IPv4 address; trace_route only traces IPv4", host),
+			Details: map[string]interface{}{"host": host},
+		}), nil
+	}
+
+	result := &TraceResult{Host: host, Address: destination.String(), Hops: []TraceHop{}}
+	if err := traceRoute(ctx, destination, maxHops, TRACE_HOP_TIMEOUT, func(hop TraceHop) {
+		result.Hops = append(result.Hops, hop)
+		result.Reached = hop.Address == result.Address
+	}); err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: tracing the route to '%s' failed: %v", host, err),
+			Details: map[string]interface{}{"host": host, "hops": result.Hops},
+		}), nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "trace_route to %s (%s), %d hops max", host, result.Address, maxHops)
+	for _, hop := range result.Hops {
+		if hop.Address == "" {
+			fmt.Fprintf(&text, "\n%2d  *", hop.TTL)
+		} else {
+			fmt.Fprintf(&text, "\n%2d  %s  %.1f ms", hop.TTL, hop.Address, hop.Ms)
+		}
+	}
+	if !result.Reached {
+		fmt.Fprintf(&text, "\n%s was not reached.", host)
+	}
+	return jsonResult(text.String(), TRACE_URI, result), nil
+}
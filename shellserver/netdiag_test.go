@@ -0,0 +1,119 @@
+package shellserver
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestResolveHost(t *testing.T) {
+	result := resolveHost(context.Background(), net.DefaultResolver, "localhost", []string{"A"})
+	found := false
+	for _, record := range result.Records {
+		found = found || (record.Type == "A" && record.Value == "127.0.0.1")
+	}
+	if !found {
+		t.Errorf("resolveHost(localhost, A) = %+v, want 127.0.0.1", result)
+	}
+
+	// Lookups of a name that cannot exist fail without failing the others
+	result = resolveHost(context.Background(), net.DefaultResolver, "no-such-host.invalid", []string{"A", "AAAA"})
+	if len(result.Records) != 0 || result.Errors["A"] == "" || result.Errors["AAAA"] == "" {
+		t.Errorf("resolveHost(no-such-host.invalid) = %+v, want errors for A and AAAA", result)
+	}
+}
+
+func TestTCPPing(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	result := tcpPing(context.Background(), "127.0.0.1", port, 3, time.Millisecond)
+	if result.Sent != 3 || result.Received != 3 || result.MinMs > result.AvgMs || result.AvgMs > result.MaxMs {
+		t.Errorf("tcpPing(open port) = %+v, want 3 of 3 with min <= avg <= max", result)
+	}
+
+	listener.Close()
+	result = tcpPing(context.Background(), "127.0.0.1", port, 2, time.Millisecond)
+	if result.Sent != 2 || result.Received != 0 || result.Attempts[0].Error == "" {
+		t.Errorf("tcpPing(closed port) = %+v, want 0 of 2 with errors", result)
+	}
+}
+
+func TestTraceRoute(t *testing.T) {
+	var hops []TraceHop
+	err := traceRoute(context.Background(), net.IPv4(127, 0, 0, 1), 5, time.Second, func(hop TraceHop) {
+		hops = append(hops, hop)
+	})
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Errorf("traceRoute succeeded on %s, want an error", runtime.GOOS)
+		}
+		return
+	}
+	if err != nil || len(hops) != 1 || hops[0].Address != "127.0.0.1" {
+		t.Errorf("traceRoute(127.0.0.1) = %+v, %v, want a single hop to 127.0.0.1", hops, err)
+	}
+}
+
+func TestNetworkDiagnosticTools(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("ls"), WithProbeRateLimit(4, time.Minute))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		tool     string
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{"resolve_host", map[string]interface{}{"host": "localhost", "types": "a"}, "A\t127.0.0.1", ""},
+		{"resolve_host", map[string]interface{}{"host": "localhost", "types": "SRV"}, "unknown record type 'SRV'", ERROR_INVALID_ARGUMENT},
+		{"resolve_host", map[string]interface{}{"host": "http://localhost/"}, "'host' must be a host name or IP address", ERROR_INVALID_ARGUMENT},
+		{"tcp_ping", map[string]interface{}{"host": "localhost", "port": float64(70000)}, "'port' must be a port number", ERROR_INVALID_ARGUMENT},
+		{"tcp_ping", map[string]interface{}{"host": "localhost", "port": float64(22), "count": float64(0)}, "positive whole number", ERROR_INVALID_ARGUMENT},
+		{"trace_route", map[string]interface{}{"host": "localhost", "max_hops": float64(-1)}, "positive whole number", ERROR_INVALID_ARGUMENT},
+		{"resolve_host", map[string]interface{}{"host": "localhost"}, "records for localhost", ""},
+		{"resolve_host", map[string]interface{}{"host": "localhost"}, "records for localhost", ""},
+		{"resolve_host", map[string]interface{}{"host": "localhost"}, "records for localhost", ""},
+		{"resolve_host", map[string]interface{}{"host": "localhost"}, "Rate limit of 4 network diagnostics per 1m0s exceeded", ERROR_RATE_LIMITED},
+		{"tcp_ping", map[string]interface{}{"host": "localhost", "port": float64(22)}, "Rate limit of 4", ERROR_RATE_LIMITED},
+	}
+
+	handlers := map[string]func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error){
+		"resolve_host": s.handleResolveHost,
+		"tcp_ping":     s.handleTCPPing,
+		"trace_route":  s.handleTraceRoute,
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		result, _ := handlers[tt.tool](context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("%s(%v) = %q (code %q), want %q (code %q)", tt.tool, tt.args, text, code, tt.want, tt.wantCode)
+		}
+	}
+}
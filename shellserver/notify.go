@@ -0,0 +1,218 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Notifier kinds accepted by --notify
+const (
+	NOTIFIER_STDERR  = "stderr"  // One log line per event on stderr
+	NOTIFIER_FILE    = "file"    // JSON lines appended to a file
+	NOTIFIER_WEBHOOK = "webhook" // Signed JSON POSTs to a URL
+	NOTIFIER_SYSLOG  = "syslog"  // Local syslog under the mcp-unix-shell tag
+)
+
+// SYSLOG_TAG identifies the server's messages in syslog
+const SYSLOG_TAG = "mcp-unix-shell"
+
+// Notifier receives command events. Notify is called on the execution path,
+// so implementations talking to slow destinations should queue internally.
+type Notifier interface {
+	Notify(event CommandEvent) error
+}
+
+// filteredNotifier forwards only the events in its filter
+type filteredNotifier struct {
+	notifier Notifier
+	events   map[string]bool
+	projects map[string]bool // Nil forwards events of every project
+}
+
+// Notify forwards the event if it passes the filter
+func (f *filteredNotifier) Notify(event CommandEvent) error {
+	if !f.events[event.Event] {
+		return nil
+	}
+	if f.projects != nil && !f.projects[event.Execution.Project] {
+		return nil
+	}
+	return f.notifier.Notify(event)
+}
+
+// withProjectFilter restricts a notifier to the events of a comma-separated
+// project list
+func withProjectFilter(notifier Notifier, projects string) (Notifier, error) {
+	filter := make(map[string]bool)
+	for _, project := range strings.Split(projects, ",") {
+		if project = strings.TrimSpace(project); project != "" {
+			filter[project] = true
+		}
+	}
+	if len(filter) == 0 {
+		return nil, fmt.Errorf("project filter names no project")
+	}
+
+	filtered, ok := notifier.(*filteredNotifier)
+	if !ok {
+		events, _ := parseEventFilter("")
+		filtered = &filteredNotifier{notifier: notifier, events: events}
+	}
+	filtered.projects = filter
+	return filtered, nil
+}
+
+// withEventFilter restricts a notifier to a comma-separated event list;
+// empty means all events
+func withEventFilter(notifier Notifier, events string) (Notifier, error) {
+	if strings.TrimSpace(events) == "" {
+		return notifier, nil
+	}
+	filter, err := parseEventFilter(events)
+	if err != nil {
+		return nil, err
+	}
+	return &filteredNotifier{notifier: notifier, events: filter}, nil
+}
+
+// formatEvent renders an event as a single human-readable line
+func formatEvent(event CommandEvent) string {
+	execution := event.Execution
+	switch event.Event {
+	case EVENT_DENIAL, EVENT_TRIPWIRE, EVENT_ANOMALY:
+		return fmt.Sprintf("%s: %s (%s)", event.Event, execution.Command, event.Reason)
+	case EVENT_FINISH, EVENT_TIMEOUT:
+		return fmt.Sprintf("%s: %s (exit %d, %d ms)", event.Event, execution.Command, execution.ExitCode, execution.ExecutionMs)
+	default:
+		return fmt.Sprintf("%s: %s", event.Event, execution.Command)
+	}
+}
+
+// stderrNotifier logs events through the standard logger
+type stderrNotifier struct{}
+
+// Notify logs one line per event
+func (stderrNotifier) Notify(event CommandEvent) error {
+	log.Print(formatEvent(event))
+	return nil
+}
+
+// fileNotifier appends events to a file as hash-linked JSON lines
+type fileNotifier struct {
+	mutex    sync.Mutex
+	file     *os.File
+	prevHash string // Hash of the last line written; empty for a new file
+}
+
+// newFileNotifier opens path for appending, creating it if needed, and
+// continues the hash chain of the lines already in it. A line a crash left
+// half written is salvaged first, so the chain continues from the last
+// complete line.
+func newFileNotifier(path string) (*fileNotifier, error) {
+	file, err := openPrivateFile(path, os.O_APPEND|os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := recoverAppendLog(file, path); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to recover event file: %v", err)
+	}
+	last, err := lastLine(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	notifier := &fileNotifier{file: file}
+	if last != nil {
+		notifier.prevHash = lineHash(last)
+	}
+	return notifier, nil
+}
+
+// Notify writes the event as one JSON line linked to the line before it and
+// flushes it to disk
+func (f *fileNotifier) Notify(event CommandEvent) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	line, err := json.Marshal(chainedEvent{CommandEvent: event, PrevHash: f.prevHash})
+	if err != nil {
+		return err
+	}
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	f.prevHash = lineHash(line)
+	return nil
+}
+
+// ParseNotifier builds a notifier from a --notify spec of the form
+// "kind[:target] [events] [project=names]", e.g.
+// "file:/var/log/shell.jsonl denial,timeout project=api"
+func ParseNotifier(spec string, webhookSecret string) (Notifier, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 3 {
+		return nil, fmt.Errorf("expected 'kind[:target] [events] [project=names]', got '%s'", spec)
+	}
+	kind, target, _ := strings.Cut(fields[0], ":")
+	events, projects, projectFilter := "", "", false
+	for _, field := range fields[1:] {
+		if names, found := strings.CutPrefix(field, "project="); found {
+			projects, projectFilter = names, true
+		} else if events == "" {
+			events = field
+		} else {
+			return nil, fmt.Errorf("expected 'kind[:target] [events] [project=names]', got '%s'", spec)
+		}
+	}
+
+	var notifier Notifier
+	var err error
+	switch kind {
+	case NOTIFIER_STDERR:
+		notifier = stderrNotifier{}
+	case NOTIFIER_FILE:
+		if target == "" {
+			return nil, fmt.Errorf("file notifier needs a path, e.g. 'file:/var/log/shell.jsonl'")
+		}
+		notifier, err = newFileNotifier(target)
+	case NOTIFIER_WEBHOOK:
+		notifier, err = newWebhook(target, webhookSecret)
+	case NOTIFIER_SYSLOG:
+		notifier, err = newSyslogNotifier()
+	default:
+		return nil, fmt.Errorf("unknown notifier '%s', expected one of: %s, %s, %s, %s",
+			kind, NOTIFIER_STDERR, NOTIFIER_FILE, NOTIFIER_WEBHOOK, NOTIFIER_SYSLOG)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if notifier, err = withEventFilter(notifier, events); err != nil || !projectFilter {
+		return notifier, err
+	}
+	return withProjectFilter(notifier, projects)
+}
+
+// emitEvent publishes a command event to every configured notifier
+func (s *ShellServer) emitEvent(event string, execution CommandExecution, reason string) {
+	commandEvent := CommandEvent{
+		Event:     event,
+		Timestamp: s.now(),
+		Execution: execution,
+		Reason:    reason,
+	}
+	if s.shadow != nil && execution.Command != "" {
+		commandEvent.Shadow = s.shadow.decide(s, execution.Command)
+	}
+	for _, notifier := range s.notifiers {
+		if err := notifier.Notify(commandEvent); err != nil {
+			s.logger.Printf("Failed to deliver %s event: %v", event, err)
+		}
+	}
+}
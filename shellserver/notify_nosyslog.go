@@ -0,0 +1,21 @@
+//go:build windows || plan9
+
+package shellserver
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// syslogNotifier is unavailable where log/syslog is not supported
+type syslogNotifier struct{}
+
+// newSyslogNotifier reports that there is no syslog to connect to
+func newSyslogNotifier() (*syslogNotifier, error) {
+	return nil, fmt.Errorf("syslog is not available on %s", runtime.GOOS)
+}
+
+// Notify discards the event
+func (s *syslogNotifier) Notify(event CommandEvent) error {
+	return nil
+}
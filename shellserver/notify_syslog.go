@@ -0,0 +1,31 @@
+//go:build !windows && !plan9
+
+package shellserver
+
+import "log/syslog"
+
+// syslogNotifier sends events to the local syslog daemon
+type syslogNotifier struct {
+	writer *syslog.Writer
+}
+
+// newSyslogNotifier connects to the local syslog daemon
+func newSyslogNotifier() (*syslogNotifier, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, SYSLOG_TAG)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogNotifier{writer: writer}, nil
+}
+
+// Notify logs tripwires as alerts, denials, timeouts and anomalies as
+// warnings and everything else as info
+func (s *syslogNotifier) Notify(event CommandEvent) error {
+	if event.Event == EVENT_TRIPWIRE {
+		return s.writer.Alert(formatEvent(event))
+	}
+	if event.Event == EVENT_DENIAL || event.Event == EVENT_TIMEOUT || event.Event == EVENT_ANOMALY {
+		return s.writer.Warning(formatEvent(event))
+	}
+	return s.writer.Info(formatEvent(event))
+}
@@ -0,0 +1,126 @@
+package shellserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recordingNotifier keeps every event it receives
+type recordingNotifier struct {
+	events []CommandEvent
+}
+
+func (r *recordingNotifier) Notify(event CommandEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestParseNotifier(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"stderr", false},
+		{"stderr denial,timeout", false},
+		{"file:" + filepath.Join(dir, "events.jsonl"), false},
+		{"file:", true},
+		{"webhook:https://example.com/hook finish", false},
+		{"webhook:ftp://example.com", true},
+		{"stderr explode", true},
+		{"pager", true},
+		{"", true},
+		{"stderr denial extra", true},
+		{"stderr project=api", false},
+		{"stderr denial,finish project=api,web", false},
+		{"stderr project=", true},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseNotifier(tt.spec, "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseNotifier(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEmitEventFilters(t *testing.T) {
+	all := &recordingNotifier{}
+	denials := &recordingNotifier{}
+	filtered, err := withEventFilter(denials, "denial")
+	if err != nil {
+		t.Fatalf("withEventFilter failed: %v", err)
+	}
+
+	api := &recordingNotifier{}
+	apiOnly, err := withProjectFilter(api, "api")
+	if err != nil {
+		t.Fatalf("withProjectFilter failed: %v", err)
+	}
+
+	s := &ShellServer{notifiers: []Notifier{all, filtered, apiOnly}}
+	s.emitEvent(EVENT_START, CommandExecution{Command: "ls", Project: "api"}, "")
+	s.emitEvent(EVENT_DENIAL, CommandExecution{Command: "rm -rf /"}, "not allowed")
+
+	if len(all.events) != 2 {
+		t.Errorf("unfiltered notifier got %d events, want 2", len(all.events))
+	}
+	if len(denials.events) != 1 || denials.events[0].Event != EVENT_DENIAL {
+		t.Errorf("filtered notifier got %+v, want only the denial", denials.events)
+	}
+	if len(api.events) != 1 || api.events[0].Execution.Project != "api" {
+		t.Errorf("project notifier got %+v, want only the api event", api.events)
+	}
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	notifier, err := ParseNotifier("file:"+path+" finish", "")
+	if err != nil {
+		t.Fatalf("ParseNotifier failed: %v", err)
+	}
+
+	s := &ShellServer{notifiers: []Notifier{notifier}}
+	s.emitEvent(EVENT_START, CommandExecution{Command: "ls"}, "")
+	s.emitEvent(EVENT_FINISH, CommandExecution{Command: "ls", ExitCode: 0}, "")
+	s.emitEvent(EVENT_FINISH, CommandExecution{Command: "false", ExitCode: 1}, "")
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("event file was not created: %v", err)
+	}
+	defer file.Close()
+
+	var commands []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event CommandEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event line %q: %v", scanner.Text(), err)
+		}
+		commands = append(commands, event.Execution.Command)
+	}
+	if len(commands) != 2 || commands[0] != "ls" || commands[1] != "false" {
+		t.Errorf("event file contains %v, want [ls false]", commands)
+	}
+}
+
+func TestFormatEvent(t *testing.T) {
+	tests := []struct {
+		event CommandEvent
+		want  string
+	}{
+		{CommandEvent{Event: EVENT_START, Execution: CommandExecution{Command: "ls"}}, "start: ls"},
+		{CommandEvent{Event: EVENT_FINISH, Execution: CommandExecution{Command: "ls", ExitCode: 2, ExecutionMs: 5}}, "finish: ls (exit 2, 5 ms)"},
+		{CommandEvent{Event: EVENT_DENIAL, Execution: CommandExecution{Command: "rm"}, Reason: "not allowed"}, "denial: rm (not allowed)"},
+	}
+
+	for _, tt := range tests {
+		if got := formatEvent(tt.event); got != tt.want {
+			t.Errorf("formatEvent(%+v) = %q, want %q", tt.event, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package shellserver
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+// Option configures a ShellServer
+type Option func(*ShellServer) error
+
+// WithAllowedCommands allows commands whose first word is in a
+// comma-separated list, or every command with "*"
+func WithAllowedCommands(allowedCommands string) Option {
+	return func(s *ShellServer) error {
+		s.policy = NewAllowlistPolicy(allowedCommands)
+		s.allowedCommands, s.policySources = allowedCommands, nil
+		return nil
+	}
+}
+
+// WithTimeout limits how long each command may run
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *ShellServer) error {
+		if timeout <= 0 {
+			return fmt.Errorf("timeout must be positive, got %s", timeout)
+		}
+		s.timeout = timeout
+		return nil
+	}
+}
+
+// WithIdleTimeout stops commands that produce no output for timeout, while
+// the WithTimeout limit still caps their total run time. Zero disables it.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *ShellServer) error {
+		if timeout < 0 {
+			return fmt.Errorf("idle timeout must not be negative, got %s", timeout)
+		}
+		s.idleTimeout = timeout
+		return nil
+	}
+}
+
+// WithLogger sends the server's diagnostics to logger instead of the
+// standard logger
+func WithLogger(logger *log.Logger) Option {
+	return func(s *ShellServer) error {
+		s.logger = logger
+		return nil
+	}
+}
+
+// WithExecutor replaces the executor that runs one-off commands
+func WithExecutor(executor Executor) Option {
+	return func(s *ShellServer) error {
+		s.executor = executor
+		return nil
+	}
+}
+
+// WithResourceLimits applies rlimits to every command, session and REPL
+func WithResourceLimits(limits ResourceLimits) Option {
+	return func(s *ShellServer) error {
+		control := s.control
+		control.limits = limits
+		if err := checkProcessControl(control); err != nil {
+			return err
+		}
+		s.control = control
+		return nil
+	}
+}
+
+// WithRunAs runs every command, session and REPL as username, which may
+// also be a numeric user ID. The server itself must run as root.
+func WithRunAs(username string) Option {
+	return func(s *ShellServer) error {
+		credential, err := lookupCredential(username)
+		if err != nil {
+			return err
+		}
+		control := s.control
+		control.credential = credential
+		if err := checkProcessControl(control); err != nil {
+			return err
+		}
+		s.control = control
+		return nil
+	}
+}
+
+// WithSandbox restricts the server process with sandbox once it has
+// started, e.g. with PlatformSandbox. Sandboxes passed several times are
+// applied in order.
+func WithSandbox(sandbox Sandbox) Option {
+	return func(s *ShellServer) error {
+		switch current := s.sandbox.(type) {
+		case nil:
+			s.sandbox = sandbox
+		case sandboxChain:
+			s.sandbox = append(current, sandbox)
+		default:
+			s.sandbox = sandboxChain{current, sandbox}
+		}
+		return nil
+	}
+}
+
+// WithTranslator shows denials, summaries and history listings in another
+// language, e.g. with a catalog from LoadMessageCatalog
+func WithTranslator(translator Translator) Option {
+	return func(s *ShellServer) error {
+		if err := checkTranslator(translator); err != nil {
+			return err
+		}
+		s.translator = translator
+		return nil
+	}
+}
+
+// WithPolicy replaces the allowlist from WithAllowedCommands
+func WithPolicy(policy Policy) Option {
+	return func(s *ShellServer) error {
+		s.policy = policy
+		s.customPolicy, s.policySources = true, nil
+		return nil
+	}
+}
+
+// WithHistoryStore replaces the in-memory command history, e.g. with a
+// store from ParseHistoryStore or a database of your own
+func WithHistoryStore(history HistoryStore) Option {
+	return func(s *ShellServer) error {
+		s.history = history
+		return nil
+	}
+}
+
+// WithMiddleware adds custom steps to the execution chain. They run in the
+// given order after the policy, rate limit and audit steps and before
+// redaction and execution.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(s *ShellServer) error {
+		s.middleware = append(s.middleware, middleware...)
+		return nil
+	}
+}
+
+// WithRateLimit refuses commands once limit have run within period
+func WithRateLimit(limit int, period time.Duration) Option {
+	return func(s *ShellServer) error {
+		if limit <= 0 || period <= 0 {
+			return fmt.Errorf("rate limit must be positive, got %d per %s", limit, period)
+		}
+		s.rateLimit = &rateLimiter{limit: limit, period: period}
+		return nil
+	}
+}
+
+// WithRedaction masks matches of patterns in command output before it is
+// returned, recorded in history or sent to notifiers. Without patterns a
+// built-in set matching common credentials is used.
+func WithRedaction(patterns ...*regexp.Regexp) Option {
+	return func(s *ShellServer) error {
+		if len(patterns) == 0 {
+			patterns = defaultRedactions
+		}
+		s.redactions = append(s.redactions, patterns...)
+		return nil
+	}
+}
+
+// WithLintOnExecute runs shellcheck on every executed command and attaches
+// any findings to its result
+func WithLintOnExecute(enabled bool) Option {
+	return func(s *ShellServer) error {
+		s.lintOnExecute = enabled
+		return nil
+	}
+}
+
+// WithSessionBackend selects the backend for persistent sessions
+func WithSessionBackend(backend string) Option {
+	return func(s *ShellServer) error {
+		if backend != SESSION_BACKEND_PIPE && backend != SESSION_BACKEND_TMUX {
+			return fmt.Errorf("invalid session backend '%s': expected '%s' or '%s'", backend, SESSION_BACKEND_PIPE, SESSION_BACKEND_TMUX)
+		}
+		s.sessionBackend = backend
+		return nil
+	}
+}
+
+// WithRecordDir records executions, sessions and REPLs as asciicast files in dir
+func WithRecordDir(dir string) Option {
+	return func(s *ShellServer) error {
+		s.recordDir = dir
+		return nil
+	}
+}
+
+// WithNotifier adds a notifier that receives every command event
+func WithNotifier(notifier Notifier) Option {
+	return func(s *ShellServer) error {
+		s.notifiers = append(s.notifiers, notifier)
+		return nil
+	}
+}
+
+// WithApproval holds commands starting with one of the comma-separated
+// prefixes in required until a human approves them through a Slack or
+// Discord webhook. The callback endpoint is served on listenAddr.
+func WithApproval(required string, chatURL string, publicURL string, listenAddr string, timeout time.Duration) Option {
+	return func(s *ShellServer) error {
+		s.approvals = newApprovalManager(required, chatURL, publicURL, timeout)
+		s.approvalListen = listenAddr
+		return nil
+	}
+}
+
+// WithEmailDigest emails a summary of activity to the comma-separated
+// recipients in to every interval. Commands that need approval are reported
+// as high-risk.
+func WithEmailDigest(smtpAddr string, from string, to string, interval time.Duration) Option {
+	return func(s *ShellServer) error {
+		digest, err := newActivityDigest(smtpAddr, from, to, interval, func(command string) bool {
+			return s.approvals.requiresApproval(command)
+		})
+		if err != nil {
+			return err
+		}
+		s.digest = digest
+		s.notifiers = append(s.notifiers, digest)
+		return nil
+	}
+}
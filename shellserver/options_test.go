@@ -0,0 +1,188 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// fakeExecutor echoes commands back instead of running them
+type fakeExecutor struct {
+	commands []string
+}
+
+func (f *fakeExecutor) Execute(ctx context.Context, command string, shell string, env []string, stream io.Writer) CommandExecution {
+	f.commands = append(f.commands, command)
+	return CommandExecution{
+		Command:   command,
+		Shell:     shell,
+		Output:    "fake: " + command,
+		StartTime: time.Now(),
+		EndTime:   time.Now(),
+	}
+}
+
+// prefixPolicy allows commands starting with a prefix
+type prefixPolicy string
+
+func (p prefixPolicy) Allowed(command string) bool {
+	return strings.HasPrefix(command, string(p))
+}
+
+// callTool invokes a handler with the given arguments and returns its text
+func callTool(t *testing.T, handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), args map[string]interface{}) (string, bool) {
+	t.Helper()
+	var request mcp.CallToolRequest
+	request.Params.Arguments = args
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	return result.Content[0].(mcp.TextContent).Text, result.IsError
+}
+
+func TestWithExecutorAndPolicy(t *testing.T) {
+	executor := &fakeExecutor{}
+	s, err := NewShellServer(WithExecutor(executor), WithPolicy(prefixPolicy("git ")))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	text, isError := callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "git status"})
+	if isError || !strings.Contains(text, "fake: git status") {
+		t.Errorf("execute_command = %q (error %v), want fake executor output", text, isError)
+	}
+
+	if _, isError := callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "rm -rf /"}); !isError {
+		t.Errorf("execute_command should deny commands rejected by the policy")
+	}
+	if len(executor.commands) != 1 {
+		t.Errorf("executor ran %v, want only 'git status'", executor.commands)
+	}
+
+	text, _ = callTool(t, s.handleListAllowedCommands, nil)
+	if !strings.Contains(text, "custom policy") {
+		t.Errorf("list_allowed_commands = %q, want a custom policy note", text)
+	}
+
+	if got := recentHistory(t, s, 0); len(got) != 1 || got[0].Command != "git status" {
+		t.Errorf("history = %+v, want the executed command", got)
+	}
+}
+
+func TestOptionErrors(t *testing.T) {
+	if _, err := NewShellServer(WithAllowedCommands("ls"), WithSessionBackend("screen")); err == nil {
+		t.Errorf("NewShellServer should reject an unknown session backend")
+	}
+	if _, err := NewShellServer(WithAllowedCommands("ls"), WithEmailDigest("no-port", "a@example.com", "b@example.com", time.Hour)); err == nil {
+		t.Errorf("NewShellServer should reject an invalid digest configuration")
+	}
+
+	s, err := NewShellServer(WithAllowedCommands("ls"), WithSessionBackend(SESSION_BACKEND_TMUX), WithRecordDir("/tmp/casts"), WithLintOnExecute(true))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if s.sessionBackend != SESSION_BACKEND_TMUX || s.recordDir != "/tmp/casts" || !s.lintOnExecute {
+		t.Errorf("options were not applied: backend %q, recordDir %q, lint %v", s.sessionBackend, s.recordDir, s.lintOnExecute)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	if _, err := NewShellServer(WithTimeout(0)); err == nil {
+		t.Errorf("NewShellServer should reject a zero timeout")
+	}
+
+	s, err := NewShellServer(WithAllowedCommands("sleep"), WithTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	execution := s.executeCommand("sleep 5", "bash", nil)
+	if !execution.TimedOut || execution.ExitCode != 124 {
+		t.Errorf("execution = %+v, want a timeout", execution)
+	}
+	if !strings.Contains(execution.Output, "timed out after 200ms") {
+		t.Errorf("output = %q, want the configured timeout", execution.Output)
+	}
+}
+
+func TestWithIdleTimeout(t *testing.T) {
+	if _, err := NewShellServer(WithIdleTimeout(-time.Second)); err == nil {
+		t.Errorf("NewShellServer should reject a negative idle timeout")
+	}
+
+	s, err := NewShellServer(WithAllowedCommands("echo,sleep"), WithTimeout(2*time.Second), WithIdleTimeout(300*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		command   string
+		idle      interface{} // idle_timeout argument, if any
+		want      string
+		errorCode string
+	}{
+		// Output every 0.1s keeps the command alive past the idle timeout
+		{"echo 1; sleep 0.1; echo 2; sleep 0.1; echo 3; sleep 0.1; echo 4; sleep 0.1; echo 5", nil, "Command completed successfully", ""},
+		{"echo start; sleep 5", nil, "no output for 300ms", ERROR_IDLE_TIMEOUT},
+		{"echo start; sleep 5", float64(0.1), "no output for 100ms", ERROR_IDLE_TIMEOUT},
+		// Disabled for the call, so only the overall timeout applies
+		{"sleep 5", float64(0), "timed out after 2s", ERROR_TIMEOUT},
+		{"echo hi", float64(-1), "must not be negative", ERROR_INVALID_ARGUMENT},
+	}
+
+	for _, tt := range tests {
+		args := map[string]interface{}{"command": tt.command}
+		if tt.idle != nil {
+			args["idle_timeout"] = tt.idle
+		}
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		result, _ := s.handleExecuteCommand(context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		var code string
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.errorCode {
+			t.Errorf("%s (idle %v) = %q with code %q, want %q with code %q", tt.command, tt.idle, text, code, tt.want, tt.errorCode)
+		}
+	}
+}
+
+// failingNotifier rejects every event
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(event CommandEvent) error {
+	return fmt.Errorf("unreachable")
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := NewShellServer(WithLogger(log.New(&buf, "", 0)), WithNotifier(failingNotifier{}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	s.emitEvent(EVENT_START, CommandExecution{Command: "ls"}, "")
+	if !strings.Contains(buf.String(), "Failed to deliver start event: unreachable") {
+		t.Errorf("logger got %q, want the delivery failure", buf.String())
+	}
+}
+
+func TestDefaultDeniesEverything(t *testing.T) {
+	s, err := NewShellServer()
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if s.isCommandAllowed("ls") {
+		t.Errorf("a server without allowed commands should deny 'ls'")
+	}
+}
@@ -0,0 +1,202 @@
+package shellserver
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limits on what the stdio server holds for a client that reads slowly
+const (
+	WRITE_TIMEOUT     = 30 * time.Second // How long the client may read nothing before it is given up on
+	STDIO_QUEUE_BYTES = 64 * 1024 * 1024 // Bytes of messages queued for the client
+	STDIO_CHUNK_SIZE  = 64 * 1024        // Bytes written to the client at a time by default
+)
+
+// WithWriteTimeout sets how long the stdio client may go without reading
+// while messages wait for it, after which Serve gives up on it and returns.
+// Zero waits forever.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *ShellServer) error {
+		if timeout < 0 {
+			return fmt.Errorf("write timeout must not be negative, got %s", timeout)
+		}
+		s.writeTimeout = timeout
+		return nil
+	}
+}
+
+// outboundQueue writes messages to the stdio client from a goroutine of its
+// own, so a handler with a large result does not hold up the next request
+// while the client reads it. Messages are written in chunks, and the client
+// counts as stalled only when no chunk was written for the timeout. Once the
+// queue is full, writers wait for room; if the client stalls meanwhile, every
+// write fails from then on instead of blocking forever.
+type outboundQueue struct {
+	out     io.Writer
+	timeout time.Duration // Zero waits forever
+	limit   int           // Bytes queued at most, except for a single larger message
+	chunk   int           // Bytes written at a time
+
+	mutex    sync.Mutex
+	messages [][]byte
+	queued   int
+	progress time.Time // When a chunk was last written, or the queue last empty
+	err      error     // Why every write fails, once the client stalled
+
+	wake  chan struct{} // Tells the writer goroutine there is a message
+	space chan struct{} // Tells a waiting Write a message was written
+	stop  chan struct{} // Closed to stop the writer goroutine
+	done  chan struct{} // Closed when the writer goroutine exits
+}
+
+func newOutboundQueue(out io.Writer, timeout time.Duration, limit int, chunk int) *outboundQueue {
+	q := &outboundQueue{
+		out:      out,
+		timeout:  timeout,
+		limit:    limit,
+		chunk:    chunk,
+		progress: time.Now(),
+		wake:     make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// wakeUp wakes a goroutine waiting on ch, if it is not already woken
+func wakeUp(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Write queues a copy of p for the client, waiting while the queue is full
+func (q *outboundQueue) Write(p []byte) (int, error) {
+	message := append([]byte{}, p...)
+	for {
+		q.mutex.Lock()
+		if q.err != nil {
+			q.mutex.Unlock()
+			return 0, q.err
+		}
+		if q.queued == 0 || q.queued+len(message) <= q.limit {
+			if q.queued == 0 {
+				q.progress = time.Now()
+			}
+			q.messages = append(q.messages, message)
+			q.queued += len(message)
+			q.mutex.Unlock()
+			wakeUp(q.wake)
+			return len(p), nil
+		}
+		stalled := q.stalled()
+		q.mutex.Unlock()
+		if stalled != nil {
+			return 0, stalled
+		}
+
+		var timeout <-chan time.Time
+		if q.timeout > 0 {
+			timeout = time.After(q.timeout)
+		}
+		select {
+		case <-q.space:
+		case <-timeout:
+		case <-q.done:
+		}
+	}
+}
+
+// stalled fails the queue if the client read nothing for the timeout; the
+// caller holds the mutex
+func (q *outboundQueue) stalled() error {
+	if q.err == nil && q.timeout > 0 && q.queued > 0 && time.Since(q.progress) >= q.timeout {
+		q.err = fmt.Errorf("the client read no output for %s; %d bytes of messages were not delivered", q.timeout, q.queued)
+	}
+	return q.err
+}
+
+// run writes the queued messages in order until a write fails
+func (q *outboundQueue) run() {
+	defer close(q.done)
+	for {
+		select {
+		case <-q.wake:
+		case <-q.stop:
+			return
+		}
+		for {
+			q.mutex.Lock()
+			if len(q.messages) == 0 {
+				q.mutex.Unlock()
+				break
+			}
+			message := q.messages[0]
+			q.mutex.Unlock()
+
+			for len(message) > 0 {
+				chunk := message[:min(len(message), q.chunk)]
+				if _, err := q.out.Write(chunk); err != nil {
+					q.mutex.Lock()
+					if q.err == nil {
+						q.err = err
+					}
+					q.mutex.Unlock()
+					wakeUp(q.space)
+					return
+				}
+				message = message[len(chunk):]
+				q.mutex.Lock()
+				q.progress = time.Now()
+				q.mutex.Unlock()
+			}
+
+			q.mutex.Lock()
+			q.queued -= len(q.messages[0])
+			q.messages[0] = nil
+			q.messages = q.messages[1:]
+			q.mutex.Unlock()
+			wakeUp(q.space)
+		}
+	}
+}
+
+// state returns the messages and bytes waiting, how long the client has
+// read nothing while they did, and why writes fail, if they do
+func (q *outboundQueue) state() (int, int, int64, string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var idle int64
+	if q.queued > 0 {
+		idle = time.Since(q.progress).Milliseconds()
+	}
+	var failed string
+	if q.err != nil {
+		failed = q.err.Error()
+	}
+	return len(q.messages), q.queued, idle, failed
+}
+
+// flush waits until every queued message is written, or the client stalls,
+// and stops the writer goroutine
+func (q *outboundQueue) flush() error {
+	for {
+		q.mutex.Lock()
+		empty, err := q.queued == 0, q.stalled()
+		q.mutex.Unlock()
+		if empty || err != nil {
+			close(q.stop)
+			return err
+		}
+		select {
+		case <-q.space:
+		case <-q.done:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
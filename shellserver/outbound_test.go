@@ -0,0 +1,82 @@
+package shellserver
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowWriter accepts one chunk per delay
+type slowWriter struct {
+	mutex sync.Mutex
+	delay time.Duration
+	data  bytes.Buffer
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.data.Write(p)
+}
+
+func TestOutboundQueueDelivers(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay time.Duration
+		sizes []int
+	}{
+		{"small messages", 0, []int{10, 20, 30}},
+		{"chunked message", 0, []int{3*STDIO_CHUNK_SIZE + 5, 1}},
+		{"slow but reading", 20 * time.Millisecond, []int{STDIO_CHUNK_SIZE * 6}},
+	}
+	for _, tt := range tests {
+		out := &slowWriter{delay: tt.delay}
+		// The slow client takes longer than the timeout for the whole
+		// message, but reads a chunk well within it
+		q := newOutboundQueue(out, 80*time.Millisecond, STDIO_CHUNK_SIZE, STDIO_CHUNK_SIZE)
+		var want bytes.Buffer
+		for i, size := range tt.sizes {
+			message := bytes.Repeat([]byte{byte('a' + i)}, size)
+			want.Write(message)
+			if _, err := q.Write(message); err != nil {
+				t.Errorf("%s: Write failed: %v", tt.name, err)
+			}
+		}
+		if err := q.flush(); err != nil {
+			t.Errorf("%s: flush failed: %v", tt.name, err)
+		}
+		if !bytes.Equal(out.data.Bytes(), want.Bytes()) {
+			t.Errorf("%s: client got %d bytes, want the %d bytes written in order", tt.name, out.data.Len(), want.Len())
+		}
+	}
+}
+
+func TestOutboundQueueStalledClient(t *testing.T) {
+	_, out := io.Pipe() // Never read
+	q := newOutboundQueue(out, 50*time.Millisecond, 100, STDIO_CHUNK_SIZE)
+
+	// Writes are queued while there is room, without waiting for the client
+	for i := 0; i < 2; i++ {
+		if _, err := q.Write(bytes.Repeat([]byte("x"), 50)); err != nil {
+			t.Fatalf("write %d to a queue with room failed: %v", i, err)
+		}
+	}
+	start := time.Now()
+	_, err := q.Write([]byte("one too many"))
+	if err == nil || !strings.Contains(err.Error(), "read no output for 50ms") {
+		t.Errorf("write to a full queue of a stalled client = %v, want a stall error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("write to a stalled client took %s", elapsed)
+	}
+	if _, err := q.Write([]byte("later")); err == nil {
+		t.Errorf("write after the client stalled succeeded")
+	}
+	if err := q.flush(); err == nil {
+		t.Errorf("flush to a stalled client succeeded")
+	}
+}
@@ -0,0 +1,713 @@
+package shellserver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MAX_PARSE_DEPTH limits nesting of subshells and substitutions
+const MAX_PARSE_DEPTH = 32
+
+// assignmentPattern matches a leading NAME= variable assignment
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// commandPrefixWords are reserved words after which a command name follows;
+// standalone closing words carry no command
+var commandPrefixWords = map[string]bool{
+	"!": true, "{": true, "}": true, "if": true, "then": true, "else": true, "elif": true,
+	"fi": true, "while": true, "until": true, "do": true, "done": true, "time": true,
+}
+
+// ParseCommandNames returns the name of every command a shell command line
+// would run, including those in pipelines, lists, subshells and command or
+// process substitutions. It fails on anything it cannot resolve to literal
+// command names: unterminated quotes, NUL bytes, invalid UTF-8, non-ASCII
+// names (which could be look-alikes of allowed commands) and names built
+// from expansions such as $CMD.
+func ParseCommandNames(command string) ([]string, error) {
+	commands, err := ParseCommands(command)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.Name
+	}
+	return names, nil
+}
+
+// ParsedCommand is one simple command of a command line
+type ParsedCommand struct {
+	Name      string
+	Args      []string // Arguments with quotes removed; expansions are dropped
+	Redirects []string // Redirection operators and their targets, e.g. ">out.txt"; here-documents and here-strings are left out
+}
+
+// ParseCommands is ParseCommandNames with the arguments and redirection
+// targets of every command
+func ParseCommands(command string) ([]ParsedCommand, error) {
+	if strings.ContainsRune(command, 0) {
+		return nil, fmt.Errorf("command contains a NUL byte")
+	}
+	if !utf8.ValidString(command) {
+		return nil, fmt.Errorf("command is not valid UTF-8")
+	}
+
+	p := &commandParser{input: []rune(command)}
+	if err := p.parseList(0, 0); err != nil {
+		return nil, err
+	}
+	return p.commands, nil
+}
+
+// commandParser walks a command line collecting commands
+type commandParser struct {
+	input    []rune
+	pos      int
+	commands []ParsedCommand
+}
+
+// shellWord accumulates one word of a simple command
+type shellWord struct {
+	text    []byte
+	start   int  // Position of the word in the input
+	started bool // Something, possibly an empty quoted string, was read
+	quoted  bool // Part of the word was quoted
+	dynamic bool // The word depends on an expansion or substitution
+}
+
+// reset empties the word, keeping its buffer for the next word
+func (w *shellWord) reset() {
+	*w = shellWord{text: w.text[:0]}
+}
+
+// writeRune appends a rune to the word's text
+func (w *shellWord) writeRune(r rune) {
+	w.text = utf8.AppendRune(w.text, r)
+}
+
+// simpleCommand tracks the state of the simple command being parsed
+type simpleCommand struct {
+	named        bool      // The command name has been seen
+	expectTarget bool      // The next word is a redirection target
+	heredoc      bool      // The redirection target is a here-document delimiter
+	heredocTabs  bool      // The here-document was opened with <<-
+	redirectOp   string    // Operator of the pending redirection
+	heredocs     []heredoc // Here-documents whose bodies start at the next newline
+	index        int       // Index of the named command in the parser's commands
+	redirects    []string  // Redirection targets seen before the command name
+}
+
+// heredoc is a pending here-document
+type heredoc struct {
+	delimiter string
+	stripTabs bool
+	expand    bool // The body undergoes expansion because the delimiter was unquoted
+}
+
+// peek returns the rune at offset from the current position, or 0 at the end
+func (p *commandParser) peek(offset int) rune {
+	if p.pos+offset < len(p.input) {
+		return p.input[p.pos+offset]
+	}
+	return 0
+}
+
+// parseList parses commands until closer (or the end of input when closer is 0)
+func (p *commandParser) parseList(closer rune, depth int) error {
+	if depth > MAX_PARSE_DEPTH {
+		return fmt.Errorf("command is nested too deeply")
+	}
+
+	var cmd simpleCommand
+	var word shellWord
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch {
+		case c == closer:
+			if err := p.finishWord(&cmd, &word); err != nil {
+				return err
+			}
+			p.pos++
+			return p.endCommand(&cmd, false)
+
+		case c == ' ' || c == '\t':
+			if err := p.finishWord(&cmd, &word); err != nil {
+				return err
+			}
+			p.pos++
+			word.reset()
+
+		case c == '&' && p.peek(1) == '>':
+			if err := p.startRedirect(&cmd, &word); err != nil {
+				return err
+			}
+			word.reset()
+
+		case c == '\n' || c == ';' || c == '&' || c == '|':
+			if err := p.finishWord(&cmd, &word); err != nil {
+				return err
+			}
+			p.pos++
+			word.reset()
+			if err := p.endCommand(&cmd, c == '\n'); err != nil {
+				return err
+			}
+
+		case c == '(':
+			if word.started || cmd.named {
+				return fmt.Errorf("unexpected '(' at position %d", p.pos)
+			}
+			// ((...)) is an arithmetic command, whose variables can
+			// expand into substitutions, not a nested subshell
+			if p.peek(1) == '(' {
+				return fmt.Errorf("arithmetic commands are not supported at position %d", p.pos)
+			}
+			p.pos++
+			if err := p.parseList(')', depth+1); err != nil {
+				return err
+			}
+
+		case c == ')':
+			return fmt.Errorf("unexpected ')' at position %d", p.pos)
+
+		case (c == '<' || c == '>') && p.peek(1) == '(':
+			// Process substitution
+			p.pos += 2
+			if !word.started {
+				word.start = p.pos - 2
+			}
+			word.started, word.dynamic = true, true
+			if err := p.parseList(')', depth+1); err != nil {
+				return err
+			}
+
+		case c == '<' || c == '>':
+			if err := p.startRedirect(&cmd, &word); err != nil {
+				return err
+			}
+			word.reset()
+
+		case c == '#' && !word.started:
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+
+		default:
+			if err := p.parseWordPart(&word, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if closer != 0 {
+		return fmt.Errorf("missing '%c'", closer)
+	}
+	if err := p.finishWord(&cmd, &word); err != nil {
+		return err
+	}
+	if err := p.endCommand(&cmd, false); err != nil {
+		return err
+	}
+	if len(cmd.heredocs) > 0 {
+		return fmt.Errorf("here-document '%s' has no body", cmd.heredocs[0].delimiter)
+	}
+	return nil
+}
+
+// startRedirect consumes a redirection operator; a word of digits before it
+// is a file descriptor rather than an argument
+func (p *commandParser) startRedirect(cmd *simpleCommand, word *shellWord) error {
+	if word.started && !word.quoted && !word.dynamic && strings.Trim(string(word.text), "0123456789") == "" {
+		word.reset()
+	}
+	if err := p.finishWord(cmd, word); err != nil {
+		return err
+	}
+	if cmd.expectTarget {
+		return fmt.Errorf("missing redirection target at position %d", p.pos)
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && strings.ContainsRune("<>&|-", p.input[p.pos]) {
+		p.pos++
+	}
+	op := string(p.input[start:p.pos])
+	if op == ">&-" || op == "<&-" {
+		// Closing a file descriptor takes no target
+		return nil
+	}
+	cmd.expectTarget = true
+	cmd.redirectOp = op
+	cmd.heredoc = op == "<<" || op == "<<-"
+	cmd.heredocTabs = op == "<<-"
+	return nil
+}
+
+// finishWord classifies a completed word as an assignment, a redirection
+// target, the command name or an argument
+func (p *commandParser) finishWord(cmd *simpleCommand, word *shellWord) error {
+	if !word.started {
+		return nil
+	}
+
+	if cmd.expectTarget {
+		cmd.expectTarget = false
+		if cmd.heredoc {
+			if word.dynamic && !word.quoted {
+				return fmt.Errorf("here-document delimiter must be a literal word")
+			}
+			cmd.heredocs = append(cmd.heredocs, heredoc{delimiter: string(word.text), stripTabs: cmd.heredocTabs, expand: !word.quoted})
+		} else if cmd.redirectOp == "<<<" {
+			// A here-string is data, not a file
+		} else if cmd.named {
+			p.commands[cmd.index].Redirects = append(p.commands[cmd.index].Redirects, cmd.redirectOp+string(word.text))
+		} else {
+			cmd.redirects = append(cmd.redirects, cmd.redirectOp+string(word.text))
+		}
+		return nil
+	}
+	if cmd.named {
+		p.commands[cmd.index].Args = append(p.commands[cmd.index].Args, string(word.text))
+		return nil
+	}
+	text := string(word.text)
+
+	raw := string(p.input[word.start:p.pos])
+	if assignmentPattern.MatchString(raw) {
+		return nil
+	}
+	if commandPrefixWords[raw] {
+		return nil
+	}
+	if word.dynamic && raw != "[" && raw != "[[" {
+		return fmt.Errorf("command name '%s' is not a literal word", strings.TrimSpace(raw))
+	}
+	if text == "" {
+		return fmt.Errorf("empty command name")
+	}
+	for _, r := range text {
+		if r >= utf8.RuneSelf || r < ' ' {
+			return fmt.Errorf("command name %q contains a non-ASCII or control character", text)
+		}
+	}
+
+	p.commands = append(p.commands, ParsedCommand{Name: text, Redirects: cmd.redirects})
+	cmd.named, cmd.index, cmd.redirects = true, len(p.commands)-1, nil
+	return nil
+}
+
+// endCommand finishes a simple command at a separator. After a newline any
+// pending here-document bodies are skipped.
+func (p *commandParser) endCommand(cmd *simpleCommand, newline bool) error {
+	if cmd.expectTarget {
+		return fmt.Errorf("missing redirection target at position %d", p.pos)
+	}
+	pending := cmd.heredocs
+	*cmd = simpleCommand{}
+	if !newline {
+		cmd.heredocs = pending
+		return nil
+	}
+
+	for _, doc := range pending {
+		if err := p.skipHeredoc(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipHeredoc consumes a here-document body up to its delimiter line
+func (p *commandParser) skipHeredoc(doc heredoc) error {
+	for p.pos < len(p.input) {
+		end := p.pos
+		for end < len(p.input) && p.input[end] != '\n' {
+			end++
+		}
+		line := string(p.input[p.pos:end])
+		p.pos = end
+		if p.pos < len(p.input) {
+			p.pos++
+		}
+
+		if doc.stripTabs {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == doc.delimiter {
+			return nil
+		}
+		if doc.expand && (strings.Contains(line, "$(") || strings.ContainsRune(line, '`')) {
+			return fmt.Errorf("command substitution in here-document '%s'", doc.delimiter)
+		}
+	}
+	return fmt.Errorf("here-document '%s' is not terminated", doc.delimiter)
+}
+
+// parseWordPart consumes one character, quoted string or expansion of a word
+func (p *commandParser) parseWordPart(word *shellWord, depth int) error {
+	if !word.started {
+		word.start = p.pos
+	}
+	c := p.input[p.pos]
+
+	switch c {
+	case '\\':
+		if p.peek(1) == '\n' {
+			// Line continuation
+			p.pos += 2
+			return nil
+		}
+		if p.pos+1 >= len(p.input) {
+			return fmt.Errorf("command ends with a backslash")
+		}
+		word.writeRune(p.input[p.pos+1])
+		word.started, word.quoted = true, true
+		p.pos += 2
+
+	case '\'':
+		end := p.pos + 1
+		for end < len(p.input) && p.input[end] != '\'' {
+			end++
+		}
+		if end >= len(p.input) {
+			return fmt.Errorf("unterminated single quote")
+		}
+		for _, r := range p.input[p.pos+1 : end] {
+			word.writeRune(r)
+		}
+		word.started, word.quoted = true, true
+		p.pos = end + 1
+
+	case '"':
+		p.pos++
+		word.started, word.quoted = true, true
+		return p.parseDoubleQuoted(word, depth)
+
+	case '$':
+		word.started = true
+		return p.parseDollar(word, depth, false)
+
+	case '`':
+		word.started = true
+		return p.parseBackticks(word, depth)
+
+	case '*', '?', '[', '{', '~':
+		// Glob, brace and tilde expansion can change what the word becomes
+		word.writeRune(c)
+		word.started, word.dynamic = true, true
+		p.pos++
+
+	default:
+		word.writeRune(c)
+		word.started = true
+		p.pos++
+	}
+	return nil
+}
+
+// parseDoubleQuoted consumes a double-quoted string after its opening quote
+func (p *commandParser) parseDoubleQuoted(word *shellWord, depth int) error {
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return nil
+		case '\\':
+			next := p.peek(1)
+			switch next {
+			case '\n':
+				p.pos += 2
+			case '$', '`', '"', '\\':
+				word.writeRune(next)
+				p.pos += 2
+			default:
+				word.writeRune(c)
+				p.pos++
+			}
+		case '$':
+			if err := p.parseDollar(word, depth, true); err != nil {
+				return err
+			}
+		case '`':
+			if err := p.parseBackticks(word, depth); err != nil {
+				return err
+			}
+		default:
+			word.writeRune(c)
+			p.pos++
+		}
+	}
+	return fmt.Errorf("unterminated double quote")
+}
+
+// parseDollar consumes a $ expansion, parsing the commands of any substitution
+func (p *commandParser) parseDollar(word *shellWord, depth int, inDouble bool) error {
+	next := p.peek(1)
+	switch {
+	case next == '(' && p.peek(2) == '(':
+		// Arithmetic expansion; substitutions inside it are not followed
+		end, err := p.matchClosing(p.pos+3, '(', ')')
+		if err != nil {
+			return err
+		}
+		body := string(p.input[p.pos+3 : end])
+		if strings.Contains(body, "$(") || strings.ContainsRune(body, '`') {
+			return fmt.Errorf("command substitution inside arithmetic expansion")
+		}
+		if strings.ContainsAny(body, `'"`) {
+			return fmt.Errorf("quotes inside arithmetic expansion are not supported")
+		}
+		if err := checkArithmetic(body); err != nil {
+			return err
+		}
+		if end+1 >= len(p.input) || p.input[end+1] != ')' {
+			return fmt.Errorf("unterminated arithmetic expansion")
+		}
+		p.pos = end + 2
+
+	case next == '(':
+		p.pos += 2
+		if err := p.parseList(')', depth+1); err != nil {
+			return err
+		}
+
+	case next == '[':
+		// Obsolete $[...] arithmetic
+		return fmt.Errorf("$[...] arithmetic is not supported; use $((...))")
+
+	case next == '{':
+		end, err := p.matchClosing(p.pos+2, '{', '}')
+		if err != nil {
+			return err
+		}
+		body := string(p.input[p.pos+2 : end])
+		if strings.Contains(body, "$(") || strings.ContainsRune(body, '`') || strings.Contains(body, "<(") || strings.Contains(body, ">(") {
+			return fmt.Errorf("command substitution inside parameter expansion")
+		}
+		// Whether quotes inside ${...} hide its closer depends on the
+		// context, e.g. in ${x:-"}"}, so they are refused
+		if strings.ContainsAny(body, `'"`) {
+			return fmt.Errorf("quotes inside parameter expansion are not supported")
+		}
+		if err := checkParameterExpansion(body); err != nil {
+			return err
+		}
+		p.pos = end + 1
+
+	case next == '\'' && !inDouble:
+		// ANSI-C quoting; escapes are not decoded so the word is dynamic
+		end := p.pos + 2
+		for end < len(p.input) && p.input[end] != '\'' {
+			if p.input[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.input) {
+			return fmt.Errorf("unterminated $'...' string")
+		}
+		word.quoted = true
+		p.pos = end + 1
+
+	case next == '"' && !inDouble:
+		// Locale-translated string
+		p.pos += 2
+		word.quoted = true
+		if err := p.parseDoubleQuoted(word, depth); err != nil {
+			return err
+		}
+
+	case next == '_' || next >= 'A' && next <= 'Z' || next >= 'a' && next <= 'z':
+		p.pos++
+		for p.pos < len(p.input) {
+			r := p.input[p.pos]
+			if r != '_' && !(r >= 'A' && r <= 'Z') && !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') {
+				break
+			}
+			p.pos++
+		}
+
+	case next != 0 && strings.ContainsRune("0123456789?#@*!$-", next):
+		p.pos += 2
+
+	default:
+		// A lone $ is literal
+		word.writeRune('$')
+		p.pos++
+		return nil
+	}
+
+	word.dynamic = true
+	return nil
+}
+
+// checkArithmetic refuses an arithmetic body that names a variable. Bash
+// evaluates the value of a variable as an expression, so a value such as
+// a[$(id)] runs a command that the parser never sees; only numeric literals
+// and operators are accepted
+func checkArithmetic(body string) error {
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c >= '0' && c <= '9':
+			// Literals such as 0x1f and 2#101 continue with letters
+			for i+1 < len(body) && isArithmeticLiteral(body[i+1]) {
+				i++
+			}
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '$':
+			return fmt.Errorf("variables inside arithmetic expansion are not supported")
+		case c == '[':
+			return fmt.Errorf("array subscripts inside arithmetic expansion are not supported")
+		case strings.IndexByte(" \t\n+-*/%<>=!&|^~?:,()", c) >= 0:
+		default:
+			return fmt.Errorf("unexpected %q inside arithmetic expansion", c)
+		}
+	}
+	return nil
+}
+
+func isArithmeticLiteral(c byte) bool {
+	return c == '_' || c == '#' || c == '@' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// checkParameterExpansion accepts the body of a ${...} expansion only when
+// it names a parameter, optionally followed by a default, alternative,
+// pattern or case operator. Indirection, array subscripts, substring offsets
+// and @ transformations are refused because bash evaluates them as
+// arithmetic or prompt strings, which can run commands
+func checkParameterExpansion(body string) error {
+	if body == "#" || body == "!" {
+		return nil
+	}
+	name := strings.TrimPrefix(body, "#")
+	if strings.HasPrefix(name, "!") {
+		return fmt.Errorf("indirect parameter expansion is not supported")
+	}
+	n := 0
+	switch {
+	case name == "":
+		return fmt.Errorf("empty parameter expansion")
+	case name[0] == '_' || name[0] >= 'A' && name[0] <= 'Z' || name[0] >= 'a' && name[0] <= 'z':
+		for n < len(name) && (name[n] == '_' || name[n] >= 'A' && name[n] <= 'Z' || name[n] >= 'a' && name[n] <= 'z' || name[n] >= '0' && name[n] <= '9') {
+			n++
+		}
+	case name[0] >= '0' && name[0] <= '9':
+		for n < len(name) && name[n] >= '0' && name[n] <= '9' {
+			n++
+		}
+	case strings.IndexByte("?#@*!$-", name[0]) >= 0:
+		n = 1
+	default:
+		return fmt.Errorf("unsupported parameter expansion ${%s}", body)
+	}
+	rest := name[n:]
+	if rest == "" {
+		return nil
+	}
+	if len(body) != len(name) {
+		// ${#name} takes no operator
+		return fmt.Errorf("unsupported parameter expansion ${%s}", body)
+	}
+	switch {
+	case rest[0] == '[':
+		return fmt.Errorf("array subscripts inside parameter expansion are not supported")
+	case rest[0] == '@':
+		return fmt.Errorf("parameter transformations are not supported")
+	case rest[0] == ':':
+		if len(rest) < 2 || strings.IndexByte("-=+?", rest[1]) < 0 {
+			return fmt.Errorf("substring expansion is not supported")
+		}
+		rest = rest[2:]
+	case strings.IndexByte("-=+?#%/^,", rest[0]) >= 0:
+		rest = rest[1:]
+	default:
+		return fmt.Errorf("unsupported parameter expansion ${%s}", body)
+	}
+	return checkExpansionWord(rest)
+}
+
+// checkExpansionWord checks the expansions nested in the word of a ${...}
+// operator
+func checkExpansionWord(word string) error {
+	for i := 0; i < len(word); i++ {
+		switch {
+		case word[i] == '\\':
+			i++
+		case word[i] != '$' || i+1 >= len(word):
+		case word[i+1] == '[':
+			return fmt.Errorf("$[...] arithmetic is not supported; use $((...))")
+		case word[i+1] == '{':
+			level, end := 0, -1
+			for j := i + 2; j < len(word) && end < 0; j++ {
+				switch word[j] {
+				case '\\':
+					j++
+				case '{':
+					level++
+				case '}':
+					if level == 0 {
+						end = j
+					}
+					level--
+				}
+			}
+			if end < 0 {
+				return fmt.Errorf("missing '}'")
+			}
+			if err := checkParameterExpansion(word[i+2 : end]); err != nil {
+				return err
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+// parseBackticks consumes a `...` command substitution and parses its body
+func (p *commandParser) parseBackticks(word *shellWord, depth int) error {
+	end := p.pos + 1
+	for end < len(p.input) && p.input[end] != '`' {
+		if p.input[end] == '\\' {
+			return fmt.Errorf("escapes inside backtick substitution are not supported; use $(...)")
+		}
+		end++
+	}
+	if end >= len(p.input) {
+		return fmt.Errorf("unterminated backtick substitution")
+	}
+
+	inner := &commandParser{input: p.input[p.pos+1 : end]}
+	if err := inner.parseList(0, depth+1); err != nil {
+		return err
+	}
+	p.commands = append(p.commands, inner.commands...)
+	word.dynamic = true
+	p.pos = end + 1
+	return nil
+}
+
+// matchClosing finds the closer matching an already consumed opener,
+// starting at from and skipping escaped characters. Quotes are not
+// skipped: the caller refuses a body that contains any, so the closer
+// found is the one the shell sees.
+func (p *commandParser) matchClosing(from int, opener, closer rune) (int, error) {
+	level := 0
+	for i := from; i < len(p.input); i++ {
+		switch p.input[i] {
+		case '\\':
+			i++
+		case opener:
+			level++
+		case closer:
+			if level == 0 {
+				return i, nil
+			}
+			level--
+		}
+	}
+	return 0, fmt.Errorf("missing '%c'", closer)
+}
@@ -0,0 +1,387 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	MAX_PINS = 200                 // Most commands a tenant can pin
+	PINS_URI = "shell://pins.json" // URI of the structured list_pinned result
+)
+
+var (
+	// pinNamePattern matches the names commands can be pinned under
+	pinNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+	// pinParamPattern matches the {{param}} placeholders of a pinned command
+	pinParamPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// Pin is a command saved under a name with pin_command
+type Pin struct {
+	Name        string    `json:"name"`
+	Command     string    `json:"command"` // May contain {{param}} placeholders
+	Params      []string  `json:"params,omitempty"`
+	Shell       string    `json:"shell"`
+	Project     string    `json:"project,omitempty"` // Project the command runs for, if any
+	Description string    `json:"description,omitempty"`
+	Created     time.Time `json:"created"`
+	Tenant      string    `json:"tenant,omitempty"` // Tenant that pinned it; other tenants do not see it
+}
+
+// pinStore keeps pinned commands, in a JSON file next to a persistent
+// history or only in memory otherwise. The file is opened once, so pins
+// can still be saved once the server is sandboxed.
+type pinStore struct {
+	mutex sync.Mutex
+	pins  map[string]Pin // By pinKey
+	file  *os.File       // nil to keep pins in memory
+}
+
+// pinKey is the key of a tenant's pin in a pinStore
+func pinKey(tenant string, name string) string {
+	return tenant + "\x00" + name
+}
+
+// newPinStore loads the pins kept alongside history
+func newPinStore(history HistoryStore) (*pinStore, error) {
+	store := &pinStore{pins: make(map[string]Pin)}
+	historyPath := historyFile(history)
+	if historyPath == "" {
+		return store, nil
+	}
+
+	path := strings.TrimSuffix(historyPath, filepath.Ext(historyPath)) + ".pins.json"
+	file, err := openPrivateFile(path, os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if len(data) > 0 {
+		var pins []Pin
+		if err := json.Unmarshal(data, &pins); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("invalid pins file %s: %v", path, err)
+		}
+		for _, pin := range pins {
+			store.pins[pinKey(pin.Tenant, pin.Name)] = pin
+		}
+	}
+	store.file = file
+	return store, nil
+}
+
+// list returns a tenant's pins sorted by name
+func (p *pinStore) list(tenant string) []Pin {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var pins []Pin
+	for _, pin := range p.sorted() {
+		if pin.Tenant == tenant {
+			pins = append(pins, pin)
+		}
+	}
+	return pins
+}
+
+// sorted returns the pins of every tenant sorted by name; the caller holds
+// the lock
+func (p *pinStore) sorted() []Pin {
+	pins := make([]Pin, 0, len(p.pins))
+	for _, pin := range p.pins {
+		pins = append(pins, pin)
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		if pins[i].Name != pins[j].Name {
+			return pins[i].Name < pins[j].Name
+		}
+		return pins[i].Tenant < pins[j].Tenant
+	})
+	return pins
+}
+
+// get returns a tenant's pin named name
+func (p *pinStore) get(tenant string, name string) (Pin, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pin, found := p.pins[pinKey(tenant, name)]
+	return pin, found
+}
+
+// put adds or replaces a pin of its tenant and saves the pins
+func (p *pinStore) put(pin Pin) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key := pinKey(pin.Tenant, pin.Name)
+	previous, replaced := p.pins[key]
+	p.pins[key] = pin
+	if err := p.save(); err != nil {
+		if replaced {
+			p.pins[key] = previous
+		} else {
+			delete(p.pins, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// remove deletes a tenant's pin named name and saves the pins
+func (p *pinStore) remove(tenant string, name string) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key := pinKey(tenant, name)
+	pin, found := p.pins[key]
+	if !found {
+		return false, nil
+	}
+	delete(p.pins, key)
+	if err := p.save(); err != nil {
+		p.pins[key] = pin
+		return false, err
+	}
+	return true, nil
+}
+
+// save rewrites the pins file, if any; the caller holds the lock
+func (p *pinStore) save() error {
+	if p.file == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := p.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := p.file.WriteAt(append(data, '\n'), 0); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+// pinParams returns the names of command's {{param}} placeholders in order
+// of first use
+func pinParams(command string) []string {
+	var params []string
+	for _, match := range pinParamPattern.FindAllStringSubmatch(command, -1) {
+		if !containsString(params, match[1]) {
+			params = append(params, match[1])
+		}
+	}
+	return params
+}
+
+// fillPinParams replaces command's {{param}} placeholders with the quoted
+// values
+func fillPinParams(command string, values map[string]string) string {
+	return pinParamPattern.ReplaceAllStringFunc(command, func(placeholder string) string {
+		name := pinParamPattern.FindStringSubmatch(placeholder)[1]
+		return shellQuote(values[name])
+	})
+}
+
+func (s *ShellServer) handlePinCommand(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	invalid := func(argument string, format string, args ...interface{}) (*mcp.CallToolResult, error) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf(format, args...),
+			Details: map[string]interface{}{"argument": argument},
+		}), nil
+	}
+
+	name, _ := request.Params.Arguments["name"].(string)
+	if !pinNamePattern.MatchString(name) {
+		return invalid("name", "Error: 'name' must be 1 to 64 letters, digits, '.', '_' or '-', starting with a letter or digit")
+	}
+	command, _ := request.Params.Arguments["command"].(string)
+	if strings.TrimSpace(command) == "" {
+		return invalid("command", "Error: 'command' must be a non-empty string")
+	}
+	pin := Pin{
+		Name:    name,
+		Command: command,
+		Params:  pinParams(command),
+		Shell:   DEFAULT_SHELL,
+		Created: s.now(),
+		Tenant:  s.tenant(ctx),
+	}
+	pin.Description, _ = request.Params.Arguments["description"].(string)
+	if shell, _ := request.Params.Arguments["shell"].(string); shell != "" {
+		if shell != "bash" && shell != "zsh" {
+			return invalid("shell", "Error: Unsupported shell '%s'. Only bash and zsh are supported.", shell)
+		}
+		pin.Shell = shell
+	}
+	pin.Project, _ = request.Params.Arguments["project"].(string)
+	if pin.Project != "" {
+		if _, found := s.projects[pin.Project]; !found {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: s.message(MSG_UNKNOWN_PROJECT, pin.Project),
+				Details: map[string]interface{}{"argument": "project", "project": pin.Project, "projects": s.ProjectNames()},
+			}), nil
+		}
+	}
+
+	// Refuse commands the policy would refuse whatever their parameters;
+	// each run is checked again with the values filled in
+	example := make(map[string]string, len(pin.Params))
+	for _, param := range pin.Params {
+		example[param] = param
+	}
+	if denied := s.policyDenial(s.policyFor(pin.Project, clientName(s.clientIdentity(ctx))), fillPinParams(command, example)); denied != nil {
+		return errorResult(refusal(denied)), nil
+	}
+
+	overwrite, _ := request.Params.Arguments["overwrite"].(bool)
+	existing, found := s.pins.get(pin.Tenant, name)
+	if found && !overwrite {
+		return invalid("name", "Error: '%s' is already pinned as '%s'; set overwrite to replace it", name, existing.Command)
+	}
+	if !found && len(s.pins.list(pin.Tenant)) >= MAX_PINS {
+		return invalid("name", "Error: %d commands are pinned, the most allowed; unpin one first", MAX_PINS)
+	}
+	if err := s.pins.put(pin); err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: fmt.Sprintf("Error: Failed to save the pin: %v", err)}), nil
+	}
+
+	text := fmt.Sprintf("Pinned '%s': %s", name, command)
+	if len(pin.Params) > 0 {
+		text += "\nParameters: " + strings.Join(pin.Params, ", ")
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{mcp.NewTextContent(text)}}, nil
+}
+
+func (s *ShellServer) handleListPinned(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	pins := s.pins.list(s.tenant(ctx))
+	if len(pins) == 0 {
+		return &mcp.CallToolResult{Content: []mcp.Content{mcp.NewTextContent(s.message(MSG_PINS_EMPTY))}}, nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Pinned commands (%d):\n", len(pins))
+	for _, pin := range pins {
+		fmt.Fprintf(&text, "- %s: %s", pin.Name, pin.Command)
+		if pin.Project != "" {
+			fmt.Fprintf(&text, " (project %s)", pin.Project)
+		}
+		if pin.Description != "" {
+			fmt.Fprintf(&text, "\n  %s", pin.Description)
+		}
+		text.WriteString("\n")
+	}
+	return jsonResult(text.String(), PINS_URI, pins), nil
+}
+
+func (s *ShellServer) handleRunPinned(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, _ := request.Params.Arguments["name"].(string)
+	pin, found := s.pins.get(s.tenant(ctx), name)
+	if !found {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_PIN_NOT_FOUND, name),
+			Details: map[string]interface{}{"argument": "name", "name": name},
+		}), nil
+	}
+
+	values := map[string]string{}
+	if raw, ok := request.Params.Arguments["params"]; ok && raw != nil {
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: "Error: 'params' must be an object of parameter names to values",
+				Details: map[string]interface{}{"argument": "params"},
+			}), nil
+		}
+		for param, value := range object {
+			if !containsString(pin.Params, param) {
+				return errorResult(ToolError{
+					Code:    ERROR_INVALID_ARGUMENT,
+					Message: fmt.Sprintf("Error: '%s' has no parameter '%s'", name, param),
+					Details: map[string]interface{}{"argument": "params", "params": pin.Params},
+				}), nil
+			}
+			values[param] = fmt.Sprint(value)
+			// A value is data; execute_command must not expand it
+			if placeholderPattern.MatchString(values[param]) {
+				return errorResult(ToolError{
+					Code:    ERROR_INVALID_ARGUMENT,
+					Message: fmt.Sprintf("Error: The value of '%s' contains an {{exec:...}} placeholder, which parameters cannot use", param),
+					Details: map[string]interface{}{"argument": "params", "param": param},
+				}), nil
+			}
+		}
+	}
+	var missing []string
+	for _, param := range pin.Params {
+		if _, ok := values[param]; !ok {
+			missing = append(missing, param)
+		}
+	}
+	if len(missing) > 0 {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: '%s' needs a value for %s", name, strings.Join(missing, ", ")),
+			Details: map[string]interface{}{"argument": "params", "missing": missing},
+		}), nil
+	}
+
+	// Run it as execute_command would, so the authorizer and the policy
+	// check it as it runs
+	arguments := map[string]interface{}{
+		"command": fillPinParams(pin.Command, values),
+		"shell":   pin.Shell,
+	}
+	if pin.Project != "" {
+		arguments["project"] = pin.Project
+	}
+	var execRequest mcp.CallToolRequest
+	execRequest.Params.Meta = request.Params.Meta
+	execRequest.Params.Arguments = arguments
+	return s.authorized("execute_command", s.handleExecuteCommand)(ctx, execRequest)
+}
+
+func (s *ShellServer) handleUnpinCommand(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, _ := request.Params.Arguments["name"].(string)
+	removed, err := s.pins.remove(s.tenant(ctx), name)
+	if err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: fmt.Sprintf("Error: Failed to save the pins: %v", err)}), nil
+	}
+	if !removed {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_PIN_NOT_FOUND, name),
+			Details: map[string]interface{}{"argument": "name", "name": name},
+		}), nil
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{mcp.NewTextContent(fmt.Sprintf("Unpinned '%s'", name))}}, nil
+}
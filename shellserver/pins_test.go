@@ -0,0 +1,114 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestPinParams(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		filled  string
+	}{
+		{"ls", nil, "ls"},
+		{"grep {{ pattern }} {{file}} {{pattern}}", []string{"pattern", "file"}, "grep 'a b' 'it'\\''s' 'a b'"},
+		{"wc -l <<< {{exec:3:output}}", nil, "wc -l <<< {{exec:3:output}}"},
+	}
+
+	values := map[string]string{"pattern": "a b", "file": "it's"}
+	for _, tt := range tests {
+		if got := pinParams(tt.command); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pinParams(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+		if got := fillPinParams(tt.command, values); got != tt.filled {
+			t.Errorf("fillPinParams(%q) = %q, want %q", tt.command, got, tt.filled)
+		}
+	}
+}
+
+func TestPinnedCommands(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo,cat"), WithPolicyRules(&PolicyRules{Deny: []string{"cat /etc/shadow"}}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		tool     string
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{"list_pinned", map[string]interface{}{}, "No commands are pinned", ""},
+		{"pin_command", map[string]interface{}{"name": "greet", "command": "echo hello {{who}}"}, "Pinned 'greet': echo hello {{who}}\nParameters: who", ""},
+		{"pin_command", map[string]interface{}{"name": "greet", "command": "echo hi"}, "already pinned as 'echo hello {{who}}'", ERROR_INVALID_ARGUMENT},
+		{"pin_command", map[string]interface{}{"name": "bad name", "command": "echo hi"}, "'name' must be", ERROR_INVALID_ARGUMENT},
+		{"pin_command", map[string]interface{}{"name": "shadow", "command": "cat /etc/shadow"}, "", ERROR_POLICY_DENIED},
+		{"pin_command", map[string]interface{}{"name": "remove", "command": "rm -rf {{dir}}"}, "Command 'rm' is not in the allowed list", ERROR_POLICY_DENIED},
+		{"pin_command", map[string]interface{}{"name": "show", "command": "cat {{file}}", "description": "Print a file"}, "Pinned 'show'", ""},
+		{"list_pinned", map[string]interface{}{}, "- greet: echo hello {{who}}\n- show: cat {{file}}\n  Print a file\n", ""},
+		{"run_pinned", map[string]interface{}{"name": "greet", "params": map[string]interface{}{"who": "world; rm -rf /"}}, "hello world; rm -rf /\n", ""},
+		{"run_pinned", map[string]interface{}{"name": "greet"}, "'greet' needs a value for who", ERROR_INVALID_ARGUMENT},
+		{"run_pinned", map[string]interface{}{"name": "greet", "params": map[string]interface{}{"who": "x", "extra": "y"}}, "'greet' has no parameter 'extra'", ERROR_INVALID_ARGUMENT},
+		{"run_pinned", map[string]interface{}{"name": "greet", "params": map[string]interface{}{"who": "{{exec:1:output}}"}}, "contains an {{exec:...}} placeholder", ERROR_INVALID_ARGUMENT},
+		{"run_pinned", map[string]interface{}{"name": "show", "params": map[string]interface{}{"file": "/etc/shadow"}}, "", ERROR_POLICY_DENIED},
+		{"run_pinned", map[string]interface{}{"name": "missing"}, "No command is pinned as 'missing'", ERROR_INVALID_ARGUMENT},
+		{"pin_command", map[string]interface{}{"name": "greet", "command": "echo hi", "overwrite": true}, "Pinned 'greet': echo hi", ""},
+		{"unpin_command", map[string]interface{}{"name": "show"}, "Unpinned 'show'", ""},
+		{"unpin_command", map[string]interface{}{"name": "show"}, "No command is pinned as 'show'", ERROR_INVALID_ARGUMENT},
+		{"list_pinned", map[string]interface{}{}, "Pinned commands (1):\n- greet: echo hi\n", ""},
+	}
+
+	handlers := map[string]func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error){
+		"pin_command":   s.handlePinCommand,
+		"list_pinned":   s.handleListPinned,
+		"run_pinned":    s.handleRunPinned,
+		"unpin_command": s.handleUnpinCommand,
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		result, _ := handlers[tt.tool](context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("%s(%v) = %q (code %q), want %q (code %q)", tt.tool, tt.args, text, code, tt.want, tt.wantCode)
+		}
+	}
+}
+
+func TestPinsPersistWithHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	open := func() *ShellServer {
+		history, err := ParseHistoryStore("jsonl:" + path)
+		if err != nil {
+			t.Fatalf("ParseHistoryStore failed: %v", err)
+		}
+		s, err := NewShellServer(WithAllowedCommands("echo"), WithHistoryStore(history))
+		if err != nil {
+			t.Fatalf("NewShellServer failed: %v", err)
+		}
+		return s
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"name": "greet", "command": "echo hello"}
+	open().handlePinCommand(context.Background(), request)
+
+	if info, err := os.Stat(filepath.Join(filepath.Dir(path), "history.pins.json")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("pins file = %v, %v, want a private file next to the history", info, err)
+	}
+	if pin, found := open().pins.get("", "greet"); !found || pin.Command != "echo hello" {
+		t.Errorf("pin after a restart = %+v, %v, want 'echo hello'", pin, found)
+	}
+}
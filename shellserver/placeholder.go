@@ -0,0 +1,65 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MAX_PLACEHOLDER_SIZE caps the output placeholders add to a command. The
+// whole command is one argument to the shell, which Linux limits to 128 KiB.
+const MAX_PLACEHOLDER_SIZE = 64 * 1024
+
+// placeholderPattern matches {{exec:<id>:<part>}}, with the part optional
+var placeholderPattern = regexp.MustCompile(`\{\{\s*exec:([^:}]*)(?::([^}]*))?\s*\}\}`)
+
+// expandPlaceholders replaces each {{exec:<id>:output}} in command with the
+// output of that execution, as one single-quoted word with trailing
+// newlines removed, as $(...) would. It returns command unchanged if it has
+// no placeholders.
+func (s *ShellServer) expandPlaceholders(ctx context.Context, command string) (string, *ToolError) {
+	matches := placeholderPattern.FindAllStringSubmatchIndex(command, -1)
+	if matches == nil {
+		return command, nil
+	}
+
+	var expanded strings.Builder
+	size, last := 0, 0
+	for _, match := range matches {
+		placeholder := command[match[0]:match[1]]
+		id, err := strconv.ParseInt(strings.TrimSpace(command[match[2]:match[3]]), 10, 64)
+		part := "output"
+		if match[4] >= 0 {
+			part = strings.TrimSpace(command[match[4]:match[5]])
+		}
+		if err != nil || id <= 0 || part != "output" {
+			return "", &ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: fmt.Sprintf("Error: '%s' is not a valid placeholder; use {{exec:<id>:output}} with an ID from list_recent_commands", placeholder),
+				Details: map[string]interface{}{"argument": "command", "placeholder": placeholder},
+				Hint:    "Executions record stdout and stderr together, so 'output' is the only part",
+			}
+		}
+		output, toolError := s.executionOutput(ctx, id, "command")
+		if toolError != nil {
+			return "", toolError
+		}
+
+		output = strings.TrimRight(output, "\n")
+		if size += len(output); size > MAX_PLACEHOLDER_SIZE {
+			return "", &ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: fmt.Sprintf("Error: The placeholders expand to more than the %d byte limit.", MAX_PLACEHOLDER_SIZE),
+				Details: map[string]interface{}{"argument": "command", "limitBytes": MAX_PLACEHOLDER_SIZE},
+				Hint:    fmt.Sprintf("Pass large outputs as input instead, with stdin_resource set to %s", executionURI(id)),
+			}
+		}
+		expanded.WriteString(command[last:match[0]])
+		expanded.WriteString(shellQuote(output))
+		last = match[1]
+	}
+	expanded.WriteString(command[last:])
+	return expanded.String(), nil
+}
@@ -0,0 +1,70 @@
+package shellserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	history := newMemoryHistory(MAX_HISTORY_SIZE)
+	history.Add(CommandExecution{ID: 1, Output: "it's here\n\n"})
+	history.Add(CommandExecution{ID: 2, Output: "two"})
+	history.Add(CommandExecution{ID: 3, Output: strings.Repeat("x", MAX_PLACEHOLDER_SIZE/2+1)})
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithHistoryStore(history))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		command  string
+		want     string
+		wantCode string
+	}{
+		{"echo plain", "echo plain", ""},
+		{"echo {{exec:1:output}}", `echo 'it'\''s here'`, ""},
+		{"echo {{exec:2}} {{ exec:1:output }}", `echo 'two' 'it'\''s here'`, ""},
+		{"echo {{exec:3:output}}", "echo '" + strings.Repeat("x", MAX_PLACEHOLDER_SIZE/2+1) + "'", ""},
+		{"echo {{exec:3:output}} {{exec:3:output}}", "expand to more than the 65536 byte limit", ERROR_INVALID_ARGUMENT},
+		{"echo {{exec:9:output}}", "Execution 9 is not in the recent history", ERROR_INVALID_ARGUMENT},
+		{"echo {{exec:2:stdout}}", "'{{exec:2:stdout}}' is not a valid placeholder", ERROR_INVALID_ARGUMENT},
+		{"echo {{exec:two:output}}", "'{{exec:two:output}}' is not a valid placeholder", ERROR_INVALID_ARGUMENT},
+	}
+
+	for _, tt := range tests {
+		got, toolError := s.expandPlaceholders(context.Background(), tt.command)
+		code := ""
+		if toolError != nil {
+			code, got = toolError.Code, toolError.Message
+		}
+		if !strings.Contains(got, tt.want) || code != tt.wantCode {
+			t.Errorf("expandPlaceholders(%q) = %q (code %q), want %q (code %q)", tt.command, got, code, tt.want, tt.wantCode)
+		}
+	}
+}
+
+func TestExecuteCommandPlaceholders(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo,wc"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	var first mcp.CallToolRequest
+	first.Params.Arguments = map[string]interface{}{"command": "echo one two three"}
+	s.handleExecuteCommand(context.Background(), first)
+
+	var second mcp.CallToolRequest
+	second.Params.Arguments = map[string]interface{}{"command": "wc -w <<< {{exec:1:output}}"}
+	result, _ := s.handleExecuteCommand(context.Background(), second)
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.HasPrefix(text, "$ wc -w <<< {{exec:1:output}}\n\n3\n") {
+		t.Errorf("execute_command with a placeholder = %q, want the word count with the placeholder shown", text)
+	}
+
+	history, _ := s.history.Recent(1)
+	if len(history) != 1 || history[0].Command != "wc -w <<< 'one two three'" || history[0].Original != "wc -w <<< {{exec:1:output}}" {
+		t.Errorf("history = %+v, want the expanded command and the original", history)
+	}
+}
@@ -0,0 +1,298 @@
+package shellserver
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// Policy decides which commands may run
+type Policy interface {
+	Allowed(command string) bool
+}
+
+// AllowlistPolicy allows commands whose first word is in a list, unless a
+// deny rule or protected path of a preset refuses them. Admin tools can add
+// commands and deny rules while the server runs.
+type AllowlistPolicy struct {
+	mutex     sync.RWMutex // Guards every field below, which admin tools change while commands are checked
+	commands  []string
+	allowAll  bool
+	deny      [][]string // Command names followed by arguments they may not use
+	denyPaths [][]string // Path components commands may not refer to
+	readOnly  bool       // Refuse redirecting output to files other than /dev/null
+}
+
+// Kinds of rule that refuse an allowed command
+const (
+	DENY_RULE     = "deny_rule"   // A deny rule matched
+	DENY_PATH     = "denied_path" // A protected path was referred to
+	DENY_REDIRECT = "read_only"   // Output was redirected to a file in read-only mode
+)
+
+// NewAllowlistPolicy parses a comma-separated command list, or "*" to allow
+// every command
+func NewAllowlistPolicy(allowedCommands string) *AllowlistPolicy {
+	if allowedCommands == "*" {
+		return &AllowlistPolicy{commands: []string{}, allowAll: true}
+	}
+
+	// Split by comma and trim spaces
+	var cmdList []string
+	for _, cmd := range strings.Split(allowedCommands, ",") {
+		trimmed := strings.TrimSpace(cmd)
+		if trimmed != "" {
+			cmdList = append(cmdList, trimmed)
+		}
+	}
+	return &AllowlistPolicy{commands: cmdList}
+}
+
+// Allowed checks that every command the command line would run, including
+// those after pipes, separators and inside substitutions, is in the allowed
+// list. Command lines that cannot be parsed are refused.
+func (p *AllowlistPolicy) Allowed(command string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.allowAll && len(p.deny) == 0 && len(p.denyPaths) == 0 && !p.readOnly {
+		return true
+	}
+
+	commands, err := ParseCommands(command)
+	if err != nil || len(commands) == 0 {
+		return false
+	}
+
+	for _, cmd := range commands {
+		if !p.allowAll && !p.allows(cmd.Name) {
+			return false
+		}
+	}
+	rule, _ := p.deniedBy(commands)
+	return rule == ""
+}
+
+// deniedBy returns the first deny rule, protected path or redirection that
+// refuses one of the commands, and its DENY_* kind. The caller holds the
+// mutex.
+func (p *AllowlistPolicy) deniedBy(commands []ParsedCommand) (string, string) {
+	for _, cmd := range commands {
+		for _, rule := range p.deny {
+			if matchesDenyRule(cmd, rule) {
+				return strings.Join(rule, " "), DENY_RULE
+			}
+		}
+		for _, redirect := range cmd.Redirects {
+			op, target, duplicate := splitRedirect(redirect)
+			if duplicate {
+				continue
+			}
+			if p.readOnly && strings.ContainsRune(op, '>') && target != "/dev/null" {
+				return redirect, DENY_REDIRECT
+			}
+			for _, protected := range p.denyPaths {
+				if refersToPath(target, protected) {
+					return strings.Join(protected, "/"), DENY_PATH
+				}
+			}
+		}
+		for _, protected := range p.denyPaths {
+			for _, arg := range cmd.Args {
+				if refersToPath(arg, protected) {
+					return strings.Join(protected, "/"), DENY_PATH
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// splitRedirect splits a redirection into its operator and target, and
+// reports whether it duplicates a file descriptor, as in 2>&1
+func splitRedirect(redirect string) (string, string, bool) {
+	op := redirect[:len(redirect)-len(strings.TrimLeft(redirect, "<>&|-"))]
+	target := redirect[len(op):]
+	return op, target, strings.HasSuffix(op, "&") && strings.Trim(target, "0123456789") == ""
+}
+
+// matchesDenyRule reports whether a command has the rule's name and uses
+// every argument of the rule, in any position. A rule argument also matches
+// its --flag=value form, and a short flag such as -f matches a group of
+// short flags containing it, such as -fu.
+func matchesDenyRule(cmd ParsedCommand, rule []string) bool {
+	if cmd.Name != rule[0] {
+		return false
+	}
+	for _, want := range rule[1:] {
+		found := false
+		for _, arg := range cmd.Args {
+			switch {
+			case arg == want, strings.HasPrefix(want, "--") && strings.HasPrefix(arg, want+"="):
+				found = true
+			case len(want) == 2 && want[0] == '-' && want[1] != '-' &&
+				len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && strings.IndexByte(arg[1:], want[1]) >= 0:
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// refersToPath reports whether the components of a protected path appear,
+// in order, among the components of a word. Relative references such as
+// "cd ~/.ssh && cat id_rsa" are not detected.
+func refersToPath(word string, protected []string) bool {
+	components := pathComponents(word)
+	for i := 0; i+len(protected) <= len(components); i++ {
+		matched := true
+		for j, component := range protected {
+			if components[i+j] != component {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// pathComponents splits a path into its cleaned components, ignoring any
+// leading ~ or /
+func pathComponents(word string) []string {
+	if _, value, found := strings.Cut(word, "="); found && strings.HasPrefix(word, "-") {
+		// --file=~/.ssh/id_rsa
+		word = value
+	}
+	word = strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(word, "~")), "/")
+	if word == "" {
+		return nil
+	}
+	return strings.Split(word, "/")
+}
+
+// allows checks if a single command name is in the allowed list. The
+// caller holds the mutex.
+func (p *AllowlistPolicy) allows(name string) bool {
+	for _, allowed := range p.commands {
+		if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Commands returns the allowed commands; it is empty in '*' mode
+func (p *AllowlistPolicy) Commands() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append([]string{}, p.commands...)
+}
+
+// AllowAll reports whether every command is allowed
+func (p *AllowlistPolicy) AllowAll() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.allowAll
+}
+
+// DenyRules returns the deny rules, e.g. "git push --force"
+func (p *AllowlistPolicy) DenyRules() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var rules []string
+	for _, rule := range p.deny {
+		rules = append(rules, strings.Join(rule, " "))
+	}
+	return rules
+}
+
+// ReadOnly reports whether output may only be redirected to /dev/null
+func (p *AllowlistPolicy) ReadOnly() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.readOnly
+}
+
+// DeniedPaths returns the protected paths, e.g. ".ssh"
+func (p *AllowlistPolicy) DeniedPaths() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var paths []string
+	for _, protected := range p.denyPaths {
+		paths = append(paths, strings.Join(protected, "/"))
+	}
+	return paths
+}
+
+// protects returns the protected path a path refers to, e.g. ".ssh", or ""
+// if it refers to none
+func (p *AllowlistPolicy) protects(path string) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for _, protected := range p.denyPaths {
+		if refersToPath(path, protected) {
+			return strings.Join(protected, "/")
+		}
+	}
+	return ""
+}
+
+// deniedRuleReasons and deniedRuleMessages describe each DENY_* kind
+var (
+	deniedRuleReasons = map[string]string{
+		DENY_RULE:     "command matches the deny rule",
+		DENY_PATH:     "command refers to the protected path",
+		DENY_REDIRECT: "read-only policy refuses the output redirection",
+	}
+	deniedRuleMessages = map[string]string{
+		DENY_RULE:     MSG_DENY_RULE,
+		DENY_PATH:     MSG_DENIED_PATH,
+		DENY_REDIRECT: MSG_READ_ONLY,
+	}
+)
+
+// deniedRule explains a refusal by a deny rule, protected path or read-only
+// policy: it returns the rule and its DENY_* kind, or "" for other refusals
+func deniedRule(policy Policy, command string) (string, string) {
+	allowlist, ok := policy.(*AllowlistPolicy)
+	if !ok {
+		return "", ""
+	}
+	commands, err := ParseCommands(command)
+	if err != nil {
+		return "", ""
+	}
+	allowlist.mutex.RLock()
+	defer allowlist.mutex.RUnlock()
+	return allowlist.deniedBy(commands)
+}
+
+// isCommandAllowed checks a command against the server's policy
+func (s *ShellServer) isCommandAllowed(command string) bool {
+	return s.policy.Allowed(command)
+}
+
+// deniedCommand explains why the policy refused a command: it returns the
+// first command name the policy does not allow, or the parse error
+func deniedCommand(policy Policy, command string) (string, error) {
+	names, err := ParseCommandNames(command)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if !policy.Allowed(name) {
+			return name, nil
+		}
+	}
+
+	// A custom policy refused the command line as a whole
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return fields[0], nil
+	}
+	return "", nil
+}
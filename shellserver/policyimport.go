@@ -0,0 +1,303 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Formats accepted by ImportPolicy
+const (
+	IMPORT_FORMAT_AUTO        = "auto"        // Permission JSON if the file is JSON, otherwise sudoers
+	IMPORT_FORMAT_PERMISSIONS = "permissions" // Claude or Cursor style {"permissions": {"allow": [...], "deny": [...]}}
+	IMPORT_FORMAT_SUDOERS     = "sudoers"     // sudoers user specifications and Cmnd_Alias lines
+)
+
+// permissionRule matches a Tool(specifier) permission rule
+var permissionRule = regexp.MustCompile(`^(\w+)(?:\((.*)\))?$`)
+
+// policyImport collects imported rules and what could not be converted
+type policyImport struct {
+	rules    PolicyRules
+	warnings []string
+}
+
+// warn records a rule that was dropped or converted loosely
+func (i *policyImport) warn(format string, args ...interface{}) {
+	i.warnings = append(i.warnings, fmt.Sprintf(format, args...))
+}
+
+// allow adds a command name to the allow list once
+func (i *policyImport) allow(name string) {
+	if !containsString(i.rules.Allow, name) {
+		i.rules.Allow = append(i.rules.Allow, name)
+	}
+}
+
+// ImportPolicy converts another tool's allow and deny configuration into
+// policy rules. This server allows whole commands, so allow rules that
+// restrict arguments are widened to their command name; every such loss is
+// reported in the returned warnings.
+func ImportPolicy(data []byte, format string) (*PolicyRules, []string, error) {
+	if format == IMPORT_FORMAT_AUTO {
+		format = IMPORT_FORMAT_SUDOERS
+		if json.Valid(data) {
+			format = IMPORT_FORMAT_PERMISSIONS
+		}
+	}
+
+	var imported policyImport
+	switch format {
+	case IMPORT_FORMAT_PERMISSIONS:
+		if err := imported.permissions(data); err != nil {
+			return nil, nil, err
+		}
+	case IMPORT_FORMAT_SUDOERS:
+		imported.sudoers(string(data))
+	default:
+		return nil, nil, fmt.Errorf("unknown format '%s': expected %s, %s or %s", format, IMPORT_FORMAT_AUTO, IMPORT_FORMAT_PERMISSIONS, IMPORT_FORMAT_SUDOERS)
+	}
+
+	if len(imported.rules.Allow) == 0 && len(imported.rules.Deny) == 0 && len(imported.rules.DenyPaths) == 0 {
+		return nil, imported.warnings, fmt.Errorf("no rules could be imported")
+	}
+	if err := imported.rules.check(); err != nil {
+		return nil, imported.warnings, err
+	}
+	return &imported.rules, imported.warnings, nil
+}
+
+// permissions imports Claude Code settings ("Bash(npm run test:*)",
+// "Read(./.env)") and Cursor CLI configs ("Shell(ls)")
+func (i *policyImport) permissions(data []byte) error {
+	var settings struct {
+		Permissions struct {
+			Allow []string `json:"allow"`
+			Deny  []string `json:"deny"`
+			Ask   []string `json:"ask"`
+		} `json:"permissions"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("invalid permission JSON: %v", err)
+	}
+
+	for _, rule := range settings.Permissions.Allow {
+		tool, spec := splitPermission(rule)
+		switch {
+		case tool != "Bash" && tool != "Shell":
+			i.warn("allow '%s': only shell command rules are imported", rule)
+		case spec == "":
+			i.warn("allow '%s': allows every command; use --allowed-commands='*'", rule)
+		default:
+			words := strings.Fields(spec)
+			if strings.ContainsAny(words[0], "*?[") {
+				i.warn("allow '%s': command name is a pattern", rule)
+				continue
+			}
+			if len(words) > 1 {
+				i.warn("allow '%s': widened to every use of '%s'", rule, words[0])
+			}
+			i.allow(words[0])
+		}
+	}
+
+	for _, rule := range settings.Permissions.Deny {
+		tool, spec := splitPermission(rule)
+		switch tool {
+		case "Bash", "Shell":
+			if spec == "" {
+				i.warn("deny '%s': denies every command; nothing to import", rule)
+				continue
+			}
+			i.denyCommand(rule, strings.Fields(spec))
+		case "Read", "Edit", "Write", "MultiEdit":
+			i.denyPath(rule, spec)
+		default:
+			i.warn("deny '%s': only shell command and file rules are imported", rule)
+		}
+	}
+
+	for _, rule := range settings.Permissions.Ask {
+		i.warn("ask '%s': not imported; use --approval-required for commands that need a human decision", rule)
+	}
+	return nil
+}
+
+// splitPermission splits "Bash(git diff:*)" into "Bash" and "git diff",
+// dropping the trailing wildcard that makes the rule a prefix match
+func splitPermission(rule string) (string, string) {
+	match := permissionRule.FindStringSubmatch(strings.TrimSpace(rule))
+	if match == nil {
+		return "", rule
+	}
+	spec := strings.TrimSpace(match[2])
+	spec = strings.TrimSuffix(spec, ":*")
+	spec = strings.TrimSpace(strings.TrimSuffix(spec, " *"))
+	return match[1], spec
+}
+
+// denyCommand adds a deny rule for a command name and arguments
+func (i *policyImport) denyCommand(source string, words []string) {
+	var rule []string
+	for _, word := range words {
+		if strings.ContainsAny(word, "*?[") {
+			i.warn("deny '%s': pattern '%s' dropped, so the rule denies more", source, word)
+			continue
+		}
+		rule = append(rule, word)
+	}
+	if len(rule) == 0 || rule[0] != words[0] {
+		i.warn("deny '%s': command name is a pattern", source)
+		return
+	}
+	i.rules.Deny = append(i.rules.Deny, strings.Join(rule, " "))
+}
+
+// denyPath adds a protected path, cut at its first glob component:
+// "./secrets/**" protects "secrets"
+func (i *policyImport) denyPath(source string, pattern string) {
+	var kept []string
+	for _, component := range pathComponents(pattern) {
+		if strings.ContainsAny(component, "*?[") {
+			break
+		}
+		kept = append(kept, component)
+	}
+	if len(kept) == 0 {
+		i.warn("deny '%s': no literal path to protect", source)
+		return
+	}
+	if protected := strings.Join(kept, "/"); !containsString(i.rules.DenyPaths, protected) {
+		i.rules.DenyPaths = append(i.rules.DenyPaths, protected)
+	}
+}
+
+// sudoers imports the commands of user specifications such as
+// "alice ALL=(ALL) NOPASSWD: /bin/ls, !/usr/bin/su", expanding Cmnd_Alias
+// definitions. Users, hosts and run-as users are not part of the policy.
+func (i *policyImport) sudoers(data string) {
+	aliases := map[string][]string{}
+	var specs []string
+	for _, line := range sudoersLines(data) {
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "Cmnd_Alias":
+			for _, definition := range strings.Split(strings.TrimSpace(strings.TrimPrefix(line, "Cmnd_Alias")), ":") {
+				name, commands, found := strings.Cut(definition, "=")
+				if found {
+					aliases[strings.TrimSpace(name)] = splitSudoersList(commands)
+				}
+			}
+		case fields[0] == "Defaults" || strings.HasPrefix(fields[0], "Defaults") ||
+			strings.HasSuffix(fields[0], "_Alias"):
+			continue
+		case strings.Contains(line, "="):
+			specs = append(specs, line)
+		default:
+			i.warn("sudoers '%s': not a user specification", line)
+		}
+	}
+
+	for _, spec := range specs {
+		_, commands, _ := strings.Cut(spec, "=")
+		for _, command := range splitSudoersList(commands) {
+			i.sudoersCommand(command, aliases, 0)
+		}
+	}
+}
+
+// sudoersTag matches run-as users and tags before a command, e.g.
+// "(root) NOPASSWD: "
+var sudoersTag = regexp.MustCompile(`^(\([^)]*\)\s*|[A-Z_]+:\s*)+`)
+
+// sudoersCommand imports one command of a user specification
+func (i *policyImport) sudoersCommand(command string, aliases map[string][]string, depth int) {
+	command = strings.TrimSpace(sudoersTag.ReplaceAllString(strings.TrimSpace(command), ""))
+	negated := strings.HasPrefix(command, "!")
+	command = strings.TrimSpace(strings.TrimPrefix(command, "!"))
+
+	words := strings.Fields(command)
+	switch {
+	case len(words) == 0:
+		return
+	case words[0] == "ALL":
+		if negated {
+			i.warn("sudoers '!ALL': denies every command; nothing to import")
+		} else {
+			i.warn("sudoers 'ALL': allows every command; use --allowed-commands='*'")
+		}
+		return
+	case aliases[words[0]] != nil:
+		if depth > MAX_PARSE_DEPTH {
+			i.warn("sudoers '%s': aliases nested too deeply", words[0])
+			return
+		}
+		for _, aliased := range aliases[words[0]] {
+			if negated {
+				aliased = "!" + strings.TrimPrefix(aliased, "!")
+			}
+			i.sudoersCommand(aliased, aliases, depth+1)
+		}
+		return
+	case !strings.HasPrefix(words[0], "/"):
+		i.warn("sudoers '%s': not a command path or Cmnd_Alias", command)
+		return
+	}
+
+	// sudo matches the full path; this server matches the name as typed
+	words[0] = filepath.Base(words[0])
+	if negated {
+		i.denyCommand("!"+command, words)
+		return
+	}
+	if len(words) > 1 {
+		i.warn("sudoers '%s': widened to every use of '%s'", command, words[0])
+	}
+	i.allow(words[0])
+}
+
+// sudoersLines returns the non-comment lines of a sudoers file with
+// backslash continuations joined
+func sudoersLines(data string) []string {
+	var lines []string
+	var current strings.Builder
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\") + " ")
+			continue
+		}
+		current.WriteString(line)
+		joined := strings.TrimSpace(current.String())
+		current.Reset()
+		// #include and #includedir are directives, not comments, but name
+		// other files that are not imported
+		if joined == "" || strings.HasPrefix(joined, "#") || strings.HasPrefix(joined, "@include") {
+			continue
+		}
+		lines = append(lines, joined)
+	}
+	return lines
+}
+
+// splitSudoersList splits a comma-separated command list, keeping escaped
+// commas in arguments
+func splitSudoersList(list string) []string {
+	var items []string
+	var current strings.Builder
+	for j := 0; j < len(list); j++ {
+		switch {
+		case list[j] == '\\' && j+1 < len(list):
+			current.WriteByte(list[j+1])
+			j++
+		case list[j] == ',':
+			items = append(items, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(list[j])
+		}
+	}
+	return append(items, current.String())
+}
@@ -0,0 +1,86 @@
+package shellserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportPolicy(t *testing.T) {
+	claude := `{"permissions": {
+		"allow": ["Bash(git status)", "Bash(npm run test:*)", "Bash(ls)", "Read(src/**)", "Bash(*)"],
+		"deny": ["Bash(git push:*)", "Bash(rm *)", "Read(./.env)", "Edit(~/.ssh/**)", "Read(**/*.pem)", "WebFetch"],
+		"ask": ["Bash(docker:*)"]
+	}}`
+	cursor := `{"permissions": {"allow": ["Shell(ls)", "Shell(grep)"], "deny": ["Shell(rm)"]}}`
+	sudoers := `# Operators
+Defaults env_reset
+Cmnd_Alias SERVICES = /usr/bin/systemctl restart nginx, \
+	/usr/bin/journalctl
+Cmnd_Alias SHELLS = /bin/sh, /bin/bash
+%ops ALL=(root) NOPASSWD: SERVICES, /bin/ls, !SHELLS, !/usr/bin/passwd root
+alice ALL=(ALL) ALL
+`
+
+	tests := []struct {
+		data      string
+		format    string
+		allow     string // Comma-separated
+		deny      string
+		denyPaths string
+		warnings  int
+		wantErr   string
+	}{
+		{claude, IMPORT_FORMAT_AUTO, "git,npm,ls", "git push,rm", ".env,.ssh", 7, ""},
+		{cursor, IMPORT_FORMAT_PERMISSIONS, "ls,grep", "rm", "", 0, ""},
+		{sudoers, IMPORT_FORMAT_AUTO, "systemctl,journalctl,ls", "sh,bash,passwd root", "", 2, ""},
+		{`{"permissions": {"allow": ["Read(**)"]}}`, IMPORT_FORMAT_AUTO, "", "", "", 1, "no rules"},
+		{sudoers, IMPORT_FORMAT_PERMISSIONS, "", "", "", 0, "invalid permission JSON"},
+		{sudoers, "yaml", "", "", "", 0, "unknown format"},
+	}
+
+	for _, tt := range tests {
+		rules, warnings, err := ImportPolicy([]byte(tt.data), tt.format)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ImportPolicy(%.30q) error = %v, want %q", tt.data, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ImportPolicy(%.30q) failed: %v", tt.data, err)
+			continue
+		}
+		got := []string{strings.Join(rules.Allow, ","), strings.Join(rules.Deny, ","), strings.Join(rules.DenyPaths, ",")}
+		want := []string{tt.allow, tt.deny, tt.denyPaths}
+		if strings.Join(got, "|") != strings.Join(want, "|") || len(warnings) != tt.warnings {
+			t.Errorf("ImportPolicy(%.30q) = %q with %d warnings %q, want %q with %d", tt.data, got, len(warnings), warnings, want, tt.warnings)
+		}
+	}
+}
+
+func TestImportedPolicyApplies(t *testing.T) {
+	rules, _, err := ImportPolicy([]byte(`{"permissions": {"allow": ["Bash(git:*)"], "deny": ["Bash(git push --force:*)", "Read(.env)"]}}`), IMPORT_FORMAT_AUTO)
+	if err != nil {
+		t.Fatalf("ImportPolicy failed: %v", err)
+	}
+	s, err := NewShellServer(WithPolicyRules(rules))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"git status", true},
+		{"git push --force origin", false},
+		{"git show HEAD:.env", true}, // HEAD:.env is one path component
+		{"git diff .env", false},
+		{"ls", false},
+	}
+	for _, tt := range tests {
+		if got := s.isCommandAllowed(tt.command); got != tt.allowed {
+			t.Errorf("isCommandAllowed(%q) = %v, want %v", tt.command, got, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package shellserver
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Policy presets are curated allow, deny, path and environment rules shipped
+// as JSON files in presets/. A file of the same name in the user's preset
+// directory replaces a built-in preset, and may extend it to add rules.
+
+// PRESET_DIR is the user's preset directory, relative to os.UserConfigDir
+const PRESET_DIR = "mcp-unix-shell/presets"
+
+//go:embed presets/*.json
+var builtinPresets embed.FS
+
+// envPattern matches a NAME=value environment entry
+var envPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// PolicyRules are the rules of a preset or policy file
+type PolicyRules struct {
+	Description string     `json:"description,omitempty"`
+	Extends     []string   `json:"extends,omitempty"`   // Presets whose rules come first
+	Allow       []string   `json:"allow,omitempty"`     // Allowed command names
+	Deny        []string   `json:"deny,omitempty"`      // A command name and arguments it may not use, e.g. "git push --force"
+	DenyPaths   []string   `json:"denyPaths,omitempty"` // Paths no argument or redirection may refer to, e.g. ".ssh"
+	ReadOnly    *bool      `json:"readOnly,omitempty"`  // Refuse redirecting output to files
+	Env         []string   `json:"env,omitempty"`       // NAME=value pairs set for every command
+	Locks       []LockRule `json:"locks,omitempty"`     // Groups of commands that run one at a time
+	Tripwires   []Tripwire `json:"tripwires,omitempty"` // Commands that alert the operator, see Tripwire
+}
+
+// PresetNames returns the names of the built-in presets
+func PresetNames() []string {
+	entries, _ := builtinPresets.ReadDir("presets")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadPreset loads a preset by name, or a policy file if spec is a path to
+// a .json file, with the rules of everything it extends merged in
+func LoadPreset(spec string) (*PolicyRules, error) {
+	return resolvePreset(spec, false, map[string]bool{})
+}
+
+// resolvePreset loads spec and merges the presets it extends. A preset that
+// extends its own name extends the built-in preset it replaces.
+func resolvePreset(spec string, builtinOnly bool, seen map[string]bool) (*PolicyRules, error) {
+	key := fmt.Sprintf("%s/%v", spec, builtinOnly)
+	if seen[key] {
+		return nil, fmt.Errorf("preset '%s' extends itself", spec)
+	}
+	seen[key] = true
+	defer delete(seen, key)
+
+	name, data, err := readPreset(spec, builtinOnly)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parsePolicyRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preset '%s': %v", spec, err)
+	}
+
+	merged := &PolicyRules{Description: rules.Description}
+	for _, base := range rules.Extends {
+		baseRules, err := resolvePreset(base, builtinOnly || base == name, seen)
+		if err != nil {
+			return nil, err
+		}
+		merged.merge(baseRules)
+	}
+	merged.merge(rules)
+	return merged, nil
+}
+
+// readPreset reads a policy file, a user preset or a built-in preset, and
+// returns its preset name
+func readPreset(spec string, builtinOnly bool) (string, []byte, error) {
+	if strings.HasSuffix(spec, ".json") || strings.ContainsRune(spec, filepath.Separator) {
+		data, err := os.ReadFile(spec)
+		return strings.TrimSuffix(filepath.Base(spec), ".json"), data, err
+	}
+
+	if !builtinOnly {
+		if dir, err := os.UserConfigDir(); err == nil {
+			data, err := os.ReadFile(filepath.Join(dir, PRESET_DIR, spec+".json"))
+			if err == nil {
+				return spec, data, nil
+			}
+			if !os.IsNotExist(err) {
+				return "", nil, err
+			}
+		}
+	}
+
+	data, err := builtinPresets.ReadFile("presets/" + spec + ".json")
+	if err != nil {
+		return "", nil, fmt.Errorf("unknown preset '%s': expected one of %s, or a .json policy file", spec, strings.Join(PresetNames(), ", "))
+	}
+	return spec, data, nil
+}
+
+// parsePolicyRules decodes and checks policy rules, rejecting unknown fields
+// so a misspelt rule is not silently ignored
+func parsePolicyRules(data []byte) (*PolicyRules, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var rules PolicyRules
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, err
+	}
+	if err := rules.check(); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// check rejects rules that would match nothing or cannot be applied
+func (r *PolicyRules) check() error {
+	for _, name := range r.Allow {
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("allowed command '%s' is not a command name", name)
+		}
+	}
+	for _, rule := range r.Deny {
+		if len(strings.Fields(rule)) == 0 {
+			return fmt.Errorf("empty deny rule")
+		}
+	}
+	for _, protected := range r.DenyPaths {
+		if len(pathComponents(protected)) == 0 {
+			return fmt.Errorf("deny path '%s' names no file", protected)
+		}
+	}
+	for _, entry := range r.Env {
+		if !envPattern.MatchString(entry) {
+			return fmt.Errorf("env entry '%s' is not NAME=value", entry)
+		}
+	}
+	for _, lock := range r.Locks {
+		if err := lock.check(); err != nil {
+			return err
+		}
+	}
+	for _, tripwire := range r.Tripwires {
+		if err := tripwire.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge adds other's rules after r's; other's readOnly, when set, wins
+func (r *PolicyRules) merge(other *PolicyRules) {
+	for _, name := range other.Allow {
+		if !containsString(r.Allow, name) {
+			r.Allow = append(r.Allow, name)
+		}
+	}
+	r.Deny = append(r.Deny, other.Deny...)
+	r.DenyPaths = append(r.DenyPaths, other.DenyPaths...)
+	r.Env = append(r.Env, other.Env...)
+	r.Locks = append(r.Locks, other.Locks...)
+	r.Tripwires = append(r.Tripwires, other.Tripwires...)
+	if other.ReadOnly != nil {
+		r.ReadOnly = other.ReadOnly
+	}
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// addRules extends the policy with a preset's rules. In '*' mode the allow
+// list is ignored, but deny rules still apply.
+func (p *AllowlistPolicy) addRules(rules *PolicyRules) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.allowAll {
+		for _, name := range rules.Allow {
+			if !p.allows(name) {
+				p.commands = append(p.commands, name)
+			}
+		}
+	}
+	for _, rule := range rules.Deny {
+		p.deny = append(p.deny, strings.Fields(rule))
+	}
+	for _, protected := range rules.DenyPaths {
+		p.denyPaths = append(p.denyPaths, pathComponents(protected))
+	}
+	if rules.ReadOnly != nil {
+		p.readOnly = *rules.ReadOnly
+	}
+}
+
+// policySource is a preset or policy file, or rules given directly, that
+// extended the server's allowlist
+type policySource struct {
+	preset string
+	rules  *PolicyRules // When not read from a preset
+}
+
+// WithPreset extends the allowlist from WithAllowedCommands with the rules
+// of a preset or policy file (see LoadPreset). ReloadPolicy reads the file
+// again.
+func WithPreset(spec string) Option {
+	return func(s *ShellServer) error {
+		rules, err := LoadPreset(spec)
+		if err != nil {
+			return err
+		}
+		if err := s.addPolicyRules(rules); err != nil {
+			return err
+		}
+		s.policySources = append(s.policySources, policySource{preset: spec})
+		return nil
+	}
+}
+
+// WithPolicyRules extends the allowlist from WithAllowedCommands with rules
+func WithPolicyRules(rules *PolicyRules) Option {
+	return func(s *ShellServer) error {
+		if err := s.addPolicyRules(rules); err != nil {
+			return err
+		}
+		s.policySources = append(s.policySources, policySource{rules: rules})
+		return nil
+	}
+}
+
+// addPolicyRules extends the server's allowlist with rules
+func (s *ShellServer) addPolicyRules(rules *PolicyRules) error {
+	policy, ok := s.policy.(*AllowlistPolicy)
+	if !ok {
+		return fmt.Errorf("policy rules extend the allowlist and cannot be combined with a custom policy")
+	}
+	if err := rules.check(); err != nil {
+		return err
+	}
+	policy.addRules(rules)
+	s.control.env = append(s.control.env, rules.Env...)
+	s.lockRules = append(s.lockRules, rules.Locks...)
+	for _, rule := range rules.Tripwires {
+		compiled, err := rule.compile()
+		if err != nil {
+			return err
+		}
+		s.tripwires = append(s.tripwires, compiled)
+	}
+	return nil
+}
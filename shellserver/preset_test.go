@@ -0,0 +1,173 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestBuiltinPresets(t *testing.T) {
+	for _, name := range PresetNames() {
+		rules, err := LoadPreset(name)
+		if err != nil {
+			t.Errorf("LoadPreset(%s) failed: %v", name, err)
+			continue
+		}
+		if rules.Description == "" || len(rules.Allow) == 0 {
+			t.Errorf("preset %s has no description or allowed commands", name)
+		}
+	}
+
+	rules, err := LoadPreset("devtools")
+	if err != nil {
+		t.Fatalf("LoadPreset(devtools) failed: %v", err)
+	}
+	if !containsString(rules.Allow, "cat") || !containsString(rules.Allow, "go") || rules.ReadOnly == nil || *rules.ReadOnly {
+		t.Errorf("devtools = %+v, want readonly-inspection's commands and writes allowed", rules)
+	}
+}
+
+func TestPresetPolicy(t *testing.T) {
+	tests := []struct {
+		preset  string
+		command string
+		rule    string // Expected DENY_* kind, or "" if allowed
+	}{
+		{"readonly-inspection", "ls -la /tmp | grep x 2>&1", ""},
+		{"readonly-inspection", "find . -name '*.go' > /dev/null", ""},
+		{"readonly-inspection", "find . -delete", DENY_RULE},
+		{"readonly-inspection", "find . -exec rm {} ;", DENY_RULE},
+		{"readonly-inspection", "sort --output=x in", DENY_RULE},
+		{"readonly-inspection", "cat ~/.ssh/id_rsa", DENY_PATH},
+		{"readonly-inspection", "cat \"$HOME/.ssh/config\"", DENY_PATH},
+		{"readonly-inspection", "grep x /home/me/../me/.aws/credentials", DENY_PATH},
+		{"readonly-inspection", "wc -l < /etc/shadow", DENY_PATH},
+		{"readonly-inspection", "echo hi > notes.txt", DENY_REDIRECT},
+		{"readonly-inspection", "ls &>> log", DENY_REDIRECT},
+		{"devtools", "echo hi > notes.txt", ""},
+		{"devtools", "git push -fu origin main", DENY_RULE},
+		{"git-only", "git push origin main", ""},
+		{"git-only", "git -c core.pager=sh log", DENY_RULE},
+		{"git-only", "git commit -m 'fix config'", ""},
+		{"k8s-readonly", "kubectl get pods -n kube-system | grep dns", ""},
+		{"k8s-readonly", "kubectl -n prod delete pod web", DENY_RULE},
+		{"k8s-readonly", "kubectl config use-context prod", DENY_RULE},
+	}
+
+	for _, tt := range tests {
+		s, err := NewShellServer(WithPreset(tt.preset))
+		if err != nil {
+			t.Fatalf("NewShellServer(%s) failed: %v", tt.preset, err)
+		}
+		_, kind := deniedRule(s.policy, tt.command)
+		if allowed := s.isCommandAllowed(tt.command); kind != tt.rule || allowed != (tt.rule == "") {
+			t.Errorf("%s: %q allowed %v by %q, want rule %q", tt.preset, tt.command, allowed, kind, tt.rule)
+		}
+	}
+}
+
+func TestPresetExtendsAllowlist(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("terraform"), WithPreset("git-only"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if !s.isCommandAllowed("terraform plan && git status") {
+		t.Errorf("--allowed-commands should extend the preset")
+	}
+
+	s, err = NewShellServer(WithAllowedCommands("*"), WithPreset("git-only"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if !s.isCommandAllowed("rm x") || s.isCommandAllowed("git clean -fdx") {
+		t.Errorf("'*' with a preset should allow everything but the deny rules")
+	}
+
+	if _, err := NewShellServer(WithPolicy(NewAllowlistPolicy("ls")), WithPolicyRules(&PolicyRules{Allow: []string{"cat"}})); err != nil {
+		t.Errorf("rules should extend an AllowlistPolicy: %v", err)
+	}
+}
+
+func TestUserPresets(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("HOME", config)
+	dir := filepath.Join(config, PRESET_DIR)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"git-only.json": `{"extends": ["git-only"], "allow": ["gh"], "deny": ["gh repo delete"]}`,
+		"loop-a.json":   `{"extends": ["loop-b"]}`,
+		"loop-b.json":   `{"extends": ["loop-a"]}`,
+		"typo.json":     `{"alow": ["ls"]}`,
+		"env.json":      `{"env": ["not an assignment"]}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policyFile := filepath.Join(t.TempDir(), "team.json")
+	os.WriteFile(policyFile, []byte(`{"extends": ["git-only"], "denyPaths": ["secrets"]}`), 0600)
+
+	tests := []struct {
+		spec    string
+		allow   string // Comma-separated allowed commands
+		wantErr string
+	}{
+		{"git-only", "git,gh", ""},
+		{policyFile, "git,gh", ""},
+		{"loop-a", "", "extends itself"},
+		{"typo", "", "unknown field"},
+		{"env", "", "not NAME=value"},
+		{"missing", "", "unknown preset 'missing'"},
+	}
+
+	for _, tt := range tests {
+		rules, err := LoadPreset(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadPreset(%s) error = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("LoadPreset(%s) failed: %v", tt.spec, err)
+			continue
+		}
+		if got := strings.Join(rules.Allow, ","); got != tt.allow {
+			t.Errorf("LoadPreset(%s) allows %s, want %s", tt.spec, got, tt.allow)
+		}
+	}
+}
+
+func TestExecuteCommandPresetDenial(t *testing.T) {
+	s, err := NewShellServer(WithPreset("readonly-inspection"), WithPolicyRules(&PolicyRules{Env: []string{"PRESET_TEST=on"}}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "find . -delete"}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	toolError := resultError(t, result)
+	if toolError == nil || toolError.Code != ERROR_POLICY_DENIED || toolError.Details["rule"] != DENY_RULE || toolError.Details["match"] != "find -delete" {
+		t.Errorf("denial = %+v, want the find -delete rule", toolError)
+	}
+
+	request.Params.Arguments = map[string]interface{}{"command": "echo $PRESET_TEST"}
+	result, _ = s.handleExecuteCommand(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "on") {
+		t.Errorf("output = %q, want the preset's environment", text)
+	}
+
+	result, _ = s.handleListAllowedCommands(context.Background(), mcp.CallToolRequest{})
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "- find -delete") || !strings.Contains(text, "Protected paths: .ssh") {
+		t.Errorf("list_allowed_commands = %q, want the deny rules and protected paths", text)
+	}
+}
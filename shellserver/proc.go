@@ -0,0 +1,256 @@
+package shellserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Process control for commands, sessions and REPLs started on this machine.
+// The platform code lives in build-tagged files:
+//
+//   - proc_unix.go (linux, darwin and the BSDs) starts every child in its own
+//     process group so a timeout kills the whole tree, and runs it under
+//     WithRunAs credentials.
+//   - proc_other.go is the fallback: only the direct child is killed, and
+//     WithRunAs and WithResourceLimits are refused rather than ignored.
+//
+// Resource limits are set with bash's ulimit before the child is exec'd, so
+// they need no cgo and behave the same on glibc and musl. Memory limits are
+// refused on darwin, which does not enforce RLIMIT_AS. No backend allocates
+// a pty; tmux sessions get theirs from the tmux server, which is outside
+// this layer.
+
+// PROCESS_WAIT_DELAY bounds how long output is read after a killed child
+// exits, in case a descendant that escaped its process group holds a pipe
+const PROCESS_WAIT_DELAY = 2 * time.Second
+
+// STOP_GRACE_PERIOD is how long a command stopped with errStopRequested may
+// take to exit before it is killed
+const STOP_GRACE_PERIOD = time.Second
+
+// errStopRequested is the cancel cause of a command that should be stopped
+// gracefully rather than killed
+var errStopRequested = errors.New("stop requested")
+
+// ResourceLimits are rlimits applied to every process the server starts.
+// Zero fields are left at the server's own limits.
+type ResourceLimits struct {
+	CPUTime   time.Duration // RLIMIT_CPU, rounded up to whole seconds
+	Memory    uint64        // RLIMIT_AS in bytes
+	FileSize  uint64        // RLIMIT_FSIZE in bytes
+	OpenFiles uint64        // RLIMIT_NOFILE
+	Processes uint64        // RLIMIT_NPROC; counts every process of the user
+}
+
+// isZero reports whether no limit is set
+func (l ResourceLimits) isZero() bool {
+	return l == ResourceLimits{}
+}
+
+// ParseResourceLimits parses a --limits spec of comma-separated key=value
+// pairs, e.g. "cpu=30s,memory=2G,fsize=1G,nofile=1024,nproc=256"
+func ParseResourceLimits(spec string) (ResourceLimits, error) {
+	var limits ResourceLimits
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return ResourceLimits{}, fmt.Errorf("expected key=value, got '%s'", pair)
+		}
+
+		var err error
+		switch key {
+		case "cpu":
+			if limits.CPUTime, err = time.ParseDuration(value); err == nil && limits.CPUTime <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "memory":
+			limits.Memory, err = parseByteSize(value)
+		case "fsize":
+			limits.FileSize, err = parseByteSize(value)
+		case "nofile":
+			limits.OpenFiles, err = parseLimitCount(value)
+		case "nproc":
+			limits.Processes, err = parseLimitCount(value)
+		default:
+			return ResourceLimits{}, fmt.Errorf("unknown limit '%s': expected cpu, memory, fsize, nofile or nproc", key)
+		}
+		if err != nil {
+			return ResourceLimits{}, fmt.Errorf("invalid %s limit '%s': %v", key, value, err)
+		}
+	}
+	return limits, nil
+}
+
+// parseByteSize parses a size with an optional K, M or G binary suffix
+func parseByteSize(value string) (uint64, error) {
+	multiplier := uint64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := parseLimitCount(value)
+	if err != nil {
+		return 0, err
+	}
+	// ulimit takes sizes in KiB
+	if size*multiplier < 1<<10 {
+		return 0, fmt.Errorf("must be at least 1K")
+	}
+	return size * multiplier, nil
+}
+
+// parseLimitCount parses a positive count
+func parseLimitCount(value string) (uint64, error) {
+	count, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || count == 0 {
+		return 0, fmt.Errorf("expected a positive number")
+	}
+	return count, nil
+}
+
+// processCredential is the user WithRunAs runs children as
+type processCredential struct {
+	username string
+	uid      uint32
+	gid      uint32
+	groups   []uint32
+}
+
+// lookupCredential resolves a user name or numeric ID. Without cgo, as in
+// static and musl builds, only /etc/passwd and /etc/group are consulted.
+func lookupCredential(name string) (*processCredential, error) {
+	account, err := user.Lookup(name)
+	if err != nil {
+		if account, err = user.LookupId(name); err != nil {
+			return nil, fmt.Errorf("unknown user '%s'", name)
+		}
+	}
+
+	credential := &processCredential{username: account.Username}
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user '%s' has non-numeric uid '%s'", name, account.Uid)
+	}
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user '%s' has non-numeric gid '%s'", name, account.Gid)
+	}
+	credential.uid, credential.gid = uint32(uid), uint32(gid)
+
+	groupIDs, _ := account.GroupIds()
+	for _, id := range groupIDs {
+		if group, err := strconv.ParseUint(id, 10, 32); err == nil {
+			credential.groups = append(credential.groups, uint32(group))
+		}
+	}
+	return credential, nil
+}
+
+// secretEnvNames are environment variables holding the server's own
+// secrets. The command line unsets them at startup; they are also left out
+// of children's environments in case a program embedding the server keeps
+// them set.
+var secretEnvNames = []string{
+	"MCP_SHELL_ADMIN_TOKEN", "MCP_SHELL_AGENT_TOKEN", "MCP_SHELL_WEBHOOK_SECRET",
+	"MCP_SHELL_SMTP_USER", "MCP_SHELL_SMTP_PASSWORD",
+}
+
+// childEnv returns the server's environment without its secrets, followed
+// by extra NAME=value pairs
+func childEnv(extra ...string) []string {
+	var env []string
+	for _, pair := range os.Environ() {
+		name, _, _ := strings.Cut(pair, "=")
+		if !slices.Contains(secretEnvNames, name) {
+			env = append(env, pair)
+		}
+	}
+	return append(env, extra...)
+}
+
+// processControl is how the server starts and stops child processes
+type processControl struct {
+	limits     ResourceLimits
+	credential *processCredential // Nil runs children as the server's user
+	env        []string           // NAME=value pairs added to every child's environment
+	nice       int                // Niceness added to children, with nice(1)
+	umask      string             // File mode creation mask of children; empty keeps the server's
+	noNetwork  bool               // Run children in a network namespace of their own, with unshare(1)
+}
+
+// command returns a command for name with the platform's process attributes
+// and ctx cancellation wired to kill its whole process tree
+func (c processControl) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if c.noNetwork {
+		args = append([]string{"--user", "--map-current-user", "--net", "--", name}, args...)
+		name = "unshare"
+	}
+	if c.nice > 0 {
+		args = append([]string{"-n", strconv.Itoa(c.nice), name}, args...)
+		name = "nice"
+	}
+	if !c.limits.isZero() || c.umask != "" {
+		args = append([]string{"-c", c.limitPrelude() + ` && exec "$0" "$@"`, name}, args...)
+		name = "bash"
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = childEnv(c.env...)
+	setProcessAttrs(cmd, c.credential)
+	cmd.Cancel = func() error {
+		if context.Cause(ctx) == errStopRequested {
+			// Give the tree STOP_GRACE_PERIOD to exit cleanly
+			time.AfterFunc(STOP_GRACE_PERIOD, func() { killProcessTree(cmd) })
+			return terminateProcessTree(cmd)
+		}
+		return killProcessTree(cmd)
+	}
+	cmd.WaitDelay = PROCESS_WAIT_DELAY
+	return cmd
+}
+
+// limitPrelude returns the bash commands that apply the limits and the
+// umask. Both soft and hard limits are set, so commands cannot raise them
+// again; posix mode is turned off because it changes the unit of ulimit -f.
+func (c processControl) limitPrelude() string {
+	commands := []string{"set +o posix"}
+	if c.umask != "" {
+		commands = append(commands, "umask "+c.umask)
+	}
+	if c.limits.CPUTime > 0 {
+		seconds := (c.limits.CPUTime + time.Second - 1) / time.Second
+		commands = append(commands, fmt.Sprintf("ulimit -t %d", seconds))
+	}
+	if c.limits.Memory > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -v %d", c.limits.Memory>>10))
+	}
+	if c.limits.FileSize > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -f %d", c.limits.FileSize>>10))
+	}
+	if c.limits.OpenFiles > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -n %d", c.limits.OpenFiles))
+	}
+	if c.limits.Processes > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -u %d", c.limits.Processes))
+	}
+	return strings.Join(commands, " && ")
+}
@@ -0,0 +1,56 @@
+//go:build !unix
+
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// setProcessAttrs does nothing; there are no process groups to join
+func setProcessAttrs(cmd *exec.Cmd, credential *processCredential) {}
+
+// killProcessTree kills only cmd's own process. Its descendants keep
+// running until they exit or their output pipes close.
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// terminateProcessTree kills cmd's own process; there is no signal to ask
+// it to exit
+func terminateProcessTree(cmd *exec.Cmd) error {
+	return killProcessTree(cmd)
+}
+
+// checkProcessControl refuses credentials, limits, niceness, umasks and
+// network isolation, which cannot be enforced here
+func checkProcessControl(control processControl) error {
+	if control.credential != nil {
+		return fmt.Errorf("running commands as another user is not supported on %s", runtime.GOOS)
+	}
+	if !control.limits.isZero() || control.nice > 0 || control.umask != "" {
+		return fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+	}
+	if control.noNetwork {
+		return fmt.Errorf("commands can only be cut off from the network on Linux")
+	}
+	return nil
+}
+
+// resourceUsage reports CPU time only; memory and I/O are not available
+func resourceUsage(state *os.ProcessState) *ResourceUsage {
+	return &ResourceUsage{
+		UserCPUMs:   state.UserTime().Milliseconds(),
+		SystemCPUMs: state.SystemTime().Milliseconds(),
+	}
+}
+
+// processOwner is unknown without /proc
+func processOwner(pid int) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package shellserver
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResourceLimits(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ResourceLimits
+		wantErr string
+	}{
+		{"", ResourceLimits{}, ""},
+		{"cpu=30s,memory=2G", ResourceLimits{CPUTime: 30 * time.Second, Memory: 2 << 30}, ""},
+		{"fsize=512K, nofile=64 ,nproc=100", ResourceLimits{FileSize: 512 << 10, OpenFiles: 64, Processes: 100}, ""},
+		{"memory=100", ResourceLimits{}, "at least 1K"},
+		{"cpu=-1s", ResourceLimits{}, "must be positive"},
+		{"nofile=0", ResourceLimits{}, "positive number"},
+		{"stack=8M", ResourceLimits{}, "unknown limit 'stack'"},
+		{"cpu", ResourceLimits{}, "expected key=value"},
+	}
+
+	for _, tt := range tests {
+		limits, err := ParseResourceLimits(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseResourceLimits(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || limits != tt.want {
+			t.Errorf("ParseResourceLimits(%q) = %+v, %v, want %+v", tt.spec, limits, err, tt.want)
+		}
+	}
+}
+
+func TestResourceLimitsApplied(t *testing.T) {
+	limits := ResourceLimits{CPUTime: 1500 * time.Millisecond, FileSize: 1 << 20, OpenFiles: 64}
+	s, err := NewShellServer(WithAllowedCommands("*"), WithResourceLimits(limits))
+	if err != nil {
+		t.Skipf("resource limits unavailable: %v", err)
+	}
+
+	for _, shell := range []string{"bash", "zsh"} {
+		if _, err := exec.LookPath(shell); err != nil {
+			continue
+		}
+		execution := s.executor.Execute(context.Background(), "ulimit -t; ulimit -n; ulimit -Hn", shell, nil, nil)
+		if execution.ExitCode != 0 || strings.Fields(execution.Output)[0] != "2" || !strings.Contains(execution.Output, "64\n64") {
+			t.Errorf("%s limits = (%q, %d), want cpu 2 and nofile 64 soft and hard", shell, execution.Output, execution.ExitCode)
+		}
+
+		// A command cannot raise its limits again
+		execution = s.executor.Execute(context.Background(), "ulimit -n 1024", shell, nil, nil)
+		if execution.ExitCode == 0 {
+			t.Errorf("%s raised its open file limit: %q", shell, execution.Output)
+		}
+	}
+}
+
+func TestChildrenDoNotInheritSecrets(t *testing.T) {
+	for _, name := range secretEnvNames {
+		t.Setenv(name, "secret-"+name)
+	}
+	t.Setenv("MCP_SHELL_TEST_VISIBLE", "visible")
+
+	output, err := processControl{}.command(context.Background(), "env").Output()
+	if err != nil {
+		t.Fatalf("env failed: %v", err)
+	}
+	for _, name := range secretEnvNames {
+		if strings.Contains(string(output), "secret-"+name) {
+			t.Errorf("the child inherited %s", name)
+		}
+	}
+	if !strings.Contains(string(output), "MCP_SHELL_TEST_VISIBLE=visible") {
+		t.Errorf("the child lost the rest of the environment: %q", output)
+	}
+}
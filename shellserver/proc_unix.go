@@ -0,0 +1,102 @@
+//go:build unix
+
+package shellserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// setProcessAttrs starts cmd as the leader of a new process group, running
+// as credential if it is set
+func setProcessAttrs(cmd *exec.Cmd, credential *processCredential) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if credential != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    credential.uid,
+			Gid:    credential.gid,
+			Groups: credential.groups,
+		}
+	}
+}
+
+// killProcessTree kills cmd's process group, which holds every descendant
+// that did not start a group of its own
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return cmd.Process.Kill()
+	}
+	return err
+}
+
+// terminateProcessTree asks cmd's process group to exit with SIGTERM
+func terminateProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	if errors.Is(err, syscall.ESRCH) {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	return err
+}
+
+// checkProcessControl refuses settings this platform cannot enforce
+func checkProcessControl(control processControl) error {
+	if control.limits.Memory > 0 && runtime.GOOS == "darwin" {
+		return fmt.Errorf("memory limits are not enforced on darwin")
+	}
+	if control.credential != nil && os.Geteuid() != 0 && uint32(os.Geteuid()) != control.credential.uid {
+		return fmt.Errorf("running commands as '%s' requires the server to run as root", control.credential.username)
+	}
+	if control.noNetwork {
+		if _, err := exec.LookPath("unshare"); err != nil || runtime.GOOS != "linux" {
+			return fmt.Errorf("commands can only be cut off from the network on Linux, with unshare(1)")
+		}
+	}
+	return nil
+}
+
+// resourceUsage converts the rusage of a finished process. Linux and the
+// BSDs report max RSS in KiB and darwin in bytes; only Linux counts block
+// I/O in 512-byte units.
+func resourceUsage(state *os.ProcessState) *ResourceUsage {
+	usage := &ResourceUsage{
+		UserCPUMs:   state.UserTime().Milliseconds(),
+		SystemCPUMs: state.SystemTime().Milliseconds(),
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return usage
+	}
+	usage.MaxRSSBytes = int64(rusage.Maxrss)
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		usage.MaxRSSBytes *= 1024
+	}
+	if runtime.GOOS == "linux" {
+		usage.ReadBytes = int64(rusage.Inblock) * 512
+		usage.WriteBytes = int64(rusage.Oublock) * 512
+	}
+	return usage
+}
+
+// processOwner returns the user ID a process runs as, where /proc shows it
+func processOwner(pid int) (int, bool) {
+	info, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+	if err != nil {
+		return 0, false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Uid), true
+}
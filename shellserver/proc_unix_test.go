@@ -0,0 +1,73 @@
+//go:build unix
+
+package shellserver
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processRunning reports whether pid is alive and not a zombie
+func processRunning(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	return err != nil || !strings.Contains(string(stat), ") Z ")
+}
+
+func TestTimeoutKillsProcessTree(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	execution := localExecutor{}.Execute(ctx, "sleep 30 & echo $!; sleep 30 | cat", "bash", nil, nil)
+	if !execution.TimedOut || time.Since(start) > 5*time.Second {
+		t.Fatalf("Execute() = %+v after %s, want a prompt timeout", execution, time.Since(start))
+	}
+
+	pid, err := strconv.Atoi(strings.Fields(execution.Output)[0])
+	if err != nil {
+		t.Fatalf("no background pid in %q", execution.Output)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("background child %d survived the timeout", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		if _, err := NewShellServer(WithRunAs("nobody")); err == nil {
+			t.Errorf("WithRunAs(nobody) should fail when the server is not root")
+		}
+		t.Skip("running commands as another user requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user")
+	}
+
+	s, err := NewShellServer(WithAllowedCommands("id"), WithRunAs("nobody"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	execution := s.executor.Execute(context.Background(), "id -u", "bash", nil, nil)
+	if strings.TrimSpace(execution.Output) != nobody.Uid {
+		t.Errorf("id -u = %q, want %s", execution.Output, nobody.Uid)
+	}
+
+	if _, err := NewShellServer(WithRunAs("no-such-user-mcp")); err == nil {
+		t.Errorf("WithRunAs should fail for an unknown user")
+	}
+}
@@ -0,0 +1,142 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Progress notification settings
+const (
+	PROGRESS_INTERVAL  = 5 * time.Second // Time between progress notifications
+	PROGRESS_TAIL_SIZE = 200             // Bytes of recent output in a notification
+	PROGRESS_TAIL_LINE = 3               // Lines of recent output in a notification
+)
+
+// progressReporter sends MCP progress notifications for a running command,
+// so clients can show that it is alive and what it last printed
+type progressReporter struct {
+	send  func(params map[string]interface{}) error
+	token mcp.ProgressToken
+	start time.Time
+
+	mutex  sync.Mutex
+	bytes  int64
+	tail   []byte // Last PROGRESS_TAIL_SIZE bytes of output
+	status string // What the command waits for before it can run, if anything
+}
+
+// Write records output as the command produces it
+func (p *progressReporter) Write(data []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.bytes += int64(len(data))
+	p.tail = append(p.tail, data...)
+	if len(p.tail) > PROGRESS_TAIL_SIZE {
+		p.tail = append(p.tail[:0], p.tail[len(p.tail)-PROGRESS_TAIL_SIZE:]...)
+	}
+	return len(data), nil
+}
+
+// notify sends one notification. Progress is the elapsed time in seconds,
+// which increases with every notification as the protocol requires; the
+// total is unknown.
+func (p *progressReporter) notify(now time.Time) error {
+	p.mutex.Lock()
+	bytes, tail, status := p.bytes, progressTail(p.tail), p.status
+	p.mutex.Unlock()
+
+	elapsed := now.Sub(p.start)
+	message := fmt.Sprintf("Running for %s, %s of output", elapsed.Round(time.Second), formatByteSize(bytes))
+	if status != "" {
+		message = status
+	} else if tail != "" {
+		message += "; last output:\n" + tail
+	}
+	return p.send(map[string]interface{}{
+		"progressToken": p.token,
+		"progress":      elapsed.Seconds(),
+		"message":       message,
+		"elapsedMs":     elapsed.Milliseconds(),
+		"outputBytes":   bytes,
+		"tail":          tail,
+	})
+}
+
+// wait reports right away that the command waits, and keeps reporting it
+// instead of the output until wait is called with an empty status
+func (p *progressReporter) wait(status string) {
+	p.mutex.Lock()
+	p.status = status
+	p.mutex.Unlock()
+	if status != "" {
+		p.notify(time.Now())
+	}
+}
+
+// run sends a notification every interval until stop is closed
+func (p *progressReporter) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			// A full notification channel only costs the client an update
+			p.notify(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// progressTail returns the last complete lines of output, at most
+// PROGRESS_TAIL_LINE of them, as valid UTF-8
+func progressTail(data []byte) string {
+	tail := strings.ToValidUTF8(string(data), "")
+	lines := strings.Split(strings.TrimRight(tail, "\n"), "\n")
+	if len(lines) > PROGRESS_TAIL_LINE {
+		lines = lines[len(lines)-PROGRESS_TAIL_LINE:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// startProgress starts progress notifications for a tool call if the client
+// asked for them with a progress token. It returns the writer the output
+// should be copied to and the function that stops the notifications, or a
+// nil writer if there is nothing to report to.
+func (s *ShellServer) startProgress(ctx context.Context, request mcp.CallToolRequest) (*progressReporter, func()) {
+	if request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil || s.progressInterval <= 0 {
+		return nil, func() {}
+	}
+	mcpServer := server.ServerFromContext(ctx)
+	if mcpServer == nil {
+		mcpServer = s.server
+	}
+	reporter := &progressReporter{
+		send: func(params map[string]interface{}) error {
+			return mcpServer.SendNotificationToClient(ctx, "notifications/progress", params)
+		},
+		token: request.Params.Meta.ProgressToken,
+		start: time.Now(),
+	}
+	stop := make(chan struct{})
+	go reporter.run(s.progressInterval, stop)
+	return reporter, func() { close(stop) }
+}
+
+// WithProgressInterval sets how often progress notifications are sent for
+// commands whose caller asked for them; zero disables them
+func WithProgressInterval(interval time.Duration) Option {
+	return func(s *ShellServer) error {
+		if interval < 0 {
+			return fmt.Errorf("progress interval must not be negative")
+		}
+		s.progressInterval = interval
+		return nil
+	}
+}
@@ -0,0 +1,95 @@
+package shellserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// testSession is a client session that collects notifications
+type testSession struct {
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *testSession) Initialize()       {}
+func (s *testSession) Initialized() bool { return true }
+func (s *testSession) SessionID() string { return "test" }
+func (s *testSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func TestProgressTail(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"", ""},
+		{"building\n", "building"},
+		{"1\n2\n3\n4\n5\n", "3\n4\n5"},
+		{"partial line", "partial line"},
+		{"\xffok\n", "ok"},
+	}
+
+	for _, tt := range tests {
+		if got := progressTail([]byte(tt.output)); got != tt.want {
+			t.Errorf("progressTail(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestProgressReporterTail(t *testing.T) {
+	reporter := &progressReporter{}
+	for i := 0; i < 100; i++ {
+		reporter.Write([]byte("line of output\n"))
+	}
+	if reporter.bytes != 1500 || len(reporter.tail) != PROGRESS_TAIL_SIZE {
+		t.Errorf("after 1500 bytes: bytes = %d, tail = %d bytes", reporter.bytes, len(reporter.tail))
+	}
+}
+
+func TestExecuteCommandProgress(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo,sleep"), WithProgressInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	session := &testSession{notifications: make(chan mcp.JSONRPCNotification, 100)}
+	ctx := s.server.WithContext(context.Background(), session)
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "echo started; sleep 0.3"}
+	request.Params.Meta = &struct {
+		ProgressToken mcp.ProgressToken `json:"progressToken,omitempty"`
+	}{ProgressToken: "build-1"}
+	s.handleExecuteCommand(ctx, request)
+
+	// Without a progress token nothing is sent
+	request.Params.Meta = nil
+	s.handleExecuteCommand(ctx, request)
+	count := len(session.notifications)
+	if count == 0 || count > 16 {
+		t.Fatalf("got %d progress notifications over 0.3s at 20ms, want some from the first call only", count)
+	}
+
+	var last float64
+	for i := 0; i < count; i++ {
+		notification := <-session.notifications
+		params := notification.Params.AdditionalFields
+		if notification.Method != "notifications/progress" || params["progressToken"] != "build-1" {
+			t.Errorf("notification = %+v, want progress for build-1", notification)
+		}
+		if progress := params["progress"].(float64); progress <= last {
+			t.Errorf("progress %v after %v, want it to increase", progress, last)
+		} else {
+			last = progress
+		}
+		if i == count-1 && (params["tail"] != "started" || params["outputBytes"] != int64(8)) {
+			t.Errorf("last notification params = %v, want the output so far", params)
+		}
+		if message := params["message"].(string); !strings.HasPrefix(message, "Running for") {
+			t.Errorf("message = %q", message)
+		}
+	}
+}
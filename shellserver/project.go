@@ -0,0 +1,234 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Project is a directory the server runs commands for, e.g. one repository
+// of many, with its own environment and policy
+type Project struct {
+	Name   string          `json:"name"`
+	Dir    string          `json:"dir"`              // Absolute directory commands run in
+	Env    []string        `json:"env,omitempty"`    // NAME=value pairs for the project's commands
+	Policy *PolicyRules    `json:"policy,omitempty"` // Rules added to the server's allowlist for the project
+	Tasks  map[string]Task `json:"tasks,omitempty"`  // Named commands for run_task
+}
+
+// project is a configured project with its resolved policy
+type project struct {
+	Project
+	policy Policy
+}
+
+// LoadProjects reads a --projects file: {"projects": [{"name": "api",
+// "dir": "/src/api", "env": ["GOFLAGS=-mod=vendor"], "policy": {"extends":
+// ["devtools"]}}]}. Unknown fields are rejected.
+func LoadProjects(path string) ([]Project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Projects []Project `json:"projects"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid projects file: %v", err)
+	}
+	return file.Projects, nil
+}
+
+// WithProjects configures the projects execute_command and
+// list_recent_commands accept. Each project's policy extends the server's
+// allowlist as configured by the other options.
+func WithProjects(projects []Project) Option {
+	return func(s *ShellServer) error {
+		for _, config := range projects {
+			if err := s.addProject(config); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addProject checks and adds a configured project
+func (s *ShellServer) addProject(config Project) error {
+	if config.Name == "" || strings.ContainsAny(config.Name, " \t,") {
+		return fmt.Errorf("invalid project name '%s'", config.Name)
+	}
+	if _, found := s.projects[config.Name]; found {
+		return fmt.Errorf("project '%s' is configured twice", config.Name)
+	}
+	if !filepath.IsAbs(config.Dir) {
+		return fmt.Errorf("project '%s': dir must be an absolute path, got '%s'", config.Name, config.Dir)
+	}
+	if info, err := os.Stat(config.Dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("project '%s': dir '%s' is not a directory", config.Name, config.Dir)
+	}
+	for _, entry := range config.Env {
+		if !envPattern.MatchString(entry) {
+			return fmt.Errorf("project '%s': env entry '%s' is not NAME=value", config.Name, entry)
+		}
+	}
+	for name, task := range config.Tasks {
+		if !taskNamePattern.MatchString(name) {
+			return fmt.Errorf("project '%s': invalid task name '%s'", config.Name, name)
+		}
+		if strings.TrimSpace(task.Command) == "" {
+			return fmt.Errorf("project '%s': task '%s' has no command", config.Name, name)
+		}
+	}
+	if config.Policy != nil {
+		if len(config.Policy.Tripwires) > 0 {
+			return fmt.Errorf("project '%s': tripwires cannot be set per project", config.Name)
+		}
+		rules, err := resolveRules(config.Policy)
+		if err != nil {
+			return fmt.Errorf("project '%s': %v", config.Name, err)
+		}
+		config.Policy = rules
+	}
+	config.Dir = filepath.Clean(config.Dir)
+	s.projects[config.Name] = &project{Project: config}
+	return nil
+}
+
+// resolveRules merges the presets rules extend into them, so a missing
+// preset fails at startup
+func resolveRules(rules *PolicyRules) (*PolicyRules, error) {
+	resolved := &PolicyRules{}
+	for _, base := range rules.Extends {
+		baseRules, err := LoadPreset(base)
+		if err != nil {
+			return nil, err
+		}
+		resolved.merge(baseRules)
+	}
+	resolved.merge(rules)
+	if err := resolved.check(); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// resolveProjectPolicies gives each project the server's policy with the
+// project's rules added; it runs after every option
+func (s *ShellServer) resolveProjectPolicies() error {
+	for _, p := range s.projects {
+		p.policy = s.policy
+		if p.Policy == nil {
+			continue
+		}
+		base, ok := s.policy.(*AllowlistPolicy)
+		if !ok {
+			return fmt.Errorf("project '%s': policy rules extend the allowlist and cannot be combined with a custom policy", p.Name)
+		}
+		policy := base.clone()
+		policy.addRules(p.Policy)
+		p.policy = policy
+		p.Env = append(append([]string{}, p.Policy.Env...), p.Env...)
+	}
+	return nil
+}
+
+// ProjectNames returns the configured project names, sorted
+func (s *ShellServer) ProjectNames() []string {
+	var names []string
+	for name := range s.projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// policyFor returns the policy for a project's commands from a client
+func (s *ShellServer) policyFor(name string, client string) Policy {
+	if policies, found := s.clientPolicies[strings.ToLower(client)]; found {
+		if policy, found := policies[name]; found {
+			return policy
+		}
+		return policies[""]
+	}
+	if p, found := s.projects[name]; found {
+		return p.policy
+	}
+	return s.policy
+}
+
+// detectProject names the project of a directory: the configured project
+// containing it, or else the base name of its git repository. It returns
+// nil when neither applies.
+func (s *ShellServer) detectProject(dir string) *project {
+	var best *project
+	for _, p := range s.projects {
+		if rel, err := filepath.Rel(p.Dir, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			if best == nil || len(p.Dir) > len(best.Dir) {
+				best = p
+			}
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	for current := dir; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return &project{Project: Project{Name: filepath.Base(current), Dir: current}, policy: s.policy}
+		}
+		if filepath.Dir(current) == current {
+			return nil
+		}
+	}
+}
+
+// clone returns a copy of the policy that can be extended independently
+func (p *AllowlistPolicy) clone() *AllowlistPolicy {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return &AllowlistPolicy{
+		commands:  append([]string{}, p.commands...),
+		allowAll:  p.allowAll,
+		deny:      append([][]string{}, p.deny...),
+		denyPaths: append([][]string{}, p.denyPaths...),
+		readOnly:  p.readOnly,
+	}
+}
+
+// replace gives the policy the rules of other, which nothing else holds
+func (p *AllowlistPolicy) replace(other *AllowlistPolicy) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.commands, p.allowAll, p.deny, p.denyPaths, p.readOnly = other.commands, other.allowAll, other.deny, other.denyPaths, other.readOnly
+}
+
+// projectList lists the configured projects for a tool description
+func (s *ShellServer) projectList() string {
+	if len(s.projects) == 0 {
+		return ""
+	}
+	return " (one of: " + strings.Join(s.ProjectNames(), ", ") + ")"
+}
+
+// projectHistory returns up to limit of the newest executions the caller
+// may see for a project, newest first, and how many the history holds
+// for it
+func (s *ShellServer) projectHistory(ctx context.Context, name string, limit int) ([]CommandExecution, int, error) {
+	tenant := s.tenant(ctx)
+	return s.filterHistory(limit, func(execution CommandExecution) bool {
+		return execution.Project == name && executionTenant(execution) == tenant
+	})
+}
+
+// shellQuote quotes a word for bash and zsh
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
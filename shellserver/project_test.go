@@ -0,0 +1,127 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestWithProjects(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		projects []Project
+		wantErr  string
+	}{
+		{[]Project{{Name: "api", Dir: dir}, {Name: "web", Dir: dir, Env: []string{"NODE_ENV=test"}}}, ""},
+		{[]Project{{Name: "api", Dir: dir, Policy: &PolicyRules{Extends: []string{"git-only"}}}}, ""},
+		{[]Project{{Name: "api", Dir: dir}, {Name: "api", Dir: dir}}, "configured twice"},
+		{[]Project{{Name: "my api", Dir: dir}}, "invalid project name"},
+		{[]Project{{Name: "api", Dir: "src/api"}}, "absolute path"},
+		{[]Project{{Name: "api", Dir: filepath.Join(dir, "missing")}}, "not a directory"},
+		{[]Project{{Name: "api", Dir: dir, Env: []string{"-x"}}}, "not NAME=value"},
+		{[]Project{{Name: "api", Dir: dir, Policy: &PolicyRules{Extends: []string{"nope"}}}}, "unknown preset"},
+		{[]Project{{Name: "api", Dir: dir, Tasks: map[string]Task{"build:prod": {Command: "make"}}}}, ""},
+		{[]Project{{Name: "api", Dir: dir, Tasks: map[string]Task{"-x": {Command: "make"}}}}, "invalid task name '-x'"},
+		{[]Project{{Name: "api", Dir: dir, Tasks: map[string]Task{"test": {}}}}, "task 'test' has no command"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewShellServer(WithProjects(tt.projects))
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("WithProjects(%+v) error = %v, want %q", tt.projects, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadProjects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	os.WriteFile(path, []byte(`{"projects": [{"name": "api", "dir": "/src/api", "policy": {"allow": ["go"]}}]}`), 0600)
+	projects, err := LoadProjects(path)
+	if err != nil || len(projects) != 1 || projects[0].Name != "api" || projects[0].Policy.Allow[0] != "go" {
+		t.Errorf("LoadProjects = %+v, %v, want the api project", projects, err)
+	}
+
+	os.WriteFile(path, []byte(`{"projects": [{"name": "api", "directory": "/src/api"}]}`), 0600)
+	if _, err := LoadProjects(path); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("LoadProjects error = %v, want the misspelt field", err)
+	}
+}
+
+func TestDetectProject(t *testing.T) {
+	root := t.TempDir()
+	api := filepath.Join(root, "api")
+	repo := filepath.Join(root, "repo")
+	for _, dir := range []string{filepath.Join(api, "cmd"), filepath.Join(repo, ".git"), filepath.Join(repo, "src")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := NewShellServer(WithProjects([]Project{{Name: "all", Dir: root}, {Name: "api", Dir: api}}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{filepath.Join(api, "cmd"), "api"},
+		{api + "-old", "all"},
+		{filepath.Join(repo, "src"), "all"},
+	}
+	for _, tt := range tests {
+		if p := s.detectProject(tt.dir); p == nil || p.Name != tt.want {
+			t.Errorf("detectProject(%s) = %+v, want %s", tt.dir, p, tt.want)
+		}
+	}
+
+	s, _ = NewShellServer()
+	if p := s.detectProject(filepath.Join(repo, "src")); p == nil || p.Name != "repo" {
+		t.Errorf("detectProject = %+v, want the git repository 'repo'", p)
+	}
+}
+
+func TestExecuteCommandProject(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewShellServer(
+		WithAllowedCommands("pwd,echo"),
+		WithProjects([]Project{
+			{Name: "api", Dir: dir, Env: []string{"PROJECT_TEST=api"}, Policy: &PolicyRules{Allow: []string{"ls"}}},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		project string
+		command string
+		want    string
+	}{
+		{"api", "pwd && echo $PROJECT_TEST", dir + "\napi"},
+		{"api", "ls -d .", "."},
+		{"", "ls", "not in the allowed list"},
+		{"web", "pwd", "No project named 'web'"},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"command": tt.command, "project": tt.project}
+		result, _ := s.handleExecuteCommand(context.Background(), request)
+		if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, tt.want) {
+			t.Errorf("%s in project %q = %q, want %q", tt.command, tt.project, text, tt.want)
+		}
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"project": "api"}
+	result, _ := s.handleListRecentCommands(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "showing 2 of 2") || strings.Contains(text, "$ ls\n") {
+		t.Errorf("api history = %q, want only the api project's commands", text)
+	}
+}
@@ -0,0 +1,292 @@
+package shellserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Minimal hosts and slim images often lack the tools agents reach for
+// first, such as rg, fd and jq, so agents fall back to slower commands or
+// fail. Tool provisioning downloads pinned static builds of them once, into
+// a tools directory put in front of the PATH of every command. Every
+// download is checked against its SHA-256 before it is installed, and the
+// installed binaries against theirs at every start.
+
+// Tool provisioning settings
+const (
+	PROVISION_TIMEOUT = 5 * time.Minute   // How long a download may take
+	MAX_TOOL_SIZE     = 200 * 1024 * 1024 // Bytes of a download
+	TOOLS_DIR_NAME    = "mcp-unix-shell/tools"
+)
+
+var (
+	// sha256Pattern matches a SHA-256 in hex
+	sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	// platformPattern matches a GOOS/GOARCH pair such as linux/amd64
+	platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9]+$`)
+)
+
+// ToolManifest pins the tools to provision and where they are installed
+type ToolManifest struct {
+	Dir   string            `json:"dir,omitempty"` // Tools directory; mcp-unix-shell/tools in the user's cache directory if empty
+	Tools []ProvisionedTool `json:"tools"`
+}
+
+// ProvisionedTool is a tool with a pinned download for each platform
+type ProvisionedTool struct {
+	Name      string                  `json:"name"`              // Name of the binary in the tools directory, e.g. "rg"
+	Version   string                  `json:"version,omitempty"` // For the log only
+	Platforms map[string]ToolDownload `json:"platforms"`         // By GOOS/GOARCH, e.g. "linux/amd64"
+}
+
+// ToolDownload is where a tool is downloaded from and what it must hash to
+type ToolDownload struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`         // Of the download, in hex
+	Path   string `json:"path,omitempty"` // The binary within a .tar.gz, .zip or .gz download; empty if the download is the binary
+}
+
+// LoadToolManifest reads a --provision-tools file:
+// {"tools": [{"name": "jq", "version": "1.7.1", "platforms": {"linux/amd64":
+// {"url": "https://github.com/jqlang/jq/releases/download/jq-1.7.1/jq-linux-amd64",
+// "sha256": "<hex>"}}}]}
+func LoadToolManifest(path string) (*ToolManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var manifest ToolManifest
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid tool manifest: %v", err)
+	}
+	return &manifest, nil
+}
+
+// check validates the tools and their downloads
+func (m *ToolManifest) check() error {
+	if len(m.Tools) == 0 {
+		return fmt.Errorf("tool manifest lists no tools")
+	}
+	names := make(map[string]bool)
+	for _, tool := range m.Tools {
+		if tool.Name == "" || tool.Name != filepath.Base(tool.Name) || tool.Name == "." || tool.Name == ".." || strings.HasPrefix(tool.Name, ".") {
+			return fmt.Errorf("invalid tool name '%s'; expected a file name such as 'rg'", tool.Name)
+		}
+		if names[tool.Name] {
+			return fmt.Errorf("tool '%s' is listed twice", tool.Name)
+		}
+		names[tool.Name] = true
+		if len(tool.Platforms) == 0 {
+			return fmt.Errorf("tool '%s' has no platforms", tool.Name)
+		}
+		for platform, download := range tool.Platforms {
+			if !platformPattern.MatchString(platform) {
+				return fmt.Errorf("tool '%s': invalid platform '%s'; expected GOOS/GOARCH such as linux/amd64", tool.Name, platform)
+			}
+			if parsed, err := url.Parse(download.URL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				return fmt.Errorf("tool '%s' on %s: invalid url '%s'", tool.Name, platform, download.URL)
+			}
+			if !sha256Pattern.MatchString(download.SHA256) {
+				return fmt.Errorf("tool '%s' on %s: sha256 must be 64 lowercase hex digits", tool.Name, platform)
+			}
+		}
+	}
+	return nil
+}
+
+// WithToolProvisioning installs the manifest's tools for this platform
+// when the server starts, unless they are installed already, and puts the
+// tools directory in front of the PATH of commands run on this machine.
+// Tools without a download for the platform are skipped.
+func WithToolProvisioning(manifest *ToolManifest) Option {
+	return func(s *ShellServer) error {
+		if err := manifest.check(); err != nil {
+			return err
+		}
+		if manifest.Dir == "" {
+			cache, err := os.UserCacheDir()
+			if err != nil {
+				return fmt.Errorf("no tools directory given and no cache directory: %v", err)
+			}
+			manifest.Dir = filepath.Join(cache, TOOLS_DIR_NAME)
+		}
+		s.toolManifest = manifest
+		return nil
+	}
+}
+
+// provisionTools installs the tools that are missing or changed and adds
+// the tools directory to the commands' PATH
+func (s *ShellServer) provisionTools() error {
+	dir := s.toolManifest.Dir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	client := &http.Client{Timeout: PROVISION_TIMEOUT}
+	for _, tool := range s.toolManifest.Tools {
+		download, found := tool.Platforms[platform]
+		if !found {
+			s.logger.Printf("Tool '%s' has no download for %s; skipped", tool.Name, platform)
+			continue
+		}
+		installed, err := installTool(client, dir, tool.Name, download)
+		if err != nil {
+			return fmt.Errorf("tool '%s': %v", tool.Name, err)
+		}
+		if installed {
+			label := tool.Name
+			if tool.Version != "" {
+				label += " " + tool.Version
+			}
+			s.logger.Printf("Provisioned %s from %s", label, download.URL)
+		}
+	}
+
+	// The last PATH given to commands wins, so the tools go in front of it
+	searchPath := os.Getenv("PATH")
+	for _, entry := range s.control.env {
+		if value, found := strings.CutPrefix(entry, "PATH="); found {
+			searchPath = value
+		}
+	}
+	s.control.env = append(s.control.env, "PATH="+dir+string(os.PathListSeparator)+searchPath)
+	return nil
+}
+
+// installTool downloads a tool into dir, verifies it and extracts its
+// binary, unless the binary installed from the same download is there
+// unchanged. It reports whether it installed the tool.
+func installTool(client *http.Client, dir string, name string, download ToolDownload) (bool, error) {
+	target := filepath.Join(dir, name)
+	stamp := filepath.Join(dir, "."+name+".sha256")
+
+	// The stamp holds the hashes of the download and of the binary
+	if recorded, err := os.ReadFile(stamp); err == nil {
+		downloadHash, binaryHash, _ := strings.Cut(strings.TrimSpace(string(recorded)), " ")
+		if current, err := fileSHA256(target); err == nil && downloadHash == download.SHA256 && current == binaryHash {
+			return false, nil
+		}
+	}
+
+	archive, err := os.CreateTemp(dir, "."+name+"-download-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	if err := fetchVerified(client, download, archive); err != nil {
+		return false, err
+	}
+
+	binary, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(binary.Name())
+	defer binary.Close()
+	if err := extractTool(archive.Name(), download.Path, binary); err != nil {
+		return false, err
+	}
+	if err := binary.Close(); err != nil {
+		return false, err
+	}
+	binaryHash, err := fileSHA256(binary.Name())
+	if err != nil {
+		return false, err
+	}
+	if err := os.Chmod(binary.Name(), 0o755); err != nil {
+		return false, err
+	}
+	if err := os.Rename(binary.Name(), target); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(stamp, []byte(download.SHA256+" "+binaryHash+"\n"), 0o644)
+}
+
+// fetchVerified downloads a tool into file and checks its SHA-256
+func fetchVerified(client *http.Client, download ToolDownload, file *os.File) error {
+	resp, err := client.Get(download.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s returned %s", download.URL, resp.Status)
+	}
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(resp.Body, MAX_TOOL_SIZE+1))
+	if err != nil {
+		return fmt.Errorf("downloading %s: %v", download.URL, err)
+	}
+	if written > MAX_TOOL_SIZE {
+		return fmt.Errorf("%s is larger than %d bytes", download.URL, MAX_TOOL_SIZE)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != download.SHA256 {
+		return fmt.Errorf("%s has SHA-256 %s, not the pinned %s; it was not installed", download.URL, sum, download.SHA256)
+	}
+	return nil
+}
+
+// extractTool copies the binary at member of an archive, or the download
+// itself if member is empty, to binary
+func extractTool(archive string, member string, binary *os.File) error {
+	if member == "" {
+		source, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		_, err = io.Copy(binary, source)
+		return err
+	}
+
+	found := false
+	want := path.Clean(strings.TrimPrefix(member, "./"))
+	_, err := walkArchive(archive, func(entry ArchiveEntry, content io.Reader) error {
+		if entry.Type != "file" || path.Clean(strings.TrimPrefix(entry.Name, "./")) != want {
+			return nil
+		}
+		found = true
+		if _, err := io.Copy(binary, content); err != nil {
+			return err
+		}
+		return errArchiveStop
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("the download has no file '%s'", member)
+	}
+	return nil
+}
+
+// fileSHA256 returns the SHA-256 of a file in hex
+func fileSHA256(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
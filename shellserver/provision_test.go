@@ -0,0 +1,149 @@
+package shellserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestToolManifestCheck(t *testing.T) {
+	hash := strings.Repeat("a", 64)
+	download := map[string]ToolDownload{"linux/amd64": {URL: "https://example.com/jq", SHA256: hash}}
+	tests := []struct {
+		tools   []ProvisionedTool
+		wantErr string
+	}{
+		{[]ProvisionedTool{{Name: "jq", Platforms: download}}, ""},
+		{nil, "lists no tools"},
+		{[]ProvisionedTool{{Name: "../jq", Platforms: download}}, "invalid tool name"},
+		{[]ProvisionedTool{{Name: ".jq", Platforms: download}}, "invalid tool name"},
+		{[]ProvisionedTool{{Name: "jq", Platforms: download}, {Name: "jq", Platforms: download}}, "listed twice"},
+		{[]ProvisionedTool{{Name: "jq"}}, "has no platforms"},
+		{[]ProvisionedTool{{Name: "jq", Platforms: map[string]ToolDownload{"linux": {URL: "https://example.com/jq", SHA256: hash}}}}, "invalid platform"},
+		{[]ProvisionedTool{{Name: "jq", Platforms: map[string]ToolDownload{"linux/amd64": {URL: "ftp://example.com/jq", SHA256: hash}}}}, "invalid url"},
+		{[]ProvisionedTool{{Name: "jq", Platforms: map[string]ToolDownload{"linux/amd64": {URL: "https://example.com/jq", SHA256: "abc"}}}}, "sha256 must be"},
+	}
+
+	for _, tt := range tests {
+		err := (&ToolManifest{Tools: tt.tools}).check()
+		if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("check(%+v) error = %v, want %q", tt.tools, err, tt.wantErr)
+		}
+	}
+}
+
+// toolArchive returns a .tar.gz holding one executable file
+func toolArchive(t *testing.T, name string, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	compressed := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(compressed)
+	if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	archive.Write([]byte(content))
+	archive.Close()
+	compressed.Close()
+	return buf.Bytes()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestProvisionTools(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the tools are shell scripts")
+	}
+	jq := []byte("#!/bin/sh\necho jq stub\n")
+	fd := toolArchive(t, "./fd-v10.2.0/fd", "#!/bin/sh\necho fd stub\n")
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		switch r.URL.Path {
+		case "/jq":
+			w.Write(jq)
+		case "/fd.tar.gz":
+			w.Write(fd)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	dir := t.TempDir()
+	manifest := func(tools ...ProvisionedTool) *ToolManifest {
+		return &ToolManifest{Dir: dir, Tools: tools}
+	}
+	jqTool := ProvisionedTool{Name: "jq", Platforms: map[string]ToolDownload{platform: {URL: server.URL + "/jq", SHA256: sha256Hex(jq)}}}
+	fdTool := ProvisionedTool{Name: "fd", Platforms: map[string]ToolDownload{platform: {URL: server.URL + "/fd.tar.gz", SHA256: sha256Hex(fd), Path: "fd-v10.2.0/fd"}}}
+	otherTool := ProvisionedTool{Name: "rg", Platforms: map[string]ToolDownload{"plan9/mips": {URL: server.URL + "/rg", SHA256: strings.Repeat("0", 64)}}}
+
+	var logs bytes.Buffer
+	s, err := NewShellServer(WithAllowedCommands("jq,fd"), WithToolProvisioning(manifest(jqTool, fdTool, otherTool)), WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if got := downloads.Load(); got != 2 {
+		t.Errorf("provisioning made %d downloads, want 2", got)
+	}
+	if !strings.Contains(logs.String(), "Tool 'rg' has no download for "+platform) {
+		t.Errorf("log = %q, want the skipped tool", logs.String())
+	}
+	for _, command := range []string{"jq", "fd"} {
+		if text, _ := callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": command}); !strings.Contains(text, command+" stub") {
+			t.Errorf("execute_command(%q) = %q, want the provisioned tool to run", command, text)
+		}
+	}
+
+	// Installed tools are not downloaded again, changed ones are
+	if _, err := NewShellServer(WithToolProvisioning(manifest(jqTool, fdTool))); err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if got := downloads.Load(); got != 2 {
+		t.Errorf("provisioning installed tools made %d downloads, want none", got-2)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "jq"), []byte("#!/bin/sh\necho changed\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewShellServer(WithToolProvisioning(manifest(jqTool, fdTool))); err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if got := downloads.Load(); got != 3 {
+		t.Errorf("provisioning a changed tool made %d downloads, want 1", got-2)
+	}
+	if installed, _ := os.ReadFile(filepath.Join(dir, "jq")); !bytes.Equal(installed, jq) {
+		t.Errorf("changed jq was not reinstalled: %q", installed)
+	}
+
+	tests := []struct {
+		tool    ProvisionedTool
+		wantErr string
+	}{
+		{ProvisionedTool{Name: "bad", Platforms: map[string]ToolDownload{platform: {URL: server.URL + "/jq", SHA256: strings.Repeat("0", 64)}}}, "not the pinned"},
+		{ProvisionedTool{Name: "gone", Platforms: map[string]ToolDownload{platform: {URL: server.URL + "/gone", SHA256: strings.Repeat("0", 64)}}}, "404"},
+		{ProvisionedTool{Name: "fd2", Platforms: map[string]ToolDownload{platform: {URL: server.URL + "/fd.tar.gz", SHA256: sha256Hex(fd), Path: "fd"}}}, "has no file 'fd'"},
+	}
+	for _, tt := range tests {
+		_, err := NewShellServer(WithToolProvisioning(manifest(tt.tool)))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("provisioning %s: error = %v, want %q", tt.tool.Name, err, tt.wantErr)
+		}
+		if _, err := os.Stat(filepath.Join(dir, tt.tool.Name)); err == nil {
+			t.Errorf("%s was installed despite the error", tt.tool.Name)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Priorities of tool calls
+const (
+	PRIORITY_INTERACTIVE = "interactive" // Someone waits for the result; the default
+	PRIORITY_BATCH       = "batch"       // Long jobs such as test runs and builds
+)
+
+// QUEUE_INTERACTIVE_BURST is how many interactive calls may start ahead of
+// a queued batch call before it gets the next free slot
+const QUEUE_INTERACTIVE_BURST = 4
+
+// queuedCall is a command waiting for a slot
+type queuedCall struct {
+	batch bool
+	ready chan struct{} // Closed when the call is given a slot
+}
+
+// executionQueue limits how many commands run at once. Interactive calls
+// are started before queued batch calls, but every QUEUE_INTERACTIVE_BURST
+// interactive calls a waiting batch call gets its turn, and batch calls
+// leave one slot free for interactive ones when there are several.
+type executionQueue struct {
+	mutex        sync.Mutex
+	slots        int
+	running      int
+	runningBatch int
+	interactive  []*queuedCall
+	batch        []*queuedCall
+	burst        int // Interactive calls started in a row while batch calls waited
+}
+
+// batchSlots is how many slots batch calls may hold at once
+func (q *executionQueue) batchSlots() int {
+	if q.slots > 1 {
+		return q.slots - 1
+	}
+	return 1
+}
+
+// acquire waits for a slot, calling waiting once with how many calls of the
+// lane are queued ahead if it has to wait. It fails if ctx ends first.
+func (q *executionQueue) acquire(ctx context.Context, batch bool, waiting func(ahead int)) error {
+	call := &queuedCall{batch: batch, ready: make(chan struct{})}
+	q.mutex.Lock()
+	ahead := len(q.interactive)
+	if batch {
+		q.batch = append(q.batch, call)
+		ahead = len(q.batch) - 1
+	} else {
+		q.interactive = append(q.interactive, call)
+	}
+	q.dispatch()
+	q.mutex.Unlock()
+
+	select {
+	case <-call.ready:
+		return nil
+	default:
+	}
+	waiting(ahead)
+	select {
+	case <-call.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	select {
+	case <-call.ready:
+		// Given a slot as ctx ended; pass it on
+		q.releaseLocked(batch)
+	default:
+		q.interactive = removeCall(q.interactive, call)
+		q.batch = removeCall(q.batch, call)
+	}
+	return ctx.Err()
+}
+
+// release frees the slot of a call
+func (q *executionQueue) release(batch bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.releaseLocked(batch)
+}
+
+// releaseLocked frees a slot; the caller holds the mutex
+func (q *executionQueue) releaseLocked(batch bool) {
+	q.running--
+	if batch {
+		q.runningBatch--
+	}
+	q.dispatch()
+}
+
+// dispatch gives free slots to queued calls; the caller holds the mutex
+func (q *executionQueue) dispatch() {
+	for q.running < q.slots {
+		batchReady := len(q.batch) > 0 && q.runningBatch < q.batchSlots()
+		var call *queuedCall
+		switch {
+		case len(q.interactive) > 0 && (!batchReady || q.burst < QUEUE_INTERACTIVE_BURST):
+			call, q.interactive = q.interactive[0], q.interactive[1:]
+			if batchReady {
+				q.burst++
+			}
+		case batchReady:
+			call, q.batch = q.batch[0], q.batch[1:]
+			q.burst = 0
+			q.runningBatch++
+		default:
+			return
+		}
+		q.running++
+		close(call.ready)
+	}
+}
+
+// state returns how many calls of each lane wait
+func (q *executionQueue) state() (interactive int, batch int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.interactive), len(q.batch)
+}
+
+// removeCall removes call from calls, if it is there
+func removeCall(calls []*queuedCall, call *queuedCall) []*queuedCall {
+	for i, queued := range calls {
+		if queued == call {
+			return append(calls[:i], calls[i+1:]...)
+		}
+	}
+	return calls
+}
+
+// WithMaxConcurrentCommands runs at most n commands at once; the others
+// queue, interactive calls ahead of batch ones. Zero, the default, runs
+// every command right away.
+func WithMaxConcurrentCommands(n int) Option {
+	return func(s *ShellServer) error {
+		if n < 0 {
+			return fmt.Errorf("maximum concurrent commands must not be negative, got %d", n)
+		}
+		if n > 0 {
+			s.queue = &executionQueue{slots: n}
+		}
+		return nil
+	}
+}
+
+// queueStep waits for a slot in the execution queue, if there is one. A
+// call that has to wait is told so through a progress notification.
+func (s *ShellServer) queueStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		if s.queue == nil {
+			return next(ctx, req)
+		}
+		batch := req.Priority == PRIORITY_BATCH
+		reporter, _ := req.Output.(*progressReporter)
+		err := s.queue.acquire(ctx, batch, func(ahead int) {
+			lane := PRIORITY_INTERACTIVE
+			if batch {
+				lane = PRIORITY_BATCH
+			}
+			if reporter != nil {
+				reporter.wait(fmt.Sprintf("Queued as %s behind %d other %s calls; %d commands may run at once", lane, ahead, lane, s.queue.slots))
+			}
+		})
+		if err != nil {
+			return CommandExecution{}, &DeniedError{
+				Reason:  fmt.Sprintf("canceled while queued: %v", err),
+				Message: s.message(MSG_QUEUE_CANCELED, err),
+				Code:    ERROR_EXECUTION_FAILED,
+				Details: map[string]interface{}{"priority": req.Priority},
+			}
+		}
+		defer s.queue.release(batch)
+		if reporter != nil {
+			reporter.wait("")
+		}
+		return next(ctx, req)
+	}
+}
@@ -0,0 +1,120 @@
+package shellserver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startOrder queues a call for each lane in turn behind the interactive
+// call holding the only slot, and returns the lanes in the order they were
+// given slots
+func startOrder(t *testing.T, q *executionQueue, lanes []bool) []bool {
+	var mutex sync.Mutex
+	var order []bool
+	var wg sync.WaitGroup
+	for _, batch := range lanes {
+		wg.Add(1)
+		queued := make(chan struct{})
+		go func(batch bool) {
+			defer wg.Done()
+			if err := q.acquire(context.Background(), batch, func(int) { close(queued) }); err != nil {
+				t.Errorf("acquire failed: %v", err)
+				return
+			}
+			mutex.Lock()
+			order = append(order, batch)
+			mutex.Unlock()
+			q.release(batch)
+		}(batch)
+		<-queued
+	}
+	// Free the slot that filled the queue, and let every call run
+	q.release(false)
+	wg.Wait()
+	return order
+}
+
+func TestExecutionQueue(t *testing.T) {
+	q := &executionQueue{slots: 1}
+	if err := q.acquire(context.Background(), false, nil); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	// Interactive calls go first, but a batch call waits for at most
+	// QUEUE_INTERACTIVE_BURST of them
+	lanes := []bool{true, false, false, false, false, false, false}
+	order := startOrder(t, q, lanes)
+	want := []bool{false, false, false, false, true, false, false}
+	if len(order) != len(want) {
+		t.Fatalf("started %d calls, want %d", len(order), len(want))
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Errorf("start order (true for batch) = %v, want %v", order, want)
+			break
+		}
+	}
+
+	// Batch calls leave one slot free for interactive ones
+	q = &executionQueue{slots: 2}
+	q.acquire(context.Background(), true, nil)
+	queued := false
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(ctx, true, func(int) { queued = true }); err == nil || !queued {
+		t.Errorf("a second batch call took the last slot")
+	}
+	if err := q.acquire(context.Background(), false, func(int) { t.Errorf("an interactive call waited for the free slot") }); err != nil {
+		t.Errorf("acquire failed: %v", err)
+	}
+	if interactive, batch := q.state(); interactive != 0 || batch != 0 {
+		t.Errorf("queue holds %d interactive and %d batch calls after a cancel, want none", interactive, batch)
+	}
+}
+
+func TestQueueStep(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("sleep,echo"), WithMaxConcurrentCommands(1))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.exec(context.Background(), &ExecRequest{Command: "sleep 0.3", Shell: "bash", Priority: PRIORITY_BATCH})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	var sent []string
+	var mutex sync.Mutex
+	reporter := &progressReporter{
+		send: func(params map[string]interface{}) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			sent = append(sent, params["message"].(string))
+			return nil
+		},
+		start: time.Now(),
+	}
+	execution, err := s.exec(context.Background(), &ExecRequest{Command: "echo quick", Shell: "bash", Output: reporter})
+	if err != nil || strings.TrimSpace(execution.Output) != "quick" {
+		t.Errorf("exec = (%q, %v)", execution.Output, err)
+	}
+	mutex.Lock()
+	if len(sent) == 0 || !strings.Contains(sent[0], "Queued as interactive behind 0 other interactive calls") {
+		t.Errorf("progress notifications %q do not say the call was queued", sent)
+	}
+	mutex.Unlock()
+	<-done
+
+	if _, err := NewShellServer(WithMaxConcurrentCommands(-1)); err == nil {
+		t.Errorf("a negative limit was accepted")
+	}
+	_, isError := callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "echo x", "priority": "urgent"})
+	if !isError {
+		t.Errorf("an unknown priority was accepted")
+	}
+}
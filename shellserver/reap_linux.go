@@ -0,0 +1,74 @@
+//go:build linux
+
+package shellserver
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// childProcess is a child of the server as /proc shows it
+type childProcess struct {
+	pid    int
+	pgid   int
+	zombie bool // Exited, and not yet waited for
+}
+
+// childProcesses lists the server's children, including the orphans it
+// adopted
+func childProcesses() []childProcess {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	self := os.Getpid()
+	var children []childProcess
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command name in parentheses may contain spaces; the state,
+		// parent and group follow its closing parenthesis
+		end := strings.LastIndexByte(string(data), ')')
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(string(data[end+1:]))
+		if len(fields) < 3 {
+			continue
+		}
+		if parent, _ := strconv.Atoi(fields[1]); parent != self {
+			continue
+		}
+		pgid, _ := strconv.Atoi(fields[2])
+		children = append(children, childProcess{pid: pid, pgid: pgid, zombie: fields[0] == "Z"})
+	}
+	return children
+}
+
+// reapOrphans collects the exited orphans the kernel gave the server, as
+// PID 1 or a subreaper. It must not wait for the server's own children,
+// whose exec.Cmd waits for them: those lead a process group of their own,
+// or share the server's, while orphans of a command stay in the command's
+// group. Zombies are waited for one by one.
+func reapOrphans() int {
+	group := syscall.Getpgrp()
+	reaped := 0
+	for _, child := range childProcesses() {
+		if !child.zombie || child.pgid == child.pid || child.pgid == group {
+			continue
+		}
+		var status syscall.WaitStatus
+		if waited, err := syscall.Wait4(child.pid, &status, syscall.WNOHANG, nil); err == nil && waited == child.pid {
+			reaped++
+		}
+	}
+	return reaped
+}
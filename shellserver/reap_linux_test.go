@@ -0,0 +1,49 @@
+//go:build linux
+
+package shellserver
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReapOrphans(t *testing.T) {
+	// As a subreaper the test adopts orphans as PID 1 would
+	if err := becomeSubreaper(); err != nil {
+		t.Skip(err)
+	}
+	defer syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_CHILD_SUBREAPER, 0, 0)
+
+	// The background job outlives the shell and is orphaned
+	cmd := exec.Command("bash", "-c", "sleep 0.1 & echo $!")
+	setProcessAttrs(cmd, nil)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("bash failed: %v", err)
+	}
+	orphan, _ := strconv.Atoi(strings.TrimSpace(string(output)))
+
+	// A child the test waits for itself is left alone
+	own := exec.Command("true")
+	setProcessAttrs(own, nil)
+	if err := own.Start(); err != nil {
+		t.Fatalf("true failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	reaped := 0
+	for syscall.Kill(orphan, 0) == nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		reaped += reapOrphans()
+	}
+	if reaped != 1 || syscall.Kill(orphan, 0) == nil {
+		t.Errorf("reapOrphans collected %d processes, want the orphan %d", reaped, orphan)
+	}
+	if err := own.Wait(); err != nil {
+		t.Errorf("waiting for the server's own child failed: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Defaults of the reaper
+const (
+	REAP_INTERVAL = time.Minute // Time between reaper runs
+	REAP_TEMP_AGE = time.Hour   // Age after which a temporary file left by a crash is removed
+)
+
+// ReaperDiagnostics counts what the reaper cleaned up since the server started
+type ReaperDiagnostics struct {
+	LastRun      time.Time `json:"lastRun,omitempty"`
+	Zombies      int       `json:"zombies"`      // Exited orphans collected while the server is an init
+	IdleSessions int       `json:"idleSessions"` // Sessions and REPLs closed for being idle
+	TempFiles    int       `json:"tempFiles"`    // Temporary files left by interrupted writes
+	TrashEntries int       `json:"trashEntries"` // Trash entries purged after the retention period
+}
+
+// reaper periodically cleans up what the server would otherwise leak when
+// it runs for a long time
+type reaper struct {
+	stop  chan struct{} // Closed by Close; nil when the reaper does not run
+	mutex sync.Mutex
+	stats ReaperDiagnostics
+}
+
+// WithReaper sets how often the reaper runs. Each run collects exited
+// orphan processes when the server is an init (see WithInit),
+// closes sessions and REPLs idle for longer than WithSessionIdleTimeout,
+// purges expired trash entries and removes temporary files left by crashed
+// writes of the history file. Zero disables it.
+func WithReaper(interval time.Duration) Option {
+	return func(s *ShellServer) error {
+		if interval < 0 {
+			return fmt.Errorf("reap interval must not be negative, got %s", interval)
+		}
+		s.reapInterval = interval
+		return nil
+	}
+}
+
+// WithSessionIdleTimeout makes the reaper close sessions and REPLs that ran
+// nothing for timeout. Zero keeps them open until closed.
+func WithSessionIdleTimeout(timeout time.Duration) Option {
+	return func(s *ShellServer) error {
+		if timeout < 0 {
+			return fmt.Errorf("session idle timeout must not be negative, got %s", timeout)
+		}
+		s.sessionIdleTimeout = timeout
+		return nil
+	}
+}
+
+// runReaper reaps every reapInterval until stop is closed
+func (s *ShellServer) runReaper(stop chan struct{}) {
+	ticker := time.NewTicker(s.reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.reap()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reap cleans up once and logs what it did
+func (s *ShellServer) reap() {
+	var run ReaperDiagnostics
+	if s.isInit() {
+		run.Zombies = reapOrphans()
+	}
+	if s.sessionIdleTimeout > 0 {
+		run.IdleSessions = s.closeIdleSessions(time.Now().Add(-s.sessionIdleTimeout))
+	}
+	if s.trash != nil {
+		run.TrashEntries = s.trash.purge(s.now())
+	}
+	if history, ok := s.history.(*jsonlHistory); ok {
+		run.TempFiles = removeStaleTempFiles(history.path, time.Now().Add(-REAP_TEMP_AGE))
+	}
+
+	if run.Zombies > 0 {
+		s.logger.Printf("Reaper collected %d exited orphan processes", run.Zombies)
+	}
+	if run.IdleSessions > 0 {
+		s.logger.Printf("Reaper closed %d sessions and REPLs idle for more than %s", run.IdleSessions, s.sessionIdleTimeout)
+	}
+	if run.TrashEntries > 0 {
+		s.logger.Printf("Reaper purged %d expired trash entries", run.TrashEntries)
+	}
+	if run.TempFiles > 0 {
+		s.logger.Printf("Reaper removed %d temporary files left by interrupted writes", run.TempFiles)
+	}
+
+	run.LastRun = s.now()
+	s.addReaped(run)
+}
+
+// addReaped adds what a run of the reaper cleaned up to its totals
+func (s *ShellServer) addReaped(run ReaperDiagnostics) {
+	s.reaper.mutex.Lock()
+	defer s.reaper.mutex.Unlock()
+	if !run.LastRun.IsZero() {
+		s.reaper.stats.LastRun = run.LastRun
+	}
+	s.reaper.stats.Zombies += run.Zombies
+	s.reaper.stats.IdleSessions += run.IdleSessions
+	s.reaper.stats.TrashEntries += run.TrashEntries
+	s.reaper.stats.TempFiles += run.TempFiles
+}
+
+// reaperStats returns what the reaper cleaned up so far
+func (s *ShellServer) reaperStats() ReaperDiagnostics {
+	s.reaper.mutex.Lock()
+	defer s.reaper.mutex.Unlock()
+	return s.reaper.stats
+}
+
+// closeIdleSessions closes the sessions and REPLs last used before cutoff
+// that are not running anything, and returns how many
+func (s *ShellServer) closeIdleSessions(cutoff time.Time) int {
+	closed := 0
+	s.sessionMutex.Lock()
+	var sessions []*shellSession
+	for id, session := range s.sessions {
+		if !session.runMutex.TryLock() {
+			continue
+		}
+		if session.lastUsed.Before(cutoff) {
+			delete(s.sessions, id)
+			sessions = append(sessions, session)
+		} else {
+			session.runMutex.Unlock()
+		}
+	}
+	s.sessionMutex.Unlock()
+	for _, session := range sessions {
+		session.recorder.Close()
+		session.impl.close()
+		session.runMutex.Unlock()
+		closed++
+	}
+
+	s.replMutex.Lock()
+	var repls []*replSession
+	for id, repl := range s.replSessions {
+		if !repl.evalMutex.TryLock() {
+			continue
+		}
+		if repl.lastUsed.Before(cutoff) {
+			delete(s.replSessions, id)
+			repls = append(repls, repl)
+		} else {
+			repl.evalMutex.Unlock()
+		}
+	}
+	s.replMutex.Unlock()
+	for _, repl := range repls {
+		repl.stop()
+		repl.evalMutex.Unlock()
+		closed++
+	}
+	return closed
+}
+
+// removeStaleTempFiles removes the temporary files writeFileAtomic left next
+// to path when it was interrupted before cutoff, and returns how many
+func removeStaleTempFiles(path string, cutoff time.Time) int {
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*"))
+	removed := 0
+	for _, match := range matches {
+		if info, err := os.Lstat(match); err == nil && info.Mode().IsRegular() && info.ModTime().Before(cutoff) {
+			if os.Remove(match) == nil {
+				removed++
+			}
+		}
+	}
+	return removed
+}
@@ -0,0 +1,93 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCloseIdleSessions(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithReaper(0), WithSessionIdleTimeout(time.Hour))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	idle, err := s.startSession("bash", "")
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	busy, err := s.startSession("bash", "")
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	idle.lastUsed = time.Now().Add(-2 * time.Hour)
+	busy.runMutex.Lock()
+	busy.lastUsed = time.Now().Add(-2 * time.Hour)
+
+	// Only the idle session that runs nothing is closed
+	s.reap()
+	busy.runMutex.Unlock()
+	s.sessionMutex.Lock()
+	_, idleOpen := s.sessions[idle.id]
+	_, busyOpen := s.sessions[busy.id]
+	s.sessionMutex.Unlock()
+	if idleOpen || !busyOpen {
+		t.Errorf("after reaping idle open = %v, busy open = %v; want false, true", idleOpen, busyOpen)
+	}
+	if stats := s.reaperStats(); stats.IdleSessions != 1 || stats.LastRun.IsZero() {
+		t.Errorf("reaper stats = %+v, want 1 idle session", stats)
+	}
+}
+
+func TestRemoveStaleTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+	old := time.Now().Add(-2 * REAP_TEMP_AGE)
+	tests := []struct {
+		name    string
+		modTime time.Time
+		removed bool
+	}{
+		{".history.jsonl.tmp-1", old, true},
+		{".history.jsonl.tmp-2", time.Now(), false}, // May still be being written
+		{".other.jsonl.tmp-3", old, false},
+		{"history.jsonl", old, false},
+	}
+	for _, test := range tests {
+		file := filepath.Join(dir, test.name)
+		os.WriteFile(file, []byte("x"), 0600)
+		os.Chtimes(file, test.modTime, test.modTime)
+	}
+
+	if removed := removeStaleTempFiles(path, time.Now().Add(-REAP_TEMP_AGE)); removed != 1 {
+		t.Errorf("removeStaleTempFiles removed %d files, want 1", removed)
+	}
+	for _, test := range tests {
+		_, err := os.Stat(filepath.Join(dir, test.name))
+		if removed := os.IsNotExist(err); removed != test.removed {
+			t.Errorf("%s removed = %v, want %v", test.name, removed, test.removed)
+		}
+	}
+}
+
+func TestReaperOptions(t *testing.T) {
+	if _, err := NewShellServer(WithReaper(-time.Second)); err == nil {
+		t.Errorf("a negative reap interval was accepted")
+	}
+	if _, err := NewShellServer(WithSessionIdleTimeout(-time.Second)); err == nil {
+		t.Errorf("a negative session idle timeout was accepted")
+	}
+	s, err := NewShellServer(WithReaper(10 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.reaperStats().LastRun.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Close()
+	if s.reaperStats().LastRun.IsZero() {
+		t.Errorf("the reaper did not run")
+	}
+}
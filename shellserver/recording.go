@@ -0,0 +1,244 @@
+package shellserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Asciicast recording settings
+const (
+	CAST_WIDTH         = 200 // Terminal width written to recording headers
+	CAST_HEIGHT        = 50  // Terminal height written to recording headers
+	CAST_EXTENSION     = ".cast"
+	DEFAULT_CAST_LIMIT = 20 // Default number of recordings to list
+)
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castRecorder writes an asciicast v2 recording: a JSON header line followed
+// by one [elapsed, kind, data] event per line
+type castRecorder struct {
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+}
+
+// newCastRecorder creates a recording file in dir named after kind
+func (s *ShellServer) newCastRecorder(kind string, title string, shell string) (*castRecorder, error) {
+	if err := os.MkdirAll(s.recordDir, 0700); err != nil {
+		return nil, err
+	}
+
+	s.recordMutex.Lock()
+	s.recordCounter++
+	name := fmt.Sprintf("%s-%s-%d%s", time.Now().Format("20060102T150405"), kind, s.recordCounter, CAST_EXTENSION)
+	s.recordMutex.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(s.recordDir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &castRecorder{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		start:  time.Now(),
+	}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     CAST_WIDTH,
+		Height:    CAST_HEIGHT,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"SHELL": shell, "TERM": "xterm-256color"},
+	})
+	r.writer.Write(header)
+	r.writer.WriteString("\n")
+	return r, nil
+}
+
+// event appends a single event; "o" is output and "i" is input
+func (r *castRecorder) event(kind string, data string) {
+	if r == nil || data == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Pipes produce bare LF; terminals replaying the cast expect CRLF
+	data = strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\n", "\r\n")
+	line, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), kind, data})
+	r.writer.Write(line)
+	r.writer.WriteString("\n")
+}
+
+// Write records output as it is produced so the replay keeps its timing
+func (r *castRecorder) Write(p []byte) (int, error) {
+	r.event("o", string(p))
+	return len(p), nil
+}
+
+// input records a command as if it had been typed at a prompt
+func (r *castRecorder) input(command string) {
+	r.event("o", "$ "+command+"\n")
+}
+
+// Close flushes and closes the recording file
+func (r *castRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// recordingInfo summarizes a recording file for list_recordings
+type recordingInfo struct {
+	name    string
+	title   string
+	size    int64
+	modTime time.Time
+}
+
+// listRecordings returns recordings in the recording directory, newest first
+func (s *ShellServer) listRecordings() ([]recordingInfo, error) {
+	entries, err := os.ReadDir(s.recordDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var recordings []recordingInfo
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != CAST_EXTENSION {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, recordingInfo{
+			name:    entry.Name(),
+			title:   readCastTitle(filepath.Join(s.recordDir, entry.Name())),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].modTime.After(recordings[j].modTime)
+	})
+	return recordings, nil
+}
+
+// readCastTitle reads the title from a recording's header line
+func readCastTitle(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return ""
+	}
+	var header castHeader
+	if json.Unmarshal(line, &header) != nil {
+		return ""
+	}
+	return header.Title
+}
+
+func (s *ShellServer) handleListRecordings(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if s.recordDir == "" {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "Recording is disabled. Start the server with '--record-dir' to record executions as asciicast files.",
+				},
+			},
+		}, nil
+	}
+
+	limit := DEFAULT_CAST_LIMIT
+	if limitArg, ok := request.Params.Arguments["limit"].(float64); ok {
+		limit = int(limitArg)
+	}
+
+	recordings, err := s.listRecordings()
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	if len(recordings) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "No recordings yet.",
+				},
+			},
+		}, nil
+	}
+
+	total := len(recordings)
+	if limit > 0 && limit < total {
+		recordings = recordings[:limit]
+	}
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Recordings in %s (showing %d of %d, replay with 'asciinema play <file>'):\n\n",
+		s.recordDir, len(recordings), total))
+	for i, recording := range recordings {
+		result.WriteString(fmt.Sprintf("%d. %s (%d bytes, %s)\n   %s\n",
+			i+1, recording.name, recording.size, recording.modTime.Format(time.RFC3339), recording.title))
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result.String(),
+			},
+		},
+	}, nil
+}
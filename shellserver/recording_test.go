@@ -0,0 +1,53 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecutionRecording(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	s.recordDir = t.TempDir()
+
+	s.executeCommand("echo first; echo second", "bash", nil)
+
+	recordings, err := s.listRecordings()
+	if err != nil {
+		t.Fatalf("listRecordings failed: %v", err)
+	}
+	if len(recordings) != 1 {
+		t.Fatalf("listRecordings returned %d recordings, want 1", len(recordings))
+	}
+	if recordings[0].title != "echo first; echo second" {
+		t.Errorf("recording title = %q, want the command", recordings[0].title)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.recordDir, recordings[0].name))
+	if err != nil {
+		t.Fatalf("reading recording failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	var header castHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header.Version != 2 {
+		t.Errorf("recording header = %q, want asciicast v2", lines[0])
+	}
+
+	var output strings.Builder
+	for _, line := range lines[1:] {
+		var event []interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil || len(event) != 3 {
+			t.Fatalf("invalid event line %q", line)
+		}
+		output.WriteString(event[2].(string))
+	}
+	if !strings.Contains(output.String(), "first\r\nsecond\r\n") {
+		t.Errorf("recorded output = %q, want CRLF-terminated command output", output.String())
+	}
+}
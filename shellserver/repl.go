@@ -0,0 +1,464 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// REPL session limits
+const (
+	MAX_REPL_SESSIONS = 5               // Maximum concurrently running REPLs
+	REPL_EVAL_TIMEOUT = COMMAND_TIMEOUT // How long an eval may take to produce its marker
+	REPL_STOP_TIMEOUT = 2 * time.Second // Grace period after closing stdin before killing
+	REPL_POLL_DELAY   = 10 * time.Millisecond
+)
+
+// errTooManyRepls refuses a REPL over MAX_REPL_SESSIONS
+var errTooManyRepls = fmt.Errorf("too many REPL sessions (maximum %d); stop one with 'stop_repl' first", MAX_REPL_SESSIONS)
+
+// replInterpreter describes how to drive one interpreter over pipes
+type replInterpreter struct {
+	binary  string                     // Executable, checked against the allowlist
+	args    []string                   // Arguments that start a quiet, prompt-free REPL
+	marker  func(marker string) string // Code that prints marker on its own line
+	prompts []string                   // Prompt prefixes stripped from output lines
+	flush   string                     // Sent before the marker to terminate open blocks
+}
+
+// replInterpreters lists the supported interpreters keyed by tool parameter value
+var replInterpreters = map[string]replInterpreter{
+	"python": {
+		binary: "python3",
+		args:   []string{"-u", "-i", "-q", "-c", "import sys; sys.ps1 = sys.ps2 = ''"},
+		marker: func(m string) string { return fmt.Sprintf("print(%q)", m) },
+		flush:  "\n",
+	},
+	"node": {
+		binary:  "node",
+		args:    []string{"-e", "require('repl').start({prompt: '', ignoreUndefined: true})"},
+		marker:  func(m string) string { return fmt.Sprintf("console.log(%q)", m) },
+		prompts: []string{"... "},
+	},
+	"psql": {
+		binary: "psql",
+		args:   []string{"-X", "-q"},
+		marker: func(m string) string { return `\echo ` + m },
+	},
+	"redis-cli": {
+		binary: "redis-cli",
+		marker: func(m string) string { return "ECHO " + m },
+	},
+}
+
+// markerBuffer collects process output and hands it out in chunks
+// delimited by marker lines
+type markerBuffer struct {
+	mutex  sync.Mutex
+	output strings.Builder
+	exited bool
+}
+
+// Write collects process output; it is used for both stdout and stderr
+func (b *markerBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.output.Write(p)
+	return len(p), nil
+}
+
+// markExited records that the process writing to the buffer has exited
+func (b *markerBuffer) markExited() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.exited = true
+}
+
+// hasExited reports whether the process writing to the buffer has exited
+func (b *markerBuffer) hasExited() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.exited
+}
+
+// takeUntil waits for a line starting with marker to appear in the output and
+// returns everything before it along with the rest of the marker line
+func (b *markerBuffer) takeUntil(marker string, timeout time.Duration) (string, string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		b.mutex.Lock()
+		buffered := b.output.String()
+		exited := b.exited
+		if idx := strings.Index(buffered, marker); idx >= 0 {
+			rest := buffered[idx+len(marker):]
+			if end := strings.IndexByte(rest, '\n'); end >= 0 {
+				trailer := rest[:end]
+				b.output.Reset()
+				b.output.WriteString(rest[end+1:])
+				b.mutex.Unlock()
+				return buffered[:idx], trailer, nil
+			}
+		}
+		b.mutex.Unlock()
+
+		if exited {
+			return buffered, "", fmt.Errorf("process exited")
+		}
+		if time.Now().After(deadline) {
+			return buffered, "", fmt.Errorf("timed out after %s waiting for output", timeout)
+		}
+		time.Sleep(REPL_POLL_DELAY)
+	}
+}
+
+// replSession is a running interpreter with its merged stdout and stderr
+type replSession struct {
+	id          string
+	interpreter string
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	startTime   time.Time
+	evalMutex   sync.Mutex // Serializes evals so outputs don't interleave
+	output      markerBuffer
+	tenant      string        // Tenant that started the session
+	recorder    *castRecorder // Nil unless recording is enabled
+	lastUsed    time.Time     // When the last eval finished; guarded by evalMutex
+}
+
+// newReplMarker returns a random marker that will not occur in normal output
+func newReplMarker() string {
+	return "__MCP_REPL_" + randomHex(12) + "__"
+}
+
+// startRepl launches an interpreter for client and registers it as a
+// session of tenant. The interpreter and its arguments are checked as a
+// command line with the client's policy and the tripwires; a refusal is
+// returned as a *DeniedError.
+func (s *ShellServer) startRepl(interpreter string, args []string, client *ClientIdentity, tenant string) (*replSession, error) {
+	spec, ok := replInterpreters[interpreter]
+	if !ok {
+		return nil, fmt.Errorf("unsupported interpreter '%s'", interpreter)
+	}
+	command := spec.binary
+	for _, arg := range args {
+		command += " " + shellQuote(arg)
+	}
+	req := &ExecRequest{Command: command, Shell: interpreter, Client: client}
+	denied := s.tripwireDenial(req)
+	if denied == nil {
+		denied = s.policyDenial(s.policyFor("", clientName(client)), command)
+	}
+	if denied != nil {
+		s.deniedToolError(req, denied)
+		return nil, denied
+	}
+
+	// Refuse early rather than start an interpreter only to stop it; the
+	// limit itself is enforced where the session is added
+	s.replMutex.Lock()
+	count := len(s.replSessions)
+	s.replMutex.Unlock()
+	if count >= MAX_REPL_SESSIONS {
+		return nil, errTooManyRepls
+	}
+
+	session := &replSession{
+		interpreter: interpreter,
+		startTime:   time.Now(),
+	}
+	session.cmd = s.control.command(context.Background(), spec.binary, append(append([]string{}, spec.args...), args...)...)
+	session.cmd.Stdout = &session.output
+	session.cmd.Stderr = &session.output
+
+	stdin, err := session.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	session.stdin = stdin
+	if err := session.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", spec.binary, err)
+	}
+
+	go func() {
+		session.cmd.Wait()
+		session.output.markExited()
+	}()
+
+	// Make sure the interpreter is responsive before handing it out
+	if output, err := session.eval(""); err != nil {
+		session.stop()
+		return nil, fmt.Errorf("%s did not start: %v\n%s", spec.binary, err, strings.TrimSpace(output))
+	}
+
+	// The interpreter is not started with the lock held, since starting
+	// one can take until REPL_EVAL_TIMEOUT, so sessions started meanwhile
+	// are counted again here
+	s.replMutex.Lock()
+	if len(s.replSessions) >= MAX_REPL_SESSIONS {
+		s.replMutex.Unlock()
+		session.stop()
+		return nil, errTooManyRepls
+	}
+	defer s.replMutex.Unlock()
+	s.replCounter++
+	session.id = fmt.Sprintf("repl-%d", s.replCounter)
+	session.tenant = tenant
+	s.replSessions[session.id] = session
+
+	if s.recordDir != "" {
+		if session.recorder, err = s.newCastRecorder("repl", session.id+" ("+interpreter+")", spec.binary); err != nil {
+			s.logger.Printf("Failed to start recording for %s: %v", session.id, err)
+		}
+	}
+
+	return session, nil
+}
+
+// eval sends code to the interpreter followed by a marker and returns
+// only the output produced before the marker
+func (r *replSession) eval(code string) (string, error) {
+	r.evalMutex.Lock()
+	defer r.evalMutex.Unlock()
+	defer func() { r.lastUsed = time.Now() }()
+
+	spec := replInterpreters[r.interpreter]
+	marker := newReplMarker()
+
+	input := code
+	if input != "" && !strings.HasSuffix(input, "\n") {
+		input += "\n"
+	}
+	input += spec.flush + spec.marker(marker) + "\n"
+
+	if _, err := io.WriteString(r.stdin, input); err != nil {
+		return "", fmt.Errorf("failed to send input: %v", err)
+	}
+
+	output, _, err := r.output.takeUntil(marker, REPL_EVAL_TIMEOUT)
+	output = stripPrompts(output, spec.prompts)
+	if code != "" {
+		r.recorder.event("o", strings.TrimSuffix(code, "\n")+"\n"+output)
+	}
+	return output, err
+}
+
+// stop closes the interpreter's stdin and kills it if it does not exit
+func (r *replSession) stop() {
+	r.recorder.Close()
+	r.stdin.Close()
+	deadline := time.Now().Add(REPL_STOP_TIMEOUT)
+	for time.Now().Before(deadline) {
+		if r.output.hasExited() {
+			return
+		}
+		time.Sleep(REPL_POLL_DELAY)
+	}
+	killProcessTree(r.cmd)
+}
+
+// buildReplChain assembles the steps evals go through: they are rate
+// limited, audited and recorded in history, and their output is redacted
+// and capped as a command's. The policy, anomaly and trash steps and custom
+// middleware are left out, since they read the code as a shell command.
+func (s *ShellServer) buildReplChain() ExecFunc {
+	steps := []Middleware{s.rateLimitStep, s.auditStep, s.redactionStep, postProcessStep}
+	run := s.evalStep
+	for i := len(steps) - 1; i >= 0; i-- {
+		run = steps[i](run)
+	}
+	return run
+}
+
+// evalStep runs the request's code in its REPL session. An eval that fails
+// or times out is reported as a failed execution with the error appended
+// to its output.
+func (s *ShellServer) evalStep(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+	session, found := s.getRepl(ctx, req.Session)
+	if !found {
+		return CommandExecution{}, fmt.Errorf("no REPL session with ID '%s'", req.Session)
+	}
+	execution := CommandExecution{Command: req.Command, Shell: req.Shell, Session: req.Session, StartTime: s.now()}
+	output, err := session.eval(req.Command)
+	execution.EndTime = s.now()
+	execution.ExecutionMs = execution.EndTime.Sub(execution.StartTime).Milliseconds()
+	execution.Output = output
+	if err != nil {
+		execution.Output += fmt.Sprintf("\n\nError: %v", err)
+		execution.ExitCode, execution.ErrorCode = 1, ERROR_EXECUTION_FAILED
+	}
+	return execution, nil
+}
+
+// getRepl looks up a running session of the calling tenant by ID
+func (s *ShellServer) getRepl(ctx context.Context, id string) (*replSession, bool) {
+	tenant := s.tenant(ctx)
+	s.replMutex.Lock()
+	defer s.replMutex.Unlock()
+	session, ok := s.replSessions[id]
+	if !ok || session.tenant != tenant {
+		return nil, false
+	}
+	return session, true
+}
+
+// stripPrompts removes continuation prompts the interpreter prints before output
+func stripPrompts(output string, prompts []string) string {
+	if len(prompts) == 0 {
+		return output
+	}
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		for stripped := true; stripped; {
+			stripped = false
+			for _, prompt := range prompts {
+				if strings.HasPrefix(line, prompt) {
+					line = strings.TrimPrefix(line, prompt)
+					stripped = true
+				}
+			}
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s *ShellServer) handleStartRepl(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	interpreter, ok := request.Params.Arguments["interpreter"].(string)
+	if !ok {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "Error: 'interpreter' must be a string",
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	var args []string
+	if argsArg, ok := request.Params.Arguments["args"].(string); ok {
+		args = strings.Fields(argsArg)
+	}
+
+	session, err := s.startRepl(interpreter, args, s.clientIdentity(ctx), s.tenant(ctx))
+	if err != nil {
+		return errorResult(refusal(err)), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: fmt.Sprintf("Started %s REPL with session ID '%s'. Use 'eval_in_repl' to run code and 'stop_repl' when done.", interpreter, session.id),
+			},
+		},
+	}, nil
+}
+
+func (s *ShellServer) handleEvalInRepl(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	id, _ := request.Params.Arguments["session_id"].(string)
+	code, ok := request.Params.Arguments["code"].(string)
+	if !ok {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "Error: 'code' must be a string",
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	session, ok := s.getRepl(ctx, id)
+	if !ok {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Error: No REPL session with ID '%s'. Run 'start_repl' first.", id),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	req := &ExecRequest{Command: code, Shell: session.interpreter, Session: id, Client: s.clientIdentity(ctx)}
+	execution, err := s.replExec(ctx, req)
+	if err != nil {
+		return errorResult(s.deniedToolError(req, err)), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: execution.Output,
+			},
+		},
+		IsError: execution.ErrorCode != "",
+	}, nil
+}
+
+func (s *ShellServer) handleStopRepl(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	id, _ := request.Params.Arguments["session_id"].(string)
+
+	tenant := s.tenant(ctx)
+	s.replMutex.Lock()
+	session, ok := s.replSessions[id]
+	ok = ok && session.tenant == tenant
+	if ok {
+		delete(s.replSessions, id)
+	}
+	s.replMutex.Unlock()
+
+	if !ok {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Error: No REPL session with ID '%s'.", id),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	session.stop()
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: fmt.Sprintf("Stopped %s REPL '%s' after %s.", session.interpreter, id, time.Since(session.startTime).Round(time.Second)),
+			},
+		},
+	}, nil
+}
+
+// replInterpreterNames returns the supported interpreter names in order
+func replInterpreterNames() []string {
+	names := make([]string, 0, len(replInterpreters))
+	for name := range replInterpreters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
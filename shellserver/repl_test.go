@@ -0,0 +1,140 @@
+package shellserver
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStripPrompts(t *testing.T) {
+	if got := stripPrompts("... ... 3\nplain\n", []string{"... "}); got != "3\nplain\n" {
+		t.Errorf("stripPrompts() = %q, want %q", got, "3\nplain\n")
+	}
+}
+
+func TestReplSession(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+
+	s, err := NewShellServer(WithAllowedCommands("python3"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	session, err := s.startRepl("python", nil, nil, "")
+	if err != nil {
+		t.Fatalf("startRepl failed: %v", err)
+	}
+	defer session.stop()
+
+	if output, err := session.eval("x = 2\ndef double(v):\n    return v * 2"); err != nil || strings.TrimSpace(output) != "" {
+		t.Errorf("eval(definitions) = (%q, %v), want no output", output, err)
+	}
+	if output, err := session.eval("double(x) * 10 + 2"); err != nil || strings.TrimSpace(output) != "42" {
+		t.Errorf("eval(expression) = (%q, %v), want 42", output, err)
+	}
+
+	// The interpreter binary must be allowed
+	if _, err := s.startRepl("node", nil, nil, ""); err == nil {
+		t.Errorf("startRepl(node) should fail when node is not allowed")
+	}
+}
+
+func TestReplPolicy(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+
+	notifier := &recordingNotifier{}
+	s, err := NewShellServer(
+		WithAllowedCommands("python3"),
+		WithPolicyRules(&PolicyRules{Deny: []string{"python3 -X"}}),
+		WithClientPolicies([]ClientPolicy{{Name: "cursor", Policy: &PolicyRules{Deny: []string{"python3"}}}}),
+		WithRateLimit(3, time.Minute),
+		WithNotifier(notifier),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	// Arguments and the client's policy are checked, not only the binary
+	if _, err := s.startRepl("python", []string{"-X", "dev"}, nil, ""); err == nil {
+		t.Errorf("startRepl with a denied argument succeeded")
+	}
+	if _, err := s.startRepl("python", nil, &ClientIdentity{Name: "cursor"}, ""); err == nil {
+		t.Errorf("startRepl for a client whose policy denies python3 succeeded")
+	}
+
+	text, isError := callTool(t, s.handleStartRepl, map[string]interface{}{"interpreter": "python"})
+	if isError {
+		t.Fatalf("start_repl failed: %s", text)
+	}
+	eval := func(code string) (string, bool) {
+		return callTool(t, s.handleEvalInRepl, map[string]interface{}{"session_id": "repl-1", "code": code})
+	}
+
+	// Evals are recorded in history and count towards the rate limit
+	if text, isError := eval("print(6 * 7)"); isError || strings.TrimSpace(text) != "42" {
+		t.Errorf("eval_in_repl = %q (error %v), want 42", text, isError)
+	}
+	history := recentHistory(t, s, 1)
+	if len(history) != 1 || history[0].Command != "print(6 * 7)" || history[0].Shell != "python" || history[0].Session != "repl-1" {
+		t.Errorf("history = %+v, want the eval", history)
+	}
+	eval("1")
+	eval("2")
+	if text, isError := eval("3"); !isError || !strings.Contains(text, "Rate limit") {
+		t.Errorf("eval_in_repl beyond the rate limit = %q, want it refused", text)
+	}
+	finished := 0
+	for _, event := range notifier.events {
+		if event.Event == EVENT_FINISH && event.Execution.Session == "repl-1" {
+			finished++
+		}
+	}
+	if finished != 3 {
+		t.Errorf("%d finish events for evals, want 3", finished)
+	}
+}
+
+func TestReplSessionLimit(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+
+	s, err := NewShellServer(WithAllowedCommands("python3"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	// Sessions started at once are counted when they are added, so no more
+	// than the maximum survive however the starts interleave
+	var wg sync.WaitGroup
+	var started atomic.Int32
+	for range 2 * MAX_REPL_SESSIONS {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.startRepl("python", nil, nil, ""); err == nil {
+				started.Add(1)
+			} else if !errors.Is(err, errTooManyRepls) {
+				t.Errorf("startRepl failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.replMutex.Lock()
+	count := len(s.replSessions)
+	s.replMutex.Unlock()
+	if started.Load() != MAX_REPL_SESSIONS || count != MAX_REPL_SESSIONS {
+		t.Errorf("started %d REPLs, %d registered, want %d", started.Load(), count, MAX_REPL_SESSIONS)
+	}
+}
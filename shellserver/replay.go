@@ -0,0 +1,235 @@
+package shellserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// REPLAY_URI is the URI of the structured replay_execution result
+const REPLAY_URI = "shell://replay.json"
+
+// ExecutionContext is what a command ran under, recorded so that
+// replay_execution can tell whether it would run under the same conditions.
+// Environment values are not kept, only a fingerprint of them.
+type ExecutionContext struct {
+	Dir            string `json:"dir,omitempty"`            // Working directory; empty in sessions, which keep their own
+	EnvFingerprint string `json:"envFingerprint,omitempty"` // Hash of the environment variables the command got
+	PolicyVersion  string `json:"policyVersion,omitempty"`  // Hash of the policy rules it was checked against; "custom" for a custom policy
+}
+
+// Divergence is a difference between a historical execution and its replay
+type Divergence struct {
+	Field    string `json:"field"` // dir, env, policy, exitCode or output
+	Recorded string `json:"recorded"`
+	Replayed string `json:"replayed"`
+}
+
+// ReplayResult is the structured result of replay_execution
+type ReplayResult struct {
+	Original    int64        `json:"original"`         // ID of the replayed execution
+	Replay      int64        `json:"replay,omitempty"` // ID of the new execution; zero if it did not run
+	Divergences []Divergence `json:"divergences"`      // Empty when the replay matched
+	Strict      bool         `json:"strict,omitempty"` // Whether diverging conditions kept it from running
+}
+
+// fingerprint returns a short hash of a value that need not be kept
+func fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// envFingerprint hashes the environment a command gets: the server's, its
+// process control variables and the request's
+func (s *ShellServer) envFingerprint(req *ExecRequest) string {
+	env := append(childEnv(s.control.env...), req.Env...)
+	values := make(map[string]string, len(env))
+	for _, entry := range env {
+		name, value, _ := strings.Cut(entry, "=")
+		values[name] = value
+	}
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var all strings.Builder
+	for _, name := range names {
+		all.WriteString(name + "=" + values[name] + "\x00")
+	}
+	return fingerprint(all.String())
+}
+
+// policyVersion hashes the rules of a policy, so a replay can tell whether
+// they changed since
+func policyVersion(policy Policy) string {
+	allowlist, ok := policy.(*AllowlistPolicy)
+	if !ok {
+		return "custom"
+	}
+	rules := PolicyRules{Allow: allowlist.Commands(), Deny: allowlist.DenyRules(), DenyPaths: allowlist.DeniedPaths()}
+	if allowlist.AllowAll() {
+		rules.Allow = []string{"*"}
+	}
+	readOnly := allowlist.ReadOnly()
+	rules.ReadOnly = &readOnly
+	data, _ := json.Marshal(rules)
+	return fingerprint(string(data))
+}
+
+// executionContext records what req runs under
+func (s *ShellServer) executionContext(req *ExecRequest) *ExecutionContext {
+	recorded := &ExecutionContext{
+		Dir:            req.Dir,
+		EnvFingerprint: s.envFingerprint(req),
+		PolicyVersion:  policyVersion(s.policyFor(req.Project, clientName(req.Client))),
+	}
+	if recorded.Dir == "" && req.Session == "" && req.Target == "" && req.Container == "" {
+		recorded.Dir, _ = os.Getwd()
+	}
+	return recorded
+}
+
+// replayRequest rebuilds the request of a historical execution as
+// execute_command would make it today
+func (s *ShellServer) replayRequest(ctx context.Context, execution CommandExecution) *ExecRequest {
+	req := &ExecRequest{
+		Command:   execution.Command,
+		Shell:     execution.Shell,
+		Project:   execution.Project,
+		Target:    execution.Target,
+		Container: execution.Container,
+		Client:    s.clientIdentity(ctx),
+	}
+	if p, found := s.projects[execution.Project]; found {
+		req.Env = append([]string{}, p.Env...)
+	} else if s.workProject != nil && s.workProject.Name == execution.Project {
+		req.Env = append([]string{}, s.workProject.Env...)
+	}
+	if execution.Context != nil && req.Target == "" && req.Container == "" {
+		req.Dir = execution.Context.Dir
+	}
+	if len(s.dotenvNames) > 0 && req.Dir != "" {
+		req.Env = append(s.dotenv(req.Dir), req.Env...)
+	}
+	return req
+}
+
+// contextDivergences lists where the conditions of a replay differ from
+// those recorded
+func contextDivergences(recorded *ExecutionContext, replayed *ExecutionContext) []Divergence {
+	divergences := []Divergence{}
+	if recorded == nil {
+		return append(divergences, Divergence{Field: "context", Recorded: "not recorded", Replayed: "recorded"})
+	}
+	if recorded.Dir != replayed.Dir {
+		divergences = append(divergences, Divergence{Field: "dir", Recorded: recorded.Dir, Replayed: replayed.Dir})
+	} else if recorded.Dir != "" {
+		if info, err := os.Stat(recorded.Dir); err != nil || !info.IsDir() {
+			divergences = append(divergences, Divergence{Field: "dir", Recorded: recorded.Dir, Replayed: "missing"})
+		}
+	}
+	if recorded.EnvFingerprint != replayed.EnvFingerprint {
+		divergences = append(divergences, Divergence{Field: "env", Recorded: recorded.EnvFingerprint, Replayed: replayed.EnvFingerprint})
+	}
+	if recorded.PolicyVersion != replayed.PolicyVersion {
+		divergences = append(divergences, Divergence{Field: "policy", Recorded: recorded.PolicyVersion, Replayed: replayed.PolicyVersion})
+	}
+	return divergences
+}
+
+// findExecution returns an execution of the caller's history by ID
+func (s *ShellServer) findExecution(ctx context.Context, id int64, argument string) (CommandExecution, *ToolError) {
+	recent, _, err := s.tenantHistory(ctx, 0)
+	if err != nil {
+		return CommandExecution{}, &ToolError{Code: ERROR_EXECUTION_FAILED, Message: fmt.Sprintf("Error: Failed to read command history: %v", err)}
+	}
+	for _, execution := range recent {
+		if execution.ID == id {
+			return execution, nil
+		}
+	}
+	return CommandExecution{}, &ToolError{
+		Code:    ERROR_INVALID_ARGUMENT,
+		Message: s.message(MSG_EXECUTION_NOT_FOUND, id),
+		Details: map[string]interface{}{"argument": argument, "id": id},
+	}
+}
+
+func (s *ShellServer) handleReplayExecution(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	idArg, ok := request.Params.Arguments["id"].(float64)
+	if !ok || idArg != float64(int64(idArg)) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'id' must be the ID of an execution in the recent history",
+			Details: map[string]interface{}{"argument": "id"},
+		}), nil
+	}
+	strict, _ := request.Params.Arguments["strict"].(bool)
+	original, toolError := s.findExecution(ctx, int64(idArg), "id")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	if original.Session != "" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: Execution %d ran in session '%s', whose state cannot be replayed.", original.ID, original.Session),
+			Details: map[string]interface{}{"argument": "id", "session": original.Session},
+		}), nil
+	}
+
+	req := s.replayRequest(ctx, original)
+	result := ReplayResult{Original: original.ID, Strict: strict}
+	result.Divergences = contextDivergences(original.Context, s.executionContext(req))
+	if strict && len(result.Divergences) > 0 {
+		return errorResult(ToolError{
+			Code:    ERROR_REPLAY_DIVERGED,
+			Message: fmt.Sprintf("Error: Execution %d was not replayed: %s changed since it ran.", original.ID, divergenceFields(result.Divergences)),
+			Details: map[string]interface{}{"divergences": result.Divergences},
+		}), nil
+	}
+
+	execution, err := s.exec(ctx, req)
+	if err != nil {
+		return errorResult(s.deniedToolError(req, err)), nil
+	}
+	result.Replay = execution.ID
+	if execution.ExitCode != original.ExitCode {
+		result.Divergences = append(result.Divergences, Divergence{Field: "exitCode", Recorded: fmt.Sprint(original.ExitCode), Replayed: fmt.Sprint(execution.ExitCode)})
+	}
+	if execution.Output != original.Output {
+		result.Divergences = append(result.Divergences, Divergence{Field: "output", Recorded: fingerprint(original.Output), Replayed: fingerprint(execution.Output)})
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("Replayed execution %d as %d: %s\n", original.ID, execution.ID, execution.Command))
+	if len(result.Divergences) == 0 {
+		text.WriteString("No divergences: same conditions, exit code and output.\n")
+	} else {
+		text.WriteString("Divergences:\n")
+		for _, d := range result.Divergences {
+			text.WriteString(fmt.Sprintf("- %s: %s, now %s\n", d.Field, d.Recorded, d.Replayed))
+		}
+	}
+	text.WriteString(fmt.Sprintf("\nExit code: %d\nOutput:\n%s", execution.ExitCode, execution.Output))
+	return jsonResult(text.String(), REPLAY_URI, result), nil
+}
+
+// divergenceFields names the fields that diverged
+func divergenceFields(divergences []Divergence) string {
+	var fields []string
+	for _, d := range divergences {
+		fields = append(fields, d.Field)
+	}
+	return strings.Join(fields, ", ")
+}
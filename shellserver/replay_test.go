@@ -0,0 +1,62 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayExecution(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.txt")
+	if err := os.WriteFile(file, []byte("yesterday\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewShellServer(WithAllowedCommands("echo,cat"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.closeAllSessions()
+	callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "echo same"})
+	callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "cat " + file})
+	callTool(t, s.handleStartSession, map[string]interface{}{})
+	callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "echo in-session", "session_id": "session-1"})
+
+	recent, _ := s.history.Recent(0)
+	for _, execution := range recent {
+		if execution.Context == nil || execution.Context.EnvFingerprint == "" || execution.Context.PolicyVersion == "" {
+			t.Errorf("execution %d has no context: %+v", execution.ID, execution.Context)
+		}
+	}
+	if err := os.WriteFile(file, []byte("today\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    map[string]interface{}
+		want    string
+		isError bool
+	}{
+		{"unchanged", map[string]interface{}{"id": float64(1), "strict": true}, "No divergences", false},
+		{"changed output", map[string]interface{}{"id": float64(2)}, "- output:", false},
+		{"session", map[string]interface{}{"id": float64(3)}, "whose state cannot be replayed", true},
+		{"unknown", map[string]interface{}{"id": float64(99)}, "Execution 99 is not in the recent history", true},
+		{"not an ID", map[string]interface{}{"id": "1"}, "'id' must be", true},
+	}
+	for _, tt := range tests {
+		text, isError := callTool(t, s.handleReplayExecution, tt.args)
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s: got %q (error %v), want %q (error %v)", tt.name, text, isError, tt.want, tt.isError)
+		}
+	}
+
+	s.policy.(*AllowlistPolicy).addRules(&PolicyRules{Deny: []string{"echo secret"}})
+	text, isError := callTool(t, s.handleReplayExecution, map[string]interface{}{"id": float64(1), "strict": true})
+	if !isError || !strings.Contains(text, "policy changed since it ran") {
+		t.Errorf("strict replay after a policy change = %q, want it refused", text)
+	}
+	if text, isError := callTool(t, s.handleReplayExecution, map[string]interface{}{"id": float64(1)}); isError || !strings.Contains(text, "- policy:") {
+		t.Errorf("replay after a policy change = %q, want it run with the divergence flagged", text)
+	}
+}
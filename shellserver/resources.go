@@ -0,0 +1,192 @@
+package shellserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// HISTORY_RESOURCE_URI is the resource listing the most recent commands.
+// Clients subscribed to it are notified whenever a command is recorded.
+const HISTORY_RESOURCE_URI = "history://recent"
+
+// Methods the vendored MCP server does not route, so Serve answers them
+const (
+	methodResourcesSubscribe   = "resources/subscribe"
+	methodResourcesUnsubscribe = "resources/unsubscribe"
+	methodResourcesUpdated     = "notifications/resources/updated"
+)
+
+// RecentHistory is the content of HISTORY_RESOURCE_URI
+type RecentHistory struct {
+	Total    int                `json:"total"`    // Executions in the history store the client may see
+	Commands []CommandExecution `json:"commands"` // Newest first
+}
+
+// registerResources adds the resources the shell tools offer
+func (s *ShellServer) registerResources(mcpServer *server.MCPServer) {
+	mcpServer.AddResource(mcp.NewResource(
+		HISTORY_RESOURCE_URI,
+		"Recent commands",
+		mcp.WithResourceDescription(fmt.Sprintf("The %d most recently executed commands, newest first, as list_recent_commands returns them. Subscribe to be notified of new executions.", MAX_HISTORY_SIZE)),
+		mcp.WithMIMEType("application/json"),
+	), s.handleReadHistory)
+}
+
+func (s *ShellServer) handleReadHistory(
+	ctx context.Context,
+	request mcp.ReadResourceRequest,
+) ([]mcp.ResourceContents, error) {
+	commands, total, err := s.tenantHistory(ctx, MAX_HISTORY_SIZE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command history: %v", err)
+	}
+	if commands == nil {
+		commands = []CommandExecution{}
+	}
+	data, err := json.MarshalIndent(RecentHistory{Total: total, Commands: commands}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return []mcp.ResourceContents{mcp.TextResourceContents{
+		URI:      HISTORY_RESOURCE_URI,
+		MIMEType: "application/json",
+		Text:     string(data),
+	}}, nil
+}
+
+// subscriptions holds the resources the stdio client subscribed to. The
+// vendored MCP server neither routes resources/subscribe nor can notify
+// clients outside a request, so Serve answers the subscription requests
+// before they reach it and writes the notifications itself.
+type subscriptions struct {
+	mutex sync.Mutex
+	uris  map[string]bool
+	out   *lockedWriter
+}
+
+// lockedWriter serializes the messages Serve and the MCP server write, so
+// notifications are never interleaved with responses
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.w.Write(p)
+}
+
+func newSubscriptions(out io.Writer) *subscriptions {
+	return &subscriptions{uris: make(map[string]bool), out: &lockedWriter{w: out}}
+}
+
+// writeMessage writes one JSON-RPC message as a line
+func (sub *subscriptions) writeMessage(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = sub.out.Write(append(data, '\n'))
+	return err
+}
+
+// handle answers line if it is a subscription request, and reports
+// whether it was one
+func (sub *subscriptions) handle(line []byte) bool {
+	var request struct {
+		ID     mcp.RequestId `json:"id"`
+		Method string        `json:"method"`
+		Params struct {
+			URI string `json:"uri"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(line, &request) != nil {
+		return false
+	}
+	if request.Method != methodResourcesSubscribe && request.Method != methodResourcesUnsubscribe {
+		return false
+	}
+
+	if request.Params.URI != HISTORY_RESOURCE_URI {
+		response := mcp.JSONRPCError{JSONRPC: mcp.JSONRPC_VERSION, ID: request.ID}
+		response.Error.Code = mcp.INVALID_PARAMS
+		response.Error.Message = fmt.Sprintf("resource '%s' cannot be subscribed to", request.Params.URI)
+		sub.writeMessage(response)
+		return true
+	}
+	sub.mutex.Lock()
+	if request.Method == methodResourcesSubscribe {
+		sub.uris[request.Params.URI] = true
+	} else {
+		delete(sub.uris, request.Params.URI)
+	}
+	sub.mutex.Unlock()
+	sub.writeMessage(mcp.JSONRPCResponse{JSONRPC: mcp.JSONRPC_VERSION, ID: request.ID, Result: struct{}{}})
+	return true
+}
+
+// updated notifies the client that the resource at uri changed, if it
+// subscribed to it
+func (sub *subscriptions) updated(uri string) error {
+	sub.mutex.Lock()
+	subscribed := sub.uris[uri]
+	sub.mutex.Unlock()
+	if !subscribed {
+		return nil
+	}
+	return sub.writeMessage(mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: methodResourcesUpdated,
+			Params: mcp.NotificationParams{AdditionalFields: map[string]interface{}{"uri": uri}},
+		},
+	})
+}
+
+// subscriptionFilter passes the client's messages on to the MCP server,
+// except the subscription requests it answers
+type subscriptionFilter struct {
+	sub     *subscriptions
+	reader  *bufio.Reader
+	pending []byte
+}
+
+func (f *subscriptionFilter) Read(p []byte) (int, error) {
+	for len(f.pending) == 0 {
+		line, err := f.reader.ReadBytes('\n')
+		if len(line) > 0 && !f.sub.handle(line) {
+			f.pending = line
+		}
+		if err != nil && len(f.pending) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// filter returns in without the subscription requests
+func (sub *subscriptions) filter(in io.Reader) io.Reader {
+	return &subscriptionFilter{sub: sub, reader: bufio.NewReader(in)}
+}
+
+// resourceUpdated notifies a subscribed client that the resource at uri
+// changed
+func (s *ShellServer) resourceUpdated(uri string) {
+	sub := s.subscriptions.Load()
+	if sub == nil {
+		return
+	}
+	if err := sub.updated(uri); err != nil {
+		s.logger.Printf("Failed to notify the client of an update to %s: %v", uri, err)
+	}
+}
@@ -0,0 +1,99 @@
+package shellserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryResourceSubscription(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	in, client := io.Pipe()
+	replies, out := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- s.serveStdio(context.Background(), in, out) }()
+	// The vendored stdio server is stopped by closing its input, since
+	// cancelling its context races with its reader
+	defer func() {
+		client.Close()
+		<-done
+	}()
+
+	lines := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(replies)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	// send writes a request and returns the messages written until its
+	// response, which comes last
+	send := func(id int, method string, params string) []string {
+		t.Helper()
+		if _, err := io.WriteString(client, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"%s","params":%s}`+"\n", id, method, params)); err != nil {
+			t.Fatalf("failed to send %s: %v", method, err)
+		}
+		var messages []string
+		for {
+			select {
+			case line := <-lines:
+				messages = append(messages, line)
+				if strings.Contains(line, fmt.Sprintf(`"id":%d,`, id)) {
+					return messages
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("no response to %s, got %q", method, messages)
+			}
+		}
+	}
+	const updated = `"method":"notifications/resources/updated","params":{"uri":"history://recent"}`
+	call := `{"name":"execute_command","arguments":{"command":"echo hello"}}`
+
+	send(1, "initialize", `{"protocolVersion":"2024-11-05","clientInfo":{"name":"test","version":"1"},"capabilities":{}}`)
+	if messages := send(2, "tools/call", call); strings.Contains(strings.Join(messages, "\n"), updated) {
+		t.Errorf("notified without a subscription: %q", messages)
+	}
+	if messages := send(3, "resources/subscribe", `{"uri":"exec://1/output"}`); !strings.Contains(messages[0], "cannot be subscribed to") {
+		t.Errorf("subscribing to an unknown resource = %q, want an error", messages)
+	}
+	if messages := send(4, "resources/subscribe", `{"uri":"history://recent"}`); messages[0] != `{"jsonrpc":"2.0","id":4,"result":{}}` {
+		t.Errorf("resources/subscribe = %q", messages)
+	}
+	if messages := send(5, "tools/call", call); !strings.Contains(messages[0], updated) {
+		t.Errorf("execute_command after subscribing = %q, want an update notification first", messages)
+	}
+
+	messages := send(6, "resources/read", `{"uri":"history://recent"}`)
+	var read struct {
+		Result struct {
+			Contents []struct {
+				MIMEType string `json:"mimeType"`
+				Text     string `json:"text"`
+			} `json:"contents"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(messages[0]), &read); err != nil || len(read.Result.Contents) != 1 {
+		t.Fatalf("resources/read = %q (%v)", messages, err)
+	}
+	var history RecentHistory
+	if err := json.Unmarshal([]byte(read.Result.Contents[0].Text), &history); err != nil {
+		t.Fatalf("history resource is not JSON: %v", err)
+	}
+	if history.Total != 2 || len(history.Commands) != 2 || history.Commands[0].Command != "echo hello" {
+		t.Errorf("history resource = %+v, want the two executions", history)
+	}
+
+	send(7, "resources/unsubscribe", `{"uri":"history://recent"}`)
+	if messages := send(8, "tools/call", call); strings.Contains(strings.Join(messages, "\n"), updated) {
+		t.Errorf("notified after unsubscribing: %q", messages)
+	}
+}
@@ -0,0 +1,175 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RETENTION_INTERVAL is the default time between runs of the retention job
+const RETENTION_INTERVAL = 24 * time.Hour
+
+// RetentionDiagnostics counts what the retention job removed since the
+// server started, and the space that freed
+type RetentionDiagnostics struct {
+	LastRun        time.Time `json:"lastRun,omitempty"`
+	HistoryEntries int       `json:"historyEntries"` // Executions pruned from the history
+	HistoryBytes   int64     `json:"historyBytes"`
+	AuditFiles     int       `json:"auditFiles"` // Rotated audit files deleted
+	AuditBytes     int64     `json:"auditBytes"`
+	Recordings     int       `json:"recordings"` // Session recordings deleted
+	RecordingBytes int64     `json:"recordingBytes"`
+}
+
+// retentionJob periodically enforces the storage retention, so a server
+// that runs for months does not fill its disk with history, audit logs and
+// recordings
+type retentionJob struct {
+	stop  chan struct{} // Closed by Close; nil when no retention is set
+	mutex sync.Mutex
+	stats RetentionDiagnostics
+}
+
+// WithStorageRetention removes what the server stored more than age ago,
+// every retention interval: executions from the history, if its store
+// can prune (see HistoryPruner), audit files rotated away from the paths of
+// file notifiers, and session recordings. Zero, the default, keeps
+// everything.
+func WithStorageRetention(age time.Duration) Option {
+	return func(s *ShellServer) error {
+		if age < 0 {
+			return fmt.Errorf("storage retention must not be negative, got %s", age)
+		}
+		s.retention = age
+		return nil
+	}
+}
+
+// WithRetentionInterval sets how often the storage retention is enforced;
+// the first run is one interval after the server starts
+func WithRetentionInterval(interval time.Duration) Option {
+	return func(s *ShellServer) error {
+		if interval <= 0 {
+			return fmt.Errorf("retention interval must be positive, got %s", interval)
+		}
+		s.retentionInterval = interval
+		return nil
+	}
+}
+
+// runRetention enforces the retention every retentionInterval until stop
+// is closed
+func (s *ShellServer) runRetention(stop chan struct{}) {
+	ticker := time.NewTicker(s.retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.enforceRetention()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// enforceRetention removes what is older than the retention once, logs what it
+// freed and returns it
+func (s *ShellServer) enforceRetention() RetentionDiagnostics {
+	cutoff := s.now().Add(-s.retention)
+	var run RetentionDiagnostics
+	if pruner, ok := s.history.(HistoryPruner); ok {
+		removed, reclaimed, err := pruner.Prune(cutoff)
+		if err != nil {
+			s.logger.Printf("Failed to prune the history: %v", err)
+		}
+		run.HistoryEntries, run.HistoryBytes = removed, reclaimed
+	}
+	for _, path := range s.auditFiles() {
+		matches, _ := filepath.Glob(path + ".*")
+		dashed, _ := filepath.Glob(path + "-*")
+		for _, match := range append(matches, dashed...) {
+			if match == path+PARTIAL_SUFFIX {
+				continue // Salvaged lines are kept for inspection
+			}
+			if size, ok := removeIfOlder(match, cutoff); ok {
+				run.AuditFiles++
+				run.AuditBytes += size
+			}
+		}
+	}
+	if s.recordDir != "" {
+		matches, _ := filepath.Glob(filepath.Join(s.recordDir, "*.cast"))
+		for _, match := range matches {
+			if size, ok := removeIfOlder(match, cutoff); ok {
+				run.Recordings++
+				run.RecordingBytes += size
+			}
+		}
+	}
+
+	if run.HistoryEntries > 0 {
+		s.logger.Printf("Retention pruned %d executions older than %s from the history, freeing %s", run.HistoryEntries, s.retention, formatByteSize(run.HistoryBytes))
+	}
+	if run.AuditFiles > 0 {
+		s.logger.Printf("Retention deleted %d rotated audit files, freeing %s", run.AuditFiles, formatByteSize(run.AuditBytes))
+	}
+	if run.Recordings > 0 {
+		s.logger.Printf("Retention deleted %d recordings, freeing %s", run.Recordings, formatByteSize(run.RecordingBytes))
+	}
+
+	run.LastRun = s.now()
+	s.retentionJob.mutex.Lock()
+	defer s.retentionJob.mutex.Unlock()
+	stats := &s.retentionJob.stats
+	stats.LastRun = run.LastRun
+	stats.HistoryEntries += run.HistoryEntries
+	stats.HistoryBytes += run.HistoryBytes
+	stats.AuditFiles += run.AuditFiles
+	stats.AuditBytes += run.AuditBytes
+	stats.Recordings += run.Recordings
+	stats.RecordingBytes += run.RecordingBytes
+	return run
+}
+
+// retentionStats returns what the retention job removed so far
+func (s *ShellServer) retentionStats() RetentionDiagnostics {
+	s.retentionJob.mutex.Lock()
+	defer s.retentionJob.mutex.Unlock()
+	return s.retentionJob.stats
+}
+
+// auditFiles returns the paths file notifiers append events to. The live
+// files are never trimmed, which would break their hash chains; log
+// rotation moves them aside, as <path>.1 or <path>-20060102, and
+// the retention job deletes those.
+func (s *ShellServer) auditFiles() []string {
+	var paths []string
+	for _, notifier := range s.notifiers {
+		for {
+			filtered, ok := notifier.(*filteredNotifier)
+			if !ok {
+				break
+			}
+			notifier = filtered.notifier
+		}
+		if file, ok := notifier.(*fileNotifier); ok {
+			paths = append(paths, file.file.Name())
+		}
+	}
+	return paths
+}
+
+// removeIfOlder removes the regular file at path if it was last modified
+// before cutoff, and returns its size
+func removeIfOlder(path string, cutoff time.Time) (int64, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+		return 0, false
+	}
+	if os.Remove(path) != nil {
+		return 0, false
+	}
+	return info.Size(), true
+}
@@ -0,0 +1,154 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLHistoryPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := newJSONLHistory(path, 3)
+	if err != nil {
+		t.Fatalf("newJSONLHistory failed: %v", err)
+	}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		start := now.Add(time.Duration(i-5) * time.Hour)
+		store.Add(CommandExecution{Command: fmt.Sprintf("command%d", i), StartTime: start})
+	}
+	before, _ := os.Stat(path)
+
+	removed, reclaimed, err := store.Prune(now.Add(-150 * time.Minute))
+	if err != nil || removed != 3 {
+		t.Fatalf("Prune() = (%d, %v), want 3 removed", removed, err)
+	}
+	after, _ := os.Stat(path)
+	if reclaimed != before.Size()-after.Size() || reclaimed <= 0 {
+		t.Errorf("reclaimed %d bytes, want %d", reclaimed, before.Size()-after.Size())
+	}
+	if count, _ := store.Count(); count != 2 {
+		t.Errorf("Count() = %d, want 2", count)
+	}
+	if recent, _ := store.Recent(0); len(recent) != 2 || recent[1].Command != "command3" {
+		t.Errorf("Recent(0) = %+v, want command4 and command3", recent)
+	}
+
+	// The file is still appended to, and holds only what was kept
+	store.Add(CommandExecution{Command: "command5", StartTime: now})
+	if removed, _, err := store.Prune(now.Add(-150 * time.Minute)); removed != 0 || err != nil {
+		t.Errorf("second Prune() = (%d, %v), want nothing removed", removed, err)
+	}
+	store.file.Close()
+	reopened, err := newJSONLHistory(path, 10)
+	if err != nil {
+		t.Fatalf("reopening history failed: %v", err)
+	}
+	defer reopened.file.Close()
+	recent, _ := reopened.Recent(0)
+	var commands []string
+	for _, execution := range recent {
+		commands = append(commands, execution.Command)
+	}
+	if strings.Join(commands, ",") != "command5,command4,command3" {
+		t.Errorf("history after reopening = %v, want command5,command4,command3", commands)
+	}
+}
+
+func TestMemoryHistoryPrune(t *testing.T) {
+	h := newMemoryHistory(3)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		h.Add(CommandExecution{Command: fmt.Sprintf("command%d", i), StartTime: now.Add(time.Duration(i) * time.Minute)})
+	}
+	if removed, _, _ := h.Prune(now.Add(3 * time.Minute)); removed != 1 {
+		t.Errorf("Prune() removed %d, want 1", removed)
+	}
+	h.Add(CommandExecution{Command: "command5"})
+	recent, _ := h.Recent(0)
+	if len(recent) != 3 || recent[0].Command != "command5" || recent[2].Command != "command3" {
+		t.Errorf("Recent(0) = %+v, want command5..command3", recent)
+	}
+}
+
+func TestEnforceRetention(t *testing.T) {
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	recordDir := filepath.Join(dir, "recordings")
+	os.Mkdir(recordDir, 0700)
+	history, err := newJSONLHistory(filepath.Join(dir, "history.jsonl"), 10)
+	if err != nil {
+		t.Fatalf("newJSONLHistory failed: %v", err)
+	}
+	notifier, err := ParseNotifier("file:"+auditPath+" denial", "")
+	if err != nil {
+		t.Fatalf("ParseNotifier failed: %v", err)
+	}
+	s, err := NewShellServer(WithHistoryStore(history), WithNotifier(notifier), WithRecordDir(recordDir),
+		WithStorageRetention(24*time.Hour), WithRetentionInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+
+	old := time.Now().Add(-48 * time.Hour)
+	history.Add(CommandExecution{Command: "old", StartTime: old})
+	history.Add(CommandExecution{Command: "new", StartTime: time.Now()})
+	tests := []struct {
+		path    string
+		modTime time.Time
+		removed bool
+	}{
+		{auditPath + ".1.gz", old, true},
+		{auditPath + "-20260101", old, true},
+		{auditPath + ".2", time.Now(), false},
+		{auditPath + PARTIAL_SUFFIX, old, false},
+		{filepath.Join(dir, "other.jsonl.1"), old, false},
+		{filepath.Join(recordDir, "old-session-1.cast"), old, true},
+		{filepath.Join(recordDir, "new-session-2.cast"), time.Now(), false},
+	}
+	for _, test := range tests {
+		os.WriteFile(test.path, []byte("12345"), 0600)
+		os.Chtimes(test.path, test.modTime, test.modTime)
+	}
+	os.Chtimes(auditPath, old, old)
+
+	run := s.enforceRetention()
+	if run.HistoryEntries != 1 || run.AuditFiles != 2 || run.AuditBytes != 10 || run.Recordings != 1 || run.RecordingBytes != 5 {
+		t.Errorf("enforceRetention() = %+v, want 1 history entry, 2 audit files and 1 recording removed", run)
+	}
+	for _, test := range tests {
+		_, err := os.Stat(test.path)
+		if removed := os.IsNotExist(err); removed != test.removed {
+			t.Errorf("%s removed = %v, want %v", filepath.Base(test.path), removed, test.removed)
+		}
+	}
+	if _, err := os.Stat(auditPath); err != nil {
+		t.Errorf("the live audit file was removed: %v", err)
+	}
+
+	diagnostics := s.diagnostics(false)
+	if diagnostics.Retention == nil || diagnostics.Retention.HistoryEntries != 1 || diagnostics.Retention.LastRun.IsZero() {
+		t.Errorf("diagnostics = %+v, want the retention run counted", diagnostics.Retention)
+	}
+}
+
+func TestRetentionOptions(t *testing.T) {
+	if _, err := NewShellServer(WithStorageRetention(-time.Hour)); err == nil {
+		t.Errorf("a negative retention was accepted")
+	}
+	if _, err := NewShellServer(WithRetentionInterval(0)); err == nil {
+		t.Errorf("a zero retention interval was accepted")
+	}
+	s, err := NewShellServer()
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	if s.diagnostics(false).Retention != nil {
+		t.Errorf("diagnostics report a retention job that does not run")
+	}
+}
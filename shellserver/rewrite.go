@@ -0,0 +1,83 @@
+package shellserver
+
+import (
+	"strings"
+)
+
+// structuredRewrite describes how to ask a known tool for machine-readable output
+type structuredRewrite struct {
+	command    string   // Base command, e.g. "git"
+	subcommand string   // Optional first argument that must match, e.g. "status"
+	flags      []string // Flags that request structured output
+	prepend    bool     // Insert flags right after the base command instead of appending
+	skipIf     []string // Arguments that mean the caller already chose an output format
+}
+
+// structuredRewrites lists the tools the rewrite layer knows about
+var structuredRewrites = []structuredRewrite{
+	{command: "git", subcommand: "status", flags: []string{"--porcelain=v1"}, skipIf: []string{"--porcelain", "-s", "--short", "--long"}},
+	{command: "git", subcommand: "log", flags: []string{"--format=%H%x09%an%x09%aI%x09%s"}, skipIf: []string{"--format", "--pretty", "--oneline"}},
+	{command: "kubectl", subcommand: "get", flags: []string{"-o", "json"}, skipIf: []string{"-o", "--output"}},
+	{command: "docker", subcommand: "ps", flags: []string{"--format", "'{{json .}}'"}, skipIf: []string{"--format", "-q", "--quiet"}},
+	{command: "docker", subcommand: "images", flags: []string{"--format", "'{{json .}}'"}, skipIf: []string{"--format", "-q", "--quiet"}},
+	{command: "ip", flags: []string{"-json"}, prepend: true, skipIf: []string{"-j", "-json", "--json"}},
+	{command: "lsblk", flags: []string{"--json"}, skipIf: []string{"-J", "--json"}},
+	{command: "findmnt", flags: []string{"--json"}, skipIf: []string{"-J", "--json"}},
+}
+
+// structuredEnv is added to the environment so output does not depend on the host locale
+var structuredEnv = []string{"LC_ALL=C", "LANG=C"}
+
+// rewriteForStructuredOutput appends machine-readable output flags to known tools.
+// Only simple commands are rewritten; anything containing shell operators is
+// returned unchanged since the flags could end up on the wrong command.
+func rewriteForStructuredOutput(command string) (string, bool) {
+	if strings.ContainsAny(command, "|;&<>`$()\n") {
+		return command, false
+	}
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return command, false
+	}
+
+	for _, rule := range structuredRewrites {
+		if fields[0] != rule.command {
+			continue
+		}
+		if rule.subcommand != "" && (len(fields) < 2 || fields[1] != rule.subcommand) {
+			continue
+		}
+		if hasAnyFlag(fields[1:], rule.skipIf) {
+			return command, false
+		}
+
+		// Splice the flags into the original text so quoting is preserved
+		flags := strings.Join(rule.flags, " ")
+		trimmed := strings.TrimSpace(command)
+		if rule.prepend {
+			rest := strings.TrimPrefix(trimmed, fields[0])
+			return fields[0] + " " + flags + rest, true
+		}
+		return trimmed + " " + flags, true
+	}
+
+	return command, false
+}
+
+// hasAnyFlag reports whether any argument matches one of the given flags,
+// either exactly, in its --flag=value form, or as a short flag with an
+// attached value such as -ojson
+func hasAnyFlag(args []string, flags []string) bool {
+	for _, arg := range args {
+		for _, flag := range flags {
+			if arg == flag || strings.HasPrefix(arg, flag+"=") {
+				return true
+			}
+			if len(flag) == 2 && flag[0] == '-' && strings.HasPrefix(arg, flag) {
+				return true
+			}
+		}
+	}
+	return false
+}
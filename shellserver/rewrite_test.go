@@ -0,0 +1,32 @@
+package shellserver
+
+import "testing"
+
+func TestRewriteForStructuredOutput(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected string
+		changed  bool
+	}{
+		{"git status", "git status --porcelain=v1", true},
+		{"git status -s", "git status -s", false},
+		{"git status --porcelain=v2", "git status --porcelain=v2", false},
+		{"kubectl get pods", "kubectl get pods -o json", true},
+		{"kubectl get pods -owide", "kubectl get pods -owide", false},
+		{"ip addr show", "ip -json addr show", true},
+		{"ip -j addr", "ip -j addr", false},
+		{"lsblk", "lsblk --json", true},
+		{"git diff", "git diff", false},
+		{"git status | head", "git status | head", false},
+		{"ls -la", "ls -la", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		result, changed := rewriteForStructuredOutput(test.command)
+		if result != test.expected || changed != test.changed {
+			t.Errorf("rewriteForStructuredOutput(%q) = (%q, %v), want (%q, %v)",
+				test.command, result, changed, test.expected, test.changed)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Sandbox restricts the server process itself once it is configured, so a
+// bug in the server cannot reach more of the system than it needs. Children
+// are governed by the command policy and process control, not the sandbox.
+type Sandbox interface {
+	// Name identifies the sandbox in logs, e.g. "pledge"
+	Name() string
+	// Restrict confines the process to paths, which maps each path to the
+	// access the server needs: any of "r", "w", "x" and "c" (create)
+	Restrict(paths map[string]string) error
+}
+
+// sandboxChain applies several sandboxes in order
+type sandboxChain []Sandbox
+
+// Name joins the names of the sandboxes
+func (c sandboxChain) Name() string {
+	names := make([]string, len(c))
+	for i, sandbox := range c {
+		names[i] = sandbox.Name()
+	}
+	return strings.Join(names, " and ")
+}
+
+// Restrict applies each sandbox, stopping at the first that fails
+func (c sandboxChain) Restrict(paths map[string]string) error {
+	for _, sandbox := range c {
+		if err := sandbox.Restrict(paths); err != nil {
+			return fmt.Errorf("%s: %v", sandbox.Name(), err)
+		}
+	}
+	return nil
+}
+
+// sandboxPaths lists the files and directories the server needs after
+// startup. Files that stay open, such as the notifier logs, remain usable
+// and are not listed. Files that are replaced or created later, such as the
+// JSONL history when cleared and the policy edits, are granted with their
+// directory.
+func (s *ShellServer) sandboxPaths() map[string]string {
+	paths := map[string]string{}
+	grant := func(path string, access string) {
+		if path == "" {
+			return
+		}
+		for _, c := range access {
+			if !strings.ContainsRune(paths[path], c) {
+				paths[path] += string(c)
+			}
+		}
+	}
+
+	// Commands, shells and helpers such as tmux are found on PATH
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		grant(dir, "rx")
+	}
+	if s.toolManifest != nil {
+		grant(s.toolManifest.Dir, "rx")
+	}
+	grant("/dev/null", "rw")
+	grant("/etc", "r")      // Name resolution, time zones and TLS roots
+	grant(s.tempDir, "rwc") // Registry credentials and SSH control sockets
+	// output_image, fetch_url's save_to and archives work in the workspaces,
+	// and rm moves files from them into the trash and restore_file back
+	for _, root := range s.workRoots() {
+		grant(root, "rwc")
+	}
+	if s.trash != nil {
+		grant(s.trash.dir, "rwc")
+	}
+	if s.recordDir != "" {
+		grant(s.recordDir, "rwc")
+	}
+	if s.snapshotDir != "" {
+		grant(s.snapshotDir, "rwc")
+	}
+	if path := historyFile(s.history); path != "" {
+		// SQLite also creates its journal next to the database
+		grant(filepath.Dir(path), "rwc")
+	}
+	if s.retention > 0 {
+		// The retention job deletes rotated audit files
+		for _, path := range s.auditFiles() {
+			grant(filepath.Dir(path), "rwc")
+		}
+	}
+	if s.policyEdits != "" {
+		grant(filepath.Dir(s.policyEdits), "rwc")
+	}
+	if s.targets != nil {
+		// ssh reads its configuration, keys and known hosts, and sandboxes
+		// that carry over to commands must let it
+		if home, err := os.UserHomeDir(); err == nil {
+			grant(filepath.Join(home, ".ssh"), "r")
+		}
+	}
+	if s.discovery != nil {
+		for _, source := range s.discovery.sources {
+			if registry, ok := source.(registryFile); ok {
+				// Registries are rewritten, so grant their directory
+				grant(filepath.Dir(registry.path), "r")
+			}
+		}
+	}
+	return paths
+}
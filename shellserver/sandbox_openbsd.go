@@ -0,0 +1,102 @@
+//go:build openbsd && (amd64 || arm64)
+
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// pledgeSandbox restricts the server with OpenBSD's unveil(2) and pledge(2).
+// Neither carries over to commands: unveil is reset by exec and the server
+// passes no execpromises, so children are limited by the command policy.
+type pledgeSandbox struct{}
+
+// PlatformSandbox returns the sandbox for this platform: unveil and pledge
+// on OpenBSD
+func PlatformSandbox() (Sandbox, error) {
+	return &pledgeSandbox{}, nil
+}
+
+// Name identifies the sandbox
+func (p *pledgeSandbox) Name() string {
+	return "unveil and pledge"
+}
+
+// Restrict unveils paths, locks the unveil list and pledges the promises
+// the server needs to run commands and reach webhooks and mail servers
+func (p *pledgeSandbox) Restrict(paths map[string]string) error {
+	names := make([]string, 0, len(paths))
+	for path := range paths {
+		names = append(names, path)
+	}
+	sort.Strings(names)
+	for _, path := range names {
+		if err := unveil(path, paths[path]); err != nil && err != syscall.ENOENT {
+			return fmt.Errorf("unveil %s: %v", path, err)
+		}
+	}
+	if err := unveil("", ""); err != nil {
+		return fmt.Errorf("failed to lock unveil: %v", err)
+	}
+
+	promises := []string{"stdio", "rpath", "wpath", "cpath", "fattr", "proc", "exec", "inet", "dns", "unix"}
+	if os.Geteuid() == 0 {
+		// Children started with WithRunAs change credentials before exec
+		promises = append(promises, "id")
+	}
+	if err := pledge(strings.Join(promises, " ")); err != nil {
+		return fmt.Errorf("pledge: %v", err)
+	}
+	return nil
+}
+
+// unveil calls unveil(2); empty arguments lock the list
+func unveil(path string, permissions string) error {
+	var pathPtr, permissionsPtr *byte
+	if path != "" {
+		var err error
+		if pathPtr, err = syscall.BytePtrFromString(path); err != nil {
+			return err
+		}
+		if permissionsPtr, err = syscall.BytePtrFromString(permissions); err != nil {
+			return err
+		}
+	}
+	_, _, errno := syscall_syscall(libc_unveil_trampoline_addr, uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(permissionsPtr)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// pledge calls pledge(2) with no execpromises, leaving children unrestricted
+func pledge(promises string) error {
+	promisesPtr, err := syscall.BytePtrFromString(promises)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall_syscall(libc_pledge_trampoline_addr, uintptr(unsafe.Pointer(promisesPtr)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// OpenBSD only permits system calls from libc, so pledge and unveil are
+// called through libc trampolines, as golang.org/x/sys/unix does
+
+//go:linkname syscall_syscall syscall.syscall
+func syscall_syscall(fn, a1, a2, a3 uintptr) (r1, r2 uintptr, err syscall.Errno)
+
+var libc_pledge_trampoline_addr uintptr
+
+//go:cgo_import_dynamic libc_pledge pledge "libc.so"
+
+var libc_unveil_trampoline_addr uintptr
+
+//go:cgo_import_dynamic libc_unveil unveil "libc.so"
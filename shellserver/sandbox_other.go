@@ -0,0 +1,14 @@
+//go:build !(openbsd && (amd64 || arm64)) && !(linux && (amd64 || arm64))
+
+package shellserver
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// PlatformSandbox returns the sandbox for this platform. There is none
+// here, so hardening is refused rather than silently skipped.
+func PlatformSandbox() (Sandbox, error) {
+	return nil, fmt.Errorf("no server sandbox is available on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
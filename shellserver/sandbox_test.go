@@ -0,0 +1,68 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSandbox records the paths it was asked to restrict to
+type fakeSandbox struct {
+	paths map[string]string
+	err   error
+}
+
+func (f *fakeSandbox) Name() string {
+	return "fake"
+}
+
+func (f *fakeSandbox) Restrict(paths map[string]string) error {
+	f.paths = paths
+	return f.err
+}
+
+func TestWithSandbox(t *testing.T) {
+	recordDir := t.TempDir()
+	historyDir := t.TempDir()
+	history, err := ParseHistoryStore("jsonl:" + filepath.Join(historyDir, "history.jsonl"))
+	if err != nil {
+		t.Fatalf("ParseHistoryStore failed: %v", err)
+	}
+	sandbox := &fakeSandbox{}
+	if _, err := NewShellServer(WithSandbox(sandbox), WithRecordDir(recordDir), WithHistoryStore(history)); err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	wd, _ := os.Getwd()
+	want := map[string]string{"/dev/null": "rw", "/etc": "r", wd: "rwc", recordDir: "rwc", historyDir: "rwc"}
+	for path, access := range want {
+		if sandbox.paths[path] != access {
+			t.Errorf("sandbox access to %s = %q, want %q", path, sandbox.paths[path], access)
+		}
+	}
+	if !strings.Contains(sandbox.paths["/usr/bin"], "x") && !strings.Contains(sandbox.paths["/bin"], "x") {
+		t.Errorf("sandbox paths %v do not allow running commands from PATH", sandbox.paths)
+	}
+
+	_, err = NewShellServer(WithSandbox(&fakeSandbox{err: fmt.Errorf("not permitted")}))
+	if err == nil || !strings.Contains(err.Error(), "failed to apply the fake sandbox: not permitted") {
+		t.Errorf("NewShellServer with a failing sandbox error = %v", err)
+	}
+}
+
+func TestSandboxChain(t *testing.T) {
+	first, second := &fakeSandbox{}, &fakeSandbox{}
+	if _, err := NewShellServer(WithSandbox(first), WithSandbox(second)); err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if first.paths == nil || second.paths == nil {
+		t.Errorf("not every sandbox was applied")
+	}
+
+	_, err := NewShellServer(WithSandbox(&fakeSandbox{}), WithSandbox(&fakeSandbox{err: fmt.Errorf("not permitted")}))
+	if err == nil || !strings.Contains(err.Error(), "failed to apply the fake and fake sandbox: fake: not permitted") {
+		t.Errorf("NewShellServer with a failing sandbox in a chain error = %v", err)
+	}
+}
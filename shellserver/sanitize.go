@@ -0,0 +1,132 @@
+package shellserver
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// A command shown to a human for approval must read as what it runs.
+// Bidirectional controls can make 'rm -rf ~ #' display as a harmless
+// comment, zero-width characters can split a word the allowlist matches
+// from the word the human sees, and a carriage return or escape sequence
+// can overwrite a line in a terminal. Commands with such characters are
+// refused, and wherever a command is shown to a human, they are escaped.
+
+// hiddenCharacterNames names the characters that can disguise a command
+var hiddenCharacterNames = map[rune]string{
+	'\u00ad': "a soft hyphen",
+	'\u061c': "an Arabic letter mark",
+	'\u180e': "a Mongolian vowel separator",
+	'\u200b': "a zero-width space",
+	'\u200c': "a zero-width non-joiner",
+	'\u200d': "a zero-width joiner",
+	'\u200e': "a left-to-right mark",
+	'\u200f': "a right-to-left mark",
+	'\u202a': "a left-to-right embedding",
+	'\u202b': "a right-to-left embedding",
+	'\u202c': "a pop directional formatting",
+	'\u202d': "a left-to-right override",
+	'\u202e': "a right-to-left override",
+	'\u2060': "a word joiner",
+	'\u2061': "an invisible function application",
+	'\u2062': "an invisible times",
+	'\u2063': "an invisible separator",
+	'\u2064': "an invisible plus",
+	'\u2066': "a left-to-right isolate",
+	'\u2067': "a right-to-left isolate",
+	'\u2068': "a first strong isolate",
+	'\u2069': "a pop directional isolate",
+	'\ufeff': "a zero-width no-break space",
+}
+
+// hiddenCharacter describes r if it is invisible or a control character
+// other than tab and newline, or returns "" if it is shown as it is
+func hiddenCharacter(r rune) string {
+	switch {
+	case r == 0:
+		return "a NUL byte"
+	case r == '\r':
+		return "a carriage return"
+	case r == '\x1b':
+		return "an escape"
+	case r < ' ' && r != '\t' && r != '\n', r >= 0x7F && r < 0xA0:
+		return "a control character"
+	}
+	return hiddenCharacterNames[r]
+}
+
+// findHiddenCharacter returns the first hidden character of a command, or
+// invalid byte, with its description and byte offset, or "" if there is
+// none. With invisible, only control characters and invalid bytes count.
+func findHiddenCharacter(command string, invisible bool) (string, string, int) {
+	for offset, r := range command {
+		if invalidByte(command, offset, r) {
+			return fmt.Sprintf("\\x%02x", command[offset]), "invalid UTF-8", offset
+		}
+		if _, named := hiddenCharacterNames[r]; named && invisible {
+			continue
+		}
+		if description := hiddenCharacter(r); description != "" {
+			return fmt.Sprintf("U+%04X", r), description, offset
+		}
+	}
+	return "", "", 0
+}
+
+// invalidByte reports whether the rune r at offset is an invalid byte
+// rather than a U+FFFD written out
+func invalidByte(command string, offset int, r rune) bool {
+	if r != utf8.RuneError {
+		return false
+	}
+	_, size := utf8.DecodeRuneInString(command[offset:])
+	return size == 1
+}
+
+// escapeHidden shows a command's hidden characters as \u{202e} escapes, and
+// invalid bytes as \x escapes, leaving the rest as written
+func escapeHidden(command string) string {
+	if character, _, _ := findHiddenCharacter(command, false); character == "" {
+		return command
+	}
+	var escaped strings.Builder
+	for offset, r := range command {
+		switch {
+		case invalidByte(command, offset, r):
+			fmt.Fprintf(&escaped, "\\x%02x", command[offset])
+		case hiddenCharacter(r) != "":
+			fmt.Fprintf(&escaped, "\\u{%x}", r)
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
+
+// WithInvisibleCharacters lets commands contain invisible characters, such
+// as the zero-width joiners of emoji or the marks of right-to-left text.
+// Control characters and invalid UTF-8 are still refused, and approvals
+// show the invisible characters escaped.
+func WithInvisibleCharacters() Option {
+	return func(s *ShellServer) error {
+		s.allowInvisible = true
+		return nil
+	}
+}
+
+// checkHiddenCharacters refuses a command, or another argument that
+// reaches a shell, with hidden characters and logs it with them escaped
+func (s *ShellServer) checkHiddenCharacters(name string, argument string, command string) *ToolError {
+	character, description, offset := findHiddenCharacter(command, s.allowInvisible)
+	if character == "" {
+		return nil
+	}
+	s.logger.Printf("Refused %s call with %s in its %s: %s", name, description, argument, escapeHidden(command))
+	return &ToolError{
+		Code:    ERROR_INVALID_ARGUMENT,
+		Message: s.message(MSG_HIDDEN_CHARACTER, description, character, offset),
+		Details: map[string]interface{}{"argument": argument, "character": character, "offset": offset, "tool": name},
+		Hint:    s.message(MSG_REMOVE_HIDDEN),
+	}
+}
@@ -0,0 +1,67 @@
+package shellserver
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestFindHiddenCharacter(t *testing.T) {
+	tests := []struct {
+		command     string
+		invisible   bool
+		want        string
+		wantOffset  int
+		wantEscaped string
+	}{
+		{"ls -la\tsrc\nwc -l", false, "", 0, "ls -la\tsrc\nwc -l"},
+		{"echo héllo 日本", false, "", 0, "echo héllo 日本"},
+		{"echo \ufffd", false, "", 0, "echo \ufffd"},
+		{"ls\x00 -la", false, "U+0000", 2, "ls\\u{0} -la"},
+		{"rm -rf ~ #\u202e txt.sh", false, "U+202E", 10, "rm -rf ~ #\\u{202e} txt.sh"},
+		{"r\u200bm -rf /", false, "U+200B", 1, "r\\u{200b}m -rf /"},
+		{"echo ok\rrm -rf /", false, "U+000D", 7, "echo ok\\u{d}rm -rf /"},
+		{"echo \x1b[2K", false, "U+001B", 5, "echo \\u{1b}[2K"},
+		{"echo \xff", false, "\\xff", 5, "echo \\xff"},
+		{"echo 👨\u200d👩", true, "", 0, "echo 👨\\u{200d}👩"},
+		{"echo \u200d\x07", true, "U+0007", 8, "echo \\u{200d}\\u{7}"},
+	}
+
+	for _, tt := range tests {
+		character, _, offset := findHiddenCharacter(tt.command, tt.invisible)
+		if character != tt.want || offset != tt.wantOffset {
+			t.Errorf("findHiddenCharacter(%q) = %s at %d, want %s at %d", tt.command, character, offset, tt.want, tt.wantOffset)
+		}
+		if escaped := escapeHidden(tt.command); escaped != tt.wantEscaped {
+			t.Errorf("escapeHidden(%q) = %q, want %q", tt.command, escaped, tt.wantEscaped)
+		}
+	}
+}
+
+func TestHiddenCharactersRefused(t *testing.T) {
+	var logged bytes.Buffer
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithLogger(log.New(&logged, "", 0)))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	execute := s.authorized("execute_command", s.handleExecuteCommand)
+
+	text, isError := callTool(t, execute, map[string]interface{}{"command": "echo safe\u202e"})
+	if !isError || !strings.Contains(text, "a right-to-left override (U+202E) at byte 9") {
+		t.Errorf("execute_command with a bidi override = %q (error %v)", text, isError)
+	}
+	if !strings.Contains(logged.String(), `echo safe\u{202e}`) {
+		t.Errorf("log = %q, want the command escaped", logged.String())
+	}
+
+	// Emoji may be allowed, but control characters never are
+	s, _ = NewShellServer(WithAllowedCommands("echo"), WithInvisibleCharacters())
+	execute = s.authorized("execute_command", s.handleExecuteCommand)
+	if text, isError := callTool(t, execute, map[string]interface{}{"command": "echo 👨\u200d👩"}); isError || !strings.Contains(text, "👨\u200d👩") {
+		t.Errorf("execute_command with a zero-width joiner = %q (error %v)", text, isError)
+	}
+	if text, isError := callTool(t, execute, map[string]interface{}{"command": "echo ok\rrm"}); !isError || !strings.Contains(text, "carriage return") {
+		t.Errorf("execute_command with a carriage return = %q (error %v)", text, isError)
+	}
+}
@@ -0,0 +1,170 @@
+package shellserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Scrubbing profiles, from least to most masked
+const (
+	SCRUB_OFF      = "off"      // Output is returned as is
+	SCRUB_STANDARD = "standard" // Home directories become ~, user and host names are masked
+	SCRUB_STRICT   = "strict"   // Standard, plus IP, MAC and email addresses are masked
+)
+
+// Placeholders for scrubbed values
+const (
+	SCRUBBED_USER  = "<user>"
+	SCRUBBED_HOST  = "<host>"
+	SCRUBBED_IP    = "<ip>"
+	SCRUBBED_MAC   = "<mac>"
+	SCRUBBED_EMAIL = "<email>"
+)
+
+// Patterns of the strict profile
+var (
+	macPattern   = regexp.MustCompile(`\b(?:[0-9A-Fa-f]{2}[:-]){5}[0-9A-Fa-f]{2}\b`)
+	ipv4Pattern  = regexp.MustCompile(`\b(?:[0-9]{1,3}\.){3}[0-9]{1,3}\b`)
+	ipv6Pattern  = regexp.MustCompile(`\b(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}\b`)
+	emailPattern = regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)
+)
+
+// machineIdentity is what scrubbing hides about the machine
+type machineIdentity struct {
+	homes []string // Home directories of the server's user and of --run-as
+	users []string
+	hosts []string // Full and short host name
+}
+
+// scrubRule replaces every match of pattern with replace(match)
+type scrubRule struct {
+	pattern *regexp.Regexp
+	replace func(match string) string
+}
+
+// WithScrubbing masks what command output reveals about the machine before
+// it is returned, recorded in history or sent to notifiers: SCRUB_STANDARD
+// or SCRUB_STRICT, or SCRUB_OFF to keep output as is
+func WithScrubbing(profile string) Option {
+	return func(s *ShellServer) error {
+		switch profile {
+		case SCRUB_OFF, SCRUB_STANDARD, SCRUB_STRICT:
+			s.scrubProfile = profile
+			return nil
+		}
+		return fmt.Errorf("unknown scrubbing profile '%s', expected one of: %s, %s, %s", profile, SCRUB_OFF, SCRUB_STANDARD, SCRUB_STRICT)
+	}
+}
+
+// machineIdentity collects the home directories, user names and host names
+// commands may print
+func (s *ShellServer) machineIdentity() machineIdentity {
+	var identity machineIdentity
+	accounts := []*user.User{}
+	if current, err := user.Current(); err == nil {
+		accounts = append(accounts, current)
+	}
+	if s.control.credential != nil {
+		if account, err := user.Lookup(s.control.credential.username); err == nil {
+			accounts = append(accounts, account)
+		}
+	}
+	for _, account := range accounts {
+		identity.homes = append(identity.homes, account.HomeDir)
+		identity.users = append(identity.users, account.Username)
+	}
+	if host, err := os.Hostname(); err == nil {
+		identity.hosts = append(identity.hosts, host)
+		if short, _, found := strings.Cut(host, "."); found {
+			identity.hosts = append(identity.hosts, short)
+		}
+	}
+	return identity
+}
+
+// scrubRules returns the rules of a profile for a machine, in the order they
+// apply: home directories, for example, before the user names they contain
+func scrubRules(profile string, identity machineIdentity) []scrubRule {
+	if profile == SCRUB_OFF || profile == "" {
+		return nil
+	}
+
+	// Email addresses go first so their user part is not masked separately
+	var rules []scrubRule
+	if profile == SCRUB_STRICT {
+		rules = append(rules, scrubRule{emailPattern, func(string) string { return SCRUBBED_EMAIL }})
+	}
+	for _, home := range longestFirst(identity.homes) {
+		if home == "" || home == "/" {
+			continue
+		}
+		// Only whole path components: /home/al is not in /home/alice
+		pattern := regexp.MustCompile(regexp.QuoteMeta(strings.TrimSuffix(home, "/")) + `(?:[^A-Za-z0-9._-]|$)`)
+		length := len(strings.TrimSuffix(home, "/"))
+		rules = append(rules, scrubRule{pattern, func(match string) string { return "~" + match[length:] }})
+	}
+	for _, host := range longestFirst(identity.hosts) {
+		if host == "" || host == "localhost" {
+			continue
+		}
+		rules = append(rules, constantRule(`(?i)\b`+regexp.QuoteMeta(host)+`\b`, SCRUBBED_HOST))
+	}
+	for _, name := range longestFirst(identity.users) {
+		if name == "" {
+			continue
+		}
+		rules = append(rules, constantRule(`\b`+regexp.QuoteMeta(name)+`\b`, SCRUBBED_USER))
+	}
+
+	if profile == SCRUB_STRICT {
+		// MAC addresses also look like IPv6 ones, so they go first
+		rules = append(rules,
+			scrubRule{macPattern, func(string) string { return SCRUBBED_MAC }},
+			scrubRule{ipv4Pattern, maskAddress},
+			scrubRule{ipv6Pattern, maskAddress},
+		)
+	}
+	return rules
+}
+
+// constantRule replaces matches of a pattern with a fixed placeholder
+func constantRule(pattern string, placeholder string) scrubRule {
+	return scrubRule{regexp.MustCompile(pattern), func(string) string { return placeholder }}
+}
+
+// maskAddress masks an IP address unless it is a loopback or unspecified
+// one, which reveal nothing; matches that are no address, such as times,
+// are kept
+func maskAddress(match string) string {
+	ip := net.ParseIP(match)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return match
+	}
+	return SCRUBBED_IP
+}
+
+// longestFirst sorts names so that longer ones are replaced before names
+// they contain
+func longestFirst(names []string) []string {
+	sorted := append([]string{}, names...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	return sorted
+}
+
+// scrub applies rules to text
+func scrub(text string, rules []scrubRule) string {
+	for _, rule := range rules {
+		// Matching first avoids copying output that has nothing to scrub
+		if rule.pattern.MatchString(text) {
+			text = rule.pattern.ReplaceAllStringFunc(text, rule.replace)
+		}
+	}
+	return text
+}
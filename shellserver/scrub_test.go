@@ -0,0 +1,43 @@
+package shellserver
+
+import "testing"
+
+func TestScrub(t *testing.T) {
+	identity := machineIdentity{
+		homes: []string{"/home/alice"},
+		users: []string{"alice"},
+		hosts: []string{"build-01.corp.example.com", "build-01"},
+	}
+
+	tests := []struct {
+		profile  string
+		text     string
+		expected string
+	}{
+		{SCRUB_OFF, "/home/alice/src on build-01", "/home/alice/src on build-01"},
+		{SCRUB_STANDARD, "/home/alice/src/app", "~/src/app"},
+		{SCRUB_STANDARD, "cd /home/alice\n", "cd ~\n"},
+		{SCRUB_STANDARD, "/home/alice", "~"},
+		{SCRUB_STANDARD, "/home/alicex/src", "/home/alicex/src"},
+		{SCRUB_STANDARD, "-rw-r--r-- 1 alice staff 12 notes", "-rw-r--r-- 1 <user> staff 12 notes"},
+		{SCRUB_STANDARD, "alicent", "alicent"},
+		{SCRUB_STANDARD, "ssh build-01.corp.example.com", "ssh <host>"},
+		{SCRUB_STANDARD, "BUILD-01 up 3 days", "<host> up 3 days"},
+		{SCRUB_STANDARD, "inet 10.0.0.5", "inet 10.0.0.5"},
+		{SCRUB_STRICT, "inet 10.0.0.5 peer 127.0.0.1", "inet <ip> peer 127.0.0.1"},
+		{SCRUB_STRICT, "inet6 fe80::1c2b:3aff:fe4d:5e6f", "inet6 <ip>"},
+		{SCRUB_STRICT, "ether 3c:22:fb:12:34:56", "ether <mac>"},
+		{SCRUB_STRICT, "started at 12:34:56", "started at 12:34:56"},
+		{SCRUB_STRICT, "Author: Alice <alice@example.com>", "Author: Alice <<email>>"},
+	}
+
+	for _, test := range tests {
+		if result := scrub(test.text, scrubRules(test.profile, identity)); result != test.expected {
+			t.Errorf("scrub(%s, %q) = %q, want %q", test.profile, test.text, result, test.expected)
+		}
+	}
+
+	if err := WithScrubbing("paranoid")(&ShellServer{}); err == nil {
+		t.Errorf("WithScrubbing accepted an unknown profile")
+	}
+}
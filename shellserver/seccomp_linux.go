@@ -0,0 +1,82 @@
+//go:build linux && (amd64 || arm64)
+
+package shellserver
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// seccomp(2), BPF and prctl(2) constants not exported by package syscall
+const (
+	seccompSetModeFilter   = 1          // SECCOMP_SET_MODE_FILTER
+	seccompFilterFlagTSync = 1          // SECCOMP_FILTER_FLAG_TSYNC: apply to every thread
+	seccompRetErrno        = 0x00050000 // SECCOMP_RET_ERRNO
+	seccompRetAllow        = 0x7fff0000 // SECCOMP_RET_ALLOW
+	seccompDataNr          = 0          // Offset of nr in struct seccomp_data
+	seccompDataArch        = 4          // Offset of arch in struct seccomp_data
+	prSetNoNewPrivs        = 38         // PR_SET_NO_NEW_PRIVS
+)
+
+// seccompSandbox installs a seccomp filter that fails system calls for
+// kernel and system administration with EPERM. Seccomp filters survive
+// fork and exec, so the denylist holds for every command as well: nothing
+// the server or an ordinary command needs is on it.
+type seccompSandbox struct{}
+
+// PlatformSandbox returns the sandbox for this platform: a seccomp
+// denylist on Linux
+func PlatformSandbox() (Sandbox, error) {
+	return &seccompSandbox{}, nil
+}
+
+// Name identifies the sandbox
+func (s *seccompSandbox) Name() string {
+	return "seccomp"
+}
+
+// Restrict installs the filter on every thread. Paths are not restricted.
+// no_new_privs is set first, as seccomp requires without CAP_SYS_ADMIN, so
+// setuid programs such as sudo no longer gain privileges.
+func (s *seccompSandbox) Restrict(paths map[string]string) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %v", errno)
+	}
+
+	filter := seccompFilter(seccompAuditArch, seccompDeniedSyscalls)
+	program := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if _, _, errno := syscall.Syscall(seccompSyscall, seccompSetModeFilter, seccompFilterFlagTSync, uintptr(unsafe.Pointer(&program))); errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %v", errno)
+	}
+	return nil
+}
+
+// seccompFilter builds a BPF program that allows every system call except
+// denied ones. Calls made with a foreign ABI, such as 32-bit calls on
+// amd64, fail with ENOSYS because their numbers do not match the table.
+func seccompFilter(arch uint32, denied []uint32) []syscall.SockFilter {
+	statement := func(code uint16, k uint32) syscall.SockFilter {
+		return syscall.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+		return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+
+	filter := []syscall.SockFilter{
+		statement(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, arch, 1, 0),
+		statement(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.ENOSYS)),
+		statement(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr),
+		// The x32 ABI shares amd64's arch value and sets bit 30 of the number
+		jump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, 0x40000000, uint8(len(denied)+1), 0),
+	}
+	for i, nr := range denied {
+		// Jump past the remaining checks and the allow to the deny
+		filter = append(filter, jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, uint8(len(denied)-i), 0))
+	}
+	return append(filter,
+		statement(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow),
+		statement(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EPERM)),
+	)
+}
@@ -0,0 +1,37 @@
+package shellserver
+
+// AUDIT_ARCH_X86_64 and the seccomp system call number
+const (
+	seccompAuditArch = 0xc000003e
+	seccompSyscall   = 317
+)
+
+// seccompDeniedSyscalls load kernel code, reboot, mount, swap, set the
+// clock, access I/O ports or are common exploitation primitives
+var seccompDeniedSyscalls = []uint32{
+	246, // kexec_load
+	320, // kexec_file_load
+	175, // init_module
+	313, // finit_module
+	176, // delete_module
+	169, // reboot
+	167, // swapon
+	168, // swapoff
+	165, // mount
+	166, // umount2
+	155, // pivot_root
+	163, // acct
+	164, // settimeofday
+	227, // clock_settime
+	159, // adjtimex
+	305, // clock_adjtime
+	172, // iopl
+	173, // ioperm
+	103, // syslog
+	179, // quotactl
+	180, // nfsservctl
+	212, // lookup_dcookie
+	321, // bpf
+	323, // userfaultfd
+	304, // open_by_handle_at
+}
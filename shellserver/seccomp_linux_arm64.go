@@ -0,0 +1,35 @@
+package shellserver
+
+// AUDIT_ARCH_AARCH64 and the seccomp system call number
+const (
+	seccompAuditArch = 0xc00000b7
+	seccompSyscall   = 277
+)
+
+// seccompDeniedSyscalls load kernel code, reboot, mount, swap, set the
+// clock or are common exploitation primitives. arm64 has no I/O port calls.
+var seccompDeniedSyscalls = []uint32{
+	104, // kexec_load
+	294, // kexec_file_load
+	105, // init_module
+	273, // finit_module
+	106, // delete_module
+	142, // reboot
+	224, // swapon
+	225, // swapoff
+	40,  // mount
+	39,  // umount2
+	41,  // pivot_root
+	89,  // acct
+	170, // settimeofday
+	112, // clock_settime
+	171, // adjtimex
+	266, // clock_adjtime
+	116, // syslog
+	60,  // quotactl
+	42,  // nfsservctl
+	18,  // lookup_dcookie
+	280, // bpf
+	282, // userfaultfd
+	265, // open_by_handle_at
+}
@@ -0,0 +1,51 @@
+//go:build linux && (amd64 || arm64)
+
+package shellserver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestSeccompSandbox applies the filter in a child test process, since it
+// cannot be removed once installed
+func TestSeccompSandbox(t *testing.T) {
+	if os.Getenv("SHELLSERVER_SECCOMP_CHILD") == "1" {
+		if err := (&seccompSandbox{}).Restrict(nil); err != nil {
+			t.Fatalf("Restrict failed: %v", err)
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_ACCT, 0, 0, 0); errno != syscall.EPERM {
+			t.Errorf("acct() = %v, want EPERM", errno)
+		}
+		execution := localExecutor{}.Execute(context.Background(), "echo still works", "bash", nil, nil)
+		if execution.ExitCode != 0 || strings.TrimSpace(execution.Output) != "still works" {
+			t.Errorf("command under seccomp = (%q, %d)", execution.Output, execution.ExitCode)
+		}
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSeccompSandbox$", "-test.v")
+	cmd.Env = append(os.Environ(), "SHELLSERVER_SECCOMP_CHILD=1")
+	output, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(output), "--- PASS") {
+		t.Errorf("seccomp child failed: %v\n%s", err, output)
+	}
+}
+
+func TestSeccompFilter(t *testing.T) {
+	filter := seccompFilter(seccompAuditArch, []uint32{1, 2})
+	// arch check, load nr, x32 check, one jump per denied call, allow, deny
+	if len(filter) != 9 {
+		t.Fatalf("filter has %d instructions, want 9", len(filter))
+	}
+	if filter[4].Jt != 3 || filter[5].Jt != 2 || filter[6].Jt != 1 {
+		t.Errorf("jumps = %d, %d, %d, want each to land on the deny", filter[4].Jt, filter[5].Jt, filter[6].Jt)
+	}
+	if filter[8].K != seccompRetErrno|uint32(syscall.EPERM) {
+		t.Errorf("last instruction = %+v, want deny with EPERM", filter[8])
+	}
+}
@@ -0,0 +1,1780 @@
+// Package shellserver implements an MCP server that runs shell commands
+// under an allowlist. It can be served on its own or its tools can be
+// registered on another MCP server.
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Constants
+const (
+	DEFAULT_LIMIT    = 10               // Default number of commands to list
+	DEFAULT_SHELL    = "bash"           // Default shell to use
+	COMMAND_TIMEOUT  = 30 * time.Second // Default timeout for commands
+	MAX_OUTPUT_SIZE  = 1024 * 1024      // 1MB max output size
+	MAX_HISTORY_SIZE = 100              // Maximum commands to keep in history
+	MAX_REASON_SIZE  = 1000             // Bytes of the reason an agent gives for a command
+)
+
+// CommandExecution stores information about an executed command
+type CommandExecution struct {
+	ID               int64             `json:"id,omitempty"` // Sequence number, referenced as exec://<id>/output
+	Command          string            `json:"command"`
+	Original         string            `json:"original,omitempty"` // Command as requested, if it was rewritten
+	Reason           string            `json:"reason,omitempty"`   // Why the agent ran the command, in its own words
+	Shell            string            `json:"shell"`
+	Session          string            `json:"session,omitempty"`      // Persistent session the command ran in, if any
+	Project          string            `json:"project,omitempty"`      // Project the command ran for, if any
+	Target           string            `json:"target,omitempty"`       // SSH host the command ran on, if any
+	Container        string            `json:"container,omitempty"`    // Sandbox container the command ran in, if any
+	FailoverFrom     string            `json:"failoverFrom,omitempty"` // Unreachable target the command ran on Target instead of
+	Output           string            `json:"output"`
+	ExitCode         int               `json:"exitCode"`
+	OriginalExitCode *int              `json:"originalExitCode,omitempty"` // Real exit code when success_pattern or failure_pattern changed ExitCode
+	TimedOut         bool              `json:"timedOut,omitempty"`
+	StoppedOnPattern string            `json:"stoppedOnPattern,omitempty"` // Pattern whose match stopped the command, if any
+	IdleTimeoutMs    int64             `json:"idleTimeoutMs,omitempty"`    // Idle timeout the command ran with, if any
+	TimeoutMs        int64             `json:"timeoutMs,omitempty"`        // Timeout the command ran with, if its limits shortened the server's
+	OutputLimit      int               `json:"outputLimit,omitempty"`      // Bytes of output kept, if its limits lowered MAX_OUTPUT_SIZE
+	ErrorCode        string            `json:"errorCode,omitempty"`        // ERROR_* code if the command was refused or cut short
+	StartTime        time.Time         `json:"startTime"`
+	EndTime          time.Time         `json:"endTime"`
+	ExecutionMs      int64             `json:"executionMs"`
+	Usage            *ResourceUsage    `json:"usage,omitempty"`   // CPU, memory and I/O used, for commands run locally
+	Client           *ClientIdentity   `json:"client,omitempty"`  // MCP client the command ran for, if known
+	Context          *ExecutionContext `json:"context,omitempty"` // Conditions the command ran under, for replay_execution
+}
+
+// ShellServer implements the MCP server for shell command execution
+type ShellServer struct {
+	policy             Policy
+	authorizer         Authorizer // Decides on every tool call before the policy; nil to let all through
+	executor           Executor
+	control            processControl          // How child processes are started and killed
+	sandbox            Sandbox                 // Restricts the server itself; nil when not hardened
+	tools              toolFilter              // Which tools are registered
+	toolNames          map[string]bool         // Every tool, registered or not
+	projects           map[string]*project     // Configured projects by name
+	workProject        *project                // Project of the server's working directory; nil if none
+	targets            *Targets                // SSH hosts and agents for execute_on_targets; nil if none
+	targetRules        map[string]*PolicyRules // Rules added for commands on each target
+	labelRules         []labelRule             // Rules added for commands on targets with labels
+	discovery          *discovery              // Sources of more hosts; nil if none
+	liveTargets        atomic.Pointer[Targets] // targets with the hosts discovered last; nil before discovery
+	sshPool            *sshPool                // Connections to the targets
+	targetHealth       map[string]targetHealth // Last known state of each target
+	healthInterval     time.Duration           // How often targets are checked; zero disables the checks
+	healthMutex        sync.Mutex
+	history            HistoryStore
+	executionID        atomic.Int64  // Last ID given to an execution
+	historyMutex       sync.Mutex    // Keeps IDs in the order executions are added to history
+	pins               *pinStore     // Commands saved with pin_command
+	manifestRoots      []string      // Directories whose manifests are trusted
+	timeout            time.Duration // Limit for each command
+	idleTimeout        time.Duration // Limit on silence for each command; zero for none
+	logger             *log.Logger
+	subscriptions      atomic.Pointer[subscriptions] // Resources the stdio client subscribed to; nil until Serve
+	stdioQueue         atomic.Pointer[outboundQueue] // Messages waiting for the stdio client; nil until Serve
+	translator         Translator                    // Replaces English user-facing messages; nil for English
+	middleware         []Middleware                  // Custom steps run between audit and redaction
+	rateLimit          *rateLimiter                  // Nil when commands are not rate limited
+	anomalies          *anomalyDetector              // Nil without anomaly detection
+	latency            *latencyTracker               // How long commands took, for estimates
+	toolManifest       *ToolManifest                 // Tools to provision at start; nil for none
+	probeLimit         *rateLimiter                  // Limits resolve_host, tcp_ping and trace_route calls
+	redactions         []*regexp.Regexp              // Secrets masked in command output
+	scrubProfile       string                        // SCRUB_* profile; empty scrubs nothing
+	scrubbers          []scrubRule                   // Machine identity masked in command output, per scrubProfile
+	clients            map[string]mcp.Implementation // Name and version of each initialized client by session ID
+	clientMutex        sync.Mutex
+	clientRules        map[string]*PolicyRules      // Rules added for commands from each client, by lower-case name
+	clientPolicies     map[string]map[string]Policy // Resolved policy by client, then project; "" for none
+	tenancy            tenancy                      // Partitions history and workspaces by authenticated subject
+	exec               ExecFunc                     // The assembled middleware chain
+	replExec           ExecFunc                     // The steps REPL evals go through
+	lintOnExecute      bool                         // Attach shellcheck findings to execute_command results
+	describeCache      map[string]describeEntry
+	describeMutex      sync.Mutex
+	replSessions       map[string]*replSession
+	replCounter        int
+	replMutex          sync.Mutex
+	sessionBackend     string // Backend for persistent sessions: "pipe" or "tmux"
+	sessions           map[string]*shellSession
+	sessionCounter     int
+	sessionMutex       sync.Mutex
+	dotenvNames        []string           // Patterns of the variables loaded from .env and .envrc files
+	snapshotDir        string             // Where session snapshots are saved; empty disables them
+	containerRuntime   string             // Runs sandbox containers, e.g. "docker"; empty disables them
+	containerImages    *ContainerImages   // Images sandboxes may run; nil allows none
+	containerDevices   []string           // Patterns of host devices sandboxes may be given
+	containerGPUs      bool               // Whether sandboxes may be given all GPUs
+	containerHardening containerHardening // How sandbox containers are locked down
+	containers         map[string]*sandboxContainer
+	containerCounter   int
+	containerMutex     sync.Mutex
+	recordDir          string        // Directory for asciicast recordings; empty disables recording
+	tempDir            string        // Where the server and its commands create temporary files
+	trash              *trash        // Where rm moves deleted files; nil when rm deletes them
+	fetch              fetchConfig   // What fetch_url may fetch
+	progressInterval   time.Duration // Time between progress notifications; zero disables them
+	writeTimeout       time.Duration // How long the stdio client may read nothing; zero waits forever
+	chunkSize          int           // Bytes of a response written at a time
+	requestLimits      RequestLimits // Sizes tool calls may not exceed
+	allowInvisible     bool          // Commands may contain invisible characters
+	compressionLevel   int           // gzip level for HTTP clients that accept it; gzip.NoCompression for none
+	compressMinSize    int           // Bytes from which HTTP responses are compressed
+	recordCounter      int
+	recordMutex        sync.Mutex
+	running            map[int64]runningCommand // Commands in flight, for dump_diagnostics
+	runningCounter     int64
+	locks              commandLocks    // Locks of the mutual-exclusion groups of lockRules
+	lockRules          []LockRule      // Groups of commands that run one at a time, besides those of projects
+	tripwires          []tripwire      // Commands refused with an alert, on the whole server
+	queue              *executionQueue // Limits how many commands run at once; nil for no limit
+	runningMutex       sync.Mutex
+	clock              Clock            // Tells the time executions and events are recorded with; nil for the system clock
+	deterministic      bool             // Number the IDs that are otherwise random
+	idSequence         atomic.Int64     // Last number given in place of a random ID
+	notifiers          []Notifier       // Receive command events
+	approvals          *approvalManager // Human approval for high-risk commands; nil when not configured
+	approvalListen     string           // Address of the approval callback endpoint
+	adminSocket        string           // Unix socket the admin tools are served on; empty for none
+	adminListener      net.Listener
+	profiling          bool // Serve the pprof endpoints next to the admin socket
+	pprofListener      net.Listener
+	adminAPI           string // Address the admin API is served on: a Unix socket path or host:port; empty for none
+	adminAPIToken      string // Bearer token admin API requests must carry; empty for none
+	adminAPIListener   net.Listener
+	gateway            string                      // Address agents connect to; empty for none
+	gatewayCert        string                      // TLS certificate of the gateway; empty for plain HTTP on loopback
+	gatewayKey         string                      // Key of gatewayCert
+	agents             *agentHub                   // Commands on their way to agents; nil without a gateway
+	agent              *agentClient                // Connection to the gateway this server is an agent of; nil if none
+	policyEdits        string                      // Policy file persisted policy edits are kept in; empty for none
+	allowedCommands    string                      // WithAllowedCommands' list, which ReloadPolicy builds the allowlist on
+	policySources      []policySource              // What extended the allowlist, in order, for ReloadPolicy
+	customPolicy       bool                        // Set by WithPolicy; such a policy cannot be reloaded
+	policyMutex        sync.Mutex                  // Serializes policy edits and reloads
+	policyEditMutex    sync.Mutex                  // Serializes writing the policy edits file
+	shadow             *shadowPolicy               // Proposed policy evaluated without enforcing it; nil when not configured
+	maintenance        atomic.Pointer[maintenance] // Set while agent tool calls are refused
+	digest             *activityDigest             // Periodic email summary; nil when not configured
+	reapInterval       time.Duration               // Time between reaper runs; zero disables the reaper
+	sessionIdleTimeout time.Duration               // Idle time after which the reaper closes sessions and REPLs; zero for none
+	reaper             reaper
+	retention          time.Duration // Age after which stored history, rotated audit files and recordings are removed; zero keeps them
+	retentionInterval  time.Duration // Time between runs of the retention job
+	retentionJob       retentionJob
+	asInit             bool // Do an init's duties even when not PID 1
+	server             *server.MCPServer
+}
+
+// describeEntry caches a usage summary produced by describe_command
+type describeEntry struct {
+	summary string
+	source  string
+}
+
+// NewShellServer creates a new shell server configured by opts. Without
+// WithAllowedCommands or WithPolicy no command is allowed.
+func NewShellServer(opts ...Option) (*ShellServer, error) {
+	s := &ShellServer{
+		policy:            NewAllowlistPolicy(""),
+		executor:          localExecutor{},
+		history:           newMemoryHistory(MAX_HISTORY_SIZE),
+		timeout:           COMMAND_TIMEOUT,
+		logger:            log.Default(),
+		describeCache:     make(map[string]describeEntry),
+		replSessions:      make(map[string]*replSession),
+		sessionBackend:    SESSION_BACKEND_PIPE,
+		sessions:          make(map[string]*shellSession),
+		toolNames:         make(map[string]bool),
+		containers:        make(map[string]*sandboxContainer),
+		projects:          make(map[string]*project),
+		targetHealth:      make(map[string]targetHealth),
+		progressInterval:  PROGRESS_INTERVAL,
+		writeTimeout:      WRITE_TIMEOUT,
+		chunkSize:         STDIO_CHUNK_SIZE,
+		requestLimits:     defaultRequestLimits,
+		compressionLevel:  DEFAULT_COMPRESSION_LEVEL,
+		compressMinSize:   COMPRESS_MIN_SIZE,
+		reapInterval:      REAP_INTERVAL,
+		latency:           newLatencyTracker(),
+		retentionInterval: RETENTION_INTERVAL,
+		clients:           make(map[string]mcp.Implementation),
+		probeLimit:        &rateLimiter{limit: DEFAULT_PROBE_LIMIT, period: DEFAULT_PROBE_PERIOD},
+	}
+	hooks := &server.Hooks{}
+	s.AddHooks(hooks)
+	s.server = server.NewMCPServer(
+		"unix-shell-server",
+		"0.1.0",
+		server.WithResourceCapabilities(true, false),
+		server.WithHooks(hooks),
+	)
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkWritablePaths(); err != nil {
+		return nil, err
+	}
+	if s.toolManifest != nil {
+		if err := s.provisionTools(); err != nil {
+			return nil, fmt.Errorf("failed to provision tools: %v", err)
+		}
+	}
+	if _, ok := s.executor.(localExecutor); ok {
+		s.executor = localExecutor{control: s.control}
+	}
+	s.fetch.fetchDefaults()
+	if cwd, err := os.Getwd(); err == nil {
+		if err := s.loadManifest(cwd); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.protectAdminSockets(); err != nil {
+		return nil, err
+	}
+	s.seedExecutionIDs()
+	var err error
+	if s.pins, err = newPinStore(s.history); err != nil {
+		return nil, fmt.Errorf("failed to open the pinned commands: %v", err)
+	}
+	if err := s.loadPolicyEdits(); err != nil {
+		return nil, err
+	}
+	if err := s.resolveProjectPolicies(); err != nil {
+		return nil, err
+	}
+	if err := s.resolveClientPolicies(); err != nil {
+		return nil, err
+	}
+	if err := s.resolveGateway(); err != nil {
+		return nil, err
+	}
+	s.scrubbers = scrubRules(s.scrubProfile, s.machineIdentity())
+	if cwd, err := os.Getwd(); err == nil {
+		s.workProject = s.detectProject(cwd)
+	}
+
+	s.exec = s.buildChain()
+	s.replExec = s.buildReplChain()
+	s.RegisterTools(s.server)
+	// Refuse misspelled tool names before anything is started
+	if err := s.tools.check(s.toolNames); err != nil {
+		return nil, err
+	}
+
+	// Start background services once every option, including the logger, is applied
+	if s.lintOnExecute {
+		if _, err := exec.LookPath("shellcheck"); err != nil {
+			s.logger.Println("Warning: lint on execute is enabled but shellcheck is not installed; findings will not be attached")
+		}
+	}
+	if s.trash != nil && !s.trashApplies() {
+		s.logger.Println("Warning: the trash only works for commands the server runs as its own user; rm deletes files as usual")
+	}
+	if s.approvals != nil {
+		s.approvals.logger = s.logger
+		if s.approvals.chatURL == "" {
+			s.logger.Println("Warning: approval is required without an approval webhook; matching commands will always be denied")
+		} else if err := s.approvals.listen(s.approvalListen); err != nil {
+			return nil, fmt.Errorf("failed to start approval endpoint: %v", err)
+		} else {
+			s.logger.Printf("Approval endpoint listening on %s", s.approvals.listenAddr)
+		}
+	}
+	if s.adminSocket != "" {
+		if err := s.listenAdmin(); err != nil {
+			return nil, fmt.Errorf("failed to start admin socket: %v", writableError(err))
+		}
+		s.logger.Printf("Admin tools listening on %s", s.adminSocket)
+	}
+	if s.adminAPI != "" {
+		if err := s.listenAdminAPI(); err != nil {
+			return nil, fmt.Errorf("failed to listen for the admin API: %v", err)
+		}
+		s.logger.Printf("Admin API listening on %s", s.adminAPI)
+	}
+	if s.agents != nil {
+		if err := s.listenGateway(); err != nil {
+			return nil, fmt.Errorf("failed to listen for agents: %v", err)
+		}
+		s.logger.Printf("Gateway listening for agents on %s", s.gateway)
+	}
+	if s.profiling {
+		if s.adminSocket == "" {
+			return nil, fmt.Errorf("profiling endpoints are served next to the admin socket; set one with --admin-socket")
+		}
+		if err := s.listenProfiling(); err != nil {
+			return nil, fmt.Errorf("failed to start profiling endpoints: %v", err)
+		}
+		s.logger.Printf("Profiling endpoints listening on %s", s.adminSocket+PPROF_SOCKET_SUFFIX)
+	}
+	if s.anomalies != nil {
+		// Programs of the history are not novel
+		past, _ := s.history.Recent(MAX_ANOMALY_BASELINE)
+		s.anomalies.learn(past)
+	}
+	// Estimates start from the history, oldest first so the latest runs are kept
+	if past, err := s.history.Recent(0); err == nil {
+		for i := len(past) - 1; i >= 0; i-- {
+			s.recordLatency(past[i])
+		}
+	}
+	if s.digest != nil {
+		s.digest.logger = s.logger
+		go s.digest.run()
+	}
+	if s.discovery != nil {
+		s.discovery.stop = make(chan struct{})
+		go s.runDiscovery(s.discovery.stop)
+	}
+	if s.targets != nil && s.healthInterval > 0 {
+		go s.checkTargets()
+	}
+	if s.reapInterval > 0 {
+		s.reaper.stop = make(chan struct{})
+		go s.runReaper(s.reaper.stop)
+	}
+	if s.retention > 0 {
+		s.retentionJob.stop = make(chan struct{})
+		go s.runRetention(s.retentionJob.stop)
+	}
+
+	// Restrict the server last, once every file and listener is open
+	if s.sandbox != nil {
+		if err := s.sandbox.Restrict(s.sandboxPaths()); err != nil {
+			return nil, fmt.Errorf("failed to apply the %s sandbox: %v", s.sandbox.Name(), err)
+		}
+		s.logger.Printf("Server restricted with %s", s.sandbox.Name())
+	}
+	return s, nil
+}
+
+// RegisterTools adds the shell tools and the history resource to an MCP
+// server, so they can be served alongside an embedding application's own
+// tools
+func (s *ShellServer) RegisterTools(mcpServer *server.MCPServer) {
+	s.addTool(mcpServer, mcp.NewTool(
+		"execute_command",
+		mcp.WithDescription("Execute a shell command using bash or zsh."),
+		mcp.WithString("command",
+			mcp.Description("The command to execute. {{exec:<id>:output}} is replaced with the output of an earlier execution as one quoted word, e.g. 'wc -w <<< {{exec:12:output}}'"),
+			mcp.Required(),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell to use (bash or zsh)"),
+		),
+		mcp.WithBoolean("prefer_structured_output",
+			mcp.Description("Request machine-readable output from known tools (e.g. git status --porcelain, kubectl -o json) and run with a C locale"),
+		),
+		mcp.WithString("json_format",
+			mcp.Description("If the output is valid JSON, re-serialize it ('pretty' or 'compact') and return it with a JSON MIME type"),
+			mcp.Enum(JSON_FORMAT_PRETTY, JSON_FORMAT_COMPACT),
+		),
+		mcp.WithString("json_path",
+			mcp.Description("Extract a value from JSON output before returning it, e.g. '.items[0].metadata.name'"),
+		),
+		mcp.WithString("session_id",
+			mcp.Description("Run the command in a persistent session from start_session, keeping its working directory and environment"),
+		),
+		mcp.WithString("reason",
+			mcp.Description(fmt.Sprintf("Why you are running the command, in a sentence. It is recorded in the history and audit log and shown to whoever approves high-risk commands (at most %d bytes)", MAX_REASON_SIZE)),
+		),
+		mcp.WithString("format_hint",
+			mcp.Description("Also return the output as a JSON table of headers and rows: 'auto' detects CSV or TSV, 'columns' splits whitespace-aligned output such as ps aux, df or kubectl get"),
+			mcp.Enum(TABLE_FORMAT_AUTO, TABLE_FORMAT_CSV, TABLE_FORMAT_TSV, TABLE_FORMAT_COLUMNS),
+		),
+		mcp.WithString("output_image",
+			mcp.Description("Absolute path of a PNG, JPEG, GIF, WebP or BMP image the command writes (e.g. a plot or screenshot), returned as image content"),
+		),
+		mcp.WithNumber("idle_timeout",
+			mcp.Description("Stop the command after this many seconds without output, so long jobs that keep printing can run up to the overall timeout while hung ones stop early; 0 disables it for this call. Same as limits.idle_timeout"),
+		),
+		mcp.WithObject("limits",
+			mcp.Description("Limits for this call, which can only tighten the server's: timeout and idle_timeout (seconds), max_output (bytes), nice (0-19), umask (octal string), network (false to run without network, Linux only), and cpu, memory, fsize, nofile and nproc as in --limits, e.g. {\"timeout\": 10, \"memory\": \"512M\", \"network\": false}. Not used in sessions"),
+			mcp.Properties(map[string]interface{}{
+				"timeout":      map[string]interface{}{"type": "number"},
+				"idle_timeout": map[string]interface{}{"type": "number"},
+				"max_output":   map[string]interface{}{"type": "integer"},
+				"nice":         map[string]interface{}{"type": "integer", "minimum": 0, "maximum": MAX_NICE},
+				"umask":        map[string]interface{}{"type": "string"},
+				"network":      map[string]interface{}{"type": "boolean"},
+				"cpu":          map[string]interface{}{"type": "string"},
+				"memory":       map[string]interface{}{"type": "string"},
+				"fsize":        map[string]interface{}{"type": "string"},
+				"nofile":       map[string]interface{}{"type": "integer"},
+				"nproc":        map[string]interface{}{"type": "integer"},
+			}),
+		),
+		mcp.WithString("stop_on_pattern",
+			mcp.Description("Regular expression; once a line of output matches, the command is stopped gracefully and the output so far returned as a success, e.g. 'Server started on port' to start a service and move on"),
+		),
+		mcp.WithString("success_pattern",
+			mcp.Description("Regular expression; if the output matches, a nonzero exit is reported as success (the real exit code is kept in originalExitCode)"),
+		),
+		mcp.WithString("failure_pattern",
+			mcp.Description("Regular expression; if the output matches, a zero exit is reported as failure with exit code 1. Takes precedence over success_pattern"),
+		),
+		mcp.WithString("project",
+			mcp.Description("Project to run the command for"+s.projectList()+". The command runs in the project's directory with its environment and policy. Defaults to the project of the server's working directory"),
+		),
+		mcp.WithString("stdin_resource",
+			mcp.Description("Feed the command this input instead of serializing it into the command: the output of an earlier execution as 'exec://<id>/output' (shown by list_recent_commands), or a file as 'file:///path'. Not used in sessions"),
+		),
+		mcp.WithString("priority",
+			mcp.Description("'batch' for long jobs such as test runs and builds, so that quick interactive commands are not queued behind them when the server limits how many commands run at once; defaults to 'interactive'"),
+			mcp.Enum(PRIORITY_INTERACTIVE, PRIORITY_BATCH),
+		),
+		mcp.WithString("target",
+			mcp.Description("SSH host or agent from list_targets to run the command on instead of this machine, in its login or working directory and under its policy. Not used in sessions"),
+		),
+		mcp.WithString("target_selector",
+			mcp.Description("Instead of 'target', labels such as 'role=build,os=linux': the command runs on the first reachable host or agent with all of them"),
+		),
+	), s.handleExecuteCommand)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_recent_commands",
+		mcp.WithDescription("List recently executed commands."),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of commands to return"),
+		),
+		mcp.WithString("project",
+			mcp.Description("Only list commands run for this project"),
+		),
+	), s.handleListRecentCommands)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_tenant_commands",
+		mcp.WithDescription("List the recent commands of every tenant, or of one, with the tenant each ran for. Only tenant administrators may call it."),
+		mcp.WithString("tenant",
+			mcp.Description("Only list the commands of the clients that authenticated as this subject; empty for clients without one"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of commands to return"),
+		),
+	), s.handleListTenantCommands)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"replay_execution",
+		mcp.WithDescription("Run a command from the recent history again under the conditions it ran under, where possible, and report what diverged: working directory, environment, policy, exit code or output. Useful to find out why something that worked before fails now."),
+		mcp.WithNumber("id",
+			mcp.Description("ID of the execution to replay, as list_recent_commands shows it"),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("strict",
+			mcp.Description("Refuse to run the command if its working directory, environment or policy changed since"),
+		),
+	), s.handleReplayExecution)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"export_transcript",
+		mcp.WithDescription("Export commands from the recent history as a markdown document, ready to paste into a pull request or incident report: each command with its status, duration and trimmed output, and a list of the failures."),
+		mcp.WithString("session_id",
+			mcp.Description("Only export the commands run in this session"),
+		),
+		mcp.WithString("project",
+			mcp.Description("Only export the commands run for this project"),
+		),
+		mcp.WithNumber("from_id",
+			mcp.Description("ID of the first execution to export, as list_recent_commands shows it"),
+		),
+		mcp.WithNumber("to_id",
+			mcp.Description("ID of the last execution to export"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description(fmt.Sprintf("Export at most this many of the newest matching commands (default %d)", DEFAULT_TRANSCRIPT_LIMIT)),
+		),
+		mcp.WithNumber("output_lines",
+			mcp.Description(fmt.Sprintf("Lines of output kept per command, from its start and its end (default %d); 0 leaves outputs out", DEFAULT_TRANSCRIPT_OUTPUT_LINES)),
+		),
+		mcp.WithString("title",
+			mcp.Description("Heading of the document"),
+		),
+	), s.handleExportTranscript)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"pin_command",
+		mcp.WithDescription("Save a command under a name so it can be re-run with run_pinned. {{param}} placeholders in the command are filled in on each run. The command is checked against the policy now and again on every run."),
+		mcp.WithString("name",
+			mcp.Description("Name to pin the command under: letters, digits, '.', '_' or '-'"),
+			mcp.Required(),
+		),
+		mcp.WithString("command",
+			mcp.Description("The command, e.g. 'kubectl logs -n prod {{pod}} --tail=200'"),
+			mcp.Required(),
+		),
+		mcp.WithString("description",
+			mcp.Description("What the command is for"),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell to use (bash or zsh)"),
+		),
+		mcp.WithString("project",
+			mcp.Description("Project to run the command for"+s.projectList()),
+		),
+		mcp.WithBoolean("overwrite",
+			mcp.Description("Replace a command already pinned under the name"),
+		),
+	), s.handlePinCommand)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_pinned",
+		mcp.WithDescription("List the pinned commands with their parameters."),
+	), s.handleListPinned)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"run_pinned",
+		mcp.WithDescription("Run a pinned command, filling in its parameters. It runs as execute_command would, under the policy in force now."),
+		mcp.WithString("name",
+			mcp.Description("Name of the pinned command"),
+			mcp.Required(),
+		),
+		mcp.WithObject("params",
+			mcp.Description("Values of the command's parameters, e.g. {\"pod\": \"api-7d9f\"}; each is passed as one quoted word"),
+		),
+	), s.handleRunPinned)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"unpin_command",
+		mcp.WithDescription("Remove a pinned command."),
+		mcp.WithString("name",
+			mcp.Description("Name of the pinned command"),
+			mcp.Required(),
+		),
+	), s.handleUnpinCommand)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_tasks",
+		mcp.WithDescription("List the named tasks run_task can run, e.g. test or lint: those the project declares, and the Makefile targets, justfile recipes and package.json scripts in its directory."),
+		mcp.WithString("project",
+			mcp.Description("Project to list the tasks of"+s.projectList()+". Defaults to the server's working directory"),
+		),
+	), s.handleListTasks)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"run_task",
+		mcp.WithDescription("Run a task from list_tasks, in the project's directory with its environment and policy. Only listed tasks can be run."),
+		mcp.WithString("name",
+			mcp.Description("Name of the task"),
+			mcp.Required(),
+		),
+		mcp.WithString("project",
+			mcp.Description("Project of the task"+s.projectList()+". Defaults to the server's working directory"),
+		),
+		mcp.WithArray("args",
+			mcp.Description("Extra arguments appended to the task's command, each as one quoted word"),
+			mcp.Items(map[string]interface{}{"type": "string"}),
+		),
+		mcp.WithString("priority",
+			mcp.Description("'batch' for long tasks such as tests and builds; see execute_command"),
+			mcp.Enum(PRIORITY_INTERACTIVE, PRIORITY_BATCH),
+		),
+	), s.handleRunTask)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"execute_on_targets",
+		mcp.WithDescription("Execute the same shell command on several SSH hosts or agents concurrently and return each one's output and exit code, with a summary of which failed."),
+		mcp.WithString("command",
+			mcp.Description("The command to execute on every host"),
+			mcp.Required(),
+		),
+		mcp.WithString("targets",
+			mcp.Description("Comma-separated host, agent or group names from list_targets; every host and agent if empty and a selector is given"),
+		),
+		mcp.WithString("selector",
+			mcp.Description("Only run on the targets with all of these labels, e.g. 'role=build,os=linux'"),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell to use on the hosts (bash or zsh)"),
+		),
+		mcp.WithNumber("max_parallel",
+			mcp.Description(fmt.Sprintf("Maximum number of hosts to run on at once (default %d, at most %d)", TARGET_PARALLELISM, MAX_TARGET_PARALLELISM)),
+		),
+	), s.handleExecuteOnTargets)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_targets",
+		mcp.WithDescription("List the SSH hosts, agents and groups execute_on_targets and execute_command can run commands on, with their labels."),
+		mcp.WithString("selector",
+			mcp.Description("Only list the hosts and agents with all of these labels, e.g. 'role=build,os=linux'"),
+		),
+	), s.handleListTargets)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"push_file",
+		mcp.WithDescription(fmt.Sprintf("Copy a local file to an SSH host from list_targets (at most %d bytes).", MAX_TRANSFER_SIZE)),
+		mcp.WithString("target",
+			mcp.Description("The host to copy to"),
+			mcp.Required(),
+		),
+		mcp.WithString("local_path",
+			mcp.Description("Absolute path of the local file"),
+			mcp.Required(),
+		),
+		mcp.WithString("remote_path",
+			mcp.Description("Path to write on the host; relative paths are in the login user's home directory"),
+			mcp.Required(),
+		),
+	), s.handlePushFile)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"pull_file",
+		mcp.WithDescription(fmt.Sprintf("Copy a file from an SSH host from list_targets to the local machine (at most %d bytes).", MAX_TRANSFER_SIZE)),
+		mcp.WithString("target",
+			mcp.Description("The host to copy from"),
+			mcp.Required(),
+		),
+		mcp.WithString("remote_path",
+			mcp.Description("Path of the file on the host; relative paths are in the login user's home directory"),
+			mcp.Required(),
+		),
+		mcp.WithString("local_path",
+			mcp.Description("Absolute local path to write; an existing file is replaced"),
+			mcp.Required(),
+		),
+	), s.handlePullFile)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_allowed_commands",
+		mcp.WithDescription("List all commands that are allowed to be executed."),
+	), s.handleListAllowedCommands)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"check_command",
+		mcp.WithDescription("Check whether the policy allows a command, without running it, and how long it is expected to take from the latest runs of the same command or its programs. Use it to choose between commands, e.g. rg or grep -r, and to set timeouts."),
+		mcp.WithString("command",
+			mcp.Description("The command line to check"),
+			mcp.Required(),
+		),
+		mcp.WithString("project",
+			mcp.Description("A project from --projects whose policy the command would run under"),
+		),
+		mcp.WithString("target",
+			mcp.Description("An SSH host or agent the command would run on"),
+		),
+	), s.handleCheckCommand)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"describe_command",
+		mcp.WithDescription("Show a short usage summary for a command from its --help output or man page."),
+		mcp.WithString("command",
+			mcp.Description("The command name to describe, e.g. 'tar'"),
+			mcp.Required(),
+		),
+	), s.handleDescribeCommand)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"expand_glob",
+		mcp.WithDescription("Show the paths a glob pattern such as 'build/**/*.o' would expand to, without running anything. Paths under protected paths are left out."),
+		mcp.WithString("pattern",
+			mcp.Description("The pattern to expand; supports *, ?, [...], ** and {a,b}"),
+			mcp.Required(),
+		),
+		mcp.WithString("project",
+			mcp.Description("Expand in this project's directory instead of the server's"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description(fmt.Sprintf("Maximum number of paths to return (default %d, at most %d)", DEFAULT_GLOB_LIMIT, MAX_GLOB_LIMIT)),
+		),
+	), s.handleExpandGlob)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"preview_impact",
+		mcp.WithDescription("Show which files the rm, mv, truncate and dd in a command would delete, move, overwrite or truncate, and how many bytes, without running anything."),
+		mcp.WithString("command",
+			mcp.Description("The command to assess"),
+			mcp.Required(),
+		),
+		mcp.WithString("project",
+			mcp.Description("Resolve paths in this project's directory instead of the server's"),
+		),
+	), s.handlePreviewImpact)
+
+	pathArgument := mcp.WithString("path",
+		mcp.Description("The file; relative paths are resolved in the project's directory, or the server's"),
+		mcp.Required(),
+	)
+	projectArgument := mcp.WithString("project",
+		mcp.Description("Resolve relative paths in this project's directory instead of the server's"),
+	)
+	s.addTool(mcpServer, mcp.NewTool(
+		"grep_file",
+		mcp.WithDescription("Show the lines of a file matching a regular expression, with their line numbers, without running grep."),
+		pathArgument,
+		mcp.WithString("pattern",
+			mcp.Description("The regular expression (RE2 syntax) to match lines against"),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("ignore_case",
+			mcp.Description("Match without regard to case"),
+		),
+		mcp.WithNumber("max_matches",
+			mcp.Description(fmt.Sprintf("Maximum number of lines to return (default %d, at most %d)", DEFAULT_GREP_MATCHES, MAX_GREP_MATCHES)),
+		),
+		projectArgument,
+	), s.handleGrepFile)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"head_file",
+		mcp.WithDescription("Show the first lines of a file without running head."),
+		pathArgument,
+		mcp.WithNumber("lines",
+			mcp.Description(fmt.Sprintf("Number of lines (default %d, at most %d)", DEFAULT_INSPECT_LINES, MAX_INSPECT_LINES)),
+		),
+		projectArgument,
+	), s.handleHeadFile)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"tail_file",
+		mcp.WithDescription("Show the last lines of a file without running tail."),
+		pathArgument,
+		mcp.WithNumber("lines",
+			mcp.Description(fmt.Sprintf("Number of lines (default %d, at most %d)", DEFAULT_INSPECT_LINES, MAX_INSPECT_LINES)),
+		),
+		projectArgument,
+	), s.handleTailFile)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"count_lines",
+		mcp.WithDescription("Count the lines, words and bytes of a file as wc does, without running wc."),
+		pathArgument,
+		projectArgument,
+	), s.handleCountLines)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"file_stat",
+		mcp.WithDescription("Show the type, size, permissions and modification time of a file, directory or symlink without running stat."),
+		pathArgument,
+		projectArgument,
+	), s.handleFileStat)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_archive",
+		mcp.WithDescription("List the entries of a tar, tar.gz, zip or gzip archive without running tar or unzip."),
+		pathArgument,
+		projectArgument,
+	), s.handleListArchive)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"extract_archive",
+		mcp.WithDescription("Extract a tar, tar.gz, zip or gzip archive into a directory inside the server's or a project's directory. Archives with entries that would land outside it, links, or protected paths are refused before anything is written."),
+		pathArgument,
+		mcp.WithString("destination",
+			mcp.Description("Directory to extract into; created if missing"),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("overwrite",
+			mcp.Description("Replace existing files instead of refusing the archive"),
+		),
+		projectArgument,
+	), s.handleExtractArchive)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"hash_file",
+		mcp.WithDescription("Compute the md5, sha1 or sha256 checksum of a file without running sha256sum."),
+		pathArgument,
+		mcp.WithString("algorithm",
+			mcp.Description("md5, sha1 or sha256 (the default)"),
+			mcp.Enum("md5", "sha1", "sha256"),
+		),
+		projectArgument,
+	), s.handleHashFile)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"compare_files",
+		mcp.WithDescription("Compare two files without running diff or cmp: the first differing byte, or a unified line diff with context."),
+		pathArgument,
+		mcp.WithString("other_path",
+			mcp.Description("The file to compare against; relative paths are resolved like 'path'"),
+			mcp.Required(),
+		),
+		mcp.WithString("mode",
+			mcp.Description("lines (the default) for a unified diff, or bytes for the first differing byte only"),
+			mcp.Enum("lines", "bytes"),
+		),
+		mcp.WithNumber("context",
+			mcp.Description(fmt.Sprintf("Lines of context around each change (default %d)", DEFAULT_DIFF_LINES)),
+		),
+		projectArgument,
+	), s.handleCompareFiles)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"find_files",
+		mcp.WithDescription("Find files and directories by name, type, size and age without running find. Symlinks are not followed and protected paths are skipped."),
+		mcp.WithString("path",
+			mcp.Description("Directory to search; relative paths are resolved against the project's directory (default '.')"),
+		),
+		mcp.WithString("name",
+			mcp.Description("Glob the base name must match, e.g. '*.go'"),
+		),
+		mcp.WithString("type",
+			mcp.Description("Only files or only directories"),
+			mcp.Enum("file", "directory"),
+		),
+		mcp.WithNumber("min_size",
+			mcp.Description("Smallest size in bytes"),
+		),
+		mcp.WithNumber("max_size",
+			mcp.Description("Largest size in bytes"),
+		),
+		mcp.WithString("newer_than",
+			mcp.Description("Only paths modified within this age, e.g. 30m, 12h or 7d"),
+		),
+		mcp.WithString("older_than",
+			mcp.Description("Only paths last modified longer ago than this age"),
+		),
+		mcp.WithNumber("max_depth",
+			mcp.Description("How many directory levels below 'path' to search"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description(fmt.Sprintf("Maximum number of paths to return (default %d, at most %d)", DEFAULT_FIND_LIMIT, MAX_FIND_LIMIT)),
+		),
+		projectArgument,
+	), s.handleFindFiles)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"fetch_url",
+		mcp.WithDescription(fmt.Sprintf("Fetch a URL from the server's URL allowlist without running curl or wget, to download a file or check an endpoint. Bodies are limited to %s and requests to %s; redirects must stay on the allowlist and away from private addresses.", formatByteSize(s.fetch.maxSize), s.fetch.timeout)),
+		mcp.WithString("url",
+			mcp.Description("The http or https URL to fetch"),
+			mcp.Required(),
+		),
+		mcp.WithString("method",
+			mcp.Description(fmt.Sprintf("HTTP method: %s (default GET)", strings.Join(s.fetch.methods, ", "))),
+		),
+		mcp.WithObject("headers",
+			mcp.Description("Request headers by name"),
+		),
+		mcp.WithString("body",
+			mcp.Description("Request body, for methods other than GET and HEAD"),
+		),
+		mcp.WithString("save_to",
+			mcp.Description("Save the body to this file, inside the server's or a project's directory, instead of showing it"),
+		),
+		mcp.WithBoolean("overwrite",
+			mcp.Description("Replace an existing save_to file"),
+		),
+		projectArgument,
+	), s.handleFetchURL)
+
+	hostArgument := mcp.WithString("host",
+		mcp.Description("Host name or IP address"),
+		mcp.Required(),
+	)
+	s.addTool(mcpServer, mcp.NewTool(
+		"resolve_host",
+		mcp.WithDescription("Look up DNS records of a host, or the names of an IP address, without running dig or host."),
+		hostArgument,
+		mcp.WithString("types",
+			mcp.Description(fmt.Sprintf("Comma-separated record types: %s (default %s)", strings.Join(recordTypes, ", "), DEFAULT_RECORD_TYPES)),
+		),
+	), s.handleResolveHost)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"tcp_ping",
+		mcp.WithDescription("Check that a TCP port is reachable and measure how long connecting takes, without running ping or nc."),
+		hostArgument,
+		mcp.WithNumber("port",
+			mcp.Description("TCP port to connect to"),
+			mcp.Required(),
+		),
+		mcp.WithNumber("count",
+			mcp.Description(fmt.Sprintf("Number of connection attempts (default %d, at most %d)", DEFAULT_PING_COUNT, MAX_PING_COUNT)),
+		),
+	), s.handleTCPPing)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"trace_route",
+		mcp.WithDescription("Show the routers on the IPv4 path to a host without running traceroute. Only available on Linux."),
+		hostArgument,
+		mcp.WithNumber("max_hops",
+			mcp.Description(fmt.Sprintf("Most hops to probe (default %d, at most %d)", DEFAULT_TRACE_HOPS, MAX_TRACE_HOPS)),
+		),
+	), s.handleTraceRoute)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"validate_syntax",
+		mcp.WithDescription("Check a command or script for shell syntax errors without executing it."),
+		mcp.WithString("script",
+			mcp.Description("The command or script to check"),
+			mcp.Required(),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell whose parser to use (bash or zsh)"),
+		),
+	), s.handleValidateSyntax)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"lint_script",
+		mcp.WithDescription("Run shellcheck over a command or script and report its findings without executing it."),
+		mcp.WithString("script",
+			mcp.Description("The command or script to lint"),
+			mcp.Required(),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell dialect of the script (bash or zsh)"),
+		),
+	), s.handleLintScript)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"start_repl",
+		mcp.WithDescription("Start a persistent interpreter session (python, node, psql or redis-cli). The interpreter binary must be an allowed command."),
+		mcp.WithString("interpreter",
+			mcp.Description("The interpreter to start"),
+			mcp.Enum(replInterpreterNames()...),
+			mcp.Required(),
+		),
+		mcp.WithString("args",
+			mcp.Description("Extra arguments for the interpreter, e.g. a psql connection string"),
+		),
+	), s.handleStartRepl)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"eval_in_repl",
+		mcp.WithDescription("Evaluate code in a REPL session and return only the output it produced."),
+		mcp.WithString("session_id",
+			mcp.Description("The session ID returned by start_repl"),
+			mcp.Required(),
+		),
+		mcp.WithString("code",
+			mcp.Description("The code to evaluate"),
+			mcp.Required(),
+		),
+	), s.handleEvalInRepl)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"stop_repl",
+		mcp.WithDescription("Stop a REPL session."),
+		mcp.WithString("session_id",
+			mcp.Description("The session ID returned by start_repl"),
+			mcp.Required(),
+		),
+	), s.handleStopRepl)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"start_session",
+		mcp.WithDescription("Start a persistent shell session whose working directory and environment carry across execute_command calls."),
+		mcp.WithString("shell",
+			mcp.Description("The shell to use (bash or zsh)"),
+		),
+	), s.handleStartSession)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"close_session",
+		mcp.WithDescription("Close a persistent shell session."),
+		mcp.WithString("session_id",
+			mcp.Description("The session ID returned by start_session"),
+			mcp.Required(),
+		),
+	), s.handleCloseSession)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_sessions",
+		mcp.WithDescription("List open persistent shell sessions."),
+	), s.handleListSessions)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"snapshot_session",
+		mcp.WithDescription("Save the working directory, environment and shell variables of a persistent session to disk, so restore_session can resume it after a server restart or in a new conversation."),
+		mcp.WithString("session_id",
+			mcp.Description("The session ID returned by start_session"),
+			mcp.Required(),
+		),
+		mcp.WithString("name",
+			mcp.Description("Name to save the snapshot under, replacing one of the same name; defaults to the session ID and the time"),
+		),
+	), s.handleSnapshotSession)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"restore_session",
+		mcp.WithDescription("Bring back a session saved by snapshot_session: its working directory, environment and shell variables."),
+		mcp.WithString("name",
+			mcp.Description("The snapshot's name"),
+			mcp.Required(),
+		),
+		mcp.WithString("session_id",
+			mcp.Description("Restore into this open session instead of starting a new one"),
+		),
+	), s.handleRestoreSession)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"activate_environment",
+		mcp.WithDescription("Activate a Python virtualenv, conda environment, nvm Node.js version or rbenv Ruby version in a persistent session, and check that the session's interpreter is then the environment's."),
+		mcp.WithString("session_id",
+			mcp.Description("The session ID returned by start_session"),
+			mcp.Required(),
+		),
+		mcp.WithString("manager",
+			mcp.Description("The environment manager"),
+			mcp.Enum(ENV_MANAGER_VENV, ENV_MANAGER_CONDA, ENV_MANAGER_NVM, ENV_MANAGER_RBENV),
+			mcp.Required(),
+		),
+		mcp.WithString("name",
+			mcp.Description("venv: path of the virtualenv (default .venv); conda: environment name or prefix (default base); nvm and rbenv: version (default from .nvmrc or .ruby-version)"),
+		),
+	), s.handleActivateEnvironment)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"create_sandbox",
+		mcp.WithDescription("Start a persistent container whose files and installed packages carry across exec_in_sandbox calls, keeping stateful work such as package installs and builds off the host."),
+		mcp.WithString("image",
+			mcp.Description("Image to run, e.g. debian:bookworm. Only images the server allows can run"),
+			mcp.Required(),
+		),
+		mcp.WithArray("mounts",
+			mcp.Description("Directories to mount as host:container, read-only unless suffixed with :rw. Host directories must be in the server's or a project's directory"),
+			mcp.Items(map[string]interface{}{"type": "string"}),
+		),
+		mcp.WithBoolean("network",
+			mcp.Description("Give the container network access, e.g. to install packages (default false)"),
+		),
+		mcp.WithArray("devices",
+			mcp.Description("Host devices to pass through, e.g. /dev/kvm. Only devices the server allows can be passed"),
+			mcp.Items(map[string]interface{}{"type": "string"}),
+		),
+		mcp.WithBoolean("gpus",
+			mcp.Description("Pass through all GPUs, if the server allows it (default false)"),
+		),
+	), s.handleCreateSandbox)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"exec_in_sandbox",
+		mcp.WithDescription("Run a command in a sandbox container from create_sandbox. The command is checked by the policy as in execute_command."),
+		mcp.WithString("sandbox_id",
+			mcp.Description("The sandbox ID returned by create_sandbox"),
+			mcp.Required(),
+		),
+		mcp.WithString("command",
+			mcp.Description("The command to execute"),
+			mcp.Required(),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell to use (sh, bash or zsh; default sh)"),
+		),
+	), s.handleExecInSandbox)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"destroy_sandbox",
+		mcp.WithDescription("Remove a sandbox container and everything in it, except what was written to writable mounts."),
+		mcp.WithString("sandbox_id",
+			mcp.Description("The sandbox ID returned by create_sandbox"),
+			mcp.Required(),
+		),
+	), s.handleDestroySandbox)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_recordings",
+		mcp.WithDescription("List asciicast recordings of executions and sessions, newest first."),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of recordings to return"),
+		),
+	), s.handleListRecordings)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"list_trash",
+		mcp.WithDescription("List files and directories rm moved to the trash, with the IDs restore_file takes."),
+	), s.handleListTrash)
+
+	s.addTool(mcpServer, mcp.NewTool(
+		"restore_file",
+		mcp.WithDescription("Restore a file or directory rm moved to the trash."),
+		mcp.WithString("id",
+			mcp.Description("The trash ID reported by rm or list_trash"),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("Absolute path to restore to instead of where it was deleted from"),
+		),
+	), s.handleRestoreFile)
+
+	s.registerResources(mcpServer)
+}
+
+// patternArgument compiles an optional regular expression argument
+func patternArgument(request mcp.CallToolRequest, name string) (*regexp.Regexp, *ToolError) {
+	pattern, _ := request.Params.Arguments[name].(string)
+	if pattern == "" {
+		return nil, nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: invalid '%s': %v", name, err),
+			Details: map[string]interface{}{"argument": name},
+		}
+	}
+	return compiled, nil
+}
+
+// refusal describes why a command or tool call was not run
+func refusal(err error) ToolError {
+	toolError := ToolError{Code: ERROR_EXECUTION_FAILED, Message: "Error: " + err.Error()}
+	if denied, ok := err.(*DeniedError); ok {
+		if denied.Message != "" {
+			toolError.Message = denied.Message
+		}
+		toolError.Code, toolError.Details, toolError.Hint = ERROR_POLICY_DENIED, denied.Details, denied.Hint
+		if denied.Code != "" {
+			toolError.Code = denied.Code
+		}
+	}
+	return toolError
+}
+
+// deniedToolError describes why the chain did not run req and emits a
+// denial event for it
+func (s *ShellServer) deniedToolError(req *ExecRequest, err error) ToolError {
+	reason := err.Error()
+	toolError := refusal(err)
+	s.emitEvent(EVENT_DENIAL, CommandExecution{
+		Command:   req.Command,
+		Original:  req.Original,
+		Reason:    req.Reason,
+		Shell:     req.Shell,
+		Session:   req.Session,
+		Project:   req.Project,
+		Target:    req.Target,
+		Container: req.Container,
+		Client:    req.Client,
+		ErrorCode: toolError.Code,
+		StartTime: s.now(),
+	}, reason)
+	return toolError
+}
+
+// Tool handlers
+func (s *ShellServer) handleExecuteCommand(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	command, ok := request.Params.Arguments["command"].(string)
+	if !ok {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_INVALID_COMMAND),
+			Details: map[string]interface{}{"argument": "command"},
+		}), nil
+	}
+
+	// Get optional shell parameter
+	shell := DEFAULT_SHELL
+	if shellArg, ok := request.Params.Arguments["shell"].(string); ok && shellArg != "" {
+		shell = shellArg
+	}
+
+	// Check the session exists before anything is run or recorded
+	sessionID, _ := request.Params.Arguments["session_id"].(string)
+	if sessionID != "" {
+		if _, found := s.getSession(ctx, sessionID); !found {
+			return errorResult(ToolError{
+				Code:    ERROR_SESSION_NOT_FOUND,
+				Message: s.message(MSG_SESSION_NOT_FOUND, sessionID),
+				Details: map[string]interface{}{"sessionId": sessionID},
+			}), nil
+		}
+	}
+
+	// Builtins such as cd and export only last as long as the shell, which
+	// outside a session ends with the command
+	if builtin := statelessBuiltin(command); builtin != "" && sessionID == "" {
+		return errorResult(ToolError{
+			Code:    ERROR_STATELESS_BUILTIN,
+			Message: s.message(MSG_STATELESS_BUILTIN, builtin),
+			Details: map[string]interface{}{"builtin": builtin},
+			Hint:    s.message(MSG_USE_SESSION, builtin),
+		}), nil
+	}
+
+	// Run on another machine, if asked to
+	target, _ := request.Params.Arguments["target"].(string)
+	if selector, _ := request.Params.Arguments["target_selector"].(string); selector != "" {
+		if target != "" {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: "Error: give either 'target' or 'target_selector'",
+				Details: map[string]interface{}{"argument": "target_selector"},
+			}), nil
+		}
+		if s.targets == nil {
+			return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: s.message(MSG_NO_TARGETS)}), nil
+		}
+		var err error
+		if target, err = s.pickTarget(selector); err != nil {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: s.message(MSG_INVALID_TARGETS, err),
+				Details: map[string]interface{}{"argument": "target_selector"},
+			}), nil
+		}
+	}
+	if target != "" {
+		if sessionID != "" {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: "Error: 'target' cannot be used in a session",
+				Details: map[string]interface{}{"argument": "target"},
+			}), nil
+		}
+		if imagePath, _ := request.Params.Arguments["output_image"].(string); imagePath != "" {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: "Error: 'output_image' is read on this machine and cannot be used with 'target'",
+				Details: map[string]interface{}{"argument": "output_image"},
+			}), nil
+		}
+		if s.targets == nil {
+			return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: s.message(MSG_NO_TARGETS)}), nil
+		}
+		if !s.currentTargets().has(target) {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: s.message(MSG_INVALID_TARGETS, fmt.Errorf("unknown host or agent '%s'", target)),
+				Details: map[string]interface{}{"argument": "target"},
+			}), nil
+		}
+	}
+
+	// The reason is shown to humans on one line, so it cannot fake the
+	// lines of an approval request
+	reason, _ := request.Params.Arguments["reason"].(string)
+	reason = strings.Join(strings.Fields(reason), " ")
+	if len(reason) > MAX_REASON_SIZE {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: 'reason' is %d bytes; explain the command in at most %d", len(reason), MAX_REASON_SIZE),
+			Details: map[string]interface{}{"argument": "reason", "size": len(reason), "limit": MAX_REASON_SIZE},
+		}), nil
+	}
+
+	req := &ExecRequest{
+		Command: command,
+		Shell:   shell,
+		Session: sessionID,
+		Target:  target,
+		Reason:  reason,
+		Client:  s.clientIdentity(ctx),
+	}
+
+	// Run for the requested project, or the one the server runs in. Sessions
+	// keep their own working directory.
+	if projectName, _ := request.Params.Arguments["project"].(string); projectName != "" {
+		p, found := s.projects[projectName]
+		if !found {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: s.message(MSG_UNKNOWN_PROJECT, projectName),
+				Details: map[string]interface{}{"argument": "project", "project": projectName, "projects": s.ProjectNames()},
+			}), nil
+		}
+		req.Project, req.Env = p.Name, append([]string{}, p.Env...)
+		if sessionID == "" && target == "" {
+			req.Dir = p.Dir
+		}
+	} else if s.workProject != nil {
+		req.Project, req.Env = s.workProject.Name, append([]string{}, s.workProject.Env...)
+	}
+
+	// Commands get the allowed variables of the .env and .envrc files where
+	// they run. Configured environments take precedence over them.
+	if sessionID == "" && target == "" && len(s.dotenvNames) > 0 {
+		dir := req.Dir
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		req.Env = append(s.dotenv(dir), req.Env...)
+	}
+
+	// Put the output of earlier executions in place of their placeholders
+	expanded, placeholderError := s.expandPlaceholders(ctx, command)
+	if placeholderError != nil {
+		return errorResult(*placeholderError), nil
+	}
+	if expanded != command {
+		req.Command = expanded
+		req.Original = command
+	}
+
+	// Optionally rewrite the command to request machine-readable output
+	if structured, ok := request.Params.Arguments["prefer_structured_output"].(bool); ok && structured {
+		if rewritten, changed := rewriteForStructuredOutput(req.Command); changed {
+			req.Command = rewritten
+			req.Original = command
+		}
+		req.Env = append(req.Env, structuredEnv...)
+	}
+
+	// Stop the command early if it goes quiet, if requested
+	req.IdleTimeout = s.idleTimeout
+	if idleArg, ok := request.Params.Arguments["idle_timeout"].(float64); ok {
+		if idleArg < 0 {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: "Error: 'idle_timeout' must not be negative",
+				Details: map[string]interface{}{"argument": "idle_timeout"},
+			}), nil
+		}
+		req.IdleTimeout = time.Duration(idleArg * float64(time.Second))
+	}
+
+	// Run within tighter limits than the server's, if requested
+	limits, idleTimeout, limitsError := s.callLimits(request)
+	if limitsError != nil {
+		return errorResult(*limitsError), nil
+	}
+	if (!limits.isZero() || idleTimeout != nil) && sessionID != "" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'limits' cannot be used in a session",
+			Details: map[string]interface{}{"argument": "limits"},
+		}), nil
+	}
+	req.Limits = limits
+	if idleTimeout != nil {
+		req.IdleTimeout = *idleTimeout
+	}
+
+	// Queue long jobs behind interactive calls, if asked to
+	if priority, ok := request.Params.Arguments["priority"]; ok {
+		req.Priority, _ = priority.(string)
+		if req.Priority != PRIORITY_INTERACTIVE && req.Priority != PRIORITY_BATCH {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: fmt.Sprintf("Error: 'priority' must be '%s' or '%s'", PRIORITY_INTERACTIVE, PRIORITY_BATCH),
+				Details: map[string]interface{}{"argument": "priority"},
+			}), nil
+		}
+	}
+
+	// Stop the command once its output shows what the agent waits for, and
+	// judge its success by its output, if requested
+	var argError *ToolError
+	if req.StopPattern, argError = patternArgument(request, "stop_on_pattern"); argError != nil {
+		return errorResult(*argError), nil
+	}
+	if req.StopPattern != nil && sessionID != "" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'stop_on_pattern' cannot be used in a session",
+			Details: map[string]interface{}{"argument": "stop_on_pattern"},
+		}), nil
+	}
+	if req.SuccessPattern, argError = patternArgument(request, "success_pattern"); argError != nil {
+		return errorResult(*argError), nil
+	}
+	if req.FailurePattern, argError = patternArgument(request, "failure_pattern"); argError != nil {
+		return errorResult(*argError), nil
+	}
+
+	// Give the command its input from a resource, if requested
+	if req.Stdin, argError = s.stdinResource(ctx, request); argError != nil {
+		return errorResult(*argError), nil
+	}
+	if req.Stdin != nil && sessionID != "" {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'stdin_resource' cannot be used in a session",
+			Details: map[string]interface{}{"argument": "stdin_resource"},
+		}), nil
+	}
+
+	// Report progress while the command runs, if the client asked for it
+	progress, stopProgress := s.startProgress(ctx, request)
+	if progress != nil {
+		req.Output = progress
+	}
+
+	// Run the command through the middleware chain
+	execution, err := s.exec(ctx, req)
+	stopProgress()
+	if err != nil {
+		return errorResult(s.deniedToolError(req, err)), nil
+	}
+
+	// Lint the command as requested, if configured
+	var lintNote string
+	if s.lintOnExecute {
+		if findings, err := lintScript(command, shell); err == nil && len(findings) > 0 {
+			lintNote = fmt.Sprintf("\n\nShellcheck findings (%d):\n%s", len(findings), formatLintFindings(findings))
+		}
+	}
+	// Show the command as it ran, except for the output placeholders stand for
+	if expanded == command {
+		command = execution.Command
+	}
+
+	// Construct the response
+	var executionStatus string
+	if execution.ExitCode == 0 {
+		executionStatus = "completed successfully"
+	} else {
+		executionStatus = fmt.Sprintf("failed with exit code %d", execution.ExitCode)
+	}
+
+	// Attach an image the command wrote, if requested
+	var attachments []mcp.Content
+	if imagePath, _ := request.Params.Arguments["output_image"].(string); imagePath != "" {
+		image, err := loadOutputImage(imagePath, execution.StartTime)
+		if err != nil {
+			execution.Output += "\n\nWarning: " + err.Error()
+		} else {
+			attachments = append(attachments, image)
+		}
+	}
+
+	// Attach the output as a table, if requested
+	if formatHint, _ := request.Params.Arguments["format_hint"].(string); formatHint != "" {
+		table, err := parseTable(execution.Output, formatHint)
+		if err == nil {
+			var resource mcp.EmbeddedResource
+			if resource, err = tableResource(table); err == nil {
+				attachments = append(attachments, resource)
+			}
+		}
+		if err != nil {
+			execution.Output += "\n\nWarning: output is not a table: " + err.Error()
+		}
+	}
+
+	// Report what the command used, when it ran locally
+	var usageNote string
+	if execution.Usage != nil {
+		usageNote = " (" + execution.Usage.String() + ")"
+		attachments = append(attachments, usageResource(execution.Usage))
+	}
+
+	// Tell the agent how to pass the output on to another command
+	var referenceNote string
+	if execution.ID != 0 {
+		referenceNote = ", output at " + executionURI(execution.ID)
+	}
+
+	// Say why the command was cut short, if it was
+	toolError := s.executionError(execution)
+	if toolError != nil {
+		attachments = append(attachments, errorResource(*toolError))
+	}
+	// Nothing ran when the shell is not supported
+	isError := toolError != nil && toolError.Code == ERROR_SHELL_UNSUPPORTED
+
+	// Re-serialize JSON output if requested
+	jsonFormat, _ := request.Params.Arguments["json_format"].(string)
+	jsonPath, _ := request.Params.Arguments["json_path"].(string)
+	if jsonFormat != "" || jsonPath != "" {
+		formatted, isJSON, err := processJSONOutput(execution.Output, jsonFormat, jsonPath)
+		if err != nil {
+			execution.Output += "\n\nWarning: " + err.Error()
+		} else if isJSON {
+			resource := mcp.EmbeddedResource{
+				Type: "resource",
+				Resource: mcp.TextResourceContents{
+					URI:      JSON_OUTPUT_URI,
+					MIMEType: JSON_MIME_TYPE,
+					Text:     formatted,
+				},
+			}
+			annotate(&resource.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+			content := []mcp.Content{
+				assistantText(fmt.Sprintf(
+					"$ %s\n\nCommand %s in %d ms%s%s, JSON output attached%s",
+					command,
+					executionStatus,
+					execution.ExecutionMs,
+					usageNote,
+					referenceNote,
+					lintNote,
+				)),
+				resource,
+			}
+			content = append(content, attachments...)
+			return s.withLatency(ctx, &mcp.CallToolResult{
+				Content: append(content, s.executionSummary(execution)),
+				IsError: isError,
+			}, execution), nil
+		}
+	}
+
+	content := []mcp.Content{
+		assistantText(fmt.Sprintf(
+			"$ %s\n\n%s\n\nCommand %s in %d ms%s%s%s",
+			command,
+			execution.Output,
+			executionStatus,
+			execution.ExecutionMs,
+			usageNote,
+			referenceNote,
+			lintNote,
+		)),
+	}
+	content = append(content, attachments...)
+	return s.withLatency(ctx, &mcp.CallToolResult{
+		Content: append(content, s.executionSummary(execution)),
+		IsError: isError,
+	}, execution), nil
+}
+
+func (s *ShellServer) handleListRecentCommands(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	// Get optional limit parameter
+	limit := DEFAULT_LIMIT
+	if limitArg, ok := request.Params.Arguments["limit"].(float64); ok {
+		limit = int(limitArg)
+	}
+	projectName, _ := request.Params.Arguments["project"].(string)
+
+	// Get command history
+	var history []CommandExecution
+	var total int
+	var err error
+	if projectName == "" {
+		history, total, err = s.tenantHistory(ctx, limit)
+	} else {
+		history, total, err = s.projectHistory(ctx, projectName, limit)
+	}
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Error: Failed to read command history: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	return s.historyResult(history, total, false), nil
+}
+
+// historyResult lists executions newest first, with the tenant each ran
+// for if showTenant is set
+func (s *ShellServer) historyResult(history []CommandExecution, total int, showTenant bool) *mcp.CallToolResult {
+	if len(history) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: s.message(MSG_HISTORY_EMPTY),
+				},
+			},
+		}
+	}
+
+	// Format the response
+	var result strings.Builder
+	result.WriteString(s.message(MSG_HISTORY_HEADER, len(history), total) + "\n\n")
+
+	for i, cmd := range history {
+		statusMsg := s.message(MSG_HISTORY_SUCCESS)
+		if cmd.ExitCode != 0 {
+			statusMsg = s.message(MSG_HISTORY_FAILED, cmd.ExitCode)
+		}
+
+		result.WriteString(s.message(
+			MSG_HISTORY_ENTRY,
+			i+1,
+			cmd.StartTime.Format(time.RFC3339),
+			cmd.Command,
+			cmd.Shell,
+			cmd.ExecutionMs,
+			statusMsg,
+		) + "\n")
+		if cmd.ID != 0 {
+			result.WriteString(s.message(MSG_HISTORY_OUTPUT, executionURI(cmd.ID)) + "\n")
+		}
+		if cmd.Reason != "" {
+			result.WriteString(s.message(MSG_HISTORY_REASON, cmd.Reason) + "\n")
+		}
+		if showTenant {
+			result.WriteString(s.message(MSG_HISTORY_TENANT, executionTenant(cmd)) + "\n")
+		}
+		result.WriteString("\n")
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result.String(),
+			},
+		},
+	}
+}
+
+func (s *ShellServer) handleListAllowedCommands(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	policy, ok := s.policy.(*AllowlistPolicy)
+	if !ok {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "Commands are checked by a custom policy; there is no fixed list of allowed commands.",
+				},
+			},
+		}, nil
+	}
+
+	if policy.AllowAll() {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: strings.TrimSuffix("All commands are allowed ('*' mode).\n\nWarning: This server is configured to execute any shell command. This poses a security risk.\n"+policyRestrictions(policy), "\n"),
+				},
+			},
+		}, nil
+	}
+
+	allowedCommands := policy.Commands()
+	if len(allowedCommands) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "No commands are currently allowed. Configure the server with the '--allowed-commands' flag.",
+				},
+			},
+		}, nil
+	}
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Allowed commands (%d):\n\n", len(allowedCommands)))
+
+	for i, cmd := range allowedCommands {
+		result.WriteString(fmt.Sprintf("%d. %s\n", i+1, cmd))
+	}
+	result.WriteString(policyRestrictions(policy))
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result.String(),
+			},
+		},
+	}, nil
+}
+
+// policyRestrictions lists what the policy refuses even for allowed commands,
+// one paragraph per kind of rule
+func policyRestrictions(policy *AllowlistPolicy) string {
+	var result strings.Builder
+	if rules := policy.DenyRules(); len(rules) > 0 {
+		result.WriteString("\nDenied even though the command is allowed:\n")
+		for _, rule := range rules {
+			result.WriteString(fmt.Sprintf("- %s\n", rule))
+		}
+	}
+	if paths := policy.DeniedPaths(); len(paths) > 0 {
+		result.WriteString(fmt.Sprintf("\nProtected paths: %s\n", strings.Join(paths, ", ")))
+	}
+	if policy.ReadOnly() {
+		result.WriteString("\nOutput may only be redirected to /dev/null.\n")
+	}
+	return result.String()
+}
+
+// Serve serves MCP requests over stdio until the client disconnects
+func (s *ShellServer) Serve() error {
+	// Log the server configuration
+	if policy, ok := s.policy.(*AllowlistPolicy); !ok {
+		s.logger.Println("Starting shell server with a custom command policy")
+	} else if policy.AllowAll() {
+		s.logger.Println("Starting shell server with all commands allowed ('*' mode)")
+	} else {
+		s.logger.Printf("Starting shell server with %d allowed commands", len(policy.Commands()))
+	}
+
+	if s.isInit() {
+		s.logger.Println("Running as init: forwarding signals to child processes and collecting orphans")
+		defer s.serveInit()()
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	return s.serveStdio(ctx, os.Stdin, os.Stdout)
+}
+
+// serveStdio serves one client over in and out until in is closed or ctx
+// is cancelled
+func (s *ShellServer) serveStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	identity := stdioIdentity()
+	stdio := server.NewStdioServer(s.server)
+	stdio.SetErrorLogger(s.logger)
+	stdio.SetContextFunc(func(ctx context.Context) context.Context {
+		return ContextWithClientIdentity(ctx, identity)
+	})
+	// Responses are queued so a client reading a large one slowly does not
+	// hold up the next request
+	queue := newOutboundQueue(out, s.writeTimeout, STDIO_QUEUE_BYTES, s.chunkSize)
+	s.stdioQueue.Store(queue)
+	sub := newSubscriptions(queue)
+	s.subscriptions.Store(sub)
+	err := stdio.Listen(ctx, sub.filter(in), sub.out)
+	if flushErr := queue.flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// Close terminates open sessions, REPLs and SSH connections, and removes
+// sandbox containers
+func (s *ShellServer) Close() {
+	if s.reaper.stop != nil {
+		close(s.reaper.stop)
+		s.reaper.stop = nil
+	}
+	if s.retentionJob.stop != nil {
+		close(s.retentionJob.stop)
+		s.retentionJob.stop = nil
+	}
+	s.closeAdmin()
+	if s.discovery != nil && s.discovery.stop != nil {
+		close(s.discovery.stop)
+		s.discovery.stop = nil
+	}
+	if s.agents != nil && s.agents.listener != nil {
+		s.agents.listener.Close()
+	}
+	s.closeAllSessions()
+	s.destroyAllContainers()
+	if s.sshPool != nil {
+		s.sshPool.close(s.currentTargets(), s.control)
+	}
+
+	s.replMutex.Lock()
+	repls := s.replSessions
+	s.replSessions = make(map[string]*replSession)
+	s.replMutex.Unlock()
+	for _, repl := range repls {
+		repl.stop()
+	}
+}
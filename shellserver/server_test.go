@@ -0,0 +1,193 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestIsCommandAllowed(t *testing.T) {
+	// Test with specific allowed commands
+	s := &ShellServer{
+		policy: &AllowlistPolicy{commands: []string{"ls", "echo", "cat"}, allowAll: false},
+	}
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"ls", true},
+		{"ls -la", true},
+		{"echo hello", true},
+		{"cat file.txt", true},
+		{"rm file.txt", false},
+		{"sudo ls", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		result := s.isCommandAllowed(test.command)
+		if result != test.allowed {
+			t.Errorf("isCommandAllowed(%q) = %v, want %v", test.command, result, test.allowed)
+		}
+	}
+
+	// Test with all commands allowed
+	sAll := &ShellServer{
+		policy: &AllowlistPolicy{commands: []string{}, allowAll: true},
+	}
+
+	for _, test := range tests {
+		if !sAll.isCommandAllowed(test.command) && test.command != "" {
+			t.Errorf("With allowAll=true, isCommandAllowed(%q) should be true", test.command)
+		}
+	}
+}
+
+func TestAddToHistory(t *testing.T) {
+	s := &ShellServer{
+		policy:  &AllowlistPolicy{commands: []string{"ls", "echo"}, allowAll: false},
+		history: newMemoryHistory(MAX_HISTORY_SIZE),
+	}
+
+	// Add a few commands
+	for i := 0; i < 5; i++ {
+		execution := CommandExecution{
+			Command:   fmt.Sprintf("command%d", i),
+			Shell:     "bash",
+			Output:    fmt.Sprintf("output%d", i),
+			ExitCode:  0,
+			StartTime: time.Now(),
+			EndTime:   time.Now(),
+		}
+		s.addToHistory(execution)
+	}
+
+	// Check the length
+	if historyCount(t, s) != 5 {
+		t.Errorf("History length = %d, want 5", historyCount(t, s))
+	}
+
+	// Check the order (most recent first)
+	if first := recentHistory(t, s, 1)[0]; first.Command != "command4" {
+		t.Errorf("First history entry = %s, want command4", first.Command)
+	}
+
+	// Add more commands to test truncation
+	for i := 5; i < MAX_HISTORY_SIZE+10; i++ {
+		execution := CommandExecution{
+			Command:   fmt.Sprintf("command%d", i),
+			Shell:     "bash",
+			Output:    fmt.Sprintf("output%d", i),
+			ExitCode:  0,
+			StartTime: time.Now(),
+			EndTime:   time.Now(),
+		}
+		s.addToHistory(execution)
+	}
+
+	// Check that history is truncated
+	if historyCount(t, s) > MAX_HISTORY_SIZE {
+		t.Errorf("History length = %d, want at most %d", historyCount(t, s), MAX_HISTORY_SIZE)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	s := &ShellServer{
+		policy:  &AllowlistPolicy{commands: []string{"ls", "echo"}, allowAll: false},
+		history: newMemoryHistory(MAX_HISTORY_SIZE),
+	}
+
+	// Add some commands
+	for i := 0; i < 10; i++ {
+		execution := CommandExecution{
+			Command:   fmt.Sprintf("command%d", i),
+			Shell:     "bash",
+			Output:    fmt.Sprintf("output%d", i),
+			ExitCode:  0,
+			StartTime: time.Now(),
+			EndTime:   time.Now(),
+		}
+		s.addToHistory(execution)
+	}
+
+	// Test getting all history
+	history := recentHistory(t, s, 0)
+	if len(history) != 10 {
+		t.Errorf("Recent(0) returned %d items, want 10", len(history))
+	}
+
+	// Test getting limited history
+	history = recentHistory(t, s, 5)
+	if len(history) != 5 {
+		t.Errorf("Recent(5) returned %d items, want 5", len(history))
+	}
+
+	// Test getting more than available
+	history = recentHistory(t, s, 20)
+	if len(history) != 10 {
+		t.Errorf("Recent(20) returned %d items, want 10", len(history))
+	}
+}
+
+func TestNewShellServer(t *testing.T) {
+	// Test with specific allowed commands
+	server, err := NewShellServer(WithAllowedCommands("ls,cat,echo"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	policy := server.policy.(*AllowlistPolicy)
+	if len(policy.Commands()) != 3 {
+		t.Errorf("policy.Commands() has %d items, want 3", len(policy.Commands()))
+	}
+
+	if policy.AllowAll() {
+		t.Errorf("policy.AllowAll() = true, want false")
+	}
+
+	// Test with all commands allowed
+	serverAll, err := NewShellServer(WithAllowedCommands("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	if !serverAll.policy.(*AllowlistPolicy).AllowAll() {
+		t.Errorf("serverAll policy AllowAll() = false, want true")
+	}
+
+	// Test with empty commands
+	serverEmpty, err := NewShellServer(WithAllowedCommands(""))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	policyEmpty := serverEmpty.policy.(*AllowlistPolicy)
+	if len(policyEmpty.Commands()) != 0 {
+		t.Errorf("policyEmpty.Commands() has %d items, want 0", len(policyEmpty.Commands()))
+	}
+
+	if policyEmpty.AllowAll() {
+		t.Errorf("policyEmpty.AllowAll() = true, want false")
+	}
+}
+
+func BenchmarkHandleExecuteCommand(b *testing.B) {
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithExecutor(fixedExecutor("hello\n")))
+	if err != nil {
+		b.Fatalf("NewShellServer failed: %v", err)
+	}
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "echo hello"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if result, _ := s.handleExecuteCommand(context.Background(), request); result.IsError {
+			b.Fatalf("execute_command failed: %v", result.Content)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Session backends selectable with --session-backend
+const (
+	SESSION_BACKEND_PIPE = "pipe" // A shell process driven over stdin/stdout
+	SESSION_BACKEND_TMUX = "tmux" // A detached tmux session a human can attach to
+	MAX_SESSIONS         = 5      // Maximum concurrently open shell sessions
+	TMUX_HISTORY_LIMIT   = 50000  // Scrollback lines kept per tmux session
+)
+
+// sessionBackend runs commands in a long-lived shell
+type sessionBackend interface {
+	// run executes a command and returns its combined output and exit code
+	run(command string, timeout time.Duration) (string, int, error)
+	// close terminates the shell
+	close() error
+	// attachHint tells a human how to observe the session, if possible
+	attachHint() string
+}
+
+// shellSession is a persistent shell whose cwd and environment carry
+// across execute_command calls
+type shellSession struct {
+	id        string
+	shell     string
+	backend   string
+	startTime time.Time
+	impl      sessionBackend
+	tenant    string        // Tenant that started the session
+	recorder  *castRecorder // Nil unless recording is enabled
+	runMutex  sync.Mutex    // Serializes commands within one session
+	lastUsed  time.Time     // When the last command finished; guarded by runMutex
+}
+
+// sessionMarkers returns printf commands whose output marks the start and end
+// of a command's output. The marker text is split across two printf
+// arguments so the typed command line never contains it verbatim.
+func sessionMarkers(id string) (string, string, string, string) {
+	startMarker := "__MCP_START_" + id + "__"
+	endMarker := "__MCP_END_" + id + "__"
+	start := fmt.Sprintf("printf '%%s%%s\\n' '__MCP_START_' '%s__'", id)
+	end := fmt.Sprintf("printf '\\n%%s%%s %%d\\n' '__MCP_END_' '%s__' \"$__mcp_status\"", id)
+	return start, end, startMarker, endMarker
+}
+
+// wrapSessionCommand frames a command with start and end markers.
+// The braces keep multi-line commands together, redirect applies to the
+// whole group, and the group's exit status is reported after the end marker.
+func wrapSessionCommand(command string, id string, redirect string) (string, string, string) {
+	start, end, startMarker, endMarker := sessionMarkers(id)
+	wrapped := fmt.Sprintf("%s; {\n%s\n}%s; __mcp_status=$?; %s\n", start, command, redirect, end)
+	return wrapped, startMarker, endMarker
+}
+
+// parseSessionExit extracts the exit code written after the end marker
+func parseSessionExit(trailer string) int {
+	code, err := strconv.Atoi(strings.TrimSpace(trailer))
+	if err != nil {
+		return 1
+	}
+	return code
+}
+
+// pipeSession drives a non-interactive shell over pipes
+type pipeSession struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	output markerBuffer
+	runs   int
+}
+
+// newPipeSession starts a shell reading commands from stdin
+func newPipeSession(shell string, control processControl) (*pipeSession, error) {
+	args := []string{"--noprofile", "--norc"}
+	if shell == "zsh" {
+		args = []string{"-f"}
+	}
+
+	p := &pipeSession{cmd: control.command(context.Background(), shell, args...)}
+	p.cmd.Stdout = &p.output
+	p.cmd.Stderr = &p.output
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	p.stdin = stdin
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		p.cmd.Wait()
+		p.output.markExited()
+	}()
+
+	return p, nil
+}
+
+func (p *pipeSession) run(command string, timeout time.Duration) (string, int, error) {
+	p.runs++
+	id := fmt.Sprintf("%d_%d", os.Getpid(), p.runs)
+
+	// Commands must not consume the shell's own stdin, which carries our framing
+	wrapped, startMarker, endMarker := wrapSessionCommand(command, id, " </dev/null")
+	if _, err := io.WriteString(p.stdin, wrapped); err != nil {
+		return "", 1, fmt.Errorf("session is no longer running: %v", err)
+	}
+
+	if _, _, err := p.output.takeUntil(startMarker, timeout); err != nil {
+		return "", 1, err
+	}
+	output, trailer, err := p.output.takeUntil(endMarker, timeout)
+	if err != nil {
+		// A non-interactive shell cannot interrupt its foreground job, so
+		// the whole session is torn down instead
+		p.close()
+		return output, 124, fmt.Errorf("%v; the session was terminated", err)
+	}
+
+	return strings.TrimSuffix(output, "\n"), parseSessionExit(trailer), nil
+}
+
+func (p *pipeSession) close() error {
+	p.stdin.Close()
+	if p.output.hasExited() {
+		return nil
+	}
+	return killProcessTree(p.cmd)
+}
+
+func (p *pipeSession) attachHint() string {
+	return ""
+}
+
+// tmuxSession drives an interactive shell inside a detached tmux session
+type tmuxSession struct {
+	name string
+	runs int
+}
+
+// newTmuxSession creates a detached tmux session running shell
+func newTmuxSession(name string, shell string) (*tmuxSession, error) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return nil, fmt.Errorf("tmux is not installed on the server")
+	}
+
+	shellCmd := shell + " --noprofile --norc"
+	if shell == "zsh" {
+		shellCmd = "zsh -f"
+	}
+	if output, err := exec.Command("tmux", "new-session", "-d", "-s", name, "-x", "200", "-y", "50", shellCmd).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("tmux new-session failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	exec.Command("tmux", "set-option", "-t", name, "history-limit", strconv.Itoa(TMUX_HISTORY_LIMIT)).Run()
+
+	return &tmuxSession{name: name}, nil
+}
+
+func (t *tmuxSession) run(command string, timeout time.Duration) (string, int, error) {
+	t.runs++
+	id := fmt.Sprintf("%d_%d", os.Getpid(), t.runs)
+	wrapped, startMarker, endMarker := wrapSessionCommand(command, id, "")
+
+	if output, err := exec.Command("tmux", "send-keys", "-t", t.name, "-l", strings.TrimSuffix(wrapped, "\n")).CombinedOutput(); err != nil {
+		return "", 1, fmt.Errorf("tmux send-keys failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	if err := exec.Command("tmux", "send-keys", "-t", t.name, "Enter").Run(); err != nil {
+		return "", 1, fmt.Errorf("tmux send-keys failed: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		pane, err := t.capture()
+		if err != nil {
+			return "", 1, err
+		}
+
+		if start := strings.LastIndex(pane, startMarker+"\n"); start >= 0 {
+			body := pane[start+len(startMarker)+1:]
+			if end := strings.Index(body, endMarker); end >= 0 {
+				trailer := body[end+len(endMarker):]
+				if nl := strings.IndexByte(trailer, '\n'); nl >= 0 {
+					trailer = trailer[:nl]
+				}
+				return strings.TrimSuffix(body[:end], "\n"), parseSessionExit(trailer), nil
+			}
+			if time.Now().After(deadline) {
+				// Interrupt the foreground job; the session itself survives
+				exec.Command("tmux", "send-keys", "-t", t.name, "C-c").Run()
+				return body, 124, fmt.Errorf("timed out after %s; sent Ctrl-C to the session", timeout)
+			}
+		} else if time.Now().After(deadline) {
+			exec.Command("tmux", "send-keys", "-t", t.name, "C-c").Run()
+			return "", 124, fmt.Errorf("timed out after %s; sent Ctrl-C to the session", timeout)
+		}
+
+		time.Sleep(REPL_POLL_DELAY * 5)
+	}
+}
+
+// capture returns the full scrollback of the session's pane with wrapped lines joined
+func (t *tmuxSession) capture() (string, error) {
+	output, err := exec.Command("tmux", "capture-pane", "-p", "-J", "-S", "-", "-t", t.name).Output()
+	if err != nil {
+		return "", fmt.Errorf("tmux capture-pane failed: %v", err)
+	}
+	return string(output), nil
+}
+
+func (t *tmuxSession) close() error {
+	return exec.Command("tmux", "kill-session", "-t", t.name).Run()
+}
+
+func (t *tmuxSession) attachHint() string {
+	return fmt.Sprintf("tmux attach -t %s", t.name)
+}
+
+// startSession opens a new persistent shell for tenant using the
+// configured backend
+func (s *ShellServer) startSession(shell string, tenant string) (*shellSession, error) {
+	if shell == "" {
+		shell = DEFAULT_SHELL
+	}
+	if shell != "bash" && shell != "zsh" {
+		return nil, fmt.Errorf("unsupported shell '%s'. Only bash and zsh are supported", shell)
+	}
+
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	if len(s.sessions) >= MAX_SESSIONS {
+		return nil, fmt.Errorf("too many sessions (maximum %d); close one with 'close_session' first", MAX_SESSIONS)
+	}
+
+	s.sessionCounter++
+	session := &shellSession{
+		id:        fmt.Sprintf("session-%d", s.sessionCounter),
+		shell:     shell,
+		backend:   s.sessionBackend,
+		startTime: time.Now(),
+		lastUsed:  time.Now(),
+		tenant:    tenant,
+	}
+
+	var err error
+	switch session.backend {
+	case SESSION_BACKEND_TMUX:
+		session.impl, err = newTmuxSession(fmt.Sprintf("mcp-%d-%s", os.Getpid(), session.id), shell)
+	case SESSION_BACKEND_PIPE, "":
+		session.backend = SESSION_BACKEND_PIPE
+		session.impl, err = newPipeSession(shell, s.control)
+	default:
+		err = fmt.Errorf("unknown session backend '%s'", session.backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.recordDir != "" {
+		if session.recorder, err = s.newCastRecorder("session", session.id, shell); err != nil {
+			s.logger.Printf("Failed to start recording for %s: %v", session.id, err)
+		}
+	}
+
+	s.sessions[session.id] = session
+	return session, nil
+}
+
+// getSession looks up an open session of the calling tenant by ID
+func (s *ShellServer) getSession(ctx context.Context, id string) (*shellSession, bool) {
+	tenant := s.tenant(ctx)
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.tenant != tenant {
+		return nil, false
+	}
+	return session, true
+}
+
+// closeSession terminates a session and forgets it
+func (s *ShellServer) closeSession(id string) error {
+	s.sessionMutex.Lock()
+	session, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.sessionMutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no session with ID '%s'", id)
+	}
+	session.recorder.Close()
+	return session.impl.close()
+}
+
+// closeAllSessions terminates every open session, e.g. on shutdown
+func (s *ShellServer) closeAllSessions() {
+	s.sessionMutex.Lock()
+	ids := make([]string, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	s.sessionMutex.Unlock()
+
+	for _, id := range ids {
+		s.closeSession(id)
+	}
+}
+
+// executeInSession runs a command in a persistent session and records it
+// the same way executeCommand does
+func (s *ShellServer) executeInSession(session *shellSession, command string) CommandExecution {
+	session.runMutex.Lock()
+	defer session.runMutex.Unlock()
+	defer func() { session.lastUsed = time.Now() }()
+
+	execution := CommandExecution{
+		Command:   command,
+		Shell:     session.shell,
+		Session:   session.id,
+		StartTime: time.Now(),
+	}
+
+	session.recorder.input(command)
+	output, exitCode, err := session.impl.run(command, s.timeout)
+	session.recorder.event("o", output)
+
+	execution.EndTime = time.Now()
+	execution.ExecutionMs = execution.EndTime.Sub(execution.StartTime).Milliseconds()
+
+	execution.Output = output
+	execution.ExitCode = exitCode
+	execution.TimedOut = err != nil && exitCode == 124
+	if execution.TimedOut {
+		execution.ErrorCode = ERROR_TIMEOUT
+	}
+	if err != nil {
+		execution.Output += "\n\nError: " + err.Error()
+		if exitCode == 0 {
+			execution.ExitCode = 1
+		}
+	}
+
+	return execution
+}
+
+func (s *ShellServer) handleStartSession(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	shell, _ := request.Params.Arguments["shell"].(string)
+
+	session, err := s.startSession(shell, s.tenant(ctx))
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	text := fmt.Sprintf("Started %s session '%s' (%s backend). Pass session_id to execute_command to run commands in it.", session.shell, session.id, session.backend)
+	if hint := session.impl.attachHint(); hint != "" {
+		text += fmt.Sprintf("\nA human can observe or take over with: %s", hint)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: text,
+			},
+		},
+	}, nil
+}
+
+func (s *ShellServer) handleCloseSession(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	id, _ := request.Params.Arguments["session_id"].(string)
+
+	err := fmt.Errorf("no session with ID '%s'", id)
+	if _, found := s.getSession(ctx, id); found {
+		err = s.closeSession(id)
+	}
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: fmt.Sprintf("Closed session '%s'.", id),
+			},
+		},
+	}, nil
+}
+
+func (s *ShellServer) handleListSessions(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	tenant := s.tenant(ctx)
+	s.sessionMutex.Lock()
+	sessions := make([]*shellSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if session.tenant == tenant {
+			sessions = append(sessions, session)
+		}
+	}
+	s.sessionMutex.Unlock()
+
+	if len(sessions) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "No sessions are open. Use 'start_session' to open one.",
+				},
+			},
+		}, nil
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].startTime.Before(sessions[j].startTime)
+	})
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Open sessions (%d):\n\n", len(sessions)))
+	for i, session := range sessions {
+		result.WriteString(fmt.Sprintf("%d. %s [%s, %s backend] started %s\n",
+			i+1, session.id, session.shell, session.backend, session.startTime.Format(time.RFC3339)))
+		if hint := session.impl.attachHint(); hint != "" {
+			result.WriteString(fmt.Sprintf("   Attach: %s\n", hint))
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result.String(),
+			},
+		},
+	}, nil
+}
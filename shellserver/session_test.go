@@ -0,0 +1,97 @@
+package shellserver
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestWrapSessionCommand(t *testing.T) {
+	wrapped, startMarker, endMarker := wrapSessionCommand("echo hi", "42", "")
+
+	// The typed command must never contain the markers verbatim, otherwise
+	// the echoed input would be mistaken for output
+	if strings.Contains(wrapped, startMarker) || strings.Contains(wrapped, endMarker) {
+		t.Errorf("wrapSessionCommand() leaks markers into the command: %q", wrapped)
+	}
+}
+
+func testSessionBackend(t *testing.T, backend string) {
+	s, err := NewShellServer(WithAllowedCommands("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	s.sessionBackend = backend
+
+	session, err := s.startSession("bash", "")
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	defer s.closeAllSessions()
+
+	// Working directory and variables persist across commands
+	s.executeInSession(session, "cd /tmp && export GREETING=hello")
+	execution := s.executeInSession(session, "pwd; echo $GREETING")
+	if execution.ExitCode != 0 || strings.TrimSpace(execution.Output) != "/tmp\nhello" {
+		t.Errorf("executeInSession() = (%q, %d), want (\"/tmp\\nhello\", 0)", execution.Output, execution.ExitCode)
+	}
+
+	execution = s.executeInSession(session, "false")
+	if execution.ExitCode != 1 {
+		t.Errorf("executeInSession(false) exit code = %d, want 1", execution.ExitCode)
+	}
+
+	execution = s.executeInSession(session, "for i in 1 2; do\n  echo line$i\ndone")
+	if strings.TrimSpace(execution.Output) != "line1\nline2" {
+		t.Errorf("executeInSession(multi-line) = %q", execution.Output)
+	}
+}
+
+func TestPipeSession(t *testing.T) {
+	testSessionBackend(t, SESSION_BACKEND_PIPE)
+}
+
+func TestTmuxSession(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not installed")
+	}
+	testSessionBackend(t, SESSION_BACKEND_TMUX)
+}
+
+func TestSessionEnvQuoted(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	session, err := s.startSession("bash", "")
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	defer s.closeAllSessions()
+
+	tests := []struct {
+		env     string
+		output  string
+		invalid bool
+	}{
+		{"X=a; id", "a; id", false},
+		{"X=$(id)", "$(id)", false},
+		{"X=it's", "it's", false},
+		{"X; id=a", "", true},
+		{"1X=a", "", true},
+	}
+
+	for _, tt := range tests {
+		execution, err := s.runStep(context.Background(), &ExecRequest{Command: "printenv X", Shell: "bash", Session: session.id, Env: []string{tt.env}})
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%q: ran %q, want the name refused", tt.env, execution.Output)
+			}
+			continue
+		}
+		if err != nil || strings.TrimSpace(execution.Output) != tt.output {
+			t.Errorf("%q: output = %q, %v, want %q", tt.env, execution.Output, err, tt.output)
+		}
+	}
+}
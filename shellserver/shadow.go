@@ -0,0 +1,181 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// SHADOW_REPORT_URI is the URI of the structured shadow_policy_report result
+const SHADOW_REPORT_URI = "shell://shadow-report.json"
+
+// MAX_SHADOW_DISAGREEMENTS caps the distinct commands the shadow report keeps
+const MAX_SHADOW_DISAGREEMENTS = 100
+
+// ShadowDecision is what the shadow policy would have decided about a
+// command, recorded in its command events
+type ShadowDecision struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"` // Why it would have been refused
+}
+
+// ShadowDisagreement is a command the shadow policy decided differently
+type ShadowDisagreement struct {
+	Command string `json:"command"`
+	Count   int    `json:"count"`            // Times it was requested
+	Reason  string `json:"reason,omitempty"` // Why the policy that refuses it does
+}
+
+// ShadowReport compares the live policy with the shadow policy over the
+// commands requested since the server started
+type ShadowReport struct {
+	Policy     string               `json:"policy"`     // The shadow policy file or preset
+	Evaluated  int                  `json:"evaluated"`  // Commands both policies decided
+	Agreed     int                  `json:"agreed"`     // Commands both allowed or both refused
+	WouldDeny  []ShadowDisagreement `json:"wouldDeny"`  // Ran, but the shadow policy refuses them
+	WouldAllow []ShadowDisagreement `json:"wouldAllow"` // Refused, but the shadow policy allows them
+	Dropped    int                  `json:"dropped"`    // Disagreements beyond MAX_SHADOW_DISAGREEMENTS
+}
+
+// shadowPolicy evaluates a proposed policy alongside the live one without
+// enforcing it
+type shadowPolicy struct {
+	spec   string
+	policy *AllowlistPolicy
+
+	mutex      sync.Mutex
+	evaluated  int
+	agreed     int
+	wouldDeny  map[string]*ShadowDisagreement
+	wouldAllow map[string]*ShadowDisagreement
+	dropped    int
+}
+
+// WithShadowPolicy evaluates the rules of a policy file or preset (see
+// LoadPreset) against every command in shadow: commands are still decided
+// by the live policy, but command events record what the shadow policy would
+// have decided, and the shadow_policy_report admin tool sums up where the
+// two disagree. It helps tighten an allowlist safely.
+func WithShadowPolicy(spec string) Option {
+	return func(s *ShellServer) error {
+		rules, err := LoadPreset(spec)
+		if err != nil {
+			return err
+		}
+		policy := NewAllowlistPolicy(strings.Join(rules.Allow, ","))
+		if containsString(rules.Allow, "*") {
+			policy = NewAllowlistPolicy("*")
+		}
+		policy.addRules(&PolicyRules{Deny: rules.Deny, DenyPaths: rules.DenyPaths, ReadOnly: rules.ReadOnly})
+		s.shadow = &shadowPolicy{
+			spec:       spec,
+			policy:     policy,
+			wouldDeny:  make(map[string]*ShadowDisagreement),
+			wouldAllow: make(map[string]*ShadowDisagreement),
+		}
+		return nil
+	}
+}
+
+// decide returns what the shadow policy would decide about command
+func (p *shadowPolicy) decide(s *ShellServer, command string) *ShadowDecision {
+	if denied := s.policyDenial(p.policy, command); denied != nil {
+		return &ShadowDecision{Reason: denied.Reason}
+	}
+	return &ShadowDecision{Allowed: true}
+}
+
+// record counts a command the live policy allowed or refused with reason
+func (p *shadowPolicy) record(command string, allowed bool, reason string, shadow *ShadowDecision) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.evaluated++
+	if allowed == shadow.Allowed {
+		p.agreed++
+		return
+	}
+	disagreements := p.wouldAllow
+	if allowed {
+		disagreements, reason = p.wouldDeny, shadow.Reason
+	}
+	if d, found := disagreements[command]; found {
+		d.Count++
+		return
+	}
+	if len(p.wouldDeny)+len(p.wouldAllow) >= MAX_SHADOW_DISAGREEMENTS {
+		p.dropped++
+		return
+	}
+	disagreements[command] = &ShadowDisagreement{Command: command, Count: 1, Reason: reason}
+}
+
+// report sums up the disagreements, most frequent first
+func (p *shadowPolicy) report() ShadowReport {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sorted := func(disagreements map[string]*ShadowDisagreement) []ShadowDisagreement {
+		list := []ShadowDisagreement{}
+		for _, d := range disagreements {
+			list = append(list, *d)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Command < list[j].Command
+		})
+		return list
+	}
+	return ShadowReport{
+		Policy:     p.spec,
+		Evaluated:  p.evaluated,
+		Agreed:     p.agreed,
+		WouldDeny:  sorted(p.wouldDeny),
+		WouldAllow: sorted(p.wouldAllow),
+		Dropped:    p.dropped,
+	}
+}
+
+func (s *ShellServer) handleShadowPolicyReport(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if s.shadow == nil {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: No shadow policy is configured; start the server with --shadow-policy.",
+		}), nil
+	}
+	report := s.shadow.report()
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Shadow policy %s agreed on %d of %d commands.\n", report.Policy, report.Agreed, report.Evaluated))
+	sections := []struct {
+		title         string
+		disagreements []ShadowDisagreement
+	}{
+		{"Ran, but the shadow policy would refuse", report.WouldDeny},
+		{"Refused, but the shadow policy would allow", report.WouldAllow},
+	}
+	for _, section := range sections {
+		if len(section.disagreements) == 0 {
+			continue
+		}
+		result.WriteString(fmt.Sprintf("\n%s:\n", section.title))
+		for _, d := range section.disagreements {
+			result.WriteString(fmt.Sprintf("- %dx %s", d.Count, d.Command))
+			if d.Reason != "" {
+				result.WriteString(fmt.Sprintf(" (%s)", d.Reason))
+			}
+			result.WriteString("\n")
+		}
+	}
+	if report.Dropped > 0 {
+		result.WriteString(fmt.Sprintf("\n%d more disagreements were not kept.\n", report.Dropped))
+	}
+	return jsonResult(result.String(), SHADOW_REPORT_URI, report), nil
+}
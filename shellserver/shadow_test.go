@@ -0,0 +1,74 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShadowPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stricter.json")
+	if err := os.WriteFile(path, []byte(`{"allow": ["echo", "ls"], "deny": ["ls -R"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	recorder := &recordingNotifier{}
+	s, err := NewShellServer(WithAllowedCommands("echo,pwd,ls"), WithShadowPolicy(path), WithNotifier(recorder))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	for _, command := range []string{"echo hi", "pwd", "pwd", "ls -R", "echo a | cat"} {
+		callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": command})
+	}
+
+	tests := []struct {
+		event   string
+		command string
+		allowed bool
+	}{
+		{EVENT_START, "echo hi", true},
+		{EVENT_FINISH, "pwd", false},
+		{EVENT_DENIAL, "echo a | cat", false},
+	}
+	for _, tt := range tests {
+		found := false
+		for _, event := range recorder.events {
+			if event.Event != tt.event || event.Execution.Command != tt.command {
+				continue
+			}
+			found = true
+			if event.Shadow == nil || event.Shadow.Allowed != tt.allowed {
+				t.Errorf("%s event of %q has shadow decision %+v, want allowed %v", tt.event, tt.command, event.Shadow, tt.allowed)
+			}
+		}
+		if !found {
+			t.Errorf("no %s event of %q", tt.event, tt.command)
+		}
+	}
+
+	report := s.shadow.report()
+	if report.Evaluated != 5 || report.Agreed != 2 || len(report.WouldDeny) != 2 || len(report.WouldAllow) != 0 {
+		t.Errorf("shadow report = %+v, want 2 of 5 agreed and 2 commands it would deny", report)
+	}
+	if len(report.WouldDeny) > 0 && (report.WouldDeny[0].Command != "pwd" || report.WouldDeny[0].Count != 2) {
+		t.Errorf("most frequent disagreement = %+v, want pwd twice", report.WouldDeny[0])
+	}
+	text, isError := callTool(t, s.handleShadowPolicyReport, map[string]interface{}{})
+	if isError || !strings.Contains(text, "agreed on 2 of 5 commands") || !strings.Contains(text, "ls -R (command matches the deny rule 'ls -R')") {
+		t.Errorf("shadow_policy_report = %q", text)
+	}
+}
+
+func TestShadowPolicyReportWithoutShadow(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if text, isError := callTool(t, s.handleShadowPolicyReport, map[string]interface{}{}); !isError || !strings.Contains(text, "--shadow-policy") {
+		t.Errorf("shadow_policy_report without a shadow policy = %q, want an error", text)
+	}
+	if _, err := NewShellServer(WithShadowPolicy("no-such-preset")); err == nil {
+		t.Errorf("WithShadowPolicy with an unknown preset should fail")
+	}
+}
@@ -0,0 +1,139 @@
+package shelltest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gamunu/mcp-unix-shell/shellserver"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Client talks JSON-RPC to the shell tools in process, going through the
+// same request parsing and dispatch as a stdio client
+type Client struct {
+	server  *server.MCPServer
+	session *session
+	nextID  int64
+}
+
+// session is the client's side of a stdio-like connection; notifications
+// beyond what its buffer holds are dropped
+type session struct {
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *session) Initialize()       {}
+func (s *session) Initialized() bool { return true }
+func (s *session) SessionID() string { return "shelltest" }
+func (s *session) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+// NewClient registers the tools and hooks of shell on a fresh MCP server and
+// initializes a session with it as the client "shelltest"
+func NewClient(ctx context.Context, shell *shellserver.ShellServer) (*Client, error) {
+	hooks := &server.Hooks{}
+	shell.AddHooks(hooks)
+	mcpServer := server.NewMCPServer("shelltest", "0.1.0", server.WithToolCapabilities(false), server.WithHooks(hooks))
+	shell.RegisterTools(mcpServer)
+
+	c := &Client{server: mcpServer, session: &session{notifications: make(chan mcp.JSONRPCNotification, 100)}}
+	_, err := c.request(ctx, string(mcp.MethodInitialize), map[string]interface{}{
+		"protocolVersion": mcp.LATEST_PROTOCOL_VERSION,
+		"clientInfo":      map[string]interface{}{"name": "shelltest", "version": "0.1.0"},
+		"capabilities":    map[string]interface{}{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize: %v", err)
+	}
+	return c, nil
+}
+
+// ListTools returns the tools the server offers
+func (c *Client) ListTools(ctx context.Context) ([]mcp.Tool, error) {
+	result, err := c.request(ctx, string(mcp.MethodToolsList), map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	switch list := result.(type) {
+	case mcp.ListToolsResult:
+		return list.Tools, nil
+	case *mcp.ListToolsResult:
+		return list.Tools, nil
+	}
+	return nil, fmt.Errorf("unexpected tools/list result %T", result)
+}
+
+// CallTool calls a tool by name. Tool failures are reported through the
+// result's IsError, not as an error.
+func (c *Client) CallTool(ctx context.Context, name string, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	result, err := c.request(ctx, string(mcp.MethodToolsCall), map[string]interface{}{
+		"name":      name,
+		"arguments": args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch call := result.(type) {
+	case mcp.CallToolResult:
+		return &call, nil
+	case *mcp.CallToolResult:
+		return call, nil
+	}
+	return nil, fmt.Errorf("unexpected tools/call result %T", result)
+}
+
+// request sends a JSON-RPC request and returns its result
+func (c *Client) request(ctx context.Context, method string, params interface{}) (interface{}, error) {
+	message, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": mcp.JSONRPC_VERSION,
+		"id":      atomic.AddInt64(&c.nextID, 1),
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch response := c.server.HandleMessage(c.server.WithContext(ctx, c.session), message).(type) {
+	case mcp.JSONRPCResponse:
+		return response.Result, nil
+	case mcp.JSONRPCError:
+		return nil, fmt.Errorf("%s failed: %s (code %d)", method, response.Error.Message, response.Error.Code)
+	default:
+		return nil, fmt.Errorf("unexpected response to %s: %T", method, response)
+	}
+}
+
+// Text returns the text content of a tool result, joined by newlines
+func Text(result *mcp.CallToolResult) string {
+	var texts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ErrorCode returns the shellserver.ERROR_* code attached to a tool result,
+// or "" if there is none
+func ErrorCode(result *mcp.CallToolResult) string {
+	for _, content := range result.Content {
+		resource, ok := content.(mcp.EmbeddedResource)
+		if !ok {
+			continue
+		}
+		if contents, ok := resource.Resource.(mcp.TextResourceContents); ok && contents.URI == shellserver.ERROR_URI {
+			var toolError shellserver.ToolError
+			if json.Unmarshal([]byte(contents.Text), &toolError) == nil {
+				return toolError.Code
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+// Package shelltest provides utilities for testing code built on
+// shellserver without running real shell commands: a scripted Executor and
+// an in-process MCP client.
+package shelltest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gamunu/mcp-unix-shell/shellserver"
+)
+
+// Result is the scripted outcome of a command
+type Result struct {
+	Output   string
+	ExitCode int
+	Duration time.Duration // Reported execution time
+	TimedOut bool          // Report the command as killed by the timeout
+}
+
+// Call records a command the Executor was asked to run
+type Call struct {
+	Command string
+	Shell   string
+	Env     []string
+}
+
+// script holds the results for one command or prefix
+type script struct {
+	command string
+	prefix  bool
+	results []Result
+	next    int
+}
+
+// Executor is a shellserver.Executor that returns scripted results instead
+// of running commands. Commands without a script fail with exit code 127.
+type Executor struct {
+	mutex   sync.Mutex
+	scripts []*script
+	calls   []Call
+}
+
+// NewExecutor creates an Executor with no scripts
+func NewExecutor() *Executor {
+	return &Executor{}
+}
+
+// On scripts the results for an exact command. Successive calls return
+// successive results; the last one repeats.
+func (e *Executor) On(command string, results ...Result) *Executor {
+	return e.add(&script{command: command, results: results})
+}
+
+// OnPrefix scripts the results for every command starting with prefix
+func (e *Executor) OnPrefix(prefix string, results ...Result) *Executor {
+	return e.add(&script{command: prefix, prefix: true, results: results})
+}
+
+func (e *Executor) add(s *script) *Executor {
+	if len(s.results) == 0 {
+		s.results = []Result{{}}
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.scripts = append(e.scripts, s)
+	return e
+}
+
+// Calls returns the commands run so far, oldest first
+func (e *Executor) Calls() []Call {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]Call(nil), e.calls...)
+}
+
+// Commands returns the command lines run so far, oldest first
+func (e *Executor) Commands() []string {
+	calls := e.Calls()
+	commands := make([]string, len(calls))
+	for i, call := range calls {
+		commands[i] = call.Command
+	}
+	return commands
+}
+
+// Execute implements shellserver.Executor. Exact scripts take precedence
+// over prefixes, and later scripts over earlier ones.
+func (e *Executor) Execute(ctx context.Context, command string, shell string, env []string, stream io.Writer) shellserver.CommandExecution {
+	e.mutex.Lock()
+	e.calls = append(e.calls, Call{Command: command, Shell: shell, Env: append([]string(nil), env...)})
+	result := Result{
+		Output:   fmt.Sprintf("shelltest: no scripted output for '%s'", command),
+		ExitCode: 127,
+	}
+	if s := e.match(command); s != nil {
+		result = s.results[s.next]
+		if s.next < len(s.results)-1 {
+			s.next++
+		}
+	}
+	e.mutex.Unlock()
+
+	if stream != nil {
+		io.WriteString(stream, result.Output)
+	}
+
+	start := time.Now()
+	return shellserver.CommandExecution{
+		Command:     command,
+		Shell:       shell,
+		Output:      result.Output,
+		ExitCode:    result.ExitCode,
+		StartTime:   start,
+		EndTime:     start.Add(result.Duration),
+		ExecutionMs: result.Duration.Milliseconds(),
+		TimedOut:    result.TimedOut,
+	}
+}
+
+// match finds the script for command; the caller holds the mutex
+func (e *Executor) match(command string) *script {
+	var prefixMatch *script
+	for i := len(e.scripts) - 1; i >= 0; i-- {
+		s := e.scripts[i]
+		if !s.prefix && s.command == command {
+			return s
+		}
+		if s.prefix && prefixMatch == nil && strings.HasPrefix(command, s.command) {
+			prefixMatch = s
+		}
+	}
+	return prefixMatch
+}
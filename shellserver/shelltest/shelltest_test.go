@@ -0,0 +1,103 @@
+package shelltest_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gamunu/mcp-unix-shell/shellserver"
+	"github.com/gamunu/mcp-unix-shell/shellserver/shelltest"
+)
+
+func TestExecutorScripts(t *testing.T) {
+	executor := shelltest.NewExecutor().
+		On("git status", shelltest.Result{Output: "clean"}, shelltest.Result{Output: "dirty", ExitCode: 1}).
+		OnPrefix("git ", shelltest.Result{Output: "git"}).
+		OnPrefix("git log", shelltest.Result{Output: "log"})
+
+	tests := []struct {
+		command  string
+		output   string
+		exitCode int
+	}{
+		{"git status", "clean", 0},
+		{"git status", "dirty", 1},
+		{"git status", "dirty", 1}, // The last result repeats
+		{"git log --oneline", "log", 0},
+		{"git diff", "git", 0},
+		{"ls", "shelltest: no scripted output for 'ls'", 127},
+	}
+
+	for _, tt := range tests {
+		execution := executor.Execute(context.Background(), tt.command, "bash", nil, nil)
+		if execution.Output != tt.output || execution.ExitCode != tt.exitCode {
+			t.Errorf("Execute(%q) = %q (exit %d), want %q (exit %d)", tt.command, execution.Output, execution.ExitCode, tt.output, tt.exitCode)
+		}
+	}
+
+	if got := len(executor.Calls()); got != len(tests) {
+		t.Errorf("Calls() has %d entries, want %d", got, len(tests))
+	}
+}
+
+func TestClient(t *testing.T) {
+	executor := shelltest.NewExecutor().On("echo hi", shelltest.Result{Output: "hi\n"})
+	shell, err := shellserver.NewShellServer(shellserver.WithAllowedCommands("echo"), shellserver.WithExecutor(executor))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer shell.Close()
+
+	ctx := context.Background()
+	client, err := shelltest.NewClient(ctx, shell)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+	found := false
+	for _, tool := range tools {
+		found = found || tool.Name == "execute_command"
+	}
+	if !found {
+		t.Errorf("ListTools returned %d tools without execute_command", len(tools))
+	}
+
+	result, err := client.CallTool(ctx, "execute_command", map[string]interface{}{"command": "echo hi"})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError || !strings.Contains(shelltest.Text(result), "hi") {
+		t.Errorf("execute_command = %q (error %v), want the scripted output", shelltest.Text(result), result.IsError)
+	}
+
+	result, err = client.CallTool(ctx, "execute_command", map[string]interface{}{"command": "rm -rf /"})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError || shelltest.ErrorCode(result) != shellserver.ERROR_POLICY_DENIED {
+		t.Errorf("execute_command should refuse 'rm' with %s, got %q", shellserver.ERROR_POLICY_DENIED, shelltest.ErrorCode(result))
+	}
+	if commands := executor.Commands(); len(commands) != 1 || commands[0] != "echo hi" {
+		t.Errorf("executor ran %v, want only 'echo hi'", commands)
+	}
+
+	if _, err := client.CallTool(ctx, "no_such_tool", nil); err == nil {
+		t.Errorf("calling an unknown tool should fail")
+	}
+}
+
+func Example() {
+	executor := shelltest.NewExecutor().On("uptime", shelltest.Result{Output: "up 3 days"})
+	shell, _ := shellserver.NewShellServer(shellserver.WithAllowedCommands("uptime"), shellserver.WithExecutor(executor))
+	defer shell.Close()
+
+	client, _ := shelltest.NewClient(context.Background(), shell)
+	result, _ := client.CallTool(context.Background(), "execute_command", map[string]interface{}{"command": "uptime"})
+	fmt.Println(strings.Contains(shelltest.Text(result), "up 3 days"), executor.Commands())
+	// Output: true [uptime]
+}
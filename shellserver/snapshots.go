@@ -0,0 +1,391 @@
+package shellserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// SNAPSHOT_URI is the URI of the structured snapshot_session result
+const SNAPSHOT_URI = "shell://snapshot.json"
+
+var (
+	// snapshotNamePattern matches the names sessions are snapshotted under
+	snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+	// envNamePattern matches the environment variables a snapshot restores
+	envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// snapshotEnvScript prints the session's environment one variable per line,
+// with '%' and newlines percent-encoded. awk is used because its ENVIRON
+// reads the same in bash and zsh.
+const snapshotEnvScript = `awk 'BEGIN { for (k in ENVIRON) { v = ENVIRON[k]; gsub(/%/, "%25", v); gsub(/\n/, "%0A", v); print k "=" v } }'`
+
+// snapshotVarScripts print, by shell, the declarations of the session's
+// shell variables that are neither exported, which the environment covers,
+// nor read-only or maintained by the shell itself
+var snapshotVarScripts = map[string]string{
+	"bash": `for __mcp_v in $(compgen -v); do
+  case "$__mcp_v" in
+    __mcp_*|BASH*|COMP_*|DIRSTACK|EPOCH*|FUNCNAME|GROUPS|HIST*|HOSTNAME|HOSTTYPE|IFS|LINENO|LINES|COLUMNS|MACHTYPE|MAILCHECK|OPTERR|OPTIND|OSTYPE|PIPESTATUS|PS[0-4]|RANDOM|SECONDS|SRANDOM|_) continue ;;
+  esac
+  __mcp_d=$(declare -p "$__mcp_v" 2>/dev/null) || continue
+  __mcp_f=${__mcp_d#declare -}
+  case "${__mcp_f%% *}" in *[rx]*) continue ;; esac
+  printf '%s\n' "$__mcp_d"
+done
+unset __mcp_v __mcp_d __mcp_f`,
+	"zsh": `zmodload zsh/parameter
+for __mcp_v in ${(k)parameters}; do
+  case "$__mcp_v" in __mcp_*|_) continue ;; esac
+  case "${parameters[$__mcp_v]}" in *export*|*readonly*|*special*|*local*) continue ;; esac
+  typeset -p -- "$__mcp_v"
+done
+unset __mcp_v`,
+}
+
+// SessionSnapshot is the state of a persistent session that
+// snapshot_session saves and restore_session brings back
+type SessionSnapshot struct {
+	Name      string            `json:"name"`
+	Session   string            `json:"session"` // ID of the snapshotted session
+	Shell     string            `json:"shell"`
+	Dir       string            `json:"dir"`                 // Working directory
+	Env       map[string]string `json:"env,omitempty"`       // Variables set or changed from the server's environment
+	Unset     []string          `json:"unset,omitempty"`     // Variables of the server's environment the session unset
+	Variables string            `json:"variables,omitempty"` // Shell variable declarations, as the shell prints them
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// WithSessionSnapshots lets snapshot_session save session state to dir and
+// restore_session bring it back, also after the server restarts. Snapshots
+// hold the sessions' environment, so dir is created private.
+func WithSessionSnapshots(dir string) Option {
+	return func(s *ShellServer) error {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if err := privateDir(dir); err != nil {
+			return fmt.Errorf("cannot create snapshot directory: %v", err)
+		}
+		s.snapshotDir = dir
+		return nil
+	}
+}
+
+// snapshotSession captures the working directory, environment and shell
+// variables of a session
+func (s *ShellServer) snapshotSession(session *shellSession, name string) (*SessionSnapshot, error) {
+	session.runMutex.Lock()
+	defer session.runMutex.Unlock()
+
+	run := func(script string) (string, error) {
+		output, exitCode, err := session.impl.run(script, s.timeout)
+		if err != nil {
+			return "", err
+		}
+		if exitCode != 0 {
+			return "", fmt.Errorf("exit code %d: %s", exitCode, strings.TrimSpace(output))
+		}
+		return output, nil
+	}
+
+	snapshot := &SessionSnapshot{Name: name, Session: session.id, Shell: session.shell, CreatedAt: s.now()}
+	dir, err := run("pwd")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the working directory: %v", err)
+	}
+	snapshot.Dir = strings.TrimSpace(dir)
+
+	env, err := run(snapshotEnvScript)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the environment: %v", err)
+	}
+	snapshot.Env, snapshot.Unset = envDiff(env, os.Environ())
+
+	if script, found := snapshotVarScripts[session.shell]; found {
+		if snapshot.Variables, err = run(script); err != nil {
+			return nil, fmt.Errorf("cannot read the shell variables: %v", err)
+		}
+	}
+	return snapshot, nil
+}
+
+// envDiff compares the output of snapshotEnvScript with the server's
+// environment. It returns the variables the session set or changed and
+// those it unset. Variables the shell maintains are left out.
+func envDiff(output string, environ []string) (map[string]string, []string) {
+	skip := map[string]bool{"_": true, "PWD": true, "OLDPWD": true, "SHLVL": true}
+	base := make(map[string]string)
+	for _, entry := range environ {
+		if name, value, found := strings.Cut(entry, "="); found {
+			base[name] = value
+		}
+	}
+
+	env := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		name, encoded, found := strings.Cut(line, "=")
+		if !found || skip[name] || !envNamePattern.MatchString(name) {
+			continue
+		}
+		value, err := url.PathUnescape(encoded)
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+		if current, inherited := base[name]; !inherited || current != value {
+			env[name] = value
+		}
+	}
+
+	var unset []string
+	for name := range base {
+		if !seen[name] && !skip[name] && envNamePattern.MatchString(name) {
+			unset = append(unset, name)
+		}
+	}
+	sort.Strings(unset)
+	return env, unset
+}
+
+// restoreScript returns the commands that bring a session to the snapshot's
+// state
+func (snapshot *SessionSnapshot) restoreScript() string {
+	var script strings.Builder
+	fmt.Fprintf(&script, "cd -- %s", shellQuote(snapshot.Dir))
+	if len(snapshot.Unset) > 0 {
+		fmt.Fprintf(&script, "\nunset %s", strings.Join(snapshot.Unset, " "))
+	}
+	names := make([]string, 0, len(snapshot.Env))
+	for name := range snapshot.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&script, "\nexport %s=%s", name, shellQuote(snapshot.Env[name]))
+	}
+	if snapshot.Variables != "" {
+		fmt.Fprintf(&script, "\neval %s", shellQuote(snapshot.Variables))
+	}
+	return script.String()
+}
+
+// tenantSnapshotDir returns the directory tenant's snapshots are saved in.
+// Each authenticated tenant has its own, so names never collide.
+func (s *ShellServer) tenantSnapshotDir(tenant string) string {
+	if tenant == "" {
+		return s.snapshotDir
+	}
+	sum := sha256.Sum256([]byte(tenant))
+	return filepath.Join(s.snapshotDir, "tenants", hex.EncodeToString(sum[:16]))
+}
+
+// snapshotPath returns the file a snapshot of tenant is saved in
+func (s *ShellServer) snapshotPath(tenant string, name string) string {
+	return filepath.Join(s.tenantSnapshotDir(tenant), name+".json")
+}
+
+// saveSnapshot writes a snapshot of tenant, replacing one of the same name
+func (s *ShellServer) saveSnapshot(tenant string, snapshot *SessionSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.tenantSnapshotDir(tenant), 0700); err != nil {
+		return err
+	}
+	file, err := openPrivateFile(s.snapshotPath(tenant, snapshot.Name), os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// loadSnapshot reads a saved snapshot of tenant
+func (s *ShellServer) loadSnapshot(tenant string, name string) (*SessionSnapshot, error) {
+	data, err := os.ReadFile(s.snapshotPath(tenant, name))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot SessionSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %v", name, err)
+	}
+	for name := range snapshot.Env {
+		if !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid snapshot %s: invalid variable name '%s'", snapshot.Name, name)
+		}
+	}
+	for _, name := range snapshot.Unset {
+		if !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid snapshot %s: invalid variable name '%s'", snapshot.Name, name)
+		}
+	}
+	return &snapshot, nil
+}
+
+// snapshotNames returns the names of tenant's saved snapshots
+func (s *ShellServer) snapshotNames(tenant string) []string {
+	paths, _ := filepath.Glob(filepath.Join(s.tenantSnapshotDir(tenant), "*.json"))
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		names = append(names, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+	return names
+}
+
+func (s *ShellServer) handleSnapshotSession(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if s.snapshotDir == "" {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: s.message(MSG_NO_SNAPSHOTS)}), nil
+	}
+	id, _ := request.Params.Arguments["session_id"].(string)
+	session, found := s.getSession(ctx, id)
+	if !found {
+		return errorResult(ToolError{
+			Code:    ERROR_SESSION_NOT_FOUND,
+			Message: s.message(MSG_SESSION_NOT_FOUND, id),
+			Details: map[string]interface{}{"argument": "session_id", "session_id": id},
+		}), nil
+	}
+	name, _ := request.Params.Arguments["name"].(string)
+	if name == "" {
+		name = session.id + "-" + s.now().Format("20060102-150405")
+	}
+	if !snapshotNamePattern.MatchString(name) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'name' must be 1 to 64 letters, digits, '.', '_' or '-', starting with a letter or digit",
+			Details: map[string]interface{}{"argument": "name"},
+		}), nil
+	}
+
+	snapshot, err := s.snapshotSession(session, name)
+	if err == nil {
+		err = s.saveSnapshot(session.tenant, snapshot)
+	}
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: Cannot snapshot session '%s': %v", session.id, err),
+			Details: map[string]interface{}{"session_id": session.id},
+		}), nil
+	}
+
+	text := fmt.Sprintf("Saved session '%s' as snapshot '%s': directory %s, %d environment variables set and %d unset.",
+		session.id, name, snapshot.Dir, len(snapshot.Env), len(snapshot.Unset))
+	text += fmt.Sprintf(" Bring it back with restore_session name=%s.", name)
+	// The environment may hold secrets, so only its names are returned
+	summary := map[string]interface{}{
+		"name":    snapshot.Name,
+		"session": snapshot.Session,
+		"shell":   snapshot.Shell,
+		"dir":     snapshot.Dir,
+		"unset":   snapshot.Unset,
+	}
+	names := make([]string, 0, len(snapshot.Env))
+	for name := range snapshot.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	summary["env"] = names
+	return jsonResult(text, SNAPSHOT_URI, summary), nil
+}
+
+func (s *ShellServer) handleRestoreSession(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if s.snapshotDir == "" {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: s.message(MSG_NO_SNAPSHOTS)}), nil
+	}
+	tenant := s.tenant(ctx)
+	name, _ := request.Params.Arguments["name"].(string)
+	if !snapshotNamePattern.MatchString(name) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_SNAPSHOT_NOT_FOUND, name),
+			Details: map[string]interface{}{"argument": "name", "name": name, "snapshots": s.snapshotNames(tenant)},
+		}), nil
+	}
+	snapshot, err := s.loadSnapshot(tenant, name)
+	if os.IsNotExist(err) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_SNAPSHOT_NOT_FOUND, name),
+			Details: map[string]interface{}{"argument": "name", "name": name, "snapshots": s.snapshotNames(tenant)},
+		}), nil
+	}
+	if err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: fmt.Sprintf("Error: %v", err)}), nil
+	}
+
+	// Restore into the given session, or a new one of the snapshot's shell
+	var session *shellSession
+	if id, _ := request.Params.Arguments["session_id"].(string); id != "" {
+		var found bool
+		if session, found = s.getSession(ctx, id); !found {
+			return errorResult(ToolError{
+				Code:    ERROR_SESSION_NOT_FOUND,
+				Message: s.message(MSG_SESSION_NOT_FOUND, id),
+				Details: map[string]interface{}{"argument": "session_id", "session_id": id},
+			}), nil
+		}
+		if session.shell != snapshot.Shell {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: fmt.Sprintf("Error: Snapshot '%s' is of a %s session, but session '%s' runs %s.", name, snapshot.Shell, id, session.shell),
+				Details: map[string]interface{}{"argument": "session_id", "session_id": id, "shell": snapshot.Shell},
+			}), nil
+		}
+	} else if session, err = s.startSession(snapshot.Shell, s.tenant(ctx)); err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: fmt.Sprintf("Error: %v", err)}), nil
+	}
+
+	session.runMutex.Lock()
+	output, exitCode, err := session.impl.run(snapshot.restoreScript(), s.timeout)
+	session.runMutex.Unlock()
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: Cannot restore snapshot '%s' into session '%s': %v", name, session.id, err),
+			Details: map[string]interface{}{"session_id": session.id},
+		}), nil
+	}
+
+	text := fmt.Sprintf("Restored snapshot '%s' into %s session '%s', in %s. Pass session_id to execute_command to run commands in it.",
+		name, session.shell, session.id, snapshot.Dir)
+	if exitCode != 0 {
+		// A directory that no longer exists or a variable that cannot be
+		// set leaves the rest of the state restored
+		text += fmt.Sprintf("\nWarning: some state could not be restored (exit code %d):\n%s", exitCode, output)
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: text,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,103 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestEnvDiff(t *testing.T) {
+	environ := []string{"HOME=/home/me", "PATH=/bin", "GONE=1", "PWD=/"}
+	output := "HOME=/home/me\nPATH=/opt/bin:/bin\nNEW=a%0Ab%25c\nPWD=/tmp\n_=/usr/bin/awk\n1BAD=x"
+
+	env, unset := envDiff(output, environ)
+	wantEnv := map[string]string{"PATH": "/opt/bin:/bin", "NEW": "a\nb%c"}
+	if !reflect.DeepEqual(env, wantEnv) {
+		t.Errorf("envDiff() env = %v, want %v", env, wantEnv)
+	}
+	if !reflect.DeepEqual(unset, []string{"GONE"}) {
+		t.Errorf("envDiff() unset = %v, want [GONE]", unset)
+	}
+}
+
+func TestSessionSnapshots(t *testing.T) {
+	t.Setenv("MCP_SNAPSHOT_GONE", "1")
+	dir := t.TempDir()
+	work := t.TempDir()
+
+	s, err := NewShellServer(WithAllowedCommands("*"), WithSessionSnapshots(dir))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	session, err := s.startSession("bash", "")
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	s.executeInSession(session, "cd "+shellQuote(work)+" && export GREETING='hello\nworld 100%' && unset MCP_SNAPSHOT_GONE")
+	s.executeInSession(session, `count=3; list=(a "b c"); declare -i total=7`)
+
+	text, isError := callTool(t, s.handleSnapshotSession, map[string]interface{}{"session_id": session.id, "name": "work"})
+	if isError {
+		t.Fatalf("snapshot_session failed: %s", text)
+	}
+	if strings.Contains(text, "hello") {
+		t.Errorf("snapshot_session result reveals environment values")
+	}
+	if info, err := os.Stat(s.snapshotPath("", "work")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("snapshot file = (%v, %v), want mode 0600", info, err)
+	}
+	s.closeAllSessions()
+
+	// A new server, as after a restart, brings the state back
+	s, err = NewShellServer(WithAllowedCommands("*"), WithSessionSnapshots(dir))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.closeAllSessions()
+	text, isError = callTool(t, s.handleRestoreSession, map[string]interface{}{"name": "work"})
+	if isError {
+		t.Fatalf("restore_session failed: %s", text)
+	}
+	if strings.Contains(text, "Warning") {
+		t.Errorf("restore_session reported a warning: %s", text)
+	}
+	if len(s.sessions) != 1 {
+		t.Fatalf("restore_session opened %d sessions, want 1", len(s.sessions))
+	}
+	for _, restored := range s.sessions {
+		execution := s.executeInSession(restored, `pwd; printf '%s|' "$GREETING" "${MCP_SNAPSHOT_GONE-unset}" "$count" "${list[1]}" "$((total + 1))"`)
+		want := work + "\nhello\nworld 100%|unset|3|b c|8|"
+		if execution.Output != want {
+			t.Errorf("restored session state = %q, want %q", execution.Output, want)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]interface{}
+		want    string
+	}{
+		{"unknown snapshot", s.handleRestoreSession, map[string]interface{}{"name": "missing"}, "No snapshot named 'missing'"},
+		{"invalid snapshot name", s.handleRestoreSession, map[string]interface{}{"name": "../work"}, "No snapshot named '../work'"},
+		{"unknown session", s.handleSnapshotSession, map[string]interface{}{"session_id": "session-9"}, "No session with ID 'session-9'"},
+		{"invalid name", s.handleSnapshotSession, map[string]interface{}{"session_id": "session-1", "name": "a/b"}, "'name' must be"},
+	}
+	for _, tt := range tests {
+		if text, isError := callTool(t, tt.handler, tt.args); !isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s: result = %q, want an error containing %q", tt.name, text, tt.want)
+		}
+	}
+
+	disabled, err := NewShellServer(WithAllowedCommands("*"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if text, isError := callTool(t, disabled.handleRestoreSession, map[string]interface{}{"name": "work"}); !isError || !strings.Contains(text, "--snapshot-dir") {
+		t.Errorf("restore_session without --snapshot-dir = %q, want an error", text)
+	}
+}
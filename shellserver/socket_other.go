@@ -0,0 +1,11 @@
+//go:build !unix
+
+package shellserver
+
+import "net"
+
+// listenUnix listens on a Unix socket; without a umask, the caller's chmod
+// restricts it
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
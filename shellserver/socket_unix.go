@@ -0,0 +1,17 @@
+//go:build unix
+
+package shellserver
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix listens on a Unix socket created with mode 0600, so nobody
+// else can connect before it is chmodded. The umask is process-wide, but
+// sockets are only created while the server starts.
+func listenUnix(path string) (net.Listener, error) {
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
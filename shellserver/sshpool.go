@@ -0,0 +1,208 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connections to SSH targets are multiplexed with OpenSSH's ControlMaster:
+// the first command to a host opens a master connection that later
+// commands share, and that stays open for SSH_CONTROL_PERSIST after the
+// last one. A host's sessions are capped below sshd's MaxSessions, and a
+// host that cannot be reached is not tried again until its backoff ends.
+
+// SSH connection pool settings
+const (
+	SSH_MAX_SESSIONS       = 8               // Concurrent sessions per host by default; sshd allows 10
+	SSH_CONTROL_PERSIST    = 5 * time.Minute // Idle time before a master connection closes
+	SSH_KEEPALIVE_INTERVAL = 15              // Seconds between keepalives on an idle connection
+	SSH_KEEPALIVE_COUNT    = 3               // Keepalives missed before the connection is dropped
+	SSH_BACKOFF_MIN        = time.Second     // Wait after the first failed connection
+	SSH_BACKOFF_MAX        = time.Minute     // Longest wait between connection attempts
+)
+
+// sshPool tracks the sessions and connection failures of each target
+type sshPool struct {
+	controlDir string // Directory of master connection sockets; empty without multiplexing
+	mutex      sync.Mutex
+	hosts      map[string]*pooledHost
+}
+
+// pooledHost is the pool's state for one target
+type pooledHost struct {
+	sessions chan struct{} // Holds a token for each open session
+	failures int           // Consecutive failed connections
+	retryAt  time.Time     // No connection is attempted before this
+}
+
+// newSSHPool creates the pool for targets, with its control sockets in a
+// directory under tempDir. Windows' OpenSSH cannot multiplex, so there each
+// command opens its own connection.
+func newSSHPool(targets *Targets, tempDir string) (*sshPool, error) {
+	pool := &sshPool{hosts: make(map[string]*pooledHost)}
+	pool.add(targets)
+	if runtime.GOOS != "windows" {
+		// Socket paths are limited to about 100 bytes, so keep them short
+		dir, err := os.MkdirTemp(tempDir, "mcp-ssh-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the ssh control directory: %v", err)
+		}
+		pool.controlDir = dir
+	}
+	return pool, nil
+}
+
+// add tracks the targets' hosts the pool does not know yet, which
+// discovery may add at any time
+func (p *sshPool) add(targets *Targets) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for name, host := range targets.Hosts {
+		if p.hosts[name] != nil {
+			continue
+		}
+		sessions := host.MaxSessions
+		if sessions == 0 {
+			sessions = SSH_MAX_SESSIONS
+		}
+		p.hosts[name] = &pooledHost{sessions: make(chan struct{}, sessions)}
+	}
+}
+
+// sshArgs returns the ssh options and destination for a target. Batch mode
+// fails instead of prompting for passwords or host keys.
+func (p *sshPool) sshArgs(host SSHHost) []string {
+	args := []string{
+		"-T",
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=" + strconv.Itoa(SSH_CONNECT_TIMEOUT),
+		"-o", "ServerAliveInterval=" + strconv.Itoa(SSH_KEEPALIVE_INTERVAL),
+		"-o", "ServerAliveCountMax=" + strconv.Itoa(SSH_KEEPALIVE_COUNT),
+	}
+	if p.controlDir != "" {
+		args = append(args,
+			"-o", "ControlMaster=auto",
+			"-o", "ControlPath="+p.controlDir+"/%C",
+			"-o", "ControlPersist="+strconv.Itoa(int(SSH_CONTROL_PERSIST.Seconds())),
+		)
+	}
+	if host.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(host.Port))
+	}
+	if host.IdentityFile != "" {
+		args = append(args, "-i", host.IdentityFile)
+	}
+	if host.User != "" {
+		args = append(args, "-l", host.User)
+	}
+	return append(args, "--", host.Address)
+}
+
+// session waits up to timeout for a free session to a target and returns
+// the function that frees it
+func (p *sshPool) session(ctx context.Context, name string, timeout time.Duration) (func(), error) {
+	p.mutex.Lock()
+	host := p.hosts[name]
+	p.mutex.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case host.sessions <- struct{}{}:
+		return func() { <-host.sessions }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, fmt.Errorf("all %d sessions to %s stayed busy for %s", cap(host.sessions), name, timeout)
+	}
+}
+
+// backoff returns an error while connections to a target are held off
+func (p *sshPool) backoff(name string, now time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	host := p.hosts[name]
+	if now.Before(host.retryAt) {
+		return fmt.Errorf("not reconnecting to %s for %s after %d failed connections",
+			name, host.retryAt.Sub(now).Round(time.Second), host.failures)
+	}
+	return nil
+}
+
+// report records whether a connection to a target failed, doubling the
+// backoff from SSH_BACKOFF_MIN up to SSH_BACKOFF_MAX with each failure
+func (p *sshPool) report(name string, unreachable bool, now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	host := p.hosts[name]
+	if !unreachable {
+		host.failures, host.retryAt = 0, time.Time{}
+		return
+	}
+	host.failures++
+	wait := SSH_BACKOFF_MAX
+	if host.failures < 7 {
+		wait = min(SSH_BACKOFF_MIN<<(host.failures-1), SSH_BACKOFF_MAX)
+	}
+	host.retryAt = now.Add(wait)
+}
+
+// close stops the master connections and removes their sockets
+func (p *sshPool) close(targets *Targets, control processControl) {
+	if p.controlDir == "" {
+		return
+	}
+	for _, host := range targets.Hosts {
+		args := append([]string{"-O", "exit"}, p.sshArgs(host)...)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		control.command(ctx, "ssh", args...).Run()
+		cancel()
+	}
+	os.RemoveAll(p.controlDir)
+}
+
+// sshFailed reports whether an ssh client exited because it could not
+// connect or log in, rather than because of the remote command
+func sshFailed(err error, stderr string) bool {
+	exitError, ok := err.(*exec.ExitError)
+	return ok && exitError.ExitCode() == SSH_UNREACHABLE_EXIT && sshUnreachable(strings.TrimSpace(stderr))
+}
+
+// runOnSSH runs a request on its target with a pooled connection
+func (s *ShellServer) runOnSSH(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+	host, found := s.currentTargets().Hosts[req.Target]
+	if !found {
+		return CommandExecution{}, fmt.Errorf("no host named '%s'", req.Target)
+	}
+
+	// A refused connection looks to the rest of the chain like ssh failing
+	refused := func(err error) (CommandExecution, error) {
+		now := time.Now()
+		return CommandExecution{
+			Command:   req.Command,
+			Shell:     req.Shell,
+			Output:    "ssh: " + err.Error(),
+			ExitCode:  SSH_UNREACHABLE_EXIT,
+			StartTime: now,
+			EndTime:   now,
+		}, nil
+	}
+	if err := s.sshPool.backoff(req.Target, time.Now()); err != nil {
+		return refused(err)
+	}
+	release, err := s.sshPool.session(ctx, req.Target, s.timeout)
+	if err != nil {
+		return refused(err)
+	}
+	defer release()
+
+	execution := s.executeWith(sshExecutor{host: host, pool: s.sshPool, control: s.control}, req, req.Command)
+	s.sshPool.report(req.Target, execution.ExitCode == SSH_UNREACHABLE_EXIT && sshUnreachable(execution.Output), time.Now())
+	return execution, nil
+}
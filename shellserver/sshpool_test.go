@@ -0,0 +1,98 @@
+package shellserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSHArgs(t *testing.T) {
+	host := SSHHost{Address: "db.example.com", User: "deploy", Port: 2222, IdentityFile: "/keys/deploy"}
+	tests := []struct {
+		controlDir string
+		want       string
+	}{
+		{"", "-T -o BatchMode=yes -o ConnectTimeout=10 -o ServerAliveInterval=15 -o ServerAliveCountMax=3 -p 2222 -i /keys/deploy -l deploy -- db.example.com"},
+		{"/tmp/mcp-ssh-1", "-T -o BatchMode=yes -o ConnectTimeout=10 -o ServerAliveInterval=15 -o ServerAliveCountMax=3 -o ControlMaster=auto -o ControlPath=/tmp/mcp-ssh-1/%C -o ControlPersist=300 -p 2222 -i /keys/deploy -l deploy -- db.example.com"},
+	}
+
+	for _, tt := range tests {
+		pool := &sshPool{controlDir: tt.controlDir}
+		if got := strings.Join(pool.sshArgs(host), " "); got != tt.want {
+			t.Errorf("sshArgs() with control dir %q = %q, want %q", tt.controlDir, got, tt.want)
+		}
+	}
+}
+
+func TestSSHPoolBackoff(t *testing.T) {
+	pool, err := newSSHPool(testTargets(), t.TempDir())
+	if err != nil {
+		t.Fatalf("newSSHPool failed: %v", err)
+	}
+	defer pool.close(testTargets(), processControl{})
+
+	now := time.Now()
+	tests := []struct {
+		unreachable bool
+		wait        time.Duration // Backoff after the report
+	}{
+		{true, time.Second},
+		{true, 2 * time.Second},
+		{true, 4 * time.Second},
+		{false, 0},
+		{true, time.Second},
+	}
+
+	for i, tt := range tests {
+		pool.report("web1", tt.unreachable, now)
+		if tt.wait > 0 && pool.backoff("web1", now.Add(tt.wait-time.Millisecond)) == nil {
+			t.Errorf("report %d: no backoff just before %s", i, tt.wait)
+		}
+		if err := pool.backoff("web1", now.Add(tt.wait)); err != nil {
+			t.Errorf("report %d: backoff after %s = %v", i, tt.wait, err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		pool.report("web2", true, now)
+	}
+	if err := pool.backoff("web2", now.Add(SSH_BACKOFF_MAX)); err != nil {
+		t.Errorf("backoff after 20 failures = %v, want at most %s", err, SSH_BACKOFF_MAX)
+	}
+}
+
+func TestSSHPoolSessions(t *testing.T) {
+	targets := testTargets()
+	targets.Hosts["web1"] = SSHHost{Address: "web1", MaxSessions: 1}
+	pool, err := newSSHPool(targets, t.TempDir())
+	if err != nil {
+		t.Fatalf("newSSHPool failed: %v", err)
+	}
+	defer pool.close(targets, processControl{})
+
+	release, err := pool.session(context.Background(), "web1", time.Second)
+	if err != nil {
+		t.Fatalf("first session: %v", err)
+	}
+	if _, err := pool.session(context.Background(), "web1", 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "all 1 sessions") {
+		t.Errorf("second session = %v, want busy", err)
+	}
+	if _, err := pool.session(context.Background(), "web2", 10*time.Millisecond); err != nil {
+		t.Errorf("session to another host = %v", err)
+	}
+	release()
+	if _, err := pool.session(context.Background(), "web1", 10*time.Millisecond); err != nil {
+		t.Errorf("session after release = %v", err)
+	}
+}
+
+func TestExecuteOnTargetsBackoff(t *testing.T) {
+	s := fakeSSHServer(t, WithAllowedCommands("echo"))
+	for i, want := range []string{"Connection refused", "not reconnecting to down1"} {
+		text, _ := callTool(t, s.handleExecuteOnTargets, map[string]interface{}{"command": "echo hi", "targets": "down1"})
+		if !strings.Contains(text, want) || !strings.Contains(text, ERROR_TARGET_UNREACHABLE) {
+			t.Errorf("run %d on down1 = %q, want %q", i, text, want)
+		}
+	}
+}
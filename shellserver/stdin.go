@@ -0,0 +1,150 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MAX_STDIN_SIZE caps the input stdin_resource gives a command by default;
+// see RequestLimits
+const MAX_STDIN_SIZE = 10 * 1024 * 1024
+
+// stdinKey is the context key of a command's input
+type stdinKey struct{}
+
+// withStdin returns ctx carrying data as the input of the command run with it
+func withStdin(ctx context.Context, data []byte) context.Context {
+	return context.WithValue(ctx, stdinKey{}, data)
+}
+
+// StdinFromContext returns the input for the command executed with ctx, or
+// nil if it has none. Executors should give it to the command as its
+// standard input.
+func StdinFromContext(ctx context.Context) io.Reader {
+	data, ok := ctx.Value(stdinKey{}).([]byte)
+	if !ok {
+		return nil
+	}
+	return bytes.NewReader(data)
+}
+
+// executionURI returns the reference to the output of the execution with id
+func executionURI(id int64) string {
+	return fmt.Sprintf("exec://%d/output", id)
+}
+
+// executionOutput returns the output of the execution with id, if it is
+// still in the history and the caller may see it; argument names what
+// referenced it
+func (s *ShellServer) executionOutput(ctx context.Context, id int64, argument string) (string, *ToolError) {
+	execution, toolError := s.findExecution(ctx, id, argument)
+	if toolError != nil {
+		return "", toolError
+	}
+	return execution.Output, nil
+}
+
+// seedExecutionIDs numbers new executions after those already in history,
+// so references stay unique across restarts with a persistent history
+func (s *ShellServer) seedExecutionIDs() {
+	if s.deterministic {
+		return
+	}
+	var last int64
+	if recent, err := s.history.Recent(1); err == nil && len(recent) > 0 {
+		last = recent[0].ID
+	}
+	if count, err := s.history.Count(); err == nil {
+		last = max(last, int64(count))
+	}
+	s.executionID.Store(last)
+}
+
+// stdinResource reads the input the stdin_resource argument refers to: the
+// output of an earlier execution as exec://<id>/output, or a file as
+// file:///path. Files are subject to the same protected paths as the file
+// tools. It returns nil if the argument is not set.
+func (s *ShellServer) stdinResource(ctx context.Context, request mcp.CallToolRequest) ([]byte, *ToolError) {
+	reference, _ := request.Params.Arguments["stdin_resource"].(string)
+	if reference == "" {
+		return nil, nil
+	}
+	invalid := &ToolError{
+		Code:    ERROR_INVALID_ARGUMENT,
+		Message: fmt.Sprintf("Error: 'stdin_resource' must be exec://<id>/output or a file:// URI, not '%s'", reference),
+		Details: map[string]interface{}{"argument": "stdin_resource"},
+		Hint:    "list_recent_commands shows the exec:// reference of each recent execution",
+	}
+	target, err := url.Parse(reference)
+	if err != nil {
+		return nil, invalid
+	}
+
+	switch target.Scheme {
+	case "exec":
+		id, err := strconv.ParseInt(target.Host, 10, 64)
+		if err != nil || id <= 0 || target.Path != "/output" {
+			return nil, invalid
+		}
+		output, toolError := s.executionOutput(ctx, id, "stdin_resource")
+		if toolError != nil {
+			return nil, toolError
+		}
+		if len(output) > s.requestLimits.StdinSize {
+			return nil, s.stdinTooLarge(reference)
+		}
+		return []byte(output), nil
+
+	case "file":
+		if (target.Host != "" && target.Host != "localhost") || !strings.HasPrefix(target.Path, "/") {
+			return nil, invalid
+		}
+		// Resolve the path as the file tools do, through their path argument
+		arguments := map[string]interface{}{"path": target.Path}
+		if project, ok := request.Params.Arguments["project"]; ok {
+			arguments["project"] = project
+		}
+		var pathRequest mcp.CallToolRequest
+		pathRequest.Params.Name = request.Params.Name
+		pathRequest.Params.Arguments = arguments
+		path, toolError := s.inspectPath(ctx, pathRequest, "path")
+		if toolError != nil {
+			return nil, toolError
+		}
+		file, info, toolError := openRegular(path)
+		if toolError != nil {
+			return nil, toolError
+		}
+		defer file.Close()
+		if info.Size() > int64(s.requestLimits.StdinSize) {
+			return nil, s.stdinTooLarge(reference)
+		}
+		data, err := io.ReadAll(io.LimitReader(file, int64(s.requestLimits.StdinSize)+1))
+		if err != nil {
+			return nil, fileError(path, err)
+		}
+		if len(data) > s.requestLimits.StdinSize {
+			return nil, s.stdinTooLarge(reference)
+		}
+		return data, nil
+
+	default:
+		return nil, invalid
+	}
+}
+
+// stdinTooLarge reports an input over the stdin limit
+func (s *ShellServer) stdinTooLarge(reference string) *ToolError {
+	return &ToolError{
+		Code:    ERROR_FILE_TOO_LARGE,
+		Message: s.message(MSG_STDIN_TOO_LARGE, reference, s.requestLimits.StdinSize),
+		Details: map[string]interface{}{"argument": "stdin_resource", "limitBytes": s.requestLimits.StdinSize},
+	}
+}
@@ -0,0 +1,104 @@
+package shellserver
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestStdinResource(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "words.txt"), []byte("alpha\nbeta\ngamma\n"), 0o644)
+	os.Mkdir(filepath.Join(dir, "secrets"), 0o755)
+	os.WriteFile(filepath.Join(dir, "secrets", "key.txt"), []byte("hunter2\n"), 0o600)
+
+	s, err := NewShellServer(
+		WithAllowedCommands("echo,cat,wc,sort"),
+		WithPolicyRules(&PolicyRules{DenyPaths: []string{"secrets"}}),
+		WithProjects([]Project{{Name: "app", Dir: dir}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{map[string]interface{}{"command": "echo banana; echo apple"}, "output at exec://1/output", ""},
+		{map[string]interface{}{"command": "sort", "stdin_resource": "exec://1/output"}, "apple\nbanana\n", ""},
+		{map[string]interface{}{"command": "wc -l", "stdin_resource": "file://" + filepath.Join(dir, "words.txt")}, "3", ""},
+		{map[string]interface{}{"command": "cat", "stdin_resource": "exec://99/output"}, "Execution 99 is not in the recent history", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"command": "cat", "stdin_resource": "exec://1/stderr"}, "must be exec://<id>/output or a file:// URI", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"command": "cat", "stdin_resource": "https://example.com/"}, "must be exec://<id>/output or a file:// URI", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"command": "cat", "stdin_resource": "file://words.txt"}, "must be exec://<id>/output or a file:// URI", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"command": "cat", "stdin_resource": "file://" + filepath.Join(dir, "secrets", "key.txt")}, "under the protected path 'secrets'", ERROR_POLICY_DENIED},
+		{map[string]interface{}{"command": "cat", "stdin_resource": "file://" + filepath.Join(dir, "missing.txt")}, "does not exist", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"command": "cat", "stdin_resource": "file://" + dir}, "is a directory", ERROR_INVALID_ARGUMENT},
+		{map[string]interface{}{"command": "cat", "stdin_resource": "exec://1/output", "session_id": "missing"}, "No session with ID 'missing'", ERROR_SESSION_NOT_FOUND},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		request.Params.Arguments["project"] = "app"
+		result, _ := s.handleExecuteCommand(context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("execute_command(%v) = %q (code %q), want %q (code %q)", tt.args, text, code, tt.want, tt.wantCode)
+		}
+	}
+
+	// Commands run without a resource still get no input
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "cat"}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "$ cat\n\n\n\nCommand completed successfully") {
+		t.Errorf("cat without stdin_resource = %q, want no output", text)
+	}
+
+	// The history lists the reference of each execution
+	result, _ = s.handleListRecentCommands(context.Background(), mcp.CallToolRequest{})
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "$ echo banana; echo apple\n   Shell: bash") || !strings.Contains(text, "Output: exec://1/output") {
+		t.Errorf("list_recent_commands = %q, want the exec:// reference of each entry", text)
+	}
+}
+
+func TestExecutionIDsFollowHistory(t *testing.T) {
+	history := newMemoryHistory(MAX_HISTORY_SIZE)
+	history.Add(CommandExecution{Command: "ls"})
+	history.Add(CommandExecution{ID: 7, Command: "ls"})
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithHistoryStore(history))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	execution, err := s.exec(context.Background(), &ExecRequest{Command: "echo hi", Shell: DEFAULT_SHELL})
+	if err != nil || execution.ID != 8 {
+		t.Errorf("exec after ID 7 = %d, %v, want ID 8", execution.ID, err)
+	}
+}
+
+func TestStdinFromContext(t *testing.T) {
+	if reader := StdinFromContext(context.Background()); reader != nil {
+		t.Errorf("StdinFromContext without input = %v, want nil", reader)
+	}
+	ctx := withStdin(context.Background(), []byte("input"))
+	// Every caller reads the whole input
+	for i := 0; i < 2; i++ {
+		data, _ := io.ReadAll(StdinFromContext(ctx))
+		if string(data) != "input" {
+			t.Errorf("StdinFromContext read %q, want %q", data, "input")
+		}
+	}
+}
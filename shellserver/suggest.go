@@ -0,0 +1,106 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// MAX_PATH_COMMANDS caps how many PATH entries are considered for a suggestion
+const MAX_PATH_COMMANDS = 10000
+
+// notFoundPatterns extract the missing command from bash, zsh and sh errors
+var notFoundPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?m)(?:^|: )([^\s:]+): command not found$`), // bash
+	regexp.MustCompile(`(?m)command not found: (\S+)$`),             // zsh
+	regexp.MustCompile(`(?m)^sh: \d+: (\S+): not found$`),           // dash
+}
+
+// missingCommand returns the command a shell reported as not found, or ""
+func missingCommand(output string) string {
+	for _, pattern := range notFoundPatterns {
+		if match := pattern.FindStringSubmatch(output); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and swaps of adjacent characters
+// each cost one, so "gti" is one edit from "git"
+func editDistance(a string, b string) int {
+	previous2 := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = min(current[j], previous2[j-2]+1)
+			}
+		}
+		previous2, previous, current = previous, current, previous2
+	}
+	return previous[len(b)]
+}
+
+// closeEnough is the largest distance at which name is taken to be a typo
+func closeEnough(name string) int {
+	if len(name) <= 4 {
+		return 1
+	}
+	return 2
+}
+
+// suggestCommand returns the allowed command nearest to name, from the
+// allowlist and the executables on PATH, or "" if none is close enough
+func suggestCommand(policy Policy, name string) string {
+	candidates := pathCommands()
+	if allowlist, ok := policy.(*AllowlistPolicy); ok {
+		candidates = append(candidates, allowlist.Commands()...)
+	}
+
+	best, bestDistance := "", closeEnough(name)+1
+	sort.Strings(candidates)
+	for _, candidate := range candidates {
+		if candidate == name {
+			continue
+		}
+		distance := editDistance(name, candidate)
+		// Only allowed commands are worth suggesting
+		if distance < bestDistance && policy.Allowed(candidate) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// pathCommands lists the executables in the directories on PATH
+func pathCommands() []string {
+	var commands []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if len(commands) == MAX_PATH_COMMANDS {
+				return commands
+			}
+			if info, err := entry.Info(); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				commands = append(commands, entry.Name())
+			}
+		}
+	}
+	return commands
+}
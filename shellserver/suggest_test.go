@@ -0,0 +1,107 @@
+package shellserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"git", "git", 0},
+		{"gti", "git", 1},
+		{"grpe", "grep", 1},
+		{"kubectl", "kubctl", 1},
+		{"ls", "cd", 2},
+		{"", "ls", 2},
+		{"docker", "podman", 5},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMissingCommand(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"bash: gti: command not found\n", "gti"},
+		{"bash: line 1: gti: command not found", "gti"},
+		{"zsh:1: command not found: gti", "gti"},
+		{"sh: 1: gti: not found", "gti"},
+		{"make: *** [build] Error 127", ""},
+	}
+
+	for _, tt := range tests {
+		if got := missingCommand(tt.output); got != tt.want {
+			t.Errorf("missingCommand(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestCommand(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("git,grep,kubectl"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"gti", "git"},
+		{"grpe", "grep"},
+		{"kubctl", "kubectl"},
+		{"rm", ""},       // Nothing allowed is close
+		{"gzip", ""},     // On PATH but not allowed
+		{"zzzzzzzz", ""}, // Nothing close at all
+	}
+
+	for _, tt := range tests {
+		if got := suggestCommand(s.policy, tt.name); got != tt.want {
+			t.Errorf("suggestCommand(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteCommandSuggestions(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("gti,git"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		command string
+		code    string
+		hint    string
+	}{
+		{"gitt status", ERROR_POLICY_DENIED, "Did you mean 'git'?"},
+		{"gti status", ERROR_COMMAND_NOT_FOUND, "Did you mean 'git'?"},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"command": tt.command}
+		result, _ := s.handleExecuteCommand(context.Background(), request)
+		toolError := resultError(t, result)
+		if toolError == nil || toolError.Code != tt.code || toolError.Hint != tt.hint || toolError.Details["suggestion"] != "git" {
+			t.Errorf("%s = %+v, want %s with hint %q", tt.command, toolError, tt.code, tt.hint)
+		}
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "gitt status"}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.HasSuffix(text, "Did you mean 'git'?") {
+		t.Errorf("denial text = %q, want the suggestion", text)
+	}
+}
@@ -0,0 +1,155 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// SYNTAX_CHECK_TIMEOUT bounds how long the shell parser may run
+const SYNTAX_CHECK_TIMEOUT = 5 * time.Second
+
+// SyntaxError describes a problem reported by the shell parser
+type SyntaxError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"` // 0 when the shell does not report a position
+	Message string `json:"message"`
+}
+
+var (
+	// syntaxLinePattern matches "bash: line 3: message" and "zsh:3: message"
+	syntaxLinePattern = regexp.MustCompile(`^[^:]+:(?: line)? ?(\d+): (.*)$`)
+	// unexpectedTokenPattern extracts the token from "near unexpected token `)'"
+	unexpectedTokenPattern = regexp.MustCompile("near (?:unexpected token )?`([^']*)'")
+)
+
+// validateSyntax runs the shell's parser in no-exec mode over a script
+// and returns any syntax errors it reports. Nothing in the script is executed.
+func validateSyntax(script string, shell string) ([]SyntaxError, error) {
+	if shell == "" {
+		shell = DEFAULT_SHELL
+	}
+	if shell != "bash" && shell != "zsh" {
+		return nil, fmt.Errorf("unsupported shell '%s'. Only bash and zsh are supported", shell)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), SYNTAX_CHECK_TIMEOUT)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, shell, "-n")
+	cmd.Stdin = strings.NewReader(script)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil, nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return nil, err
+	}
+
+	lines := strings.Split(script, "\n")
+	var syntaxErrors []SyntaxError
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		match := syntaxLinePattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		lineNo, _ := strconv.Atoi(match[1])
+		message := match[2]
+
+		// bash echoes the offending source line as "`...'"; skip the echo
+		if strings.HasPrefix(message, "`") {
+			continue
+		}
+
+		syntaxErr := SyntaxError{Line: lineNo, Message: message}
+		if token := unexpectedTokenPattern.FindStringSubmatch(message); token != nil && lineNo >= 1 && lineNo <= len(lines) {
+			if idx := strings.Index(lines[lineNo-1], token[1]); idx >= 0 {
+				syntaxErr.Column = idx + 1
+			}
+		}
+		syntaxErrors = append(syntaxErrors, syntaxErr)
+	}
+
+	// The parser failed but nothing could be parsed out of its output
+	if len(syntaxErrors) == 0 {
+		syntaxErrors = append(syntaxErrors, SyntaxError{Message: strings.TrimSpace(string(output))})
+	}
+
+	return syntaxErrors, nil
+}
+
+func (s *ShellServer) handleValidateSyntax(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	script, ok := request.Params.Arguments["script"].(string)
+	if !ok {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: "Error: 'script' must be a string",
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	shell := DEFAULT_SHELL
+	if shellArg, ok := request.Params.Arguments["shell"].(string); ok && shellArg != "" {
+		shell = shellArg
+	}
+
+	syntaxErrors, err := validateSyntax(script, shell)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	if len(syntaxErrors) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Syntax OK (%s)", shell),
+				},
+			},
+		}, nil
+	}
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Found %d syntax error(s) (%s):\n\n", len(syntaxErrors), shell))
+	for _, syntaxErr := range syntaxErrors {
+		switch {
+		case syntaxErr.Line > 0 && syntaxErr.Column > 0:
+			result.WriteString(fmt.Sprintf("line %d, column %d: %s\n", syntaxErr.Line, syntaxErr.Column, syntaxErr.Message))
+		case syntaxErr.Line > 0:
+			result.WriteString(fmt.Sprintf("line %d: %s\n", syntaxErr.Line, syntaxErr.Message))
+		default:
+			result.WriteString(syntaxErr.Message + "\n")
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result.String(),
+			},
+		},
+		IsError: true,
+	}, nil
+}
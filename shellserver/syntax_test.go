@@ -0,0 +1,42 @@
+package shellserver
+
+import "testing"
+
+func TestValidateSyntax(t *testing.T) {
+	tests := []struct {
+		script string
+		line   int
+		column int
+		valid  bool
+	}{
+		{"echo hello", 0, 0, true},
+		{"for f in *.txt; do\n  wc -l \"$f\"\ndone", 0, 0, true},
+		{"echo ok\nif true; then\n  echo x\n", 4, 0, false},
+		{"echo ok\n  echo )", 2, 8, false},
+	}
+
+	for _, test := range tests {
+		syntaxErrors, err := validateSyntax(test.script, "bash")
+		if err != nil {
+			t.Fatalf("validateSyntax(%q) failed: %v", test.script, err)
+		}
+		if test.valid {
+			if len(syntaxErrors) != 0 {
+				t.Errorf("validateSyntax(%q) = %v, want no errors", test.script, syntaxErrors)
+			}
+			continue
+		}
+		if len(syntaxErrors) == 0 {
+			t.Errorf("validateSyntax(%q) returned no errors", test.script)
+			continue
+		}
+		if syntaxErrors[0].Line != test.line || syntaxErrors[0].Column != test.column {
+			t.Errorf("validateSyntax(%q) first error at %d:%d, want %d:%d",
+				test.script, syntaxErrors[0].Line, syntaxErrors[0].Column, test.line, test.column)
+		}
+	}
+
+	if _, err := validateSyntax("echo", "fish"); err == nil {
+		t.Errorf("validateSyntax with shell=fish should fail")
+	}
+}
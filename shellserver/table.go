@@ -0,0 +1,152 @@
+package shellserver
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Table formats accepted by the format_hint parameter
+const (
+	TABLE_FORMAT_AUTO    = "auto"    // Detect CSV or TSV
+	TABLE_FORMAT_CSV     = "csv"     // Comma-separated values
+	TABLE_FORMAT_TSV     = "tsv"     // Tab-separated values
+	TABLE_FORMAT_COLUMNS = "columns" // Whitespace-aligned columns, e.g. ps aux, df, kubectl get
+	TABLE_MAX_ROWS       = 500       // Rows returned in a table; the rest are counted
+	TABLE_OUTPUT_URI     = "shell://output.table.json"
+)
+
+// outputTable is command output normalized to a header and rows
+type outputTable struct {
+	Format    string     `json:"format"`
+	Headers   []string   `json:"headers"`
+	Rows      [][]string `json:"rows"`
+	TotalRows int        `json:"totalRows"`
+	Truncated bool       `json:"truncated,omitempty"`
+}
+
+// parseTable parses output as a table in the hinted format. The first line
+// is the header and every row must have as many fields as the header.
+func parseTable(output string, hint string) (*outputTable, error) {
+	lines := tableLines(output)
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("output has no rows below a header")
+	}
+
+	var records [][]string
+	var err error
+	switch hint {
+	case TABLE_FORMAT_AUTO:
+		for _, format := range []string{TABLE_FORMAT_TSV, TABLE_FORMAT_CSV} {
+			if table, err := parseTable(output, format); err == nil {
+				return table, nil
+			}
+		}
+		return nil, fmt.Errorf("output is not CSV or TSV; use format_hint 'columns' for aligned columns")
+	case TABLE_FORMAT_TSV:
+		if !strings.Contains(lines[0], "\t") {
+			return nil, fmt.Errorf("header has no tabs")
+		}
+		for _, line := range lines {
+			records = append(records, strings.Split(line, "\t"))
+		}
+	case TABLE_FORMAT_CSV:
+		reader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+		reader.FieldsPerRecord = 0
+		if records, err = reader.ReadAll(); err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		if len(records[0]) < 2 {
+			return nil, fmt.Errorf("header has a single column")
+		}
+	case TABLE_FORMAT_COLUMNS:
+		if records, err = splitColumns(lines); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format_hint '%s'", hint)
+	}
+
+	table := &outputTable{Format: hint, Headers: records[0], TotalRows: len(records) - 1}
+	for i, record := range records[1:] {
+		if len(record) != len(table.Headers) {
+			return nil, fmt.Errorf("row %d has %d fields, header has %d", i+1, len(record), len(table.Headers))
+		}
+		if len(table.Rows) == TABLE_MAX_ROWS {
+			table.Truncated = true
+			break
+		}
+		table.Rows = append(table.Rows, record)
+	}
+	return table, nil
+}
+
+// tableLines returns the non-empty lines of output
+func tableLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// splitColumns splits whitespace-aligned output. Extra fields are joined
+// into the last column (ps aux's COMMAND); when every row has one field
+// fewer than the header, its last two words name one column (df's
+// "Mounted on").
+func splitColumns(lines []string) ([][]string, error) {
+	headers := strings.Fields(lines[0])
+	if len(headers) < 2 {
+		return nil, fmt.Errorf("header has a single column")
+	}
+
+	rows := make([][]string, 0, len(lines)-1)
+	shortRows := 0
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) > len(headers) {
+			// Keep the original spacing of the last column
+			last := line
+			for _, field := range fields[:len(headers)-1] {
+				last = strings.TrimLeft(last, " \t")
+				last = strings.TrimPrefix(last, field)
+			}
+			fields = append(fields[:len(headers)-1], strings.TrimSpace(last))
+		}
+		if len(fields) == len(headers)-1 {
+			shortRows++
+		}
+		rows = append(rows, fields)
+	}
+
+	if shortRows == len(rows) && len(headers) > 2 {
+		n := len(headers)
+		headers = append(headers[:n-2], headers[n-2]+" "+headers[n-1])
+	}
+	return append([][]string{headers}, rows...), nil
+}
+
+// tableResource returns a table as embedded JSON content
+func tableResource(table *outputTable) (mcp.EmbeddedResource, error) {
+	data, err := json.Marshal(table)
+	if err != nil {
+		return mcp.EmbeddedResource{}, err
+	}
+
+	resource := mcp.EmbeddedResource{
+		Type: "resource",
+		Resource: mcp.TextResourceContents{
+			URI:      TABLE_OUTPUT_URI,
+			MIMEType: JSON_MIME_TYPE,
+			Text:     string(data),
+		},
+	}
+	annotate(&resource.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+	return resource, nil
+}
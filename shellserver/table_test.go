@@ -0,0 +1,105 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestParseTable(t *testing.T) {
+	psOutput := "USER PID %CPU COMMAND\nroot 1 0.0 /sbin/init splash\nme   42 1.5 vim  notes.txt\n"
+	dfOutput := "Filesystem Size Used Avail Use% Mounted on\n/dev/sda1 50G 20G 30G 40% /\ntmpfs 1G 0 1G 0% /run\n"
+
+	tests := []struct {
+		output  string
+		hint    string
+		format  string
+		headers string
+		rows    string // Rows joined by ';', fields by ','
+		wantErr string
+	}{
+		{"name,size\na,1\n\"b,c\",2\n", TABLE_FORMAT_AUTO, TABLE_FORMAT_CSV, "name,size", "a,1;b,c,2", ""},
+		{"name\tsize\na b\t1\n", TABLE_FORMAT_AUTO, TABLE_FORMAT_TSV, "name,size", "a b,1", ""},
+		{"name,size\r\na,1\r\n", TABLE_FORMAT_CSV, TABLE_FORMAT_CSV, "name,size", "a,1", ""},
+		{psOutput, TABLE_FORMAT_COLUMNS, TABLE_FORMAT_COLUMNS, "USER,PID,%CPU,COMMAND", "root,1,0.0,/sbin/init splash;me,42,1.5,vim  notes.txt", ""},
+		{dfOutput, TABLE_FORMAT_COLUMNS, TABLE_FORMAT_COLUMNS, "Filesystem,Size,Used,Avail,Use%,Mounted on", "/dev/sda1,50G,20G,30G,40%,/;tmpfs,1G,0,1G,0%,/run", ""},
+		{"just some text\nover two lines\n", TABLE_FORMAT_AUTO, "", "", "", "not CSV or TSV"},
+		{"a,b\n1,2,3\n", TABLE_FORMAT_CSV, "", "", "", "invalid CSV"},
+		{"a\tb\n1\n", TABLE_FORMAT_TSV, "", "", "", "row 1 has 1 fields"},
+		{"header only\n", TABLE_FORMAT_AUTO, "", "", "", "no rows"},
+		{"a b\n1 2\n", "xml", "", "", "", "unknown format_hint"},
+	}
+
+	for _, tt := range tests {
+		table, err := parseTable(tt.output, tt.hint)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseTable(%q, %s) error = %v, want %q", tt.output, tt.hint, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTable(%q, %s) failed: %v", tt.output, tt.hint, err)
+			continue
+		}
+
+		var rows []string
+		for _, row := range table.Rows {
+			rows = append(rows, strings.Join(row, ","))
+		}
+		if table.Format != tt.format || strings.Join(table.Headers, ",") != tt.headers || strings.Join(rows, ";") != tt.rows {
+			t.Errorf("parseTable(%q, %s) = %s %v %v, want %s %s %s", tt.output, tt.hint, table.Format, table.Headers, table.Rows, tt.format, tt.headers, tt.rows)
+		}
+	}
+}
+
+func TestParseTableRowCap(t *testing.T) {
+	var output strings.Builder
+	output.WriteString("n,square\n")
+	for i := 0; i < TABLE_MAX_ROWS+10; i++ {
+		fmt.Fprintf(&output, "%d,%d\n", i, i*i)
+	}
+
+	table, err := parseTable(output.String(), TABLE_FORMAT_CSV)
+	if err != nil {
+		t.Fatalf("parseTable failed: %v", err)
+	}
+	if len(table.Rows) != TABLE_MAX_ROWS || table.TotalRows != TABLE_MAX_ROWS+10 || !table.Truncated {
+		t.Errorf("table has %d of %d rows (truncated %v), want %d of %d", len(table.Rows), table.TotalRows, table.Truncated, TABLE_MAX_ROWS, TABLE_MAX_ROWS+10)
+	}
+}
+
+func TestExecuteCommandFormatHint(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("cat"), WithExecutor(fixedExecutor("id,name\n1,alice\n")))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "cat users.csv", "format_hint": TABLE_FORMAT_AUTO}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	if len(result.Content) != 3 {
+		t.Fatalf("result has %d items, want text, table and summary", len(result.Content))
+	}
+	resource, ok := result.Content[1].(mcp.EmbeddedResource)
+	if !ok {
+		t.Fatalf("second item = %#v, want the table resource", result.Content[1])
+	}
+	var table outputTable
+	if err := json.Unmarshal([]byte(resource.Resource.(mcp.TextResourceContents).Text), &table); err != nil {
+		t.Fatalf("table is not JSON: %v", err)
+	}
+	if len(table.Rows) != 1 || table.Rows[0][1] != "alice" {
+		t.Errorf("table = %+v, want one row for alice", table)
+	}
+
+	request.Params.Arguments["format_hint"] = TABLE_FORMAT_TSV
+	result, _ = s.handleExecuteCommand(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; len(result.Content) != 2 || !strings.Contains(text, "Warning: output is not a table") {
+		t.Errorf("TSV hint on CSV output = %q, want a warning and no table", text)
+	}
+}
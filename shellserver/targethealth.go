@@ -0,0 +1,206 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_HEALTH_INTERVAL is how often SSH targets are checked by default
+const DEFAULT_HEALTH_INTERVAL = time.Minute
+
+// targetHealth is the last known state of an SSH target, from a health
+// check or a command run on it
+type targetHealth struct {
+	reachable bool
+	checked   time.Time
+	err       string // Why the target could not be reached
+}
+
+// WithTargetHealthChecks checks every SSH target at this interval; zero
+// disables the checks, leaving only what commands run on targets reveal
+func WithTargetHealthChecks(interval time.Duration) Option {
+	return func(s *ShellServer) error {
+		if interval < 0 {
+			return fmt.Errorf("health check interval must not be negative")
+		}
+		s.healthInterval = interval
+		return nil
+	}
+}
+
+// checkTargets runs the health checks now and then at every interval
+func (s *ShellServer) checkTargets() {
+	ticker := time.NewTicker(s.healthInterval)
+	defer ticker.Stop()
+	for {
+		s.checkAllTargets()
+		<-ticker.C
+	}
+}
+
+// checkAllTargets checks every target, TARGET_PARALLELISM at a time
+func (s *ShellServer) checkAllTargets() {
+	semaphore := make(chan struct{}, TARGET_PARALLELISM)
+	var wg sync.WaitGroup
+	for name := range s.currentTargets().Hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			s.checkTarget(name)
+		}()
+	}
+	wg.Wait()
+}
+
+// checkTarget logs in to a target and runs 'true'. Checks ignore the
+// reconnect backoff, so a target that recovers is noticed.
+func (s *ShellServer) checkTarget(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*SSH_CONNECT_TIMEOUT*time.Second)
+	defer cancel()
+	release, err := s.sshPool.session(ctx, name, SSH_CONNECT_TIMEOUT*time.Second)
+	if err != nil {
+		// Every session is busy, so the target is in use
+		return
+	}
+	defer release()
+
+	output, err := s.control.command(ctx, "ssh", append(s.sshPool.sshArgs(s.currentTargets().Hosts[name]), "true")...).CombinedOutput()
+	s.sshPool.report(name, err != nil, time.Now())
+	if err == nil {
+		s.recordHealth(name, true, "")
+		return
+	}
+	reason := strings.TrimSpace(string(output))
+	if reason == "" {
+		reason = err.Error()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		reason = "health check timed out"
+	}
+	s.recordHealth(name, false, reason)
+}
+
+// recordHealth stores a target's state, logging when it changes
+func (s *ShellServer) recordHealth(name string, reachable bool, reason string) {
+	s.healthMutex.Lock()
+	previous, known := s.targetHealth[name]
+	s.targetHealth[name] = targetHealth{reachable: reachable, checked: time.Now(), err: reason}
+	s.healthMutex.Unlock()
+
+	if !known || previous.reachable != reachable {
+		if reachable {
+			s.logger.Printf("Target %s is reachable", name)
+		} else {
+			s.logger.Printf("Target %s is unreachable: %s", name, reason)
+		}
+	}
+}
+
+// knownUnreachable reports whether the last check of a target failed
+func (s *ShellServer) knownUnreachable(name string) bool {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+	health, known := s.targetHealth[name]
+	return known && !health.reachable
+}
+
+// healthOf describes a target's last known state for list_targets
+func (s *ShellServer) healthOf(name string) string {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+	health, known := s.targetHealth[name]
+	switch {
+	case !known:
+		return "not checked"
+	case health.reachable:
+		return "reachable at " + health.checked.Format(time.TimeOnly)
+	default:
+		return "unreachable at " + health.checked.Format(time.TimeOnly) + ": " + health.err
+	}
+}
+
+// newTargetClaims returns a function that claims a host for one
+// execute_on_targets call, so no host runs the command twice. The hosts
+// given are claimed already.
+func newTargetClaims(hosts []string) func(host string) bool {
+	var mutex sync.Mutex
+	claimed := map[string]bool{}
+	for _, host := range hosts {
+		claimed[host] = true
+	}
+	return func(host string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if claimed[host] {
+			return false
+		}
+		claimed[host] = true
+		return true
+	}
+}
+
+// failoverFor claims an alternate for an unreachable host: the first other
+// member of its failover groups not known to be unreachable
+func (s *ShellServer) failoverFor(host string, claim func(string) bool) string {
+	for _, group := range s.targets.Failover {
+		members := s.targets.Groups[group]
+		if !slices.Contains(members, host) {
+			continue
+		}
+		for _, member := range members {
+			if member != host && !s.knownUnreachable(member) && claim(member) {
+				return member
+			}
+		}
+	}
+	return ""
+}
+
+// runOnTarget runs a command on a host through the middleware chain. A host
+// known to be unreachable, or found to be, is replaced by an alternate from
+// its failover groups if there is one.
+func (s *ShellServer) runOnTarget(ctx context.Context, command string, shell string, host string, claim func(string) bool) (targetResult, *ToolError) {
+	target, from := host, ""
+	if s.knownUnreachable(host) {
+		if alternate := s.failoverFor(host, claim); alternate != "" {
+			target, from = alternate, host
+		}
+	}
+
+	for {
+		req := &ExecRequest{Command: command, Shell: shell, Target: target, FailoverFrom: from, Client: s.clientIdentity(ctx)}
+		execution, err := s.exec(ctx, req)
+		if err != nil {
+			toolError := s.deniedToolError(req, err)
+			return targetResult{Target: target, ExitCode: -1, Output: toolError.Message, ErrorCode: toolError.Code, FailoverFrom: from}, &toolError
+		}
+
+		unreachable := execution.ErrorCode == ERROR_TARGET_UNREACHABLE
+		if unreachable {
+			s.recordHealth(target, false, strings.TrimSpace(execution.Output))
+		} else {
+			s.recordHealth(target, true, "")
+		}
+		if unreachable && from == "" {
+			if alternate := s.failoverFor(host, claim); alternate != "" {
+				target, from = alternate, host
+				continue
+			}
+		}
+
+		return targetResult{
+			Target:       target,
+			ExitCode:     execution.ExitCode,
+			Output:       execution.Output,
+			ErrorCode:    execution.ErrorCode,
+			ExecutionMs:  execution.ExecutionMs,
+			FailoverFrom: from,
+		}, nil
+	}
+}
@@ -0,0 +1,711 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// SSH targets are hosts commands can run on through the system ssh client,
+// so the user's ssh config, agent and known_hosts apply. Commands for a
+// target pass through the same middleware chain as local ones. Agents, see
+// cluster.go, are targets too.
+
+// SSH target settings
+const (
+	TARGET_PARALLELISM     = 8  // Hosts a command runs on at once by default
+	MAX_TARGET_PARALLELISM = 32 // Upper bound for max_parallel
+	SSH_CONNECT_TIMEOUT    = 10 // Seconds ssh waits to connect
+	SSH_UNREACHABLE_EXIT   = 255
+	TARGETS_URI            = "shell://targets.json"
+)
+
+// targetName matches host and group names
+var targetName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// SSHHost is a host commands can run on
+type SSHHost struct {
+	Address      string `json:"address"`                // Host name, IP address or ssh config alias
+	User         string `json:"user,omitempty"`         // Login user; ssh's default if empty
+	Port         int    `json:"port,omitempty"`         // ssh's default if zero
+	IdentityFile string `json:"identityFile,omitempty"` // Private key; ssh's default if empty
+	MaxSessions  int    `json:"maxSessions,omitempty"`  // Concurrent sessions; SSH_MAX_SESSIONS if zero
+
+	Labels map[string]string `json:"labels,omitempty"` // e.g. role=build, for selectors
+	Policy *PolicyRules      `json:"policy,omitempty"` // Rules added to the policy for commands on the host
+}
+
+// Targets are the SSH hosts, agents and named groups of them commands can
+// run on
+type Targets struct {
+	Hosts    map[string]SSHHost     `json:"hosts,omitempty"`
+	Agents   map[string]AgentTarget `json:"agents,omitempty"`
+	Groups   map[string][]string    `json:"groups,omitempty"`
+	Failover []string               `json:"failover,omitempty"` // Groups whose hosts stand in for an unreachable member
+
+	LabelPolicies []LabelPolicy `json:"labelPolicies,omitempty"` // Rules added for commands on targets with labels
+}
+
+// LabelPolicy adds rules to the policy of commands on the targets a
+// selector matches
+type LabelPolicy struct {
+	Selector string       `json:"selector"` // e.g. "role=build,os=linux"
+	Policy   *PolicyRules `json:"policy"`
+}
+
+// LoadTargets reads a --targets file: {"hosts": {"web1": {"address":
+// "web1.example.com", "user": "deploy"}}, "agents": {"lab1": {"token":
+// "..."}}, "groups": {"web": ["web1", "lab1"]}}
+func LoadTargets(path string) (*Targets, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var targets Targets
+	if err := decoder.Decode(&targets); err != nil {
+		return nil, fmt.Errorf("invalid targets file: %v", err)
+	}
+	return &targets, nil
+}
+
+// check validates names and refuses values ssh would read as options
+func (t *Targets) check() error {
+	for name, host := range t.Hosts {
+		if err := checkHost(name, host); err != nil {
+			return err
+		}
+	}
+	for name, agent := range t.Agents {
+		if !targetName.MatchString(name) {
+			return fmt.Errorf("invalid agent name '%s'", name)
+		}
+		if _, found := t.Hosts[name]; found {
+			return fmt.Errorf("agent '%s' has the name of a host", name)
+		}
+		if len(agent.Token) < MIN_AGENT_TOKEN {
+			return fmt.Errorf("agent '%s': the token must have at least %d characters", name, MIN_AGENT_TOKEN)
+		}
+		if err := checkLabels(agent.Labels); err != nil {
+			return fmt.Errorf("agent '%s': %v", name, err)
+		}
+	}
+	for name, members := range t.Groups {
+		if !targetName.MatchString(name) {
+			return fmt.Errorf("invalid group name '%s'", name)
+		}
+		if t.has(name) {
+			return fmt.Errorf("group '%s' has the name of a host or agent", name)
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("group '%s' has no hosts", name)
+		}
+		for _, member := range members {
+			if !t.has(member) {
+				return fmt.Errorf("group '%s': unknown host or agent '%s'", name, member)
+			}
+		}
+	}
+	for _, name := range t.Failover {
+		if _, found := t.Groups[name]; !found {
+			return fmt.Errorf("failover: unknown group '%s'", name)
+		}
+	}
+	for _, labelPolicy := range t.LabelPolicies {
+		if _, err := parseSelector(labelPolicy.Selector); err != nil {
+			return fmt.Errorf("label policy: %v", err)
+		}
+		if labelPolicy.Policy == nil {
+			return fmt.Errorf("label policy '%s': no policy", labelPolicy.Selector)
+		}
+	}
+	return nil
+}
+
+// labelName matches label keys and values
+var labelName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// checkLabels validates label keys and values
+func checkLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !labelName.MatchString(key) || !labelName.MatchString(value) {
+			return fmt.Errorf("invalid label '%s=%s'", key, value)
+		}
+	}
+	return nil
+}
+
+// parseSelector parses a label selector such as "role=build,os=linux",
+// which matches targets with all the labels
+func parseSelector(selector string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, term := range strings.Split(selector, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(term), "=")
+		if !found || !labelName.MatchString(key) || !labelName.MatchString(value) {
+			return nil, fmt.Errorf("invalid selector '%s', expected labels such as 'role=build,os=linux'", selector)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// ParseLabels parses labels such as "role=build,gpu=a100"
+func ParseLabels(labels string) (map[string]string, error) {
+	return parseSelector(labels)
+}
+
+// matchesLabels reports whether labels has every label of selector
+func matchesLabels(selector map[string]string, labels map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// checkHost validates a host's name and refuses values ssh would read as
+// options
+func checkHost(name string, host SSHHost) error {
+	if !targetName.MatchString(name) {
+		return fmt.Errorf("invalid host name '%s'", name)
+	}
+	if host.Address == "" || strings.HasPrefix(host.Address, "-") || strings.ContainsAny(host.Address, " \t\n@") {
+		return fmt.Errorf("host '%s': invalid address '%s'", name, host.Address)
+	}
+	if strings.HasPrefix(host.User, "-") || strings.ContainsAny(host.User, " \t\n@") {
+		return fmt.Errorf("host '%s': invalid user '%s'", name, host.User)
+	}
+	if host.Port < 0 || host.Port > 65535 {
+		return fmt.Errorf("host '%s': invalid port %d", name, host.Port)
+	}
+	if host.MaxSessions < 0 {
+		return fmt.Errorf("host '%s': invalid maxSessions %d", name, host.MaxSessions)
+	}
+	if err := checkLabels(host.Labels); err != nil {
+		return fmt.Errorf("host '%s': %v", name, err)
+	}
+	return nil
+}
+
+// has reports whether name is a host or an agent
+func (t *Targets) has(name string) bool {
+	_, host := t.Hosts[name]
+	_, agent := t.Agents[name]
+	return host || agent
+}
+
+// rules returns the policy rules of a host or agent, if it has any
+func (t *Targets) rules(name string) *PolicyRules {
+	if host, found := t.Hosts[name]; found {
+		return host.Policy
+	}
+	return t.Agents[name].Policy
+}
+
+// labels returns the labels of a host or agent
+func (t *Targets) labels(name string) map[string]string {
+	if host, found := t.Hosts[name]; found {
+		return host.Labels
+	}
+	return t.Agents[name].Labels
+}
+
+// names returns the names of every host and agent, sorted
+func (t *Targets) names() []string {
+	names := make([]string, 0, len(t.Hosts)+len(t.Agents))
+	for name := range t.Hosts {
+		names = append(names, name)
+	}
+	for name := range t.Agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve expands comma-separated host, agent and group names into host
+// and agent names, each once, in the order given
+func (t *Targets) resolve(names string) ([]string, error) {
+	var hosts []string
+	seen := map[string]bool{}
+	add := func(host string) {
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if members, found := t.Groups[name]; found {
+			for _, member := range members {
+				add(member)
+			}
+		} else if t.has(name) {
+			add(name)
+		} else if name != "" {
+			return nil, fmt.Errorf("unknown host, agent or group '%s'", name)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts or groups given")
+	}
+	return hosts, nil
+}
+
+// WithTargets configures the SSH hosts and agents for execute_on_targets
+// and the target argument of execute_command. The policy rules of a host or
+// agent are added to the policy of commands on it.
+func WithTargets(targets *Targets) Option {
+	return func(s *ShellServer) error {
+		if err := targets.check(); err != nil {
+			return err
+		}
+		s.targetRules = make(map[string]*PolicyRules)
+		for name := range targets.Hosts {
+			if err := s.addTargetRules(name, targets.rules(name)); err != nil {
+				return err
+			}
+		}
+		for name := range targets.Agents {
+			if err := s.addTargetRules(name, targets.rules(name)); err != nil {
+				return err
+			}
+		}
+		s.labelRules = nil
+		for _, labelPolicy := range targets.LabelPolicies {
+			rules, err := checkTargetRules("label policy '"+labelPolicy.Selector+"'", labelPolicy.Policy)
+			if err != nil {
+				return err
+			}
+			selector, _ := parseSelector(labelPolicy.Selector)
+			s.labelRules = append(s.labelRules, labelRule{selector: selector, rules: rules})
+		}
+		s.targets = targets
+		return nil
+	}
+}
+
+// labelRule is a resolved label policy
+type labelRule struct {
+	selector map[string]string
+	rules    *PolicyRules
+}
+
+// addTargetRules resolves the policy rules of a target, if it has any
+func (s *ShellServer) addTargetRules(name string, rules *PolicyRules) error {
+	if rules == nil {
+		return nil
+	}
+	resolved, err := checkTargetRules("target '"+name+"'", rules)
+	if err != nil {
+		return err
+	}
+	s.targetRules[name] = resolved
+	return nil
+}
+
+// checkTargetRules resolves rules added for commands on targets, which
+// cannot set what applies to the server as a whole
+func checkTargetRules(owner string, rules *PolicyRules) (*PolicyRules, error) {
+	if len(rules.Env) > 0 {
+		return nil, fmt.Errorf("%s: env cannot be set per target", owner)
+	}
+	if len(rules.Locks) > 0 {
+		return nil, fmt.Errorf("%s: locks cannot be set per target", owner)
+	}
+	if len(rules.Tripwires) > 0 {
+		return nil, fmt.Errorf("%s: tripwires cannot be set per target", owner)
+	}
+	resolved, err := resolveRules(rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", owner, err)
+	}
+	return resolved, nil
+}
+
+// targetPolicy returns policy with the rules of target and of the label
+// policies its labels match added, if there are any. Only the labels of
+// the targets file and discovery count: an agent's own could be anything.
+func (s *ShellServer) targetPolicy(policy Policy, target string) Policy {
+	var rules []*PolicyRules
+	if s.targetRules[target] != nil {
+		rules = append(rules, s.targetRules[target])
+	}
+	if len(s.labelRules) > 0 && target != "" {
+		labels := s.currentTargets().labels(target)
+		for _, labelRule := range s.labelRules {
+			if matchesLabels(labelRule.selector, labels) {
+				rules = append(rules, labelRule.rules)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return policy
+	}
+	// resolveGateway made sure the policy is an allowlist
+	extended := policy.(*AllowlistPolicy).clone()
+	for _, r := range rules {
+		extended.addRules(r)
+	}
+	return extended
+}
+
+// targetLabels returns the labels of a host or agent: those of the targets
+// file or discovery, and those the agent reports
+func (s *ShellServer) targetLabels(name string) map[string]string {
+	labels := map[string]string{}
+	for key, value := range s.agents.reportedLabels(name) {
+		labels[key] = value
+	}
+	for key, value := range s.currentTargets().labels(name) {
+		labels[key] = value
+	}
+	return labels
+}
+
+// selectTargets resolves comma-separated names, or every host and agent if
+// there are none, and keeps those a selector matches, if there is one
+func (s *ShellServer) selectTargets(names string, selector string) ([]string, error) {
+	targets := s.currentTargets()
+	if strings.TrimSpace(selector) == "" {
+		return targets.resolve(names)
+	}
+	labels, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []string
+	if strings.TrimSpace(names) == "" {
+		candidates = targets.names()
+	} else if candidates, err = targets.resolve(names); err != nil {
+		return nil, err
+	}
+	var selected []string
+	for _, name := range candidates {
+		if matchesLabels(labels, s.targetLabels(name)) {
+			selected = append(selected, name)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no hosts or agents match '%s'", selector)
+	}
+	return selected, nil
+}
+
+// pickTarget returns the first host or agent a selector matches, by name,
+// that is not known to be unreachable
+func (s *ShellServer) pickTarget(selector string) (string, error) {
+	names, err := s.selectTargets("", selector)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if s.agents.has(name) && !s.agents.online(name) {
+			continue
+		}
+		if !s.knownUnreachable(name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("none of %s is reachable", strings.Join(names, ", "))
+}
+
+// sshExecutor runs commands on one host with the ssh client
+type sshExecutor struct {
+	host    SSHHost
+	pool    *sshPool
+	control processControl
+}
+
+// Execute runs command with shell -c on the host. Extra environment
+// variables in env are set for the remote shell.
+func (e sshExecutor) Execute(ctx context.Context, command string, shell string, env []string, stream io.Writer) CommandExecution {
+	if shell == "" {
+		shell = DEFAULT_SHELL
+	}
+	if execution, unsupported := unsupportedShell(command, shell); unsupported {
+		return execution
+	}
+
+	// ssh hands the remote command to the login shell as one string
+	remote := shell + " -c " + shellQuote(command)
+	if len(env) > 0 {
+		quoted := make([]string, len(env))
+		for i, entry := range env {
+			quoted[i] = shellQuote(entry)
+		}
+		remote = "env " + strings.Join(quoted, " ") + " " + remote
+	}
+
+	args := append(e.pool.sshArgs(e.host), remote)
+	execution := CommandExecution{Command: command, Shell: shell, StartTime: time.Now()}
+	execution = runCommand(ctx, e.control.command(ctx, "ssh", args...), execution, stream)
+	// The ssh client's own usage says nothing about the remote command
+	execution.Usage = nil
+	return execution
+}
+
+// sshUnreachable reports whether ssh itself failed, rather than the remote
+// command exiting with 255
+func sshUnreachable(output string) bool {
+	return strings.HasPrefix(output, "ssh: ") ||
+		strings.Contains(output, "Permission denied (") ||
+		strings.Contains(output, "Host key verification failed")
+}
+
+// targetResult is one host's part of an execute_on_targets result
+type targetResult struct {
+	Target      string `json:"target"`
+	ExitCode    int    `json:"exitCode"`
+	Output      string `json:"output"`
+	ErrorCode   string `json:"errorCode,omitempty"`
+	ExecutionMs int64  `json:"executionMs"`
+
+	FailoverFrom string `json:"failoverFrom,omitempty"` // Unreachable host this one stood in for
+}
+
+// targetsReport is the JSON content of an execute_on_targets result
+type targetsReport struct {
+	Command   string         `json:"command"`
+	Succeeded []string       `json:"succeeded"`
+	Failed    []string       `json:"failed"`
+	Results   []targetResult `json:"results"`
+}
+
+func (s *ShellServer) handleExecuteOnTargets(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	command, ok := request.Params.Arguments["command"].(string)
+	if !ok {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_INVALID_COMMAND),
+			Details: map[string]interface{}{"argument": "command"},
+		}), nil
+	}
+	if s.targets == nil {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: s.message(MSG_NO_TARGETS)}), nil
+	}
+	names, _ := request.Params.Arguments["targets"].(string)
+	selector, _ := request.Params.Arguments["selector"].(string)
+	hosts, err := s.selectTargets(names, selector)
+	if err != nil {
+		argument := "targets"
+		if selector != "" {
+			argument = "selector"
+		}
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_INVALID_TARGETS, err),
+			Details: map[string]interface{}{"argument": argument},
+		}), nil
+	}
+
+	shell := DEFAULT_SHELL
+	if shellArg, ok := request.Params.Arguments["shell"].(string); ok && shellArg != "" {
+		shell = shellArg
+	}
+	parallel := TARGET_PARALLELISM
+	if parallelArg, ok := request.Params.Arguments["max_parallel"].(float64); ok {
+		parallel = max(1, min(int(parallelArg), MAX_TARGET_PARALLELISM))
+	}
+
+	// Run on every host, at most parallel at a time. Hosts named in the
+	// request are never used as failover alternates.
+	start := s.now()
+	results := make([]targetResult, len(hosts))
+	denials := make([]*ToolError, len(hosts))
+	claim := newTargetClaims(hosts)
+	semaphore := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[i], denials[i] = s.runOnTarget(ctx, command, shell, host, claim)
+		}()
+	}
+	wg.Wait()
+
+	// A command the policy refuses on every host is refused as a whole;
+	// target rules may refuse it on some only
+	refused := true
+	for _, denial := range denials {
+		refused = refused && denial != nil && denial.Code == ERROR_POLICY_DENIED
+	}
+	if refused {
+		return errorResult(*denials[0]), nil
+	}
+
+	report := targetsReport{Command: command, Succeeded: []string{}, Failed: []string{}, Results: results}
+	var text strings.Builder
+	fmt.Fprintf(&text, "$ %s\n", command)
+	for _, result := range results {
+		status := fmt.Sprintf("exit %d, %d ms", result.ExitCode, result.ExecutionMs)
+		if result.ErrorCode != "" {
+			status = result.ErrorCode + ", " + status
+		}
+		if result.FailoverFrom != "" {
+			status = "failover from " + result.FailoverFrom + "; " + status
+		}
+		fmt.Fprintf(&text, "\n== %s (%s) ==\n%s\n", result.Target, status, strings.TrimRight(result.Output, "\n"))
+		if result.ExitCode == 0 && result.ErrorCode == "" {
+			report.Succeeded = append(report.Succeeded, result.Target)
+		} else {
+			report.Failed = append(report.Failed, result.Target)
+		}
+	}
+	fmt.Fprintf(&text, "\n%d of %d hosts succeeded", len(report.Succeeded), len(results))
+	if len(report.Failed) > 0 {
+		fmt.Fprintf(&text, "; failed: %s", strings.Join(report.Failed, ", "))
+	}
+
+	// A report of plain values always marshals
+	data, _ := json.Marshal(report)
+	resource := mcp.EmbeddedResource{
+		Type: "resource",
+		Resource: mcp.TextResourceContents{
+			URI:      TARGETS_URI,
+			MIMEType: JSON_MIME_TYPE,
+			Text:     string(data),
+		},
+	}
+	annotate(&resource.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+
+	summary := mcp.NewTextContent(s.message(MSG_SUMMARY_TARGETS, command, names, len(report.Succeeded), len(results), s.now().Sub(start).Milliseconds()))
+	annotate(&summary.Annotated, PRIORITY_SUMMARY, mcp.RoleUser)
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{assistantText(text.String()), resource, summary},
+	}, nil
+}
+
+// targetPolicyNote tells list_targets which commands a target's rules add
+// or deny
+func targetPolicyNote(rules *PolicyRules) string {
+	if rules == nil {
+		return ""
+	}
+	var parts []string
+	if len(rules.Allow) > 0 {
+		parts = append(parts, "also allows "+strings.Join(rules.Allow, ", "))
+	}
+	if len(rules.Deny) > 0 {
+		parts = append(parts, "denies "+strings.Join(rules.Deny, ", "))
+	}
+	if rules.ReadOnly != nil && *rules.ReadOnly {
+		parts = append(parts, "read-only")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, "; ") + ")"
+}
+
+// labelNote lists labels for list_targets
+func labelNote(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return " [" + strings.Join(pairs, ",") + "]"
+}
+
+func (s *ShellServer) handleListTargets(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if s.targets == nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{mcp.NewTextContent(s.message(MSG_NO_TARGETS))},
+		}, nil
+	}
+
+	// Only the hosts and agents a selector matches, if there is one
+	targets := s.currentTargets()
+	names := targets.names()
+	selector, _ := request.Params.Arguments["selector"].(string)
+	if selector != "" {
+		var err error
+		if names, err = s.selectTargets("", selector); err != nil {
+			return errorResult(ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: s.message(MSG_INVALID_TARGETS, err),
+				Details: map[string]interface{}{"argument": "selector"},
+			}), nil
+		}
+	}
+	var hosts, agents []string
+	for _, name := range names {
+		if _, found := targets.Hosts[name]; found {
+			hosts = append(hosts, name)
+		} else {
+			agents = append(agents, name)
+		}
+	}
+
+	var result strings.Builder
+	if len(hosts) > 0 {
+		fmt.Fprintf(&result, "Hosts (%d):\n", len(hosts))
+		for _, name := range hosts {
+			var discovered string
+			if _, found := s.targets.Hosts[name]; !found {
+				discovered = ", discovered"
+			}
+			fmt.Fprintf(&result, "- %s (%s%s): %s%s%s\n", name, targets.Hosts[name].Address, discovered, s.healthOf(name), labelNote(s.targetLabels(name)), targetPolicyNote(s.targetRules[name]))
+		}
+	}
+
+	if len(agents) > 0 {
+		if result.Len() > 0 {
+			result.WriteString("\n")
+		}
+		fmt.Fprintf(&result, "Agents (%d):\n", len(agents))
+		for _, name := range agents {
+			fmt.Fprintf(&result, "- %s: %s%s%s\n", name, s.agents.agentStatus(name), labelNote(s.targetLabels(name)), targetPolicyNote(s.targetRules[name]))
+		}
+	}
+	if result.Len() == 0 {
+		result.WriteString("No hosts or agents yet; discovery has not found any\n")
+	}
+
+	// Groups are named, not labeled
+	if len(s.targets.Groups) > 0 && selector == "" {
+		groups := make([]string, 0, len(s.targets.Groups))
+		for name := range s.targets.Groups {
+			groups = append(groups, name)
+		}
+		sort.Strings(groups)
+		fmt.Fprintf(&result, "\nGroups (%d):\n", len(groups))
+		for _, name := range groups {
+			var failover string
+			if slices.Contains(s.targets.Failover, name) {
+				failover = " (failover)"
+			}
+			fmt.Fprintf(&result, "- %s: %s%s\n", name, strings.Join(s.targets.Groups[name], ", "), failover)
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(result.String())},
+	}, nil
+}
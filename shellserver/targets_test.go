@@ -0,0 +1,248 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSSH stands in for the ssh client: it runs the remote command locally
+// with TARGET_HOST set, and fails like ssh for hosts named down*
+const fakeSSH = `#!/bin/sh
+while [ "$1" != "--" ]; do shift; done
+shift; host=$1; shift
+case $host in down*) echo "ssh: connect to host $host port 22: Connection refused" >&2; exit 255;; esac
+TARGET_HOST=$host exec sh -c "$1"
+`
+
+func testTargets() *Targets {
+	return &Targets{
+		Hosts: map[string]SSHHost{
+			"web1":  {Address: "web1"},
+			"web2":  {Address: "web2", User: "deploy", Port: 2222},
+			"down1": {Address: "down1"},
+		},
+		Groups: map[string][]string{
+			"web": {"web1", "web2"},
+			"all": {"web1", "web2", "down1"},
+		},
+	}
+}
+
+func TestTargetsCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets Targets
+		wantErr bool
+	}{
+		{"valid", *testTargets(), false},
+		{"no hosts", Targets{}, false}, // Discovery may find some
+		{"bad label", Targets{Hosts: map[string]SSHHost{"a": {Address: "a", Labels: map[string]string{"role": "a b"}}}}, true},
+		{"label policy", Targets{Hosts: map[string]SSHHost{"a": {Address: "a"}}, LabelPolicies: []LabelPolicy{{Selector: "role=build", Policy: &PolicyRules{Allow: []string{"make"}}}}}, false},
+		{"bad selector", Targets{Hosts: map[string]SSHHost{"a": {Address: "a"}}, LabelPolicies: []LabelPolicy{{Selector: "role", Policy: &PolicyRules{}}}}, true},
+		{"label policy without policy", Targets{Hosts: map[string]SSHHost{"a": {Address: "a"}}, LabelPolicies: []LabelPolicy{{Selector: "role=build"}}}, true},
+		{"option address", Targets{Hosts: map[string]SSHHost{"a": {Address: "-oProxyCommand=sh"}}}, true},
+		{"user in address", Targets{Hosts: map[string]SSHHost{"a": {Address: "root@a"}}}, true},
+		{"option user", Targets{Hosts: map[string]SSHHost{"a": {Address: "a", User: "-x"}}}, true},
+		{"bad port", Targets{Hosts: map[string]SSHHost{"a": {Address: "a", Port: 70000}}}, true},
+		{"bad name", Targets{Hosts: map[string]SSHHost{"a b": {Address: "a"}}}, true},
+		{"unknown member", Targets{Hosts: map[string]SSHHost{"a": {Address: "a"}}, Groups: map[string][]string{"g": {"b"}}}, true},
+		{"group named like host", Targets{Hosts: map[string]SSHHost{"a": {Address: "a"}}, Groups: map[string][]string{"a": {"a"}}}, true},
+		{"unknown failover group", Targets{Hosts: map[string]SSHHost{"a": {Address: "a"}}, Failover: []string{"g"}}, true},
+		{"only agents", Targets{Agents: map[string]AgentTarget{"lab1": {Token: testAgentToken}}}, false},
+		{"short agent token", Targets{Agents: map[string]AgentTarget{"lab1": {Token: "secret"}}}, true},
+		{"agent named like host", Targets{Hosts: map[string]SSHHost{"a": {Address: "a"}}, Agents: map[string]AgentTarget{"a": {Token: testAgentToken}}}, true},
+		{"group of host and agent", Targets{Hosts: map[string]SSHHost{"a": {Address: "a"}}, Agents: map[string]AgentTarget{"lab1": {Token: testAgentToken}}, Groups: map[string][]string{"g": {"a", "lab1"}}}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.targets.check(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: check() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTargetsResolve(t *testing.T) {
+	tests := []struct {
+		names   string
+		want    string
+		wantErr bool
+	}{
+		{"web1", "web1", false},
+		{"web", "web1,web2", false},
+		{"web2, web, down1", "web2,web1,down1", false},
+		{"web,all", "web1,web2,down1", false},
+		{"db", "", true},
+		{" , ", "", true},
+	}
+
+	targets := testTargets()
+	for _, tt := range tests {
+		hosts, err := targets.resolve(tt.names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolve(%q) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+			continue
+		}
+		if got := strings.Join(hosts, ","); got != tt.want {
+			t.Errorf("resolve(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTargets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "targets.json")
+	os.WriteFile(path, []byte(`{"hosts": {"web1": {"address": "web1.example.com", "user": "deploy"}}, "groups": {"web": ["web1"]}}`), 0644)
+	targets, err := LoadTargets(path)
+	if err != nil {
+		t.Fatalf("LoadTargets failed: %v", err)
+	}
+	if targets.Hosts["web1"].User != "deploy" || len(targets.Groups["web"]) != 1 {
+		t.Errorf("LoadTargets = %+v", targets)
+	}
+
+	os.WriteFile(path, []byte(`{"hosts": {"web1": {"host": "web1"}}}`), 0644)
+	if _, err := LoadTargets(path); err == nil {
+		t.Errorf("LoadTargets accepted an unknown field")
+	}
+}
+
+func TestExecuteOnTargets(t *testing.T) {
+	s := fakeSSHServer(t, WithAllowedCommands("echo,exit"))
+
+	tests := []struct {
+		command string
+		targets string
+		want    []string
+		isError bool
+	}{
+		{"echo on $TARGET_HOST", "web", []string{"== web1 (exit 0", "on web1", "on web2", "2 of 2 hosts succeeded"}, false},
+		{"echo on $TARGET_HOST", "all", []string{"== down1 (TARGET_UNREACHABLE, exit 255", "Connection refused", "2 of 3 hosts succeeded; failed: down1"}, false},
+		{"exit 3", "web1", []string{"== web1 (exit 3", "0 of 1 hosts succeeded; failed: web1"}, false},
+		{"rm -rf /", "web", []string{"not in the allowed list"}, true},
+		{"echo hi", "db", []string{"unknown host, agent or group 'db'"}, true},
+	}
+
+	for _, tt := range tests {
+		text, isError := callTool(t, s.handleExecuteOnTargets, map[string]interface{}{
+			"command": tt.command, "targets": tt.targets, "max_parallel": float64(2),
+		})
+		if isError != tt.isError {
+			t.Errorf("%s on %s: isError = %v, want %v (%q)", tt.command, tt.targets, isError, tt.isError, text)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("%s on %s = %q, want %q", tt.command, tt.targets, text, want)
+			}
+		}
+	}
+
+	if got := recentHistory(t, s, 1); len(got) != 1 || got[0].Target == "" {
+		t.Errorf("history = %+v, want the target recorded", got)
+	}
+}
+
+func TestListTargets(t *testing.T) {
+	s, _ := NewShellServer()
+	if text, _ := callTool(t, s.handleListTargets, nil); !strings.Contains(text, "--targets") {
+		t.Errorf("list_targets without targets = %q", text)
+	}
+
+	s, _ = NewShellServer(WithTargets(testTargets()))
+	defer s.Close()
+	text, _ := callTool(t, s.handleListTargets, nil)
+	for _, want := range []string{"Hosts (3)", "- web2 (web2)", "Groups (2)", "- web: web1, web2"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("list_targets = %q, want %q", text, want)
+		}
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	s := fakeSSHServer(t)
+	s.checkAllTargets()
+
+	text, _ := callTool(t, s.handleListTargets, nil)
+	for _, want := range []string{"- web1 (web1): reachable at", "- down1 (down1): unreachable at", "Connection refused"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("list_targets after checks = %q, want %q", text, want)
+		}
+	}
+}
+
+func TestTargetFailover(t *testing.T) {
+	targets := testTargets()
+	targets.Groups["pool"] = []string{"down1", "web2"}
+	targets.Failover = []string{"pool"}
+	s := fakeSSHServer(t, WithAllowedCommands("echo"))
+	s.targets = targets
+
+	tests := []struct {
+		targets string
+		want    string
+	}{
+		// web2 stands in for down1 once ssh fails
+		{"down1", "== web2 (failover from down1; exit 0"},
+		// web2 runs the command itself, so it cannot also stand in
+		{"down1,web2", "== down1 (TARGET_UNREACHABLE"},
+		// Hosts outside failover groups are not replaced
+		{"web1", "== web1 (exit 0"},
+	}
+
+	for _, tt := range tests {
+		text, _ := callTool(t, s.handleExecuteOnTargets, map[string]interface{}{"command": "echo on $TARGET_HOST", "targets": tt.targets})
+		if !strings.Contains(text, tt.want) {
+			t.Errorf("execute_on_targets on %s = %q, want %q", tt.targets, text, tt.want)
+		}
+	}
+
+	// down1 is now known to be unreachable, so it is not tried again
+	before := historyCount(t, s)
+	text, _ := callTool(t, s.handleExecuteOnTargets, map[string]interface{}{"command": "echo on $TARGET_HOST", "targets": "down1"})
+	if !strings.Contains(text, "on web2") || historyCount(t, s) != before+1 {
+		t.Errorf("execute_on_targets on unreachable down1 = %q after %d runs, want one run on web2", text, historyCount(t, s)-before)
+	}
+	if got := recentHistory(t, s, 1); len(got) != 1 || got[0].FailoverFrom != "down1" {
+		t.Errorf("history = %+v, want the failover recorded", got)
+	}
+}
+
+func TestExecuteCommandOnTarget(t *testing.T) {
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "ssh"), []byte(fakeSSH), 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	targets := testTargets()
+	web2 := targets.Hosts["web2"]
+	web2.Policy = &PolicyRules{Allow: []string{"printf"}, Deny: []string{"echo secret"}}
+	targets.Hosts["web2"] = web2
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithTargets(targets))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	execute := s.authorized("execute_command", s.handleExecuteCommand)
+
+	tests := []struct {
+		command string
+		target  string
+		want    string
+		isError bool
+	}{
+		{"echo on $TARGET_HOST", "web1", "on web1", false},
+		{"printf on-$TARGET_HOST", "web2", "on-web2", false},
+		{"printf on-$TARGET_HOST", "web1", "not in the allowed list", true},
+		{"echo secret", "web2", "deny rule", true},
+		{"echo secret", "web1", "secret", false},
+		{"echo hi", "web", "unknown host or agent 'web'", true},
+	}
+	for _, tt := range tests {
+		text, isError := callTool(t, execute, map[string]interface{}{"command": tt.command, "target": tt.target})
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s on %s = %q (error %v), want %q", tt.command, tt.target, text, isError, tt.want)
+		}
+	}
+	if got := recentHistory(t, s, 1); len(got) != 1 || got[0].Target != "web1" {
+		t.Errorf("history = %+v, want the target recorded", got)
+	}
+}
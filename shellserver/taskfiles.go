@@ -0,0 +1,219 @@
+package shellserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MAX_TASK_FILE_SIZE is the largest Makefile, justfile or package.json
+// whose tasks are discovered
+const MAX_TASK_FILE_SIZE = 1024 * 1024
+
+// Sources of discovered tasks, in the order they take precedence when two
+// declare the same name. Tasks a project declares take precedence over all.
+const (
+	TASK_SOURCE_PROJECT  = "project"      // --projects or .mcp-shell.yaml
+	TASK_SOURCE_MAKEFILE = "Makefile"     // make targets
+	TASK_SOURCE_JUSTFILE = "justfile"     // just recipes
+	TASK_SOURCE_NPM      = "package.json" // package.json scripts
+)
+
+var (
+	// makeTargetPattern matches a rule line: targets, then ':' or '::' not
+	// followed by '=', which would make it an assignment
+	makeTargetPattern = regexp.MustCompile(`^([^\s:#=][^:#=]*?)\s*::?(?:[^=]|$)`)
+	// justRecipePattern matches a recipe line: an optionally quiet name, its
+	// parameters, then ':' not followed by '='
+	justRecipePattern = regexp.MustCompile(`^@?([A-Za-z_][A-Za-z0-9_-]*)(?:\s+[^:]*)?:(?:[^=]|$)`)
+)
+
+// discoverTasks returns the tasks of the build files in dir: Makefile
+// targets, justfile recipes and package.json scripts. Files that are
+// missing, too large or unparsable contribute nothing.
+func discoverTasks(dir string) []NamedTask {
+	var tasks []NamedTask
+	seen := make(map[string]bool)
+	add := func(discovered []NamedTask) {
+		for _, task := range discovered {
+			if !seen[task.Name] && taskNamePattern.MatchString(task.Name) {
+				seen[task.Name] = true
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	// make and just read the first of these names that exists
+	if data := readTaskFile(dir, "GNUmakefile", "makefile", "Makefile"); data != nil {
+		add(makeTargets(data))
+	}
+	if data := readTaskFile(dir, "justfile", "Justfile", ".justfile"); data != nil {
+		add(justRecipes(data))
+	}
+	if data := readTaskFile(dir, "package.json"); data != nil {
+		add(npmScripts(data, packageManager(dir)))
+	}
+	return tasks
+}
+
+// readTaskFile returns the contents of the first of names in dir that is a
+// regular file of at most MAX_TASK_FILE_SIZE bytes
+func readTaskFile(dir string, names ...string) []byte {
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if !info.Mode().IsRegular() || info.Size() > MAX_TASK_FILE_SIZE {
+			return nil
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+	return nil
+}
+
+// makeTargets returns the explicit targets of a Makefile. Special targets
+// such as .PHONY, pattern rules and targets built from variables are left
+// out. A target is described by a "## text" comment on its line, as
+// self-documenting Makefiles do, or by the comment lines above it.
+func makeTargets(data []byte) []NamedTask {
+	var tasks []NamedTask
+	var comment []string
+	inDefine := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), MAX_TASK_FILE_SIZE)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case inDefine:
+			inDefine = trimmed != "endef"
+			continue
+		case strings.HasPrefix(trimmed, "define ") || trimmed == "define":
+			inDefine = true
+			continue
+		case strings.HasPrefix(line, "#"):
+			comment = append(comment, strings.TrimSpace(strings.TrimLeft(line, "#")))
+			continue
+		case strings.HasPrefix(line, "\t"):
+			// Recipe lines keep the comment of the rule above them
+			continue
+		}
+
+		match := makeTargetPattern.FindStringSubmatch(line)
+		if match == nil {
+			comment = nil
+			continue
+		}
+		if strings.HasPrefix(match[1], ".PHONY") {
+			// The comment is usually above .PHONY, for the rule below it
+			continue
+		}
+		description := strings.Join(comment, " ")
+		if i := strings.Index(line, "##"); i >= 0 {
+			description = strings.TrimSpace(line[i+2:])
+		}
+		comment = nil
+		for _, target := range strings.Fields(match[1]) {
+			if strings.HasPrefix(target, ".") || strings.ContainsAny(target, "%$") {
+				continue
+			}
+			tasks = append(tasks, NamedTask{
+				Name:   target,
+				Task:   Task{Command: "make " + target, Description: description},
+				Source: TASK_SOURCE_MAKEFILE,
+			})
+		}
+	}
+	return tasks
+}
+
+// justRecipes returns the public recipes of a justfile: those whose name
+// does not start with '_' and that are not marked [private]. A recipe is
+// described by the comment lines above it.
+func justRecipes(data []byte) []NamedTask {
+	var tasks []NamedTask
+	var comment []string
+	private := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "#"):
+			if !strings.HasPrefix(line, "#!") {
+				comment = append(comment, strings.TrimSpace(strings.TrimLeft(line, "#")))
+			}
+			continue
+		case strings.HasPrefix(line, "["):
+			// Attributes sit between a recipe's comment and its name
+			private = private || strings.Contains(line, "private")
+			continue
+		case line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t"):
+			if line == "" {
+				comment, private = nil, false
+			}
+			continue
+		}
+
+		match := justRecipePattern.FindStringSubmatch(line)
+		name := ""
+		if match != nil {
+			name = match[1]
+		}
+		switch name {
+		case "", "alias", "export", "import", "mod", "set":
+		default:
+			if !private && !strings.HasPrefix(name, "_") {
+				tasks = append(tasks, NamedTask{
+					Name:   name,
+					Task:   Task{Command: "just " + name, Description: strings.Join(comment, " ")},
+					Source: TASK_SOURCE_JUSTFILE,
+				})
+			}
+		}
+		comment, private = nil, false
+	}
+	return tasks
+}
+
+// npmScripts returns the scripts of a package.json, run with manager. The
+// script itself describes it. Arguments follow "--" so that the script, not
+// the package manager, receives them.
+func npmScripts(data []byte, manager string) []NamedTask {
+	var manifest struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil
+	}
+	var tasks []NamedTask
+	for name, script := range manifest.Scripts {
+		tasks = append(tasks, NamedTask{
+			Name:   name,
+			Task:   Task{Command: manager + " run " + name + " --", Description: script},
+			Source: TASK_SOURCE_NPM,
+		})
+	}
+	return tasks
+}
+
+// packageManager returns the package manager whose lock file is in dir,
+// npm if there is none
+func packageManager(dir string) string {
+	for _, lock := range []struct{ file, manager string }{
+		{"pnpm-lock.yaml", "pnpm"},
+		{"yarn.lock", "yarn"},
+		{"bun.lockb", "bun"},
+	} {
+		if _, err := os.Stat(filepath.Join(dir, lock.file)); err == nil {
+			return lock.manager
+		}
+	}
+	return "npm"
+}
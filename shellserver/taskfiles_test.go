@@ -0,0 +1,97 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMakeTargets(t *testing.T) {
+	makefile := `# Tools
+GO ?= go
+FLAGS := -v
+.PHONY: build test
+
+## Build the binary
+build: deps
+	$(GO) build $(FLAGS)
+
+test lint: build ## Check the code
+	$(GO) test ./...
+
+%.o: %.c
+	cc -c $<
+
+$(BINARY): build
+define HELP
+help: not a target
+endef
+.DEFAULT_GOAL := build
+clean::
+	rm -rf bin
+`
+	want := []NamedTask{
+		{Name: "build", Task: Task{Command: "make build", Description: "Build the binary"}, Source: TASK_SOURCE_MAKEFILE},
+		{Name: "test", Task: Task{Command: "make test", Description: "Check the code"}, Source: TASK_SOURCE_MAKEFILE},
+		{Name: "lint", Task: Task{Command: "make lint", Description: "Check the code"}, Source: TASK_SOURCE_MAKEFILE},
+		{Name: "clean", Task: Task{Command: "make clean"}, Source: TASK_SOURCE_MAKEFILE},
+	}
+	if got := makeTargets([]byte(makefile)); !reflect.DeepEqual(got, want) {
+		t.Errorf("makeTargets() = %+v, want %+v", got, want)
+	}
+}
+
+func TestJustRecipes(t *testing.T) {
+	justfile := `#!/usr/bin/env just --justfile
+set shell := ["bash", "-c"]
+alias b := build
+version := "1.0"
+
+# Build the binary
+[group('dev')]
+build target="all":
+    go build
+
+@test *args: build
+    go test {{args}}
+
+_helper:
+    echo hidden
+
+[private]
+secret:
+    echo hidden
+`
+	want := []NamedTask{
+		{Name: "build", Task: Task{Command: "just build", Description: "Build the binary"}, Source: TASK_SOURCE_JUSTFILE},
+		{Name: "test", Task: Task{Command: "just test"}, Source: TASK_SOURCE_JUSTFILE},
+	}
+	if got := justRecipes([]byte(justfile)); !reflect.DeepEqual(got, want) {
+		t.Errorf("justRecipes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiscoverTasks(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "Makefile"), []byte("build:\n\tgo build\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "justfile"), []byte("build:\n    just-build\n\nfmt:\n    gofmt -w .\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"scripts": {"test:unit": "jest", "fmt": "prettier -w .", "bad name": "x"}}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "yarn.lock"), nil, 0o644)
+
+	got := discoverTasks(dir)
+	sort.Slice(got, func(i, j int) bool { return got[i].Name < got[j].Name })
+	want := []NamedTask{
+		{Name: "build", Task: Task{Command: "make build"}, Source: TASK_SOURCE_MAKEFILE},
+		{Name: "fmt", Task: Task{Command: "just fmt"}, Source: TASK_SOURCE_JUSTFILE},
+		{Name: "test:unit", Task: Task{Command: "yarn run test:unit --", Description: "jest"}, Source: TASK_SOURCE_NPM},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("discoverTasks() = %+v, want %+v", got, want)
+	}
+
+	if got := discoverTasks(t.TempDir()); len(got) != 0 {
+		t.Errorf("discoverTasks(empty) = %+v, want none", got)
+	}
+}
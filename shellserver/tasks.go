@@ -0,0 +1,153 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// TASKS_URI is the URI of the structured list_tasks result
+const TASKS_URI = "shell://tasks.json"
+
+// NamedTask is a task with its name and where it comes from, as list_tasks
+// returns it
+type NamedTask struct {
+	Name string `json:"name"`
+	Task
+	Source string `json:"source"` // One of the TASK_SOURCE_* constants
+}
+
+// TaskList is the structured result of list_tasks
+type TaskList struct {
+	Project string      `json:"project"`
+	Dir     string      `json:"dir"`
+	Tasks   []NamedTask `json:"tasks"`
+}
+
+// taskProject returns the project named by the request's project argument,
+// or the configured project of the server's working directory. Failing
+// that, it returns a project for the working directory itself, which has
+// only the tasks its build files declare.
+func (s *ShellServer) taskProject(request mcp.CallToolRequest) (*project, *ToolError) {
+	if name, _ := request.Params.Arguments["project"].(string); name != "" {
+		p, found := s.projects[name]
+		if !found {
+			return nil, &ToolError{
+				Code:    ERROR_INVALID_ARGUMENT,
+				Message: s.message(MSG_UNKNOWN_PROJECT, name),
+				Details: map[string]interface{}{"argument": "project", "project": name, "projects": s.ProjectNames()},
+			}
+		}
+		return p, nil
+	}
+	if p := s.workProject; p != nil && s.projects[p.Name] == p {
+		return p, nil
+	}
+
+	// Commands without a project run in the working directory, so its
+	// build files are the ones to read, even within a git repository
+	dir, _ := os.Getwd()
+	p := &project{Project: Project{Name: filepath.Base(dir), Dir: dir}, policy: s.policy}
+	if s.workProject != nil {
+		p.Name = s.workProject.Name
+	}
+	return p, nil
+}
+
+// projectTasks returns p's tasks and those of the build files in its
+// directory, sorted by name
+func (s *ShellServer) projectTasks(p *project) []NamedTask {
+	tasks := make([]NamedTask, 0, len(p.Tasks))
+	for name, task := range p.Tasks {
+		tasks = append(tasks, NamedTask{Name: name, Task: task, Source: TASK_SOURCE_PROJECT})
+	}
+	for _, task := range discoverTasks(p.Dir) {
+		if _, declared := p.Tasks[task.Name]; !declared {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+	return tasks
+}
+
+func (s *ShellServer) handleListTasks(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	p, toolError := s.taskProject(request)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	list := TaskList{Project: p.Name, Dir: p.Dir, Tasks: s.projectTasks(p)}
+	var text strings.Builder
+	fmt.Fprintf(&text, "Tasks of %s (%d):\n", p.Name, len(list.Tasks))
+	for _, task := range list.Tasks {
+		fmt.Fprintf(&text, "- %s: %s (%s)\n", task.Name, task.Command, task.Source)
+		if task.Description != "" {
+			fmt.Fprintf(&text, "  %s\n", task.Description)
+		}
+	}
+	return jsonResult(text.String(), TASKS_URI, list), nil
+}
+
+func (s *ShellServer) handleRunTask(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	p, toolError := s.taskProject(request)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	name, _ := request.Params.Arguments["name"].(string)
+	var task *NamedTask
+	for _, candidate := range s.projectTasks(p) {
+		if candidate.Name == name {
+			task = &candidate
+			break
+		}
+	}
+	if task == nil {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: s.message(MSG_UNKNOWN_TASK, name, p.Name),
+			Details: map[string]interface{}{"argument": "name", "name": name, "project": p.Name},
+		}), nil
+	}
+
+	command := task.Command
+	invalidArgs := ToolError{
+		Code:    ERROR_INVALID_ARGUMENT,
+		Message: "Error: 'args' must be an array of strings",
+		Details: map[string]interface{}{"argument": "args"},
+	}
+	args, ok := request.Params.Arguments["args"].([]interface{})
+	if !ok && request.Params.Arguments["args"] != nil {
+		return errorResult(invalidArgs), nil
+	}
+	for _, arg := range args {
+		text, ok := arg.(string)
+		if !ok {
+			return errorResult(invalidArgs), nil
+		}
+		command += " " + shellQuote(text)
+	}
+
+	// Run it as execute_command would, in the project's directory with its
+	// environment, so the authorizer and the policy check it as it runs
+	var execRequest mcp.CallToolRequest
+	execRequest.Params.Meta = request.Params.Meta
+	execRequest.Params.Arguments = map[string]interface{}{"command": command}
+	if configured := s.projects[p.Name]; configured == p {
+		execRequest.Params.Arguments["project"] = p.Name
+	}
+	if priority, ok := request.Params.Arguments["priority"]; ok {
+		execRequest.Params.Arguments["priority"] = priority
+	}
+	return s.authorized("execute_command", s.handleExecuteCommand)(ctx, execRequest)
+}
@@ -0,0 +1,71 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestProjectTasks(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "marker.txt"), []byte("here\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "Makefile"), []byte("show:\n\tcat Makefile\n\nbuild: ## Build it\n\tgo build\n"), 0o644)
+	s, err := NewShellServer(
+		WithAllowedCommands("ls"),
+		WithProjects([]Project{{
+			Name:   "app",
+			Dir:    dir,
+			Policy: &PolicyRules{Allow: []string{"cat", "echo"}},
+			Tasks: map[string]Task{
+				"show":  {Command: "cat marker.txt", Description: "Print the marker"},
+				"greet": {Command: "echo hello"},
+				"wipe":  {Command: "rm marker.txt"},
+			},
+		}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		tool     string
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{"list_tasks", map[string]interface{}{"project": "app"}, "Tasks of app (4):\n- build: make build (Makefile)\n  Build it\n- greet: echo hello (project)\n- show: cat marker.txt (project)\n  Print the marker\n- wipe: rm marker.txt (project)\n", ""},
+		{"list_tasks", map[string]interface{}{"project": "nope"}, "No project named 'nope'", ERROR_INVALID_ARGUMENT},
+		{"run_task", map[string]interface{}{"project": "app", "name": "show"}, "$ cat marker.txt\n\nhere\n", ""},
+		{"run_task", map[string]interface{}{"project": "app", "name": "greet", "args": []interface{}{"a b", "c;d"}}, "hello a b c;d\n", ""},
+		{"run_task", map[string]interface{}{"project": "app", "name": "greet", "args": "x"}, "'args' must be an array of strings", ERROR_INVALID_ARGUMENT},
+		{"run_task", map[string]interface{}{"project": "app", "name": "wipe"}, "Command 'rm' is not in the allowed list", ERROR_POLICY_DENIED},
+		{"run_task", map[string]interface{}{"project": "app", "name": "build"}, "Command 'make' is not in the allowed list", ERROR_POLICY_DENIED},
+		{"run_task", map[string]interface{}{"project": "app", "name": "deploy"}, "No task named 'deploy' in project 'app'", ERROR_INVALID_ARGUMENT},
+	}
+
+	handlers := map[string]func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error){
+		"list_tasks": s.handleListTasks,
+		"run_task":   s.handleRunTask,
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		result, _ := handlers[tt.tool](context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("%s(%v) = %q (code %q), want %q (code %q)", tt.tool, tt.args, text, code, tt.want, tt.wantCode)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "marker.txt")); err != nil {
+		t.Errorf("the refused task ran: %v", err)
+	}
+}
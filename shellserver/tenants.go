@@ -0,0 +1,134 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// tenancy keeps authenticated clients apart. A tenant is the subject a
+// client authenticated with; clients without one share the "" tenant.
+type tenancy struct {
+	enabled bool
+	admins  map[string]bool // Subjects that may list every tenant's commands
+}
+
+// WithTenantIsolation partitions what clients see by the subject of the
+// token they authenticated with, as the transport reports it through
+// ContextWithClientIdentity: each sees only its own command history and
+// execution outputs, and only its own sessions, REPLs and sandboxes. The
+// subjects in admins may list the commands of every tenant with
+// list_tenant_commands.
+func WithTenantIsolation(admins []string) Option {
+	return func(s *ShellServer) error {
+		s.tenancy = tenancy{enabled: true, admins: make(map[string]bool)}
+		for _, subject := range admins {
+			if subject == "" {
+				return fmt.Errorf("empty tenant admin subject")
+			}
+			s.tenancy.admins[subject] = true
+		}
+		return nil
+	}
+}
+
+// tenant returns the tenant making a tool call, or "" if tenants are not
+// isolated
+func (s *ShellServer) tenant(ctx context.Context) string {
+	if !s.tenancy.enabled {
+		return ""
+	}
+	if client := s.clientIdentity(ctx); client != nil {
+		return client.Subject
+	}
+	return ""
+}
+
+// executionTenant returns the tenant an execution ran for
+func executionTenant(execution CommandExecution) string {
+	if execution.Client == nil {
+		return ""
+	}
+	return execution.Client.Subject
+}
+
+// tenantHistory returns up to limit of the newest executions the caller
+// may see, newest first, and how many of them the history holds
+func (s *ShellServer) tenantHistory(ctx context.Context, limit int) ([]CommandExecution, int, error) {
+	if !s.tenancy.enabled {
+		return historySnapshot(s.history, limit)
+	}
+	tenant := s.tenant(ctx)
+	return s.filterHistory(limit, func(execution CommandExecution) bool {
+		return executionTenant(execution) == tenant
+	})
+}
+
+// filterHistory returns up to limit of the newest executions keep accepts,
+// newest first, and how many it accepts in all
+func (s *ShellServer) filterHistory(limit int, keep func(CommandExecution) bool) ([]CommandExecution, int, error) {
+	executions, err := s.history.Recent(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	var matching []CommandExecution
+	for _, execution := range executions {
+		if keep(execution) {
+			matching = append(matching, execution)
+		}
+	}
+	total := len(matching)
+	if limit > 0 && limit < total {
+		matching = matching[:limit]
+	}
+	return matching, total, nil
+}
+
+func (s *ShellServer) handleListTenantCommands(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if !s.tenancy.enabled {
+		return errorResult(ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: "Error: Tenants are not isolated on this server; use list_recent_commands.",
+		}), nil
+	}
+	client := s.clientIdentity(ctx)
+	if client == nil || !s.tenancy.admins[client.Subject] {
+		return errorResult(ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: "Error: Only tenant administrators may list the commands of other tenants.",
+		}), nil
+	}
+
+	limit := DEFAULT_LIMIT
+	if limitArg, ok := request.Params.Arguments["limit"].(float64); ok {
+		limit = int(limitArg)
+	}
+	tenant, filtered := request.Params.Arguments["tenant"].(string)
+	history, total, err := s.filterHistory(limit, func(execution CommandExecution) bool {
+		return !filtered || executionTenant(execution) == tenant
+	})
+	if err != nil {
+		return errorResult(ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: fmt.Sprintf("Error: Failed to read command history: %v", err),
+		}), nil
+	}
+	s.logger.Printf("Tenant administrator '%s' listed the commands of %s", client.Subject, tenantName(tenant, filtered))
+	return s.historyResult(history, total, true), nil
+}
+
+// tenantName describes the tenants list_tenant_commands was asked for
+func tenantName(tenant string, filtered bool) string {
+	switch {
+	case !filtered:
+		return "every tenant"
+	case tenant == "":
+		return "clients without a subject"
+	default:
+		return fmt.Sprintf("tenant %q", tenant)
+	}
+}
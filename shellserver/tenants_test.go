@@ -0,0 +1,149 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithTenantIsolation([]string{"admin"}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.closeAllSessions()
+	tenants := map[string]context.Context{}
+	for _, subject := range []string{"alice", "bob", "admin"} {
+		tenants[subject] = ContextWithClientIdentity(context.Background(), ClientIdentity{Subject: subject})
+	}
+	call := func(tenant string, handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), args map[string]interface{}) (string, bool) {
+		t.Helper()
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		result, err := handler(tenants[tenant], request)
+		if err != nil {
+			t.Fatalf("handler returned error: %v", err)
+		}
+		return result.Content[0].(mcp.TextContent).Text, result.IsError
+	}
+
+	call("alice", s.handleExecuteCommand, map[string]interface{}{"command": "echo alice-secret"})
+	call("bob", s.handleExecuteCommand, map[string]interface{}{"command": "echo bob-output"})
+	call("alice", s.handleStartSession, map[string]interface{}{})
+
+	tests := []struct {
+		name    string
+		tenant  string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]interface{}
+		want    string
+		isError bool
+	}{
+		{"own history", "alice", s.handleListRecentCommands, map[string]interface{}{}, "showing 1 of 1 total", false},
+		{"other history", "bob", s.handleListRecentCommands, map[string]interface{}{}, "$ echo bob-output", false},
+		{"other output as input", "bob", s.handleExecuteCommand, map[string]interface{}{"command": "echo", "stdin_resource": "exec://1/output"}, "Execution 1 is not in the recent history", true},
+		{"other output in placeholder", "bob", s.handleExecuteCommand, map[string]interface{}{"command": "echo {{exec:1:output}}"}, "Execution 1 is not in the recent history", true},
+		{"own session", "alice", s.handleExecuteCommand, map[string]interface{}{"command": "echo in-session", "session_id": "session-1"}, "in-session", false},
+		{"other session", "bob", s.handleExecuteCommand, map[string]interface{}{"command": "echo", "session_id": "session-1"}, "No session with ID 'session-1'", true},
+		{"other sessions listed", "bob", s.handleListSessions, map[string]interface{}{}, "No sessions are open", false},
+		{"other session closed", "bob", s.handleCloseSession, map[string]interface{}{"session_id": "session-1"}, "no session with ID 'session-1'", true},
+		{"not an admin", "bob", s.handleListTenantCommands, map[string]interface{}{}, "Only tenant administrators", true},
+		{"admin", "admin", s.handleListTenantCommands, map[string]interface{}{"tenant": "alice"}, "$ echo alice-secret", false},
+	}
+
+	for _, tt := range tests {
+		text, isError := call(tt.tenant, tt.handler, tt.args)
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s: got %q (error %v), want %q (error %v)", tt.name, text, isError, tt.want, tt.isError)
+		}
+		if strings.Contains(text, "alice-secret") && tt.tenant != "alice" && tt.tenant != "admin" {
+			t.Errorf("%s: %s sees alice's output: %q", tt.name, tt.tenant, text)
+		}
+	}
+
+	text, _ := call("admin", s.handleListTenantCommands, map[string]interface{}{})
+	if !strings.Contains(text, `Tenant: "alice"`) || !strings.Contains(text, `Tenant: "bob"`) {
+		t.Errorf("list_tenant_commands = %q, want the commands of every tenant", text)
+	}
+}
+
+func TestTenantTrashAndPins(t *testing.T) {
+	work := t.TempDir()
+	os.WriteFile(filepath.Join(work, "alice.txt"), []byte("alice"), 0o644)
+	s, err := NewShellServer(
+		WithAllowedCommands("rm,echo"),
+		WithProjects([]Project{{Name: "app", Dir: work}}),
+		WithTrash(t.TempDir(), time.Hour),
+		WithTenantIsolation(nil),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	call := func(tenant string, handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), args map[string]interface{}) (string, bool) {
+		t.Helper()
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		result, err := handler(ContextWithClientIdentity(context.Background(), ClientIdentity{Subject: tenant}), request)
+		if err != nil {
+			t.Fatalf("handler returned error: %v", err)
+		}
+		return result.Content[0].(mcp.TextContent).Text, result.IsError
+	}
+
+	text, _ := call("alice", s.handleExecuteCommand, map[string]interface{}{"command": "rm alice.txt", "project": "app"})
+	match := regexp.MustCompile(`to the trash as (\S+)`).FindStringSubmatch(text)
+	if match == nil {
+		t.Fatalf("rm = %q, want the file moved to the trash", text)
+	}
+	id := match[1]
+	call("alice", s.handlePinCommand, map[string]interface{}{"name": "greet", "command": "echo alice-pin"})
+
+	tests := []struct {
+		name    string
+		tenant  string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]interface{}
+		want    string
+		isError bool
+	}{
+		{"other trash listed", "bob", s.handleListTrash, map[string]interface{}{}, "The trash is empty.", false},
+		{"other trash restored", "bob", s.handleRestoreFile, map[string]interface{}{"id": id, "path": filepath.Join(work, "stolen.txt")}, "nothing in the trash has the ID", true},
+		{"other pins listed", "bob", s.handleListPinned, map[string]interface{}{}, "No commands are pinned", false},
+		{"other pin run", "bob", s.handleRunPinned, map[string]interface{}{"name": "greet"}, "No command is pinned as 'greet'", true},
+		{"other pin removed", "bob", s.handleUnpinCommand, map[string]interface{}{"name": "greet"}, "No command is pinned as 'greet'", true},
+		{"same pin name", "bob", s.handlePinCommand, map[string]interface{}{"name": "greet", "command": "echo bob-pin"}, "Pinned 'greet'", false},
+		{"own pins listed", "alice", s.handleListPinned, map[string]interface{}{}, "greet: echo alice-pin", false},
+		{"own trash listed", "alice", s.handleListTrash, map[string]interface{}{}, id, false},
+		{"own trash restored", "alice", s.handleRestoreFile, map[string]interface{}{"id": id}, "Restored " + id, false},
+	}
+
+	for _, tt := range tests {
+		text, isError := call(tt.tenant, tt.handler, tt.args)
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s: got %q (error %v), want %q (error %v)", tt.name, text, isError, tt.want, tt.isError)
+		}
+		if tt.tenant == "bob" && strings.Contains(text, "alice") {
+			t.Errorf("%s: bob sees alice's data: %q", tt.name, text)
+		}
+	}
+}
+
+func TestTenantCommandsWithoutIsolation(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	text, isError := callTool(t, s.handleListTenantCommands, map[string]interface{}{})
+	if !isError || !strings.Contains(text, "not isolated") {
+		t.Errorf("list_tenant_commands without isolation = %q, want an error", text)
+	}
+	if _, err := NewShellServer(WithTenantIsolation([]string{""})); err == nil {
+		t.Errorf("WithTenantIsolation with an empty subject should fail")
+	}
+}
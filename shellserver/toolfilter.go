@@ -0,0 +1,65 @@
+package shellserver
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// toolFilter decides which tools are registered. Patterns are shell globs
+// such as "fetch_url" or "*_sandbox".
+type toolFilter struct {
+	enabled  []string // Only tools matching one of these are registered; nil for all
+	disabled []string // Tools matching one of these are not registered
+}
+
+// WithTools registers only the tools matching enabled, or all of them if
+// it is empty, except those matching disabled. Tools that are not
+// registered are missing from the tool list and cannot be called. Every
+// pattern must match a tool.
+func WithTools(enabled []string, disabled []string) Option {
+	return func(s *ShellServer) error {
+		for _, pattern := range append(append([]string{}, enabled...), disabled...) {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return fmt.Errorf("invalid tool pattern '%s'", pattern)
+			}
+		}
+		s.tools = toolFilter{enabled: enabled, disabled: disabled}
+		return nil
+	}
+}
+
+// matchesTool reports whether name matches one of patterns
+func matchesTool(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the tool named name is registered
+func (f toolFilter) allows(name string) bool {
+	if len(f.enabled) > 0 && !matchesTool(f.enabled, name) {
+		return false
+	}
+	return !matchesTool(f.disabled, name)
+}
+
+// check refuses patterns that match none of the tools in names, which are
+// likely misspelled
+func (f toolFilter) check(names map[string]bool) error {
+	patterns := append(append([]string{}, f.enabled...), f.disabled...)
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		found := false
+		for name := range names {
+			found = found || matchesTool([]string{pattern}, name)
+		}
+		if !found {
+			return fmt.Errorf("tool pattern '%s' matches no tool", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package shellserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToolFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  []string
+		disabled []string
+		tool     string
+		want     bool
+	}{
+		{"no filter", nil, nil, "execute_command", true},
+		{"disabled", nil, []string{"fetch_url"}, "fetch_url", false},
+		{"disabled by glob", nil, []string{"*_sandbox"}, "exec_in_sandbox", false},
+		{"not disabled", nil, []string{"*_sandbox"}, "execute_command", true},
+		{"enabled", []string{"execute_command", "list_*"}, nil, "list_sessions", true},
+		{"not enabled", []string{"execute_command"}, nil, "fetch_url", false},
+		{"disabled wins", []string{"*"}, []string{"fetch_url"}, "fetch_url", false},
+	}
+
+	for _, tt := range tests {
+		filter := toolFilter{enabled: tt.enabled, disabled: tt.disabled}
+		if got := filter.allows(tt.tool); got != tt.want {
+			t.Errorf("%s: allows(%s) = %v, want %v", tt.name, tt.tool, got, tt.want)
+		}
+	}
+}
+
+func TestWithTools(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("ls"), WithTools([]string{"execute_command", "list_*"}, []string{"list_sessions"}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if !s.toolNames["fetch_url"] {
+		t.Errorf("toolNames is missing tools that were not registered")
+	}
+
+	errors := []struct {
+		enabled  []string
+		disabled []string
+		want     string
+	}{
+		{nil, []string{"run_scirpt"}, "tool pattern 'run_scirpt' matches no tool"},
+		{[]string{"[exec"}, nil, "invalid tool pattern '[exec'"},
+		{[]string{""}, nil, "invalid tool pattern ''"},
+	}
+	for _, tt := range errors {
+		_, err := NewShellServer(WithAllowedCommands("ls"), WithTools(tt.enabled, tt.disabled))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("WithTools(%q, %q) error = %v, want %q", tt.enabled, tt.disabled, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package shellserver_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gamunu/mcp-unix-shell/shellserver"
+	"github.com/gamunu/mcp-unix-shell/shellserver/shelltest"
+)
+
+func TestToolsOverJSONRPC(t *testing.T) {
+	executor := shelltest.NewExecutor().
+		On("ls", shelltest.Result{Output: "README.md\n"}).
+		On("cat missing", shelltest.Result{Output: "cat: missing: No such file or directory\n", ExitCode: 1})
+	shell, err := shellserver.NewShellServer(shellserver.WithAllowedCommands("ls,cat"), shellserver.WithExecutor(executor))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer shell.Close()
+
+	ctx := context.Background()
+	client, err := shelltest.NewClient(ctx, shell)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		tool    string
+		args    map[string]interface{}
+		want    string
+		isError bool
+	}{
+		{"execute_command", map[string]interface{}{"command": "ls"}, "README.md", false},
+		{"execute_command", map[string]interface{}{"command": "cat missing"}, "failed with exit code 1", false},
+		{"execute_command", map[string]interface{}{"command": "curl example.com"}, "not in the allowed list", true},
+		{"execute_command", map[string]interface{}{}, "'command' must be a string", true},
+		{"list_allowed_commands", nil, "ls", false},
+		{"list_recent_commands", nil, "showing 2 of 2 total", false},
+	}
+
+	for _, tt := range tests {
+		result, err := client.CallTool(ctx, tt.tool, tt.args)
+		if err != nil {
+			t.Fatalf("%s failed: %v", tt.tool, err)
+		}
+		if text := shelltest.Text(result); !strings.Contains(text, tt.want) || result.IsError != tt.isError {
+			t.Errorf("%s(%v) = %q (error %v), want %q (error %v)", tt.tool, tt.args, text, result.IsError, tt.want, tt.isError)
+		}
+	}
+}
+
+func TestDisabledToolsOverJSONRPC(t *testing.T) {
+	shell, err := shellserver.NewShellServer(
+		shellserver.WithAllowedCommands("ls"),
+		shellserver.WithExecutor(shelltest.NewExecutor()),
+		shellserver.WithTools(nil, []string{"fetch_url", "*_sandbox"}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer shell.Close()
+
+	ctx := context.Background()
+	client, err := shelltest.NewClient(ctx, shell)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+	listed := make(map[string]bool)
+	for _, tool := range tools {
+		listed[tool.Name] = true
+	}
+	for name, want := range map[string]bool{"execute_command": true, "fetch_url": false, "create_sandbox": false, "exec_in_sandbox": false} {
+		if listed[name] != want {
+			t.Errorf("tool %s listed = %v, want %v", name, listed[name], want)
+		}
+	}
+
+	if _, err := client.CallTool(ctx, "fetch_url", map[string]interface{}{"url": "https://example.com"}); err == nil {
+		t.Errorf("calling disabled fetch_url succeeded, want an error")
+	}
+}
@@ -0,0 +1,96 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ICMP details of struct sock_extended_err (linux/errqueue.h)
+const (
+	soEeOriginICMP     = 2     // SO_EE_ORIGIN_ICMP
+	icmpDestUnreach    = 3     // ICMP_DEST_UNREACH
+	sockExtendedErrLen = 16    // Size of struct sock_extended_err, followed by the offender's address
+	traceBasePort      = 33434 // First destination port, as traceroute uses
+)
+
+// traceRoute sends UDP datagrams to destination with increasing TTLs, as
+// traceroute does, and calls hop with the router or host answering each.
+// IP_RECVERR queues the ICMP replies on the socket itself, so no raw socket
+// and no privileges are needed. It stops once the destination or a router
+// reports it unreachable.
+func traceRoute(ctx context.Context, destination net.IP, maxHops int, hopTimeout time.Duration, hop func(TraceHop)) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_RECVERR, 1); err != nil {
+		return err
+	}
+
+	buffer := make([]byte, 512)
+	oob := make([]byte, 512)
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TTL, ttl); err != nil {
+			return err
+		}
+		// The reply quotes the datagram, which tells late replies apart
+		payload := []byte(fmt.Sprintf("mcp-unix-shell trace %d", ttl))
+		address := &syscall.SockaddrInet4{Port: traceBasePort + ttl}
+		copy(address.Addr[:], destination.To4())
+		start := time.Now()
+		if err := syscall.Sendto(fd, payload, 0, address); err != nil {
+			// A late reply to an earlier hop can fail the send; it is retried once
+			if err = syscall.Sendto(fd, payload, 0, address); err != nil {
+				return err
+			}
+		}
+
+		answer := TraceHop{TTL: ttl}
+		final := false
+		for deadline := start.Add(hopTimeout); answer.Address == "" && time.Now().Before(deadline); {
+			n, oobn, _, _, err := syscall.Recvmsg(fd, buffer, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				select {
+				case <-time.After(5 * time.Millisecond):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(buffer[:n], payload) {
+				continue
+			}
+			messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+			if err != nil {
+				return err
+			}
+			for _, message := range messages {
+				data := message.Data
+				if message.Header.Level != syscall.IPPROTO_IP || message.Header.Type != syscall.IP_RECVERR ||
+					len(data) < sockExtendedErrLen+8 || data[4] != soEeOriginICMP {
+					continue
+				}
+				// The offender is a struct sockaddr_in: family, port, then the address
+				answer.Address = net.IP(data[sockExtendedErrLen+4 : sockExtendedErrLen+8]).String()
+				answer.Ms = float64(time.Since(start).Microseconds()) / 1000
+				final = data[5] == icmpDestUnreach
+			}
+		}
+		hop(answer)
+		if final {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
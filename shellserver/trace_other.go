@@ -0,0 +1,16 @@
+//go:build !linux
+
+package shellserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// traceRoute is not available here: without Linux's IP_RECVERR, reading the
+// ICMP replies it relies on needs a raw socket and so root
+func traceRoute(ctx context.Context, destination net.IP, maxHops int, hopTimeout time.Duration, hop func(TraceHop)) error {
+	return errors.New("trace_route is only supported on Linux")
+}
@@ -0,0 +1,251 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Transcript settings
+const (
+	DEFAULT_TRANSCRIPT_LIMIT        = 50  // Commands in a transcript without a limit
+	DEFAULT_TRANSCRIPT_OUTPUT_LINES = 20  // Lines of output kept per command, half from its start and half from its end
+	MAX_TRANSCRIPT_LINE_LENGTH      = 400 // Characters of an output line kept
+)
+
+// terminalEscape matches the color and cursor sequences of terminal output
+var terminalEscape = regexp.MustCompile(`\x1b(\[[0-9;?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\))`)
+
+// transcriptFilter selects the executions of a transcript
+type transcriptFilter struct {
+	session string
+	project string
+	fromID  int64 // Zero for no lower bound
+	toID    int64 // Zero for no upper bound
+}
+
+// matches reports whether an execution belongs in the transcript
+func (f transcriptFilter) matches(execution CommandExecution) bool {
+	return (f.session == "" || execution.Session == f.session) &&
+		(f.project == "" || execution.Project == f.project) &&
+		(f.fromID == 0 || execution.ID >= f.fromID) &&
+		(f.toID == 0 || execution.ID <= f.toID)
+}
+
+// transcriptID reads an optional execution ID argument
+func transcriptID(request mcp.CallToolRequest, name string) (int64, *ToolError) {
+	value, found := request.Params.Arguments[name]
+	if !found {
+		return 0, nil
+	}
+	id, ok := value.(float64)
+	if !ok || id < 1 || id != float64(int64(id)) {
+		return 0, &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf("Error: '%s' must be the ID of an execution, as list_recent_commands shows it", name),
+			Details: map[string]interface{}{"argument": name},
+		}
+	}
+	return int64(id), nil
+}
+
+func (s *ShellServer) handleExportTranscript(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	var filter transcriptFilter
+	filter.session, _ = request.Params.Arguments["session_id"].(string)
+	filter.project, _ = request.Params.Arguments["project"].(string)
+	var toolError *ToolError
+	if filter.fromID, toolError = transcriptID(request, "from_id"); toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	if filter.toID, toolError = transcriptID(request, "to_id"); toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	limit := DEFAULT_TRANSCRIPT_LIMIT
+	if limitArg, ok := request.Params.Arguments["limit"].(float64); ok && limitArg >= 1 {
+		limit = int(limitArg)
+	}
+	outputLines := DEFAULT_TRANSCRIPT_OUTPUT_LINES
+	if linesArg, ok := request.Params.Arguments["output_lines"].(float64); ok && linesArg >= 0 {
+		outputLines = int(linesArg)
+	}
+	title, _ := request.Params.Arguments["title"].(string)
+
+	recent, _, err := s.tenantHistory(ctx, 0)
+	if err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: fmt.Sprintf("Error: Failed to read command history: %v", err)}), nil
+	}
+	// The history is newest first; the transcript reads oldest first
+	var executions []CommandExecution
+	for _, execution := range recent {
+		if filter.matches(execution) && len(executions) < limit {
+			executions = append([]CommandExecution{execution}, executions...)
+		}
+	}
+	if len(executions) == 0 {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: No executions in the recent history match; list_recent_commands shows what can be exported.",
+		}), nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: transcriptMarkdown(title, filter, executions, outputLines),
+			},
+		},
+	}, nil
+}
+
+// transcriptMarkdown renders executions as a markdown document: a summary,
+// each command with its status, duration, the agent's reason and trimmed
+// output, and a list of the failures
+func transcriptMarkdown(title string, filter transcriptFilter, executions []CommandExecution, outputLines int) string {
+	if title == "" {
+		title = "Shell transcript"
+	}
+	var doc strings.Builder
+	fmt.Fprintf(&doc, "# %s\n\n", title)
+
+	var total time.Duration
+	var failures []CommandExecution
+	for _, execution := range executions {
+		total += time.Duration(execution.ExecutionMs) * time.Millisecond
+		if transcriptFailed(execution) {
+			failures = append(failures, execution)
+		}
+	}
+	first, last := executions[0], executions[len(executions)-1]
+	commands := "commands"
+	if len(executions) == 1 {
+		commands = "command"
+	}
+	fmt.Fprintf(&doc, "%d %s, %d failed, %s of execution, from %s to %s.",
+		len(executions), commands, len(failures), transcriptDuration(total),
+		first.StartTime.UTC().Format("2006-01-02 15:04:05"), last.EndTime.UTC().Format("2006-01-02 15:04:05 UTC"))
+	if filter.session != "" {
+		fmt.Fprintf(&doc, " Session %s.", inlineCode(filter.session))
+	}
+	if filter.project != "" {
+		fmt.Fprintf(&doc, " Project %s.", inlineCode(filter.project))
+	}
+	doc.WriteString("\n")
+
+	for i, execution := range executions {
+		fmt.Fprintf(&doc, "\n## %d. %s\n\n", i+1, inlineCode(escapeHidden(execution.Command)))
+		fmt.Fprintf(&doc, "%s in %s", transcriptStatus(execution), transcriptDuration(time.Duration(execution.ExecutionMs)*time.Millisecond))
+		if execution.Target != "" {
+			fmt.Fprintf(&doc, " on %s", inlineCode(execution.Target))
+		}
+		if execution.ID != 0 {
+			fmt.Fprintf(&doc, " (execution %d)", execution.ID)
+		}
+		doc.WriteString("\n")
+		if execution.Reason != "" {
+			fmt.Fprintf(&doc, "\n> %s\n", escapeHidden(execution.Reason))
+		}
+		if output := trimTranscriptOutput(execution.Output, outputLines); output != "" {
+			fence := codeFence(output)
+			fmt.Fprintf(&doc, "\n%s\n%s\n%s\n", fence, output, fence)
+		}
+	}
+
+	if len(failures) > 0 {
+		doc.WriteString("\n## Failures\n\n")
+		for _, execution := range failures {
+			fmt.Fprintf(&doc, "- %s: %s\n", inlineCode(escapeHidden(execution.Command)), transcriptStatus(execution))
+		}
+	}
+	return doc.String()
+}
+
+// transcriptFailed reports whether an execution failed, timed out or was
+// cut short
+func transcriptFailed(execution CommandExecution) bool {
+	return execution.ExitCode != 0 || execution.TimedOut || execution.ErrorCode != ""
+}
+
+// transcriptStatus describes how an execution ended
+func transcriptStatus(execution CommandExecution) string {
+	switch {
+	case execution.TimedOut:
+		return "**Timed out**"
+	case execution.ErrorCode != "" && execution.ExitCode == 0:
+		return fmt.Sprintf("**Cut short** (%s)", execution.ErrorCode)
+	case execution.ExitCode != 0:
+		return fmt.Sprintf("**Failed** (exit %d)", execution.ExitCode)
+	}
+	return "Succeeded"
+}
+
+// transcriptDuration rounds a duration for reading
+func transcriptDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// trimTranscriptOutput keeps the first and last lines of an output, up to
+// lines in all, as a terminal shows them: without colors, and of a line
+// rewritten with carriage returns, such as a progress bar, only the last
+// text. Long lines are shortened.
+func trimTranscriptOutput(output string, lines int) string {
+	output = strings.TrimRight(terminalEscape.ReplaceAllString(output, ""), "\n")
+	if output == "" || lines == 0 {
+		return ""
+	}
+	all := strings.Split(output, "\n")
+	for i, line := range all {
+		line = strings.TrimSuffix(line, "\r")
+		all[i] = escapeHidden(line[strings.LastIndexByte(line, '\r')+1:])
+		if runes := []rune(all[i]); len(runes) > MAX_TRANSCRIPT_LINE_LENGTH {
+			all[i] = string(runes[:MAX_TRANSCRIPT_LINE_LENGTH]) + " …"
+		}
+	}
+	if len(all) > lines {
+		head := (lines + 1) / 2
+		tail := lines - head
+		omitted := fmt.Sprintf("… %d lines omitted …", len(all)-lines)
+		all = append(append(all[:head:head], omitted), all[len(all)-tail:]...)
+	}
+	return strings.Join(all, "\n")
+}
+
+// codeFence returns a fence of backticks longer than any run of backticks
+// in text, so the text cannot end the block early
+func codeFence(text string) string {
+	return strings.Repeat("`", max(3, longestBacktickRun(text)+1))
+}
+
+// inlineCode quotes text as inline code, with enough backticks that those
+// in text do not end it
+func inlineCode(text string) string {
+	ticks := strings.Repeat("`", longestBacktickRun(text)+1)
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		return ticks + " " + text + " " + ticks
+	}
+	return ticks + text + ticks
+}
+
+// longestBacktickRun returns the length of the longest run of backticks
+func longestBacktickRun(text string) int {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
@@ -0,0 +1,129 @@
+package shellserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrimTranscriptOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		lines  int
+		want   string
+	}{
+		{"", 20, ""},
+		{"one\ntwo\n", 20, "one\ntwo"},
+		{"one\ntwo\n", 0, ""},
+		{"\x1b[31mred\x1b[0m text", 20, "red text"},
+		{"\x1b]0;title\x07done", 20, "done"},
+		{"10%\r50%\r100%\nok", 20, "100%\nok"},
+		{"crlf\r\nline\r\n", 20, "crlf\nline"},
+		{"a\nb\nc\nd\ne\nf", 4, "a\nb\n… 2 lines omitted …\ne\nf"},
+		{"a\nb\nc\nd\ne\nf", 3, "a\nb\n… 3 lines omitted …\nf"},
+		{"bell\x07", 20, "bell\\u{7}"},
+		{strings.Repeat("x", MAX_TRANSCRIPT_LINE_LENGTH+10), 20, strings.Repeat("x", MAX_TRANSCRIPT_LINE_LENGTH) + " …"},
+	}
+
+	for _, tt := range tests {
+		if got := trimTranscriptOutput(tt.output, tt.lines); got != tt.want {
+			t.Errorf("trimTranscriptOutput(%q, %d) = %q, want %q", tt.output, tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestTranscriptQuoting(t *testing.T) {
+	tests := []struct {
+		text   string
+		inline string
+		fence  string
+	}{
+		{"ls -la", "`ls -la`", "```"},
+		{"echo `date`", "`` echo `date` ``", "```"},
+		{"a`b", "``a`b``", "```"},
+		{"`date`", "`` `date` ``", "```"},
+		{"```go\nx\n```", "```` ```go\nx\n``` ````", "````"},
+	}
+
+	for _, tt := range tests {
+		if got := inlineCode(tt.text); got != tt.inline {
+			t.Errorf("inlineCode(%q) = %q, want %q", tt.text, got, tt.inline)
+		}
+		if got := codeFence(tt.text); got != tt.fence {
+			t.Errorf("codeFence(%q) = %q, want %q", tt.text, got, tt.fence)
+		}
+	}
+}
+
+func TestExportTranscript(t *testing.T) {
+	history := newMemoryHistory(10)
+	start := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	for _, execution := range []CommandExecution{
+		{ID: 1, Command: "go build ./...", Session: "s1", Project: "api", Reason: "Check the parser compiles", ExecutionMs: 1200},
+		{ID: 2, Command: "go test ./...", Session: "s1", Project: "api", ExitCode: 1, ExecutionMs: 3400, Output: "--- FAIL: TestParse\nFAIL\n"},
+		{ID: 3, Command: "ls", Session: "s2", Project: "web", ExecutionMs: 5, Output: "index.html\n"},
+		{ID: 4, Command: "sleep 60", Session: "s1", Project: "api", TimedOut: true, ExecutionMs: 30000},
+	} {
+		execution.StartTime = start.Add(time.Duration(execution.ID) * time.Minute)
+		execution.EndTime = execution.StartTime.Add(time.Duration(execution.ExecutionMs) * time.Millisecond)
+		history.Add(execution)
+	}
+	s, err := NewShellServer(WithHistoryStore(history))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		args    map[string]interface{}
+		want    []string
+		notWant []string
+		isError bool
+	}{
+		{
+			args: map[string]interface{}{"session_id": "s1", "title": "Fix parser"},
+			want: []string{
+				"# Fix parser\n",
+				"3 commands, 2 failed, 34.6s of execution, from 2026-03-01 09:31:00 to 2026-03-01 09:34:30 UTC. Session `s1`.",
+				"## 1. `go build ./...`\n\nSucceeded in 1.2s (execution 1)\n\n> Check the parser compiles\n",
+				"## 2. `go test ./...`\n\n**Failed** (exit 1) in 3.4s (execution 2)\n\n```\n--- FAIL: TestParse\nFAIL\n```\n",
+				"## 3. `sleep 60`\n\n**Timed out** in 30s",
+				"## Failures\n\n- `go test ./...`: **Failed** (exit 1)\n- `sleep 60`: **Timed out**\n",
+			},
+			notWant: []string{"ls"},
+		},
+		{
+			args:    map[string]interface{}{"project": "web"},
+			want:    []string{"# Shell transcript\n", "1 command, 0 failed", "Project `web`.", "```\nindex.html\n```"},
+			notWant: []string{"## Failures"},
+		},
+		{
+			args:    map[string]interface{}{"from_id": float64(2), "to_id": float64(3), "output_lines": float64(0)},
+			want:    []string{"## 1. `go test ./...`", "## 2. `ls`"},
+			notWant: []string{"go build", "sleep", "FAIL: TestParse"},
+		},
+		{
+			args:    map[string]interface{}{"limit": float64(1)},
+			want:    []string{"## 1. `sleep 60`"},
+			notWant: []string{"## 2."},
+		},
+		{args: map[string]interface{}{"session_id": "none"}, want: []string{"No executions"}, isError: true},
+		{args: map[string]interface{}{"from_id": float64(1.5)}, want: []string{"'from_id' must be the ID of an execution"}, isError: true},
+	}
+
+	for _, tt := range tests {
+		text, isError := callTool(t, s.handleExportTranscript, tt.args)
+		if isError != tt.isError {
+			t.Errorf("export_transcript(%v) isError = %v, want %v: %s", tt.args, isError, tt.isError, text)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("export_transcript(%v) = %q, want it to contain %q", tt.args, text, want)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(text, notWant) {
+				t.Errorf("export_transcript(%v) = %q, want it not to contain %q", tt.args, text, notWant)
+			}
+		}
+	}
+}
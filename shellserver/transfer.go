@@ -0,0 +1,261 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MAX_TRANSFER_SIZE caps files copied with push_file and pull_file
+const MAX_TRANSFER_SIZE = 10 * 1024 * 1024
+
+// errTransferTooLarge reports a pulled file over MAX_TRANSFER_SIZE
+var errTransferTooLarge = errors.New("file is too large")
+
+// Files are streamed through ssh with cat rather than copied with scp, so
+// remote paths are quoted the same way for every OpenSSH version and the
+// size limit is enforced while copying.
+
+// transferArgs returns the target's host and the checked local and remote
+// paths of a push_file or pull_file request
+func (s *ShellServer) transferArgs(request mcp.CallToolRequest) (SSHHost, string, string, *ToolError) {
+	invalid := func(argument string, format string, args ...interface{}) (SSHHost, string, string, *ToolError) {
+		return SSHHost{}, "", "", &ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: fmt.Sprintf(format, args...),
+			Details: map[string]interface{}{"argument": argument},
+		}
+	}
+
+	if s.targets == nil {
+		return SSHHost{}, "", "", &ToolError{Code: ERROR_INVALID_ARGUMENT, Message: s.message(MSG_NO_TARGETS)}
+	}
+	target, _ := request.Params.Arguments["target"].(string)
+	host, found := s.currentTargets().Hosts[target]
+	if !found {
+		return invalid("target", "%s", s.message(MSG_INVALID_TARGETS, fmt.Errorf("unknown host '%s'", target)))
+	}
+	localPath, _ := request.Params.Arguments["local_path"].(string)
+	if !filepath.IsAbs(localPath) {
+		return invalid("local_path", "Error: 'local_path' must be an absolute path")
+	}
+	remotePath, _ := request.Params.Arguments["remote_path"].(string)
+	if remotePath == "" || strings.ContainsAny(remotePath, "\x00\n") {
+		return invalid("remote_path", "Error: 'remote_path' must be a file path on the target")
+	}
+	return host, filepath.Clean(localPath), remotePath, nil
+}
+
+// transferDenied refuses paths that trip a tripwire, protected paths, and
+// writes when the policy is read-only
+func (s *ShellServer) transferDenied(ctx context.Context, tool string, paths []string, destination string) *ToolError {
+	for _, p := range paths {
+		if toolError := s.fileTripwireDenial(ctx, tool, p, p); toolError != nil {
+			return toolError
+		}
+	}
+	allowlist, ok := s.policy.(*AllowlistPolicy)
+	if !ok {
+		return nil
+	}
+	for _, p := range paths {
+		if rule := allowlist.protects(p); rule != "" {
+			return &ToolError{
+				Code:    ERROR_POLICY_DENIED,
+				Message: s.message(MSG_TRANSFER_DENIED_PATH, p, rule),
+				Details: map[string]interface{}{"rule": DENY_PATH, "match": rule, "path": p},
+			}
+		}
+	}
+	if allowlist.ReadOnly() {
+		return &ToolError{
+			Code:    ERROR_POLICY_DENIED,
+			Message: s.message(MSG_TRANSFER_READ_ONLY, destination),
+			Details: map[string]interface{}{"rule": DENY_REDIRECT, "path": destination},
+		}
+	}
+	return nil
+}
+
+// transferFailed describes a failed ssh transfer from its stderr
+func (s *ShellServer) transferFailed(target string, err error, stderr string) ToolError {
+	stderr = strings.TrimSpace(stderr)
+	if sshFailed(err, stderr) {
+		return ToolError{
+			Code:    ERROR_TARGET_UNREACHABLE,
+			Message: fmt.Sprintf("Host '%s' could not be reached: %s", target, stderr),
+			Details: map[string]interface{}{"target": target},
+		}
+	}
+	if stderr == "" {
+		stderr = err.Error()
+	}
+	return ToolError{
+		Code:    ERROR_EXECUTION_FAILED,
+		Message: "Error: " + stderr,
+		Details: map[string]interface{}{"target": target},
+	}
+}
+
+// transferSession takes a pooled session to a target for a transfer
+func (s *ShellServer) transferSession(ctx context.Context, target string) (func(), *ToolError) {
+	if err := s.sshPool.backoff(target, time.Now()); err != nil {
+		return nil, &ToolError{
+			Code:    ERROR_TARGET_UNREACHABLE,
+			Message: fmt.Sprintf("Host '%s' could not be reached: %v", target, err),
+			Details: map[string]interface{}{"target": target},
+		}
+	}
+	release, err := s.sshPool.session(ctx, target, s.timeout)
+	if err != nil {
+		return nil, &ToolError{
+			Code:    ERROR_EXECUTION_FAILED,
+			Message: "Error: " + err.Error(),
+			Details: map[string]interface{}{"target": target},
+		}
+	}
+	return release, nil
+}
+
+func (s *ShellServer) handlePushFile(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	host, localPath, remotePath, toolError := s.transferArgs(request)
+	if toolError == nil {
+		toolError = s.transferDenied(ctx, "push_file", []string{localPath, remotePath}, remotePath)
+	}
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	target := request.Params.Arguments["target"].(string)
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: "Error: " + err.Error(), Details: map[string]interface{}{"argument": "local_path"}}), nil
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: fmt.Sprintf("Error: '%s' is not a regular file", localPath), Details: map[string]interface{}{"argument": "local_path"}}), nil
+	}
+	if info.Size() > MAX_TRANSFER_SIZE {
+		return errorResult(s.transferTooLarge(localPath)), nil
+	}
+
+	start := s.now()
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	release, toolError := s.transferSession(ctx, target)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer release()
+	var stderr bytes.Buffer
+	cmd := s.control.command(ctx, "ssh", append(s.sshPool.sshArgs(host), "cat > "+shellQuote(remotePath))...)
+	cmd.Stdin = file
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	s.sshPool.report(target, sshFailed(err, stderr.String()), time.Now())
+	if err != nil {
+		return errorResult(s.transferFailed(target, err, stderr.String())), nil
+	}
+
+	s.logger.Printf("Pushed %s to %s:%s (%d bytes)", localPath, target, remotePath, info.Size())
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(s.message(MSG_TRANSFERRED, info.Size(), localPath, target+":"+remotePath, s.now().Sub(start).Milliseconds()))},
+	}, nil
+}
+
+func (s *ShellServer) handlePullFile(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	host, localPath, remotePath, toolError := s.transferArgs(request)
+	if toolError == nil {
+		toolError = s.transferDenied(ctx, "pull_file", []string{remotePath, localPath}, localPath)
+	}
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	target := request.Params.Arguments["target"].(string)
+
+	// Copy to a temporary file beside the destination, so a failed or
+	// oversized transfer leaves nothing behind
+	temp, err := os.CreateTemp(filepath.Dir(localPath), ".pull-*")
+	if err != nil {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: "Error: " + err.Error(), Details: map[string]interface{}{"argument": "local_path"}}), nil
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	start := s.now()
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	release, toolError := s.transferSession(ctx, target)
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+	defer release()
+	var stderr bytes.Buffer
+	cmd := s.control.command(ctx, "ssh", append(s.sshPool.sshArgs(host), "cat -- "+shellQuote(remotePath))...)
+	cmd.Stderr = &stderr
+	size, err := copyLimited(cmd, temp, MAX_TRANSFER_SIZE)
+	s.sshPool.report(target, sshFailed(err, stderr.String()), time.Now())
+	if err == errTransferTooLarge {
+		return errorResult(s.transferTooLarge(target + ":" + remotePath)), nil
+	} else if err != nil {
+		return errorResult(s.transferFailed(target, err, stderr.String())), nil
+	}
+	if err := temp.Close(); err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: "Error: " + err.Error()}), nil
+	}
+	if err := os.Rename(temp.Name(), localPath); err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: "Error: " + err.Error()}), nil
+	}
+
+	s.logger.Printf("Pulled %s:%s to %s (%d bytes)", target, remotePath, localPath, size)
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(s.message(MSG_TRANSFERRED, size, target+":"+remotePath, localPath, s.now().Sub(start).Milliseconds()))},
+	}, nil
+}
+
+// copyLimited runs cmd and copies its stdout to w, stopping it once more
+// than limit bytes have been written
+func copyLimited(cmd *exec.Cmd, w io.Writer, limit int64) (int64, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	size, copyErr := io.Copy(w, io.LimitReader(stdout, limit+1))
+	if size > limit {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return size, errTransferTooLarge
+	}
+	if err := cmd.Wait(); err != nil {
+		return size, err
+	}
+	return size, copyErr
+}
+
+// transferTooLarge reports a file over MAX_TRANSFER_SIZE
+func (s *ShellServer) transferTooLarge(path string) ToolError {
+	return ToolError{
+		Code:    ERROR_FILE_TOO_LARGE,
+		Message: s.message(MSG_FILE_TOO_LARGE, path, MAX_TRANSFER_SIZE),
+		Details: map[string]interface{}{"path": path, "limitBytes": MAX_TRANSFER_SIZE},
+	}
+}
@@ -0,0 +1,93 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSSHServer puts the fake ssh client on PATH and returns a server with
+// the test targets and extra options
+func fakeSSHServer(t *testing.T, opts ...Option) *ShellServer {
+	t.Helper()
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(fakeSSH), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s, err := NewShellServer(append(opts, WithTargets(testTargets()))...)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestPushPullFile(t *testing.T) {
+	s := fakeSSHServer(t, WithPolicyRules(&PolicyRules{
+		DenyPaths: []string{".ssh"},
+		Tripwires: []Tripwire{{Name: "aws", Paths: []string{".aws/credentials"}}},
+	}))
+	dir := t.TempDir()
+	local := filepath.Join(dir, "app.conf")
+	os.WriteFile(local, []byte("port = 8080\n"), 0644)
+	large := filepath.Join(dir, "large.bin")
+	os.WriteFile(large, nil, 0644)
+	os.Truncate(large, MAX_TRANSFER_SIZE+1)
+
+	// The fake ssh runs the remote side locally, so remote paths are in dir
+	remote := filepath.Join(dir, "remote app.conf")
+	tests := []struct {
+		tool    string
+		args    map[string]interface{}
+		want    string
+		isError bool
+	}{
+		{"push", map[string]interface{}{"target": "web1", "local_path": local, "remote_path": remote}, "Copied 12 bytes", false},
+		{"pull", map[string]interface{}{"target": "web1", "remote_path": remote, "local_path": filepath.Join(dir, "pulled.conf")}, "Copied 12 bytes", false},
+		{"push", map[string]interface{}{"target": "db", "local_path": local, "remote_path": remote}, "unknown host 'db'", true},
+		{"push", map[string]interface{}{"target": "web1", "local_path": "app.conf", "remote_path": remote}, "absolute path", true},
+		{"push", map[string]interface{}{"target": "web1", "local_path": local, "remote_path": "~/.ssh/authorized_keys"}, "protected path '.ssh'", true},
+		{"pull", map[string]interface{}{"target": "web1", "remote_path": ".ssh/id_rsa", "local_path": filepath.Join(dir, "key")}, "protected path '.ssh'", true},
+		{"pull", map[string]interface{}{"target": "web1", "remote_path": "/root/.aws/credentials", "local_path": filepath.Join(dir, "creds")}, "refused and reported to the operator", true},
+		{"push", map[string]interface{}{"target": "web1", "local_path": local, "remote_path": "/home/dev/.aws/credentials"}, "refused and reported to the operator", true},
+		{"push", map[string]interface{}{"target": "web1", "local_path": large, "remote_path": remote}, "transfer limit", true},
+		{"pull", map[string]interface{}{"target": "web1", "remote_path": large, "local_path": filepath.Join(dir, "large.copy")}, "transfer limit", true},
+		{"pull", map[string]interface{}{"target": "web1", "remote_path": filepath.Join(dir, "missing"), "local_path": filepath.Join(dir, "missing.copy")}, "No such file", true},
+		{"pull", map[string]interface{}{"target": "down1", "remote_path": remote, "local_path": filepath.Join(dir, "down.conf")}, "could not be reached", true},
+	}
+
+	for _, tt := range tests {
+		handler := s.handlePushFile
+		if tt.tool == "pull" {
+			handler = s.handlePullFile
+		}
+		text, isError := callTool(t, handler, tt.args)
+		if isError != tt.isError || !strings.Contains(text, tt.want) {
+			t.Errorf("%s_file(%v) = %q (error %v), want %q (error %v)", tt.tool, tt.args, text, isError, tt.want, tt.isError)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "pulled.conf")); string(data) != "port = 8080\n" {
+		t.Errorf("pulled file = %q, %v", data, err)
+	}
+	for _, name := range []string{"large.copy", "missing.copy"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("failed pull left %s behind", name)
+		}
+	}
+}
+
+func TestTransferReadOnly(t *testing.T) {
+	readOnly := true
+	s := fakeSSHServer(t, WithPolicyRules(&PolicyRules{ReadOnly: &readOnly}))
+	dir := t.TempDir()
+	text, isError := callTool(t, s.handlePullFile, map[string]interface{}{
+		"target": "web1", "remote_path": "/etc/hostname", "local_path": filepath.Join(dir, "hostname"),
+	})
+	if !isError || !strings.Contains(text, "read-only") {
+		t.Errorf("pull_file with a read-only policy = %q (error %v)", text, isError)
+	}
+}
@@ -0,0 +1,525 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Trash settings
+const (
+	DEFAULT_TRASH_RETENTION = 7 * 24 * time.Hour // How long deleted files are kept before they are purged
+	TRASH_INFO_FILE         = "info.json"        // Metadata of a trash entry
+	TRASH_DATA_NAME         = "data"             // The deleted file or directory within a trash entry
+)
+
+// trashIDPattern matches trash entry IDs, e.g. "20240102-150405-1a2b3c4d"
+var trashIDPattern = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}-[0-9a-f]{8}$`)
+
+// TrashEntry is a file or directory rm moved to the trash
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // Absolute path it was deleted from
+	Dir       bool      `json:"dir,omitempty"`
+	Command   string    `json:"command"`
+	DeletedAt time.Time `json:"deletedAt"`
+	Tenant    string    `json:"tenant,omitempty"` // Tenant whose command deleted it; other tenants cannot list or restore it
+}
+
+// trash keeps deleted files, each in a directory of its own named by its
+// ID, holding the file as TRASH_DATA_NAME and its TrashEntry as
+// TRASH_INFO_FILE. Files are moved with rename(2), so the trash must be on
+// the same file system as the files deleted into it.
+type trash struct {
+	dir       string
+	retention time.Duration // Zero keeps deleted files until restored
+	mutex     sync.Mutex
+}
+
+// trashTarget is an rm operand resolved to a path
+type trashTarget struct {
+	name string // As the command named it, e.g. "build/a.o"
+	path string // Absolute
+}
+
+// WithTrash makes rm move what it deletes under the server's and the
+// projects' directories into a trash in dir, from which restore_file can
+// bring it back. Deleted files are purged after retention; zero keeps them.
+func WithTrash(dir string, retention time.Duration) Option {
+	return func(s *ShellServer) error {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if err := privateDir(dir); err != nil {
+			return fmt.Errorf("cannot create trash directory: %v", err)
+		}
+		s.trash = &trash{dir: dir, retention: retention}
+		s.trash.purge(time.Now())
+		return nil
+	}
+}
+
+// put moves path into the trash for a tenant
+func (t *trash) put(path string, command string, tenant string, deletedAt time.Time, suffix string) (TrashEntry, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	entry := TrashEntry{
+		ID:        deletedAt.UTC().Format("20060102-150405") + "-" + suffix,
+		Path:      path,
+		Dir:       info.IsDir(),
+		Command:   command,
+		DeletedAt: deletedAt,
+		Tenant:    tenant,
+	}
+
+	entryDir := filepath.Join(t.dir, entry.ID)
+	if err := os.Mkdir(entryDir, 0700); err != nil {
+		return TrashEntry{}, err
+	}
+	// An entry of plain values always marshals
+	data, _ := json.Marshal(entry)
+	if err := os.WriteFile(filepath.Join(entryDir, TRASH_INFO_FILE), data, 0600); err != nil {
+		os.RemoveAll(entryDir)
+		return TrashEntry{}, err
+	}
+	if err := os.Rename(path, filepath.Join(entryDir, TRASH_DATA_NAME)); err != nil {
+		os.RemoveAll(entryDir)
+		if errors.Is(err, syscall.EXDEV) {
+			return TrashEntry{}, fmt.Errorf("it is on another file system than the trash")
+		}
+		return TrashEntry{}, err
+	}
+	return entry, nil
+}
+
+// get reads a tenant's entry with an ID. Other tenants' entries are
+// reported as missing.
+func (t *trash) get(id string, tenant string) (TrashEntry, error) {
+	entry, err := t.read(id)
+	if err == nil && entry.Tenant != tenant {
+		return TrashEntry{}, fmt.Errorf("nothing in the trash has the ID '%s'", id)
+	}
+	return entry, err
+}
+
+// read reads the entry with an ID, whichever tenant it belongs to
+func (t *trash) read(id string) (TrashEntry, error) {
+	if !trashIDPattern.MatchString(id) {
+		return TrashEntry{}, fmt.Errorf("'%s' is not a trash ID", id)
+	}
+	data, err := os.ReadFile(filepath.Join(t.dir, id, TRASH_INFO_FILE))
+	if err != nil {
+		return TrashEntry{}, fmt.Errorf("nothing in the trash has the ID '%s'", id)
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return TrashEntry{}, fmt.Errorf("trash entry '%s' is damaged: %v", id, err)
+	}
+	return entry, nil
+}
+
+// list returns a tenant's entries in the trash, most recently deleted first
+func (t *trash) list(tenant string) ([]TrashEntry, error) {
+	all, err := t.all()
+	if err != nil {
+		return nil, err
+	}
+	var entries []TrashEntry
+	for _, entry := range all {
+		if entry.Tenant == tenant {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// all returns the entries of every tenant, most recently deleted first
+func (t *trash) all() ([]TrashEntry, error) {
+	dirs, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []TrashEntry
+	for _, dir := range dirs {
+		if entry, err := t.read(dir.Name()); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// restore moves a tenant's entry back to where it was deleted from, or to
+// destination if set, creating missing parent directories
+func (t *trash) restore(id string, tenant string, destination string) (TrashEntry, string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entry, err := t.get(id, tenant)
+	if err != nil {
+		return TrashEntry{}, "", err
+	}
+	if destination == "" {
+		destination = entry.Path
+	}
+	if _, err := os.Lstat(destination); err == nil {
+		return TrashEntry{}, "", fmt.Errorf("'%s' already exists; pass 'path' to restore elsewhere", destination)
+	}
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return TrashEntry{}, "", err
+	}
+	if err := os.Rename(filepath.Join(t.dir, id, TRASH_DATA_NAME), destination); err != nil {
+		return TrashEntry{}, "", err
+	}
+	os.RemoveAll(filepath.Join(t.dir, id))
+	return entry, destination, nil
+}
+
+// purge removes entries deleted more than the retention period before now
+// and returns how many
+func (t *trash) purge(now time.Time) int {
+	if t.retention <= 0 {
+		return 0
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entries, _ := t.all()
+	purged := 0
+	for _, entry := range entries {
+		if now.Sub(entry.DeletedAt) > t.retention {
+			if os.RemoveAll(filepath.Join(t.dir, entry.ID)) == nil {
+				purged++
+			}
+		}
+	}
+	return purged
+}
+
+// workRoots returns the directories the server works in: its own and the
+// projects'. rm deletes into the trash under them.
+func (s *ShellServer) workRoots() []string {
+	var roots []string
+	if dir, err := os.Getwd(); err == nil {
+		roots = append(roots, dir)
+	}
+	for _, p := range s.projects {
+		roots = append(roots, p.Dir)
+	}
+	return roots
+}
+
+// isUnder reports whether path is dir or inside it
+func isUnder(path string, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// trashApplies reports whether the server's own process may stand in for
+// rm. It moves files as the server's user, so not when commands run as
+// another user or through an executor of the embedding program.
+func (s *ShellServer) trashApplies() bool {
+	_, local := s.executor.(localExecutor)
+	return s.control.credential == nil && local
+}
+
+// trashStep moves what rm would delete under the server's or a project's
+// directory into the trash instead of running rm. So that nothing slips
+// past the trash, rm must then run on its own with operands that resolve
+// to paths without running a shell.
+func (s *ShellServer) trashStep(next ExecFunc) ExecFunc {
+	return func(ctx context.Context, req *ExecRequest) (CommandExecution, error) {
+		if s.trash == nil || req.Target != "" || req.Container != "" || !s.trashApplies() {
+			return next(ctx, req)
+		}
+		commands, err := ParseCommands(req.Command)
+		if err != nil {
+			return next(ctx, req)
+		}
+		removes := false
+		for _, parsed := range commands {
+			removes = removes || parsed.Name == "rm"
+		}
+		if !removes {
+			return next(ctx, req)
+		}
+		if len(commands) != 1 || len(commands[0].Redirects) > 0 {
+			return CommandExecution{}, &DeniedError{
+				Reason:  "rm is combined with other commands while the trash is enabled",
+				Message: s.message(MSG_TRASH_COMPOUND),
+				Details: map[string]interface{}{"rule": "trash"},
+			}
+		}
+
+		// Sessions keep their own working directory, so only absolute
+		// paths can be resolved for them
+		dir := req.Dir
+		if dir == "" && req.Session == "" {
+			dir, _ = os.Getwd()
+		}
+		flags, operands := splitOperands(commands[0].Args, nil)
+		var targets []trashTarget
+		for _, operand := range operands {
+			resolved, ok := resolveTrashOperand(operand, dir)
+			if !ok {
+				if operand == "" {
+					operand = "(expansion)"
+				}
+				return CommandExecution{}, &DeniedError{
+					Reason:  fmt.Sprintf("rm argument '%s' cannot be resolved for the trash", operand),
+					Message: s.message(MSG_TRASH_UNRESOLVED, operand),
+					Details: map[string]interface{}{"rule": "trash", "argument": operand},
+				}
+			}
+			targets = append(targets, resolved...)
+		}
+
+		// rm runs as usual outside the trash roots; a mix is refused so a
+		// single command is either recoverable or not
+		var inside, outside []trashTarget
+		for _, target := range targets {
+			under := false
+			for _, root := range s.workRoots() {
+				under = under || isUnder(target.path, root)
+			}
+			if under {
+				inside = append(inside, target)
+			} else {
+				outside = append(outside, target)
+			}
+		}
+		if len(inside) == 0 && len(targets) > 0 {
+			return next(ctx, req)
+		}
+		if len(outside) > 0 {
+			return CommandExecution{}, &DeniedError{
+				Reason:  fmt.Sprintf("rm mixes paths inside and outside the trash roots, e.g. '%s'", outside[0].name),
+				Message: s.message(MSG_TRASH_MIXED, outside[0].name),
+				Details: map[string]interface{}{"rule": "trash", "path": outside[0].path},
+			}
+		}
+		return s.deleteToTrash(req, s.tenant(ctx), flags, targets), nil
+	}
+}
+
+// resolveTrashOperand expands an rm operand to absolute paths, or returns
+// false if it depends on an expansion or, without dir, is relative
+func resolveTrashOperand(operand string, dir string) ([]trashTarget, bool) {
+	if operand == "" {
+		return nil, false
+	}
+	name := operand
+	if home, err := os.UserHomeDir(); err == nil && (operand == "~" || strings.HasPrefix(operand, "~/")) {
+		operand = home + operand[1:]
+	}
+	if !filepath.IsAbs(operand) && dir == "" {
+		return nil, false
+	}
+	absolute := func(p string) string {
+		if filepath.IsAbs(p) {
+			return filepath.Clean(p)
+		}
+		return filepath.Join(dir, p)
+	}
+
+	// Patterns that match nothing are passed on literally, as by the shell
+	if _, err := os.Lstat(absolute(operand)); err != nil && strings.ContainsAny(operand, "*?[{") {
+		expansion, err := expandGlob(operand, dir, MAX_GLOB_LIMIT, nil)
+		if err != nil || expansion.Truncated {
+			return nil, false
+		}
+		if len(expansion.Matches) > 0 {
+			targets := make([]trashTarget, len(expansion.Matches))
+			for i, match := range expansion.Matches {
+				targets[i] = trashTarget{name: match, path: absolute(match)}
+			}
+			return targets, true
+		}
+	}
+	return []trashTarget{{name: name, path: absolute(operand)}}, true
+}
+
+// deleteToTrash does what rm would with the given flags, moving each target
+// into the trash of a tenant instead of deleting it
+func (s *ShellServer) deleteToTrash(req *ExecRequest, tenant string, flags []string, targets []trashTarget) CommandExecution {
+	startTime := s.now()
+	recursive := hasShortFlag(flags, 'r') || hasShortFlag(flags, 'R') || containsString(flags, "--recursive")
+	force := hasShortFlag(flags, 'f') || containsString(flags, "--force")
+	emptyDirs := hasShortFlag(flags, 'd') || containsString(flags, "--dir")
+
+	var output []string
+	exitCode := 0
+	fail := func(format string, args ...interface{}) {
+		output = append(output, fmt.Sprintf(format, args...))
+		exitCode = 1
+	}
+	if len(targets) == 0 && !force {
+		fail("rm: missing operand")
+	}
+
+	moved := 0
+	for _, target := range targets {
+		if base := filepath.Base(target.name); base == "." || base == ".." {
+			fail("rm: refusing to remove '.' or '..' directory: skipping '%s'", target.name)
+			continue
+		}
+		if target.path == "/" {
+			fail("rm: it is dangerous to operate recursively on '/'")
+			continue
+		}
+		if isUnder(s.trash.dir, target.path) {
+			fail("rm: cannot remove '%s': it contains the trash", target.name)
+			continue
+		}
+
+		info, err := os.Lstat(target.path)
+		if err != nil {
+			if !force {
+				fail("rm: cannot remove '%s': No such file or directory", target.name)
+			}
+			continue
+		}
+		if info.IsDir() && !recursive {
+			if !emptyDirs {
+				fail("rm: cannot remove '%s': Is a directory", target.name)
+				continue
+			}
+			if entries, err := os.ReadDir(target.path); err != nil || len(entries) > 0 {
+				fail("rm: cannot remove '%s': Directory not empty", target.name)
+				continue
+			}
+		}
+
+		entry, err := s.trash.put(target.path, req.Command, tenant, s.now(), s.randomID(4))
+		if err != nil {
+			fail("rm: cannot move '%s' to the trash: %v", target.name, err)
+			continue
+		}
+		output = append(output, fmt.Sprintf("Moved '%s' to the trash as %s", target.name, entry.ID))
+		moved++
+	}
+	if moved > 0 {
+		note := "Restore with restore_file and the trash ID."
+		if s.trash.retention > 0 {
+			note = fmt.Sprintf("Restore with restore_file and the trash ID within %s.", s.trash.retention)
+		}
+		output = append(output, "", note)
+	}
+	s.trash.purge(s.now())
+
+	endTime := s.now()
+	return CommandExecution{
+		Command:     req.Command,
+		Original:    req.Original,
+		Shell:       req.Shell,
+		Session:     req.Session,
+		Project:     req.Project,
+		Output:      strings.Join(output, "\n"),
+		ExitCode:    exitCode,
+		StartTime:   startTime,
+		EndTime:     endTime,
+		ExecutionMs: endTime.Sub(startTime).Milliseconds(),
+	}
+}
+
+func (s *ShellServer) handleListTrash(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if s.trash == nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{mcp.NewTextContent(s.message(MSG_TRASH_DISABLED))},
+		}, nil
+	}
+
+	entries, err := s.trash.list(s.tenant(ctx))
+	if err != nil {
+		return errorResult(ToolError{Code: ERROR_EXECUTION_FAILED, Message: "Error: " + err.Error()}), nil
+	}
+	if len(entries) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{mcp.NewTextContent("The trash is empty.")},
+		}, nil
+	}
+
+	var result strings.Builder
+	fmt.Fprintf(&result, "Trash in %s (%d entries, most recent first):\n", s.trash.dir, len(entries))
+	for _, entry := range entries {
+		kind := "file"
+		if entry.Dir {
+			kind = "directory"
+		}
+		fmt.Fprintf(&result, "\n%s  %s (%s, deleted %s)\n   $ %s", entry.ID, entry.Path, kind, entry.DeletedAt.Format(time.RFC3339), entry.Command)
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(result.String())},
+	}, nil
+}
+
+func (s *ShellServer) handleRestoreFile(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	if s.trash == nil {
+		return errorResult(ToolError{Code: ERROR_INVALID_ARGUMENT, Message: s.message(MSG_TRASH_DISABLED)}), nil
+	}
+	id, _ := request.Params.Arguments["id"].(string)
+	destination, _ := request.Params.Arguments["path"].(string)
+	if destination != "" && !filepath.IsAbs(destination) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: 'path' must be an absolute path",
+			Details: map[string]interface{}{"argument": "path"},
+		}), nil
+	}
+	invalidID := func(err error) (*mcp.CallToolResult, error) {
+		return errorResult(ToolError{
+			Code:    ERROR_INVALID_ARGUMENT,
+			Message: "Error: " + err.Error(),
+			Details: map[string]interface{}{"argument": "id", "id": id},
+		}), nil
+	}
+
+	// Restoring writes a file, so where it goes is checked as any other
+	// write, including where it was deleted from
+	tenant := s.tenant(ctx)
+	s.trash.mutex.Lock()
+	entry, err := s.trash.get(id, tenant)
+	s.trash.mutex.Unlock()
+	if err != nil {
+		return invalidID(err)
+	}
+	if destination == "" {
+		destination = entry.Path
+	}
+	var check mcp.CallToolRequest
+	check.Params.Arguments = map[string]interface{}{"path": destination}
+	destination, toolError := s.writablePath(ctx, check, "path")
+	if toolError != nil {
+		return errorResult(*toolError), nil
+	}
+
+	entry, restored, err := s.trash.restore(id, tenant, destination)
+	if err != nil {
+		return invalidID(err)
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{mcp.NewTextContent(fmt.Sprintf("Restored %s to %s", entry.ID, restored))},
+	}, nil
+}
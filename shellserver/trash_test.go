@@ -0,0 +1,176 @@
+package shellserver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestTrash(t *testing.T) {
+	work, outside, trashDir := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "keep.txt", "build/x.o", "build/sub/y.o"} {
+		os.MkdirAll(filepath.Join(work, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(work, name), []byte(name), 0644)
+	}
+	os.WriteFile(filepath.Join(outside, "other"), nil, 0644)
+
+	s, err := NewShellServer(
+		WithAllowedCommands("rm,echo,cat"),
+		WithProjects([]Project{{Name: "app", Dir: work}}),
+		WithTrash(trashDir, time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	run := func(command string, session string) (CommandExecution, error) {
+		req := &ExecRequest{Command: command, Shell: "bash", Project: "app", Session: session}
+		if session == "" {
+			req.Dir = work
+		}
+		return s.exec(context.Background(), req)
+	}
+
+	tests := []struct {
+		command  string
+		session  string
+		exitCode int
+		output   string // Expected in the output
+		denied   bool
+		gone     []string
+	}{
+		{"rm *.log", "", 0, "Moved 'a.log' to the trash as ", false, []string{"a.log", "b.log"}},
+		{"rm build", "", 1, "rm: cannot remove 'build': Is a directory", false, nil},
+		{"rm -rf build missing", "", 0, "Moved 'build' to the trash", false, []string{"build"}},
+		{"rm missing", "", 1, "No such file or directory", false, nil},
+		{"rm -f", "", 0, "", false, nil},
+		{"rm keep.txt && echo done", "", 0, "", true, nil},
+		{"rm \"$FILE\"", "", 0, "", true, nil},
+		{"rm keep.txt " + filepath.Join(outside, "other"), "", 0, "", true, nil},
+		{"rm keep.txt", "s-1", 0, "", true, nil},
+		{"echo rm", "", 0, "rm", false, nil},
+	}
+
+	for _, test := range tests {
+		execution, err := run(test.command, test.session)
+		var denied *DeniedError
+		if test.denied {
+			if !errors.As(err, &denied) || denied.Details["rule"] != "trash" {
+				t.Errorf("%q = %v, want a trash denial", test.command, err)
+			}
+			continue
+		}
+		if err != nil || execution.ExitCode != test.exitCode || !strings.Contains(execution.Output, test.output) {
+			t.Errorf("%q = %q (exit %d, %v), want %q (exit %d)", test.command, execution.Output, execution.ExitCode, err, test.output, test.exitCode)
+		}
+		for _, name := range test.gone {
+			if _, err := os.Lstat(filepath.Join(work, name)); err == nil {
+				t.Errorf("%q left %s in place", test.command, name)
+			}
+		}
+	}
+
+	// Paths outside the trash roots are removed by rm itself
+	if execution, err := run("rm "+filepath.Join(outside, "other"), ""); err != nil || execution.ExitCode != 0 {
+		t.Errorf("rm outside the roots = %+v, %v", execution, err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "other")); err == nil {
+		t.Errorf("rm outside the roots left the file in place")
+	}
+
+	entries, err := s.trash.list("")
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("trash has %d entries (%v), want 3", len(entries), err)
+	}
+
+	// Restoring brings the file back once; an existing path is not overwritten
+	var id string
+	for _, entry := range entries {
+		if entry.Path == filepath.Join(work, "build") {
+			id = entry.ID
+		}
+	}
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"id": id}
+	result, _ := s.handleRestoreFile(context.Background(), request)
+	if result.IsError {
+		t.Fatalf("restore_file failed: %v", result.Content)
+	}
+	if data, err := os.ReadFile(filepath.Join(work, "build/sub/y.o")); err != nil || string(data) != "build/sub/y.o" {
+		t.Errorf("restored file = %q, %v", data, err)
+	}
+	if result, _ := s.handleRestoreFile(context.Background(), request); !result.IsError {
+		t.Errorf("restoring %s twice succeeded", id)
+	}
+	request.Params.Arguments = map[string]interface{}{"id": "../../etc"}
+	if result, _ := s.handleRestoreFile(context.Background(), request); !result.IsError {
+		t.Errorf("restoring a path as an ID succeeded")
+	}
+
+	// Restoring writes a file, which must go inside the work roots
+	for _, entry := range entries {
+		if entry.ID == id {
+			continue
+		}
+		request.Params.Arguments = map[string]interface{}{"id": entry.ID, "path": filepath.Join(outside, "restored")}
+		if text, isError := callTool(t, s.handleRestoreFile, request.Params.Arguments); !isError || !strings.Contains(text, "outside") {
+			t.Errorf("restoring %s outside the work roots = %q, want it refused", entry.Path, text)
+		}
+		if _, err := os.Lstat(filepath.Join(outside, "restored")); err == nil {
+			t.Errorf("restoring %s outside the work roots created the file", entry.Path)
+		}
+		break
+	}
+
+	result, _ = s.handleListTrash(context.Background(), mcp.CallToolRequest{})
+	text := result.Content[0].(mcp.TextContent).Text
+	if !regexp.MustCompile(`(?s)2 entries.*a\.log`).MatchString(text) || strings.Contains(text, "build") {
+		t.Errorf("list_trash = %q", text)
+	}
+
+	// Entries past the retention period are purged
+	s.trash.purge(time.Now().Add(2 * time.Hour))
+	if entries, _ := s.trash.list(""); len(entries) != 0 {
+		t.Errorf("trash has %d entries after the purge, want 0", len(entries))
+	}
+}
+
+func TestTrashSkippedForOtherUsersAndExecutors(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+		want string
+	}{
+		{"run as", WithRunAs(strconv.Itoa(os.Getuid())), ""},
+		{"executor", WithExecutor(&fakeExecutor{}), "&& rm a.log"},
+	}
+
+	for _, tt := range tests {
+		work := t.TempDir()
+		os.WriteFile(filepath.Join(work, "a.log"), nil, 0o644)
+		s, err := NewShellServer(
+			WithAllowedCommands("rm"),
+			WithProjects([]Project{{Name: "app", Dir: work}}),
+			WithTrash(t.TempDir(), time.Hour),
+			tt.opt,
+		)
+		if err != nil {
+			t.Logf("%s: skipped: %v", tt.name, err)
+			continue
+		}
+		execution, err := s.exec(context.Background(), &ExecRequest{Command: "rm a.log", Shell: "bash", Project: "app", Dir: work})
+		if err != nil || strings.Contains(execution.Output, "to the trash") || !strings.Contains(execution.Output, tt.want) {
+			t.Errorf("%s: rm = %q, %v, want it run by the executor", tt.name, execution.Output, err)
+		}
+		if entries, _ := s.trash.list(""); len(entries) != 0 {
+			t.Errorf("%s: rm moved %d files to the trash", tt.name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Tripwire declares commands no legitimate agent has reason to run, such as
+// reading cloud credentials or uploading to a paste site. A command that
+// trips one is refused even if the allowlist allows it, a tripwire event
+// is sent to notifiers, and with Lockdown the server refuses every agent
+// tool call until an operator ends maintenance mode.
+type Tripwire struct {
+	Name     string   `json:"name"`
+	Commands []string `json:"commands,omitempty"` // Command names with arguments they use, as in deny rules, e.g. "aws configure export-credentials"
+	Paths    []string `json:"paths,omitempty"`    // Paths an argument or redirection refers to, as in denyPaths, e.g. ".aws/credentials"
+	Patterns []string `json:"patterns,omitempty"` // Regular expressions matched against the whole command line, e.g. "(curl|wget) .*pastebin\\.com"
+	Lockdown bool     `json:"lockdown,omitempty"` // Start maintenance mode when tripped
+}
+
+// tripwire is a Tripwire with its patterns compiled
+type tripwire struct {
+	Tripwire
+	patterns []*regexp.Regexp
+}
+
+// check rejects a tripwire that would match nothing
+func (r Tripwire) check() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("tripwire without a name")
+	}
+	if len(r.Commands) == 0 && len(r.Paths) == 0 && len(r.Patterns) == 0 {
+		return fmt.Errorf("tripwire '%s' has no commands, paths or patterns", r.Name)
+	}
+	for _, command := range r.Commands {
+		if len(strings.Fields(command)) == 0 {
+			return fmt.Errorf("tripwire '%s' has an empty command", r.Name)
+		}
+	}
+	for _, file := range r.Paths {
+		if len(pathComponents(file)) == 0 {
+			return fmt.Errorf("tripwire '%s': path '%s' names no file", r.Name, file)
+		}
+	}
+	for _, pattern := range r.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("tripwire '%s': invalid pattern '%s': %v", r.Name, pattern, err)
+		}
+	}
+	return nil
+}
+
+// compile checks a tripwire and compiles its patterns
+func (r Tripwire) compile() (tripwire, error) {
+	if err := r.check(); err != nil {
+		return tripwire{}, err
+	}
+	compiled := tripwire{Tripwire: r}
+	for _, pattern := range r.Patterns {
+		compiled.patterns = append(compiled.patterns, regexp.MustCompile(pattern))
+	}
+	return compiled, nil
+}
+
+// matches reports whether a command line matches one of the tripwire's
+// patterns, or one of the commands it runs one of its commands or paths.
+// Patterns also match command lines that cannot be parsed.
+func (r tripwire) matches(command string, commands []ParsedCommand) bool {
+	for _, pattern := range r.patterns {
+		if pattern.MatchString(command) {
+			return true
+		}
+	}
+	return matchesCommandsOrFiles(commands, r.Commands, r.Paths)
+}
+
+// trippedBy returns the first tripwire a command trips, or nil
+func (s *ShellServer) trippedBy(command string) *tripwire {
+	if len(s.tripwires) == 0 {
+		return nil
+	}
+	commands, _ := ParseCommands(command)
+	for i := range s.tripwires {
+		if s.tripwires[i].matches(command, commands) {
+			return &s.tripwires[i]
+		}
+	}
+	return nil
+}
+
+// pathTripwire returns the first tripwire one of whose paths a file path
+// refers to, or nil
+func (s *ShellServer) pathTripwire(paths ...string) *tripwire {
+	for i := range s.tripwires {
+		for _, file := range s.tripwires[i].Paths {
+			for _, p := range paths {
+				if refersToPath(p, pathComponents(file)) {
+					return &s.tripwires[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tripwireDenial refuses a request that trips a tripwire, notifies the
+// operator and, for a lockdown tripwire, starts maintenance mode. It
+// returns nil if the request trips none.
+func (s *ShellServer) tripwireDenial(req *ExecRequest) *DeniedError {
+	tripped := s.trippedBy(req.Command)
+	if tripped == nil {
+		return nil
+	}
+	return s.trip(tripped, req)
+}
+
+// fileTripwireDenial refuses a file tool's access to paths that trip a
+// tripwire, as tripwireDenial refuses commands. The access is reported as a
+// command of the tool's name and the path argument.
+func (s *ShellServer) fileTripwireDenial(ctx context.Context, tool string, name string, paths ...string) *ToolError {
+	tripped := s.pathTripwire(paths...)
+	if tripped == nil {
+		return nil
+	}
+	req := &ExecRequest{Command: strings.TrimSpace(tool + " " + shellQuote(name)), Client: s.clientIdentity(ctx)}
+	toolError := s.deniedToolError(req, s.trip(tripped, req))
+	return &toolError
+}
+
+// trip reports a request that tripped a tripwire and returns its refusal
+func (s *ShellServer) trip(tripped *tripwire, req *ExecRequest) *DeniedError {
+	reason := fmt.Sprintf("tripwire '%s'", tripped.Name)
+	s.logger.Printf("Command tripped %s: %s", reason, escapeHidden(req.Command))
+	s.emitEvent(EVENT_TRIPWIRE, CommandExecution{
+		Command:   req.Command,
+		Original:  req.Original,
+		Reason:    req.Reason,
+		Shell:     req.Shell,
+		Session:   req.Session,
+		Project:   req.Project,
+		Target:    req.Target,
+		Container: req.Container,
+		Client:    req.Client,
+		ErrorCode: ERROR_POLICY_DENIED,
+		StartTime: s.now(),
+	}, reason)
+	if tripped.Lockdown {
+		s.maintenance.Store(&maintenance{message: s.message(MSG_TRIPWIRE_LOCKDOWN)})
+		s.logger.Printf("Started maintenance mode after %s; end it once the server was reviewed", reason)
+	}
+	// The agent is not told which tripwire, so it cannot learn to avoid it
+	return &DeniedError{
+		Reason:  reason,
+		Message: s.message(MSG_TRIPWIRE),
+		Code:    ERROR_POLICY_DENIED,
+		Details: map[string]interface{}{"rule": "tripwire"},
+	}
+}
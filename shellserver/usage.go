@@ -0,0 +1,64 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// USAGE_URI is the URI of the resource usage attached to execution results
+const USAGE_URI = "shell://usage.json"
+
+// ResourceUsage is what a finished command used, from the rusage of its
+// shell and the descendants it waited for. Commands do not run in a
+// cgroup of their own, so anything that escaped the process tree is missed.
+type ResourceUsage struct {
+	UserCPUMs   int64 `json:"userCpuMs"`             // CPU time in user mode
+	SystemCPUMs int64 `json:"systemCpuMs"`           // CPU time in the kernel
+	MaxRSSBytes int64 `json:"maxRssBytes,omitempty"` // Largest resident set of any one process
+	ReadBytes   int64 `json:"readBytes,omitempty"`   // Read from storage, not counting the page cache (Linux only)
+	WriteBytes  int64 `json:"writeBytes,omitempty"`  // Written to storage (Linux only)
+}
+
+// String summarizes the usage for execution results
+func (u *ResourceUsage) String() string {
+	summary := fmt.Sprintf("user %d ms, sys %d ms", u.UserCPUMs, u.SystemCPUMs)
+	if u.MaxRSSBytes > 0 {
+		summary += ", max RSS " + formatByteSize(u.MaxRSSBytes)
+	}
+	if u.ReadBytes > 0 || u.WriteBytes > 0 {
+		summary += ", read " + formatByteSize(u.ReadBytes) + ", written " + formatByteSize(u.WriteBytes)
+	}
+	return summary
+}
+
+// formatByteSize formats a byte count with a binary unit, e.g. "12.5 MiB"
+func formatByteSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exponent := float64(bytes)/unit, 0
+	for value >= unit && exponent < 3 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exponent])
+}
+
+// usageResource returns resource usage as embedded JSON content
+func usageResource(usage *ResourceUsage) mcp.EmbeddedResource {
+	// A struct of plain values always marshals
+	data, _ := json.Marshal(usage)
+	resource := mcp.EmbeddedResource{
+		Type: "resource",
+		Resource: mcp.TextResourceContents{
+			URI:      USAGE_URI,
+			MIMEType: JSON_MIME_TYPE,
+			Text:     string(data),
+		},
+	}
+	annotate(&resource.Annotated, PRIORITY_OUTPUT, mcp.RoleAssistant)
+	return resource
+}
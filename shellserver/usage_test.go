@@ -0,0 +1,55 @@
+package shellserver
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestResourceUsageString(t *testing.T) {
+	tests := []struct {
+		usage ResourceUsage
+		want  string
+	}{
+		{ResourceUsage{UserCPUMs: 80, SystemCPUMs: 20}, "user 80 ms, sys 20 ms"},
+		{ResourceUsage{UserCPUMs: 1, MaxRSSBytes: 12 * 1024 * 1024}, "user 1 ms, sys 0 ms, max RSS 12.0 MiB"},
+		{ResourceUsage{MaxRSSBytes: 512, WriteBytes: 1536}, "user 0 ms, sys 0 ms, max RSS 512 B, read 0 B, written 1.5 KiB"},
+		{ResourceUsage{ReadBytes: 3 << 30}, "user 0 ms, sys 0 ms, read 3.0 GiB, written 0 B"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.usage.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.usage, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteCommandUsage(t *testing.T) {
+	s, err := NewShellServer(WithAllowedCommands("echo"))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"command": "echo hi"}
+	result, _ := s.handleExecuteCommand(context.Background(), request)
+	found := false
+	for _, content := range result.Content {
+		if resource, ok := content.(mcp.EmbeddedResource); ok && resource.Resource.(mcp.TextResourceContents).URI == USAGE_URI {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("execute_command result has no %s resource", USAGE_URI)
+	}
+
+	history := recentHistory(t, s, 1)
+	if len(history) != 1 || history[0].Usage == nil {
+		t.Fatalf("history = %+v, want usage recorded", history)
+	}
+	if runtime.GOOS != "windows" && history[0].Usage.MaxRSSBytes == 0 {
+		t.Errorf("usage = %+v, want max RSS", history[0].Usage)
+	}
+}
@@ -0,0 +1,169 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Request limits by default. Every tool call is checked against its limits
+// before anything else looks at it, so a misbehaving agent that sends a
+// megabyte command line or thousands of arguments is refused with
+// REQUEST_TOO_LARGE instead of tying up the parser, the authorizer and the
+// policy.
+const (
+	DEFAULT_MAX_COMMAND_LENGTH = 64 * 1024       // Bytes of a command as written
+	DEFAULT_MAX_COMMAND_ARGS   = 4096            // Words of a command, across its simple commands
+	DEFAULT_MAX_PAYLOAD_SIZE   = 4 * 1024 * 1024 // Bytes of a tool call's arguments as JSON
+)
+
+// RequestLimits bound what a tool call may carry. Zero fields keep their
+// defaults.
+type RequestLimits struct {
+	CommandLength int // Bytes of the command argument
+	CommandArgs   int // Words of the command, e.g. 3 for 'ls -l | wc'
+	StdinSize     int // Bytes of input stdin_resource gives a command
+	PayloadSize   int // Bytes of all arguments of a call, as JSON
+}
+
+// defaultRequestLimits are the limits without WithRequestLimits
+var defaultRequestLimits = RequestLimits{
+	CommandLength: DEFAULT_MAX_COMMAND_LENGTH,
+	CommandArgs:   DEFAULT_MAX_COMMAND_ARGS,
+	StdinSize:     MAX_STDIN_SIZE,
+	PayloadSize:   DEFAULT_MAX_PAYLOAD_SIZE,
+}
+
+// ParseRequestLimits parses a --request-limits spec of comma-separated
+// key=value pairs, e.g. "command=16K,args=1024,stdin=1M,payload=2M"
+func ParseRequestLimits(spec string) (RequestLimits, error) {
+	var limits RequestLimits
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return RequestLimits{}, fmt.Errorf("expected key=value, got '%s'", pair)
+		}
+
+		var err error
+		var limit uint64
+		switch key {
+		case "command":
+			limit, err = parseByteSize(value)
+			limits.CommandLength = int(limit)
+		case "args":
+			limit, err = parseLimitCount(value)
+			limits.CommandArgs = int(limit)
+		case "stdin":
+			limit, err = parseByteSize(value)
+			limits.StdinSize = int(limit)
+		case "payload":
+			limit, err = parseByteSize(value)
+			limits.PayloadSize = int(limit)
+		default:
+			return RequestLimits{}, fmt.Errorf("unknown limit '%s': expected command, args, stdin or payload", key)
+		}
+		if err != nil {
+			return RequestLimits{}, fmt.Errorf("invalid %s limit '%s': %v", key, value, err)
+		}
+	}
+	return limits, nil
+}
+
+// WithRequestLimits sets the size limits of tool calls
+func WithRequestLimits(limits RequestLimits) Option {
+	return func(s *ShellServer) error {
+		if limits.CommandLength < 0 || limits.CommandArgs < 0 || limits.StdinSize < 0 || limits.PayloadSize < 0 {
+			return fmt.Errorf("request limits must not be negative")
+		}
+		if limits.CommandLength > 0 {
+			s.requestLimits.CommandLength = limits.CommandLength
+		}
+		if limits.CommandArgs > 0 {
+			s.requestLimits.CommandArgs = limits.CommandArgs
+		}
+		if limits.StdinSize > 0 {
+			s.requestLimits.StdinSize = limits.StdinSize
+		}
+		if limits.PayloadSize > 0 {
+			s.requestLimits.PayloadSize = limits.PayloadSize
+		}
+		return nil
+	}
+}
+
+// shellArguments are the free-text arguments that reach a shell or an
+// interpreter, which validateCall checks as it checks commands. Shell
+// arguments have their words counted as the parser splits them, the others
+// at whitespace.
+var shellArguments = []struct {
+	name  string
+	shell bool
+}{
+	{"command", true},
+	{"script", true}, // validate_syntax and lint_script
+	{"code", false},  // eval_in_repl
+	{"args", false},  // start_repl
+}
+
+// validateCall checks a tool call against the request limits, and its
+// shell arguments for characters that could disguise them
+func (s *ShellServer) validateCall(name string, request mcp.CallToolRequest) *ToolError {
+	limits := s.requestLimits
+	tooLarge := func(limit string, argument string, size int, max int, unit string) *ToolError {
+		details := map[string]interface{}{"limit": limit, "size": size, "max": max, "tool": name}
+		if argument != "" {
+			details["argument"] = argument
+		}
+		return &ToolError{
+			Code:    ERROR_REQUEST_TOO_LARGE,
+			Message: s.message(MSG_REQUEST_TOO_LARGE, limit, size, unit, max),
+			Details: details,
+			Hint:    s.message(MSG_SPLIT_REQUEST),
+		}
+	}
+
+	// The arguments as decoded, marshaled again, before any field is looked
+	// at; this is close to but not exactly the size of the request as sent
+	payload, err := json.Marshal(request.Params.Arguments)
+	if err != nil {
+		return &ToolError{Code: ERROR_INVALID_ARGUMENT, Message: fmt.Sprintf("Error: invalid arguments: %v", err)}
+	}
+	if len(payload) > limits.PayloadSize {
+		return tooLarge("payload", "", len(payload), limits.PayloadSize, "bytes")
+	}
+
+	for _, argument := range shellArguments {
+		text, ok := request.Params.Arguments[argument.name].(string)
+		if !ok {
+			continue
+		}
+		if len(text) > limits.CommandLength {
+			return tooLarge("command length", argument.name, len(text), limits.CommandLength, "bytes")
+		}
+		if toolError := s.checkHiddenCharacters(name, argument.name, text); toolError != nil {
+			return toolError
+		}
+		words := len(strings.Fields(text))
+		if argument.shell {
+			// Commands that do not parse are left to the policy to refuse
+			parsed, err := ParseCommands(text)
+			if err != nil {
+				continue
+			}
+			words = 0
+			for _, simple := range parsed {
+				words += 1 + len(simple.Args)
+			}
+		}
+		if words > limits.CommandArgs {
+			return tooLarge("argument count", argument.name, words, limits.CommandArgs, "words")
+		}
+	}
+	return nil
+}
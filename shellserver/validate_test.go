@@ -0,0 +1,132 @@
+package shellserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestParseRequestLimits(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    RequestLimits
+		wantErr bool
+	}{
+		{"", RequestLimits{}, false},
+		{"command=16K,args=1024", RequestLimits{CommandLength: 16 * 1024, CommandArgs: 1024}, false},
+		{"stdin=1M, payload=2M", RequestLimits{StdinSize: 1024 * 1024, PayloadSize: 2 * 1024 * 1024}, false},
+		{"command", RequestLimits{}, true},
+		{"args=many", RequestLimits{}, true},
+		{"body=1M", RequestLimits{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRequestLimits(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRequestLimits(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRequestLimits(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	if _, err := NewShellServer(WithRequestLimits(RequestLimits{CommandArgs: -1})); err == nil {
+		t.Errorf("NewShellServer accepted a negative request limit")
+	}
+	s, err := NewShellServer(WithRequestLimits(RequestLimits{CommandArgs: 10}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	if s.requestLimits.CommandArgs != 10 || s.requestLimits.CommandLength != DEFAULT_MAX_COMMAND_LENGTH {
+		t.Errorf("request limits = %+v, want args=10 and the other defaults", s.requestLimits)
+	}
+}
+
+func TestValidateCall(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	os.WriteFile(input, []byte(strings.Repeat("line\n", 100)), 0o644)
+
+	s, err := NewShellServer(
+		WithAllowedCommands("echo,cat,wc"),
+		WithRequestLimits(RequestLimits{CommandLength: 100, CommandArgs: 8, StdinSize: 64, PayloadSize: 400}),
+		WithProjects([]Project{{Name: "app", Dir: dir}}),
+	)
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	execute := s.authorized("execute_command", s.handleExecuteCommand)
+
+	tests := []struct {
+		args     map[string]interface{}
+		want     string
+		wantCode string
+	}{
+		{map[string]interface{}{"command": "echo hello | wc -c"}, "6", ""},
+		{map[string]interface{}{"command": "echo " + strings.Repeat("a", 100)}, "its command length is 105 bytes, over the limit of 100", ERROR_REQUEST_TOO_LARGE},
+		{map[string]interface{}{"command": "echo a b c d | wc -l -c -w"}, "its argument count is 9 words, over the limit of 8", ERROR_REQUEST_TOO_LARGE},
+		{map[string]interface{}{"command": "echo hi", "description": strings.Repeat("x", 400)}, "its payload is", ERROR_REQUEST_TOO_LARGE},
+		{map[string]interface{}{"command": "wc -l", "stdin_resource": "file://" + input}, "larger than the 64 byte input limit", ERROR_FILE_TOO_LARGE},
+		// Commands that do not parse are left to the policy
+		{map[string]interface{}{"command": "echo 'open"}, "", ERROR_POLICY_DENIED},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		result, _ := execute(context.Background(), request)
+		text := result.Content[0].(mcp.TextContent).Text
+		code := ""
+		if toolError := resultError(t, result); toolError != nil {
+			code = toolError.Code
+			if code == ERROR_REQUEST_TOO_LARGE && (toolError.Details["tool"] != "execute_command" || toolError.Hint == "") {
+				t.Errorf("execute_command(%v) error = %+v, want the tool and a hint", tt.args, toolError)
+			}
+		}
+		if !strings.Contains(text, tt.want) || code != tt.wantCode {
+			t.Errorf("execute_command(%v) = %q (code %q), want %q (code %q)", tt.args, text, code, tt.want, tt.wantCode)
+		}
+	}
+}
+
+func TestValidateShellArguments(t *testing.T) {
+	s, err := NewShellServer(WithRequestLimits(RequestLimits{CommandLength: 100, CommandArgs: 8}))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+
+	tests := []struct {
+		tool     string
+		args     map[string]interface{}
+		wantCode string
+		argument string
+	}{
+		{"eval_in_repl", map[string]interface{}{"session_id": "repl-1", "code": "print('hi')"}, "", ""},
+		{"eval_in_repl", map[string]interface{}{"session_id": "repl-1", "code": "print('safe')\u202e#)'live'(tnirp"}, ERROR_INVALID_ARGUMENT, "code"},
+		{"eval_in_repl", map[string]interface{}{"session_id": "repl-1", "code": "x = 1\u200b"}, ERROR_INVALID_ARGUMENT, "code"},
+		{"eval_in_repl", map[string]interface{}{"session_id": "repl-1", "code": strings.Repeat("1+", 60) + "1"}, ERROR_REQUEST_TOO_LARGE, "code"},
+		{"eval_in_repl", map[string]interface{}{"session_id": "repl-1", "code": "a b c d e f g h i"}, ERROR_REQUEST_TOO_LARGE, "code"},
+		{"validate_syntax", map[string]interface{}{"script": "echo ok\u202e"}, ERROR_INVALID_ARGUMENT, "script"},
+		{"validate_syntax", map[string]interface{}{"script": "echo a b c d | wc -l -c -w"}, ERROR_REQUEST_TOO_LARGE, "script"},
+		{"start_repl", map[string]interface{}{"interpreter": "psql", "args": "postgres://db\u2066"}, ERROR_INVALID_ARGUMENT, "args"},
+	}
+
+	for _, tt := range tests {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = tt.args
+		toolError := s.validateCall(tt.tool, request)
+		code, argument := "", ""
+		if toolError != nil {
+			code = toolError.Code
+			argument, _ = toolError.Details["argument"].(string)
+		}
+		if code != tt.wantCode || argument != tt.argument {
+			t.Errorf("validateCall(%s, %q) = %+v, want code %q for argument %q", tt.tool, tt.args, toolError, tt.wantCode, tt.argument)
+		}
+	}
+}
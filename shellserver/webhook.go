@@ -0,0 +1,151 @@
+package shellserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Command event types delivered to notifiers
+const (
+	EVENT_START    = "start"    // A command is about to run
+	EVENT_FINISH   = "finish"   // A command ran to completion (any exit code)
+	EVENT_DENIAL   = "denial"   // A command was refused by policy
+	EVENT_TIMEOUT  = "timeout"  // A command was killed by the timeout
+	EVENT_TRIPWIRE = "tripwire" // A command tripped a tripwire; a denial event follows
+	EVENT_ANOMALY  = "anomaly"  // Commands crossed an anomaly threshold
+)
+
+// Webhook delivery settings
+const (
+	WEBHOOK_TIMEOUT    = 5 * time.Second // Per-request timeout
+	WEBHOOK_QUEUE_SIZE = 100             // Events buffered before new ones are dropped
+	WEBHOOK_SIGNATURE  = "X-MCP-Shell-Signature"
+	WEBHOOK_EVENT      = "X-MCP-Shell-Event"
+)
+
+// allEvents lists every event type in delivery order
+var allEvents = []string{EVENT_START, EVENT_FINISH, EVENT_DENIAL, EVENT_TIMEOUT, EVENT_TRIPWIRE, EVENT_ANOMALY}
+
+// CommandEvent is the JSON payload posted to webhooks and written to event files
+type CommandEvent struct {
+	Event     string           `json:"event"`
+	Timestamp time.Time        `json:"timestamp"`
+	Execution CommandExecution `json:"execution"`
+	Reason    string           `json:"reason,omitempty"` // Why a command was denied
+	Shadow    *ShadowDecision  `json:"shadow,omitempty"` // What the shadow policy would have decided, if one is configured
+}
+
+// webhook posts command events to a URL from a single background worker so
+// slow endpoints never block command execution
+type webhook struct {
+	url    string
+	secret string
+	queue  chan CommandEvent
+	client *http.Client
+}
+
+// newWebhook validates the configuration and starts the delivery worker
+func newWebhook(url string, secret string) (*webhook, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("webhook URL must start with http:// or https://")
+	}
+
+	w := &webhook{
+		url:    url,
+		secret: secret,
+		queue:  make(chan CommandEvent, WEBHOOK_QUEUE_SIZE),
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+	}
+	go w.run()
+	return w, nil
+}
+
+// parseEventFilter parses a comma-separated event list; empty means all events
+func parseEventFilter(events string) (map[string]bool, error) {
+	filter := make(map[string]bool)
+	if strings.TrimSpace(events) == "" {
+		for _, event := range allEvents {
+			filter[event] = true
+		}
+		return filter, nil
+	}
+
+	for _, event := range strings.Split(events, ",") {
+		event = strings.TrimSpace(event)
+		valid := false
+		for _, known := range allEvents {
+			if event == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown event '%s', expected one of: %s", event, strings.Join(allEvents, ", "))
+		}
+		filter[event] = true
+	}
+	return filter, nil
+}
+
+// Notify queues an event for delivery, dropping it if the queue is full
+func (w *webhook) Notify(event CommandEvent) error {
+	select {
+	case w.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue full, dropping event for '%s'", event.Execution.Command)
+	}
+}
+
+// run delivers queued events in order
+func (w *webhook) run() {
+	for event := range w.queue {
+		if err := w.deliver(event); err != nil {
+			log.Printf("Webhook delivery failed for %s event: %v", event.Event, err)
+		}
+	}
+}
+
+// deliver posts one event, signing the body when a secret is configured
+func (w *webhook) deliver(event CommandEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_EVENT, event.Event)
+	if w.secret != "" {
+		req.Header.Set(WEBHOOK_SIGNATURE, signPayload(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// signPayload returns the HMAC-SHA256 signature of body in "sha256=<hex>" form
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
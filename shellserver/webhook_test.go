@@ -0,0 +1,66 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseEventFilter(t *testing.T) {
+	filter, err := parseEventFilter("")
+	if err != nil || len(filter) != len(allEvents) {
+		t.Errorf("parseEventFilter(\"\") = %v, %v, want all events", filter, err)
+	}
+
+	filter, err = parseEventFilter("denial, timeout")
+	if err != nil || !filter[EVENT_DENIAL] || !filter[EVENT_TIMEOUT] || filter[EVENT_START] {
+		t.Errorf("parseEventFilter(\"denial, timeout\") = %v, %v", filter, err)
+	}
+
+	if _, err := parseEventFilter("start,explode"); err == nil {
+		t.Errorf("parseEventFilter should reject unknown events")
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	received := make(chan CommandEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get(WEBHOOK_SIGNATURE), signPayload("s3cret", body); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		var event CommandEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		received <- event
+	}))
+	defer srv.Close()
+
+	hook, err := ParseNotifier("webhook:"+srv.URL+" denial", "s3cret")
+	if err != nil {
+		t.Fatalf("ParseNotifier failed: %v", err)
+	}
+
+	s := &ShellServer{notifiers: []Notifier{hook}}
+	s.emitEvent(EVENT_START, CommandExecution{Command: "ls"}, "")
+	s.emitEvent(EVENT_DENIAL, CommandExecution{Command: "rm -rf /"}, "not allowed")
+
+	select {
+	case event := <-received:
+		if event.Event != EVENT_DENIAL || event.Execution.Command != "rm -rf /" || event.Reason != "not allowed" {
+			t.Errorf("received %+v, want the denial event", event)
+		}
+	case <-time.After(WEBHOOK_TIMEOUT):
+		t.Fatal("webhook was not called")
+	}
+
+	select {
+	case event := <-received:
+		t.Errorf("received filtered-out event %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
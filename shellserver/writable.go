@@ -0,0 +1,134 @@
+package shellserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// In a container whose root file system is read-only, the server writes
+// only where it is told to: the history and notifier files, the trash,
+// recording and snapshot directories, and a temporary directory. The
+// temporary directory is the first writable one of $TMPDIR (or /tmp),
+// /dev/shm and /run/user/<uid>, unless WithTempDir names one. Every path is
+// checked at startup, so a misconfigured container fails right away with an
+// error naming the path instead of when a command first needs it.
+
+// WithTempDir sets the directory the server and its commands create
+// temporary files in, through TMPDIR, instead of the first writable one
+// found
+func WithTempDir(dir string) Option {
+	return func(s *ShellServer) error {
+		if err := probeWritable(dir); err != nil {
+			return fmt.Errorf("temporary directory: %v", err)
+		}
+		s.tempDir = dir
+		return nil
+	}
+}
+
+// tempDirCandidates lists where a temporary directory is looked for
+func tempDirCandidates() []string {
+	candidates := []string{os.TempDir()}
+	if runtime.GOOS != "windows" {
+		candidates = append(candidates, "/dev/shm", fmt.Sprintf("/run/user/%d", os.Getuid()))
+	}
+	return candidates
+}
+
+// findTempDir returns the first writable temporary directory
+func findTempDir() (string, error) {
+	var failures []string
+	for _, dir := range tempDirCandidates() {
+		err := probeWritable(dir)
+		if err == nil {
+			return dir, nil
+		}
+		failures = append(failures, err.Error())
+	}
+	return "", fmt.Errorf("no writable temporary directory (%s); mount a tmpfs at %s, e.g. with 'docker run --tmpfs %s', or pass --temp-dir",
+		strings.Join(failures, "; "), os.TempDir(), os.TempDir())
+}
+
+// probeWritable checks that files can be created in dir
+func probeWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".mcp-shell-probe-*")
+	if err != nil {
+		return writableError(err)
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return nil
+}
+
+// privateDir creates dir, readable by the owner only, and checks that files
+// can be created in it
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return writableError(err)
+	}
+	return probeWritable(dir)
+}
+
+// checkWritableFile checks that the file at path can be written, or created
+// if it does not exist
+func checkWritableFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return probeWritable(filepath.Dir(path))
+	}
+	if err != nil {
+		return writableError(err)
+	}
+	return file.Close()
+}
+
+// checkWritablePaths finds the temporary directory, unless one is set, and
+// checks that the server can write where it is told to
+func (s *ShellServer) checkWritablePaths() error {
+	if s.tempDir == "" {
+		dir, err := findTempDir()
+		if err != nil {
+			return err
+		}
+		s.tempDir = dir
+	}
+	if s.tempDir != os.TempDir() {
+		s.control.env = append(s.control.env, "TMPDIR="+s.tempDir)
+	}
+	if s.recordDir != "" {
+		if err := privateDir(s.recordDir); err != nil {
+			return fmt.Errorf("cannot create recording directory: %v", err)
+		}
+	}
+	if s.policyEdits != "" {
+		if err := checkWritableFile(s.policyEdits); err != nil {
+			return fmt.Errorf("cannot write policy edits: %v", err)
+		}
+	}
+	if s.targets != nil {
+		pool, err := newSSHPool(s.targets, s.tempDir)
+		if err != nil {
+			return err
+		}
+		s.sshPool = pool
+	}
+	return nil
+}
+
+// writableError adds how to fix the errors a read-only or foreign file
+// system causes
+func writableError(err error) error {
+	switch {
+	case errors.Is(err, syscall.EROFS):
+		return fmt.Errorf("%v; the file system is read-only, so use a path on a writable volume or tmpfs", err)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("%v; the server's user cannot write there, so use another path or change its permissions", err)
+	}
+	return err
+}
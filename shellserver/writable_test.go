@@ -0,0 +1,84 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWithTempDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands run with bash")
+	}
+	dir := t.TempDir()
+	s, err := NewShellServer(WithAllowedCommands("echo"), WithTempDir(dir))
+	if err != nil {
+		t.Fatalf("NewShellServer failed: %v", err)
+	}
+	defer s.Close()
+	if text, _ := callTool(t, s.handleExecuteCommand, map[string]interface{}{"command": "echo $TMPDIR"}); !strings.Contains(text, dir) {
+		t.Errorf("commands see TMPDIR as %q, want %s", text, dir)
+	}
+
+	if _, err := NewShellServer(WithTempDir(filepath.Join(dir, "missing"))); err == nil || !strings.Contains(err.Error(), "temporary directory") {
+		t.Errorf("a missing temporary directory gave %v, want an error naming it", err)
+	}
+}
+
+func TestFindTempDir(t *testing.T) {
+	// An unusable TMPDIR is skipped for the next candidate
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("TMPDIR", missing)
+	dir, err := findTempDir()
+	if err != nil {
+		if !strings.Contains(err.Error(), "--temp-dir") || !strings.Contains(err.Error(), missing) {
+			t.Errorf("findTempDir error = %v, want the paths tried and how to fix it", err)
+		}
+		return
+	}
+	if dir == missing {
+		t.Errorf("findTempDir = %s, a directory that does not exist", dir)
+	}
+}
+
+func TestWritableError(t *testing.T) {
+	tests := []struct {
+		err  error
+		hint string
+	}{
+		{&os.PathError{Op: "open", Path: "/var/lib/history.jsonl", Err: syscall.EROFS}, "writable volume or tmpfs"},
+		{&os.PathError{Op: "open", Path: "/var/lib/history.jsonl", Err: os.ErrPermission}, "cannot write there"},
+		{&os.PathError{Op: "open", Path: "/var/lib/history.jsonl", Err: os.ErrNotExist}, ""},
+	}
+	for _, test := range tests {
+		message := writableError(test.err).Error()
+		if !strings.Contains(message, "/var/lib/history.jsonl") || (test.hint != "" && !strings.Contains(message, test.hint)) {
+			t.Errorf("writableError(%v) = %q, want the path and %q", test.err, message, test.hint)
+		}
+	}
+}
+
+func TestCheckWritableFile(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "edits.json")
+	os.WriteFile(existing, []byte("{}"), 0600)
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{existing, true},
+		{filepath.Join(dir, "new.json"), true},
+		{filepath.Join(dir, "missing", "edits.json"), false},
+	}
+	for _, test := range tests {
+		if err := checkWritableFile(test.path); (err == nil) != test.ok {
+			t.Errorf("checkWritableFile(%s) = %v, want ok %v", test.path, err, test.ok)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.json")); !os.IsNotExist(err) {
+		t.Errorf("checkWritableFile created the file it checked")
+	}
+}
@@ -0,0 +1,261 @@
+package shellserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The subset of YAML that manifests need is parsed here rather than with a
+// YAML library, to keep the server free of dependencies: block mappings and
+// sequences, flow sequences of scalars, plain and quoted scalars, and
+// comments. Anchors, tags, multi-line scalars and flow mappings are
+// rejected. Every scalar is a string; callers interpret them.
+
+// yamlLine is a non-blank line with its comment removed
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// parseYAML parses data into nested map[string]interface{},
+// []interface{} and string values; an empty document is nil
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		text := stripYAMLComment(raw)
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.TrimSpace(trimmed) == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: strings.TrimRight(trimmed, " \t")})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	value, next, err := parseYAMLNode(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].number)
+	}
+	return value, nil
+}
+
+// parseYAMLNode parses the mapping or sequence starting at lines[i], whose
+// entries are indented by indent, and returns the index after it
+func parseYAMLNode(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	if isYAMLItem(lines[i].text) {
+		return parseYAMLSequence(lines, i, indent)
+	}
+	return parseYAMLMapping(lines, i, indent)
+}
+
+// isYAMLItem reports whether text is a sequence item
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func parseYAMLSequence(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	items := []interface{}{}
+	for i < len(lines) && lines[i].indent == indent && isYAMLItem(lines[i].text) {
+		rest := strings.TrimLeft(strings.TrimPrefix(lines[i].text, "-"), " ")
+		switch {
+		case rest == "":
+			// The item is the block below it
+			if i+1 >= len(lines) || lines[i+1].indent <= indent {
+				items = append(items, "")
+				i++
+				continue
+			}
+			value, next, err := parseYAMLNode(lines, i+1, lines[i+1].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			items, i = append(items, value), next
+		case isYAMLItem(rest) || yamlKey(rest) != "":
+			// The item is a block starting on the same line, e.g. "- name: x",
+			// whose entries line up with its first one
+			column := len(lines[i].text) - len(rest) + indent
+			nested := append([]yamlLine{{number: lines[i].number, indent: column, text: rest}}, lines[i+1:]...)
+			value, next, err := parseYAMLNode(nested, 0, column)
+			if err != nil {
+				return nil, 0, err
+			}
+			items, i = append(items, value), i+next
+		default:
+			value, err := parseYAMLScalar(rest, lines[i].number)
+			if err != nil {
+				return nil, 0, err
+			}
+			items, i = append(items, value), i+1
+		}
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, 0, fmt.Errorf("line %d: unexpected indentation", lines[i].number)
+	}
+	return items, i, nil
+}
+
+func parseYAMLMapping(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	mapping := map[string]interface{}{}
+	for i < len(lines) && lines[i].indent == indent {
+		line := lines[i]
+		if isYAMLItem(line.text) {
+			return nil, 0, fmt.Errorf("line %d: expected a key, found a sequence item", line.number)
+		}
+		key := yamlKey(line.text)
+		if key == "" {
+			return nil, 0, fmt.Errorf("line %d: expected 'key: value'", line.number)
+		}
+		name, err := parseYAMLScalar(key, line.number)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, found := mapping[name.(string)]; found {
+			return nil, 0, fmt.Errorf("line %d: duplicate key '%s'", line.number, name)
+		}
+		rest := strings.TrimSpace(line.text[len(key)+1:])
+		i++
+
+		var value interface{} = ""
+		switch {
+		case rest != "":
+			if value, err = parseYAMLScalar(rest, line.number); err != nil {
+				return nil, 0, err
+			}
+		case i < len(lines) && lines[i].indent > indent:
+			if value, i, err = parseYAMLNode(lines, i, lines[i].indent); err != nil {
+				return nil, 0, err
+			}
+		case i < len(lines) && lines[i].indent == indent && isYAMLItem(lines[i].text):
+			// A sequence may line up with its key
+			if value, i, err = parseYAMLSequence(lines, i, indent); err != nil {
+				return nil, 0, err
+			}
+		}
+		mapping[name.(string)] = value
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, 0, fmt.Errorf("line %d: unexpected indentation", lines[i].number)
+	}
+	return mapping, i, nil
+}
+
+// yamlKey returns the key of a "key: value" or "key:" line, or "" if text
+// is not one
+func yamlKey(text string) string {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return text[:i]
+		}
+	}
+	return ""
+}
+
+// parseYAMLScalar parses a plain or quoted scalar, or a flow sequence of them
+func parseYAMLScalar(text string, number int) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return "", nil
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("line %d: flow sequences must end on the same line", number)
+		}
+		items := []interface{}{}
+		for _, part := range splitFlowItems(text[1 : len(text)-1]) {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			if strings.HasPrefix(part, "[") || strings.HasPrefix(part, "{") {
+				return nil, fmt.Errorf("line %d: nested flow collections are not supported", number)
+			}
+			item, err := parseYAMLScalar(part, number)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(text, "\""):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid double-quoted string %s", number, text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") || strings.Contains(strings.ReplaceAll(text[1:len(text)-1], "''", ""), "'") {
+			return nil, fmt.Errorf("line %d: invalid single-quoted string %s", number, text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.ContainsRune("{&*!|>%@`", rune(text[0])):
+		return nil, fmt.Errorf("line %d: '%c' starts YAML that is not supported here; quote the value", number, text[0])
+	default:
+		return text, nil
+	}
+}
+
+// splitFlowItems splits the inside of a flow sequence at commas outside quotes
+func splitFlowItems(text string) []string {
+	var items []string
+	quote, start := byte(0), 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, text[start:])
+}
+
+// stripYAMLComment removes a comment: a '#' at the start of the line or
+// after a space, outside quotes
+func stripYAMLComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" [,:-", rune(line[i-1]))):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
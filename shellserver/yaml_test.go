@@ -0,0 +1,70 @@
+package shellserver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"", nil},
+		{"# only a comment\n", nil},
+		{"name: api\n", map[string]interface{}{"name": "api"}},
+		{"allow: [go, make, \"git, svn\"]  # tools\n", map[string]interface{}{"allow": []interface{}{"go", "make", "git, svn"}}},
+		{"env:\n  GOFLAGS: -mod=vendor\n  CGO_ENABLED: '0'\n", map[string]interface{}{"env": map[string]interface{}{"GOFLAGS": "-mod=vendor", "CGO_ENABLED": "0"}}},
+		{"deny:\n- git push --force\n- 'rm -rf /'\n", map[string]interface{}{"deny": []interface{}{"git push --force", "rm -rf /"}}},
+		{"deny:\n  - a\n  - b\n", map[string]interface{}{"deny": []interface{}{"a", "b"}}},
+		{
+			"tasks:\n  test: go test ./...\n  lint:\n    command: golangci-lint run # fast\n    description: \"Run the linters\"\n",
+			map[string]interface{}{"tasks": map[string]interface{}{
+				"test": "go test ./...",
+				"lint": map[string]interface{}{"command": "golangci-lint run", "description": "Run the linters"},
+			}},
+		},
+		{"items:\n  - name: a\n    value: 1\n  - name: b\n", map[string]interface{}{"items": []interface{}{
+			map[string]interface{}{"name": "a", "value": "1"},
+			map[string]interface{}{"name": "b"},
+		}}},
+		{"url: http://example.com/#anchor\n", map[string]interface{}{"url": "http://example.com/#anchor"}},
+		{"echo: echo 'a # b'\n", map[string]interface{}{"echo": "echo 'a # b'"}},
+		{"empty:\nnext: x\n", map[string]interface{}{"empty": "", "next": "x"}},
+		{"a:\n  b:\n  - x\n  c: y\n", map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"x"}, "c": "y"}}},
+		{"---\nkey: \"tab\\there\"\n", map[string]interface{}{"key": "tab\there"}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseYAML([]byte(tt.input))
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseYAML(%q) = %#v, %v, want %#v", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"a: 1\na: 2\n", "line 2: duplicate key 'a'"},
+		{"a: 1\n   b: 2\n", "line 2: unexpected indentation"},
+		{"a:\n\t- b\n", "line 2: tabs cannot indent YAML"},
+		{"just text\n", "line 1: expected 'key: value'"},
+		{"a: |\n  text\n", "'|' starts YAML that is not supported"},
+		{"a: &anchor x\n", "'&' starts YAML that is not supported"},
+		{"a: [b, [c]]\n", "nested flow collections"},
+		{"a: [b,\n  c]\n", "must end on the same line"},
+		{"a: \"open\n", "invalid double-quoted string"},
+		{"a:\n  - b\n  c: d\n", "line 3: unexpected indentation"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseYAML([]byte(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseYAML(%q) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}